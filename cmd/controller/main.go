@@ -18,24 +18,41 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/awslabs/karpenter/pkg/apis"
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/registry"
 	"github.com/awslabs/karpenter/pkg/controllers"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation"
+	"github.com/awslabs/karpenter/pkg/controllers/consolidation"
+	"github.com/awslabs/karpenter/pkg/controllers/drift"
+	"github.com/awslabs/karpenter/pkg/controllers/interruption"
 	nodemetrics "github.com/awslabs/karpenter/pkg/controllers/metrics/node"
+	podmetrics "github.com/awslabs/karpenter/pkg/controllers/metrics/pod"
+	provisionermetrics "github.com/awslabs/karpenter/pkg/controllers/metrics/provisioner"
 	"github.com/awslabs/karpenter/pkg/controllers/node"
+	"github.com/awslabs/karpenter/pkg/controllers/provisioner"
+	"github.com/awslabs/karpenter/pkg/controllers/rebalance"
 	"github.com/awslabs/karpenter/pkg/controllers/termination"
+	"github.com/awslabs/karpenter/pkg/controllers/versionskew"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/utils/env"
+	"github.com/awslabs/karpenter/pkg/utils/permissions"
 	"github.com/awslabs/karpenter/pkg/utils/restconfig"
 	"github.com/go-logr/zapr"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/flowcontrol"
 	"knative.dev/pkg/configmap/informer"
 	"knative.dev/pkg/injection"
@@ -44,6 +61,8 @@ import (
 	"knative.dev/pkg/signals"
 	"knative.dev/pkg/system"
 	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var (
@@ -63,6 +82,93 @@ type Options struct {
 	HealthProbePort int
 	KubeClientQPS   int
 	KubeClientBurst int
+	// KubeClientReadQPS and KubeClientWriteQPS, with their bursts, rate limit
+	// read-heavy controllers and the write path separately from the other
+	// controllers, so that neither starves the other. They share the kube
+	// client rate limit if zero.
+	KubeClientReadQPS    int
+	KubeClientReadBurst  int
+	KubeClientWriteQPS   int
+	KubeClientWriteBurst int
+	// WorkloadClusterKubeconfig is the path to a kubeconfig for a remote
+	// cluster. If set, controllers watch pods and nodes in the remote cluster
+	// while leader election, logging configuration, and cloud provider calls
+	// remain local to the cluster Karpenter is running in.
+	WorkloadClusterKubeconfig string
+	// LifecycleWebhookURL receives node lifecycle events as JSON, e.g. for an
+	// inventory or security system
+	LifecycleWebhookURL string
+	// EnableControllers and DisableControllers are comma separated lists of
+	// controllers to run, so that controllers can be split across
+	// deployments with narrower RBAC and independent scaling
+	EnableControllers  string
+	DisableControllers string
+	// ControllerServiceAccounts is a comma separated list of service
+	// accounts, as controller=namespace/name, that controllers impersonate
+	// when writing to the API server, e.g. to narrow their RBAC
+	ControllerServiceAccounts string
+	// ExcludedInstanceFamilies and AllowedZones are comma separated lists
+	// that restrict the instance types and zones provisioners default to
+	ExcludedInstanceFamilies string
+	AllowedZones             string
+	// CachePodLabelSelector, CachePodFieldSelector and CacheNodeLabelSelector
+	// restrict the pods and nodes that controllers cache, and
+	// CacheStripUnusedFields drops fields that they don't read, to reduce
+	// memory on large clusters
+	CachePodLabelSelector  string
+	CachePodFieldSelector  string
+	CacheNodeLabelSelector string
+	CacheStripUnusedFields bool
+	// DryRun solves and packs pending pods, recording the capacity that
+	// would be launched without launching it
+	DryRun bool
+	// MarkDriftedOnImageChange marks nodes as drifted when the cloud provider
+	// replaces the image they were launched with
+	MarkDriftedOnImageChange bool
+}
+
+// Settings are hashed to detect replicas running with different
+// configuration. Options that legitimately differ between deployments, e.g.
+// the controllers they run or their ports, are excluded.
+type Settings struct {
+	Global        v1alpha4.GlobalSettings
+	CloudProvider interface{}
+}
+
+// Controllers that may be enabled or disabled. The metrics controller
+// includes the node, pod and provisioner metrics controllers.
+const (
+	AllocationController    = "allocation"
+	ConsolidationController = "consolidation"
+	DriftController         = "drift"
+	InterruptionController  = "interruption"
+	MetricsController       = "metrics"
+	NodeController          = "node"
+	ProvisionerController   = "provisioner"
+	RebalanceController     = "rebalance"
+	TerminationController   = "termination"
+	VersionSkewController   = "versionskew"
+)
+
+// Controllers whose API requests are rate limited separately, if configured.
+// The metrics controllers read every node and pod, while the allocation
+// controller creates nodes and binds pods to them.
+var (
+	readHeavyControllers = sets.NewString(MetricsController)
+	writePathControllers = sets.NewString(AllocationController)
+)
+
+var allControllers = []string{
+	AllocationController,
+	ConsolidationController,
+	DriftController,
+	InterruptionController,
+	MetricsController,
+	NodeController,
+	ProvisionerController,
+	RebalanceController,
+	TerminationController,
+	VersionSkewController,
 }
 
 func main() {
@@ -70,7 +176,25 @@ func main() {
 	flag.IntVar(&options.HealthProbePort, "health-probe-port", env.WithDefaultInt("HEALTH_PROBE_PORT", 8081), "The port the health probe endpoint binds to for reporting controller health")
 	flag.IntVar(&options.KubeClientQPS, "kube-client-qps", env.WithDefaultInt("KUBE_CLIENT_QPS", 200), "The smoothed rate of qps to kube-apiserver")
 	flag.IntVar(&options.KubeClientBurst, "kube-client-burst", env.WithDefaultInt("KUBE_CLIENT_BURST", 300), "The maximum allowed burst of queries to the kube-apiserver")
+	flag.IntVar(&options.KubeClientReadQPS, "kube-client-read-qps", env.WithDefaultInt("KUBE_CLIENT_READ_QPS", 0), "The smoothed rate of qps to kube-apiserver for read-heavy controllers, shares kube-client-qps if zero")
+	flag.IntVar(&options.KubeClientReadBurst, "kube-client-read-burst", env.WithDefaultInt("KUBE_CLIENT_READ_BURST", 0), "The maximum allowed burst of queries to the kube-apiserver for read-heavy controllers, defaults to kube-client-read-qps")
+	flag.IntVar(&options.KubeClientWriteQPS, "kube-client-write-qps", env.WithDefaultInt("KUBE_CLIENT_WRITE_QPS", 0), "The smoothed rate of qps to kube-apiserver for creating nodes and binding pods, shares kube-client-qps if zero")
+	flag.IntVar(&options.KubeClientWriteBurst, "kube-client-write-burst", env.WithDefaultInt("KUBE_CLIENT_WRITE_BURST", 0), "The maximum allowed burst of queries to the kube-apiserver for creating nodes and binding pods, defaults to kube-client-write-qps")
+	flag.StringVar(&options.WorkloadClusterKubeconfig, "workload-cluster-kubeconfig", env.WithDefaultString("WORKLOAD_CLUSTER_KUBECONFIG", ""), "The path to a kubeconfig for a remote cluster to provision nodes for, defaults to the cluster the controller runs in")
+	flag.StringVar(&options.LifecycleWebhookURL, "lifecycle-webhook-url", env.WithDefaultString("LIFECYCLE_WEBHOOK_URL", ""), "The URL to post node lifecycle events to, disabled if empty")
+	flag.StringVar(&options.EnableControllers, "enable-controllers", env.WithDefaultString("ENABLE_CONTROLLERS", strings.Join(allControllers, ",")), fmt.Sprintf("Comma separated list of controllers to run, from %s", strings.Join(allControllers, ", ")))
+	flag.StringVar(&options.DisableControllers, "disable-controllers", env.WithDefaultString("DISABLE_CONTROLLERS", ""), "Comma separated list of controllers not to run, overriding enable-controllers")
+	flag.StringVar(&options.ControllerServiceAccounts, "controller-service-accounts", env.WithDefaultString("CONTROLLER_SERVICE_ACCOUNTS", ""), "Comma separated list of service accounts that controllers impersonate, as controller=namespace/name")
+	flag.StringVar(&options.ExcludedInstanceFamilies, "excluded-instance-families", env.WithDefaultString("EXCLUDED_INSTANCE_FAMILIES", ""), "Comma separated list of instance families, e.g. t3, that provisioners which omit instance types don't launch")
+	flag.StringVar(&options.AllowedZones, "allowed-zones", env.WithDefaultString("ALLOWED_ZONES", ""), "Comma separated list of zones that provisioners which omit zones launch in, defaults to all zones")
+	flag.StringVar(&options.CachePodLabelSelector, "cache-pod-label-selector", env.WithDefaultString("CACHE_POD_LABEL_SELECTOR", ""), "Label selector for the pods that controllers cache, pods that don't match are ignored")
+	flag.StringVar(&options.CachePodFieldSelector, "cache-pod-field-selector", env.WithDefaultString("CACHE_POD_FIELD_SELECTOR", ""), "Field selector for the pods that controllers cache, e.g. status.phase!=Succeeded, pods that don't match are ignored")
+	flag.StringVar(&options.CacheNodeLabelSelector, "cache-node-label-selector", env.WithDefaultString("CACHE_NODE_LABEL_SELECTOR", ""), "Label selector for the nodes that controllers cache, nodes that don't match are ignored")
+	flag.BoolVar(&options.CacheStripUnusedFields, "cache-strip-unused-fields", env.WithDefaultBool("CACHE_STRIP_UNUSED_FIELDS", false), "Drop fields that controllers don't read, such as managed fields and container statuses, from cached pods and nodes")
+	flag.BoolVar(&options.DryRun, "dry-run", env.WithDefaultBool("DRY_RUN", false), "Record the capacity that would be launched for pending pods, without launching it")
+	flag.BoolVar(&options.MarkDriftedOnImageChange, "mark-drifted-on-image-change", env.WithDefaultBool("MARK_DRIFTED_ON_IMAGE_CHANGE", false), "Mark nodes as drifted when the image they were launched with, e.g. an AMI, is no longer resolved")
 	flag.Parse()
+	v1alpha4.Settings = v1alpha4.NewGlobalSettings(options.ExcludedInstanceFamilies, options.AllowedZones)
 
 	config := controllerruntime.GetConfigOrDie()
 	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(float32(options.KubeClientQPS), options.KubeClientBurst)
@@ -79,30 +203,310 @@ func main() {
 	// 1. Set up logger and watch for changes to log level
 	ctx := LoggingContextOrDie(config, clientSet)
 
-	// 2. Put REST config in context, as it can be used by arbitrary
+	// 2. Resolve the cluster that controllers operate against. This is the
+	// local cluster unless a remote workload cluster is configured.
+	workloadConfig, workloadClientSet := WorkloadClusterOrDie(ctx, config, clientSet)
+
+	// 3. Put REST config in context, as it can be used by arbitrary
 	// parts of the code base
-	ctx = restconfig.Inject(ctx, config)
+	ctx = restconfig.Inject(ctx, workloadConfig)
 
-	// 3. Set up controller runtime controller
-	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: clientSet})
-	manager := controllers.NewManagerOrDie(config, controllerruntime.Options{
+	// 4. Check optional permissions, disabling the features that require
+	// missing permissions
+	ctx = PermissionsContext(ctx, workloadClientSet)
+
+	// 5. Publish build metrics
+	metrics.PublishBuildInfo(registry.CloudProviderName)
+
+	// 6. Set up controller runtime controller
+	enabled := EnabledControllersOrDie(ctx)
+	cacheOptions := controllers.CacheOptions{
+		PodLabelSelector:  options.CachePodLabelSelector,
+		PodFieldSelector:  options.CachePodFieldSelector,
+		NodeLabelSelector: options.CacheNodeLabelSelector,
+		StripUnusedFields: options.CacheStripUnusedFields,
+	}
+	manager := controllers.NewManagerOrDie(workloadConfig, controllerruntime.Options{
 		Logger:                 zapr.NewLogger(logging.FromContext(ctx).Desugar()),
 		LeaderElection:         true,
-		LeaderElectionID:       "karpenter-leader-election",
+		LeaderElectionID:       LeaderElectionID(enabled),
+		LeaderElectionConfig:   config,
 		Scheme:                 scheme,
 		MetricsBindAddress:     fmt.Sprintf(":%d", options.MetricsPort),
 		HealthProbeBindAddress: fmt.Sprintf(":%d", options.HealthProbePort),
+		NewCache:               controllers.NewCache(cacheOptions),
 	})
-	if err := manager.RegisterControllers(ctx,
-		allocation.NewController(manager.GetClient(), clientSet.CoreV1(), cloudProvider),
-		termination.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider),
-		node.NewController(manager.GetClient()),
-		nodemetrics.NewController(manager.GetClient()),
-	).Start(ctx); err != nil {
+	recorder := EventRecorder(ctx, manager)
+	configFor := RateLimitedConfigs(ctx, workloadConfig)
+	clientFor := ControllerClientsOrDie(ctx, workloadConfig, configFor, manager, cacheOptions)
+	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{
+		ClientSet:  workloadClientSet,
+		RESTConfig: config,
+	})
+	if err := metrics.PublishConfigHash(SettingsFor(cloudProvider)); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to publish config hash, %s", err.Error())
+	}
+	if checker, ok := cloudProvider.(cloudprovider.ReadinessChecker); ok {
+		if err := manager.AddReadyzCheck("cloudprovider", checker.ReadinessProbe); err != nil {
+			panic(fmt.Sprintf("Failed to add cloud provider readiness probe, %s", err.Error()))
+		}
+	}
+	if err := manager.AddMetricsExtraHandler(cloudprovider.InstanceTypesPath, cloudprovider.NewInstanceTypesHandler(ctx, manager.GetClient(), cloudProvider)); err != nil {
+		panic(fmt.Sprintf("Failed to add instance types handler, %s", err.Error()))
+	}
+	notifier := LifecycleNotifier(ctx, cloudProvider)
+	coordinator := deprovisioning.NewCoordinator(manager.GetClient())
+	registered := []controllers.Controller{}
+	if enabled.Has(AllocationController) {
+		allocationController := allocation.NewController(clientFor(AllocationController), kubernetes.NewForConfigOrDie(configFor(AllocationController)).CoreV1(), cloudProvider, recorder, notifier)
+		allocationController.DryRun = options.DryRun
+		registered = append(registered, allocationController)
+	}
+	if enabled.Has(TerminationController) {
+		registered = append(registered, termination.NewController(ctx, clientFor(TerminationController), workloadClientSet.CoreV1(), cloudProvider, recorder, notifier))
+	}
+	if enabled.Has(NodeController) {
+		registered = append(registered, node.NewController(clientFor(NodeController), workloadClientSet.CoreV1(), cloudProvider, recorder, notifier, coordinator, options.MarkDriftedOnImageChange))
+	}
+	if enabled.Has(ProvisionerController) {
+		registered = append(registered, provisioner.NewController(clientFor(ProvisionerController), cloudProvider))
+	}
+	if enabled.Has(ConsolidationController) {
+		registered = append(registered, consolidation.NewController(clientFor(ConsolidationController), cloudProvider, recorder, coordinator))
+	}
+	if enabled.Has(InterruptionController) {
+		registered = append(registered, interruption.NewController(clientFor(InterruptionController), cloudProvider, recorder))
+	}
+	if enabled.Has(DriftController) {
+		registered = append(registered, drift.NewController(clientFor(DriftController), recorder, coordinator))
+	}
+	if enabled.Has(RebalanceController) {
+		registered = append(registered, rebalance.NewController(clientFor(RebalanceController), cloudProvider, recorder, coordinator))
+	}
+	if enabled.Has(VersionSkewController) {
+		registered = append(registered, versionskew.NewController(clientFor(VersionSkewController), workloadClientSet.Discovery(), recorder, coordinator))
+	}
+	if enabled.Has(MetricsController) {
+		registered = append(registered,
+			nodemetrics.NewController(clientFor(MetricsController)),
+			podmetrics.NewController(clientFor(MetricsController)),
+			provisionermetrics.NewController(clientFor(MetricsController)),
+		)
+	}
+	if err := manager.RegisterControllers(ctx, registered...).Start(ctx); err != nil {
 		panic(fmt.Sprintf("Unable to start manager, %s", err.Error()))
 	}
 }
 
+// SettingsFor returns the active global settings and the cloud provider's
+// settings, if it reports them
+func SettingsFor(cloudProvider cloudprovider.CloudProvider) Settings {
+	settings := Settings{Global: v1alpha4.Settings}
+	if reporter, ok := cloudProvider.(cloudprovider.SettingsReporter); ok {
+		settings.CloudProvider = reporter.Settings()
+	}
+	return settings
+}
+
+// LifecycleNotifier publishes node lifecycle events to the configured webhook
+// and the cloud provider's sinks. Returns nil if none are configured.
+func LifecycleNotifier(ctx context.Context, cloudProvider cloudprovider.CloudProvider) *lifecycle.Notifier {
+	sinks := []lifecycle.Sink{}
+	if options.LifecycleWebhookURL != "" {
+		sinks = append(sinks, lifecycle.NewHTTPSink(options.LifecycleWebhookURL))
+	}
+	if publisher, ok := cloudProvider.(cloudprovider.LifecyclePublisher); ok {
+		sinks = append(sinks, publisher.LifecycleSinks()...)
+	}
+	return lifecycle.NewNotifier(ctx, sinks...)
+}
+
+// EnabledControllersOrDie returns the controllers to run, i.e. the enabled
+// controllers that aren't disabled. Unknown controllers are fatal, so that a
+// typo doesn't silently disable a controller.
+func EnabledControllersOrDie(ctx context.Context) sets.String {
+	known := sets.NewString(allControllers...)
+	enabled := controllerSet(options.EnableControllers)
+	disabled := controllerSet(options.DisableControllers)
+	if unknown := enabled.Union(disabled).Difference(known); unknown.Len() > 0 {
+		logging.FromContext(ctx).Fatalf("Unknown controllers %s, must be in %s", strings.Join(unknown.List(), ", "), strings.Join(allControllers, ", "))
+	}
+	enabled = enabled.Difference(disabled)
+	if enabled.Len() == 0 {
+		logging.FromContext(ctx).Fatalf("No controllers are enabled")
+	}
+	logging.FromContext(ctx).Infof("Running controllers %s", strings.Join(enabled.List(), ", "))
+	return enabled
+}
+
+func controllerSet(names string) sets.String {
+	set := sets.NewString()
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set.Insert(name)
+		}
+	}
+	return set
+}
+
+// LeaderElectionID returns the leader election lease for the enabled
+// controllers. Deployments that run different controllers elect leaders
+// independently, while replicas of the same deployment share a lease.
+func LeaderElectionID(enabled sets.String) string {
+	if enabled.Equal(sets.NewString(allControllers...)) {
+		return "karpenter-leader-election"
+	}
+	return fmt.Sprintf("karpenter-leader-election-%s", strings.Join(enabled.List(), "-"))
+}
+
+// PermissionsContext records the optional permissions that Karpenter is
+// missing in the context, so that the features requiring them are disabled
+// rather than failing repeatedly
+func PermissionsContext(ctx context.Context, clientSet kubernetes.Interface) context.Context {
+	missing, err := permissions.Missing(ctx, clientSet, permissions.Optional...)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to check optional permissions, assuming they're granted, %s", err.Error())
+		return ctx
+	}
+	ctx = permissions.Inject(ctx, missing)
+	if disabled := permissions.DisabledFeatures(ctx); disabled != "" {
+		logging.FromContext(ctx).Warnf("Running with reduced permissions, disabled %s", disabled)
+	}
+	return ctx
+}
+
+// EventRecorder returns the manager's event recorder, or a recorder that
+// discards events if Karpenter isn't permitted to create them
+func EventRecorder(ctx context.Context, manager controllers.Manager) record.EventRecorder {
+	if !permissions.Allowed(ctx, permissions.CreateEvents) {
+		return discardingRecorder{}
+	}
+	return manager.GetEventRecorderFor("karpenter")
+}
+
+type discardingRecorder struct{}
+
+func (discardingRecorder) Event(runtime.Object, string, string, string)                  {}
+func (discardingRecorder) Eventf(runtime.Object, string, string, string, ...interface{}) {}
+func (discardingRecorder) AnnotatedEventf(runtime.Object, map[string]string, string, string, string, ...interface{}) {
+}
+
+// RateLimitedConfigs returns a function that returns the REST config for a
+// controller's API requests. Read-heavy controllers and the write path each
+// share their own rate limiter if configured, so that a burst of reads, e.g.
+// while the metrics controllers relist, doesn't delay node creation and
+// binding. Other controllers share the config's rate limiter.
+func RateLimitedConfigs(ctx context.Context, config *rest.Config) func(string) *rest.Config {
+	read := rateLimited(config, options.KubeClientReadQPS, options.KubeClientReadBurst)
+	write := rateLimited(config, options.KubeClientWriteQPS, options.KubeClientWriteBurst)
+	if read != config {
+		logging.FromContext(ctx).Infof("Rate limiting controllers %s to %d qps", strings.Join(readHeavyControllers.List(), ", "), options.KubeClientReadQPS)
+	}
+	if write != config {
+		logging.FromContext(ctx).Infof("Rate limiting controllers %s to %d qps", strings.Join(writePathControllers.List(), ", "), options.KubeClientWriteQPS)
+	}
+	return func(name string) *rest.Config {
+		if readHeavyControllers.Has(name) {
+			return read
+		}
+		if writePathControllers.Has(name) {
+			return write
+		}
+		return config
+	}
+}
+
+// rateLimited returns a copy of the config with its own rate limiter, or the
+// config itself if qps is zero
+func rateLimited(config *rest.Config, qps int, burst int) *rest.Config {
+	if qps == 0 {
+		return config
+	}
+	if burst == 0 {
+		burst = qps
+	}
+	limited := rest.CopyConfig(config)
+	limited.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(float32(qps), burst)
+	return limited
+}
+
+// ControllerClientsOrDie returns a function that returns the client for a
+// controller. Controllers with a configured service account impersonate it,
+// and controllers with their own rate limiter use it, when writing to the API
+// server. Reads are served from the manager's shared cache, except for
+// read-heavy controllers with their own rate limiter, which share a cache that
+// lists and watches with it. Other controllers use the manager's client.
+func ControllerClientsOrDie(ctx context.Context, config *rest.Config, configFor func(string) *rest.Config, manager controllers.Manager, cacheOptions controllers.CacheOptions) func(string) client.Client {
+	impersonated := map[string]string{}
+	known := sets.NewString(allControllers...)
+	for _, entry := range strings.Split(options.ControllerServiceAccounts, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !known.Has(parts[0]) || len(strings.Split(parts[1], "/")) != 2 {
+			logging.FromContext(ctx).Fatalf("Invalid controller service account %q, must be controller=namespace/name with a controller in %s", entry, strings.Join(allControllers, ", "))
+		}
+		namespaceName := strings.Split(parts[1], "/")
+		impersonated[parts[0]] = fmt.Sprintf("system:serviceaccount:%s:%s", namespaceName[0], namespaceName[1])
+	}
+	var readCache cache.Cache
+	clients := map[string]client.Client{}
+	for _, name := range allControllers {
+		controllerConfig := configFor(name)
+		userName, ok := impersonated[name]
+		if controllerConfig == config && !ok {
+			continue
+		}
+		var reader client.Reader = manager.GetCache()
+		if readHeavyControllers.Has(name) && controllerConfig != config {
+			if readCache == nil {
+				var err error
+				if readCache, err = controllers.NewReaderCache(manager, controllerConfig, cacheOptions); err != nil {
+					logging.FromContext(ctx).Fatalf("Failed to create cache for controller %s, %s", name, err.Error())
+				}
+			}
+			reader = readCache
+		}
+		if ok {
+			controllerConfig = rest.CopyConfig(controllerConfig)
+			controllerConfig.Impersonate.UserName = userName
+			logging.FromContext(ctx).Infof("Controller %s impersonates %s", name, userName)
+		}
+		writer, err := client.New(controllerConfig, client.Options{Scheme: manager.GetScheme(), Mapper: manager.GetRESTMapper()})
+		if err != nil {
+			logging.FromContext(ctx).Fatalf("Failed to create client for controller %s, %s", name, err.Error())
+		}
+		delegating, err := client.NewDelegatingClient(client.NewDelegatingClientInput{CacheReader: reader, Client: writer})
+		if err != nil {
+			logging.FromContext(ctx).Fatalf("Failed to create client for controller %s, %s", name, err.Error())
+		}
+		clients[name] = delegating
+	}
+	return func(name string) client.Client {
+		if c, ok := clients[name]; ok {
+			return c
+		}
+		return manager.GetClient()
+	}
+}
+
+// WorkloadClusterOrDie returns the REST config and client set for the cluster
+// that Karpenter provisions nodes for. If a workload cluster kubeconfig is not
+// configured, the local cluster's config and client set are returned.
+func WorkloadClusterOrDie(ctx context.Context, config *rest.Config, clientSet *kubernetes.Clientset) (*rest.Config, *kubernetes.Clientset) {
+	if options.WorkloadClusterKubeconfig == "" {
+		return config, clientSet
+	}
+	workloadConfig, err := clientcmd.BuildConfigFromFlags("", options.WorkloadClusterKubeconfig)
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to load workload cluster kubeconfig %s, %s", options.WorkloadClusterKubeconfig, err.Error())
+	}
+	workloadConfig.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(float32(options.KubeClientQPS), options.KubeClientBurst)
+	logging.FromContext(ctx).Infof("Provisioning nodes for workload cluster %s", workloadConfig.Host)
+	return workloadConfig, kubernetes.NewForConfigOrDie(workloadConfig)
+}
+
 // LoggingContextOrDie injects a logger into the returned context. The logger is
 // configured by the ConfigMap `config-logging` and live updates the level.
 func LoggingContextOrDie(config *rest.Config, clientSet *kubernetes.Clientset) context.Context {
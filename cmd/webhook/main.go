@@ -41,11 +41,13 @@ var (
 )
 
 type Options struct {
-	Port int
+	Port        int
+	MetricsPort int
 }
 
 func main() {
 	flag.IntVar(&options.Port, "port", 8443, "The port the webhook endpoint binds to for validation and mutation of resources")
+	flag.IntVar(&options.MetricsPort, "metrics-port", 8080, "The port the metric endpoint binds to for operating metrics about the webhook itself")
 	flag.Parse()
 
 	config := injection.ParseAndGetRESTConfigOrDie()
@@ -56,7 +58,10 @@ func main() {
 	})
 
 	// Register the cloud provider to attach vendor specific validation logic.
-	registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: kubernetes.NewForConfigOrDie(config)})
+	registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: kubernetes.NewForConfigOrDie(config), RESTConfig: config})
+
+	// Publish webhook latency and rejection metrics
+	serveMetrics(ctx, options.MetricsPort)
 
 	// Controllers and webhook
 	sharedmain.MainWithConfig(ctx, "webhook", config,
@@ -68,23 +73,23 @@ func main() {
 }
 
 func newCRDDefaultingWebhook(ctx context.Context, w configmap.Watcher) *controller.Impl {
-	return defaulting.NewAdmissionController(ctx,
+	return withMetrics(ctx, "defaulting", defaulting.NewAdmissionController(ctx,
 		"defaulting.webhook.provisioners.karpenter.sh",
 		"/default-resource",
 		apis.Resources,
 		InjectContext,
 		true,
-	)
+	))
 }
 
 func newCRDValidationWebhook(ctx context.Context, w configmap.Watcher) *controller.Impl {
-	return validation.NewAdmissionController(ctx,
+	return withMetrics(ctx, "validation", withWarnings(ctx, validation.NewAdmissionController(ctx,
 		"validation.webhook.provisioners.karpenter.sh",
 		"/validate-resource",
 		apis.Resources,
 		InjectContext,
 		true,
-	)
+	)))
 }
 
 func newConfigValidationController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	admissionv1 "k8s.io/api/admission/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	webhookLabel   = "webhook"
+	operationLabel = "operation"
+	allowedLabel   = "allowed"
+	fieldLabel     = "field"
+
+	reasonOther = "other"
+)
+
+var (
+	admissionDurationHistogramVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: "webhook",
+			Name:      "admission_duration_seconds",
+			Help:      "Duration of admission requests in seconds. Broken down by webhook, operation and whether the request was allowed.",
+			Buckets:   metrics.DurationBuckets(),
+		},
+		[]string{webhookLabel, operationLabel, allowedLabel},
+	)
+	admissionRejectionsCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: "webhook",
+			Name:      "admission_rejections_total",
+			Help:      "Number of rejected fields in admission requests. Broken down by webhook, field and reason.",
+		},
+		[]string{webhookLabel, fieldLabel, metrics.ReasonLabel},
+	)
+	// rejectionReasons maps the messages of knative's field errors to reasons.
+	// Other messages (e.g. from apis.ErrGeneric) are counted as "other" to
+	// bound the cardinality of the reason label.
+	rejectionReasons = map[string]string{
+		"invalid value":                       "invalid_value",
+		"missing field(s)":                    "missing_field",
+		"must not set the field(s)":           "disallowed_field",
+		"must not update deprecated field(s)": "deprecated_field",
+		"expected exactly one, got both":      "multiple_one_of",
+		"expected exactly one, got neither":   "missing_one_of",
+		"invalid key name":                    "invalid_key_name",
+		"decoding request failed":             "decoding_failed",
+	}
+	// indexPattern matches the indices and keys of field paths, e.g. [0] or [cpu]
+	indexPattern = regexp.MustCompile(`\[[^\]]*\]`)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(admissionDurationHistogramVec, admissionRejectionsCounterVec)
+}
+
+// instrumentedAdmissionController decorates an admission controller, recording
+// the latency of its admission requests and the reasons they're rejected
+type instrumentedAdmissionController struct {
+	admissionReconciler
+	name string
+}
+
+// withMetrics decorates the named admission controller to publish metrics.
+// Controllers that don't admit requests are returned undecorated.
+func withMetrics(ctx context.Context, name string, impl *controller.Impl) *controller.Impl {
+	reconciler, ok := impl.Reconciler.(admissionReconciler)
+	if !ok {
+		logging.FromContext(ctx).Errorf("Failed to publish metrics for %s admission controller, unexpected reconciler %T", name, impl.Reconciler)
+		return impl
+	}
+	impl.Reconciler = &instrumentedAdmissionController{admissionReconciler: reconciler, name: name}
+	return impl
+}
+
+// Admit admits the request, recording its latency and any rejections
+func (i *instrumentedAdmissionController) Admit(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	start := time.Now()
+	response := i.admissionReconciler.Admit(ctx, request)
+	admissionDurationHistogramVec.WithLabelValues(i.name, string(request.Operation), strconv.FormatBool(response.Allowed)).Observe(time.Since(start).Seconds())
+	if !response.Allowed && response.Result != nil {
+		for _, rejection := range rejectionsFor(response.Result.Message) {
+			admissionRejectionsCounterVec.WithLabelValues(i.name, rejection.field, rejection.reason).Inc()
+		}
+	}
+	return response
+}
+
+type rejection struct {
+	field  string
+	reason string
+}
+
+// rejectionsFor parses the rejected fields from the message of a rejected
+// admission response. Knative formats field errors one per line, as the
+// message followed by the comma separated field paths, e.g. "validation failed:
+// invalid value: -1: spec.ttlSecondsAfterEmpty".
+func rejectionsFor(message string) []rejection {
+	fieldErrors := strings.TrimPrefix(message, "validation failed: ")
+	if fieldErrors == message {
+		return []rejection{{reason: reasonFor(message)}}
+	}
+	rejections := []rejection{}
+	for _, line := range strings.Split(fieldErrors, "\n") {
+		separator := strings.LastIndex(line, ": ")
+		if separator == -1 {
+			// Field errors' details are on their own lines
+			continue
+		}
+		reason := reasonFor(line[:separator])
+		for _, path := range strings.Split(line[separator+2:], ", ") {
+			rejections = append(rejections, rejection{field: indexPattern.ReplaceAllString(path, ""), reason: reason})
+		}
+	}
+	return rejections
+}
+
+// reasonFor returns the reason for a field error message, ignoring the value
+// that was rejected, e.g. "invalid value: -1" is an "invalid_value"
+func reasonFor(message string) string {
+	for prefix, reason := range rejectionReasons {
+		if strings.HasPrefix(message, prefix) {
+			return reason
+		}
+	}
+	return reasonOther
+}
+
+// serveMetrics serves the metrics registry on the port until the context is done
+func serveMetrics(ctx context.Context, port int) {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: promhttp.HandlerFor(crmetrics.Registry, promhttp.HandlerOpts{})}
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			logging.FromContext(ctx).Errorf("Shutting down metrics server, %s", err.Error())
+		}
+	}()
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.FromContext(ctx).Errorf("Serving metrics, %s", err.Error())
+		}
+	}()
+}
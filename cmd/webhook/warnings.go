@@ -0,0 +1,82 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/awslabs/karpenter/pkg/apis"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/reconciler"
+	"knative.dev/pkg/webhook"
+	"knative.dev/pkg/webhook/resourcesemantics"
+)
+
+// warner is implemented by resources that warn users of deprecated fields
+type warner interface {
+	Warnings(context.Context) []string
+}
+
+type admissionReconciler interface {
+	controller.Reconciler
+	reconciler.LeaderAware
+	webhook.AdmissionController
+	webhook.StatelessAdmissionController
+}
+
+// warningAdmissionController decorates a validating admission controller,
+// adding warnings to the responses for resources that are admitted
+type warningAdmissionController struct {
+	admissionReconciler
+}
+
+// withWarnings decorates the admission controller to return warnings for
+// deprecated fields alongside validation results. Controllers that don't
+// admit requests are returned undecorated.
+func withWarnings(ctx context.Context, impl *controller.Impl) *controller.Impl {
+	reconciler, ok := impl.Reconciler.(admissionReconciler)
+	if !ok {
+		logging.FromContext(ctx).Errorf("Failed to add warnings to admission controller, unexpected reconciler %T", impl.Reconciler)
+		return impl
+	}
+	impl.Reconciler = &warningAdmissionController{admissionReconciler: reconciler}
+	return impl
+}
+
+// Admit validates the request, adding warnings if the resource is admitted
+func (w *warningAdmissionController) Admit(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := w.admissionReconciler.Admit(ctx, request)
+	if !response.Allowed || request.Operation == admissionv1.Delete {
+		return response
+	}
+	gvk := schema.GroupVersionKind{Group: request.Kind.Group, Version: request.Kind.Version, Kind: request.Kind.Kind}
+	resource, ok := apis.Resources[gvk]
+	if !ok {
+		return response
+	}
+	resource = resource.DeepCopyObject().(resourcesemantics.GenericCRD)
+	if err := json.Unmarshal(request.Object.Raw, resource); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to decode %s for warnings, %s", gvk.Kind, err.Error())
+		return response
+	}
+	if warner, ok := resource.(warner); ok {
+		response.Warnings = append(response.Warnings, warner.Warnings(ctx)...)
+	}
+	return response
+}
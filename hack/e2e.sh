@@ -0,0 +1,39 @@
+#!/bin/bash
+set -eu -o pipefail
+
+# Runs the e2e suite against a kind cluster running the controller with the
+# fake cloud provider. Set KEEP_CLUSTER=true to keep the cluster afterwards.
+CLUSTER_NAME="${CLUSTER_NAME:-karpenter-e2e}"
+CONTROL_PLANE_SELECTOR='node-role\.kubernetes\.io/control-plane'
+
+main() {
+    cluster
+    trap cleanup EXIT
+    install
+    go test -tags e2e -timeout 30m ./test/e2e/... -ginkgo.v
+}
+
+cluster() {
+    if ! kind get clusters | grep -q "^${CLUSTER_NAME}$"; then
+        kind create cluster --name "${CLUSTER_NAME}" --wait 5m
+    fi
+    kubectl config use-context "kind-${CLUSTER_NAME}"
+}
+
+install() {
+    kubectl create namespace karpenter --dry-run=client -o yaml | kubectl apply -f -
+    # Images are loaded into the cluster rather than pushed to a registry, and
+    # the controller is kept off of simulated nodes, which don't run pods.
+    KO_DOCKER_REPO=kind.local KIND_CLUSTER_NAME="${CLUSTER_NAME}" CLOUD_PROVIDER= make apply HELM_OPTS="\
+        --set-string controller.nodeSelector.${CONTROL_PLANE_SELECTOR}= \
+        --set-string webhook.nodeSelector.${CONTROL_PLANE_SELECTOR}="
+    kubectl rollout status deployment --namespace karpenter --timeout 5m
+}
+
+cleanup() {
+    if [[ "${KEEP_CLUSTER:-false}" != "true" ]]; then
+        kind delete cluster --name "${CLUSTER_NAME}"
+    fi
+}
+
+main "$@"
@@ -0,0 +1,37 @@
+#!/bin/bash
+set -eu -o pipefail
+
+# Runs the localstack suite against a localstack container, exercising the AWS
+# cloud provider's requests without an AWS account. Set KEEP_LOCALSTACK=true to
+# keep the container afterwards.
+CONTAINER_NAME="${CONTAINER_NAME:-karpenter-localstack}"
+LOCALSTACK_IMAGE="${LOCALSTACK_IMAGE:-localstack/localstack}"
+export LOCALSTACK_ENDPOINT="${LOCALSTACK_ENDPOINT:-http://localhost:4566}"
+
+main() {
+    start
+    trap cleanup EXIT
+    go test -tags localstack -timeout 10m ./test/localstack/... -ginkgo.v
+}
+
+start() {
+    if ! docker ps --format '{{.Names}}' | grep -q "^${CONTAINER_NAME}$"; then
+        docker run --detach --rm --name "${CONTAINER_NAME}" --publish 4566:4566 --env SERVICES=ec2,ssm,sqs "${LOCALSTACK_IMAGE}"
+    fi
+    for _ in $(seq 60); do
+        if curl --silent --fail "${LOCALSTACK_ENDPOINT}/health" > /dev/null; then
+            return
+        fi
+        sleep 2
+    done
+    echo "Timed out waiting for localstack at ${LOCALSTACK_ENDPOINT}"
+    exit 1
+}
+
+cleanup() {
+    if [[ "${KEEP_LOCALSTACK:-false}" != "true" ]]; then
+        docker stop "${CONTAINER_NAME}"
+    fi
+}
+
+main "$@"
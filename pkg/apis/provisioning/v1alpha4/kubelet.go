@@ -0,0 +1,56 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha4
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// EvictionSignals are the signals the kubelet is able to evict pods on
+var EvictionSignals = []string{
+	"memory.available",
+	"nodefs.available",
+	"nodefs.inodesFree",
+	"imagefs.available",
+	"imagefs.inodesFree",
+	"pid.available",
+}
+
+// ParseEvictionThreshold resolves an eviction threshold, which is either a
+// quantity (e.g. 500Mi) or a percentage (e.g. 5%) of the capacity.
+func ParseEvictionThreshold(threshold string, capacity resource.Quantity) (resource.Quantity, error) {
+	if strings.HasSuffix(threshold, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil {
+			return resource.Quantity{}, fmt.Errorf("parsing percentage, %w", err)
+		}
+		if percent < 0 || percent > 100 {
+			return resource.Quantity{}, fmt.Errorf("percentage must be between 0 and 100")
+		}
+		return *resource.NewQuantity(int64(float64(capacity.Value())*percent/100), capacity.Format), nil
+	}
+	quantity, err := resource.ParseQuantity(threshold)
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("parsing quantity, %w", err)
+	}
+	if quantity.Sign() < 0 {
+		return resource.Quantity{}, fmt.Errorf("cannot be negative")
+	}
+	return quantity, nil
+}
@@ -0,0 +1,124 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha4
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// LabelRegistry is the set of well known labels supported by Karpenter and
+// the values they may have, along with the label prefixes that are
+// restricted from being set by users. Cloud providers register their labels
+// at startup, after which the registry is shared by validation, scheduling
+// and metrics, so that they agree on what a label allows.
+// +k8s:deepcopy-gen=false
+type LabelRegistry struct {
+	mu sync.RWMutex
+	// values of enumerated labels, e.g. the zones of the cloud provider
+	values map[string][]string
+	// dynamic labels may have any value, e.g. instance type attributes that
+	// are resolved by the cloud provider at launch
+	dynamic    map[string]bool
+	restricted []string
+}
+
+func NewLabelRegistry() *LabelRegistry {
+	return &LabelRegistry{values: map[string][]string{}, dynamic: map[string]bool{}}
+}
+
+// Register the label as well known, adding to the values it may have
+func (r *LabelRegistry) Register(key string, values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	known := r.values[key]
+	if known == nil {
+		known = []string{}
+	}
+	for _, value := range values {
+		if !functional.ContainsString(known, value) {
+			known = append(known, value)
+		}
+	}
+	r.values[key] = known
+}
+
+// RegisterDynamic registers the label as well known, allowing any value
+func (r *LabelRegistry) RegisterDynamic(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dynamic[key] = true
+}
+
+// Restrict prevents users from setting labels with any of the prefixes
+func (r *LabelRegistry) Restrict(prefixes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, prefix := range prefixes {
+		if !functional.ContainsString(r.restricted, prefix) {
+			r.restricted = append(r.restricted, prefix)
+		}
+	}
+}
+
+// IsWellKnown returns true if the label is registered
+func (r *LabelRegistry) IsWellKnown(key string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.values[key]
+	return ok || r.dynamic[key]
+}
+
+// IsRestricted returns true if users may not set the label
+func (r *LabelRegistry) IsRestricted(key string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return functional.HasAnyPrefix(key, r.restricted...)
+}
+
+// Restricted returns the restricted label prefixes
+func (r *LabelRegistry) Restricted() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string{}, r.restricted...)
+}
+
+// Values returns the values the label may have. Nil is returned if any value
+// is allowed, i.e. if the label is dynamic or isn't well known, which leaves
+// the label unconstrained when intersected with other values.
+func (r *LabelRegistry) Values(key string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	values, ok := r.values[key]
+	if !ok || r.dynamic[key] {
+		return nil
+	}
+	return append([]string{}, values...)
+}
+
+// Validate returns an error if the label may not have the value
+func (r *LabelRegistry) Validate(key string, value string) error {
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return fmt.Errorf("%s, %s", value, strings.Join(errs, ", "))
+	}
+	if known := r.Values(key); known != nil && !functional.ContainsString(known, value) {
+		return fmt.Errorf("%s not in %v", value, known)
+	}
+	return nil
+}
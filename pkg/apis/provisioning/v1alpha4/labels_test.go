@@ -0,0 +1,62 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha4
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LabelRegistry", func() {
+	var registry *LabelRegistry
+
+	BeforeEach(func() {
+		registry = NewLabelRegistry()
+	})
+
+	It("should only allow registered values of enumerated labels", func() {
+		registry.Register("test-key", "test-value-1")
+		registry.Register("test-key", "test-value-2", "test-value-1")
+		Expect(registry.IsWellKnown("test-key")).To(BeTrue())
+		Expect(registry.Values("test-key")).To(Equal([]string{"test-value-1", "test-value-2"}))
+		Expect(registry.Validate("test-key", "test-value-2")).To(Succeed())
+		Expect(registry.Validate("test-key", "unknown")).ToNot(Succeed())
+	})
+	It("should not allow any values of enumerated labels without values", func() {
+		registry.Register("test-key")
+		Expect(registry.Values("test-key")).To(BeEmpty())
+		Expect(registry.Values("test-key")).ToNot(BeNil())
+		Expect(registry.Validate("test-key", "test-value")).ToNot(Succeed())
+	})
+	It("should allow any valid value of dynamic labels", func() {
+		registry.RegisterDynamic("test-key")
+		Expect(registry.IsWellKnown("test-key")).To(BeTrue())
+		Expect(registry.Values("test-key")).To(BeNil())
+		Expect(registry.Validate("test-key", "test-value")).To(Succeed())
+		Expect(registry.Validate("test-key", "/ is not allowed")).ToNot(Succeed())
+	})
+	It("should not constrain unknown labels", func() {
+		Expect(registry.IsWellKnown("test-key")).To(BeFalse())
+		Expect(registry.Values("test-key")).To(BeNil())
+		Expect(registry.Validate("test-key", "test-value")).To(Succeed())
+	})
+	It("should restrict labels by prefix", func() {
+		registry.Restrict("example.com/", "test-key")
+		Expect(registry.IsRestricted("example.com/team")).To(BeTrue())
+		Expect(registry.IsRestricted("test-key")).To(BeTrue())
+		Expect(registry.IsRestricted("another-key")).To(BeFalse())
+		Expect(registry.Restricted()).To(ConsistOf("example.com/", "test-key"))
+	})
+})
@@ -15,9 +15,14 @@ limitations under the License.
 package v1alpha4
 
 import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mitchellh/hashstructure/v2"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // ProvisionerSpec is the top level provisioner specification. Provisioners
@@ -32,6 +37,118 @@ import (
 type ProvisionerSpec struct {
 	// Constraints are applied to all nodes launched by this provisioner.
 	Constraints `json:",inline"`
+	// PodSelector restricts the provisioner to pods with matching labels. Pods
+	// that don't specify a provisioner name are provisioned by the first
+	// provisioner (ordered by name) whose selectors match them, and otherwise
+	// by the default provisioner.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// NamespaceSelector restricts the provisioner to pods in namespaces with
+	// matching labels. Pods are matched in the same way as PodSelector.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// MetricLabels are keys of the provisioner's labels that are added to the
+	// capacity metrics of its nodes, e.g. to attribute capacity to a team or
+	// cost center. At most MaxMetricLabels may be specified.
+	// +optional
+	MetricLabels []string `json:"metricLabels,omitempty"`
+	// SyncLabels applies changes to the provisioner's labels to the nodes it
+	// has already launched, rather than only to new nodes. Labels are added or
+	// updated but never removed, and labels in the kubernetes.io and k8s.io
+	// domains are left to the kubelet.
+	// +optional
+	SyncLabels bool `json:"syncLabels,omitempty"`
+	// TaintSyncPolicy controls whether changes to the provisioner's taints
+	// are ignored by nodes it has already launched (Ignore), applied to them
+	// (Sync), or mark them as drifted (Drift). Defaults to Ignore.
+	// +optional
+	TaintSyncPolicy TaintSyncPolicy `json:"taintSyncPolicy,omitempty"`
+	// SingleReplicaPolicy controls how nodes running single replica workloads
+	// whose pod disruption budgets don't allow any disruptions are handled,
+	// since they can't be drained. They're either ignored (Ignore), reported
+	// with recurring warning events (Warn), or excluded from voluntary
+	// disruption such as expiration (Exclude). Defaults to Ignore.
+	// +optional
+	SingleReplicaPolicy SingleReplicaPolicy `json:"singleReplicaPolicy,omitempty"`
+	// ConsolidationPolicy controls whether underutilized nodes are removed
+	// when their pods fit on other nodes (Delete), or also when their pods fit
+	// on a smaller replacement node (Replace). Defaults to Disabled.
+	// +optional
+	ConsolidationPolicy ConsolidationPolicy `json:"consolidationPolicy,omitempty"`
+	// JobProtectionThresholdSeconds protects long running batch jobs from
+	// being restarted. Nodes running pods owned by Jobs whose
+	// activeDeadlineSeconds, or time running so far, is at least this many
+	// seconds are excluded from voluntary disruption such as expiration and
+	// consolidation until the pods complete.
+	//
+	// Job protection is disabled if this field is not set.
+	// +optional
+	JobProtectionThresholdSeconds *int64 `json:"jobProtectionThresholdSeconds,omitempty"`
+	// DriftBudget is the maximum number of the provisioner's nodes that may
+	// be terminating at once while drifted nodes are replaced. Nodes drift
+	// when their zone, instance type, architecture or operating system is no
+	// longer allowed, when the provisioner's kubelet configuration or provider
+	// changes, or when marked by other controllers or the cloud provider. A
+	// budget of 0 pauses replacement.
+	//
+	// Drifted nodes are marked with the Drifted condition, but not replaced,
+	// if this field is not set.
+	// +optional
+	DriftBudget *int32 `json:"driftBudget,omitempty"`
+	// DisruptionApproval requires voluntary disruptions, i.e. expiration,
+	// consolidation and replacement of drifted nodes, to be approved before
+	// nodes are deleted, e.g. to follow change management.
+	//
+	// Voluntary disruption is not gated if this field is not set.
+	// +optional
+	DisruptionApproval *DisruptionApproval `json:"disruptionApproval,omitempty"`
+	// DisruptionBudget limits voluntary disruptions, i.e. removal of empty,
+	// expired, drifted, skewed and underutilized nodes, across all of the
+	// controllers that terminate nodes, so that they don't drain too many of
+	// the provisioner's nodes at once.
+	//
+	// Voluntary disruption is only limited by the individual controllers if
+	// this field is not set.
+	// +optional
+	DisruptionBudget *DisruptionBudget `json:"disruptionBudget,omitempty"`
+	// TerminationGracePeriod is the maximum amount of time that pods with the
+	// karpenter.sh/do-not-evict annotation may block the drain of a
+	// terminating node, measured from when the node is deleted. Once elapsed,
+	// these pods are evicted like any other.
+	//
+	// Pods with the do-not-evict annotation block drains indefinitely if this
+	// field is not set.
+	// +optional
+	TerminationGracePeriod *metav1.Duration `json:"terminationGracePeriod,omitempty"`
+	// ZoneRebalance moves the provisioner's nodes out of overweighted zones
+	// into underweighted ones, one at a time and within the disruption
+	// budget, when the number of nodes in each allowed zone stays skewed.
+	//
+	// Nodes are not rebalanced across zones if this field is not set.
+	// +optional
+	ZoneRebalance *ZoneRebalance `json:"zoneRebalance,omitempty"`
+	// MaxBatchDuration is the maximum amount of time that pods are batched
+	// together before the provisioner launches capacity for them. Longer
+	// windows trade latency for better binpacking of large batch workloads.
+	// Defaults to 10s.
+	// +optional
+	MaxBatchDuration *metav1.Duration `json:"maxBatchDuration,omitempty"`
+	// BatchIdleDuration is the amount of time without new pods after which a
+	// batch is closed early. Must not exceed MaxBatchDuration. Defaults to 1s.
+	// +optional
+	BatchIdleDuration *metav1.Duration `json:"batchIdleDuration,omitempty"`
+	// MaxKubeletVersionSkew is the number of minor versions that the kubelet
+	// and kube-proxy of the provisioner's nodes may lag the control plane.
+	// Nodes that lag further, e.g. after a control plane upgrade, are marked
+	// drifted and replaced one at a time.
+	//
+	// Nodes are not replaced due to version skew if this field is not set.
+	// +optional
+	MaxKubeletVersionSkew *int64 `json:"maxKubeletVersionSkew,omitempty"`
+	// Limits caps the resources that the provisioner's nodes may consume. Once
+	// a limit is reached, the provisioner stops launching nodes.
+	// +optional
+	Limits *Limits `json:"limits,omitempty"`
 	// TTLSecondsAfterEmpty is the number of seconds the controller will wait
 	// before attempting to delete a node, measured from when the node is
 	// detected to be empty. A Node is considered to be empty when it does not
@@ -40,6 +157,13 @@ type ProvisionerSpec struct {
 	// Termination due to underutilization is disabled if this field is not set.
 	// +optional
 	TTLSecondsAfterEmpty *int64 `json:"ttlSecondsAfterEmpty,omitempty"`
+	// TTLSecondsAfterEmptyOverrides override TTLSecondsAfterEmpty for nodes
+	// with matching labels, e.g. to remove empty on-demand nodes quickly while
+	// empty spot nodes remain as a cheaper warm buffer. The first override
+	// whose labels all match the node's labels is used. Empty nodes that
+	// don't match an override use TTLSecondsAfterEmpty.
+	// +optional
+	TTLSecondsAfterEmptyOverrides []TTLOverride `json:"ttlSecondsAfterEmptyOverrides,omitempty"`
 	// TTLSecondsUntilExpired is the number of seconds the controller will wait
 	// before terminating a node, measured from when the node is created. This
 	// is useful to implement features like eventually consistent node upgrade,
@@ -50,6 +174,138 @@ type ProvisionerSpec struct {
 	TTLSecondsUntilExpired *int64 `json:"ttlSecondsUntilExpired,omitempty"`
 }
 
+// TTLOverride overrides a TTL for nodes with matching labels
+type TTLOverride struct {
+	// Labels that a node must have for the override to apply, e.g.
+	// node.k8s.aws/capacity-type: on-demand
+	Labels map[string]string `json:"labels"`
+	// Seconds is the TTL of matching nodes
+	Seconds int64 `json:"seconds"`
+}
+
+// TaintSyncPolicy controls how changes to a provisioner's taints affect the
+// nodes it has already launched.
+type TaintSyncPolicy string
+
+const (
+	// TaintSyncPolicyIgnore only applies taints to newly launched nodes
+	TaintSyncPolicyIgnore TaintSyncPolicy = "Ignore"
+	// TaintSyncPolicySync adds and updates taints on existing nodes. Taints
+	// are never removed, since they may have been added for pods' tolerations.
+	TaintSyncPolicySync TaintSyncPolicy = "Sync"
+	// TaintSyncPolicyDrift marks existing nodes without the taints as drifted
+	TaintSyncPolicyDrift TaintSyncPolicy = "Drift"
+)
+
+// TaintSyncPolicies are the valid values of TaintSyncPolicy
+var TaintSyncPolicies = []TaintSyncPolicy{TaintSyncPolicyIgnore, TaintSyncPolicySync, TaintSyncPolicyDrift}
+
+// SingleReplicaPolicy controls how nodes with single replica workloads that
+// block disruption are handled.
+type SingleReplicaPolicy string
+
+const (
+	// SingleReplicaPolicyIgnore disrupts the nodes as usual, which stalls
+	// until the pod disruption budgets allow the pods to be evicted
+	SingleReplicaPolicyIgnore SingleReplicaPolicy = "Ignore"
+	// SingleReplicaPolicyWarn emits recurring warning events for the nodes
+	SingleReplicaPolicyWarn SingleReplicaPolicy = "Warn"
+	// SingleReplicaPolicyExclude excludes the nodes from voluntary disruption
+	SingleReplicaPolicyExclude SingleReplicaPolicy = "Exclude"
+)
+
+// SingleReplicaPolicies are the valid values of SingleReplicaPolicy
+var SingleReplicaPolicies = []SingleReplicaPolicy{SingleReplicaPolicyIgnore, SingleReplicaPolicyWarn, SingleReplicaPolicyExclude}
+
+// ConsolidationPolicy controls how underutilized nodes are consolidated.
+type ConsolidationPolicy string
+
+const (
+	// ConsolidationPolicyDisabled never consolidates nodes
+	ConsolidationPolicyDisabled ConsolidationPolicy = "Disabled"
+	// ConsolidationPolicyDelete deletes nodes whose pods fit on other nodes
+	ConsolidationPolicyDelete ConsolidationPolicy = "Delete"
+	// ConsolidationPolicyReplace also deletes nodes whose pods fit on a
+	// smaller instance type, so that their pods are provisioned onto it
+	ConsolidationPolicyReplace ConsolidationPolicy = "Replace"
+)
+
+// ConsolidationPolicies are the valid values of ConsolidationPolicy
+var ConsolidationPolicies = []ConsolidationPolicy{ConsolidationPolicyDisabled, ConsolidationPolicyDelete, ConsolidationPolicyReplace}
+
+// DisruptionApproval gates voluntary disruption of a provisioner's nodes.
+// Nodes awaiting approval are annotated with karpenter.sh/disruption-pending,
+// whose value is the reason for the disruption, and may be approved by
+// annotating them with karpenter.sh/disruption-approved=true.
+type DisruptionApproval struct {
+	// WebhookURL is posted each pending disruption as JSON, and approves it
+	// by responding with a 2xx status. Other responses leave the disruption
+	// pending, and it's requested again later. Only the annotation approves
+	// disruptions if this field is not set.
+	// +optional
+	WebhookURL string `json:"webhookURL,omitempty"`
+}
+
+// ZoneRebalance configures when a provisioner's nodes are rebalanced across
+// zones. Zones are those allowed by the provisioner, or offered by the cloud
+// provider if unconstrained, excluding unhealthy zones.
+type ZoneRebalance struct {
+	// MaxSkew is the maximum difference between the number of nodes in the
+	// provisioner's most and least populated zones.
+	// +required
+	MaxSkew int32 `json:"maxSkew"`
+	// SkewDuration is how long MaxSkew must be exceeded before nodes are
+	// moved, so that transient skew, e.g. while scaling, is ignored. Defaults
+	// to 30m.
+	// +optional
+	SkewDuration *metav1.Duration `json:"skewDuration,omitempty"`
+}
+
+// DisruptionBudget limits when, and how many of, a provisioner's nodes are
+// voluntarily disrupted. Involuntary disruptions, e.g. interruptions, and
+// deletions by users aren't limited, but count towards MaxUnavailable.
+type DisruptionBudget struct {
+	// MaxUnavailable is the number, or percentage rounded up, of the
+	// provisioner's nodes that may be terminating at once. Voluntary
+	// disruptions wait while this many nodes are terminating. Nodes aren't
+	// limited if this field is not set.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// Windows are the times when voluntary disruptions are allowed. Voluntary
+	// disruptions are allowed at any time if this field is not set.
+	// +optional
+	Windows []DisruptionWindow `json:"windows,omitempty"`
+}
+
+// DisruptionWindow is a recurring time when voluntary disruptions are allowed
+type DisruptionWindow struct {
+	// Days of the week the window starts on, e.g. Saturday. The window starts
+	// every day if this field is not set.
+	// +optional
+	Days []string `json:"days,omitempty"`
+	// Start is the time of day the window starts, as HH:MM in UTC
+	Start string `json:"start"`
+	// Duration is how long the window lasts, at most 24h
+	Duration metav1.Duration `json:"duration"`
+}
+
+// DisruptionWindowTimeFormat is the format of disruption windows' start
+const DisruptionWindowTimeFormat = "15:04"
+
+// DisruptionWindowDays are the valid days of disruption windows
+var DisruptionWindowDays = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+// Limits caps the capacity of a provisioner's nodes.
+type Limits struct {
+	// Resources caps the total capacity of the provisioner's nodes, e.g. cpu
+	// and memory. Nodes are only launched if their capacity fits within it.
+	// +optional
+	Resources v1.ResourceList `json:"resources,omitempty"`
+	// MaxNodes caps the number of the provisioner's nodes
+	// +optional
+	MaxNodes *int32 `json:"maxNodes,omitempty"`
+}
+
 // Constraints are applied to all nodes created by the provisioner. They can be
 // overriden by NodeSelectors at the pod level.
 type Constraints struct {
@@ -59,6 +315,13 @@ type Constraints struct {
 	// pod tolerations on a per-node basis.
 	// +optional
 	Taints []v1.Taint `json:"taints,omitempty"`
+	// StartupTaints are applied to every node launched by the Provisioner, but
+	// are expected to be removed by another agent (e.g. a CNI or storage
+	// daemonset) once the node has initialized. Pods do not need to tolerate
+	// these taints to be provisioned for, and daemonsets are included in node
+	// overhead regardless of whether they tolerate them.
+	// +optional
+	StartupTaints []v1.Taint `json:"startupTaints,omitempty"`
 	// Labels will be applied to every node launched by the Provisioner.
 	// +optional
 	Labels map[string]string `json:"labels,omitempty"`
@@ -76,15 +339,144 @@ type Constraints struct {
 	// OperatingSystems constrains the underlying node operating system
 	// +optional
 	OperatingSystems []string `json:"operatingSystems,omitempty"`
+	// KubeletConfiguration configures the kubelet of every node launched by
+	// the Provisioner.
+	// +optional
+	KubeletConfiguration *KubeletConfiguration `json:"kubeletConfiguration,omitempty"`
+	// Overcommit configures how pods whose limits exceed their requests are
+	// packed onto nodes. Pods are packed by their requests if unspecified.
+	// +optional
+	Overcommit *Overcommit `json:"overcommit,omitempty"`
 	// Provider contains fields specific to your cloudprovider.
 	// +kubebuilder:pruning:PreserveUnknownFields
 	Provider *runtime.RawExtension `json:"provider,omitempty"`
 }
 
+// KubeletConfiguration is passed to the kubelet by the cloud provider when
+// bootstrapping nodes. Settings that affect allocatable resources are also
+// applied to each instance type when binpacking, so that nodes fit the pods
+// they're launched for.
+type KubeletConfiguration struct {
+	// ClusterDNS is a list of IP addresses of the cluster DNS server, which
+	// overrides the cloud provider's default.
+	// +optional
+	ClusterDNS []string `json:"clusterDNS,omitempty"`
+	// MaxPods overrides the number of pods per node computed by the cloud
+	// provider.
+	// +optional
+	MaxPods *int32 `json:"maxPods,omitempty"`
+	// SystemReserved overrides the resources reserved for OS system daemons
+	// (e.g. sshd, udev). Resources that aren't specified use the cloud
+	// provider's defaults.
+	// +optional
+	SystemReserved v1.ResourceList `json:"systemReserved,omitempty"`
+	// KubeReserved overrides the resources reserved for Kubernetes system
+	// daemons (e.g. the kubelet and container runtime) in the same way as
+	// SystemReserved.
+	// +optional
+	KubeReserved v1.ResourceList `json:"kubeReserved,omitempty"`
+	// EvictionHard maps eviction signals (e.g. memory.available) to the
+	// thresholds, either quantities or percentages, at which the kubelet
+	// evicts pods. The memory.available threshold is reserved when
+	// binpacking, otherwise the kubelet's default of 100Mi is reserved.
+	// +optional
+	EvictionHard map[string]string `json:"evictionHard,omitempty"`
+}
+
+// GetClusterDNS returns the configured cluster DNS servers, or nil if unset
+func (k *KubeletConfiguration) GetClusterDNS() []string {
+	if k == nil {
+		return nil
+	}
+	return k.ClusterDNS
+}
+
+// GetMaxPods returns the configured max pods, or nil if unset
+func (k *KubeletConfiguration) GetMaxPods() *int32 {
+	if k == nil {
+		return nil
+	}
+	return k.MaxPods
+}
+
+// GetSystemReserved returns the configured system reserved resources, or nil if unset
+func (k *KubeletConfiguration) GetSystemReserved() v1.ResourceList {
+	if k == nil {
+		return nil
+	}
+	return k.SystemReserved
+}
+
+// GetKubeReserved returns the configured kube reserved resources, or nil if unset
+func (k *KubeletConfiguration) GetKubeReserved() v1.ResourceList {
+	if k == nil {
+		return nil
+	}
+	return k.KubeReserved
+}
+
+// GetEvictionHard returns the configured eviction thresholds, or nil if unset
+func (k *KubeletConfiguration) GetEvictionHard() map[string]string {
+	if k == nil {
+		return nil
+	}
+	return k.EvictionHard
+}
+
+// Overcommit configures how much of bursty pods' resource limits are reserved
+// when packing them onto nodes.
+type Overcommit struct {
+	// LimitsPercent is the percentage of each pod's limits in excess of its
+	// requests that's reserved when packing it onto a node, from 0 to 100.
+	// At 0, pods are packed by requests, and may contend for resources when
+	// they burst. At 100, pods are packed by limits and never contend.
+	// +optional
+	LimitsPercent *int32 `json:"limitsPercent,omitempty"`
+}
+
+// GetLimitsPercent returns the configured limits percent, or 0 if unset
+func (o *Overcommit) GetLimitsPercent() int32 {
+	if o == nil || o.LimitsPercent == nil {
+		return 0
+	}
+	return *o.LimitsPercent
+}
+
+// NodeConfigurationHash hashes the parts of the spec that configure nodes
+// when they're launched, i.e. the kubelet configuration and provider, so that
+// nodes launched with a different configuration are detected as drifted.
+// Both are hashed as JSON, so that equivalent values hash the same.
+func (s *ProvisionerSpec) NodeConfigurationHash() (string, error) {
+	raw, err := json.Marshal(struct {
+		KubeletConfiguration *KubeletConfiguration `json:"kubeletConfiguration,omitempty"`
+		Provider             *runtime.RawExtension `json:"provider,omitempty"`
+	}{s.KubeletConfiguration, s.Provider})
+	if err != nil {
+		return "", fmt.Errorf("marshaling node configuration, %w", err)
+	}
+	var configuration interface{}
+	if err := json.Unmarshal(raw, &configuration); err != nil {
+		return "", fmt.Errorf("unmarshaling node configuration, %w", err)
+	}
+	hash, err := hashstructure.Hash(configuration, hashstructure.FormatV2, nil)
+	if err != nil {
+		return "", fmt.Errorf("hashing node configuration, %w", err)
+	}
+	return fmt.Sprint(hash), nil
+}
+
 // Provisioner is the Schema for the Provisioners API
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:path=provisioners,scope=Cluster
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Nodes",type="integer",JSONPath=".status.nodes"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.readyNodes"
+// +kubebuilder:printcolumn:name="CPU",type="string",JSONPath=".status.allocatable.cpu"
+// +kubebuilder:printcolumn:name="Memory",type="string",JSONPath=".status.allocatable.memory"
+// +kubebuilder:printcolumn:name="Last Scale",type="date",JSONPath=".status.lastScaleTime"
+// +kubebuilder:printcolumn:name="Last Provision",type="date",JSONPath=".status.lastProvisionTime",priority=1
+// +kubebuilder:printcolumn:name="Summary",type="string",JSONPath=".status.summary",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type Provisioner struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
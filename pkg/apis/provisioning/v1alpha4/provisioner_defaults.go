@@ -16,9 +16,12 @@ package v1alpha4
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"sort"
+	"strings"
 
 	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
 )
@@ -28,14 +31,78 @@ func (p *Provisioner) SetDefaults(ctx context.Context) {
 	p.Spec.Constraints.Default(ctx)
 }
 
-// Default the constraints
+// Settings restrict the cloud provider's offerings that provisioners default
+// to. They're configured on the controller.
+var Settings = GlobalSettings{}
+
+// GlobalSettings apply to every provisioner
+type GlobalSettings struct {
+	// ExcludedInstanceFamilies, e.g. "t3", aren't defaulted to
+	ExcludedInstanceFamilies []string
+	// AllowedZones are the only zones defaulted to, if set
+	AllowedZones []string
+}
+
+// NewGlobalSettings parses comma separated lists of excluded instance
+// families and allowed zones
+func NewGlobalSettings(excludedInstanceFamilies string, allowedZones string) GlobalSettings {
+	return GlobalSettings{
+		ExcludedInstanceFamilies: splitCommaSeparated(excludedInstanceFamilies),
+		AllowedZones:             splitCommaSeparated(allowedZones),
+	}
+}
+
+func splitCommaSeparated(values string) (result []string) {
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// Default the constraints. Omitted instance types and zones aren't written to
+// the spec, since the cloud provider's offerings change over time. They're
+// resolved when the constraints are applied, see Constrain().
 func (c *Constraints) Default(ctx context.Context) {
 	DefaultHook(ctx, c)
 }
 
+// DefaultInstanceTypes are the cloud provider's instance types, except those
+// in excluded families. The family is the prefix of the instance type's name
+// up to the first ".", e.g. "m5" for "m5.large".
+func DefaultInstanceTypes() (instanceTypes []string) {
+	for _, instanceType := range WellKnownLabels.Values(v1.LabelInstanceTypeStable) {
+		if !functional.ContainsString(Settings.ExcludedInstanceFamilies, strings.Split(instanceType, ".")[0]) {
+			instanceTypes = append(instanceTypes, instanceType)
+		}
+	}
+	sort.Strings(instanceTypes)
+	return instanceTypes
+}
+
+// DefaultZones are the cloud provider's zones, limited to the allowed zones
+// if any are set
+func DefaultZones() (zones []string) {
+	for _, zone := range WellKnownLabels.Values(v1.LabelTopologyZone) {
+		if len(Settings.AllowedZones) == 0 || functional.ContainsString(Settings.AllowedZones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
 // Constrain applies the pods' scheduling constraints to the constraints.
+// Omitted instance types and zones are resolved to their defaults first.
 // Returns an error if the constraints cannot be applied.
 func (c *Constraints) Constrain(ctx context.Context, pods ...*v1.Pod) (errs error) {
+	if len(c.InstanceTypes) == 0 {
+		c.InstanceTypes = DefaultInstanceTypes()
+	}
+	if len(c.Zones) == 0 {
+		c.Zones = DefaultZones()
+	}
 	nodeAffinity := scheduling.NodeAffinityFor(pods...)
 	for label, constraint := range map[string]*[]string{
 		v1.LabelTopologyZone:       &c.Zones,
@@ -43,11 +110,92 @@ func (c *Constraints) Constrain(ctx context.Context, pods ...*v1.Pod) (errs erro
 		v1.LabelArchStable:         &c.Architectures,
 		v1.LabelOSStable:           &c.OperatingSystems,
 	} {
-		values := nodeAffinity.GetLabelValues(label, *constraint, WellKnownLabels[label])
+		values := nodeAffinity.GetLabelValues(label, *constraint, WellKnownLabels.Values(label))
 		if len(values) == 0 {
-			errs = multierr.Append(errs, fmt.Errorf("label %s is too constrained", label))
+			errs = multierr.Append(errs, nodeAffinity.ConflictFor(label, functional.IntersectStringSlice(*constraint, WellKnownLabels.Values(label))))
+		} else if functional.ContainsString(scheduling.ScoredLabels, label) {
+			values = scheduling.PreferredLabelValues(label, values, pods...)
 		}
 		*constraint = values
 	}
 	return multierr.Append(errs, ConstrainHook(ctx, c, pods...))
 }
+
+// Canonicalize normalizes semantically equivalent constraints to the same
+// representation, so that they're grouped together when hashed. Lists are
+// sorted and deduplicated, empty lists and maps are removed, and the provider
+// is reencoded with sorted keys.
+func (c *Constraints) Canonicalize() {
+	for _, values := range []*[]string{&c.Zones, &c.InstanceTypes, &c.Architectures, &c.OperatingSystems} {
+		*values = canonicalStrings(*values)
+	}
+	c.Taints = canonicalTaints(c.Taints)
+	c.StartupTaints = canonicalTaints(c.StartupTaints)
+	if len(c.Labels) == 0 {
+		c.Labels = nil
+	}
+	c.KubeletConfiguration = canonicalKubeletConfiguration(c.KubeletConfiguration)
+	if c.Overcommit != nil && c.Overcommit.LimitsPercent == nil {
+		c.Overcommit = nil
+	}
+	if c.Provider != nil && len(c.Provider.Raw) > 0 {
+		var provider interface{}
+		if err := json.Unmarshal(c.Provider.Raw, &provider); err == nil {
+			if raw, err := json.Marshal(provider); err == nil {
+				c.Provider.Raw = raw
+			}
+		}
+	}
+}
+
+func canonicalStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	values = functional.UniqueStrings(values)
+	sort.Strings(values)
+	return values
+}
+
+func canonicalTaints(taints []v1.Taint) []v1.Taint {
+	if len(taints) == 0 {
+		return nil
+	}
+	unique := map[string]v1.Taint{}
+	for _, taint := range taints {
+		unique[taint.ToString()] = taint
+	}
+	result := []v1.Taint{}
+	for _, taint := range unique {
+		result = append(result, taint)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ToString() < result[j].ToString() })
+	return result
+}
+
+// canonicalKubeletConfiguration omits empty settings, and the configuration if
+// all of its settings are empty. Cluster DNS servers aren't sorted, since the
+// kubelet queries them in order and launch templates preserve it. Schedules
+// hash constraints with slices as sets, which ignores this order, but every
+// pod in a provisioning loop shares its provisioner's servers.
+func canonicalKubeletConfiguration(kubelet *KubeletConfiguration) *KubeletConfiguration {
+	if kubelet == nil {
+		return nil
+	}
+	if len(kubelet.ClusterDNS) == 0 {
+		kubelet.ClusterDNS = nil
+	}
+	if len(kubelet.SystemReserved) == 0 {
+		kubelet.SystemReserved = nil
+	}
+	if len(kubelet.KubeReserved) == 0 {
+		kubelet.KubeReserved = nil
+	}
+	if len(kubelet.EvictionHard) == 0 {
+		kubelet.EvictionHard = nil
+	}
+	if kubelet.ClusterDNS == nil && kubelet.MaxPods == nil && kubelet.SystemReserved == nil && kubelet.KubeReserved == nil && kubelet.EvictionHard == nil {
+		return nil
+	}
+	return kubelet
+}
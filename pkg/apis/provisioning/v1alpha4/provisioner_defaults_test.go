@@ -0,0 +1,139 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha4
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/ptr"
+)
+
+var _ = Describe("Canonicalize", func() {
+	It("should canonicalize equivalent constraints to the same representation", func() {
+		first := &Constraints{
+			Zones:    []string{"test-zone-2", "test-zone-1", "test-zone-1"},
+			Labels:   map[string]string{},
+			Taints:   []v1.Taint{{Key: "b", Effect: v1.TaintEffectNoSchedule}, {Key: "a", Effect: v1.TaintEffectNoSchedule}},
+			Provider: &runtime.RawExtension{Raw: []byte(`{"b": 1, "a": {"d": 2, "c": 3}}`)},
+		}
+		second := &Constraints{
+			Zones:         []string{"test-zone-1", "test-zone-2"},
+			Taints:        []v1.Taint{{Key: "a", Effect: v1.TaintEffectNoSchedule}, {Key: "b", Effect: v1.TaintEffectNoSchedule}, {Key: "a", Effect: v1.TaintEffectNoSchedule}},
+			StartupTaints: []v1.Taint{},
+			Provider:      &runtime.RawExtension{Raw: []byte(`{"a":{"c":3,"d":2},"b":1}`)},
+		}
+		first.Canonicalize()
+		second.Canonicalize()
+		Expect(first).To(Equal(second))
+	})
+})
+
+var _ = Describe("Default", func() {
+	var registry *LabelRegistry
+	BeforeEach(func() {
+		registry = WellKnownLabels
+		WellKnownLabels = NewLabelRegistry()
+		WellKnownLabels.Register(v1.LabelTopologyZone, "test-zone-1", "test-zone-2", "test-zone-3")
+		WellKnownLabels.Register(v1.LabelInstanceTypeStable, "m5.large", "m5.xlarge", "t3.micro")
+		WellKnownLabels.Register(v1.LabelArchStable, ArchitectureAmd64)
+		WellKnownLabels.Register(v1.LabelOSStable, OperatingSystemLinux)
+	})
+	AfterEach(func() {
+		WellKnownLabels = registry
+		Settings = GlobalSettings{}
+	})
+	It("should not write default instance types and zones to the spec", func() {
+		constraints := &Constraints{}
+		constraints.Default(context.Background())
+		Expect(constraints.InstanceTypes).To(BeEmpty())
+		Expect(constraints.Zones).To(BeEmpty())
+	})
+	It("should resolve omitted instance types and zones to the cloud provider's offerings", func() {
+		constraints := &Constraints{}
+		Expect(constraints.Constrain(context.Background())).To(Succeed())
+		Expect(constraints.InstanceTypes).To(ConsistOf("m5.large", "m5.xlarge", "t3.micro"))
+		Expect(constraints.Zones).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+	})
+	It("should not resolve instance types and zones that are set", func() {
+		constraints := &Constraints{InstanceTypes: []string{"t3.micro"}, Zones: []string{"test-zone-2"}}
+		Expect(constraints.Constrain(context.Background())).To(Succeed())
+		Expect(constraints.InstanceTypes).To(Equal([]string{"t3.micro"}))
+		Expect(constraints.Zones).To(Equal([]string{"test-zone-2"}))
+	})
+	It("should exclude instance families and zones restricted by global settings", func() {
+		Settings = NewGlobalSettings("t3, c5", "test-zone-1,test-zone-3")
+		Expect(DefaultInstanceTypes()).To(Equal([]string{"m5.large", "m5.xlarge"}))
+		Expect(DefaultZones()).To(Equal([]string{"test-zone-1", "test-zone-3"}))
+	})
+})
+
+var _ = Describe("Constrain", func() {
+	BeforeEach(func() {
+		WellKnownLabels.Register(v1.LabelTopologyZone, "test-zone-1", "test-zone-2", "test-zone-3")
+	})
+	It("should describe conflicts between the provisioner and pod node selectors", func() {
+		constraints := &Constraints{Zones: []string{"test-zone-2", "test-zone-1"}}
+		err := constraints.Constrain(context.Background(), &v1.Pod{Spec: v1.PodSpec{
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-3"},
+		}})
+		Expect(err).To(MatchError("topology.kubernetes.io/zone: provisioner allows [test-zone-1,test-zone-2], pod requires [test-zone-3]"))
+	})
+	It("should describe conflicts between the provisioner and pod node affinity", func() {
+		constraints := &Constraints{Zones: []string{"test-zone-1"}}
+		err := constraints.Constrain(context.Background(), &v1.Pod{Spec: v1.PodSpec{
+			Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2"}},
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1"}},
+				}}},
+			}}},
+		}})
+		Expect(err).To(MatchError("topology.kubernetes.io/zone: provisioner allows [test-zone-1], pod requires [test-zone-1,test-zone-2] and not [test-zone-1]"))
+	})
+})
+
+var _ = Describe("NodeConfigurationHash", func() {
+	hashOf := func(spec ProvisionerSpec) string {
+		hash, err := spec.NodeConfigurationHash()
+		Expect(err).ToNot(HaveOccurred())
+		return hash
+	}
+	It("should hash equivalent providers to the same value", func() {
+		first := ProvisionerSpec{Constraints: Constraints{Provider: &runtime.RawExtension{Raw: []byte(`{"a": "1", "b": ["2"]}`)}}}
+		second := ProvisionerSpec{Constraints: Constraints{Provider: &runtime.RawExtension{Raw: []byte(`{"b":["2"],"a":"1"}`)}}}
+		Expect(hashOf(first)).To(Equal(hashOf(second)))
+	})
+	It("should change when the provider or kubelet configuration changes", func() {
+		spec := ProvisionerSpec{Constraints: Constraints{Provider: &runtime.RawExtension{Raw: []byte(`{"a": "1"}`)}}}
+		hash := hashOf(spec)
+		spec.Provider = &runtime.RawExtension{Raw: []byte(`{"a": "2"}`)}
+		Expect(hashOf(spec)).ToNot(Equal(hash))
+		hash = hashOf(spec)
+		spec.KubeletConfiguration = &KubeletConfiguration{ClusterDNS: []string{"10.0.0.10"}}
+		Expect(hashOf(spec)).ToNot(Equal(hash))
+	})
+	It("should not change when other fields change", func() {
+		spec := ProvisionerSpec{}
+		hash := hashOf(spec)
+		spec.Zones = []string{"test-zone-1"}
+		spec.DriftBudget = ptr.Int32(1)
+		Expect(hashOf(spec)).To(Equal(hash))
+	})
+})
@@ -14,6 +14,9 @@ specific language governing permissions and limitations under the License.
 package v1alpha4
 
 import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/apis"
 )
 
@@ -25,6 +28,51 @@ type ProvisionerStatus struct {
 	// +kubebuilder:validation:Format="date-time"
 	LastScaleTime *apis.VolatileTime `json:"lastScaleTime,omitempty"`
 
+	// Resources is the total capacity of the provisioner's nodes, which is
+	// compared to its limits
+	// +optional
+	Resources v1.ResourceList `json:"resources,omitempty"`
+
+	// Nodes is the number of the provisioner's nodes
+	// +optional
+	Nodes int32 `json:"nodes,omitempty"`
+
+	// Allocatable is the total allocatable resources of the provisioner's
+	// nodes that have registered
+	// +optional
+	Allocatable v1.ResourceList `json:"allocatable,omitempty"`
+
+	// ReadyNodes is the number of the provisioner's nodes that are ready
+	// +optional
+	ReadyNodes int32 `json:"readyNodes,omitempty"`
+
+	// NotReadyNodes is the number of the provisioner's nodes that aren't
+	// ready, e.g. because they're still launching or are unhealthy
+	// +optional
+	NotReadyNodes int32 `json:"notReadyNodes,omitempty"`
+
+	// LastProvisionTime is the creation time of the provisioner's newest node
+	// +optional
+	// +kubebuilder:validation:Format="date-time"
+	LastProvisionTime *apis.VolatileTime `json:"lastProvisionTime,omitempty"`
+
+	// Summary is a human readable summary of the provisioner's nodes, e.g.
+	// "4 nodes (75% ready), 8 cpu, 32Gi memory, last provisioned 2021-08-01T00:00:00Z"
+	// +optional
+	Summary string `json:"summary,omitempty"`
+
+	// InstanceTypes the provisioner launches, which are its own if it sets
+	// them, otherwise the cloud provider's instance types except those in
+	// globally excluded families
+	// +optional
+	InstanceTypes []string `json:"instanceTypes,omitempty"`
+
+	// Zones the provisioner launches in, which are its own if it sets them,
+	// otherwise the cloud provider's zones limited to the globally allowed
+	// zones
+	// +optional
+	Zones []string `json:"zones,omitempty"`
+
 	// Conditions is the set of conditions required for this provisioner to scale
 	// its target, and indicates whether or not those conditions are met.
 	// +optional
@@ -34,6 +82,7 @@ type ProvisionerStatus struct {
 func (p *Provisioner) StatusConditions() apis.ConditionManager {
 	return apis.NewLivingConditionSet(
 		Active,
+		Launchable,
 	).Manage(p)
 }
 
@@ -44,3 +93,16 @@ func (p *Provisioner) GetConditions() apis.Conditions {
 func (p *Provisioner) SetConditions(conditions apis.Conditions) {
 	p.Status.Conditions = conditions
 }
+
+// UnhealthyZones returns the zones that an operator has marked unhealthy using
+// the unhealthy zones annotation, e.g. karpenter.sh/unhealthy-zones=us-west-2a,us-west-2b.
+// Nodes will not be launched in these zones, and existing nodes in them will be evacuated.
+func (p *Provisioner) UnhealthyZones() []string {
+	zones := []string{}
+	for _, zone := range strings.Split(p.Annotations[UnhealthyZonesAnnotationKey], ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
@@ -17,16 +17,26 @@ package v1alpha4
 import (
 	"context"
 	"fmt"
-	"strings"
+	"net"
+	"net/url"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/apis"
 
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/awslabs/karpenter/pkg/utils/ptr"
 )
 
+// MaxMetricLabels is the maximum number of metric labels a provisioner may
+// specify, which bounds the cardinality of capacity metrics.
+const MaxMetricLabels = 3
+
 func (p *Provisioner) Validate(ctx context.Context) (errs *apis.FieldError) {
 	return errs.Also(
 		apis.ValidateObjectMetadata(p).ViaField("metadata"),
@@ -38,6 +48,22 @@ func (s *ProvisionerSpec) validate(ctx context.Context) (errs *apis.FieldError)
 	return errs.Also(
 		s.validateTTLSecondsUntilExpired(),
 		s.validateTTLSecondsAfterEmpty(),
+		s.validateTTLSecondsAfterEmptyOverrides(),
+		s.validateJobProtectionThresholdSeconds(),
+		s.validateMaxKubeletVersionSkew(),
+		s.validateDriftBudget(),
+		s.validateTerminationGracePeriod(),
+		s.validateZoneRebalance(),
+		s.validateBatchDurations(),
+		validateLabelSelector(s.PodSelector, "podSelector"),
+		validateLabelSelector(s.NamespaceSelector, "namespaceSelector"),
+		s.validateMetricLabels(),
+		s.validateTaintSyncPolicy(),
+		s.validateSingleReplicaPolicy(),
+		s.validateConsolidationPolicy(),
+		s.validateDisruptionApproval(),
+		s.validateDisruptionBudget(),
+		s.validateLimits(),
 		// This validation is on the ProvisionerSpec despite the fact that
 		// labels are a property of Constraints. This is necessary because
 		// validation is applied to constraints that include pod overrides.
@@ -61,12 +87,209 @@ func (s *ProvisionerSpec) validateTTLSecondsAfterEmpty() (errs *apis.FieldError)
 	return errs
 }
 
+func (s *ProvisionerSpec) validateTTLSecondsAfterEmptyOverrides() (errs *apis.FieldError) {
+	for i, override := range s.TTLSecondsAfterEmptyOverrides {
+		if len(override.Labels) == 0 {
+			errs = errs.Also(apis.ErrMissingField("labels").ViaFieldIndex("ttlSecondsAfterEmptyOverrides", i))
+		}
+		for key, value := range override.Labels {
+			for _, err := range validation.IsQualifiedName(key) {
+				errs = errs.Also(apis.ErrInvalidKeyName(key, "labels", err).ViaFieldIndex("ttlSecondsAfterEmptyOverrides", i))
+			}
+			for _, err := range validation.IsValidLabelValue(value) {
+				errs = errs.Also(apis.ErrInvalidValue(value+", "+err, "labels").ViaFieldIndex("ttlSecondsAfterEmptyOverrides", i))
+			}
+		}
+		if override.Seconds < 0 {
+			errs = errs.Also(apis.ErrInvalidValue("cannot be negative", "seconds").ViaFieldIndex("ttlSecondsAfterEmptyOverrides", i))
+		}
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateJobProtectionThresholdSeconds() (errs *apis.FieldError) {
+	if ptr.Int64Value(s.JobProtectionThresholdSeconds) < 0 {
+		return errs.Also(apis.ErrInvalidValue("cannot be negative", "jobProtectionThresholdSeconds"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateMaxKubeletVersionSkew() (errs *apis.FieldError) {
+	if ptr.Int64Value(s.MaxKubeletVersionSkew) < 0 {
+		return errs.Also(apis.ErrInvalidValue("cannot be negative", "maxKubeletVersionSkew"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateDriftBudget() (errs *apis.FieldError) {
+	if s.DriftBudget != nil && *s.DriftBudget < 0 {
+		return errs.Also(apis.ErrInvalidValue("cannot be negative", "driftBudget"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateBatchDurations() (errs *apis.FieldError) {
+	if s.MaxBatchDuration != nil && s.MaxBatchDuration.Duration <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "maxBatchDuration"))
+	}
+	if s.BatchIdleDuration != nil && s.BatchIdleDuration.Duration <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "batchIdleDuration"))
+	}
+	if s.MaxBatchDuration != nil && s.BatchIdleDuration != nil && s.BatchIdleDuration.Duration > s.MaxBatchDuration.Duration {
+		errs = errs.Also(apis.ErrInvalidValue("cannot exceed maxBatchDuration", "batchIdleDuration"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateTerminationGracePeriod() (errs *apis.FieldError) {
+	if s.TerminationGracePeriod != nil && s.TerminationGracePeriod.Duration < 0 {
+		return errs.Also(apis.ErrInvalidValue("cannot be negative", "terminationGracePeriod"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateZoneRebalance() (errs *apis.FieldError) {
+	if s.ZoneRebalance == nil {
+		return errs
+	}
+	if s.ZoneRebalance.MaxSkew < 1 {
+		errs = errs.Also(apis.ErrInvalidValue("must be at least 1", "zoneRebalance.maxSkew"))
+	}
+	if s.ZoneRebalance.SkewDuration != nil && s.ZoneRebalance.SkewDuration.Duration <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "zoneRebalance.skewDuration"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateMetricLabels() (errs *apis.FieldError) {
+	if len(s.MetricLabels) > MaxMetricLabels {
+		errs = errs.Also(apis.ErrOutOfBoundsValue(len(s.MetricLabels), 0, MaxMetricLabels, "metricLabels"))
+	}
+	// Keys are sanitized into metric labels, so distinct keys may collide
+	seen := map[string]string{}
+	for i, key := range s.MetricLabels {
+		for _, err := range validation.IsQualifiedName(key) {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s, %s", key, err), "metricLabels", i))
+		}
+		name := metrics.AttributionLabelName(key)
+		if other, ok := seen[name]; ok && other == key {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s, duplicate key", key), "metricLabels", i))
+		} else if ok {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s, same metric label as %s", key, other), "metricLabels", i))
+		} else {
+			seen[name] = key
+		}
+		// Metric labels are read from the provisioner's labels, which can't be restricted
+		if WellKnownLabels.IsRestricted(key) {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s, restricted key", key), "metricLabels", i))
+		}
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateTaintSyncPolicy() (errs *apis.FieldError) {
+	if s.TaintSyncPolicy == "" {
+		return errs
+	}
+	for _, policy := range TaintSyncPolicies {
+		if s.TaintSyncPolicy == policy {
+			return errs
+		}
+	}
+	return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", s.TaintSyncPolicy, TaintSyncPolicies), "taintSyncPolicy"))
+}
+
+func (s *ProvisionerSpec) validateSingleReplicaPolicy() (errs *apis.FieldError) {
+	if s.SingleReplicaPolicy == "" {
+		return errs
+	}
+	for _, policy := range SingleReplicaPolicies {
+		if s.SingleReplicaPolicy == policy {
+			return errs
+		}
+	}
+	return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", s.SingleReplicaPolicy, SingleReplicaPolicies), "singleReplicaPolicy"))
+}
+
+func (s *ProvisionerSpec) validateConsolidationPolicy() (errs *apis.FieldError) {
+	if s.ConsolidationPolicy == "" {
+		return errs
+	}
+	for _, policy := range ConsolidationPolicies {
+		if s.ConsolidationPolicy == policy {
+			return errs
+		}
+	}
+	return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", s.ConsolidationPolicy, ConsolidationPolicies), "consolidationPolicy"))
+}
+
+func (s *ProvisionerSpec) validateDisruptionApproval() (errs *apis.FieldError) {
+	if s.DisruptionApproval == nil || s.DisruptionApproval.WebhookURL == "" {
+		return errs
+	}
+	webhookURL, err := url.Parse(s.DisruptionApproval.WebhookURL)
+	if err != nil {
+		return errs.Also(apis.ErrInvalidValue(err.Error(), "disruptionApproval.webhookURL"))
+	}
+	if (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s is not an absolute http or https url", s.DisruptionApproval.WebhookURL), "disruptionApproval.webhookURL"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateDisruptionBudget() (errs *apis.FieldError) {
+	if s.DisruptionBudget == nil {
+		return errs
+	}
+	if maxUnavailable := s.DisruptionBudget.MaxUnavailable; maxUnavailable != nil {
+		if value, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, 100, true); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(err.Error(), "disruptionBudget.maxUnavailable"))
+		} else if value < 0 {
+			errs = errs.Also(apis.ErrInvalidValue("cannot be negative", "disruptionBudget.maxUnavailable"))
+		}
+	}
+	for i, window := range s.DisruptionBudget.Windows {
+		for _, day := range window.Days {
+			if !functional.ContainsString(DisruptionWindowDays, day) {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", day, DisruptionWindowDays), "days").ViaFieldIndex("disruptionBudget.windows", i))
+			}
+		}
+		if _, err := time.Parse(DisruptionWindowTimeFormat, window.Start); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s is not a time of day formatted as HH:MM", window.Start), "start").ViaFieldIndex("disruptionBudget.windows", i))
+		}
+		if window.Duration.Duration <= 0 || window.Duration.Duration > 24*time.Hour {
+			errs = errs.Also(apis.ErrOutOfBoundsValue(window.Duration.Duration, 0, 24*time.Hour, "duration").ViaFieldIndex("disruptionBudget.windows", i))
+		}
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateLimits() (errs *apis.FieldError) {
+	if s.Limits == nil {
+		return errs
+	}
+	for name, quantity := range s.Limits.Resources {
+		if quantity.Sign() < 0 {
+			errs = errs.Also(apis.ErrInvalidValue("cannot be negative", fmt.Sprintf("limits.resources[%s]", name)))
+		}
+	}
+	if s.Limits.MaxNodes != nil && *s.Limits.MaxNodes < 0 {
+		errs = errs.Also(apis.ErrInvalidValue("cannot be negative", "limits.maxNodes"))
+	}
+	return errs
+}
+
+func validateLabelSelector(selector *metav1.LabelSelector, fieldName string) (errs *apis.FieldError) {
+	if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+		return errs.Also(apis.ErrInvalidValue(err.Error(), fieldName))
+	}
+	return errs
+}
+
 func (s *ProvisionerSpec) validateRestrictedLabels() (errs *apis.FieldError) {
 	for key := range s.Labels {
-		for _, restricted := range RestrictedLabels {
-			if strings.HasPrefix(key, restricted) {
-				errs = errs.Also(apis.ErrInvalidKeyName(key, "labels"))
-			}
+		if WellKnownLabels.IsRestricted(key) {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "labels"))
 		}
 	}
 	return errs
@@ -79,7 +302,10 @@ func (s *ProvisionerSpec) validateRestrictedLabels() (errs *apis.FieldError) {
 func (c *Constraints) Validate(ctx context.Context) (errs *apis.FieldError) {
 	return errs.Also(
 		c.validateLabels(),
-		c.validateTaints(),
+		validateTaints(c.Taints, "taints"),
+		validateTaints(c.StartupTaints, "startupTaints"),
+		c.KubeletConfiguration.validate().ViaField("kubeletConfiguration"),
+		c.validateOvercommit(),
 		ValidateWellKnown(v1.LabelTopologyZone, c.Zones, "zones"),
 		ValidateWellKnown(v1.LabelInstanceTypeStable, c.InstanceTypes, "instanceTypes"),
 		ValidateWellKnown(v1.LabelArchStable, c.Architectures, "architectures"),
@@ -100,19 +326,19 @@ func (c *Constraints) validateLabels() (errs *apis.FieldError) {
 	return errs
 }
 
-func (c *Constraints) validateTaints() (errs *apis.FieldError) {
-	for i, taint := range c.Taints {
+func validateTaints(taints []v1.Taint, fieldName string) (errs *apis.FieldError) {
+	for i, taint := range taints {
 		// Validate Key
 		if len(taint.Key) == 0 {
-			errs = errs.Also(apis.ErrInvalidArrayValue(errs, "taints", i))
+			errs = errs.Also(apis.ErrInvalidArrayValue(errs, fieldName, i))
 		}
 		for _, err := range validation.IsQualifiedName(taint.Key) {
-			errs = errs.Also(apis.ErrInvalidArrayValue(err, "taints", i))
+			errs = errs.Also(apis.ErrInvalidArrayValue(err, fieldName, i))
 		}
 		// Validate Value
 		if len(taint.Value) != 0 {
 			for _, err := range validation.IsQualifiedName(taint.Value) {
-				errs = errs.Also(apis.ErrInvalidArrayValue(err, "taints", i))
+				errs = errs.Also(apis.ErrInvalidArrayValue(err, fieldName, i))
 			}
 		}
 		// Validate effect
@@ -125,14 +351,97 @@ func (c *Constraints) validateTaints() (errs *apis.FieldError) {
 	return errs
 }
 
+// ReservableResources are the resources the kubelet is able to reserve for
+// system daemons.
+var ReservableResources = []string{
+	string(v1.ResourceCPU),
+	string(v1.ResourceMemory),
+	string(v1.ResourceEphemeralStorage),
+}
+
+func validateReserved(reserved v1.ResourceList, fieldName string) (errs *apis.FieldError) {
+	for name, quantity := range reserved {
+		if !functional.ContainsString(ReservableResources, string(name)) {
+			errs = errs.Also(apis.ErrInvalidKeyName(string(name), fieldName, fmt.Sprintf("not in %v", ReservableResources)))
+		}
+		if quantity.Sign() < 0 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, cannot be negative", quantity.String()), fmt.Sprintf("%s[%s]", fieldName, name)))
+		}
+	}
+	return errs
+}
+
+func (k *KubeletConfiguration) validate() (errs *apis.FieldError) {
+	if k == nil {
+		return errs
+	}
+	for i, ip := range k.ClusterDNS {
+		if net.ParseIP(ip) == nil {
+			errs = errs.Also(apis.ErrInvalidArrayValue(ip, "clusterDNS", i))
+		}
+	}
+	if k.MaxPods != nil && *k.MaxPods < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(*k.MaxPods, "maxPods"))
+	}
+	return errs.Also(
+		validateReserved(k.SystemReserved, "systemReserved"),
+		validateReserved(k.KubeReserved, "kubeReserved"),
+		validateEvictionHard(k.EvictionHard),
+	)
+}
+
+func validateEvictionHard(evictionHard map[string]string) (errs *apis.FieldError) {
+	for signal, threshold := range evictionHard {
+		if !functional.ContainsString(EvictionSignals, signal) {
+			errs = errs.Also(apis.ErrInvalidKeyName(signal, "evictionHard", fmt.Sprintf("not in %v", EvictionSignals)))
+			continue
+		}
+		if _, err := ParseEvictionThreshold(threshold, resource.Quantity{}); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, %s", threshold, err.Error()), fmt.Sprintf("evictionHard[%s]", signal)))
+		}
+	}
+	return errs
+}
+
+func (c *Constraints) validateOvercommit() (errs *apis.FieldError) {
+	if c.Overcommit == nil || c.Overcommit.LimitsPercent == nil {
+		return errs
+	}
+	if percent := *c.Overcommit.LimitsPercent; percent < 0 || percent > 100 {
+		errs = errs.Also(apis.ErrOutOfBoundsValue(percent, 0, 100, "overcommit.limitsPercent"))
+	}
+	return errs
+}
+
 func ValidateWellKnown(key string, values []string, fieldName string) (errs *apis.FieldError) {
 	if values != nil && len(values) == 0 {
 		errs = errs.Also(apis.ErrMissingField(fieldName))
 	}
 	for i, value := range values {
-		if known := WellKnownLabels[key]; !functional.ContainsString(known, value) {
-			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s not in %v", value, known), fieldName, i))
+		if err := WellKnownLabels.Validate(key, value); err != nil {
+			errs = errs.Also(apis.ErrInvalidArrayValue(err.Error(), fieldName, i))
 		}
 	}
 	return errs
 }
+
+// Warnings returns warnings for fields that are deprecated and will be removed
+// or replaced in the next API version. Unlike validation errors, warnings don't
+// prevent the provisioner from being admitted.
+func (p *Provisioner) Warnings(ctx context.Context) (warnings []string) {
+	for _, deprecated := range []struct {
+		field string
+		set   bool
+		label string
+	}{
+		{field: "zones", set: p.Spec.Zones != nil, label: v1.LabelTopologyZone},
+		{field: "instanceTypes", set: p.Spec.InstanceTypes != nil, label: v1.LabelInstanceTypeStable},
+		{field: "architectures", set: p.Spec.Architectures != nil, label: v1.LabelArchStable},
+		{field: "operatingSystems", set: p.Spec.OperatingSystems != nil, label: v1.LabelOSStable},
+	} {
+		if deprecated.set {
+			warnings = append(warnings, fmt.Sprintf("spec.%s is deprecated and will be replaced by requirements with key %s in the next API version", deprecated.field, deprecated.label))
+		}
+	}
+	return warnings
+}
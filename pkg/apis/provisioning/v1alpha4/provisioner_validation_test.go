@@ -18,6 +18,7 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	. "github.com/onsi/ginkgo"
@@ -26,7 +27,9 @@ import (
 	"knative.dev/pkg/ptr"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 var ctx context.Context
@@ -59,6 +62,80 @@ var _ = Describe("Validation", func() {
 		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 	})
 
+	Context("TTLSecondsAfterEmptyOverrides", func() {
+		It("should succeed for valid overrides", func() {
+			provisioner.Spec.TTLSecondsAfterEmptyOverrides = []TTLOverride{{Labels: map[string]string{"example.com/capacity-type": "on-demand"}, Seconds: 30}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for overrides without labels", func() {
+			provisioner.Spec.TTLSecondsAfterEmptyOverrides = []TTLOverride{{Seconds: 30}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for invalid labels", func() {
+			provisioner.Spec.TTLSecondsAfterEmptyOverrides = []TTLOverride{{Labels: map[string]string{"spaces are not allowed": "value"}, Seconds: 30}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.TTLSecondsAfterEmptyOverrides = []TTLOverride{{Labels: map[string]string{"key": "/ is not allowed"}, Seconds: 30}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for negative ttls", func() {
+			provisioner.Spec.TTLSecondsAfterEmptyOverrides = []TTLOverride{{Labels: map[string]string{"key": "value"}, Seconds: -1}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	It("should fail on a negative job protection threshold", func() {
+		provisioner.Spec.JobProtectionThresholdSeconds = ptr.Int64(-1)
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+	})
+
+	It("should fail on a negative kubelet version skew", func() {
+		provisioner.Spec.MaxKubeletVersionSkew = ptr.Int64(-1)
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+	})
+
+	It("should fail on a negative drift budget", func() {
+		provisioner.Spec.DriftBudget = ptr.Int32(-1)
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		provisioner.Spec.DriftBudget = ptr.Int32(0)
+		Expect(provisioner.Validate(ctx)).To(Succeed())
+	})
+
+	It("should fail on an invalid zone rebalance", func() {
+		provisioner.Spec.ZoneRebalance = &ZoneRebalance{MaxSkew: 0}
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		provisioner.Spec.ZoneRebalance = &ZoneRebalance{MaxSkew: 1, SkewDuration: &metav1.Duration{}}
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		provisioner.Spec.ZoneRebalance = &ZoneRebalance{MaxSkew: 1, SkewDuration: &metav1.Duration{Duration: time.Hour}}
+		Expect(provisioner.Validate(ctx)).To(Succeed())
+	})
+
+	It("should fail on a negative termination grace period", func() {
+		provisioner.Spec.TerminationGracePeriod = &metav1.Duration{Duration: -time.Minute}
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		provisioner.Spec.TerminationGracePeriod = &metav1.Duration{Duration: time.Hour}
+		Expect(provisioner.Validate(ctx)).To(Succeed())
+	})
+
+	Context("Batching", func() {
+		It("should succeed for valid batch durations", func() {
+			provisioner.Spec.MaxBatchDuration = &metav1.Duration{Duration: 30 * time.Second}
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: 100 * time.Millisecond}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for non positive batch durations", func() {
+			provisioner.Spec.MaxBatchDuration = &metav1.Duration{}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.MaxBatchDuration = nil
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: -time.Second}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail if the idle duration exceeds the max duration", func() {
+			provisioner.Spec.MaxBatchDuration = &metav1.Duration{Duration: time.Second}
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: 2 * time.Second}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
 	Context("Labels", func() {
 		It("should allow unrecognized labels", func() {
 			provisioner.Spec.Labels = map[string]string{"foo": randomdata.SillyName()}
@@ -73,12 +150,156 @@ var _ = Describe("Validation", func() {
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 		})
 		It("should fail for restricted labels", func() {
-			for _, label := range RestrictedLabels {
+			for _, label := range WellKnownLabels.Restricted() {
 				provisioner.Spec.Labels = map[string]string{label: randomdata.SillyName()}
 				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 			}
 		})
 	})
+	Context("MetricLabels", func() {
+		It("should succeed for valid label keys", func() {
+			provisioner.Spec.MetricLabels = []string{"team", "example.com/cost-center"}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for invalid label keys", func() {
+			provisioner.Spec.MetricLabels = []string{"spaces are not allowed"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for duplicate label keys", func() {
+			provisioner.Spec.MetricLabels = []string{"team", "team"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for label keys with the same metric label", func() {
+			provisioner.Spec.MetricLabels = []string{"example.com/team", "example_com/team"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for restricted label keys", func() {
+			provisioner.Spec.MetricLabels = []string{v1.LabelTopologyZone}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for too many label keys", func() {
+			provisioner.Spec.MetricLabels = []string{"a", "b", "c", "d"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("TaintSyncPolicy", func() {
+		It("should succeed for valid policies", func() {
+			for _, policy := range append(TaintSyncPolicies, "") {
+				provisioner.Spec.TaintSyncPolicy = policy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail for unknown policies", func() {
+			provisioner.Spec.TaintSyncPolicy = "Replace"
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("SingleReplicaPolicy", func() {
+		It("should succeed for valid policies", func() {
+			for _, policy := range append(SingleReplicaPolicies, "") {
+				provisioner.Spec.SingleReplicaPolicy = policy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail for unknown policies", func() {
+			provisioner.Spec.SingleReplicaPolicy = "Evict"
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("Limits", func() {
+		It("should succeed for valid limits", func() {
+			provisioner.Spec.Limits = &Limits{
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100"), v1.ResourceMemory: resource.MustParse("400Gi")},
+				MaxNodes:  ptr.Int32(10),
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for negative resources", func() {
+			provisioner.Spec.Limits = &Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("-1")}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for negative max nodes", func() {
+			provisioner.Spec.Limits = &Limits{MaxNodes: ptr.Int32(-1)}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("ConsolidationPolicy", func() {
+		It("should succeed for valid policies", func() {
+			for _, policy := range append(ConsolidationPolicies, "") {
+				provisioner.Spec.ConsolidationPolicy = policy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail for unknown policies", func() {
+			provisioner.Spec.ConsolidationPolicy = "Resize"
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("DisruptionApproval", func() {
+		It("should succeed without a webhook", func() {
+			provisioner.Spec.DisruptionApproval = &DisruptionApproval{}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should succeed for http and https webhooks", func() {
+			for _, webhookURL := range []string{"http://approvals.default.svc:8080/disruptions", "https://approvals.example.com"} {
+				provisioner.Spec.DisruptionApproval = &DisruptionApproval{WebhookURL: webhookURL}
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail for invalid webhooks", func() {
+			for _, webhookURL := range []string{"approvals.example.com", "ftp://approvals.example.com", "https://", "://approvals"} {
+				provisioner.Spec.DisruptionApproval = &DisruptionApproval{WebhookURL: webhookURL}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+	})
+	Context("DisruptionBudget", func() {
+		It("should succeed for valid budgets", func() {
+			for _, maxUnavailable := range []intstr.IntOrString{intstr.FromInt(0), intstr.FromInt(3), intstr.FromString("25%")} {
+				maxUnavailable := maxUnavailable
+				provisioner.Spec.DisruptionBudget = &DisruptionBudget{
+					MaxUnavailable: &maxUnavailable,
+					Windows: []DisruptionWindow{
+						{Start: "22:00", Duration: metav1.Duration{Duration: 4 * time.Hour}},
+						{Days: []string{"Saturday", "Sunday"}, Start: "00:00", Duration: metav1.Duration{Duration: 24 * time.Hour}},
+					},
+				}
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail for invalid max unavailable", func() {
+			for _, maxUnavailable := range []intstr.IntOrString{intstr.FromInt(-1), intstr.FromString("-10%"), intstr.FromString("half")} {
+				maxUnavailable := maxUnavailable
+				provisioner.Spec.DisruptionBudget = &DisruptionBudget{MaxUnavailable: &maxUnavailable}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+		It("should fail for invalid windows", func() {
+			for _, window := range []DisruptionWindow{
+				{Start: "25:00", Duration: metav1.Duration{Duration: time.Hour}},
+				{Start: "10pm", Duration: metav1.Duration{Duration: time.Hour}},
+				{Start: "22:00"},
+				{Start: "22:00", Duration: metav1.Duration{Duration: 25 * time.Hour}},
+				{Days: []string{"Someday"}, Start: "22:00", Duration: metav1.Duration{Duration: time.Hour}},
+			} {
+				provisioner.Spec.DisruptionBudget = &DisruptionBudget{Windows: []DisruptionWindow{window}}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+	})
+	Context("Warnings", func() {
+		It("should not warn if deprecated fields are unset", func() {
+			Expect(provisioner.Warnings(ctx)).To(BeEmpty())
+		})
+		It("should warn for deprecated fields", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1"}
+			provisioner.Spec.Architectures = []string{ArchitectureAmd64}
+			Expect(provisioner.Warnings(ctx)).To(ConsistOf(
+				"spec.zones is deprecated and will be replaced by requirements with key topology.kubernetes.io/zone in the next API version",
+				"spec.architectures is deprecated and will be replaced by requirements with key kubernetes.io/arch in the next API version",
+			))
+		})
+	})
 	Context("Taints", func() {
 		It("should succeed for valid taints", func() {
 			provisioner.Spec.Taints = []v1.Taint{
@@ -106,8 +327,75 @@ var _ = Describe("Validation", func() {
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 		})
 	})
+	Context("StartupTaints", func() {
+		It("should succeed for valid taints", func() {
+			provisioner.Spec.StartupTaints = []v1.Taint{{Key: "a", Value: "b", Effect: v1.TaintEffectNoSchedule}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for invalid taints", func() {
+			provisioner.Spec.StartupTaints = []v1.Taint{{Key: "???", Effect: "???"}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("KubeletConfiguration", func() {
+		It("should succeed for reservable resources", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{
+				SystemReserved: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("100Mi")},
+				KubeReserved:   v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("1Gi")},
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for unreservable resources", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{SystemReserved: v1.ResourceList{v1.ResourcePods: resource.MustParse("1")}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for negative quantities", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{KubeReserved: v1.ResourceList{v1.ResourceMemory: resource.MustParse("-1Mi")}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should succeed for cluster DNS IP addresses", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{ClusterDNS: []string{"10.100.0.10", "fd00::a"}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for cluster DNS that isn't an IP address", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{ClusterDNS: []string{"kube-dns.kube-system"}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for max pods less than 1", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{MaxPods: ptr.Int32(0)}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should succeed for eviction thresholds", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{EvictionHard: map[string]string{"memory.available": "500Mi", "nodefs.available": "10%"}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for unknown eviction signals", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{EvictionHard: map[string]string{"memory.free": "500Mi"}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for invalid eviction thresholds", func() {
+			for _, threshold := range []string{"lots", "-1Mi", "101%", "ten%"} {
+				provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{EvictionHard: map[string]string{"memory.available": threshold}}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed(), threshold)
+			}
+		})
+	})
+	Context("Overcommit", func() {
+		It("should succeed for percentages between 0 and 100", func() {
+			for _, percent := range []int32{0, 50, 100} {
+				provisioner.Spec.Overcommit = &Overcommit{LimitsPercent: ptr.Int32(percent)}
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail for percentages outside of 0 and 100", func() {
+			for _, percent := range []int32{-1, 101} {
+				provisioner.Spec.Overcommit = &Overcommit{LimitsPercent: ptr.Int32(percent)}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+	})
 	Context("Zones", func() {
-		WellKnownLabels[v1.LabelTopologyZone] = append(WellKnownLabels[v1.LabelTopologyZone], "test-zone-1")
+		WellKnownLabels.Register(v1.LabelTopologyZone, "test-zone-1")
 		It("should fail if empty", func() {
 			provisioner.Spec.Zones = []string{}
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
@@ -123,7 +411,7 @@ var _ = Describe("Validation", func() {
 	})
 
 	Context("InstanceTypes", func() {
-		WellKnownLabels[v1.LabelInstanceTypeStable] = append(WellKnownLabels[v1.LabelInstanceTypeStable], "test-instance-type")
+		WellKnownLabels.Register(v1.LabelInstanceTypeStable, "test-instance-type")
 		It("should fail if empty", func() {
 			provisioner.Spec.InstanceTypes = []string{}
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
@@ -141,7 +429,7 @@ var _ = Describe("Validation", func() {
 	})
 
 	Context("Architecture", func() {
-		WellKnownLabels[v1.LabelArchStable] = append(WellKnownLabels[v1.LabelArchStable], "test-architecture")
+		WellKnownLabels.Register(v1.LabelArchStable, "test-architecture")
 		It("should fail if empty", func() {
 			provisioner.Spec.Architectures = []string{}
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
@@ -157,7 +445,7 @@ var _ = Describe("Validation", func() {
 	})
 
 	Context("OperatingSystem", func() {
-		WellKnownLabels[v1.LabelOSStable] = append(WellKnownLabels[v1.LabelOSStable], "test-operating-system")
+		WellKnownLabels.Register(v1.LabelOSStable, "test-operating-system")
 		It("should fail if empty", func() {
 			provisioner.Spec.OperatingSystems = []string{}
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
@@ -0,0 +1,95 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha4
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProvisioningDecisionSpec records a packing decision made by the allocation
+// controller: the pods it batched, their constraints, and the instance types
+// it considered for them.
+type ProvisioningDecisionSpec struct {
+	// Provisioner that made the decision
+	Provisioner string `json:"provisioner"`
+	// ConstraintsHash identifies the scheduling constraints that the pods were
+	// packed with. Decisions with the same hash were packed alike.
+	ConstraintsHash string `json:"constraintsHash"`
+	// Pods that were packed, as namespace/name
+	Pods []string `json:"pods"`
+	// InstanceTypes considered for the nodes, in the order they were offered
+	// to the cloud provider
+	InstanceTypes []string `json:"instanceTypes"`
+	// Zones that the nodes could be launched in
+	// +optional
+	Zones []string `json:"zones,omitempty"`
+	// NodeQuantity is the number of nodes that were requested
+	NodeQuantity int `json:"nodeQuantity"`
+	// BatchStartTime is when the first of the batched pods was observed
+	// +optional
+	BatchStartTime *metav1.Time `json:"batchStartTime,omitempty"`
+	// BatchCloseTime is when the batch closed and the pods were packed
+	BatchCloseTime metav1.Time `json:"batchCloseTime"`
+}
+
+// ProvisioningDecisionStatus records the outcome of the decision
+type ProvisioningDecisionStatus struct {
+	// Nodes launched for the decision
+	// +optional
+	Nodes []ProvisionedNode `json:"nodes,omitempty"`
+	// LaunchDuration is how long the cloud provider took to launch the nodes
+	// +optional
+	LaunchDuration *metav1.Duration `json:"launchDuration,omitempty"`
+	// Error is set if the launch failed
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// ProvisionedNode is a node launched for a decision
+type ProvisionedNode struct {
+	// Name of the node
+	Name string `json:"name"`
+	// InstanceType chosen by the cloud provider
+	// +optional
+	InstanceType string `json:"instanceType,omitempty"`
+	// Zone chosen by the cloud provider
+	// +optional
+	Zone string `json:"zone,omitempty"`
+}
+
+// ProvisioningDecision is the Schema for the ProvisioningDecisions API
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=provisioningdecisions,scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Provisioner",type="string",JSONPath=".spec.provisioner"
+// +kubebuilder:printcolumn:name="Nodes",type="integer",JSONPath=".spec.nodeQuantity"
+// +kubebuilder:printcolumn:name="Instance Types",type="string",JSONPath=".spec.instanceTypes",priority=1
+// +kubebuilder:printcolumn:name="Error",type="string",JSONPath=".status.error",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type ProvisioningDecision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProvisioningDecisionSpec   `json:"spec,omitempty"`
+	Status ProvisioningDecisionStatus `json:"status,omitempty"`
+}
+
+// ProvisioningDecisionList contains a list of ProvisioningDecision
+// +kubebuilder:object:root=true
+type ProvisioningDecisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ProvisioningDecision `json:"items"`
+}
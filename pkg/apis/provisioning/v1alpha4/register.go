@@ -30,33 +30,75 @@ var (
 	ArchitectureArm64    = "arm64"
 	OperatingSystemLinux = "linux"
 
-	ProvisionerNameLabelKey         = SchemeGroupVersion.Group + "/provisioner-name"
-	NotReadyTaintKey                = SchemeGroupVersion.Group + "/not-ready"
-	DoNotEvictPodAnnotationKey      = SchemeGroupVersion.Group + "/do-not-evict"
-	EmptinessTimestampAnnotationKey = SchemeGroupVersion.Group + "/emptiness-timestamp"
-	TerminationFinalizer            = SchemeGroupVersion.Group + "/termination"
-	DefaultProvisioner              = types.NamespacedName{Name: "default"}
+	ProvisionerNameLabelKey           = SchemeGroupVersion.Group + "/provisioner-name"
+	NotReadyTaintKey                  = SchemeGroupVersion.Group + "/not-ready"
+	InterruptionTaintKey              = SchemeGroupVersion.Group + "/interruption"
+	DoNotEvictPodAnnotationKey        = SchemeGroupVersion.Group + "/do-not-evict"
+	EmptinessTimestampAnnotationKey   = SchemeGroupVersion.Group + "/emptiness-timestamp"
+	DriftedAnnotationKey              = SchemeGroupVersion.Group + "/drifted"
+	ProvisionerHashAnnotationKey      = SchemeGroupVersion.Group + "/provisioner-hash"
+	ExtendedResourcesAnnotationKey    = SchemeGroupVersion.Group + "/extended-resources"
+	ReplacementAnnotationKey          = SchemeGroupVersion.Group + "/replacement-provisioned"
+	ExpectedReadyAnnotationKey        = SchemeGroupVersion.Group + "/expected-ready-by"
+	ProvisioningDecisionAnnotationKey = SchemeGroupVersion.Group + "/provisioning-decision"
+	RebalanceZoneAnnotationKey        = SchemeGroupVersion.Group + "/rebalance-zone"
+	ScaleHintAnnotationKey            = SchemeGroupVersion.Group + "/scale-hint"
+	ScaleHintProvisionedAnnotationKey = SchemeGroupVersion.Group + "/scale-hint-provisioned"
+	UnhealthyZonesAnnotationKey       = SchemeGroupVersion.Group + "/unhealthy-zones"
+	DryRunAnnotationKey               = SchemeGroupVersion.Group + "/dry-run"
+	RelaxedPreferencesAnnotationKey   = SchemeGroupVersion.Group + "/relaxed-preferences"
+	DisruptionPendingAnnotationKey    = SchemeGroupVersion.Group + "/disruption-pending"
+	DisruptionApprovedAnnotationKey   = SchemeGroupVersion.Group + "/disruption-approved"
+	ProvisioningDeadlineAnnotationKey = SchemeGroupVersion.Group + "/provisioning-deadline"
+	EscalatedAnnotationKey            = SchemeGroupVersion.Group + "/escalated"
+	TerminationFinalizer              = SchemeGroupVersion.Group + "/termination"
+	DefaultProvisioner                = types.NamespacedName{Name: "default"}
 )
 
+// Node conditions maintained by Karpenter on the nodes it provisions
 var (
-	// RestrictedLabels are injected by Cloud Providers
-	RestrictedLabels = []string{
-		// Use strongly typed fields instead
-		v1.LabelArchStable,
-		v1.LabelOSStable,
-		v1.LabelTopologyZone,
-		v1.LabelInstanceTypeStable,
-		// Used internally by provisioning logic
-		EmptinessTimestampAnnotationKey,
-		v1.LabelHostname,
-	}
-	// WellKnownLabels supported by karpenter and their allowable values
-	WellKnownLabels = map[string][]string{
-		v1.LabelArchStable:         {},
-		v1.LabelOSStable:           {},
-		v1.LabelTopologyZone:       {},
-		v1.LabelInstanceTypeStable: {},
-	}
+	// NodeInitialized is true once the node is ready and the not-ready taint is removed
+	NodeInitialized v1.NodeConditionType = "Initialized"
+	// NodeDrifted is true if the node no longer matches the configuration it would be launched with
+	NodeDrifted v1.NodeConditionType = "Drifted"
+	// NodeEmpty is true if no pods other than daemonsets are scheduled to the node
+	NodeEmpty v1.NodeConditionType = "Empty"
+	// NodeConsolidatable is true if the node is eligible to be removed by Karpenter
+	NodeConsolidatable v1.NodeConditionType = "Consolidatable"
+	// NodeDisruptionBlocked is true if the node runs single replica pods whose
+	// pod disruption budgets don't allow any disruptions
+	NodeDisruptionBlocked v1.NodeConditionType = "DisruptionBlocked"
+	// NodeJobProtected is true if the node runs pods of long running Jobs,
+	// which are protected from voluntary disruption until they complete
+	NodeJobProtected v1.NodeConditionType = "JobProtected"
+	// NodeTerminating is true once Karpenter has begun to drain and terminate the node
+	NodeTerminating v1.NodeConditionType = "Terminating"
+	// NodeInterrupted is true if the node's instance is about to be interrupted
+	// by the cloud provider, e.g. if it's a spot instance being reclaimed
+	NodeInterrupted v1.NodeConditionType = "Interrupted"
+)
+
+var (
+	// WellKnownLabels supported by karpenter, their allowable values, and the
+	// labels that are restricted from being set by users
+	WellKnownLabels = func() *LabelRegistry {
+		registry := NewLabelRegistry()
+		registry.Register(v1.LabelArchStable)
+		registry.Register(v1.LabelOSStable)
+		registry.Register(v1.LabelTopologyZone)
+		registry.Register(v1.LabelInstanceTypeStable)
+		registry.Restrict(
+			// Use strongly typed fields instead
+			v1.LabelArchStable,
+			v1.LabelOSStable,
+			v1.LabelTopologyZone,
+			v1.LabelInstanceTypeStable,
+			// Used internally by provisioning logic
+			EmptinessTimestampAnnotationKey,
+			v1.LabelHostname,
+		)
+		return registry
+	}()
 	DefaultHook   = func(ctx context.Context, constraints *Constraints) {}
 	ValidateHook  = func(ctx context.Context, constraints *Constraints) *apis.FieldError { return nil }
 	ConstrainHook = func(ctx context.Context, constraints *Constraints, pods ...*v1.Pod) error { return nil }
@@ -70,6 +112,8 @@ var (
 		scheme.AddKnownTypes(SchemeGroupVersion,
 			&Provisioner{},
 			&ProvisionerList{},
+			&ProvisioningDecision{},
+			&ProvisioningDecisionList{},
 		)
 		metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 		return nil
@@ -81,4 +125,24 @@ const (
 	// controller is able to take actions: it's correctly configured, can make
 	// necessary API calls, and isn't disabled.
 	Active apis.ConditionType = "Active"
+	// Launchable indicates that the provisioner is able to launch capacity. It's
+	// false while launches are suspended after repeated failures, with the last
+	// failure as its message.
+	Launchable apis.ConditionType = "Launchable"
+	// Permitted indicates that Karpenter has all of its optional permissions.
+	// It's false while Karpenter runs with reduced RBAC, with the features
+	// that are disabled as its message.
+	Permitted apis.ConditionType = "Permitted"
+	// CloudProviderPermitted indicates that the cloud provider's credentials
+	// grant the permissions it requires. It's false with the missing
+	// permissions as its message, and absent for cloud providers that don't
+	// check their permissions.
+	CloudProviderPermitted apis.ConditionType = "CloudProviderPermitted"
+	// Unsatisfiable, LimitExceeded and CloudProviderError explain why the last
+	// provisioning loop didn't launch capacity for all of its pods. They're
+	// true with the reason as their message, and removed once a provisioning
+	// loop no longer hits them.
+	Unsatisfiable      apis.ConditionType = "Unsatisfiable"
+	LimitExceeded      apis.ConditionType = "LimitExceeded"
+	CloudProviderError apis.ConditionType = "CloudProviderError"
 )
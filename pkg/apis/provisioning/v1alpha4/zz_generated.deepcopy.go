@@ -19,8 +19,10 @@ limitations under the License.
 package v1alpha4
 
 import (
-	"k8s.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"knative.dev/pkg/apis"
 )
 
@@ -29,7 +31,14 @@ func (in *Constraints) DeepCopyInto(out *Constraints) {
 	*out = *in
 	if in.Taints != nil {
 		in, out := &in.Taints, &out.Taints
-		*out = make([]v1.Taint, len(*in))
+		*out = make([]corev1.Taint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StartupTaints != nil {
+		in, out := &in.StartupTaints, &out.StartupTaints
+		*out = make([]corev1.Taint, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -61,6 +70,16 @@ func (in *Constraints) DeepCopyInto(out *Constraints) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.KubeletConfiguration != nil {
+		in, out := &in.KubeletConfiguration, &out.KubeletConfiguration
+		*out = new(KubeletConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Overcommit != nil {
+		in, out := &in.Overcommit, &out.Overcommit
+		*out = new(Overcommit)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Provider != nil {
 		in, out := &in.Provider, &out.Provider
 		*out = new(runtime.RawExtension)
@@ -78,6 +97,202 @@ func (in *Constraints) DeepCopy() *Constraints {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DisruptionApproval) DeepCopyInto(out *DisruptionApproval) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DisruptionApproval.
+func (in *DisruptionApproval) DeepCopy() *DisruptionApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(DisruptionApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DisruptionBudget) DeepCopyInto(out *DisruptionBudget) {
+	*out = *in
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]DisruptionWindow, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DisruptionBudget.
+func (in *DisruptionBudget) DeepCopy() *DisruptionBudget {
+	if in == nil {
+		return nil
+	}
+	out := new(DisruptionBudget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DisruptionWindow) DeepCopyInto(out *DisruptionWindow) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DisruptionWindow.
+func (in *DisruptionWindow) DeepCopy() *DisruptionWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(DisruptionWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalSettings) DeepCopyInto(out *GlobalSettings) {
+	*out = *in
+	if in.ExcludedInstanceFamilies != nil {
+		in, out := &in.ExcludedInstanceFamilies, &out.ExcludedInstanceFamilies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedZones != nil {
+		in, out := &in.AllowedZones, &out.AllowedZones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalSettings.
+func (in *GlobalSettings) DeepCopy() *GlobalSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeletConfiguration) DeepCopyInto(out *KubeletConfiguration) {
+	*out = *in
+	if in.ClusterDNS != nil {
+		in, out := &in.ClusterDNS, &out.ClusterDNS
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxPods != nil {
+		in, out := &in.MaxPods, &out.MaxPods
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SystemReserved != nil {
+		in, out := &in.SystemReserved, &out.SystemReserved
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.KubeReserved != nil {
+		in, out := &in.KubeReserved, &out.KubeReserved
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.EvictionHard != nil {
+		in, out := &in.EvictionHard, &out.EvictionHard
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeletConfiguration.
+func (in *KubeletConfiguration) DeepCopy() *KubeletConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeletConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Limits) DeepCopyInto(out *Limits) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.MaxNodes != nil {
+		in, out := &in.MaxNodes, &out.MaxNodes
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Limits.
+func (in *Limits) DeepCopy() *Limits {
+	if in == nil {
+		return nil
+	}
+	out := new(Limits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Overcommit) DeepCopyInto(out *Overcommit) {
+	*out = *in
+	if in.LimitsPercent != nil {
+		in, out := &in.LimitsPercent, &out.LimitsPercent
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Overcommit.
+func (in *Overcommit) DeepCopy() *Overcommit {
+	if in == nil {
+		return nil
+	}
+	out := new(Overcommit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisionedNode) DeepCopyInto(out *ProvisionedNode) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisionedNode.
+func (in *ProvisionedNode) DeepCopy() *ProvisionedNode {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisionedNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Provisioner) DeepCopyInto(out *Provisioner) {
 	*out = *in
@@ -141,11 +356,83 @@ func (in *ProvisionerList) DeepCopyObject() runtime.Object {
 func (in *ProvisionerSpec) DeepCopyInto(out *ProvisionerSpec) {
 	*out = *in
 	in.Constraints.DeepCopyInto(&out.Constraints)
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MetricLabels != nil {
+		in, out := &in.MetricLabels, &out.MetricLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.JobProtectionThresholdSeconds != nil {
+		in, out := &in.JobProtectionThresholdSeconds, &out.JobProtectionThresholdSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.DriftBudget != nil {
+		in, out := &in.DriftBudget, &out.DriftBudget
+		*out = new(int32)
+		**out = **in
+	}
+	if in.DisruptionApproval != nil {
+		in, out := &in.DisruptionApproval, &out.DisruptionApproval
+		*out = new(DisruptionApproval)
+		**out = **in
+	}
+	if in.DisruptionBudget != nil {
+		in, out := &in.DisruptionBudget, &out.DisruptionBudget
+		*out = new(DisruptionBudget)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TerminationGracePeriod != nil {
+		in, out := &in.TerminationGracePeriod, &out.TerminationGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ZoneRebalance != nil {
+		in, out := &in.ZoneRebalance, &out.ZoneRebalance
+		*out = new(ZoneRebalance)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxBatchDuration != nil {
+		in, out := &in.MaxBatchDuration, &out.MaxBatchDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.BatchIdleDuration != nil {
+		in, out := &in.BatchIdleDuration, &out.BatchIdleDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxKubeletVersionSkew != nil {
+		in, out := &in.MaxKubeletVersionSkew, &out.MaxKubeletVersionSkew
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(Limits)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.TTLSecondsAfterEmpty != nil {
 		in, out := &in.TTLSecondsAfterEmpty, &out.TTLSecondsAfterEmpty
 		*out = new(int64)
 		**out = **in
 	}
+	if in.TTLSecondsAfterEmptyOverrides != nil {
+		in, out := &in.TTLSecondsAfterEmptyOverrides, &out.TTLSecondsAfterEmptyOverrides
+		*out = make([]TTLOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.TTLSecondsUntilExpired != nil {
 		in, out := &in.TTLSecondsUntilExpired, &out.TTLSecondsUntilExpired
 		*out = new(int64)
@@ -171,6 +458,35 @@ func (in *ProvisionerStatus) DeepCopyInto(out *ProvisionerStatus) {
 		*out = new(apis.VolatileTime)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Allocatable != nil {
+		in, out := &in.Allocatable, &out.Allocatable
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.LastProvisionTime != nil {
+		in, out := &in.LastProvisionTime, &out.LastProvisionTime
+		*out = new(apis.VolatileTime)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InstanceTypes != nil {
+		in, out := &in.InstanceTypes, &out.InstanceTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make(apis.Conditions, len(*in))
@@ -189,3 +505,164 @@ func (in *ProvisionerStatus) DeepCopy() *ProvisionerStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisioningDecision) DeepCopyInto(out *ProvisioningDecision) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisioningDecision.
+func (in *ProvisioningDecision) DeepCopy() *ProvisioningDecision {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisioningDecision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ProvisioningDecision) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisioningDecisionList) DeepCopyInto(out *ProvisioningDecisionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ProvisioningDecision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisioningDecisionList.
+func (in *ProvisioningDecisionList) DeepCopy() *ProvisioningDecisionList {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisioningDecisionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ProvisioningDecisionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisioningDecisionSpec) DeepCopyInto(out *ProvisioningDecisionSpec) {
+	*out = *in
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.InstanceTypes != nil {
+		in, out := &in.InstanceTypes, &out.InstanceTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.BatchStartTime != nil {
+		in, out := &in.BatchStartTime, &out.BatchStartTime
+		*out = (*in).DeepCopy()
+	}
+	in.BatchCloseTime.DeepCopyInto(&out.BatchCloseTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisioningDecisionSpec.
+func (in *ProvisioningDecisionSpec) DeepCopy() *ProvisioningDecisionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisioningDecisionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisioningDecisionStatus) DeepCopyInto(out *ProvisioningDecisionStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]ProvisionedNode, len(*in))
+		copy(*out, *in)
+	}
+	if in.LaunchDuration != nil {
+		in, out := &in.LaunchDuration, &out.LaunchDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisioningDecisionStatus.
+func (in *ProvisioningDecisionStatus) DeepCopy() *ProvisioningDecisionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisioningDecisionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TTLOverride) DeepCopyInto(out *TTLOverride) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TTLOverride.
+func (in *TTLOverride) DeepCopy() *TTLOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(TTLOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneRebalance) DeepCopyInto(out *ZoneRebalance) {
+	*out = *in
+	if in.SkewDuration != nil {
+		in, out := &in.SkewDuration, &out.SkewDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneRebalance.
+func (in *ZoneRebalance) DeepCopy() *ZoneRebalance {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneRebalance)
+	in.DeepCopyInto(out)
+	return out
+}
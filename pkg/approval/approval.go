@@ -0,0 +1,192 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// RetryInterval is how often pending disruptions are requested again.
+	// Webhook responses are cached for this long, so that frequent
+	// reconciles don't flood the webhook.
+	RetryInterval = 1 * time.Minute
+	// httpTimeout bounds each request, so that an unresponsive webhook
+	// doesn't hold up controllers
+	httpTimeout = 10 * time.Second
+)
+
+var requestsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "disruption",
+		Name:      "approval_requests_total",
+		Help:      "Number of voluntary disruption approval requests. Broken down by reason and result.",
+	},
+	[]string{"reason", metrics.ResultLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(requestsCounter)
+}
+
+// Reason for a voluntary disruption
+type Reason string
+
+const (
+	// Expiration of nodes older than the provisioner's TTLSecondsUntilExpired
+	Expiration Reason = "Expiration"
+	// Drift replaces nodes that no longer match their configuration
+	Drift Reason = "Drift"
+	// Consolidation removes underutilized nodes
+	Consolidation Reason = "Consolidation"
+	// Rebalance moves nodes out of overweighted zones
+	Rebalance Reason = "Rebalance"
+	// Evacuation terminates nodes in zones marked unhealthy
+	Evacuation Reason = "Evacuation"
+)
+
+// Request describes a voluntary disruption awaiting approval
+type Request struct {
+	Reason       Reason    `json:"reason"`
+	Time         time.Time `json:"time"`
+	Node         string    `json:"node"`
+	ProviderID   string    `json:"providerID,omitempty"`
+	Provisioner  string    `json:"provisioner,omitempty"`
+	InstanceType string    `json:"instanceType,omitempty"`
+	Zone         string    `json:"zone,omitempty"`
+}
+
+// NewRequest describes the disruption of the node for the reason
+func NewRequest(reason Reason, node *v1.Node) *Request {
+	return &Request{
+		Reason:       reason,
+		Time:         injectabletime.Now().UTC(),
+		Node:         node.Name,
+		ProviderID:   node.Spec.ProviderID,
+		Provisioner:  node.Labels[v1alpha4.ProvisionerNameLabelKey],
+		InstanceType: node.Labels[v1.LabelInstanceTypeStable],
+		Zone:         node.Labels[v1.LabelTopologyZone],
+	}
+}
+
+// Gate approves voluntary disruptions of nodes whose provisioners require it
+type Gate struct {
+	client *http.Client
+	// responses caches whether the webhook approved a node's disruption
+	responses *cache.Cache
+}
+
+func NewGate() *Gate {
+	return &Gate{
+		client:    &http.Client{Timeout: httpTimeout},
+		responses: cache.New(RetryInterval, RetryInterval),
+	}
+}
+
+// Approve returns true if the node may be disrupted for the reason. Disruption
+// is approved if the provisioner doesn't require approval, if the node is
+// annotated as approved, or if the provisioner's webhook approves it.
+// Otherwise the node is annotated as pending, which the caller persists, so
+// that it can be approved out of band.
+func (g *Gate) Approve(ctx context.Context, provisioner *v1alpha4.Provisioner, node *v1.Node, reason Reason) bool {
+	if provisioner.Spec.DisruptionApproval == nil || node.Annotations[v1alpha4.DisruptionApprovedAnnotationKey] == "true" {
+		return true
+	}
+	if webhookURL := provisioner.Spec.DisruptionApproval.WebhookURL; webhookURL != "" && g.approvedBy(ctx, webhookURL, reason, node) {
+		return true
+	}
+	if node.Annotations[v1alpha4.DisruptionPendingAnnotationKey] != string(reason) {
+		node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha4.DisruptionPendingAnnotationKey: string(reason)})
+	}
+	return false
+}
+
+// ApproveAndPersist returns true if the node may be disrupted for the reason,
+// as Approve does, and otherwise patches the node's pending annotation. It's
+// used by controllers that don't patch the node themselves.
+func (g *Gate) ApproveAndPersist(ctx context.Context, kubeClient client.Client, provisioner *v1alpha4.Provisioner, node *v1.Node, reason Reason) (bool, error) {
+	persisted := node.DeepCopy()
+	if g.Approve(ctx, provisioner, node, reason) {
+		return true, nil
+	}
+	logging.FromContext(ctx).Infof("Skipping termination for node %s, %s disruption is pending approval", node.Name, reason)
+	if node.Annotations[v1alpha4.DisruptionPendingAnnotationKey] == persisted.Annotations[v1alpha4.DisruptionPendingAnnotationKey] {
+		return false, nil
+	}
+	if err := kubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
+		return false, fmt.Errorf("patching node %s, %w", node.Name, err)
+	}
+	return false, nil
+}
+
+// approvedBy returns true if the webhook approves the disruption. Responses
+// are cached for RetryInterval.
+func (g *Gate) approvedBy(ctx context.Context, webhookURL string, reason Reason, node *v1.Node) bool {
+	key := fmt.Sprintf("%s/%s/%s", webhookURL, node.Name, reason)
+	if approved, ok := g.responses.Get(key); ok {
+		return approved.(bool)
+	}
+	approved, err := g.post(ctx, webhookURL, NewRequest(reason, node))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to request approval to disrupt node %s, %s", node.Name, err.Error())
+		requestsCounter.WithLabelValues(string(reason), "error").Inc()
+	} else if approved {
+		requestsCounter.WithLabelValues(string(reason), "approved").Inc()
+	} else {
+		requestsCounter.WithLabelValues(string(reason), "pending").Inc()
+	}
+	g.responses.SetDefault(key, approved)
+	return approved
+}
+
+// post sends the request to the webhook, returning true for 2xx responses.
+// Other responses leave the disruption pending, and 5xx responses also fail.
+func (g *Gate) post(ctx context.Context, webhookURL string, disruption *Request) (bool, error) {
+	body, err := json.Marshal(disruption)
+	if err != nil {
+		return false, fmt.Errorf("marshaling request, %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("creating request, %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := g.client.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("posting request to %s, %w", webhookURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 500 {
+		return false, fmt.Errorf("posting request to %s, unexpected status %s", webhookURL, response.Status)
+	}
+	return response.StatusCode >= 200 && response.StatusCode < 300, nil
+}
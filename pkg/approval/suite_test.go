@@ -0,0 +1,145 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Approval")
+}
+
+var _ = Describe("Approval", func() {
+	var gate *Gate
+	var node *v1.Node
+	var provisioner *v1alpha4.Provisioner
+
+	BeforeEach(func() {
+		gate = NewGate()
+		provisioner = &v1alpha4.Provisioner{Spec: v1alpha4.ProvisionerSpec{DisruptionApproval: &v1alpha4.DisruptionApproval{}}}
+		node = test.Node(test.NodeOptions{
+			ProviderID: "test:///test-zone-1/i-123",
+			Labels: map[string]string{
+				v1alpha4.ProvisionerNameLabelKey: "default",
+				v1.LabelInstanceTypeStable:       "test-instance-type",
+				v1.LabelTopologyZone:             "test-zone-1",
+			},
+		})
+	})
+
+	// webhook responds with the status, counting requests
+	webhook := func(status int, requests *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(requests, 1)
+			w.WriteHeader(status)
+		}))
+	}
+
+	It("should approve if the provisioner doesn't require approval", func() {
+		provisioner.Spec.DisruptionApproval = nil
+		Expect(gate.Approve(ctx, provisioner, node, Expiration)).To(BeTrue())
+		Expect(node.Annotations).ToNot(HaveKey(v1alpha4.DisruptionPendingAnnotationKey))
+	})
+	It("should wait for the approval annotation without a webhook", func() {
+		Expect(gate.Approve(ctx, provisioner, node, Expiration)).To(BeFalse())
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, string(Expiration)))
+		node.Annotations[v1alpha4.DisruptionApprovedAnnotationKey] = "true"
+		Expect(gate.Approve(ctx, provisioner, node, Expiration)).To(BeTrue())
+	})
+	It("should update the pending reason", func() {
+		Expect(gate.Approve(ctx, provisioner, node, Consolidation)).To(BeFalse())
+		Expect(gate.Approve(ctx, provisioner, node, Drift)).To(BeFalse())
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, string(Drift)))
+	})
+	It("should post the disruption to the webhook", func() {
+		received := make(chan *Request, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal(http.MethodPost))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			request := &Request{}
+			Expect(json.NewDecoder(r.Body).Decode(request)).To(Succeed())
+			received <- request
+		}))
+		defer server.Close()
+		provisioner.Spec.DisruptionApproval.WebhookURL = server.URL
+		Expect(gate.Approve(ctx, provisioner, node, Drift)).To(BeTrue())
+		var request *Request
+		Eventually(received).Should(Receive(&request))
+		Expect(request.Reason).To(Equal(Drift))
+		Expect(request.Node).To(Equal(node.Name))
+		Expect(request.ProviderID).To(Equal("test:///test-zone-1/i-123"))
+		Expect(request.Provisioner).To(Equal("default"))
+		Expect(request.InstanceType).To(Equal("test-instance-type"))
+		Expect(request.Zone).To(Equal("test-zone-1"))
+		Expect(node.Annotations).ToNot(HaveKey(v1alpha4.DisruptionPendingAnnotationKey))
+	})
+	It("should leave the disruption pending if the webhook doesn't approve it", func() {
+		for _, status := range []int{http.StatusForbidden, http.StatusServiceUnavailable} {
+			var requests int32
+			server := webhook(status, &requests)
+			provisioner.Spec.DisruptionApproval.WebhookURL = server.URL
+			Expect(gate.Approve(ctx, provisioner, node, Consolidation)).To(BeFalse())
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, string(Consolidation)))
+			server.Close()
+		}
+	})
+	It("should leave the disruption pending if the webhook is unreachable", func() {
+		var requests int32
+		server := webhook(http.StatusOK, &requests)
+		server.Close()
+		provisioner.Spec.DisruptionApproval.WebhookURL = server.URL
+		Expect(gate.Approve(ctx, provisioner, node, Expiration)).To(BeFalse())
+	})
+	It("should cache webhook responses", func() {
+		var requests int32
+		server := webhook(http.StatusForbidden, &requests)
+		defer server.Close()
+		provisioner.Spec.DisruptionApproval.WebhookURL = server.URL
+		for i := 0; i < 3; i++ {
+			Expect(gate.Approve(ctx, provisioner, node, Expiration)).To(BeFalse())
+		}
+		Expect(atomic.LoadInt32(&requests)).To(BeNumerically("==", 1))
+		// Other reasons are requested separately
+		Expect(gate.Approve(ctx, provisioner, node, Drift)).To(BeFalse())
+		Expect(atomic.LoadInt32(&requests)).To(BeNumerically("==", 2))
+	})
+	It("should not call the webhook for approved nodes", func() {
+		var requests int32
+		server := webhook(http.StatusForbidden, &requests)
+		defer server.Close()
+		provisioner.Spec.DisruptionApproval.WebhookURL = server.URL
+		node.Annotations = map[string]string{v1alpha4.DisruptionApprovedAnnotationKey: "true"}
+		Expect(gate.Approve(ctx, provisioner, node, Expiration)).To(BeTrue())
+		Expect(atomic.LoadInt32(&requests)).To(BeNumerically("==", 0))
+	})
+})
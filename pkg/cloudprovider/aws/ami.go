@@ -18,13 +18,17 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
 	"github.com/patrickmn/go-cache"
 	"k8s.io/client-go/kubernetes"
 	"knative.dev/pkg/logging"
@@ -35,19 +39,39 @@ const kubernetesVersionCacheKey = "kubernetesVersion"
 type AMIProvider struct {
 	cache     *cache.Cache
 	ssm       ssmiface.SSMAPI
+	ec2api    ec2iface.EC2API
 	clientSet *kubernetes.Clientset
+	// resolved is the last AMI for each query, and replaced is the AMI that
+	// replaced each AMI that's no longer resolved. Unlike the cache, entries do
+	// not expire, so that changes to the resolved AMI can be detected.
+	resolved   map[string]string
+	replaced   map[string]string
+	resolvedMu sync.Mutex
+	// rootDeviceNames are the root devices of selected AMIs
+	rootDeviceNames   map[string]string
+	rootDeviceNamesMu sync.Mutex
 }
 
-func NewAMIProvider(ssm ssmiface.SSMAPI, clientSet *kubernetes.Clientset) *AMIProvider {
+func NewAMIProvider(ssm ssmiface.SSMAPI, ec2api ec2iface.EC2API, clientSet *kubernetes.Clientset) *AMIProvider {
 	return &AMIProvider{
-		ssm:       ssm,
-		clientSet: clientSet,
-		cache:     cache.New(CacheTTL, CacheCleanupInterval),
+		ssm:             ssm,
+		ec2api:          ec2api,
+		clientSet:       clientSet,
+		cache:           cache.New(CacheTTL, CacheCleanupInterval),
+		resolved:        map[string]string{},
+		replaced:        map[string]string{},
+		rootDeviceNames: map[string]string{},
 	}
 }
 
 // Get returns a set of AMIIDs and corresponding instance types. AMI may vary due to architecture, acclerator, etc
 func (p *AMIProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) (map[string][]cloudprovider.InstanceType, error) {
+	if constraints.AMISelector != nil {
+		return p.getSelectedAMIs(ctx, constraints.AMISelector, instanceTypes)
+	}
+	if constraints.GetAMIFamily() == v1alpha1.AMIFamilyCustom {
+		return nil, fmt.Errorf("no default amis for ami family %s, amiSelector is required", v1alpha1.AMIFamilyCustom)
+	}
 	version, err := p.kubeServerVersion(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("kube server version, %w", err)
@@ -55,7 +79,7 @@ func (p *AMIProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints
 	// Separate instance types by unique queries
 	amiQueries := map[string][]cloudprovider.InstanceType{}
 	for _, instanceType := range instanceTypes {
-		query := p.getSSMQuery(instanceType, version)
+		query := p.getSSMQuery(constraints.GetAMIFamily(), instanceType, version)
 		amiQueries[query] = append(amiQueries[query], instanceType)
 	}
 	// Separate instance types by unique AMIIDs
@@ -81,12 +105,45 @@ func (p *AMIProvider) getAMIID(ctx context.Context, query string) (string, error
 	ami := aws.StringValue(output.Parameter.Value)
 	p.cache.Set(query, ami, CacheTTL)
 	logging.FromContext(ctx).Debugf("Discovered ami %s for query %s", ami, query)
+	p.resolve(ctx, query, ami)
 	return ami, nil
 }
 
-func (p *AMIProvider) getSSMQuery(instanceType cloudprovider.InstanceType, version string) string {
+// resolve records the ami for the query, and the ami it replaced if it changed
+func (p *AMIProvider) resolve(ctx context.Context, query string, ami string) {
+	p.resolvedMu.Lock()
+	defer p.resolvedMu.Unlock()
+	previous, ok := p.resolved[query]
+	p.resolved[query] = ami
+	delete(p.replaced, ami)
+	if ok && previous != ami {
+		p.replaced[previous] = ami
+		logging.FromContext(ctx).Infof("Discovered ami %s for query %s, replacing %s", ami, query, previous)
+	}
+}
+
+// Replacement returns the ami that replaced the ami, or an empty string if it
+// hasn't been replaced
+func (p *AMIProvider) Replacement(ami string) string {
+	p.resolvedMu.Lock()
+	defer p.resolvedMu.Unlock()
+	return p.replaced[ami]
+}
+
+func (p *AMIProvider) getSSMQuery(amiFamily string, instanceType cloudprovider.InstanceType, version string) string {
+	capacity := instanceType.Resources()
+	_, nvidiaGPUs := capacity[resources.NvidiaGPU]
+	_, awsNeurons := capacity[resources.AWSNeuron]
+	switch amiFamily {
+	case v1alpha1.AMIFamilyBottlerocket:
+		var variant string
+		if nvidiaGPUs {
+			variant = "-nvidia"
+		}
+		return fmt.Sprintf("/aws/service/bottlerocket/aws-k8s-%s%s/%s/latest/image_id", version, variant, awsArchitecture(instanceType.Architecture()))
+	}
 	var amiSuffix string
-	if !instanceType.NvidiaGPUs().IsZero() || !instanceType.AWSNeurons().IsZero() {
+	if nvidiaGPUs || awsNeurons {
 		amiSuffix = "-gpu"
 	} else if instanceType.Architecture() == v1alpha4.ArchitectureArm64 {
 		amiSuffix = "-arm64"
@@ -94,6 +151,119 @@ func (p *AMIProvider) getSSMQuery(instanceType cloudprovider.InstanceType, versi
 	return fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2%s/recommended/image_id", version, amiSuffix)
 }
 
+// RootDeviceName returns the root device of the ami. Selected amis describe
+// their root device, and default amis use the root device of their family.
+// Custom amis that don't describe their root device are assumed to use AL2's.
+func (p *AMIProvider) RootDeviceName(constraints *v1alpha1.Constraints, ami string) string {
+	p.rootDeviceNamesMu.Lock()
+	defer p.rootDeviceNamesMu.Unlock()
+	if name, ok := p.rootDeviceNames[ami]; ok {
+		return name
+	}
+	if name, ok := familyRootDeviceNames[constraints.GetAMIFamily()]; ok {
+		return name
+	}
+	return familyRootDeviceNames[v1alpha1.AMIFamilyAL2]
+}
+
+// getSelectedAMIs separates instance types by the newest available AMI that
+// matches the selector and their architecture. Instance types whose
+// architecture doesn't match any AMI are omitted.
+func (p *AMIProvider) getSelectedAMIs(ctx context.Context, selector map[string]string, instanceTypes []cloudprovider.InstanceType) (map[string][]cloudprovider.InstanceType, error) {
+	images, err := p.getImages(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	newest := map[string]*ec2.Image{}
+	for _, image := range images {
+		// Only linux AMIs are supported, which have no platform
+		if image.Platform != nil {
+			continue
+		}
+		architecture, ok := v1alpha1.AWSToKubeArchitectures[aws.StringValue(image.Architecture)]
+		if !ok {
+			continue
+		}
+		if current, ok := newest[architecture]; !ok || aws.StringValue(image.CreationDate) > aws.StringValue(current.CreationDate) {
+			newest[architecture] = image
+		}
+	}
+	p.rootDeviceNamesMu.Lock()
+	for _, image := range newest {
+		if image.RootDeviceName != nil {
+			p.rootDeviceNames[aws.StringValue(image.ImageId)] = aws.StringValue(image.RootDeviceName)
+		}
+	}
+	p.rootDeviceNamesMu.Unlock()
+	amiIDs := map[string][]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		if image, ok := newest[instanceType.Architecture()]; ok {
+			amiIDs[aws.StringValue(image.ImageId)] = append(amiIDs[aws.StringValue(image.ImageId)], instanceType)
+		}
+	}
+	if len(amiIDs) == 0 {
+		return nil, fmt.Errorf("no amis match selector %v and the instance types' architectures", selector)
+	}
+	// Changes are detected per architecture, like the queries of default amis
+	for architecture, image := range newest {
+		query := fmt.Sprintf("%s/%s", selectorKey(selector), architecture)
+		p.resolve(ctx, query, aws.StringValue(image.ImageId))
+	}
+	return amiIDs, nil
+}
+
+func (p *AMIProvider) getImages(ctx context.Context, selector map[string]string) ([]*ec2.Image, error) {
+	key := selectorKey(selector)
+	if images, ok := p.cache.Get(key); ok {
+		return images.([]*ec2.Image), nil
+	}
+	output, err := p.ec2api.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{Filters: imageFilters(selector)})
+	if err != nil {
+		return nil, fmt.Errorf("describing images %v, %w", selector, err)
+	}
+	p.cache.Set(key, output.Images, CacheTTL)
+	logging.FromContext(ctx).Debugf("Discovered %d ami(s) for selector %v", len(output.Images), selector)
+	return output.Images, nil
+}
+
+func imageFilters(selector map[string]string) []*ec2.Filter {
+	filters := []*ec2.Filter{{Name: aws.String("state"), Values: aws.StringSlice([]string{ec2.ImageStateAvailable})}}
+	for _, key := range sortedKeys(selector) {
+		value := selector[key]
+		if key == v1alpha1.AMIIDsSelectorKey {
+			ids := []*string{}
+			for _, id := range strings.Split(value, ",") {
+				ids = append(ids, aws.String(strings.TrimSpace(id)))
+			}
+			filters = append(filters, &ec2.Filter{Name: aws.String("image-id"), Values: ids})
+		} else if value == "*" {
+			filters = append(filters, &ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(key)}})
+		} else {
+			filters = append(filters, &ec2.Filter{Name: aws.String(fmt.Sprintf("tag:%s", key)), Values: []*string{aws.String(value)}})
+		}
+	}
+	return filters
+}
+
+// selectorKey identifies the selector in caches, e.g. amiSelector/a=b,c=d
+func selectorKey(selector map[string]string) string {
+	pairs := []string{}
+	for _, key := range sortedKeys(selector) {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, selector[key]))
+	}
+	return fmt.Sprintf("amiSelector/%s", strings.Join(pairs, ","))
+}
+
+// awsArchitecture returns the EC2 name of the architecture, e.g. x86_64 for amd64
+func awsArchitecture(architecture string) string {
+	for ec2Architecture, kubeArchitecture := range v1alpha1.AWSToKubeArchitectures {
+		if kubeArchitecture == architecture {
+			return ec2Architecture
+		}
+	}
+	return architecture
+}
+
 func (p *AMIProvider) kubeServerVersion(ctx context.Context) (string, error) {
 	if version, ok := p.cache.Get(kubernetesVersionCacheKey); ok {
 		return version.(string), nil
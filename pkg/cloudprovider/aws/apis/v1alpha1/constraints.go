@@ -16,9 +16,12 @@ package v1alpha1
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
 )
 
 func NewConstraints(constraints *v1alpha4.Constraints) (*Constraints, error) {
@@ -56,15 +59,207 @@ type AWS struct {
 	// May be overriden by pods.spec.nodeSelector["node.k8s.aws/capacityType"]
 	// +optional
 	CapacityTypes []string `json:"capacityTypes,omitempty"`
+	// CapacityTypeFallback orders the capacity types to launch when flexible
+	// to more than one. If EC2 has insufficient capacity of one type, the
+	// launch is retried with the next. Defaults to spot, then on-demand, if
+	// CapacityTypes includes both.
+	// +optional
+	CapacityTypeFallback []string `json:"capacityTypeFallback,omitempty"`
 	// LaunchTemplate for the node. If not specified, a launch template will be generated.
 	// +optional
 	LaunchTemplate *string `json:"launchTemplate,omitempty"`
+	// AMIFamily determines the default AMIs, which are the latest EKS
+	// optimized AMIs of the family, and the format of the user data that
+	// bootstraps nodes. The Custom family has no default AMIs, so it requires
+	// an AMISelector, and bootstraps nodes with UserData. Defaults to AL2.
+	// +optional
+	AMIFamily *string `json:"amiFamily,omitempty"`
+	// AMISelector discovers AMIs by tags instead of the AMIFamily's defaults.
+	// The newest available AMI with each instance type's architecture is
+	// used, so launch templates are regenerated as new AMIs are tagged. A
+	// value of "*" is a wildcard. The aws-ids key selects AMIs by a comma
+	// separated list of AMI IDs. AMIs must be compatible with the AMIFamily.
+	// +optional
+	AMISelector map[string]string `json:"amiSelector,omitempty"`
+	// UserData bootstraps nodes of the Custom AMIFamily. It's passed through
+	// as is, except for template variables: {{ .ClusterName }},
+	// {{ .ClusterEndpoint }}, {{ .CABundle }}, and {{ .Labels }} and
+	// {{ .Taints }} formatted as the kubelet's --node-labels and
+	// --register-with-taints flags expect.
+	// +optional
+	UserData *string `json:"userData,omitempty"`
 	// SubnetSelector discovers subnets by tags. A value of "" is a wildcard.
+	// The aws-ids key selects subnets by a comma separated list of subnet IDs.
 	// +optional
 	SubnetSelector map[string]string `json:"subnetSelector,omitempty"`
+	// SubnetWeights are the relative weights, keyed by subnet ID, used to
+	// choose between selected subnets in the same zone for each launch.
+	// Subnets without a weight have a weight of 1.
+	// +optional
+	SubnetWeights map[string]int32 `json:"subnetWeights,omitempty"`
+	// ExcludedSubnets are the IDs of subnets that are never launched into,
+	// even if they are selected.
+	// +optional
+	ExcludedSubnets []string `json:"excludedSubnets,omitempty"`
 	// SecurityGroups specify the names of the security groups.
 	// +optional
 	SecurityGroupSelector map[string]string `json:"securityGroupSelector,omitempty"`
+	// PrepullImages are pulled in the background once the node has
+	// bootstrapped so that large workload images are already cached when pods
+	// land. Pulls are best effort and do not delay the node joining the cluster.
+	// Images must name their registry, e.g. docker.io/library/nginx:1.21.
+	// Private ECR images are pulled with the node's credentials, and other
+	// registries must allow anonymous pulls.
+	// +optional
+	PrepullImages []string `json:"prepullImages,omitempty"`
+	// PodDensityProfile selects how the number of pods per node is computed
+	// for the CNI in use. The vpc-cni profile (default) limits pods to the
+	// number of IP addresses available to the instance type's network
+	// interfaces. Overlay profiles (cilium-overlay, calico) aren't limited by
+	// network interfaces and use the kubelet default of 110. The Provisioner's
+	// kubeletConfiguration.maxPods overrides the profile.
+	// +optional
+	PodDensityProfile string `json:"podDensityProfile,omitempty"`
+	// PodsPerCore limits the number of pods per node to this value multiplied
+	// by the instance type's number of cores. The lesser of this limit and
+	// the pod density is used.
+	// +optional
+	PodsPerCore *int32 `json:"podsPerCore,omitempty"`
+	// BlockDeviceMappings configure the volumes attached to the node. If not
+	// specified, the root volume is configured with the controller's global
+	// volume defaults.
+	// +optional
+	BlockDeviceMappings []*BlockDeviceMapping `json:"blockDeviceMappings,omitempty"`
+	// Tags are applied to the instances, volumes and launch templates that
+	// Karpenter creates, in addition to the tags it uses for discovery, e.g.
+	// for cost allocation. The Name tag may be overridden, while keys with
+	// the kubernetes.io/, karpenter.sh/ and aws: prefixes are reserved.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+	// MetadataOptions configure the instance metadata service of nodes. If
+	// not specified, IMDSv2 is required with a hop limit of 2.
+	// +optional
+	MetadataOptions *MetadataOptions `json:"metadataOptions,omitempty"`
+}
+
+// UserDataValues are the template variables of the Custom AMIFamily's
+// UserData. Labels and Taints are comma separated, e.g. "a=b,c=d" and
+// "key=value:NoSchedule".
+type UserDataValues struct {
+	ClusterName     string
+	ClusterEndpoint string
+	CABundle        string
+	Labels          string
+	Taints          string
+}
+
+// MetadataOptions configure the instance metadata service
+type MetadataOptions struct {
+	// HTTPEndpoint enables or disables the metadata service, defaults to enabled
+	// +optional
+	HTTPEndpoint *string `json:"httpEndpoint,omitempty"`
+	// HTTPTokens are required for IMDSv2, or optional to allow IMDSv1.
+	// Defaults to required.
+	// +optional
+	HTTPTokens *string `json:"httpTokens,omitempty"`
+	// HTTPPutResponseHopLimit is the number of network hops that metadata
+	// responses may travel, from 1 to 64. Defaults to 2, so that pods without
+	// host networking may reach the metadata service.
+	// +optional
+	HTTPPutResponseHopLimit *int64 `json:"httpPutResponseHopLimit,omitempty"`
+}
+
+// BlockDeviceMapping attaches an EBS volume to the node
+type BlockDeviceMapping struct {
+	// DeviceName is the device the volume is exposed as, e.g. /dev/xvda
+	// +required
+	DeviceName string `json:"deviceName"`
+	// EBS configures the volume.
+	// +required
+	EBS *BlockDevice `json:"ebs"`
+}
+
+// BlockDevice configures an EBS volume
+type BlockDevice struct {
+	// VolumeType of the volume, e.g. gp3
+	// +optional
+	VolumeType *string `json:"volumeType,omitempty"`
+	// VolumeSize in GiB
+	// +optional
+	VolumeSize *int64 `json:"volumeSize,omitempty"`
+	// IOPS provisioned for io1, io2 and gp3 volumes
+	// +optional
+	IOPS *int64 `json:"iops,omitempty"`
+	// Throughput in MiB/s provisioned for gp3 volumes
+	// +optional
+	Throughput *int64 `json:"throughput,omitempty"`
+	// Encrypted volumes use the KMSKeyID, or the account's default EBS key.
+	// +optional
+	Encrypted *bool `json:"encrypted,omitempty"`
+	// KMSKeyID encrypts the volume, requires Encrypted.
+	// +optional
+	KMSKeyID *string `json:"kmsKeyID,omitempty"`
+	// DeleteOnTermination defaults to true.
+	// +optional
+	DeleteOnTermination *bool `json:"deleteOnTermination,omitempty"`
+}
+
+// ImageRegistry returns the registry host of an image reference, e.g.
+// "public.ecr.aws" for "public.ecr.aws/a/image:latest", or "" if the reference
+// relies on the default registry, e.g. "nginx:1.21"
+func ImageRegistry(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	if host := parts[0]; host == "localhost" || strings.ContainsAny(host, ".:") {
+		return host
+	}
+	return ""
+}
+
+// GetAMIFamily returns the configured AMI family, or AL2 if unset
+func (c *Constraints) GetAMIFamily() string {
+	if c.AMIFamily == nil {
+		return AMIFamilyAL2
+	}
+	return *c.AMIFamily
+}
+
+// GetMetadataOptions returns the configured metadata options, with defaults
+// for unset fields
+func (c *Constraints) GetMetadataOptions() *MetadataOptions {
+	options := &MetadataOptions{
+		HTTPEndpoint:            ptr.String(ec2.LaunchTemplateInstanceMetadataEndpointStateEnabled),
+		HTTPTokens:              ptr.String(ec2.LaunchTemplateHttpTokensStateRequired),
+		HTTPPutResponseHopLimit: ptr.Int64(DefaultMetadataHopLimit),
+	}
+	if c.MetadataOptions == nil {
+		return options
+	}
+	if c.MetadataOptions.HTTPEndpoint != nil {
+		options.HTTPEndpoint = c.MetadataOptions.HTTPEndpoint
+	}
+	if c.MetadataOptions.HTTPTokens != nil {
+		options.HTTPTokens = c.MetadataOptions.HTTPTokens
+	}
+	if c.MetadataOptions.HTTPPutResponseHopLimit != nil {
+		options.HTTPPutResponseHopLimit = c.MetadataOptions.HTTPPutResponseHopLimit
+	}
+	return options
+}
+
+// KubeletMaxPods returns the --max-pods value that must be passed to the
+// kubelet, or nil if the ENI limited default applies.
+func (c *Constraints) KubeletMaxPods() *int64 {
+	if maxPods := c.KubeletConfiguration.GetMaxPods(); maxPods != nil {
+		return ptr.Int64(int64(*maxPods))
+	}
+	switch c.PodDensityProfile {
+	case PodDensityProfileCiliumOverlay, PodDensityProfileCalico:
+		return ptr.Int64(OverlayMaxPods)
+	}
+	return nil
 }
 
 // Cluster configures the cluster that the provisioner operates against.
@@ -72,7 +267,8 @@ type Cluster struct {
 	// Name is required to authenticate with the API Server.
 	// +required
 	Name string `json:"name"`
-	// Endpoint is required for nodes to connect to the API Server.
-	// +required
-	Endpoint string `json:"endpoint"`
+	// Endpoint nodes use to connect to the API Server. If not specified, it
+	// is discovered from the controller's configuration or EKS.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
 }
@@ -20,6 +20,8 @@ import (
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	podutil "github.com/awslabs/karpenter/pkg/utils/pod"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -28,6 +30,7 @@ var ClusterDiscoveryTagKeyFormat = "kubernetes.io/cluster/%s"
 // Default the constraints.
 func (c *Constraints) Default(ctx context.Context) {
 	c.defaultCapacityTypes()
+	c.defaultCapacityTypeFallback()
 	c.defaultSubnets()
 	c.defaultSecurityGroups()
 }
@@ -39,6 +42,15 @@ func (c *Constraints) defaultCapacityTypes() {
 	c.CapacityTypes = []string{CapacityTypeOnDemand}
 }
 
+func (c *Constraints) defaultCapacityTypeFallback() {
+	if c.CapacityTypeFallback != nil {
+		return
+	}
+	if functional.ContainsString(c.CapacityTypes, CapacityTypeSpot) && functional.ContainsString(c.CapacityTypes, CapacityTypeOnDemand) {
+		c.CapacityTypeFallback = []string{CapacityTypeSpot, CapacityTypeOnDemand}
+	}
+}
+
 func (c *Constraints) defaultSubnets() {
 	if c.SubnetSelector != nil {
 		return
@@ -57,10 +69,24 @@ func (c *Constraints) defaultSecurityGroups() {
 // Returns an error if the constraints cannot be applied.
 func (c *Constraints) Constrain(pods ...*v1.Pod) error {
 	nodeAffinity := scheduling.NodeAffinityFor(pods...)
-	capacityTypes := nodeAffinity.GetLabelValues(CapacityTypeLabel, c.CapacityTypes, v1alpha4.WellKnownLabels[CapacityTypeLabel])
+	capacityTypes := nodeAffinity.GetLabelValues(CapacityTypeLabel, c.CapacityTypes, v1alpha4.WellKnownLabels.Values(CapacityTypeLabel))
 	if len(capacityTypes) == 0 {
-		return fmt.Errorf("no valid capacity types")
+		return nodeAffinity.ConflictFor(CapacityTypeLabel, functional.IntersectStringSlice(c.CapacityTypes, v1alpha4.WellKnownLabels.Values(CapacityTypeLabel)))
+	}
+	// Pods that missed their provisioning deadline launch on-demand if they
+	// may, since spot capacity may be what they've been waiting for
+	if escalated(pods...) && functional.ContainsString(capacityTypes, CapacityTypeOnDemand) {
+		capacityTypes = []string{CapacityTypeOnDemand}
 	}
 	c.CapacityTypes = capacityTypes
 	return nil
 }
+
+func escalated(pods ...*v1.Pod) bool {
+	for _, pod := range pods {
+		if podutil.IsEscalated(pod) {
+			return true
+		}
+	}
+	return false
+}
@@ -17,9 +17,13 @@ package v1alpha1
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"strings"
+	"text/template"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"knative.dev/pkg/apis"
 )
 
@@ -32,14 +36,30 @@ func (c *Constraints) validate(ctx context.Context) (errs *apis.FieldError) {
 		c.validateInstanceProfile(),
 		c.validateCapacityTypes(),
 		c.validateLaunchTemplate(),
+		c.validateAMIs(),
 		c.validateSubnets(),
 		c.validateSecurityGroups(),
+		c.validatePrepullImages(),
+		c.validatePodDensity(),
+		c.validateBlockDeviceMappings(),
+		c.validateTags(),
+		c.MetadataOptions.validate().ViaField("metadataOptions"),
 		c.Cluster.Validate(ctx).ViaField("cluster"),
 	)
 }
 
 func (c *Constraints) validateCapacityTypes() (errs *apis.FieldError) {
-	return v1alpha4.ValidateWellKnown(CapacityTypeLabel, c.CapacityTypes, "capacityTypes")
+	errs = v1alpha4.ValidateWellKnown(CapacityTypeLabel, c.CapacityTypes, "capacityTypes")
+	errs = errs.Also(v1alpha4.ValidateWellKnown(CapacityTypeLabel, c.CapacityTypeFallback, "capacityTypeFallback"))
+	for i, capacityType := range c.CapacityTypeFallback {
+		if functional.ContainsString(c.CapacityTypeFallback[:i], capacityType) {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s is duplicated", capacityType), "capacityTypeFallback", i))
+		}
+		if c.CapacityTypes != nil && !functional.ContainsString(c.CapacityTypes, capacityType) {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s not in capacityTypes %v", capacityType, c.CapacityTypes), "capacityTypeFallback", i))
+		}
+	}
+	return errs
 }
 
 func (c *Constraints) validateInstanceProfile() (errs *apis.FieldError) {
@@ -50,10 +70,87 @@ func (c *Constraints) validateInstanceProfile() (errs *apis.FieldError) {
 }
 
 func (c *Constraints) validateLaunchTemplate() (errs *apis.FieldError) {
-	// nothing to validate at the moment
+	if c.LaunchTemplate == nil {
+		return errs
+	}
+	if c.AMIFamily != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "amiFamily"))
+	}
+	if c.AMISelector != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "amiSelector"))
+	}
+	if c.MetadataOptions != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "metadataOptions"))
+	}
+	if c.Tags != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "tags"))
+	}
+	if c.UserData != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "userData"))
+	}
 	return errs
 }
 
+func (c *Constraints) validateAMIs() (errs *apis.FieldError) {
+	if c.AMIFamily != nil && !functional.ContainsString(AMIFamilies, *c.AMIFamily) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *c.AMIFamily, AMIFamilies), "amiFamily"))
+	}
+	if c.AMISelector != nil && len(c.AMISelector) == 0 {
+		errs = errs.Also(apis.ErrInvalidValue("{}", "amiSelector"))
+	}
+	for key, value := range c.AMISelector {
+		if key == "" || value == "" {
+			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("amiSelector['%s']", key)))
+		} else if key == AMIIDsSelectorKey {
+			for _, id := range strings.Split(value, ",") {
+				if strings.TrimSpace(id) == "" {
+					errs = errs.Also(apis.ErrInvalidValue(value, fmt.Sprintf("amiSelector['%s']", key)))
+					break
+				}
+			}
+		}
+	}
+	if c.GetAMIFamily() == AMIFamilyBottlerocket {
+		// Bottlerocket's user data is settings rather than a script
+		if len(c.PrepullImages) > 0 {
+			errs = errs.Also(apis.ErrGeneric("prepullImages are not supported by Bottlerocket", "prepullImages"))
+		}
+		if c.PodsPerCore != nil {
+			errs = errs.Also(apis.ErrGeneric("podsPerCore is not supported by Bottlerocket", "podsPerCore"))
+		}
+	}
+	if c.GetAMIFamily() == AMIFamilyCustom {
+		// Custom user data is passed through, so Karpenter can't add to it
+		if c.AMISelector == nil {
+			errs = errs.Also(apis.ErrGeneric("amiSelector is required by Custom", "amiSelector"))
+		}
+		if c.UserData == nil {
+			errs = errs.Also(apis.ErrGeneric("userData is required by Custom", "userData"))
+		} else if err := validateUserData(*c.UserData); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(err.Error(), "userData"))
+		}
+		if len(c.PrepullImages) > 0 {
+			errs = errs.Also(apis.ErrGeneric("prepullImages are not supported by Custom", "prepullImages"))
+		}
+		if c.PodsPerCore != nil {
+			errs = errs.Also(apis.ErrGeneric("podsPerCore is not supported by Custom", "podsPerCore"))
+		}
+	} else if c.UserData != nil {
+		errs = errs.Also(apis.ErrGeneric("userData is only supported by Custom", "userData"))
+	}
+	return errs
+}
+
+// validateUserData parses the template and executes it with empty values, so
+// that unknown variables are rejected at admission rather than at launch
+func validateUserData(userData string) error {
+	userDataTemplate, err := template.New("userData").Option("missingkey=error").Parse(userData)
+	if err != nil {
+		return err
+	}
+	return userDataTemplate.Execute(ioutil.Discard, UserDataValues{})
+}
+
 func (c *Constraints) validateSubnets() (errs *apis.FieldError) {
 	if c.SubnetSelector == nil {
 		errs = errs.Also(apis.ErrMissingField("subnetSelector"))
@@ -61,6 +158,26 @@ func (c *Constraints) validateSubnets() (errs *apis.FieldError) {
 	for key, value := range c.SubnetSelector {
 		if key == "" || value == "" {
 			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("subnetSelector['%s']", key)))
+		} else if key == SubnetIDsSelectorKey {
+			for _, id := range strings.Split(value, ",") {
+				if strings.TrimSpace(id) == "" {
+					errs = errs.Also(apis.ErrInvalidValue(value, fmt.Sprintf("subnetSelector['%s']", key)))
+					break
+				}
+			}
+		}
+	}
+	for id, weight := range c.SubnetWeights {
+		if id == "" {
+			errs = errs.Also(apis.ErrInvalidValue("\"\"", "subnetWeights"))
+		}
+		if weight < 1 {
+			errs = errs.Also(apis.ErrInvalidValue(weight, fmt.Sprintf("subnetWeights['%s']", id)))
+		}
+	}
+	for i, id := range c.ExcludedSubnets {
+		if id == "" {
+			errs = errs.Also(apis.ErrInvalidArrayValue("\"\"", "excludedSubnets", i))
 		}
 	}
 	return errs
@@ -78,13 +195,99 @@ func (c *Constraints) validateSecurityGroups() (errs *apis.FieldError) {
 	return errs
 }
 
+func (c *Constraints) validatePrepullImages() (errs *apis.FieldError) {
+	for i, image := range c.PrepullImages {
+		// Images are interpolated into user data, so reject anything that
+		// could escape the quoted argument.
+		if image == "" || strings.ContainsAny(image, "'\"\\$` \t\n") {
+			errs = errs.Also(apis.ErrInvalidArrayValue(image, "prepullImages", i))
+			continue
+		}
+		// Images are pulled with ctr, which doesn't resolve the default
+		// registry like the kubelet does
+		if ImageRegistry(image) == "" {
+			errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("%s must name its registry, e.g. docker.io/library/nginx:1.21", image), fmt.Sprintf("prepullImages[%d]", i)))
+		}
+	}
+	return errs
+}
+
+func (c *Constraints) validatePodDensity() (errs *apis.FieldError) {
+	if c.PodDensityProfile != "" && !functional.ContainsString(PodDensityProfiles, c.PodDensityProfile) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", c.PodDensityProfile, PodDensityProfiles), "podDensityProfile"))
+	}
+	if c.PodsPerCore != nil && *c.PodsPerCore < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(*c.PodsPerCore, "podsPerCore"))
+	}
+	return errs
+}
+
+func (c *Constraints) validateTags() (errs *apis.FieldError) {
+	for key, value := range c.Tags {
+		if key == "" {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "tags", "cannot be empty"))
+			continue
+		}
+		if len(key) > MaxTagKeyLength {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "tags", fmt.Sprintf("cannot exceed %d characters", MaxTagKeyLength)))
+		}
+		for _, prefix := range ReservedTagPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				errs = errs.Also(apis.ErrInvalidKeyName(key, "tags", fmt.Sprintf("%s is a reserved prefix", prefix)))
+			}
+		}
+		if len(value) > MaxTagValueLength {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("cannot exceed %d characters", MaxTagValueLength), fmt.Sprintf("tags['%s']", key)))
+		}
+	}
+	return errs
+}
+
+func (c *Constraints) validateBlockDeviceMappings() (errs *apis.FieldError) {
+	for i, mapping := range c.BlockDeviceMappings {
+		errs = errs.Also(mapping.validate().ViaFieldIndex("blockDeviceMappings", i))
+	}
+	return errs
+}
+
+func (b *BlockDeviceMapping) validate() (errs *apis.FieldError) {
+	if b == nil {
+		return apis.ErrMissingField("deviceName", "ebs")
+	}
+	if b.DeviceName == "" {
+		errs = errs.Also(apis.ErrMissingField("deviceName"))
+	}
+	if b.EBS == nil {
+		return errs.Also(apis.ErrMissingField("ebs"))
+	}
+	return errs.Also(b.EBS.validate().ViaField("ebs"))
+}
+
+func (b *BlockDevice) validate() (errs *apis.FieldError) {
+	if b.VolumeType != nil && !functional.ContainsString(VolumeTypes, *b.VolumeType) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *b.VolumeType, VolumeTypes), "volumeType"))
+	}
+	if b.VolumeSize != nil && *b.VolumeSize < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(*b.VolumeSize, "volumeSize"))
+	}
+	if b.IOPS != nil && *b.IOPS < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(*b.IOPS, "iops"))
+	}
+	if b.Throughput != nil && *b.Throughput < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(*b.Throughput, "throughput"))
+	}
+	if b.KMSKeyID != nil && (b.Encrypted == nil || !*b.Encrypted) {
+		errs = errs.Also(apis.ErrGeneric("kmsKeyID requires encrypted", "kmsKeyID", "encrypted"))
+	}
+	return errs
+}
+
 func (c *Cluster) Validate(context.Context) (errs *apis.FieldError) {
 	if len(c.Name) == 0 {
 		errs = errs.Also(apis.ErrMissingField("name"))
 	}
-	if len(c.Endpoint) == 0 {
-		errs = errs.Also(apis.ErrMissingField("endpoint"))
-	} else {
+	// The endpoint is discovered by the cloud provider if not specified
+	if len(c.Endpoint) != 0 {
 		endpoint, err := url.Parse(c.Endpoint)
 		// url.Parse() will accept a lot of input without error; make
 		// sure it's a real URL
@@ -94,3 +297,19 @@ func (c *Cluster) Validate(context.Context) (errs *apis.FieldError) {
 	}
 	return errs
 }
+
+func (m *MetadataOptions) validate() (errs *apis.FieldError) {
+	if m == nil {
+		return nil
+	}
+	if m.HTTPEndpoint != nil && !functional.ContainsString(MetadataHTTPEndpoints, *m.HTTPEndpoint) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *m.HTTPEndpoint, MetadataHTTPEndpoints), "httpEndpoint"))
+	}
+	if m.HTTPTokens != nil && !functional.ContainsString(MetadataHTTPTokens, *m.HTTPTokens) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *m.HTTPTokens, MetadataHTTPTokens), "httpTokens"))
+	}
+	if m.HTTPPutResponseHopLimit != nil && (*m.HTTPPutResponseHopLimit < 1 || *m.HTTPPutResponseHopLimit > 64) {
+		errs = errs.Also(apis.ErrOutOfBoundsValue(*m.HTTPPutResponseHopLimit, 1, 64, "httpPutResponseHopLimit"))
+	}
+	return errs
+}
@@ -23,14 +23,55 @@ import (
 )
 
 var (
-	AWSLabelPrefix         = "node.k8s.aws/"
-	CapacityTypeLabel      = AWSLabelPrefix + "capacity-type"
-	CapacityTypeSpot       = ec2.DefaultTargetCapacityTypeSpot
-	CapacityTypeOnDemand   = ec2.DefaultTargetCapacityTypeOnDemand
+	AWSLabelPrefix       = "node.k8s.aws/"
+	CapacityTypeLabel    = AWSLabelPrefix + "capacity-type"
+	CapacityTypeSpot     = ec2.DefaultTargetCapacityTypeSpot
+	CapacityTypeOnDemand = ec2.DefaultTargetCapacityTypeOnDemand
+	// AMIIDAnnotationKey is the AMI a node was launched with
+	AMIIDAnnotationKey = AWSLabelPrefix + "ami-id"
+	// GPUModelLabel is the manufacturer and model of the node's GPUs, e.g. nvidia-v100
+	GPUModelLabel = AWSLabelPrefix + "gpu-model"
+	// GPUMemoryLabel is the memory of each of the node's GPUs in MiB
+	GPUMemoryLabel = AWSLabelPrefix + "gpu-memory"
+	// InstanceFamilyLabel is the family of the node's instance type, e.g. m5
+	InstanceFamilyLabel = AWSLabelPrefix + "instance-family"
+	// InstanceTypeLabels are derived from the attributes of instance types
+	InstanceTypeLabels = []string{GPUModelLabel, GPUMemoryLabel, InstanceFamilyLabel}
+	// PodDensityProfiles compute the number of pods per node for a given CNI
+	PodDensityProfileVPCCNI        = "vpc-cni"
+	PodDensityProfileCiliumOverlay = "cilium-overlay"
+	PodDensityProfileCalico        = "calico"
+	PodDensityProfiles             = []string{PodDensityProfileVPCCNI, PodDensityProfileCiliumOverlay, PodDensityProfileCalico}
+	// OverlayMaxPods is the kubelet's default --max-pods
+	OverlayMaxPods = int64(110)
+	// DefaultMetadataHopLimit allows pods without host networking to reach
+	// the instance metadata service through the node
+	DefaultMetadataHopLimit = int64(2)
+	// SubnetIDsSelectorKey selects subnets by a comma separated list of IDs
+	SubnetIDsSelectorKey = "aws-ids"
+	// AMIIDsSelectorKey selects AMIs by a comma separated list of IDs
+	AMIIDsSelectorKey = "aws-ids"
+	// AMIFamilies determine the default AMIs and the format of user data
+	AMIFamilyAL2          = "AL2"
+	AMIFamilyBottlerocket = "Bottlerocket"
+	AMIFamilyCustom       = "Custom"
+	AMIFamilies           = []string{AMIFamilyAL2, AMIFamilyBottlerocket, AMIFamilyCustom}
+	// VolumeTypes are the EBS volume types supported for block devices
+	VolumeTypes            = ec2.VolumeType_Values()
 	AWSToKubeArchitectures = map[string]string{
 		"x86_64":                   v1alpha4.ArchitectureAmd64,
 		v1alpha4.ArchitectureArm64: v1alpha4.ArchitectureArm64,
 	}
+	// MetadataHTTPTokens and MetadataHTTPEndpoints are the supported instance
+	// metadata options
+	MetadataHTTPTokens    = ec2.LaunchTemplateHttpTokensState_Values()
+	MetadataHTTPEndpoints = ec2.LaunchTemplateInstanceMetadataEndpointState_Values()
+	// ReservedTagPrefixes are tag key prefixes used for discovery by
+	// Kubernetes and Karpenter, or reserved by AWS, that users may not set
+	ReservedTagPrefixes = []string{"kubernetes.io/", v1alpha4.SchemeGroupVersion.Group + "/", "aws:"}
+	// MaxTagKeyLength and MaxTagValueLength are the EC2 limits on tags
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 256
 )
 
 var (
@@ -40,6 +81,10 @@ var (
 
 func init() {
 	Scheme.AddKnownTypes(schema.GroupVersion{Group: v1alpha4.ExtensionsGroup, Version: "v1alpha1"}, &AWS{})
-	v1alpha4.RestrictedLabels = append(v1alpha4.RestrictedLabels, AWSLabelPrefix)
-	v1alpha4.WellKnownLabels[CapacityTypeLabel] = []string{CapacityTypeSpot, CapacityTypeOnDemand}
+	v1alpha4.WellKnownLabels.Restrict(AWSLabelPrefix)
+	v1alpha4.WellKnownLabels.Register(CapacityTypeLabel, CapacityTypeSpot, CapacityTypeOnDemand)
+	// Values depend on the instance types available, which are resolved by the cloud provider
+	for _, label := range InstanceTypeLabels {
+		v1alpha4.WellKnownLabels.RegisterDynamic(label)
+	}
 }
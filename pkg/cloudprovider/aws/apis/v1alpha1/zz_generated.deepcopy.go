@@ -33,11 +33,33 @@ func (in *AWS) DeepCopyInto(out *AWS) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.CapacityTypeFallback != nil {
+		in, out := &in.CapacityTypeFallback, &out.CapacityTypeFallback
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.LaunchTemplate != nil {
 		in, out := &in.LaunchTemplate, &out.LaunchTemplate
 		*out = new(string)
 		**out = **in
 	}
+	if in.AMIFamily != nil {
+		in, out := &in.AMIFamily, &out.AMIFamily
+		*out = new(string)
+		**out = **in
+	}
+	if in.AMISelector != nil {
+		in, out := &in.AMISelector, &out.AMISelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.UserData != nil {
+		in, out := &in.UserData, &out.UserData
+		*out = new(string)
+		**out = **in
+	}
 	if in.SubnetSelector != nil {
 		in, out := &in.SubnetSelector, &out.SubnetSelector
 		*out = make(map[string]string, len(*in))
@@ -45,6 +67,18 @@ func (in *AWS) DeepCopyInto(out *AWS) {
 			(*out)[key] = val
 		}
 	}
+	if in.SubnetWeights != nil {
+		in, out := &in.SubnetWeights, &out.SubnetWeights
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExcludedSubnets != nil {
+		in, out := &in.ExcludedSubnets, &out.ExcludedSubnets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.SecurityGroupSelector != nil {
 		in, out := &in.SecurityGroupSelector, &out.SecurityGroupSelector
 		*out = make(map[string]string, len(*in))
@@ -52,6 +86,39 @@ func (in *AWS) DeepCopyInto(out *AWS) {
 			(*out)[key] = val
 		}
 	}
+	if in.PrepullImages != nil {
+		in, out := &in.PrepullImages, &out.PrepullImages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PodsPerCore != nil {
+		in, out := &in.PodsPerCore, &out.PodsPerCore
+		*out = new(int32)
+		**out = **in
+	}
+	if in.BlockDeviceMappings != nil {
+		in, out := &in.BlockDeviceMappings, &out.BlockDeviceMappings
+		*out = make([]*BlockDeviceMapping, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(BlockDeviceMapping)
+				(*in).DeepCopyInto(*out)
+			}
+		}
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.MetadataOptions != nil {
+		in, out := &in.MetadataOptions, &out.MetadataOptions
+		*out = new(MetadataOptions)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWS.
@@ -72,6 +139,76 @@ func (in *AWS) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlockDevice) DeepCopyInto(out *BlockDevice) {
+	*out = *in
+	if in.VolumeType != nil {
+		in, out := &in.VolumeType, &out.VolumeType
+		*out = new(string)
+		**out = **in
+	}
+	if in.VolumeSize != nil {
+		in, out := &in.VolumeSize, &out.VolumeSize
+		*out = new(int64)
+		**out = **in
+	}
+	if in.IOPS != nil {
+		in, out := &in.IOPS, &out.IOPS
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Throughput != nil {
+		in, out := &in.Throughput, &out.Throughput
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Encrypted != nil {
+		in, out := &in.Encrypted, &out.Encrypted
+		*out = new(bool)
+		**out = **in
+	}
+	if in.KMSKeyID != nil {
+		in, out := &in.KMSKeyID, &out.KMSKeyID
+		*out = new(string)
+		**out = **in
+	}
+	if in.DeleteOnTermination != nil {
+		in, out := &in.DeleteOnTermination, &out.DeleteOnTermination
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlockDevice.
+func (in *BlockDevice) DeepCopy() *BlockDevice {
+	if in == nil {
+		return nil
+	}
+	out := new(BlockDevice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlockDeviceMapping) DeepCopyInto(out *BlockDeviceMapping) {
+	*out = *in
+	if in.EBS != nil {
+		in, out := &in.EBS, &out.EBS
+		*out = new(BlockDevice)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlockDeviceMapping.
+func (in *BlockDeviceMapping) DeepCopy() *BlockDeviceMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(BlockDeviceMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Cluster) DeepCopyInto(out *Cluster) {
 	*out = *in
@@ -111,3 +248,48 @@ func (in *Constraints) DeepCopy() *Constraints {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetadataOptions) DeepCopyInto(out *MetadataOptions) {
+	*out = *in
+	if in.HTTPEndpoint != nil {
+		in, out := &in.HTTPEndpoint, &out.HTTPEndpoint
+		*out = new(string)
+		**out = **in
+	}
+	if in.HTTPTokens != nil {
+		in, out := &in.HTTPTokens, &out.HTTPTokens
+		*out = new(string)
+		**out = **in
+	}
+	if in.HTTPPutResponseHopLimit != nil {
+		in, out := &in.HTTPPutResponseHopLimit, &out.HTTPPutResponseHopLimit
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetadataOptions.
+func (in *MetadataOptions) DeepCopy() *MetadataOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(MetadataOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UserDataValues) DeepCopyInto(out *UserDataValues) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UserDataValues.
+func (in *UserDataValues) DeepCopy() *UserDataValues {
+	if in == nil {
+		return nil
+	}
+	out := new(UserDataValues)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,218 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	envutils "github.com/awslabs/karpenter/pkg/utils/env"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"knative.dev/pkg/logging"
+)
+
+// AuditLogOptions configure the audit log of mutating AWS API calls
+type AuditLogOptions struct {
+	Enabled bool
+	// Path of a file to append records to, defaults to the controller's logs
+	Path string
+}
+
+var auditLogOptions = AuditLogOptions{}
+
+func init() {
+	flag.BoolVar(&auditLogOptions.Enabled, "aws-audit-log", envutils.WithDefaultBool("AWS_AUDIT_LOG", false), "Record every mutating AWS API call, e.g. for security reviews")
+	flag.StringVar(&auditLogOptions.Path, "aws-audit-log-path", envutils.WithDefaultString("AWS_AUDIT_LOG_PATH", ""), "Append audit records to this file rather than the controller's logs")
+}
+
+var (
+	// readOnlyOperationPrefixes identify API calls that don't mutate resources.
+	// Receiving messages only hides them from other consumers, and would
+	// otherwise record every long poll of the interruption queue.
+	readOnlyOperationPrefixes = []string{"Describe", "Get", "List", "Receive"}
+	// redactedFields may contain credentials (e.g. the cluster's CA bundle)
+	redactedFields = []string{"UserData"}
+)
+
+// AuditRecord describes a mutating AWS API call
+type AuditRecord struct {
+	Time        time.Time   `json:"time"`
+	Service     string      `json:"service"`
+	Operation   string      `json:"operation"`
+	RequestID   string      `json:"requestID,omitempty"`
+	Request     interface{} `json:"request"`
+	ResourceIDs []string    `json:"resourceIDs,omitempty"`
+	Latency     string      `json:"latency"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// AuditLogger records mutating AWS API calls, with sensitive request fields
+// redacted, either to the controller's logs or to a file.
+type AuditLogger struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func NewAuditLogger(ctx context.Context, options AuditLogOptions) (*AuditLogger, error) {
+	auditLogger := &AuditLogger{ctx: logging.WithLogger(ctx, logging.FromContext(ctx).Named("audit"))}
+	if options.Path != "" {
+		file, err := os.OpenFile(options.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log, %w", err)
+		}
+		auditLogger.writer = file
+	}
+	return auditLogger, nil
+}
+
+// Handler records requests once they've completed, including retries
+func (a *AuditLogger) Handler() request.NamedHandler {
+	return request.NamedHandler{Name: "karpenter.sh/audit", Fn: a.record}
+}
+
+func (a *AuditLogger) record(r *request.Request) {
+	if !isMutating(r) {
+		return
+	}
+	record := auditRecordFor(r)
+	if a.writer == nil {
+		logging.FromContext(a.ctx).Infow("Called AWS API",
+			"service", record.Service,
+			"operation", record.Operation,
+			"requestID", record.RequestID,
+			"request", record.Request,
+			"resourceIDs", record.ResourceIDs,
+			"latency", record.Latency,
+			"error", record.Error,
+		)
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		logging.FromContext(a.ctx).Errorf("Encoding audit record for %s, %s", record.Operation, err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.writer.Write(append(line, '\n')); err != nil {
+		logging.FromContext(a.ctx).Errorf("Writing audit record for %s, %s", record.Operation, err.Error())
+	}
+}
+
+func auditRecordFor(r *request.Request) *AuditRecord {
+	record := &AuditRecord{
+		Time:        r.Time,
+		Service:     r.ClientInfo.ServiceName,
+		Operation:   r.Operation.Name,
+		RequestID:   r.RequestID,
+		Request:     redacted(decoded(r.Params)),
+		ResourceIDs: resourceIDs(decoded(r.Data)),
+		Latency:     time.Since(r.Time).Round(time.Millisecond).String(),
+	}
+	if r.Error != nil {
+		record.Error = r.Error.Error()
+	}
+	return record
+}
+
+// isMutating returns false for read only and dry run requests
+func isMutating(r *request.Request) bool {
+	for _, prefix := range readOnlyOperationPrefixes {
+		if strings.HasPrefix(r.Operation.Name, prefix) {
+			return false
+		}
+	}
+	params := reflect.Indirect(reflect.ValueOf(r.Params))
+	if params.Kind() == reflect.Struct {
+		if dryRun := params.FieldByName("DryRun"); dryRun.IsValid() && dryRun.Kind() == reflect.Ptr && !dryRun.IsNil() {
+			return !dryRun.Elem().Bool()
+		}
+	}
+	return true
+}
+
+// decoded converts API shapes to generic values, which can be walked
+func decoded(shape interface{}) interface{} {
+	data, err := json.Marshal(shape)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+// redacted omits unset fields and masks sensitive ones
+func redacted(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if field == nil {
+				delete(value, key)
+			} else if functional.ContainsString(redactedFields, key) {
+				value[key] = "REDACTED"
+			} else {
+				value[key] = redacted(field)
+			}
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = redacted(element)
+		}
+	}
+	return value
+}
+
+// resourceIDs returns the IDs of resources in the response, e.g. InstanceId
+func resourceIDs(value interface{}) []string {
+	ids := map[string]bool{}
+	var walk func(key string, value interface{})
+	walk = func(key string, value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			for key, field := range value {
+				walk(key, field)
+			}
+		case []interface{}:
+			for _, element := range value {
+				walk(key, element)
+			}
+		case string:
+			if strings.HasSuffix(key, "Id") || strings.HasSuffix(key, "Ids") {
+				ids[value] = true
+			}
+		}
+	}
+	walk("", value)
+	result := []string{}
+	for id := range ids {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,91 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"flag"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	envutils "github.com/awslabs/karpenter/pkg/utils/env"
+)
+
+// VolumeOptions are the global defaults for the root volume of nodes whose
+// provisioner doesn't specify block device mappings
+type VolumeOptions struct {
+	VolumeType string
+	// VolumeSize in GiB
+	VolumeSize int
+	Encrypted  bool
+	KMSKeyID   string
+}
+
+var volumeOptions = VolumeOptions{}
+
+// familyRootDeviceNames are the devices of the default AMIs of each family to
+// which the global defaults apply. Bottlerocket's OS volume is read-only, so
+// the defaults apply to its data volume.
+var familyRootDeviceNames = map[string]string{
+	v1alpha1.AMIFamilyAL2:          "/dev/xvda",
+	v1alpha1.AMIFamilyBottlerocket: "/dev/xvdb",
+}
+
+func init() {
+	flag.StringVar(&volumeOptions.VolumeType, "aws-default-volume-type", envutils.WithDefaultString("AWS_DEFAULT_VOLUME_TYPE", ec2.VolumeTypeGp3), "The type of root volumes, if not specified by the provisioner")
+	flag.IntVar(&volumeOptions.VolumeSize, "aws-default-volume-size", envutils.WithDefaultInt("AWS_DEFAULT_VOLUME_SIZE", 20), "The size in GiB of root volumes, if not specified by the provisioner")
+	flag.BoolVar(&volumeOptions.Encrypted, "aws-default-volume-encrypted", envutils.WithDefaultBool("AWS_DEFAULT_VOLUME_ENCRYPTED", false), "Encrypt root volumes, if not specified by the provisioner")
+	flag.StringVar(&volumeOptions.KMSKeyID, "aws-default-volume-kms-key-id", envutils.WithDefaultString("AWS_DEFAULT_VOLUME_KMS_KEY_ID", ""), "The KMS key that encrypts root volumes, if not specified by the provisioner")
+}
+
+// blockDeviceMappings returns the provisioner's block device mappings, or the
+// AMI's root volume configured with the global defaults
+func blockDeviceMappings(constraints *v1alpha1.Constraints, rootDeviceName string) []*v1alpha1.BlockDeviceMapping {
+	if len(constraints.BlockDeviceMappings) != 0 {
+		return constraints.BlockDeviceMappings
+	}
+	device := &v1alpha1.BlockDevice{
+		VolumeType: aws.String(volumeOptions.VolumeType),
+		VolumeSize: aws.Int64(int64(volumeOptions.VolumeSize)),
+		Encrypted:  aws.Bool(volumeOptions.Encrypted || volumeOptions.KMSKeyID != ""),
+	}
+	if volumeOptions.KMSKeyID != "" {
+		device.KMSKeyID = aws.String(volumeOptions.KMSKeyID)
+	}
+	return []*v1alpha1.BlockDeviceMapping{{DeviceName: rootDeviceName, EBS: device}}
+}
+
+func blockDeviceMappingRequests(mappings []*v1alpha1.BlockDeviceMapping) []*ec2.LaunchTemplateBlockDeviceMappingRequest {
+	var requests []*ec2.LaunchTemplateBlockDeviceMappingRequest
+	for _, mapping := range mappings {
+		deleteOnTermination := mapping.EBS.DeleteOnTermination
+		if deleteOnTermination == nil {
+			deleteOnTermination = aws.Bool(true)
+		}
+		requests = append(requests, &ec2.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: aws.String(mapping.DeviceName),
+			Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType:          mapping.EBS.VolumeType,
+				VolumeSize:          mapping.EBS.VolumeSize,
+				Iops:                mapping.EBS.IOPS,
+				Throughput:          mapping.EBS.Throughput,
+				Encrypted:           mapping.EBS.Encrypted,
+				KmsKeyId:            mapping.EBS.KMSKeyID,
+				DeleteOnTermination: deleteOnTermination,
+			},
+		})
+	}
+	return requests
+}
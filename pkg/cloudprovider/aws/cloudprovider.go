@@ -16,6 +16,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -25,10 +26,17 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/awslabs/karpenter/pkg/utils/parallel"
 	"github.com/awslabs/karpenter/pkg/utils/project"
 	"go.uber.org/multierr"
@@ -61,14 +69,22 @@ const (
 
 type CloudProvider struct {
 	instanceTypeProvider *InstanceTypeProvider
+	amiProvider          *AMIProvider
 	instanceProvider     *InstanceProvider
+	permissionsProvider  *PermissionsProvider
+	interruptionProvider *InterruptionProvider
+	lifecycleSinks       []lifecycle.Sink
 	creationQueue        *parallel.WorkQueue
 }
 
 func NewCloudProvider(ctx context.Context, options cloudprovider.Options) *CloudProvider {
+	endpointResolver, err := endpointOptions.Resolver()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to configure AWS endpoints, %s", err.Error()))
+	}
 	sess := withUserAgent(session.Must(session.NewSession(
 		request.WithRetryer(
-			&aws.Config{STSRegionalEndpoint: endpoints.RegionalSTSEndpoint},
+			&aws.Config{STSRegionalEndpoint: endpoints.RegionalSTSEndpoint, EndpointResolver: endpointResolver},
 			client.DefaultRetryer{NumMaxRetries: client.DefaultRetryerMaxNumRetries},
 		),
 	)))
@@ -77,19 +93,38 @@ func NewCloudProvider(ctx context.Context, options cloudprovider.Options) *Cloud
 		*sess.Config.Region = getRegionFromIMDS(sess)
 	}
 	logging.FromContext(ctx).Debugf("Using AWS region %s", *sess.Config.Region)
+	if auditLogOptions.Enabled {
+		auditLogger, err := NewAuditLogger(ctx, auditLogOptions)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to configure AWS audit log, %s", err.Error()))
+		}
+		sess.Handlers.Complete.PushBackNamed(auditLogger.Handler())
+	}
 	ec2api := ec2.New(sess)
-	instanceTypeProvider := NewInstanceTypeProvider(ec2api)
+	ssmapi := ssm.New(sess)
+	sqsapi := sqs.New(sess)
+	pricingProvider := NewPricingProvider(pricing.New(sess, &aws.Config{Region: aws.String(pricingRegion(*sess.Config.Region))}), *sess.Config.Region)
+	instanceTypeProvider := NewInstanceTypeProvider(ec2api, pricingProvider)
+	amiProvider := NewAMIProvider(ssmapi, ec2api, options.ClientSet)
+	permissionsProvider := NewPermissionsProvider(ec2api, ssmapi, amiProvider)
+	permissionsProvider.Start(ctx)
 	return &CloudProvider{
 		instanceTypeProvider: instanceTypeProvider,
+		amiProvider:          amiProvider,
 		instanceProvider: &InstanceProvider{ec2api, instanceTypeProvider,
 			NewLaunchTemplateProvider(
 				ec2api,
-				NewAMIProvider(ssm.New(sess), options.ClientSet),
+				amiProvider,
 				NewSecurityGroupProvider(ec2api),
+				NewClusterProvider(eks.New(sess), options.ClientSet),
 			),
 			NewSubnetProvider(ec2api),
+			NewLaunchStatisticsProvider(),
 		},
-		creationQueue: parallel.NewWorkQueue(CreationQPS, CreationBurst),
+		permissionsProvider:  permissionsProvider,
+		interruptionProvider: NewInterruptionProvider(sqsapi, interruptionOptions.Queue),
+		lifecycleSinks:       NewLifecycleSinks(sns.New(sess), sqsapi, lifecycleOptions),
+		creationQueue:        parallel.NewWorkQueue(CreationQPS, CreationBurst),
 	}
 }
 
@@ -136,14 +171,110 @@ func (c *CloudProvider) create(ctx context.Context, constraints *v1alpha4.Constr
 	return err
 }
 
-func (c *CloudProvider) GetInstanceTypes(ctx context.Context) ([]cloudprovider.InstanceType, error) {
-	return c.instanceTypeProvider.Get(ctx)
+// ValidateLaunch vetoes launches that would otherwise fail when creating the
+// fleet, e.g. if none of the instance types are offered in the subnets' zones.
+func (c *CloudProvider) ValidateLaunch(ctx context.Context, constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) error {
+	vendorConstraints, err := v1alpha1.NewConstraints(constraints)
+	if err != nil {
+		return err
+	}
+	return c.instanceProvider.ValidateLaunch(ctx, vendorConstraints, instanceTypes)
+}
+
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, constraints *v1alpha4.Constraints) ([]cloudprovider.InstanceType, error) {
+	if constraints == nil || constraints.Provider == nil {
+		return c.instanceTypeProvider.Get(ctx, nil)
+	}
+	vendorConstraints, err := v1alpha1.NewConstraints(constraints)
+	if err != nil {
+		return nil, err
+	}
+	return c.instanceTypeProvider.Get(ctx, vendorConstraints)
 }
 
 func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
 	return c.instanceProvider.Terminate(ctx, node)
 }
 
+// ReadinessProbe reports an error until the controller's AWS permissions have
+// been checked
+func (c *CloudProvider) ReadinessProbe(req *http.Request) error {
+	if c.permissionsProvider == nil {
+		return nil
+	}
+	return c.permissionsProvider.ReadinessProbe(req)
+}
+
+// Settings are the AWS specific flags that the controller is running with
+type Settings struct {
+	AuditLog        AuditLogOptions
+	Volumes         VolumeOptions
+	ClusterEndpoint string
+	ClusterDNS      string
+	Endpoints       EndpointOptions
+	Interruption    InterruptionOptions
+	Lifecycle       LifecycleOptions
+}
+
+// Settings returns the AWS specific flags, so that they're included in the
+// controller's config hash
+func (c *CloudProvider) Settings() interface{} {
+	return Settings{
+		AuditLog:        auditLogOptions,
+		Volumes:         volumeOptions,
+		ClusterEndpoint: clusterEndpoint,
+		ClusterDNS:      clusterDNS,
+		Endpoints:       endpointOptions,
+		Interruption:    interruptionOptions,
+		Lifecycle:       lifecycleOptions,
+	}
+}
+
+// MissingPermissions returns the AWS permissions found missing by the last
+// periodic check
+func (c *CloudProvider) MissingPermissions() []string {
+	if c.permissionsProvider == nil {
+		return nil
+	}
+	return c.permissionsProvider.MissingPermissions()
+}
+
+// GetInterruptions blocks until interruption notices are received from the
+// configured queue, or the context is done
+func (c *CloudProvider) GetInterruptions(ctx context.Context) ([]*cloudprovider.Interruption, error) {
+	if c.interruptionProvider == nil {
+		<-ctx.Done()
+		return nil, nil
+	}
+	return c.interruptionProvider.Get(ctx)
+}
+
+// AcknowledgeInterruption deletes the interruption notice from the queue
+func (c *CloudProvider) AcknowledgeInterruption(ctx context.Context, interruption *cloudprovider.Interruption) error {
+	return c.interruptionProvider.Acknowledge(ctx, interruption)
+}
+
+// LaunchStatistics returns the recent launch outcomes of each instance type,
+// zone and capacity type
+func (c *CloudProvider) LaunchStatistics() []cloudprovider.LaunchStatistics {
+	return c.instanceProvider.launchStatistics.LaunchStatistics()
+}
+
+// ReplacedImage returns the ami the node was launched with, and the ami that
+// replaced it if it's no longer resolved
+func (c *CloudProvider) ReplacedImage(node *v1.Node) (string, string) {
+	ami := node.Annotations[v1alpha1.AMIIDAnnotationKey]
+	if replacement := c.amiProvider.Replacement(ami); replacement != "" {
+		return ami, replacement
+	}
+	return "", ""
+}
+
+// LifecycleSinks returns sinks for the configured SNS topic and SQS queue
+func (c *CloudProvider) LifecycleSinks() []lifecycle.Sink {
+	return c.lifecycleSinks
+}
+
 // Validate the constraints
 func (c *CloudProvider) Validate(ctx context.Context, constraints *v1alpha4.Constraints) *apis.FieldError {
 	vendorConstraints, err := v1alpha1.NewConstraints(constraints)
@@ -177,9 +308,46 @@ func (c *CloudProvider) Constrain(ctx context.Context, constraints *v1alpha4.Con
 	if err := vendorConstraints.Constrain(pods...); err != nil {
 		return err
 	}
+	if err := c.constrainInstanceTypes(ctx, constraints, pods...); err != nil {
+		return err
+	}
 	constraints.Provider.Raw, err = json.Marshal(vendorConstraints.AWS)
 	if err != nil {
 		return fmt.Errorf("failed to serialize provider, %w", err)
 	}
 	return nil
 }
+
+// constrainInstanceTypes narrows the constraints' instance types to those with
+// attributes (e.g. GPU model) that satisfy the pods' node affinity.
+func (c *CloudProvider) constrainInstanceTypes(ctx context.Context, constraints *v1alpha4.Constraints, pods ...*v1.Pod) error {
+	nodeAffinity := scheduling.NodeAffinityFor(pods...)
+	required := functional.IntersectStringSlice(nodeAffinity.GetLabels(), v1alpha1.InstanceTypeLabels)
+	if len(required) == 0 {
+		return nil
+	}
+	instanceTypes, err := c.instanceTypeProvider.Get(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("getting instance types, %w", err)
+	}
+	satisfying := []string{}
+	for _, instanceType := range instanceTypes {
+		if allowsLabels(nodeAffinity, instanceType.(*InstanceType).Labels(), required) {
+			satisfying = append(satisfying, instanceType.Name())
+		}
+	}
+	constraints.InstanceTypes = functional.IntersectStringSlice(constraints.InstanceTypes, satisfying)
+	if len(constraints.InstanceTypes) == 0 {
+		return fmt.Errorf("no instance types satisfy requirements for %v", required)
+	}
+	return nil
+}
+
+func allowsLabels(nodeAffinity scheduling.NodeAffinity, labels map[string]string, keys []string) bool {
+	for _, key := range keys {
+		if !nodeAffinity.AllowsLabel(labels, key) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	envutils "github.com/awslabs/karpenter/pkg/utils/env"
+	"github.com/patrickmn/go-cache"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/logging"
+)
+
+var clusterEndpoint string
+var clusterDNS string
+
+// clusterDNSService is the service that cluster DNS servers are discovered from
+var clusterDNSService = types.NamespacedName{Namespace: "kube-system", Name: "kube-dns"}
+
+func init() {
+	flag.StringVar(&clusterEndpoint, "aws-cluster-endpoint", envutils.WithDefaultString("AWS_CLUSTER_ENDPOINT", ""), "The API server endpoint nodes connect to, if not specified by the provisioner. If empty, it is discovered from EKS")
+	flag.StringVar(&clusterDNS, "aws-cluster-dns", envutils.WithDefaultString("AWS_CLUSTER_DNS", ""), "Comma separated IP addresses of the cluster DNS servers passed to the kubelet, if not specified by the provisioner. If empty, they are discovered from the kube-system/kube-dns service")
+}
+
+// ClusterInfo is how nodes connect to the cluster
+type ClusterInfo struct {
+	Endpoint string
+	// CABundle is base64 encoded, and only known if discovered from EKS
+	CABundle *string
+	// DNS is the cluster DNS servers, or empty if the bootstrap defaults apply
+	DNS []string
+}
+
+// dnsFor returns the cluster DNS servers for nodes launched with the
+// constraints, preferring the provisioner's kubelet configuration
+func (c *ClusterInfo) dnsFor(constraints *v1alpha1.Constraints) []string {
+	if clusterDNS := constraints.KubeletConfiguration.GetClusterDNS(); len(clusterDNS) > 0 {
+		return clusterDNS
+	}
+	return c.DNS
+}
+
+type ClusterProvider struct {
+	eksapi    eksiface.EKSAPI
+	clientSet kubernetes.Interface
+	cache     *cache.Cache
+}
+
+func NewClusterProvider(eksapi eksiface.EKSAPI, clientSet kubernetes.Interface) *ClusterProvider {
+	return &ClusterProvider{
+		eksapi:    eksapi,
+		clientSet: clientSet,
+		cache:     cache.New(CacheTTL, CacheCleanupInterval),
+	}
+}
+
+// Get returns how nodes connect to the cluster. The endpoint is, in order of
+// precedence, from the provisioner, the controller's configuration, or EKS.
+// The DNS servers are from the controller's configuration or the cluster's DNS
+// service, and may be overridden by the provisioner's kubelet configuration.
+func (p *ClusterProvider) Get(ctx context.Context, cluster v1alpha1.Cluster) (*ClusterInfo, error) {
+	info, err := p.getEndpoint(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	dns, err := p.getDNS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterInfo{Endpoint: info.Endpoint, CABundle: info.CABundle, DNS: dns}, nil
+}
+
+func (p *ClusterProvider) getEndpoint(ctx context.Context, cluster v1alpha1.Cluster) (*ClusterInfo, error) {
+	if cluster.Endpoint != "" {
+		return &ClusterInfo{Endpoint: cluster.Endpoint}, nil
+	}
+	if clusterEndpoint != "" {
+		return &ClusterInfo{Endpoint: clusterEndpoint}, nil
+	}
+	if info, ok := p.cache.Get(cluster.Name); ok {
+		return info.(*ClusterInfo), nil
+	}
+	output, err := p.eksapi.DescribeClusterWithContext(ctx, &eks.DescribeClusterInput{Name: aws.String(cluster.Name)})
+	if err != nil {
+		return nil, fmt.Errorf("describing cluster %s, %w", cluster.Name, err)
+	}
+	if aws.StringValue(output.Cluster.Endpoint) == "" {
+		return nil, fmt.Errorf("cluster %s has no endpoint", cluster.Name)
+	}
+	info := &ClusterInfo{Endpoint: aws.StringValue(output.Cluster.Endpoint)}
+	if output.Cluster.CertificateAuthority != nil && output.Cluster.CertificateAuthority.Data != nil {
+		info.CABundle = output.Cluster.CertificateAuthority.Data
+	}
+	p.cache.SetDefault(cluster.Name, info)
+	logging.FromContext(ctx).Debugf("Discovered endpoint %s for cluster %s", info.Endpoint, cluster.Name)
+	return info, nil
+}
+
+// getDNS returns the cluster DNS servers. Bootstrap scripts infer them from
+// the VPC's CIDR, which is wrong for clusters with a custom service CIDR or
+// node local DNS caches, so the cluster's DNS service is used instead.
+func (p *ClusterProvider) getDNS(ctx context.Context) ([]string, error) {
+	if clusterDNS != "" {
+		servers := strings.Split(clusterDNS, ",")
+		for _, server := range servers {
+			if net.ParseIP(server) == nil {
+				return nil, fmt.Errorf("parsing cluster dns %s, invalid ip address", server)
+			}
+		}
+		return servers, nil
+	}
+	if p.clientSet == nil {
+		return nil, nil
+	}
+	if servers, ok := p.cache.Get(clusterDNSService.String()); ok {
+		return servers.([]string), nil
+	}
+	service, err := p.clientSet.CoreV1().Services(clusterDNSService.Namespace).Get(ctx, clusterDNSService.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting service %s, %w", clusterDNSService, err)
+	}
+	var servers []string
+	if ip := net.ParseIP(service.Spec.ClusterIP); ip != nil {
+		servers = []string{ip.String()}
+	}
+	p.cache.SetDefault(clusterDNSService.String(), servers)
+	logging.FromContext(ctx).Debugf("Discovered cluster dns %s from service %s", strings.Join(servers, ","), clusterDNSService)
+	return servers, nil
+}
@@ -0,0 +1,127 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	envutils "github.com/awslabs/karpenter/pkg/utils/env"
+)
+
+// sqsEndpointsID is the endpoint ID of the SQS service
+const sqsEndpointsID = "sqs"
+
+// EndpointOptions configure how AWS service endpoints are resolved, e.g. for
+// alternative partitions (GovCloud, China), VPC endpoints, or FIPS endpoints.
+type EndpointOptions struct {
+	// Partition overrides the partition inferred from the region, which is
+	// required for regions unknown to the AWS SDK
+	Partition string
+	// Endpoints maps service endpoint IDs (e.g. ec2) to custom endpoint URLs
+	Endpoints map[string]string
+	// UseFIPSEndpoint resolves FIPS 140-2 validated endpoints
+	UseFIPSEndpoint bool
+}
+
+var endpointOptions = EndpointOptions{Endpoints: map[string]string{}}
+
+func init() {
+	flag.StringVar(&endpointOptions.Partition, "aws-partition", envutils.WithDefaultString("AWS_PARTITION", ""), "The AWS partition (e.g. aws-us-gov, aws-cn) to resolve endpoints in, defaults to the partition of the region")
+	flag.BoolVar(&endpointOptions.UseFIPSEndpoint, "aws-use-fips-endpoint", envutils.WithDefaultBool("AWS_USE_FIPS_ENDPOINT", false), "Use FIPS endpoints for AWS services")
+	for service, key := range map[string]string{ec2.EndpointsID: "EC2", ssm.EndpointsID: "SSM", sqsEndpointsID: "SQS"} {
+		flag.Var(endpointFlag{service: service, endpoints: endpointOptions.Endpoints},
+			fmt.Sprintf("aws-%s-endpoint", service),
+			fmt.Sprintf("A custom endpoint URL for %s, e.g. a VPC endpoint", key),
+		)
+		if value := envutils.WithDefaultString(fmt.Sprintf("AWS_%s_ENDPOINT", key), ""); value != "" {
+			endpointOptions.Endpoints[service] = value
+		}
+	}
+}
+
+// endpointFlag sets a custom endpoint for a service
+type endpointFlag struct {
+	service   string
+	endpoints map[string]string
+}
+
+func (f endpointFlag) String() string {
+	if f.endpoints == nil {
+		return ""
+	}
+	return f.endpoints[f.service]
+}
+
+func (f endpointFlag) Set(value string) error {
+	f.endpoints[f.service] = value
+	return nil
+}
+
+// Resolver returns an endpoint resolver that prefers custom endpoints, and
+// otherwise resolves endpoints in the configured partition.
+func (o EndpointOptions) Resolver() (endpoints.Resolver, error) {
+	var resolver endpoints.Resolver = endpoints.DefaultResolver()
+	if o.Partition != "" {
+		partition, ok := partitionFor(o.Partition)
+		if !ok {
+			return nil, fmt.Errorf("unknown partition %s", o.Partition)
+		}
+		resolver = partition
+	}
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		if endpoint, ok := o.Endpoints[service]; ok && endpoint != "" {
+			return endpoints.ResolvedEndpoint{URL: endpoint, SigningRegion: region}, nil
+		}
+		resolved, err := resolver.EndpointFor(service, region, opts...)
+		if err != nil {
+			return resolved, err
+		}
+		if o.UseFIPSEndpoint {
+			if resolved.URL, err = fipsEndpoint(service, resolved.URL); err != nil {
+				return resolved, err
+			}
+		}
+		return resolved, nil
+	}), nil
+}
+
+func partitionFor(id string) (endpoints.Partition, bool) {
+	for _, partition := range endpoints.DefaultPartitions() {
+		if partition.ID() == id {
+			return partition, true
+		}
+	}
+	return endpoints.Partition{}, false
+}
+
+// fipsEndpoint converts a service endpoint (e.g. https://ec2.us-east-1.amazonaws.com)
+// to its FIPS equivalent (e.g. https://ec2-fips.us-east-1.amazonaws.com).
+func fipsEndpoint(service string, endpoint string) (string, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("parsing endpoint %s, %w", endpoint, err)
+	}
+	if strings.HasPrefix(parsed.Host, service+"-fips.") || !strings.HasPrefix(parsed.Host, service+".") {
+		return endpoint, nil
+	}
+	parsed.Host = service + "-fips" + strings.TrimPrefix(parsed.Host, service)
+	return parsed.String(), nil
+}
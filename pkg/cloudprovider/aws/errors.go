@@ -15,7 +15,9 @@ package aws
 import (
 	"errors"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 )
 
@@ -25,6 +27,20 @@ var (
 		"InvalidInstanceID.NotFound",
 		"InvalidLaunchTemplateName.NotFoundException",
 	}
+	// This is not an exhaustive list, add to it as needed
+	unauthorizedErrorCodes = []string{
+		"UnauthorizedOperation",
+		"AccessDenied",
+		"AccessDeniedException",
+	}
+	// This is not an exhaustive list, add to it as needed
+	insufficientCapacityErrorCodes = []string{
+		"InsufficientInstanceCapacity",
+		"MaxSpotInstanceCountExceeded",
+		"UnfulfillableCapacity",
+	}
+	// dryRunErrorCode is returned if a dry run request would have succeeded
+	dryRunErrorCode = "DryRunOperation"
 )
 
 // isNotFound returns true if the err is an AWS error (even if it's
@@ -37,3 +53,34 @@ func isNotFound(err error) bool {
 	}
 	return false
 }
+
+// isInsufficientCapacity returns true if any of the fleet's errors mean that
+// EC2 didn't have enough capacity for the launch
+func isInsufficientCapacity(errors []*ec2.CreateFleetError) bool {
+	for _, err := range errors {
+		if functional.ContainsString(insufficientCapacityErrorCodes, aws.StringValue(err.ErrorCode)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnauthorized returns true if the err is an AWS error (even if it's
+// wrapped) and is known to mean the caller lacks permissions
+func isUnauthorized(err error) bool {
+	var awsError awserr.Error
+	if errors.As(err, &awsError) {
+		return functional.ContainsString(unauthorizedErrorCodes, awsError.Code())
+	}
+	return false
+}
+
+// isDryRun returns true if the err is an AWS error (even if it's wrapped)
+// indicating that a dry run request would have succeeded
+func isDryRun(err error) bool {
+	var awsError awserr.Error
+	if errors.As(err, &awsError) {
+		return awsError.Code() == dryRunErrorCode
+	}
+	return false
+}
@@ -40,10 +40,17 @@ type EC2Behavior struct {
 	DescribeInstanceTypesOutput         *ec2.DescribeInstanceTypesOutput
 	DescribeInstanceTypeOfferingsOutput *ec2.DescribeInstanceTypeOfferingsOutput
 	DescribeAvailabilityZonesOutput     *ec2.DescribeAvailabilityZonesOutput
+	DescribeImagesOutput                *ec2.DescribeImagesOutput
 	CalledWithCreateFleetInput          set.Set
 	CalledWithCreateLaunchTemplateInput set.Set
+	CalledWithDescribeSubnetsInput      set.Set
+	CalledWithDescribeImagesInput       set.Set
 	Instances                           sync.Map
 	LaunchTemplates                     sync.Map
+	// UnauthorizedOperations fail dry run requests with UnauthorizedOperation
+	UnauthorizedOperations set.Set
+	// InsufficientCapacityTypes fail fleets of these capacity types with InsufficientInstanceCapacity
+	InsufficientCapacityTypes set.Set
 }
 
 type EC2API struct {
@@ -57,19 +64,42 @@ func (e *EC2API) Reset() {
 	e.EC2Behavior = EC2Behavior{
 		CalledWithCreateFleetInput:          set.NewSet(),
 		CalledWithCreateLaunchTemplateInput: set.NewSet(),
+		CalledWithDescribeSubnetsInput:      set.NewSet(),
+		CalledWithDescribeImagesInput:       set.NewSet(),
+		UnauthorizedOperations:              set.NewSet(),
+		InsufficientCapacityTypes:           set.NewSet(),
 	}
 }
 
 func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFleetInput, _ ...request.Option) (*ec2.CreateFleetOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("CreateFleet")
+	}
 	e.CalledWithCreateFleetInput.Add(input)
 	if input.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName == nil {
 		return nil, fmt.Errorf("missing launch template name")
 	}
+	if e.InsufficientCapacityTypes.Contains(aws.StringValue(input.TargetCapacitySpecification.DefaultTargetCapacityType)) {
+		fleetErrors := []*ec2.CreateFleetError{}
+		for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+			for _, override := range launchTemplateConfig.Overrides {
+				fleetErrors = append(fleetErrors, &ec2.CreateFleetError{
+					ErrorCode:    aws.String("InsufficientInstanceCapacity"),
+					ErrorMessage: aws.String("There is no capacity available"),
+					LaunchTemplateAndOverrides: &ec2.LaunchTemplateAndOverridesResponse{
+						Overrides: &ec2.FleetLaunchTemplateOverrides{InstanceType: override.InstanceType, SubnetId: override.SubnetId},
+					},
+				})
+			}
+		}
+		return &ec2.CreateFleetOutput{Errors: fleetErrors}, nil
+	}
 	instances := []*ec2.Instance{}
 	instanceIds := []*string{}
 	for i := 0; i < int(*input.TargetCapacitySpecification.TotalTargetCapacity); i++ {
 		instances = append(instances, &ec2.Instance{
 			InstanceId:     aws.String(randomdata.SillyName()),
+			ImageId:        aws.String("test-ami-id"),
 			Placement:      &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
 			PrivateDnsName: aws.String(randomdata.IpV4Address()),
 			InstanceType:   input.LaunchTemplateConfigs[0].Overrides[0].InstanceType,
@@ -82,13 +112,29 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 }
 
 func (e *EC2API) CreateLaunchTemplateWithContext(_ context.Context, input *ec2.CreateLaunchTemplateInput, _ ...request.Option) (*ec2.CreateLaunchTemplateOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("CreateLaunchTemplate")
+	}
 	e.CalledWithCreateLaunchTemplateInput.Add(input)
 	launchTemplate := &ec2.LaunchTemplate{LaunchTemplateName: input.LaunchTemplateName}
 	e.LaunchTemplates.Store(input.LaunchTemplateName, launchTemplate)
 	return &ec2.CreateLaunchTemplateOutput{LaunchTemplate: launchTemplate}, nil
 }
 
+func (e *EC2API) TerminateInstancesWithContext(_ context.Context, input *ec2.TerminateInstancesInput, _ ...request.Option) (*ec2.TerminateInstancesOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("TerminateInstances")
+	}
+	for _, instanceID := range input.InstanceIds {
+		e.Instances.Delete(aws.StringValue(instanceID))
+	}
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
 func (e *EC2API) DescribeInstancesWithContext(_ context.Context, input *ec2.DescribeInstancesInput, _ ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("DescribeInstances")
+	}
 	if e.DescribeInstancesOutput != nil {
 		return e.DescribeInstancesOutput, nil
 	}
@@ -104,6 +150,9 @@ func (e *EC2API) DescribeInstancesWithContext(_ context.Context, input *ec2.Desc
 }
 
 func (e *EC2API) DescribeLaunchTemplatesWithContext(_ context.Context, input *ec2.DescribeLaunchTemplatesInput, _ ...request.Option) (*ec2.DescribeLaunchTemplatesOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("DescribeLaunchTemplates")
+	}
 	if e.DescribeLaunchTemplatesOutput != nil {
 		return e.DescribeLaunchTemplatesOutput, nil
 	}
@@ -121,7 +170,11 @@ func (e *EC2API) DescribeLaunchTemplatesWithContext(_ context.Context, input *ec
 	return output, nil
 }
 
-func (e *EC2API) DescribeSubnetsWithContext(context.Context, *ec2.DescribeSubnetsInput, ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+func (e *EC2API) DescribeSubnetsWithContext(_ context.Context, input *ec2.DescribeSubnetsInput, _ ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("DescribeSubnets")
+	}
+	e.CalledWithDescribeSubnetsInput.Add(input)
 	if e.DescribeSubnetsOutput != nil {
 		return e.DescribeSubnetsOutput, nil
 	}
@@ -135,7 +188,10 @@ func (e *EC2API) DescribeSubnetsWithContext(context.Context, *ec2.DescribeSubnet
 	}}, nil
 }
 
-func (e *EC2API) DescribeSecurityGroupsWithContext(context.Context, *ec2.DescribeSecurityGroupsInput, ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+func (e *EC2API) DescribeSecurityGroupsWithContext(_ context.Context, input *ec2.DescribeSecurityGroupsInput, _ ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("DescribeSecurityGroups")
+	}
 	if e.DescribeSecurityGroupsOutput != nil {
 		return e.DescribeSecurityGroupsOutput, nil
 	}
@@ -146,7 +202,25 @@ func (e *EC2API) DescribeSecurityGroupsWithContext(context.Context, *ec2.Describ
 	}}, nil
 }
 
-func (e *EC2API) DescribeAvailabilityZonesWithContext(context.Context, *ec2.DescribeAvailabilityZonesInput, ...request.Option) (*ec2.DescribeAvailabilityZonesOutput, error) {
+func (e *EC2API) DescribeImagesWithContext(_ context.Context, input *ec2.DescribeImagesInput, _ ...request.Option) (*ec2.DescribeImagesOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("DescribeImages")
+	}
+	e.CalledWithDescribeImagesInput.Add(input)
+	if e.DescribeImagesOutput != nil {
+		return e.DescribeImagesOutput, nil
+	}
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+		{ImageId: aws.String("test-ami-x86-old"), Architecture: aws.String("x86_64"), CreationDate: aws.String("2021-01-01T00:00:00.000Z")},
+		{ImageId: aws.String("test-ami-x86"), Architecture: aws.String("x86_64"), CreationDate: aws.String("2021-06-01T00:00:00.000Z")},
+		{ImageId: aws.String("test-ami-arm64"), Architecture: aws.String("arm64"), CreationDate: aws.String("2021-06-01T00:00:00.000Z")},
+	}}, nil
+}
+
+func (e *EC2API) DescribeAvailabilityZonesWithContext(_ context.Context, input *ec2.DescribeAvailabilityZonesInput, _ ...request.Option) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	if aws.BoolValue(input.DryRun) {
+		return nil, e.dryRun("DescribeAvailabilityZones")
+	}
 	if e.DescribeAvailabilityZonesOutput != nil {
 		return e.DescribeAvailabilityZonesOutput, nil
 	}
@@ -157,7 +231,10 @@ func (e *EC2API) DescribeAvailabilityZonesWithContext(context.Context, *ec2.Desc
 	}}, nil
 }
 
-func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2.DescribeInstanceTypesInput, fn func(*ec2.DescribeInstanceTypesOutput, bool) bool, _ ...request.Option) error {
+func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, input *ec2.DescribeInstanceTypesInput, fn func(*ec2.DescribeInstanceTypesOutput, bool) bool, _ ...request.Option) error {
+	if aws.BoolValue(input.DryRun) {
+		return e.dryRun("DescribeInstanceTypes")
+	}
 	if e.DescribeInstanceTypesOutput != nil {
 		fn(e.DescribeInstanceTypesOutput, false)
 		return nil
@@ -221,8 +298,10 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 				},
 				GpuInfo: &ec2.GpuInfo{
 					Gpus: []*ec2.GpuDeviceInfo{{
+						Name:         aws.String("V100"),
 						Manufacturer: aws.String("NVIDIA"),
 						Count:        aws.Int64(4),
+						MemoryInfo:   &ec2.GpuDeviceMemoryInfo{SizeInMiB: aws.Int64(16384)},
 					}},
 				},
 				NetworkInfo: &ec2.NetworkInfo{
@@ -280,7 +359,10 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 	return nil
 }
 
-func (e *EC2API) DescribeInstanceTypeOfferingsPagesWithContext(_ context.Context, _ *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool, _ ...request.Option) error {
+func (e *EC2API) DescribeInstanceTypeOfferingsPagesWithContext(_ context.Context, input *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool, _ ...request.Option) error {
+	if aws.BoolValue(input.DryRun) {
+		return e.dryRun("DescribeInstanceTypeOfferings")
+	}
 	if e.DescribeInstanceTypeOfferingsOutput != nil {
 		fn(e.DescribeInstanceTypeOfferingsOutput, false)
 		return nil
@@ -327,3 +409,11 @@ func (e *EC2API) DescribeInstanceTypeOfferingsPagesWithContext(_ context.Context
 	}, false)
 	return nil
 }
+
+// dryRun returns the error EC2 responds with to a dry run request for the operation
+func (e *EC2API) dryRun(operation string) error {
+	if e.UnauthorizedOperations != nil && e.UnauthorizedOperations.Contains(operation) {
+		return awserr.New("UnauthorizedOperation", fmt.Sprintf("not authorized to perform %s", operation), nil)
+	}
+	return awserr.New("DryRunOperation", "request would have succeeded", nil)
+}
@@ -0,0 +1,73 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+)
+
+// DefaultPrices are the on demand hourly prices of the fake instance types
+var DefaultPrices = map[string]float64{
+	"m5.large":     0.096,
+	"m5.xlarge":    0.192,
+	"p3.8xlarge":   12.24,
+	"c6g.large":    0.068,
+	"inf1.6xlarge": 1.18,
+}
+
+type PricingAPI struct {
+	pricingiface.PricingAPI
+	// Prices override the DefaultPrices, if set
+	Prices  map[string]float64
+	WantErr error
+}
+
+func (a *PricingAPI) GetProductsPagesWithContext(_ context.Context, _ *pricing.GetProductsInput, fn func(*pricing.GetProductsOutput, bool) bool, _ ...request.Option) error {
+	if a.WantErr != nil {
+		return a.WantErr
+	}
+	prices := a.Prices
+	if prices == nil {
+		prices = DefaultPrices
+	}
+	output := &pricing.GetProductsOutput{}
+	for instanceType, price := range prices {
+		output.PriceList = append(output.PriceList, aws.JSONValue{
+			"product": map[string]interface{}{
+				"attributes": map[string]interface{}{"instanceType": instanceType},
+			},
+			"terms": map[string]interface{}{
+				"OnDemand": map[string]interface{}{
+					"test-sku.test-term": map[string]interface{}{
+						"priceDimensions": map[string]interface{}{
+							"test-sku.test-term.test-dimension": map[string]interface{}{
+								"unit":         "Hrs",
+								"pricePerUnit": map[string]interface{}{"USD": fmt.Sprintf("%.10f", price)},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	fn(output, true)
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+type SQSAPI struct {
+	sqsiface.SQSAPI
+	// Messages are received once each, in order
+	Messages        []*sqs.Message
+	DeletedMessages []string
+	SentMessages    []*sqs.SendMessageInput
+	mu              sync.Mutex
+}
+
+func (a *SQSAPI) GetQueueUrlWithContext(_ context.Context, input *sqs.GetQueueUrlInput, _ ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String("https://sqs.test-region.amazonaws.com/123456789012/" + aws.StringValue(input.QueueName))}, nil
+}
+
+func (a *SQSAPI) ReceiveMessageWithContext(_ context.Context, input *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.Messages) == 0 {
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	count := int(aws.Int64Value(input.MaxNumberOfMessages))
+	if count > len(a.Messages) {
+		count = len(a.Messages)
+	}
+	messages := a.Messages[:count]
+	a.Messages = a.Messages[count:]
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (a *SQSAPI) DeleteMessageWithContext(_ context.Context, input *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.DeletedMessages = append(a.DeletedMessages, aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (a *SQSAPI) SendMessageWithContext(_ context.Context, input *sqs.SendMessageInput, _ ...request.Option) (*sqs.SendMessageOutput, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.SentMessages = append(a.SentMessages, input)
+	return &sqs.SendMessageOutput{}, nil
+}
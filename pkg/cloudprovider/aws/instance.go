@@ -33,6 +33,7 @@ import (
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
 )
 
 type InstanceProvider struct {
@@ -40,6 +41,7 @@ type InstanceProvider struct {
 	instanceTypeProvider   *InstanceTypeProvider
 	launchTemplateProvider *LaunchTemplateProvider
 	subnetProvider         *SubnetProvider
+	launchStatistics       *LaunchStatisticsProvider
 }
 
 // Create an instance given the constraints.
@@ -73,6 +75,7 @@ func (p *InstanceProvider) Create(ctx context.Context, constraints *v1alpha1.Con
 			aws.StringValue(instance.Placement.AvailabilityZone),
 			getCapacityType(instance),
 		)
+		p.launchStatistics.RecordSuccess(aws.StringValue(instance.InstanceType), aws.StringValue(instance.Placement.AvailabilityZone), getCapacityType(instance))
 
 		// Convert Instance to Node
 		node, err := p.instanceToNode(instance, instanceTypes)
@@ -104,21 +107,66 @@ func (p *InstanceProvider) Terminate(ctx context.Context, node *v1.Node) error {
 	return nil
 }
 
+// ValidateLaunch returns a LaunchError if none of the instance types are
+// offered in the zones of the subnets selected by the constraints.
+func (p *InstanceProvider) ValidateLaunch(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) error {
+	subnets, err := p.subnetProvider.Get(ctx, constraints)
+	if err != nil {
+		return fmt.Errorf("getting subnets, %w", err)
+	}
+	zones := []string{}
+	for _, subnet := range subnets {
+		zones = append(zones, aws.StringValue(subnet.AvailabilityZone))
+	}
+	names := []string{}
+	for _, instanceType := range instanceTypes {
+		if len(functional.IntersectStringSlice(instanceType.Zones(), zones)) > 0 {
+			return nil
+		}
+		names = append(names, instanceType.Name())
+	}
+	return cloudprovider.NewLaunchError(cloudprovider.InvalidCombination, "instance types %v are not offered in the zones %v of the selected subnets", names, functional.UniqueStrings(zones))
+}
+
 func (p *InstanceProvider) launchInstances(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) ([]*string, error) {
-	// Default to on-demand unless constrained otherwise. This code assumes two
-	// options: {spot, on-demand}, which is enforced by constraints.Constrain().
-	// Spot may be selected by constraining the provisioner, or using
-	// nodeSelectors, required node affinity, or preferred node affinity.
-	capacityType := v1alpha1.CapacityTypeOnDemand
-	if len(constraints.CapacityTypes) == 0 {
+	capacityTypes := getCapacityTypes(constraints)
+	if len(capacityTypes) == 0 {
 		return nil, fmt.Errorf("invariant violated, must contain at least one capacity type")
-	} else if len(constraints.CapacityTypes) == 1 {
-		capacityType = constraints.CapacityTypes[0]
 	}
+	instanceIds := []*string{}
+	for i, capacityType := range capacityTypes {
+		ids, fleetErrors, err := p.createFleet(ctx, constraints, instanceTypes, capacityType, quantity-len(instanceIds))
+		if err != nil && len(instanceIds) == 0 {
+			return nil, err
+		} else if err != nil {
+			logging.FromContext(ctx).Errorf("Failed to launch %d EC2 instances out of the %d EC2 instances requested: %s", quantity-len(instanceIds), quantity, err.Error())
+			break
+		}
+		instanceIds = append(instanceIds, ids...)
+		if len(instanceIds) == quantity {
+			break
+		}
+		// Retry the remaining instances with the next capacity type if EC2 didn't have enough of this one
+		if i < len(capacityTypes)-1 && isInsufficientCapacity(fleetErrors) {
+			logging.FromContext(ctx).Infof("Insufficient %s capacity for %d EC2 instances, falling back to %s: %s",
+				capacityType, quantity-len(instanceIds), capacityTypes[i+1], combineFleetErrors(fleetErrors).Error())
+			continue
+		}
+		if len(instanceIds) == 0 {
+			return nil, combineFleetErrors(fleetErrors)
+		}
+		logging.FromContext(ctx).Errorf("Failed to launch %d EC2 instances out of the %d EC2 instances requested: %s",
+			quantity-len(instanceIds), quantity, combineFleetErrors(fleetErrors).Error())
+		break
+	}
+	return instanceIds, nil
+}
+
+func (p *InstanceProvider) createFleet(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, capacityType string, quantity int) ([]*string, []*ec2.CreateFleetError, error) {
 	// Get Launch Template Configs, which may differ due to GPU or Architecture requirements
 	launchTemplateConfigs, err := p.getLaunchTemplateConfigs(ctx, constraints, instanceTypes, capacityType)
 	if err != nil {
-		return nil, fmt.Errorf("getting launch template configs, %w", err)
+		return nil, nil, fmt.Errorf("getting launch template configs, %w", err)
 	}
 	// Create fleet
 	createFleetOutput, err := p.ec2api.CreateFleetWithContext(ctx, &ec2.CreateFleetInput{
@@ -134,16 +182,58 @@ func (p *InstanceProvider) launchInstances(ctx context.Context, constraints *v1a
 		SpotOptions: &ec2.SpotOptionsRequest{AllocationStrategy: aws.String(ec2.SpotAllocationStrategyCapacityOptimizedPrioritized)},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("creating fleet %w", err)
+		return nil, nil, fmt.Errorf("creating fleet %w", err)
 	}
-	instanceIds := combineFleetInstances(*createFleetOutput)
-	if len(instanceIds) == 0 {
-		return nil, combineFleetErrors(createFleetOutput.Errors)
-	} else if len(instanceIds) != quantity {
-		logging.FromContext(ctx).Errorf("Failed to launch %d EC2 instances out of the %d EC2 instances requested: %s",
-			quantity-len(instanceIds), quantity, combineFleetErrors(createFleetOutput.Errors).Error())
+	p.recordFleetErrors(ctx, constraints, capacityType, createFleetOutput.Errors)
+	return combineFleetInstances(*createFleetOutput), createFleetOutput.Errors, nil
+}
+
+// recordFleetErrors records a failed launch for each of the fleet's errors
+// that identifies an instance type and zone
+func (p *InstanceProvider) recordFleetErrors(ctx context.Context, constraints *v1alpha1.Constraints, capacityType string, fleetErrors []*ec2.CreateFleetError) {
+	if len(fleetErrors) == 0 {
+		return
 	}
-	return instanceIds, nil
+	zones := map[string]string{}
+	subnets, err := p.subnetProvider.Get(ctx, constraints)
+	if err != nil {
+		logging.FromContext(ctx).Debugf("Failed to record launch failures, getting subnets, %s", err.Error())
+	}
+	for _, subnet := range subnets {
+		zones[aws.StringValue(subnet.SubnetId)] = aws.StringValue(subnet.AvailabilityZone)
+	}
+	for _, fleetError := range fleetErrors {
+		if fleetError.LaunchTemplateAndOverrides == nil || fleetError.LaunchTemplateAndOverrides.Overrides == nil {
+			continue
+		}
+		overrides := fleetError.LaunchTemplateAndOverrides.Overrides
+		zone := aws.StringValue(overrides.AvailabilityZone)
+		if zone == "" {
+			zone = zones[aws.StringValue(overrides.SubnetId)]
+		}
+		if overrides.InstanceType == nil || zone == "" {
+			continue
+		}
+		p.launchStatistics.RecordFailure(aws.StringValue(overrides.InstanceType), zone, capacityType, isInsufficientCapacity([]*ec2.CreateFleetError{fleetError}))
+	}
+}
+
+// getCapacityTypes returns the capacity types to launch, in order of
+// preference. This code assumes two options: {spot, on-demand}, which is
+// enforced by constraints.Constrain(). Spot may be selected by constraining the
+// provisioner, or using nodeSelectors, required node affinity, or preferred
+// node affinity. If flexible to both, launches follow the capacity type
+// fallback, or default to on-demand.
+func getCapacityTypes(constraints *v1alpha1.Constraints) []string {
+	if len(constraints.CapacityTypes) <= 1 {
+		return constraints.CapacityTypes
+	}
+	if len(constraints.CapacityTypeFallback) > 0 {
+		if capacityTypes := functional.IntersectStringSlice(constraints.CapacityTypes, constraints.CapacityTypeFallback); len(capacityTypes) > 0 {
+			return capacityTypes
+		}
+	}
+	return []string{v1alpha1.CapacityTypeOnDemand}
 }
 
 func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, capacityType string) ([]*ec2.FleetLaunchTemplateConfigRequest, error) {
@@ -153,6 +243,8 @@ func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, constra
 		return nil, fmt.Errorf("getting subnets, %w", err)
 	}
 
+	// Choose one subnet per zone for all of the launch templates
+	subnetsByZone := zonalSubnets(constraints, subnets)
 	additionalLabels := map[string]string{v1alpha1.CapacityTypeLabel: capacityType}
 	var launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest
 	launchTemplates, err := p.launchTemplateProvider.Get(ctx, constraints, instanceTypes, additionalLabels)
@@ -161,7 +253,7 @@ func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, constra
 	}
 	for launchTemplateName, instanceTypes := range launchTemplates {
 		launchTemplateConfigs = append(launchTemplateConfigs, &ec2.FleetLaunchTemplateConfigRequest{
-			Overrides: p.getOverrides(instanceTypes, subnets, capacityType),
+			Overrides: p.getOverrides(instanceTypes, subnetsByZone, capacityType),
 			LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
 				LaunchTemplateName: aws.String(launchTemplateName),
 				Version:            aws.String("$Default"),
@@ -171,27 +263,38 @@ func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, constra
 	return launchTemplateConfigs, nil
 }
 
-func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.InstanceType, subnets []*ec2.Subnet, capacityType string) []*ec2.FleetLaunchTemplateOverridesRequest {
+// getOverrides returns an override for each instance type in each zone with a
+// subnet. Offerings that recently lacked capacity are skipped, unless every
+// offering did.
+func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.InstanceType, subnetsByZone map[string]*ec2.Subnet, capacityType string) []*ec2.FleetLaunchTemplateOverridesRequest {
+	if overrides := p.overridesFor(instanceTypeOptions, subnetsByZone, capacityType, true); len(overrides) > 0 {
+		return overrides
+	}
+	return p.overridesFor(instanceTypeOptions, subnetsByZone, capacityType, false)
+}
+
+func (p *InstanceProvider) overridesFor(instanceTypeOptions []cloudprovider.InstanceType, subnetsByZone map[string]*ec2.Subnet, capacityType string, skipUnavailable bool) []*ec2.FleetLaunchTemplateOverridesRequest {
 	var overrides []*ec2.FleetLaunchTemplateOverridesRequest
 	for i, instanceType := range instanceTypeOptions {
 		for _, zone := range instanceType.Zones() {
-			for _, subnet := range subnets {
-				if aws.StringValue(subnet.AvailabilityZone) == zone {
-					override := &ec2.FleetLaunchTemplateOverridesRequest{
-						InstanceType: aws.String(instanceType.Name()),
-						SubnetId:     subnet.SubnetId,
-					}
-					// Add a priority for spot requests since we are using the capacity-optimized-prioritized spot allocation strategy
-					// to reduce the likelihood of getting an excessively large instance type.
-					// instanceTypeOptions are sorted by vcpus and memory so this prioritizes smaller instance types.
-					if capacityType == v1alpha1.CapacityTypeSpot {
-						override.Priority = aws.Float64(float64(i))
-					}
-					overrides = append(overrides, override)
-					// FleetAPI cannot span subnets from the same AZ, so break after the first one.
-					break
-				}
+			subnet, ok := subnetsByZone[zone]
+			if !ok {
+				continue
+			}
+			if skipUnavailable && p.launchStatistics.IsUnavailable(instanceType.Name(), zone, capacityType) {
+				continue
+			}
+			override := &ec2.FleetLaunchTemplateOverridesRequest{
+				InstanceType: aws.String(instanceType.Name()),
+				SubnetId:     subnet.SubnetId,
 			}
+			// Add a priority for spot requests since we are using the capacity-optimized-prioritized spot allocation strategy
+			// to reduce the likelihood of getting an excessively large instance type.
+			// instanceTypeOptions are sorted by vcpus and memory so this prioritizes smaller instance types.
+			if capacityType == v1alpha1.CapacityTypeSpot {
+				override.Priority = aws.Float64(float64(i))
+			}
+			overrides = append(overrides, override)
 		}
 	}
 	return overrides
@@ -223,12 +326,15 @@ func (p *InstanceProvider) getInstances(ctx context.Context, ids []*string) ([]*
 func (p *InstanceProvider) instanceToNode(instance *ec2.Instance, instanceTypes []cloudprovider.InstanceType) (*v1.Node, error) {
 	for _, instanceType := range instanceTypes {
 		if instanceType.Name() == aws.StringValue(instance.InstanceType) {
+			labels := map[string]string{v1alpha1.CapacityTypeLabel: getCapacityType(instance)}
+			if awsInstanceType, ok := instanceType.(*InstanceType); ok {
+				labels = functional.UnionStringMaps(awsInstanceType.Labels(), labels)
+			}
 			return &v1.Node{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: aws.StringValue(instance.PrivateDnsName),
-					Labels: map[string]string{
-						v1alpha1.CapacityTypeLabel: getCapacityType(instance),
-					},
+					Name:        aws.StringValue(instance.PrivateDnsName),
+					Labels:      labels,
+					Annotations: map[string]string{v1alpha1.AMIIDAnnotationKey: aws.StringValue(instance.ImageId)},
 				},
 				Spec: v1.NodeSpec{
 					ProviderID: fmt.Sprintf("aws:///%s/%s", aws.StringValue(instance.Placement.AvailabilityZone), aws.StringValue(instance.InstanceId)),
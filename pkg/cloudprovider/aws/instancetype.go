@@ -16,14 +16,18 @@ package aws
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/awslabs/karpenter/pkg/utils/resources"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/ptr"
 )
 
 // EC2VMAvailableMemoryFactor assumes the EC2 VM will consume <7.25% of the memory of a given machine
@@ -32,6 +36,19 @@ const EC2VMAvailableMemoryFactor = .925
 type InstanceType struct {
 	ec2.InstanceTypeInfo
 	ZoneOptions []string
+	// CapacityTypes limit the offerings to the capacity types that are
+	// launched, if set
+	CapacityTypes []string
+	// Price is the on demand hourly price, or zero if unknown
+	Price float64
+	// MaxPods overrides the ENI limited pod density, if set
+	MaxPods *int64
+	// SystemReserved and KubeReserved override the default overhead for
+	// the specified resources, if set
+	SystemReserved v1.ResourceList
+	KubeReserved   v1.ResourceList
+	// EvictionHard overrides the default eviction thresholds, if set
+	EvictionHard map[string]string
 }
 
 func (i *InstanceType) Name() string {
@@ -41,6 +58,25 @@ func (i *InstanceType) Zones() []string {
 	return i.ZoneOptions
 }
 
+// Offerings returns the capacity types supported by the instance type in each
+// of its zones
+func (i *InstanceType) Offerings() []cloudprovider.Offering {
+	offerings := []cloudprovider.Offering{}
+	for _, zone := range i.ZoneOptions {
+		for _, capacityType := range aws.StringValueSlice(i.SupportedUsageClasses) {
+			if len(i.CapacityTypes) == 0 || functional.ContainsString(i.CapacityTypes, capacityType) {
+				offerings = append(offerings, cloudprovider.Offering{Zone: zone, CapacityType: capacityType})
+			}
+		}
+	}
+	return offerings
+}
+
+// HourlyPrice returns the on demand hourly price, or zero if unknown
+func (i *InstanceType) HourlyPrice() float64 {
+	return i.Price
+}
+
 func (i *InstanceType) Architecture() string {
 	for _, architecture := range i.ProcessorInfo.SupportedArchitectures {
 		if value, ok := v1alpha1.AWSToKubeArchitectures[aws.StringValue(architecture)]; ok {
@@ -67,10 +103,34 @@ func (i *InstanceType) Memory() *resource.Quantity {
 }
 
 func (i *InstanceType) Pods() *resource.Quantity {
-	// The number of pods per node is calculated using the formula:
-	// max number of ENIs * (IPv4 Addresses per ENI -1) + 2
-	// https://github.com/awslabs/amazon-eks-ami/blob/master/files/eni-max-pods.txt#L20
-	return resources.Quantity(fmt.Sprint(*i.NetworkInfo.MaximumNetworkInterfaces*(*i.NetworkInfo.Ipv4AddressesPerInterface-1) + 2))
+	if i.MaxPods != nil {
+		return resources.Quantity(fmt.Sprint(*i.MaxPods))
+	}
+	return resources.Quantity(fmt.Sprint(i.eniLimitedPods()))
+}
+
+// eniLimitedPods is the number of pods that can be assigned VPC IP addresses
+// by the VPC CNI, calculated using the formula:
+// max number of ENIs * (IPv4 Addresses per ENI -1) + 2
+// https://github.com/awslabs/amazon-eks-ami/blob/master/files/eni-max-pods.txt#L20
+func (i *InstanceType) eniLimitedPods() int64 {
+	return *i.NetworkInfo.MaximumNetworkInterfaces*(*i.NetworkInfo.Ipv4AddressesPerInterface-1) + 2
+}
+
+// maxPods returns the pod density of the instance type given the constraints,
+// or nil if the ENI limited default applies. Mirrors the kubelet, which
+// enforces the lesser of --max-pods and --pods-per-core * cores.
+func maxPods(constraints *v1alpha1.Constraints, instanceType *InstanceType) *int64 {
+	pods := constraints.KubeletMaxPods()
+	if constraints.PodsPerCore != nil {
+		if pods == nil {
+			pods = ptr.Int64(instanceType.eniLimitedPods())
+		}
+		if perCore := int64(*constraints.PodsPerCore) * instanceType.CPU().Value(); perCore < *pods {
+			pods = ptr.Int64(perCore)
+		}
+	}
+	return pods
 }
 
 func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
@@ -97,6 +157,45 @@ func (i *InstanceType) AMDGPUs() *resource.Quantity {
 	return resources.Quantity(fmt.Sprint(count))
 }
 
+// GPUModel returns the manufacturer and model of the instance type's GPUs,
+// e.g. nvidia-v100, or "" if it has none.
+func (i *InstanceType) GPUModel() string {
+	if i.GpuInfo == nil || len(i.GpuInfo.Gpus) == 0 {
+		return ""
+	}
+	gpu := i.GpuInfo.Gpus[0]
+	return strings.ToLower(fmt.Sprintf("%s-%s", aws.StringValue(gpu.Manufacturer), aws.StringValue(gpu.Name)))
+}
+
+// GPUMemory returns the memory of each of the instance type's GPUs in MiB,
+// or "" if it has none.
+func (i *InstanceType) GPUMemory() string {
+	if i.GpuInfo == nil || len(i.GpuInfo.Gpus) == 0 || i.GpuInfo.Gpus[0].MemoryInfo == nil {
+		return ""
+	}
+	return fmt.Sprint(aws.Int64Value(i.GpuInfo.Gpus[0].MemoryInfo.SizeInMiB))
+}
+
+// Family returns the instance type's family, e.g. m5 for m5.large
+func (i *InstanceType) Family() string {
+	return strings.Split(i.Name(), ".")[0]
+}
+
+// Labels returns the well known labels derived from the instance type's attributes
+func (i *InstanceType) Labels() map[string]string {
+	labels := map[string]string{}
+	for label, value := range map[string]string{
+		v1alpha1.GPUModelLabel:       i.GPUModel(),
+		v1alpha1.GPUMemoryLabel:      i.GPUMemory(),
+		v1alpha1.InstanceFamilyLabel: i.Family(),
+	} {
+		if value != "" {
+			labels[label] = value
+		}
+	}
+	return labels
+}
+
 func (i *InstanceType) AWSNeurons() *resource.Quantity {
 	count := int64(0)
 	if i.InferenceAcceleratorInfo != nil {
@@ -107,22 +206,49 @@ func (i *InstanceType) AWSNeurons() *resource.Quantity {
 	return resources.Quantity(fmt.Sprint(count))
 }
 
+func (i *InstanceType) Resources() v1.ResourceList {
+	return resources.NonZero(v1.ResourceList{
+		v1.ResourceCPU:      *i.CPU(),
+		v1.ResourceMemory:   *i.Memory(),
+		v1.ResourcePods:     *i.Pods(),
+		resources.NvidiaGPU: *i.NvidiaGPUs(),
+		resources.AMDGPU:    *i.AMDGPUs(),
+		resources.AWSNeuron: *i.AWSNeurons(),
+	})
+}
+
 // Overhead computes overhead for https://kubernetes.io/docs/tasks/administer-cluster/reserve-compute-resources/#node-allocatable
 // using calculations copied from https://github.com/bottlerocket-os/bottlerocket#kubernetes-settings
 func (i *InstanceType) Overhead() v1.ResourceList {
-	overhead := v1.ResourceList{
-		v1.ResourceCPU: *resource.NewMilliQuantity(
-			100, // system-reserved
-			resource.DecimalSI),
-		v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dMi",
-			// kube-reserved
-			((11*i.Pods().Value())+255)+
-				// system-reserved
-				100+
-				// eviction threshold https://github.com/kubernetes/kubernetes/blob/ea0764452222146c47ec826977f49d7001b0ea8c/pkg/kubelet/apis/config/v1beta1/defaults_linux.go#L23
-				100,
-		)),
+	return resources.Merge(
+		i.systemReserved(),
+		i.kubeReserved(),
+		i.evictionThreshold(),
+	)
+}
+
+// evictionThreshold reserves the memory.available threshold, defaulting to the
+// kubelet's https://github.com/kubernetes/kubernetes/blob/ea0764452222146c47ec826977f49d7001b0ea8c/pkg/kubelet/apis/config/v1beta1/defaults_linux.go#L23
+func (i *InstanceType) evictionThreshold() v1.ResourceList {
+	threshold := resource.MustParse("100Mi")
+	if value, ok := i.EvictionHard["memory.available"]; ok {
+		// Thresholds are validated by the webhook
+		if parsed, err := v1alpha4.ParseEvictionThreshold(value, *i.Memory()); err == nil {
+			threshold = parsed
+		}
 	}
+	return v1.ResourceList{v1.ResourceMemory: threshold}
+}
+
+func (i *InstanceType) systemReserved() v1.ResourceList {
+	return withOverrides(v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("100Mi"),
+	}, i.SystemReserved)
+}
+
+func (i *InstanceType) kubeReserved() v1.ResourceList {
+	reservedCPU := resource.NewMilliQuantity(0, resource.DecimalSI)
 	// kube-reserved Computed from
 	// https://github.com/bottlerocket-os/bottlerocket/pull/1388/files#diff-bba9e4e3e46203be2b12f22e0d654ebd270f0b478dd34f40c31d7aa695620f2fR611
 	for _, cpuRange := range []struct {
@@ -140,8 +266,20 @@ func (i *InstanceType) Overhead() v1.ResourceList {
 			if cpu < cpuRange.end {
 				r = float64(cpu - cpuRange.start)
 			}
-			overhead.Cpu().Add(*resource.NewMilliQuantity(int64(r*cpuRange.percentage), resource.DecimalSI))
+			reservedCPU.Add(*resource.NewMilliQuantity(int64(r*cpuRange.percentage), resource.DecimalSI))
 		}
 	}
-	return overhead
+	return withOverrides(v1.ResourceList{
+		v1.ResourceCPU:    *reservedCPU,
+		v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dMi", (11*i.Pods().Value())+255)),
+	}, i.KubeReserved)
+}
+
+// withOverrides replaces the defaults with any resources that are overridden,
+// matching the kubelet, which only reserves the resources it's passed.
+func withOverrides(defaults v1.ResourceList, overrides v1.ResourceList) v1.ResourceList {
+	for name, quantity := range overrides {
+		defaults[name] = quantity
+	}
+	return defaults
 }
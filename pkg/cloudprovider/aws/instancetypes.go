@@ -22,6 +22,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/patrickmn/go-cache"
 	"knative.dev/pkg/logging"
@@ -32,22 +33,26 @@ const (
 )
 
 type InstanceTypeProvider struct {
-	ec2api ec2iface.EC2API
-	cache  *cache.Cache
+	ec2api          ec2iface.EC2API
+	pricingProvider *PricingProvider
+	cache           *cache.Cache
 }
 
-func NewInstanceTypeProvider(ec2api ec2iface.EC2API) *InstanceTypeProvider {
+func NewInstanceTypeProvider(ec2api ec2iface.EC2API, pricingProvider *PricingProvider) *InstanceTypeProvider {
 	return &InstanceTypeProvider{
-		ec2api: ec2api,
-		cache:  cache.New(CacheTTL, CacheCleanupInterval),
+		ec2api:          ec2api,
+		pricingProvider: pricingProvider,
+		cache:           cache.New(CacheTTL, CacheCleanupInterval),
 	}
 }
 
-// Get all instance types that are available per availability zone
-func (p *InstanceTypeProvider) Get(ctx context.Context) ([]cloudprovider.InstanceType, error) {
-	var instanceTypes []cloudprovider.InstanceType
+// Get all instance types that are available per availability zone. If
+// constraints are provided, they are used to compute each instance type's pod
+// density and reserved resources.
+func (p *InstanceTypeProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints) ([]cloudprovider.InstanceType, error) {
+	var instanceTypes []*InstanceType
 	if cached, ok := p.cache.Get(allInstanceTypesKey); ok {
-		instanceTypes = cached.([]cloudprovider.InstanceType)
+		instanceTypes = cached.([]*InstanceType)
 	} else {
 		var err error
 		instanceTypes, err = p.get(ctx)
@@ -57,10 +62,23 @@ func (p *InstanceTypeProvider) Get(ctx context.Context) ([]cloudprovider.Instanc
 		p.cache.SetDefault(allInstanceTypesKey, instanceTypes)
 		logging.FromContext(ctx).Debugf("Discovered %d EC2 instance types", len(instanceTypes))
 	}
-	return instanceTypes, nil
+	// convert to cloudprovider.InstanceType, copying to avoid mutating the cache
+	result := []cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		instanceType := *instanceType
+		if constraints != nil {
+			instanceType.MaxPods = maxPods(constraints, &instanceType)
+			instanceType.CapacityTypes = constraints.CapacityTypes
+			instanceType.SystemReserved = constraints.KubeletConfiguration.GetSystemReserved()
+			instanceType.KubeReserved = constraints.KubeletConfiguration.GetKubeReserved()
+			instanceType.EvictionHard = constraints.KubeletConfiguration.GetEvictionHard()
+		}
+		result = append(result, &instanceType)
+	}
+	return result, nil
 }
 
-func (p *InstanceTypeProvider) get(ctx context.Context) ([]cloudprovider.InstanceType, error) {
+func (p *InstanceTypeProvider) get(ctx context.Context) ([]*InstanceType, error) {
 	// 1. Get InstanceTypes from EC2
 	instanceTypes, err := p.getInstanceTypes(ctx)
 	if err != nil {
@@ -82,13 +100,16 @@ func (p *InstanceTypeProvider) get(ctx context.Context) ([]cloudprovider.Instanc
 	if err != nil {
 		return nil, fmt.Errorf("describing instance type zone offerings, %w", err)
 	}
-
-	// convert to cloudprovider.InstanceType
-	result := []cloudprovider.InstanceType{}
+	// Prices are only used to estimate and compare costs, so instance types are
+	// left unpriced rather than failing if they can't be discovered
+	prices, err := p.pricingProvider.Get(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to discover instance type prices, %s", err.Error())
+	}
 	for _, instanceType := range instanceTypes {
-		result = append(result, instanceType)
+		instanceType.Price = prices[instanceType.Name()]
 	}
-	return result, nil
+	return instanceTypes, nil
 }
 
 // getInstanceTypes retrieves all instance types from the ec2 DescribeInstanceTypes API using some opinionated filters
@@ -0,0 +1,176 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	envutils "github.com/awslabs/karpenter/pkg/utils/env"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// interruptionWaitSeconds is how long each request for interruption
+	// notices waits for messages to arrive, the maximum that SQS supports
+	interruptionWaitSeconds = 20
+	// interruptionBatchSize is the maximum number of messages received at once
+	interruptionBatchSize = 10
+)
+
+// InterruptionOptions configure the queue that interruption notices are
+// received from
+type InterruptionOptions struct {
+	// Queue is the name or URL of an SQS queue that EventBridge delivers EC2
+	// spot interruption warnings, rebalance recommendations and instance state
+	// change notifications to
+	Queue string
+}
+
+var interruptionOptions = InterruptionOptions{}
+
+func init() {
+	flag.StringVar(&interruptionOptions.Queue, "aws-interruption-queue", envutils.WithDefaultString("AWS_INTERRUPTION_QUEUE", ""), "The name or URL of an SQS queue to receive EC2 interruption events from, disabled if empty")
+}
+
+var (
+	// stoppingStates are the instance states of state change notifications
+	// that interrupt the instance's node
+	stoppingStates = []string{ec2InstanceStateStopping, ec2InstanceStateStopped, ec2InstanceStateShuttingDown, ec2InstanceStateTerminated}
+)
+
+const (
+	ec2InstanceStateStopping     = "stopping"
+	ec2InstanceStateStopped      = "stopped"
+	ec2InstanceStateShuttingDown = "shutting-down"
+	ec2InstanceStateTerminated   = "terminated"
+
+	spotInterruptionDetailType        = "EC2 Spot Instance Interruption Warning"
+	rebalanceRecommendationDetailType = "EC2 Instance Rebalance Recommendation"
+	stateChangeDetailType             = "EC2 Instance State-change Notification"
+)
+
+// event is an EventBridge event for an EC2 instance
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/spot-instance-termination-notices.html
+type event struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Detail     struct {
+		InstanceID string `json:"instance-id"`
+		State      string `json:"state"`
+	} `json:"detail"`
+}
+
+// InterruptionProvider receives interruption notices from an SQS queue
+type InterruptionProvider struct {
+	sqsapi sqsiface.SQSAPI
+	queue  *sqsQueue
+}
+
+func NewInterruptionProvider(sqsapi sqsiface.SQSAPI, queue string) *InterruptionProvider {
+	return &InterruptionProvider{sqsapi: sqsapi, queue: newSQSQueue(sqsapi, queue)}
+}
+
+// Get blocks until interruption notices are received. If no queue is
+// configured, it blocks until the context is done. Messages that aren't
+// interruption notices are deleted from the queue.
+func (p *InterruptionProvider) Get(ctx context.Context) ([]*cloudprovider.Interruption, error) {
+	if p.queue.name == "" {
+		<-ctx.Done()
+		return nil, nil
+	}
+	queueURL, err := p.queue.URL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for ctx.Err() == nil {
+		output, err := p.sqsapi.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(interruptionBatchSize),
+			WaitTimeSeconds:     aws.Int64(interruptionWaitSeconds),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("receiving messages from %s, %w", p.queue.name, err)
+		}
+		interruptions := []*cloudprovider.Interruption{}
+		for _, message := range output.Messages {
+			if interruption, ok := interruptionFor(message); ok {
+				interruptions = append(interruptions, interruption)
+				continue
+			}
+			logging.FromContext(ctx).Debugf("Ignoring message %s, not an interruption notice", aws.StringValue(message.MessageId))
+			if err := p.delete(ctx, queueURL, aws.StringValue(message.ReceiptHandle)); err != nil {
+				return nil, err
+			}
+		}
+		if len(interruptions) != 0 {
+			return interruptions, nil
+		}
+	}
+	return nil, nil
+}
+
+// Acknowledge deletes the interruption notice from the queue
+func (p *InterruptionProvider) Acknowledge(ctx context.Context, interruption *cloudprovider.Interruption) error {
+	queueURL, err := p.queue.URL(ctx)
+	if err != nil {
+		return err
+	}
+	return p.delete(ctx, queueURL, interruption.Handle)
+}
+
+func (p *InterruptionProvider) delete(ctx context.Context, queueURL string, receiptHandle string) error {
+	if _, err := p.sqsapi.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	}); err != nil {
+		return fmt.Errorf("deleting message from %s, %w", p.queue.name, err)
+	}
+	return nil
+}
+
+// interruptionFor parses the interruption notice from an EventBridge event,
+// or returns false if the message isn't one
+func interruptionFor(message *sqs.Message) (*cloudprovider.Interruption, bool) {
+	event := &event{}
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), event); err != nil {
+		return nil, false
+	}
+	if event.Source != "aws.ec2" || event.Detail.InstanceID == "" {
+		return nil, false
+	}
+	interruption := &cloudprovider.Interruption{InstanceID: event.Detail.InstanceID, Handle: aws.StringValue(message.ReceiptHandle)}
+	switch event.DetailType {
+	case spotInterruptionDetailType:
+		interruption.Reason = cloudprovider.SpotInterruption
+	case rebalanceRecommendationDetailType:
+		interruption.Reason = cloudprovider.RebalanceRecommendation
+	case stateChangeDetailType:
+		if !functional.ContainsString(stoppingStates, event.Detail.State) {
+			return nil, false
+		}
+		interruption.Reason = cloudprovider.InstanceStopping
+	default:
+		return nil, false
+	}
+	return interruption, true
+}
@@ -0,0 +1,187 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// LaunchStatisticsWindow is how long a launch's outcome counts towards
+	// the success rate of its instance type, zone and capacity type
+	LaunchStatisticsWindow = 1 * time.Hour
+	// InsufficientCapacityTTL is how long an instance type, zone and capacity
+	// type that last failed due to insufficient capacity is avoided
+	InsufficientCapacityTTL = 3 * time.Minute
+
+	metricSubsystem   = "cloudprovider_aws"
+	instanceTypeLabel = "instance_type"
+	zoneLabel         = "zone"
+	capacityTypeLabel = "capacity_type"
+	resultLabel       = "result"
+)
+
+var (
+	launchesCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "launches_total",
+			Help:      "Number of instances launched, or failed to launch. Broken down by instance type, zone, capacity type and result.",
+		},
+		[]string{instanceTypeLabel, zoneLabel, capacityTypeLabel, resultLabel},
+	)
+	launchSuccessRateGaugeVec = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "launch_success_rate",
+			Help:      "Ratio of successful launches to launches within the last hour, from 0 to 1. Broken down by instance type, zone and capacity type.",
+		},
+		[]string{instanceTypeLabel, zoneLabel, capacityTypeLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(launchesCounterVec, launchSuccessRateGaugeVec)
+}
+
+// offering is an instance type in a zone with a capacity type
+type offering struct {
+	instanceType string
+	zone         string
+	capacityType string
+}
+
+type launchOutcome struct {
+	time                 time.Time
+	success              bool
+	insufficientCapacity bool
+}
+
+// LaunchStatisticsProvider tracks the outcomes of launches for each offering
+// within a rolling window. Offerings whose last launch failed due to
+// insufficient capacity are reported as unavailable for a while, so that
+// launches try other offerings rather than failing repeatedly.
+type LaunchStatisticsProvider struct {
+	mu       sync.Mutex
+	outcomes map[offering][]launchOutcome
+}
+
+func NewLaunchStatisticsProvider() *LaunchStatisticsProvider {
+	return &LaunchStatisticsProvider{outcomes: map[offering][]launchOutcome{}}
+}
+
+// RecordSuccess records that an instance of the offering was launched
+func (p *LaunchStatisticsProvider) RecordSuccess(instanceType string, zone string, capacityType string) {
+	p.record(offering{instanceType, zone, capacityType}, launchOutcome{time: injectabletime.Now(), success: true})
+}
+
+// RecordFailure records that an instance of the offering failed to launch
+func (p *LaunchStatisticsProvider) RecordFailure(instanceType string, zone string, capacityType string, insufficientCapacity bool) {
+	p.record(offering{instanceType, zone, capacityType}, launchOutcome{time: injectabletime.Now(), insufficientCapacity: insufficientCapacity})
+}
+
+// IsUnavailable returns true if the offering's last launch failed due to
+// insufficient capacity within the InsufficientCapacityTTL
+func (p *LaunchStatisticsProvider) IsUnavailable(instanceType string, zone string, capacityType string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return isUnavailable(p.outcomes[offering{instanceType, zone, capacityType}])
+}
+
+// LaunchStatistics summarizes the outcomes of each offering's launches within
+// the LaunchStatisticsWindow, sorted by instance type, zone and capacity type
+func (p *LaunchStatisticsProvider) LaunchStatistics() []cloudprovider.LaunchStatistics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire()
+	statistics := []cloudprovider.LaunchStatistics{}
+	for offering, outcomes := range p.outcomes {
+		successes := successesOf(outcomes)
+		statistics = append(statistics, cloudprovider.LaunchStatistics{
+			InstanceType: offering.instanceType,
+			Zone:         offering.zone,
+			CapacityType: offering.capacityType,
+			Successes:    successes,
+			Failures:     len(outcomes) - successes,
+			SuccessRate:  float64(successes) / float64(len(outcomes)),
+			Unavailable:  isUnavailable(outcomes),
+		})
+	}
+	sort.Slice(statistics, func(i, j int) bool {
+		if statistics[i].InstanceType != statistics[j].InstanceType {
+			return statistics[i].InstanceType < statistics[j].InstanceType
+		}
+		if statistics[i].Zone != statistics[j].Zone {
+			return statistics[i].Zone < statistics[j].Zone
+		}
+		return statistics[i].CapacityType < statistics[j].CapacityType
+	})
+	return statistics
+}
+
+func (p *LaunchStatisticsProvider) record(o offering, outcome launchOutcome) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := "success"
+	if !outcome.success {
+		result = "failure"
+	}
+	launchesCounterVec.WithLabelValues(o.instanceType, o.zone, o.capacityType, result).Inc()
+	p.outcomes[o] = append(p.outcomes[o], outcome)
+	p.expire()
+	launchSuccessRateGaugeVec.WithLabelValues(o.instanceType, o.zone, o.capacityType).Set(float64(successesOf(p.outcomes[o])) / float64(len(p.outcomes[o])))
+}
+
+// expire forgets outcomes older than the LaunchStatisticsWindow, and the
+// offerings without any recent outcomes
+func (p *LaunchStatisticsProvider) expire() {
+	cutoff := injectabletime.Now().Add(-LaunchStatisticsWindow)
+	for o, outcomes := range p.outcomes {
+		i := sort.Search(len(outcomes), func(i int) bool { return outcomes[i].time.After(cutoff) })
+		if i == len(outcomes) {
+			delete(p.outcomes, o)
+			launchSuccessRateGaugeVec.DeleteLabelValues(o.instanceType, o.zone, o.capacityType)
+			continue
+		}
+		p.outcomes[o] = outcomes[i:]
+	}
+}
+
+func isUnavailable(outcomes []launchOutcome) bool {
+	if len(outcomes) == 0 {
+		return false
+	}
+	last := outcomes[len(outcomes)-1]
+	return last.insufficientCapacity && injectabletime.Now().Sub(last.time) < InsufficientCapacityTTL
+}
+
+func successesOf(outcomes []launchOutcome) (successes int) {
+	for _, outcome := range outcomes {
+		if outcome.success {
+			successes++
+		}
+	}
+	return successes
+}
@@ -19,8 +19,10 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"regexp"
 	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -28,6 +30,7 @@ import (
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
 	"github.com/awslabs/karpenter/pkg/utils/restconfig"
 	"github.com/mitchellh/hashstructure/v2"
 	core "k8s.io/api/core/v1"
@@ -46,14 +49,16 @@ type LaunchTemplateProvider struct {
 	ec2api                ec2iface.EC2API
 	amiProvider           *AMIProvider
 	securityGroupProvider *SecurityGroupProvider
+	clusterProvider       *ClusterProvider
 	cache                 *cache.Cache
 }
 
-func NewLaunchTemplateProvider(ec2api ec2iface.EC2API, amiProvider *AMIProvider, securityGroupProvider *SecurityGroupProvider) *LaunchTemplateProvider {
+func NewLaunchTemplateProvider(ec2api ec2iface.EC2API, amiProvider *AMIProvider, securityGroupProvider *SecurityGroupProvider, clusterProvider *ClusterProvider) *LaunchTemplateProvider {
 	return &LaunchTemplateProvider{
 		ec2api:                ec2api,
 		amiProvider:           amiProvider,
 		securityGroupProvider: securityGroupProvider,
+		clusterProvider:       clusterProvider,
 		cache:                 cache.New(CacheTTL, CacheCleanupInterval),
 	}
 }
@@ -74,6 +79,10 @@ type launchTemplateOptions struct {
 	ClusterName     string
 	UserData        string
 	InstanceProfile string
+	// BlockDeviceMappings change with provisioners or the global defaults.
+	BlockDeviceMappings []*v1alpha1.BlockDeviceMapping
+	MetadataOptions     *v1alpha1.MetadataOptions
+	Tags                map[string]string
 	// Level-triggered fields that may change out of sync.
 	SecurityGroupsIds []string
 	AMIID             string
@@ -89,6 +98,11 @@ func (p *LaunchTemplateProvider) Get(ctx context.Context, constraints *v1alpha1.
 	if err != nil {
 		return nil, err
 	}
+	// Get the cluster's endpoint, discovering it if not specified
+	cluster, err := p.clusterProvider.Get(ctx, constraints.Cluster)
+	if err != nil {
+		return nil, err
+	}
 	// Get constrained AMI ID
 	amis, err := p.amiProvider.Get(ctx, constraints, instanceTypes)
 	if err != nil {
@@ -98,17 +112,20 @@ func (p *LaunchTemplateProvider) Get(ctx context.Context, constraints *v1alpha1.
 	launchTemplates := map[string][]cloudprovider.InstanceType{}
 	for amiID, instanceTypes := range amis {
 		// Get userData for Node
-		userData, err := p.getUserData(ctx, constraints, instanceTypes, additionalLabels)
+		userData, err := p.getUserData(ctx, constraints, cluster, instanceTypes, additionalLabels)
 		if err != nil {
 			return nil, err
 		}
 		// Ensure the launch template exists, or create it
 		launchTemplate, err := p.ensureLaunchTemplate(ctx, &launchTemplateOptions{
-			UserData:          userData,
-			ClusterName:       constraints.Cluster.Name,
-			InstanceProfile:   constraints.InstanceProfile,
-			AMIID:             amiID,
-			SecurityGroupsIds: securityGroupsIds,
+			UserData:            userData,
+			ClusterName:         constraints.Cluster.Name,
+			InstanceProfile:     constraints.InstanceProfile,
+			BlockDeviceMappings: blockDeviceMappings(constraints, p.amiProvider.RootDeviceName(constraints, amiID)),
+			MetadataOptions:     constraints.GetMetadataOptions(),
+			Tags:                constraints.Tags,
+			AMIID:               amiID,
+			SecurityGroupsIds:   securityGroupsIds,
 		})
 		if err != nil {
 			return nil, err
@@ -152,7 +169,11 @@ func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, optio
 // conatinerd directly
 func needsDocker(is []cloudprovider.InstanceType) bool {
 	for _, i := range is {
-		if !i.AWSNeurons().IsZero() || !i.NvidiaGPUs().IsZero() {
+		capacity := i.Resources()
+		if _, ok := capacity[resources.AWSNeuron]; ok {
+			return true
+		}
+		if _, ok := capacity[resources.NvidiaGPU]; ok {
 			return true
 		}
 	}
@@ -160,32 +181,30 @@ func needsDocker(is []cloudprovider.InstanceType) bool {
 }
 
 func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, options *launchTemplateOptions) (*ec2.LaunchTemplate, error) {
+	tags := launchTemplateTags(options)
 	output, err := p.ec2api.CreateLaunchTemplateWithContext(ctx, &ec2.CreateLaunchTemplateInput{
 		LaunchTemplateName: aws.String(launchTemplateName(options)),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeLaunchTemplate),
+			Tags:         tags,
+		}},
 		LaunchTemplateData: &ec2.RequestLaunchTemplateData{
 			IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{
 				Name: aws.String(options.InstanceProfile),
 			},
-			TagSpecifications: []*ec2.LaunchTemplateTagSpecificationRequest{{
-				ResourceType: aws.String(ec2.ResourceTypeInstance),
-				Tags: []*ec2.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String(fmt.Sprintf("Karpenter/%s", options.ClusterName)),
-					},
-					{
-						Key:   aws.String(fmt.Sprintf(ClusterTagKeyFormat, options.ClusterName)),
-						Value: aws.String("owned"),
-					},
-					{
-						Key:   aws.String(fmt.Sprintf(KarpenterTagKeyFormat, options.ClusterName)),
-						Value: aws.String("owned"),
-					},
-				},
-			}},
-			SecurityGroupIds: aws.StringSlice(options.SecurityGroupsIds),
-			UserData:         aws.String(options.UserData),
-			ImageId:          aws.String(options.AMIID),
+			TagSpecifications: []*ec2.LaunchTemplateTagSpecificationRequest{
+				{ResourceType: aws.String(ec2.ResourceTypeInstance), Tags: tags},
+				{ResourceType: aws.String(ec2.ResourceTypeVolume), Tags: tags},
+			},
+			SecurityGroupIds:    aws.StringSlice(options.SecurityGroupsIds),
+			UserData:            aws.String(options.UserData),
+			ImageId:             aws.String(options.AMIID),
+			BlockDeviceMappings: blockDeviceMappingRequests(options.BlockDeviceMappings),
+			MetadataOptions: &ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+				HttpEndpoint:            options.MetadataOptions.HTTPEndpoint,
+				HttpTokens:              options.MetadataOptions.HTTPTokens,
+				HttpPutResponseHopLimit: options.MetadataOptions.HTTPPutResponseHopLimit,
+			},
 		},
 	})
 	if err != nil {
@@ -195,6 +214,25 @@ func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, optio
 	return output.LaunchTemplate, nil
 }
 
+// launchTemplateTags returns the provisioner's tags and the tags Karpenter
+// uses for discovery, sorted by key. The provisioner's tags may override the
+// default Name tag, but not the discovery tags.
+func launchTemplateTags(options *launchTemplateOptions) []*ec2.Tag {
+	tags := functional.UnionStringMaps(
+		map[string]string{"Name": fmt.Sprintf("Karpenter/%s", options.ClusterName)},
+		options.Tags,
+		map[string]string{
+			fmt.Sprintf(ClusterTagKeyFormat, options.ClusterName):   "owned",
+			fmt.Sprintf(KarpenterTagKeyFormat, options.ClusterName): "owned",
+		},
+	)
+	result := []*ec2.Tag{}
+	for _, key := range sortedKeys(tags) {
+		result = append(result, &ec2.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
 func sortedTaints(ts []core.Taint) []core.Taint {
 	sorted := append(ts[:0:0], ts...) // copy to avoid touching original
 	sort.Slice(sorted, func(i, j int) bool {
@@ -224,10 +262,42 @@ func sortedKeys(m map[string]string) []string {
 	return keys
 }
 
+// reservedResourcesArg formats resources as the kubelet's reserved flags
+// expect, in sorted order so equivalent options hash the same.
+func reservedResourcesArg(reserved core.ResourceList) string {
+	var args []string
+	for name, quantity := range reserved {
+		args = append(args, fmt.Sprintf("%s=%s", name, quantity.String()))
+	}
+	return strings.Join(sortedStrings(args), ",")
+}
+
+// evictionHardArg formats thresholds as the kubelet's --eviction-hard flag
+// expects, in sorted order so equivalent options hash the same.
+func evictionHardArg(evictionHard map[string]string) string {
+	var args []string
+	for signal, threshold := range evictionHard {
+		args = append(args, fmt.Sprintf("%s<%s", signal, threshold))
+	}
+	return strings.Join(sortedStrings(args), ",")
+}
+
+func sortedStrings(s []string) []string {
+	sorted := append(s[:0:0], s...) // copy to avoid touching original
+	sort.Strings(sorted)
+	return sorted
+}
+
 // getUserData returns the exact same string for equivalent input,
 // even if elements of those inputs are in differeing orders,
 // guaranteeing it won't cause spurious hash differences.
-func (p *LaunchTemplateProvider) getUserData(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, additionalLabels map[string]string) (string, error) {
+func (p *LaunchTemplateProvider) getUserData(ctx context.Context, constraints *v1alpha1.Constraints, cluster *ClusterInfo, instanceTypes []cloudprovider.InstanceType, additionalLabels map[string]string) (string, error) {
+	switch constraints.GetAMIFamily() {
+	case v1alpha1.AMIFamilyBottlerocket:
+		return p.getBottlerocketUserData(ctx, constraints, cluster, additionalLabels)
+	case v1alpha1.AMIFamilyCustom:
+		return p.getCustomUserData(ctx, constraints, cluster, additionalLabels)
+	}
 	var containerRuntimeArg string
 	if !needsDocker(instanceTypes) {
 		containerRuntimeArg = "--container-runtime containerd"
@@ -240,10 +310,10 @@ exec > >(tee /var/log/user-data.log|logger -t user-data -s 2>/dev/console) 2>&1
     --apiserver-endpoint '%s'`,
 		constraints.Cluster.Name,
 		containerRuntimeArg,
-		constraints.Cluster.Endpoint))
-	caBundle, err := p.GetCABundle(ctx)
+		cluster.Endpoint))
+	caBundle, err := p.getClusterCABundle(ctx, cluster)
 	if err != nil {
-		return "", fmt.Errorf("getting ca bundle for user data, %w", err)
+		return "", err
 	}
 	if caBundle != nil {
 		userData.WriteString(fmt.Sprintf(` \
@@ -251,44 +321,208 @@ exec > >(tee /var/log/user-data.log|logger -t user-data -s 2>/dev/console) 2>&1
 			*caBundle))
 	}
 
-	nodeLabels := functional.UnionStringMaps(additionalLabels, constraints.Labels)
 	var nodeLabelArgs bytes.Buffer
-	if len(nodeLabels) > 0 {
-		nodeLabelArgs.WriteString("--node-labels=")
-		first := true
-		// Must be in sorted order or else equivalent options won't
-		// hash the same
-		for _, k := range sortedKeys(nodeLabels) {
-			if !first {
-				nodeLabelArgs.WriteString(",")
-			}
-			first = false
-			nodeLabelArgs.WriteString(fmt.Sprintf("%s=%v", k, nodeLabels[k]))
-		}
+	if nodeLabels := kubeletNodeLabels(functional.UnionStringMaps(additionalLabels, constraints.Labels)); nodeLabels != "" {
+		nodeLabelArgs.WriteString("--node-labels=" + nodeLabels)
 	}
 	var nodeTaintsArgs bytes.Buffer
-	if len(constraints.Taints) > 0 {
-		nodeTaintsArgs.WriteString("--register-with-taints=")
-		first := true
-		// Must be in sorted order or else equivalent options won't
-		// hash the same.
-		sorted := sortedTaints(constraints.Taints)
-		for _, taint := range sorted {
-			if !first {
-				nodeTaintsArgs.WriteString(",")
-			}
-			first = false
-			nodeTaintsArgs.WriteString(fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
-		}
+	if taints := kubeletTaints(append(append([]core.Taint{}, constraints.Taints...), constraints.StartupTaints...)); taints != "" {
+		nodeTaintsArgs.WriteString("--register-with-taints=" + taints)
+	}
+	var podDensityArgs []string
+	if maxPods := constraints.KubeletMaxPods(); maxPods != nil {
+		// Disable the bootstrap script's ENI limited max pods
+		userData.WriteString(` \
+    --use-max-pods false`)
+		podDensityArgs = append(podDensityArgs, fmt.Sprintf("--max-pods=%d", *maxPods))
+	}
+	if constraints.PodsPerCore != nil {
+		podDensityArgs = append(podDensityArgs, fmt.Sprintf("--pods-per-core=%d", *constraints.PodsPerCore))
 	}
-	kubeletExtraArgs := strings.Trim(strings.Join([]string{nodeLabelArgs.String(), nodeTaintsArgs.String()}, " "), " ")
+	// Reserved resources must match the instance types' overhead, or nodes
+	// won't fit the pods they were launched for
+	var reservedArgs []string
+	if reserved := constraints.KubeletConfiguration.GetSystemReserved(); len(reserved) > 0 {
+		reservedArgs = append(reservedArgs, fmt.Sprintf("--system-reserved=%s", reservedResourcesArg(reserved)))
+	}
+	if reserved := constraints.KubeletConfiguration.GetKubeReserved(); len(reserved) > 0 {
+		reservedArgs = append(reservedArgs, fmt.Sprintf("--kube-reserved=%s", reservedResourcesArg(reserved)))
+	}
+	if evictionHard := constraints.KubeletConfiguration.GetEvictionHard(); len(evictionHard) > 0 {
+		reservedArgs = append(reservedArgs, fmt.Sprintf("--eviction-hard=%s", evictionHardArg(evictionHard)))
+	}
+	var clusterDNSArgs []string
+	if clusterDNS := cluster.dnsFor(constraints); len(clusterDNS) > 0 {
+		clusterDNSArgs = append(clusterDNSArgs, fmt.Sprintf("--cluster-dns=%s", strings.Join(clusterDNS, ",")))
+	}
+	kubeletExtraArgs := strings.Trim(strings.Join(append(append(append([]string{nodeLabelArgs.String(), nodeTaintsArgs.String()}, podDensityArgs...), reservedArgs...), clusterDNSArgs...), " "), " ")
 	if len(kubeletExtraArgs) > 0 {
 		userData.WriteString(fmt.Sprintf(` \
     --kubelet-extra-args '%s'`, kubeletExtraArgs))
 	}
+	if len(constraints.PrepullImages) > 0 {
+		// Pulls run in the background so they don't delay the node joining the
+		// cluster. Sorted so equivalent options hash the same.
+		userData.WriteString("\n(")
+		for _, image := range sortedStrings(constraints.PrepullImages) {
+			userData.WriteString(fmt.Sprintf("\n    %s || true", prepullCommand(image, needsDocker(instanceTypes))))
+		}
+		userData.WriteString("\n) &")
+	}
+	return base64.StdEncoding.EncodeToString(userData.Bytes()), nil
+}
+
+// ecrRegistryPattern matches private ECR registries, capturing their region
+var ecrRegistryPattern = regexp.MustCompile(`^[0-9]{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// prepullCommand pulls the image with docker or ctr. The kubelet's ECR
+// credential provider doesn't apply to either, so images in private ECR
+// registries are pulled with a token from the node's credentials. Images in
+// other registries are pulled anonymously.
+func prepullCommand(image string, docker bool) string {
+	registry := v1alpha1.ImageRegistry(image)
+	match := ecrRegistryPattern.FindStringSubmatch(registry)
+	switch {
+	case match == nil && docker:
+		return fmt.Sprintf("docker pull '%s'", image)
+	case match == nil:
+		return fmt.Sprintf("ctr -n k8s.io images pull '%s'", image)
+	case docker:
+		return fmt.Sprintf("aws ecr get-login-password --region %s | docker login --username AWS --password-stdin '%s' && docker pull '%s'", match[1], registry, image)
+	default:
+		return fmt.Sprintf("ctr -n k8s.io images pull --user \"AWS:$(aws ecr get-login-password --region %s)\" '%s'", match[1], image)
+	}
+}
+
+// getBottlerocketUserData configures the kubelet through Bottlerocket's TOML
+// settings rather than the EKS bootstrap script. Tables are written in sorted
+// order so equivalent options hash the same.
+func (p *LaunchTemplateProvider) getBottlerocketUserData(ctx context.Context, constraints *v1alpha1.Constraints, cluster *ClusterInfo, additionalLabels map[string]string) (string, error) {
+	var userData bytes.Buffer
+	userData.WriteString("[settings.kubernetes]\n")
+	userData.WriteString(fmt.Sprintf("api-server = %q\n", cluster.Endpoint))
+	caBundle, err := p.getClusterCABundle(ctx, cluster)
+	if err != nil {
+		return "", err
+	}
+	if caBundle != nil {
+		userData.WriteString(fmt.Sprintf("cluster-certificate = %q\n", *caBundle))
+	}
+	userData.WriteString(fmt.Sprintf("cluster-name = %q\n", constraints.Cluster.Name))
+	if maxPods := constraints.KubeletMaxPods(); maxPods != nil {
+		userData.WriteString(fmt.Sprintf("max-pods = %d\n", *maxPods))
+	}
+	if clusterDNS := cluster.dnsFor(constraints); len(clusterDNS) > 0 {
+		userData.WriteString(fmt.Sprintf("cluster-dns-ip = %q\n", clusterDNS[0]))
+	}
+	writeTOMLTable(&userData, "settings.kubernetes.node-labels", functional.UnionStringMaps(additionalLabels, constraints.Labels))
+	taints := map[string][]string{}
+	for _, taint := range sortedTaints(append(append([]core.Taint{}, constraints.Taints...), constraints.StartupTaints...)) {
+		taints[taint.Key] = append(taints[taint.Key], fmt.Sprintf("%s:%s", taint.Value, taint.Effect))
+	}
+	if len(taints) > 0 {
+		userData.WriteString("[settings.kubernetes.node-taints]\n")
+		for _, key := range sortedTaintKeys(taints) {
+			var values []string
+			for _, value := range taints[key] {
+				values = append(values, fmt.Sprintf("%q", value))
+			}
+			userData.WriteString(fmt.Sprintf("%q = [%s]\n", key, strings.Join(values, ", ")))
+		}
+	}
+	writeTOMLTable(&userData, "settings.kubernetes.eviction-hard", constraints.KubeletConfiguration.GetEvictionHard())
+	writeTOMLTable(&userData, "settings.kubernetes.kube-reserved", resourceListStrings(constraints.KubeletConfiguration.GetKubeReserved()))
+	writeTOMLTable(&userData, "settings.kubernetes.system-reserved", resourceListStrings(constraints.KubeletConfiguration.GetSystemReserved()))
+	return base64.StdEncoding.EncodeToString(userData.Bytes()), nil
+}
+
+// getCustomUserData passes the provisioner's user data through, substituting
+// its template variables
+func (p *LaunchTemplateProvider) getCustomUserData(ctx context.Context, constraints *v1alpha1.Constraints, cluster *ClusterInfo, additionalLabels map[string]string) (string, error) {
+	if constraints.UserData == nil {
+		return "", fmt.Errorf("no user data for ami family %s", v1alpha1.AMIFamilyCustom)
+	}
+	userDataTemplate, err := template.New("userData").Option("missingkey=error").Parse(*constraints.UserData)
+	if err != nil {
+		return "", fmt.Errorf("parsing user data, %w", err)
+	}
+	caBundle, err := p.getClusterCABundle(ctx, cluster)
+	if err != nil {
+		return "", err
+	}
+	var userData bytes.Buffer
+	if err := userDataTemplate.Execute(&userData, v1alpha1.UserDataValues{
+		ClusterName:     constraints.Cluster.Name,
+		ClusterEndpoint: cluster.Endpoint,
+		CABundle:        ptr.StringValue(caBundle),
+		Labels:          kubeletNodeLabels(functional.UnionStringMaps(additionalLabels, constraints.Labels)),
+		Taints:          kubeletTaints(append(append([]core.Taint{}, constraints.Taints...), constraints.StartupTaints...)),
+	}); err != nil {
+		return "", fmt.Errorf("executing user data template, %w", err)
+	}
 	return base64.StdEncoding.EncodeToString(userData.Bytes()), nil
 }
 
+// kubeletNodeLabels formats labels as the kubelet's --node-labels flag
+// expects, in sorted order so equivalent options hash the same.
+func kubeletNodeLabels(labels map[string]string) string {
+	var args []string
+	for _, key := range sortedKeys(labels) {
+		args = append(args, fmt.Sprintf("%s=%s", key, labels[key]))
+	}
+	return strings.Join(args, ",")
+}
+
+// kubeletTaints formats taints as the kubelet's --register-with-taints flag
+// expects, in sorted order so equivalent options hash the same.
+func kubeletTaints(taints []core.Taint) string {
+	var args []string
+	for _, taint := range sortedTaints(taints) {
+		args = append(args, fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
+	}
+	return strings.Join(args, ",")
+}
+
+func writeTOMLTable(buffer *bytes.Buffer, name string, entries map[string]string) {
+	if len(entries) == 0 {
+		return
+	}
+	buffer.WriteString(fmt.Sprintf("[%s]\n", name))
+	for _, key := range sortedKeys(entries) {
+		buffer.WriteString(fmt.Sprintf("%q = %q\n", key, entries[key]))
+	}
+}
+
+func sortedTaintKeys(taints map[string][]string) []string {
+	keys := make([]string, 0, len(taints))
+	for key := range taints {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func resourceListStrings(resources core.ResourceList) map[string]string {
+	entries := map[string]string{}
+	for name, quantity := range resources {
+		entries[string(name)] = quantity.String()
+	}
+	return entries
+}
+
+// getClusterCABundle prefers the CA bundle of Karpenter's own rest config,
+// falling back to the one discovered with the cluster.
+func (p *LaunchTemplateProvider) getClusterCABundle(ctx context.Context, cluster *ClusterInfo) (*string, error) {
+	caBundle, err := p.GetCABundle(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting ca bundle for user data, %w", err)
+	}
+	if caBundle == nil {
+		caBundle = cluster.CABundle
+	}
+	return caBundle, nil
+}
+
 func (p *LaunchTemplateProvider) GetCABundle(ctx context.Context) (*string, error) {
 	// Discover CA Bundle from the REST client. We could alternatively
 	// have used the simpler client-go InClusterConfig() method.
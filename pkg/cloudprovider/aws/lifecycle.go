@@ -0,0 +1,110 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	envutils "github.com/awslabs/karpenter/pkg/utils/env"
+)
+
+// phaseAttribute is set on messages so that subscribers can filter by phase
+const phaseAttribute = "phase"
+
+// LifecycleOptions configure where node lifecycle events are published
+type LifecycleOptions struct {
+	// TopicARN of an SNS topic
+	TopicARN string
+	// Queue is the name or URL of an SQS queue
+	Queue string
+}
+
+var lifecycleOptions = LifecycleOptions{}
+
+func init() {
+	flag.StringVar(&lifecycleOptions.TopicARN, "aws-lifecycle-topic-arn", envutils.WithDefaultString("AWS_LIFECYCLE_TOPIC_ARN", ""), "The ARN of an SNS topic to publish node lifecycle events to, disabled if empty")
+	flag.StringVar(&lifecycleOptions.Queue, "aws-lifecycle-queue", envutils.WithDefaultString("AWS_LIFECYCLE_QUEUE", ""), "The name or URL of an SQS queue to send node lifecycle events to, disabled if empty")
+}
+
+// NewLifecycleSinks returns sinks for the configured topic and queue
+func NewLifecycleSinks(snsapi snsiface.SNSAPI, sqsapi sqsiface.SQSAPI, options LifecycleOptions) []lifecycle.Sink {
+	sinks := []lifecycle.Sink{}
+	if options.TopicARN != "" {
+		sinks = append(sinks, &SNSSink{snsapi: snsapi, topicARN: options.TopicARN})
+	}
+	if options.Queue != "" {
+		sinks = append(sinks, &SQSSink{sqsapi: sqsapi, queue: newSQSQueue(sqsapi, options.Queue)})
+	}
+	return sinks
+}
+
+// SNSSink publishes events as JSON to an SNS topic
+type SNSSink struct {
+	snsapi   snsiface.SNSAPI
+	topicARN string
+}
+
+func (s *SNSSink) Publish(ctx context.Context, event *lifecycle.Event) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshaling event, %w", err)
+	}
+	if _, err := s.snsapi.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.topicARN),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			phaseAttribute: {DataType: aws.String("String"), StringValue: aws.String(string(event.Phase))},
+		},
+	}); err != nil {
+		return fmt.Errorf("publishing to %s, %w", s.topicARN, err)
+	}
+	return nil
+}
+
+// SQSSink sends events as JSON to an SQS queue
+type SQSSink struct {
+	sqsapi sqsiface.SQSAPI
+	queue  *sqsQueue
+}
+
+func (s *SQSSink) Publish(ctx context.Context, event *lifecycle.Event) error {
+	queueURL, err := s.queue.URL(ctx)
+	if err != nil {
+		return err
+	}
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshaling event, %w", err)
+	}
+	if _, err := s.sqsapi.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(message)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			phaseAttribute: {DataType: aws.String("String"), StringValue: aws.String(string(event.Phase))},
+		},
+	}); err != nil {
+		return fmt.Errorf("sending message to %s, %w", s.queue.name, err)
+	}
+	return nil
+}
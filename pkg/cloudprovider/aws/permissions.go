@@ -0,0 +1,210 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// PermissionsCheckInterval is the period at which required permissions are
+	// rechecked, so that fixes to IAM policies are eventually reflected.
+	PermissionsCheckInterval = 5 * time.Minute
+	// permissionsCheckSSMParameterFormat is the public parameter used to verify
+	// access to the parameters from which AMIs are resolved, formatted with the
+	// cluster's kubernetes version. The parameter need not exist for the check
+	// to pass.
+	permissionsCheckSSMParameterFormat = "/aws/service/eks/optimized-ami/%s/amazon-linux-2/recommended/image_id"
+	// permissionsCheckInstanceID is a well formed instance id that doesn't exist
+	permissionsCheckInstanceID = "i-00000000000000000"
+)
+
+// PermissionsProvider verifies that the controller has the permissions it
+// requires by exercising AWS APIs, using DryRun where possible. Other failures
+// (e.g. malformed dry run requests) are inconclusive and assumed permitted.
+// iam:PassRole cannot be verified ahead of time. Missing permissions are
+// logged each time they're checked, and reported in provisioners' status.
+type PermissionsProvider struct {
+	ec2api      ec2iface.EC2API
+	ssm         ssmiface.SSMAPI
+	amiProvider *AMIProvider
+
+	mu      sync.RWMutex
+	checked bool
+	missing []string
+}
+
+func NewPermissionsProvider(ec2api ec2iface.EC2API, ssm ssmiface.SSMAPI, amiProvider *AMIProvider) *PermissionsProvider {
+	return &PermissionsProvider{ec2api: ec2api, ssm: ssm, amiProvider: amiProvider}
+}
+
+// Start checks permissions immediately and then periodically until the context is cancelled
+func (p *PermissionsProvider) Start(ctx context.Context) {
+	go func() {
+		for {
+			if err := p.Check(ctx); err != nil {
+				logging.FromContext(ctx).Errorf("Checking AWS permissions, %s, provisioning will fail until they're granted", err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(PermissionsCheckInterval):
+			}
+		}
+	}()
+}
+
+// Check exercises the required APIs and returns an error listing any
+// permissions that are missing.
+func (p *PermissionsProvider) Check(ctx context.Context) error {
+	missing := []string{}
+	for permission, check := range p.checks() {
+		if err := check(ctx); err != nil {
+			if isUnauthorized(err) {
+				missing = append(missing, permission)
+				continue
+			}
+			if !isDryRun(err) {
+				logging.FromContext(ctx).Debugf("Inconclusive permissions check for %s, %s", permission, err.Error())
+			}
+		}
+	}
+	sort.Strings(missing)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(missing) == 0 && (!p.checked || len(p.missing) != 0) {
+		logging.FromContext(ctx).Infof("Verified AWS permissions")
+	}
+	p.checked = true
+	p.missing = missing
+	return p.err()
+}
+
+// MissingPermissions returns the permissions found missing by the last check
+func (p *PermissionsProvider) MissingPermissions() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.missing
+}
+
+// ReadinessProbe reports an error until permissions have been checked once.
+// Missing permissions don't fail readiness, since they only fail the requests
+// that need them. They're reported by MissingPermissions instead.
+func (p *PermissionsProvider) ReadinessProbe(_ *http.Request) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.checked {
+		return fmt.Errorf("permissions have not been checked")
+	}
+	return nil
+}
+
+func (p *PermissionsProvider) err() error {
+	if len(p.missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing permissions %s", strings.Join(p.missing, ", "))
+}
+
+func (p *PermissionsProvider) checks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{
+		"ec2:CreateFleet": func(ctx context.Context) error {
+			_, err := p.ec2api.CreateFleetWithContext(ctx, &ec2.CreateFleetInput{
+				DryRun: aws.Bool(true),
+				LaunchTemplateConfigs: []*ec2.FleetLaunchTemplateConfigRequest{{
+					LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+						LaunchTemplateName: aws.String(permissionsCheckInstanceID),
+						Version:            aws.String("$Latest"),
+					},
+				}},
+				TargetCapacitySpecification: &ec2.TargetCapacitySpecificationRequest{
+					DefaultTargetCapacityType: aws.String(ec2.DefaultTargetCapacityTypeOnDemand),
+					TotalTargetCapacity:       aws.Int64(1),
+				},
+				Type: aws.String(ec2.FleetTypeInstant),
+			})
+			return err
+		},
+		"ec2:CreateLaunchTemplate": func(ctx context.Context) error {
+			_, err := p.ec2api.CreateLaunchTemplateWithContext(ctx, &ec2.CreateLaunchTemplateInput{
+				DryRun:             aws.Bool(true),
+				LaunchTemplateName: aws.String(permissionsCheckInstanceID),
+				LaunchTemplateData: &ec2.RequestLaunchTemplateData{},
+			})
+			return err
+		},
+		"ec2:TerminateInstances": func(ctx context.Context) error {
+			_, err := p.ec2api.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+				DryRun:      aws.Bool(true),
+				InstanceIds: []*string{aws.String(permissionsCheckInstanceID)},
+			})
+			return err
+		},
+		"ec2:DescribeInstances": func(ctx context.Context) error {
+			_, err := p.ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{DryRun: aws.Bool(true)})
+			return err
+		},
+		"ec2:DescribeLaunchTemplates": func(ctx context.Context) error {
+			_, err := p.ec2api.DescribeLaunchTemplatesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{DryRun: aws.Bool(true)})
+			return err
+		},
+		"ec2:DescribeSubnets": func(ctx context.Context) error {
+			_, err := p.ec2api.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{DryRun: aws.Bool(true)})
+			return err
+		},
+		"ec2:DescribeSecurityGroups": func(ctx context.Context) error {
+			_, err := p.ec2api.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{DryRun: aws.Bool(true)})
+			return err
+		},
+		"ec2:DescribeAvailabilityZones": func(ctx context.Context) error {
+			_, err := p.ec2api.DescribeAvailabilityZonesWithContext(ctx, &ec2.DescribeAvailabilityZonesInput{DryRun: aws.Bool(true)})
+			return err
+		},
+		"ec2:DescribeImages": func(ctx context.Context) error {
+			_, err := p.ec2api.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{DryRun: aws.Bool(true)})
+			return err
+		},
+		"ec2:DescribeInstanceTypes": func(ctx context.Context) error {
+			return p.ec2api.DescribeInstanceTypesPagesWithContext(ctx, &ec2.DescribeInstanceTypesInput{DryRun: aws.Bool(true)},
+				func(*ec2.DescribeInstanceTypesOutput, bool) bool { return false })
+		},
+		"ec2:DescribeInstanceTypeOfferings": func(ctx context.Context) error {
+			return p.ec2api.DescribeInstanceTypeOfferingsPagesWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{DryRun: aws.Bool(true)},
+				func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool { return false })
+		},
+		"ssm:GetParameter": func(ctx context.Context) error {
+			version, err := p.amiProvider.kubeServerVersion(ctx)
+			if err != nil {
+				return fmt.Errorf("kube server version, %w", err)
+			}
+			_, err = p.ssm.GetParameterWithContext(ctx, &ssm.GetParameterInput{Name: aws.String(fmt.Sprintf(permissionsCheckSSMParameterFormat, version))})
+			return err
+		},
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/patrickmn/go-cache"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// PricingCacheTTL is the interval at which prices are refreshed. On demand
+	// prices rarely change, and the price list is large.
+	PricingCacheTTL = 24 * time.Hour
+	pricesCacheKey  = "prices"
+)
+
+// PricingProvider resolves the on demand hourly prices of instance types in the
+// region using the AWS Price List API
+type PricingProvider struct {
+	pricing pricingiface.PricingAPI
+	region  string
+	cache   *cache.Cache
+}
+
+func NewPricingProvider(pricing pricingiface.PricingAPI, region string) *PricingProvider {
+	return &PricingProvider{
+		pricing: pricing,
+		region:  region,
+		cache:   cache.New(PricingCacheTTL, CacheCleanupInterval),
+	}
+}
+
+// pricingRegion returns the region of the Price List API endpoint closest to
+// the region. The API is only served from us-east-1 and ap-south-1.
+func pricingRegion(region string) string {
+	if strings.HasPrefix(region, "ap-") {
+		return "ap-south-1"
+	}
+	return "us-east-1"
+}
+
+// Get returns the on demand hourly price in USD of each instance type, by name.
+// Linux instances with shared tenancy and no preinstalled software are priced.
+func (p *PricingProvider) Get(ctx context.Context) (map[string]float64, error) {
+	if prices, ok := p.cache.Get(pricesCacheKey); ok {
+		return prices.(map[string]float64), nil
+	}
+	prices := map[string]float64{}
+	var parseErr error
+	if err := p.pricing.GetProductsPagesWithContext(ctx, &pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []*pricing.Filter{
+			{Type: aws.String(pricing.FilterTypeTermMatch), Field: aws.String("regionCode"), Value: aws.String(p.region)},
+			{Type: aws.String(pricing.FilterTypeTermMatch), Field: aws.String("operatingSystem"), Value: aws.String("Linux")},
+			{Type: aws.String(pricing.FilterTypeTermMatch), Field: aws.String("tenancy"), Value: aws.String("Shared")},
+			{Type: aws.String(pricing.FilterTypeTermMatch), Field: aws.String("preInstalledSw"), Value: aws.String("NA")},
+			{Type: aws.String(pricing.FilterTypeTermMatch), Field: aws.String("capacitystatus"), Value: aws.String("Used")},
+		},
+	}, func(output *pricing.GetProductsOutput, lastPage bool) bool {
+		for _, product := range output.PriceList {
+			instanceType, price, err := parsePrice(product)
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			if instanceType != "" && price > 0 {
+				prices[instanceType] = price
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("getting products, %w", err)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("parsing products, %w", parseErr)
+	}
+	p.cache.SetDefault(pricesCacheKey, prices)
+	logging.FromContext(ctx).Debugf("Discovered prices of %d EC2 instance types", len(prices))
+	return prices, nil
+}
+
+// product is the subset of a Price List API product that prices its instance type
+type product struct {
+	Product struct {
+		Attributes struct {
+			InstanceType string `json:"instanceType"`
+		} `json:"attributes"`
+	} `json:"product"`
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// parsePrice returns the instance type of the product and its hourly price
+func parsePrice(value aws.JSONValue) (string, float64, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", 0, err
+	}
+	parsed := &product{}
+	if err := json.Unmarshal(raw, parsed); err != nil {
+		return "", 0, err
+	}
+	for _, term := range parsed.Terms.OnDemand {
+		for _, dimension := range term.PriceDimensions {
+			usd, ok := dimension.PricePerUnit["USD"]
+			if !ok {
+				continue
+			}
+			price, err := strconv.ParseFloat(usd, 64)
+			if err != nil {
+				return "", 0, fmt.Errorf("parsing price of %s, %w", parsed.Product.Attributes.InstanceType, err)
+			}
+			return parsed.Product.Attributes.InstanceType, price, nil
+		}
+	}
+	return parsed.Product.Attributes.InstanceType, 0, nil
+}
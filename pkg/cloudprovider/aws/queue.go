@@ -0,0 +1,58 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// sqsQueue is an SQS queue configured by name or URL
+type sqsQueue struct {
+	sqsapi sqsiface.SQSAPI
+	name   string
+
+	mu  sync.Mutex
+	url string
+}
+
+func newSQSQueue(sqsapi sqsiface.SQSAPI, name string) *sqsQueue {
+	return &sqsQueue{sqsapi: sqsapi, name: name}
+}
+
+// URL resolves the queue's name to its URL, once
+func (q *sqsQueue) URL(ctx context.Context) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.url != "" {
+		return q.url, nil
+	}
+	if strings.HasPrefix(q.name, "https://") {
+		q.url = q.name
+		return q.url, nil
+	}
+	output, err := q.sqsapi.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(q.name)})
+	if err != nil {
+		return "", fmt.Errorf("getting url of queue %s, %w", q.name, err)
+	}
+	q.url = aws.StringValue(output.QueueUrl)
+	return q.url, nil
+}
@@ -17,11 +17,14 @@ package aws
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/patrickmn/go-cache"
 	"knative.dev/pkg/logging"
@@ -45,6 +48,16 @@ func (s *SubnetProvider) Get(ctx context.Context, constraints *v1alpha1.Constrai
 	if err != nil {
 		return nil, err
 	}
+	// Remove excluded subnets
+	if len(constraints.ExcludedSubnets) > 0 {
+		included := []*ec2.Subnet{}
+		for _, subnet := range subnets {
+			if !functional.ContainsString(constraints.ExcludedSubnets, aws.StringValue(subnet.SubnetId)) {
+				included = append(included, subnet)
+			}
+		}
+		subnets = included
+	}
 	// Fail if no subnets found
 	if len(subnets) == 0 {
 		return nil, fmt.Errorf("no subnets exist given constraints")
@@ -64,7 +77,16 @@ func (s *SubnetProvider) getFilters(constraints *v1alpha1.Constraints) []*ec2.Fi
 	}
 	// Filter by selector
 	for key, value := range constraints.SubnetSelector {
-		if value == "*" {
+		if key == v1alpha1.SubnetIDsSelectorKey {
+			ids := []*string{}
+			for _, id := range strings.Split(value, ",") {
+				ids = append(ids, aws.String(strings.TrimSpace(id)))
+			}
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("subnet-id"),
+				Values: ids,
+			})
+		} else if value == "*" {
 			filters = append(filters, &ec2.Filter{
 				Name:   aws.String("tag-key"),
 				Values: []*string{aws.String(key)},
@@ -103,3 +125,41 @@ func (s *SubnetProvider) subnetIds(subnets []*ec2.Subnet) []string {
 	}
 	return names
 }
+
+// zonalSubnets chooses one subnet in each zone, since fleets cannot span
+// subnets in the same zone. Subnets in the same zone are chosen at random in
+// proportion to their weights, so that capacity is distributed deliberately
+// between subnets of different sizes.
+func zonalSubnets(constraints *v1alpha1.Constraints, subnets []*ec2.Subnet) map[string]*ec2.Subnet {
+	zonal := map[string][]*ec2.Subnet{}
+	for _, subnet := range subnets {
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		zonal[zone] = append(zonal[zone], subnet)
+	}
+	chosen := map[string]*ec2.Subnet{}
+	for zone, subnets := range zonal {
+		chosen[zone] = weightedSubnet(constraints.SubnetWeights, subnets)
+	}
+	return chosen
+}
+
+func weightedSubnet(weights map[string]int32, subnets []*ec2.Subnet) *ec2.Subnet {
+	total := int64(0)
+	for _, subnet := range subnets {
+		total += subnetWeight(weights, subnet)
+	}
+	choice := rand.Int63n(total)
+	for _, subnet := range subnets {
+		if choice -= subnetWeight(weights, subnet); choice < 0 {
+			return subnet
+		}
+	}
+	return subnets[len(subnets)-1]
+}
+
+func subnetWeight(weights map[string]int32, subnet *ec2.Subnet) int64 {
+	if weight, ok := weights[aws.StringValue(subnet.SubnetId)]; ok && weight > 0 {
+		return int64(weight)
+	}
+	return 1
+}
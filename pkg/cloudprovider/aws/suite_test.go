@@ -15,29 +15,41 @@ limitations under the License.
 package aws
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/fake"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/registry"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
+	provisioners "github.com/awslabs/karpenter/pkg/controllers/provisioner"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
 	"github.com/awslabs/karpenter/pkg/test"
 	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
 	"github.com/awslabs/karpenter/pkg/utils/parallel"
 	"github.com/awslabs/karpenter/pkg/utils/resources"
 	"github.com/patrickmn/go-cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/ssm"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
@@ -45,14 +57,21 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
 )
 
 var ctx context.Context
 var env *test.Environment
 var launchTemplateCache *cache.Cache
 var fakeEC2API *fake.EC2API
-var controller reconcile.Reconciler
+var fakeEKSAPI *fake.EKSAPI
+var fakePricingAPI *fake.PricingAPI
+var instanceTypeProvider *InstanceTypeProvider
+var clusterProvider *ClusterProvider
+var launchStatistics *LaunchStatisticsProvider
+var controller *allocation.Controller
 
 func TestAPIs(t *testing.T) {
 	ctx = TestContextWithLogger(t)
@@ -62,19 +81,27 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	launchTemplateCache = cache.New(CacheTTL, CacheCleanupInterval)
+	launchStatistics = NewLaunchStatisticsProvider()
 	fakeEC2API = &fake.EC2API{}
-	instanceTypeProvider := NewInstanceTypeProvider(fakeEC2API)
+	fakeEKSAPI = &fake.EKSAPI{}
+	fakePricingAPI = &fake.PricingAPI{}
+	instanceTypeProvider = NewInstanceTypeProvider(fakeEC2API, NewPricingProvider(fakePricingAPI, "test-region"))
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
 		clientSet := kubernetes.NewForConfigOrDie(e.Config)
+		clusterProvider = NewClusterProvider(fakeEKSAPI, clientSet)
+		amiProvider := NewAMIProvider(&fake.SSMAPI{}, fakeEC2API, clientSet)
 		cloudProvider := &CloudProvider{
 			instanceTypeProvider: instanceTypeProvider,
+			amiProvider:          amiProvider,
 			instanceProvider: &InstanceProvider{fakeEC2API, instanceTypeProvider, &LaunchTemplateProvider{
 				fakeEC2API,
-				NewAMIProvider(&fake.SSMAPI{}, clientSet),
+				amiProvider,
 				NewSecurityGroupProvider(fakeEC2API),
+				clusterProvider,
 				launchTemplateCache,
 			},
 				NewSubnetProvider(fakeEC2API),
+				launchStatistics,
 			},
 			creationQueue: parallel.NewWorkQueue(CreationQPS, CreationBurst),
 		}
@@ -83,10 +110,12 @@ var _ = BeforeSuite(func() {
 			Filter:        &allocation.Filter{KubeClient: e.Client},
 			Binder:        &allocation.Binder{KubeClient: e.Client, CoreV1Client: clientSet.CoreV1()},
 			Batcher:       allocation.NewBatcher(1*time.Millisecond, 1*time.Millisecond),
+			Breaker:       allocation.NewBreaker(),
 			Scheduler:     scheduling.NewScheduler(e.Client),
 			Packer:        binpacking.NewPacker(),
 			CloudProvider: cloudProvider,
 			KubeClient:    e.Client,
+			Recorder:      &record.FakeRecorder{},
 		}
 	})
 
@@ -112,8 +141,11 @@ var _ = Describe("Allocation", func() {
 		provisioner = ProvisionerWithProvider(&v1alpha4.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name}}, provider)
 		provisioner.SetDefaults(ctx)
 		fakeEC2API.Reset()
+		fakeEKSAPI.Reset()
 		ExpectCleanedUp(env.Client)
 		launchTemplateCache.Flush()
+		clusterProvider.cache.Flush()
+		launchStatistics.outcomes = map[offering][]launchOutcome{}
 	})
 
 	Context("Reconciliation", func() {
@@ -147,10 +179,10 @@ var _ = Describe("Allocation", func() {
 				scheduled1 := ExpectPodExists(env.Client, pod1.GetName(), pod1.GetNamespace())
 				scheduled2 := ExpectPodExists(env.Client, pod2.GetName(), pod2.GetNamespace())
 				scheduled3 := ExpectPodExists(env.Client, pod3.GetName(), pod3.GetNamespace())
-				Expect(scheduled1.Spec.NodeName).To(Equal(scheduled2.Spec.NodeName))
-				Expect(scheduled1.Spec.NodeName).ToNot(Equal(scheduled3.Spec.NodeName))
-				ExpectNodeExists(env.Client, scheduled1.Spec.NodeName)
-				ExpectNodeExists(env.Client, scheduled3.Spec.NodeName)
+				Expect(scheduled1.Status.NominatedNodeName).To(Equal(scheduled2.Status.NominatedNodeName))
+				Expect(scheduled1.Status.NominatedNodeName).ToNot(Equal(scheduled3.Status.NominatedNodeName))
+				ExpectNodeExists(env.Client, scheduled1.Status.NominatedNodeName)
+				ExpectNodeExists(env.Client, scheduled3.Status.NominatedNodeName)
 				Expect(InstancesLaunchedFrom(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(Equal(2))
 				overrides := []*ec2.FleetLaunchTemplateOverridesRequest{}
 				for i := range fakeEC2API.CalledWithCreateFleetInput.Iter() {
@@ -160,6 +192,83 @@ var _ = Describe("Allocation", func() {
 					Expect(*override.InstanceType).To(Equal("p3.8xlarge"))
 				}
 			})
+			It("should label nodes with gpu model and memory", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{
+						Requests: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+						Limits:   v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+					},
+				}))
+				node := ExpectNodeExists(env.Client, pods[0].Status.NominatedNodeName)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.GPUModelLabel, "nvidia-v100"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.GPUMemoryLabel, "16384"))
+			})
+			It("should launch instances that satisfy gpu requirements", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{
+						NodeSelector: map[string]string{v1alpha1.GPUModelLabel: "nvidia-v100", v1alpha1.GPUMemoryLabel: "16384"},
+						ResourceRequirements: v1.ResourceRequirements{
+							Requests: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+							Limits:   v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+						},
+					}),
+					test.UnschedulablePod(test.PodOptions{
+						NodeSelector: map[string]string{v1alpha1.GPUModelLabel: "nvidia-a100"},
+						ResourceRequirements: v1.ResourceRequirements{
+							Requests: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+							Limits:   v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+						},
+					}),
+				)
+				ExpectNodeExists(env.Client, pods[0].Status.NominatedNodeName)
+				Expect(pods[1].Status.NominatedNodeName).To(BeEmpty())
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, override := range input.LaunchTemplateConfigs[0].Overrides {
+					Expect(*override.InstanceType).To(Equal("p3.8xlarge"))
+				}
+			})
+			It("should launch instances that satisfy numeric gpu requirements", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{
+						NodeRequirements: []v1.NodeSelectorRequirement{
+							{Key: v1alpha1.GPUModelLabel, Operator: v1.NodeSelectorOpExists},
+							{Key: v1alpha1.GPUMemoryLabel, Operator: v1.NodeSelectorOpGt, Values: []string{"8192"}},
+						},
+						ResourceRequirements: v1.ResourceRequirements{
+							Requests: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+							Limits:   v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+						},
+					}),
+					test.UnschedulablePod(test.PodOptions{
+						NodeRequirements: []v1.NodeSelectorRequirement{
+							{Key: v1alpha1.GPUMemoryLabel, Operator: v1.NodeSelectorOpLt, Values: []string{"8192"}},
+						},
+						ResourceRequirements: v1.ResourceRequirements{
+							Requests: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+							Limits:   v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+						},
+					}),
+				)
+				ExpectNodeExists(env.Client, pods[0].Status.NominatedNodeName)
+				Expect(pods[1].Status.NominatedNodeName).To(BeEmpty())
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, override := range input.LaunchTemplateConfigs[0].Overrides {
+					Expect(*override.InstanceType).To(Equal("p3.8xlarge"))
+				}
+			})
+			It("should not launch gpu instances for pods requiring no gpu model", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					NodeRequirements: []v1.NodeSelectorRequirement{
+						{Key: v1alpha1.GPUModelLabel, Operator: v1.NodeSelectorOpDoesNotExist},
+					},
+				}))
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(node.Labels).ToNot(HaveKey(v1alpha1.GPUModelLabel))
+			})
 			It("should launch instances for AWS Neuron resource requests", func() {
 				// Setup
 				pod1 := test.UnschedulablePod(test.PodOptions{
@@ -189,10 +298,10 @@ var _ = Describe("Allocation", func() {
 				scheduled1 := ExpectPodExists(env.Client, pod1.GetName(), pod1.GetNamespace())
 				scheduled2 := ExpectPodExists(env.Client, pod2.GetName(), pod2.GetNamespace())
 				scheduled3 := ExpectPodExists(env.Client, pod3.GetName(), pod3.GetNamespace())
-				Expect(scheduled1.Spec.NodeName).To(Equal(scheduled2.Spec.NodeName))
-				Expect(scheduled1.Spec.NodeName).ToNot(Equal(scheduled3.Spec.NodeName))
-				ExpectNodeExists(env.Client, scheduled1.Spec.NodeName)
-				ExpectNodeExists(env.Client, scheduled3.Spec.NodeName)
+				Expect(scheduled1.Status.NominatedNodeName).To(Equal(scheduled2.Status.NominatedNodeName))
+				Expect(scheduled1.Status.NominatedNodeName).ToNot(Equal(scheduled3.Status.NominatedNodeName))
+				ExpectNodeExists(env.Client, scheduled1.Status.NominatedNodeName)
+				ExpectNodeExists(env.Client, scheduled3.Status.NominatedNodeName)
 				Expect(InstancesLaunchedFrom(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(Equal(2))
 				overrides := []*ec2.FleetLaunchTemplateOverridesRequest{}
 				for input := range fakeEC2API.CalledWithCreateFleetInput.Iter() {
@@ -203,6 +312,64 @@ var _ = Describe("Allocation", func() {
 				}
 			})
 		})
+		Context("Instance Family", func() {
+			It("should label nodes with their instance family", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"},
+				}))
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.InstanceFamilyLabel, "m5"))
+			})
+			It("should launch instances of the selected instance family", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{v1alpha1.InstanceFamilyLabel: "p3"},
+				}))
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, override := range input.LaunchTemplateConfigs[0].Overrides {
+					Expect(*override.InstanceType).To(Equal("p3.8xlarge"))
+				}
+			})
+		})
+		Context("Pricing", func() {
+			var recorder *record.FakeRecorder
+			BeforeEach(func() {
+				recorder = record.NewFakeRecorder(10)
+				controller.Recorder = recorder
+			})
+			AfterEach(func() {
+				controller.Recorder = &record.FakeRecorder{}
+				fakePricingAPI.WantErr = nil
+				instanceTypeProvider.cache.Flush()
+				instanceTypeProvider.pricingProvider.cache.Flush()
+			})
+			It("should price instance types with their on demand price", func() {
+				instanceTypes, err := instanceTypeProvider.Get(ctx, nil)
+				Expect(err).ToNot(HaveOccurred())
+				for _, instanceType := range instanceTypes {
+					Expect(instanceType.(cloudprovider.PricedInstanceType).HourlyPrice()).To(Equal(fake.DefaultPrices[instanceType.Name()]))
+				}
+			})
+			It("should record the estimated cost of launches", func() {
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(recorder.Events).To(Receive(Equal(fmt.Sprintf("Normal %s Launching 1 node(s) for 1 pod(s), 1 of [m5.large], estimated cost 0.0960 per hour", allocation.LaunchEstimated))))
+			})
+			It("should launch unpriced instance types if prices can't be discovered", func() {
+				instanceTypeProvider.cache.Flush()
+				instanceTypeProvider.pricingProvider.cache.Flush()
+				fakePricingAPI.WantErr = fmt.Errorf("test pricing failed")
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(recorder.Events).To(Receive(HaveSuffix("estimated cost unknown")))
+			})
+		})
 		Context("CapacityType", func() {
 			It("should default to on demand", func() {
 				// Setup
@@ -241,6 +408,28 @@ var _ = Describe("Allocation", func() {
 				Expect(input.LaunchTemplateConfigs).To(HaveLen(1))
 				Expect(*input.TargetCapacitySpecification.DefaultTargetCapacityType).To(Equal(v1alpha1.CapacityTypeSpot))
 			})
+			It("should launch on demand capacity for pods past their provisioning deadline", func() {
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{Annotations: map[string]string{v1alpha4.ProvisioningDeadlineAnnotationKey: "0s"}}),
+				)
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(*input.TargetCapacitySpecification.DefaultTargetCapacityType).To(Equal(v1alpha1.CapacityTypeOnDemand))
+			})
+			It("should not launch on demand capacity for pods past their provisioning deadline if the provisioner doesn't allow it", func() {
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{Annotations: map[string]string{v1alpha4.ProvisioningDeadlineAnnotationKey: "0s"}}),
+				)
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(*input.TargetCapacitySpecification.DefaultTargetCapacityType).To(Equal(v1alpha1.CapacityTypeSpot))
+			})
 			It("should allow a pod to constrain the capacity type", func() {
 				// Setup
 				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
@@ -255,6 +444,114 @@ var _ = Describe("Allocation", func() {
 				Expect(input.LaunchTemplateConfigs).To(HaveLen(1))
 				Expect(*input.TargetCapacitySpecification.DefaultTargetCapacityType).To(Equal(v1alpha1.CapacityTypeOnDemand))
 			})
+			It("should spread pods across capacity types", func() {
+				// Setup
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				labels := map[string]string{"test": "test"}
+				topology := []v1.TopologySpreadConstraint{{
+					TopologyKey:       v1alpha1.CapacityTypeLabel,
+					WhenUnsatisfiable: v1.DoNotSchedule,
+					LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+					MaxSkew:           1,
+				}}
+				ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{Labels: labels, TopologySpreadConstraints: topology}),
+					test.UnschedulablePod(test.PodOptions{Labels: labels, TopologySpreadConstraints: topology}),
+				)
+				// Assertions
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(2))
+				capacityTypes := []string{}
+				for fakeEC2API.CalledWithCreateFleetInput.Cardinality() > 0 {
+					input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+					capacityTypes = append(capacityTypes, *input.TargetCapacitySpecification.DefaultTargetCapacityType)
+				}
+				Expect(capacityTypes).To(ConsistOf(v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand))
+			})
+			It("should not spread pods across capacity types the provisioner doesn't allow", func() {
+				// Setup
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeOnDemand}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				labels := map[string]string{"test": "test"}
+				topology := []v1.TopologySpreadConstraint{{
+					TopologyKey:       v1alpha1.CapacityTypeLabel,
+					WhenUnsatisfiable: v1.DoNotSchedule,
+					LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+					MaxSkew:           1,
+				}}
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{Labels: labels, TopologySpreadConstraints: topology}),
+					test.UnschedulablePod(test.PodOptions{Labels: labels, TopologySpreadConstraints: topology}),
+				)
+				// Assertions
+				for _, pod := range pods {
+					node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+					Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.CapacityTypeLabel, v1alpha1.CapacityTypeOnDemand))
+				}
+			})
+			It("should launch on demand if flexible to both spot and on demand without a fallback", func() {
+				// Setup
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				// Assertions
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(*input.TargetCapacitySpecification.DefaultTargetCapacityType).To(Equal(v1alpha1.CapacityTypeOnDemand))
+			})
+			It("should launch the first capacity type of the fallback", func() {
+				// Setup
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				// Assertions
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(*input.TargetCapacitySpecification.DefaultTargetCapacityType).To(Equal(v1alpha1.CapacityTypeSpot))
+			})
+			It("should fall back to on demand if spot capacity is insufficient", func() {
+				// Setup
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				fakeEC2API.InsufficientCapacityTypes.Add(v1alpha1.CapacityTypeSpot)
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				// Assertions
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(2))
+				capacityTypes := []string{}
+				for input := range fakeEC2API.CalledWithCreateFleetInput.Iter() {
+					capacityTypes = append(capacityTypes, *input.(*ec2.CreateFleetInput).TargetCapacitySpecification.DefaultTargetCapacityType)
+				}
+				Expect(capacityTypes).To(ConsistOf(v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand))
+			})
+			It("should not fall back to capacity types outside of the fallback", func() {
+				// Setup
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot}
+				fakeEC2API.InsufficientCapacityTypes.Add(v1alpha1.CapacityTypeSpot)
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				// Assertions
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+			})
+			It("should not fall back if a pod constrains the capacity type", func() {
+				// Setup
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				fakeEC2API.InsufficientCapacityTypes.Add(v1alpha1.CapacityTypeSpot)
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1alpha1.CapacityTypeLabel: v1alpha1.CapacityTypeSpot}}),
+				)
+				// Assertions
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+			})
 			It("should not schedule a pod if outside of provisioner constraints", func() {
 				// Setup
 				provider.CapacityTypes = []string{v1alpha1.CapacityTypeOnDemand}
@@ -275,6 +572,78 @@ var _ = Describe("Allocation", func() {
 				Expect(pods[0].Spec.NodeName).To(BeEmpty())
 			})
 		})
+		Context("Launch Statistics", func() {
+			It("should record successful launches", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(launchStatistics.LaunchStatistics()).To(ConsistOf(cloudprovider.LaunchStatistics{
+					InstanceType: node.Labels[v1.LabelInstanceTypeStable],
+					Zone:         "test-zone-1a",
+					CapacityType: v1alpha1.CapacityTypeOnDemand,
+					Successes:    1,
+					SuccessRate:  1,
+				}))
+			})
+			It("should record launches that failed due to insufficient capacity", func() {
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot}
+				fakeEC2API.InsufficientCapacityTypes.Add(v1alpha1.CapacityTypeSpot)
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+				statistics := launchStatistics.LaunchStatistics()
+				Expect(statistics).ToNot(BeEmpty())
+				for _, statistic := range statistics {
+					Expect(statistic.CapacityType).To(Equal(v1alpha1.CapacityTypeSpot))
+					Expect(statistic.Failures).To(Equal(1))
+					Expect(statistic.SuccessRate).To(BeZero())
+					Expect(statistic.Unavailable).To(BeTrue())
+				}
+			})
+			It("should not launch offerings that recently lacked capacity", func() {
+				launchStatistics.RecordFailure("m5.large", "test-zone-1a", v1alpha1.CapacityTypeOnDemand, true)
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+					for _, override := range launchTemplateConfig.Overrides {
+						Expect(aws.StringValue(override.InstanceType) == "m5.large" && aws.StringValue(override.SubnetId) == "test-subnet-1").To(BeFalse())
+					}
+				}
+			})
+			It("should launch offerings that lacked capacity if there are no others", func() {
+				for _, zone := range []string{"test-zone-1a", "test-zone-1b", "test-zone-1c"} {
+					launchStatistics.RecordFailure("m5.large", zone, v1alpha1.CapacityTypeOnDemand, true)
+				}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}},
+				))
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			})
+			It("should not avoid offerings whose capacity recovered", func() {
+				launchStatistics.RecordFailure("m5.large", "test-zone-1a", v1alpha1.CapacityTypeOnDemand, true)
+				launchStatistics.RecordSuccess("m5.large", "test-zone-1a", v1alpha1.CapacityTypeOnDemand)
+				Expect(launchStatistics.IsUnavailable("m5.large", "test-zone-1a", v1alpha1.CapacityTypeOnDemand)).To(BeFalse())
+				Expect(launchStatistics.LaunchStatistics()).To(ConsistOf(cloudprovider.LaunchStatistics{
+					InstanceType: "m5.large",
+					Zone:         "test-zone-1a",
+					CapacityType: v1alpha1.CapacityTypeOnDemand,
+					Successes:    1,
+					Failures:     1,
+					SuccessRate:  0.5,
+				}))
+			})
+			It("should forget outcomes outside of the window", func() {
+				launchStatistics.RecordFailure("m5.large", "test-zone-1a", v1alpha1.CapacityTypeOnDemand, true)
+				injectabletime.Now = func() time.Time { return time.Now().Add(InsufficientCapacityTTL) }
+				Expect(launchStatistics.IsUnavailable("m5.large", "test-zone-1a", v1alpha1.CapacityTypeOnDemand)).To(BeFalse())
+				injectabletime.Now = func() time.Time { return time.Now().Add(LaunchStatisticsWindow) }
+				defer func() { injectabletime.Now = time.Now }()
+				Expect(launchStatistics.LaunchStatistics()).To(BeEmpty())
+			})
+		})
 		Context("LaunchTemplates", func() {
 			It("should use same launch template for equivalent constraints", func() {
 				t1 := v1.Toleration{
@@ -345,8 +714,277 @@ var _ = Describe("Allocation", func() {
 				Expect(*launchTemplate.LaunchTemplateName).To(Equal("test-launch-template"))
 				Expect(*launchTemplate.Version).To(Equal("$Default"))
 			})
+			It("should prepull images in user data", func() {
+				// Setup
+				provider.PrepullImages = []string{"public.ecr.aws/b/image:latest", "public.ecr.aws/a/image:latest"}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				// Assertions
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				userData := ExpectUserData()
+				Expect(userData).To(ContainSubstring("\n(" +
+					"\n    ctr -n k8s.io images pull 'public.ecr.aws/a/image:latest' || true" +
+					"\n    ctr -n k8s.io images pull 'public.ecr.aws/b/image:latest' || true" +
+					"\n) &"))
+			})
+			It("should prepull private ECR images with the node's credentials", func() {
+				provider.PrepullImages = []string{"123456789012.dkr.ecr.us-west-2.amazonaws.com/image:latest"}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring(
+					"\n    ctr -n k8s.io images pull --user \"AWS:$(aws ecr get-login-password --region us-west-2)\" '123456789012.dkr.ecr.us-west-2.amazonaws.com/image:latest' || true"))
+			})
+		})
+		Context("Pod Density", func() {
+			BeforeEach(func() {
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+			})
+			It("should default to ENI limited pods", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(*node.Status.Allocatable.Pods()).To(Equal(resource.MustParse("89")))
+				Expect(ExpectUserData()).ToNot(ContainSubstring("--max-pods"))
+			})
+			It("should use the kubelet default for overlay networks", func() {
+				provider.PodDensityProfile = v1alpha1.PodDensityProfileCiliumOverlay
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(*node.Status.Allocatable.Pods()).To(Equal(resource.MustParse("110")))
+				userData := ExpectUserData()
+				Expect(userData).To(ContainSubstring("--use-max-pods false"))
+				Expect(userData).To(ContainSubstring("--max-pods=110"))
+			})
+			It("should override with the kubelet's maxPods", func() {
+				provider.PodDensityProfile = v1alpha1.PodDensityProfileCalico
+				provisioner.Spec.KubeletConfiguration = &v1alpha4.KubeletConfiguration{MaxPods: ptr.Int32(20)}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(*node.Status.Allocatable.Pods()).To(Equal(resource.MustParse("20")))
+				Expect(ExpectUserData()).To(ContainSubstring("--max-pods=20"))
+			})
+			It("should limit pods with podsPerCore", func() {
+				provider.PodsPerCore = ptr.Int32(4)
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(*node.Status.Allocatable.Pods()).To(Equal(resource.MustParse("8")))
+				userData := ExpectUserData()
+				Expect(userData).To(ContainSubstring("--pods-per-core=4"))
+				Expect(userData).ToNot(ContainSubstring("--max-pods"))
+			})
+		})
+		Context("Kubelet Configuration", func() {
+			BeforeEach(func() {
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+			})
+			It("should not pass kubelet configuration by default", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				userData := ExpectUserData()
+				Expect(userData).ToNot(ContainSubstring("--system-reserved"))
+				Expect(userData).ToNot(ContainSubstring("--kube-reserved"))
+				Expect(userData).ToNot(ContainSubstring("--eviction-hard"))
+				Expect(userData).ToNot(ContainSubstring("--cluster-dns"))
+			})
+			It("should pass reserved resources to the kubelet", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha4.KubeletConfiguration{
+					SystemReserved: v1.ResourceList{v1.ResourceMemory: resource.MustParse("200Mi"), v1.ResourceCPU: resource.MustParse("200m")},
+					KubeReserved:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+				}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				userData := ExpectUserData()
+				Expect(userData).To(ContainSubstring("--system-reserved=cpu=200m,memory=200Mi"))
+				Expect(userData).To(ContainSubstring("--kube-reserved=memory=1Gi"))
+			})
+			It("should not provision pods that don't fit after reserved resources", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha4.KubeletConfiguration{KubeReserved: v1.ResourceList{v1.ResourceMemory: resource.MustParse("7Gi")}}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}},
+				}))
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			})
+			It("should pass eviction thresholds to the kubelet", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha4.KubeletConfiguration{EvictionHard: map[string]string{"nodefs.available": "10%", "memory.available": "500Mi"}}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring("--eviction-hard=memory.available<500Mi,nodefs.available<10%"))
+			})
+			It("should not provision pods that don't fit after the memory eviction threshold", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha4.KubeletConfiguration{EvictionHard: map[string]string{"memory.available": "90%"}}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}},
+				}))
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			})
+			It("should pass cluster DNS to the kubelet", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha4.KubeletConfiguration{ClusterDNS: []string{"10.0.1.100", "10.0.1.101"}}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring("--cluster-dns=10.0.1.100,10.0.1.101"))
+			})
+			It("should pass the configured cluster DNS to the kubelet", func() {
+				clusterDNS = "10.0.2.10"
+				defer func() { clusterDNS = "" }()
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring("--cluster-dns=10.0.2.10"))
+			})
+			It("should prefer the provisioner's cluster DNS over the configured cluster DNS", func() {
+				clusterDNS = "10.0.2.10"
+				defer func() { clusterDNS = "" }()
+				provisioner.Spec.KubeletConfiguration = &v1alpha4.KubeletConfiguration{ClusterDNS: []string{"10.0.1.100"}}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring("--cluster-dns=10.0.1.100'"))
+			})
+			It("should discover cluster DNS from the kube-dns service", func() {
+				service := &v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+					Spec: v1.ServiceSpec{
+						ClusterIP: "10.0.0.53",
+						Ports:     []v1.ServicePort{{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP}},
+					},
+				}
+				ExpectCreated(env.Client, provisioner, service)
+				defer ExpectDeleted(env.Client, service)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring("--cluster-dns=10.0.0.53"))
+			})
+			It("should fail to provision if the configured cluster DNS is invalid", func() {
+				clusterDNS = "not-an-ip"
+				defer func() { clusterDNS = "" }()
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			})
+		})
+		Context("AMIs", func() {
+			It("should annotate nodes with the ami they were launched with", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.AMIIDAnnotationKey, "test-ami-id"))
+			})
+			It("should report nodes launched with an ami that's been replaced", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				amiProvider := NewAMIProvider(&fake.SSMAPI{}, fakeEC2API, kubernetes.NewForConfigOrDie(env.Config))
+				cloudProvider := &CloudProvider{amiProvider: amiProvider}
+				Expect(amiProvider.getAMIID(ctx, "test-query")).To(Equal("test-ami-id"))
+				image, replacement := cloudProvider.ReplacedImage(node)
+				Expect(replacement).To(BeEmpty())
+
+				amiProvider.ssm = &fake.SSMAPI{GetParameterOutput: &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String("test-ami-id-2")}}}
+				amiProvider.cache.Flush()
+				Expect(amiProvider.getAMIID(ctx, "test-query")).To(Equal("test-ami-id-2"))
+				image, replacement = cloudProvider.ReplacedImage(node)
+				Expect(image).To(Equal("test-ami-id"))
+				Expect(replacement).To(Equal("test-ami-id-2"))
+			})
+			It("should query ssm for the ami family", func() {
+				amiProvider := NewAMIProvider(&fake.SSMAPI{}, fakeEC2API, kubernetes.NewForConfigOrDie(env.Config))
+				amd64 := &InstanceType{InstanceTypeInfo: ec2.InstanceTypeInfo{ProcessorInfo: &ec2.ProcessorInfo{SupportedArchitectures: aws.StringSlice([]string{"x86_64"})}}}
+				arm64 := &InstanceType{InstanceTypeInfo: ec2.InstanceTypeInfo{ProcessorInfo: &ec2.ProcessorInfo{SupportedArchitectures: aws.StringSlice([]string{"arm64"})}}}
+				Expect(amiProvider.getSSMQuery(v1alpha1.AMIFamilyAL2, arm64, "1.21")).To(Equal("/aws/service/eks/optimized-ami/1.21/amazon-linux-2-arm64/recommended/image_id"))
+				Expect(amiProvider.getSSMQuery(v1alpha1.AMIFamilyBottlerocket, amd64, "1.21")).To(Equal("/aws/service/bottlerocket/aws-k8s-1.21/x86_64/latest/image_id"))
+				Expect(amiProvider.getSSMQuery(v1alpha1.AMIFamilyBottlerocket, arm64, "1.21")).To(Equal("/aws/service/bottlerocket/aws-k8s-1.21/arm64/latest/image_id"))
+			})
+			It("should launch the newest ami matching the selector", func() {
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.AMIIDAnnotationKey, "test-ami-x86"))
+				Expect(fakeEC2API.CalledWithDescribeImagesInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithDescribeImagesInput.Pop().(*ec2.DescribeImagesInput)
+				Expect(input.Filters).To(ContainElement(&ec2.Filter{
+					Name:   aws.String("tag:Name"),
+					Values: aws.StringSlice([]string{provider.AMISelector["Name"]}),
+				}))
+			})
+			It("should launch the selected ami matching the instance type's architecture", func() {
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				provisioner.Spec.InstanceTypes = []string{"c6g.large"}
+				provisioner.Spec.Architectures = []string{v1alpha4.ArchitectureArm64}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.AMIIDAnnotationKey, "test-ami-arm64"))
+			})
+			It("should not launch instance types without a selected ami for their architecture", func() {
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				fakeEC2API.DescribeImagesOutput = &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+					{ImageId: aws.String("test-ami-arm64"), Architecture: aws.String("arm64"), CreationDate: aws.String("2021-06-01T00:00:00.000Z")},
+				}}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(0))
+			})
+			It("should configure bottlerocket with toml settings", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				provisioner.Spec.Labels = map[string]string{"test-label": "test-value"}
+				provisioner.Spec.Taints = []v1.Taint{{Key: "test-taint", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					Tolerations: []v1.Toleration{{Key: "test-taint", Operator: v1.TolerationOpExists}},
+				}))
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				userData := ExpectUserData()
+				Expect(userData).To(HavePrefix("[settings.kubernetes]\n"))
+				Expect(userData).To(ContainSubstring(`cluster-name = "test-cluster"`))
+				Expect(userData).To(ContainSubstring("[settings.kubernetes.node-labels]\n\"test-label\" = \"test-value\""))
+				Expect(userData).To(ContainSubstring("[settings.kubernetes.node-taints]\n\"test-taint\" = [\"test-value:NoSchedule\"]"))
+				Expect(userData).ToNot(ContainSubstring("bootstrap.sh"))
+			})
+			It("should pass custom user data through with its template variables", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyCustom)
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				provider.UserData = aws.String("#!/bin/bash\n/opt/bootstrap '{{ .ClusterName }}' '{{ .ClusterEndpoint }}' --labels '{{ .Labels }}' --taints '{{ .Taints }}'")
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				provisioner.Spec.Labels = map[string]string{"test-label": "test-value"}
+				provisioner.Spec.Taints = []v1.Taint{{Key: "test-taint", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					Tolerations: []v1.Toleration{{Key: "test-taint", Operator: v1.TolerationOpExists}},
+				}))
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				userData := ExpectUserData()
+				Expect(userData).To(HavePrefix("#!/bin/bash\n/opt/bootstrap 'test-cluster' "))
+				Expect(userData).To(ContainSubstring("test-label=test-value"))
+				Expect(userData).To(ContainSubstring("--taints 'test-taint=test-value:NoSchedule'"))
+				Expect(userData).ToNot(ContainSubstring("bootstrap.sh"))
+			})
 		})
 		Context("Subnets", func() {
+			It("should not launch instance types that aren't offered in the subnets' zones", func() {
+				provider.SubnetSelector = map[string]string{"Name": "test-subnet-unknown-zone"}
+				fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+					{SubnetId: aws.String("test-subnet-4"), AvailabilityZone: aws.String("test-zone-1z")},
+				}}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(0))
+			})
 			It("should default to the cluster's subnets", func() {
 				// Setup
 				provisioner.Spec.InstanceTypes = []string{"m5.large"} // limit instance type to simplify ConsistOf checks
@@ -363,6 +1001,46 @@ var _ = Describe("Allocation", func() {
 					&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-3"), InstanceType: aws.String("m5.large")},
 				))
 			})
+			It("should select subnets by ID", func() {
+				provider.SubnetSelector = map[string]string{v1alpha1.SubnetIDsSelectorKey: "test-subnet-1, test-subnet-2"}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(fakeEC2API.CalledWithDescribeSubnetsInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithDescribeSubnetsInput.Pop().(*ec2.DescribeSubnetsInput)
+				Expect(input.Filters).To(ContainElement(&ec2.Filter{
+					Name:   aws.String("subnet-id"),
+					Values: aws.StringSlice([]string{"test-subnet-1", "test-subnet-2"}),
+				}))
+			})
+			It("should not launch into excluded subnets", func() {
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				provider.ExcludedSubnets = []string{"test-subnet-2"}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(input.LaunchTemplateConfigs[0].Overrides).To(ConsistOf(
+					&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-1"), InstanceType: aws.String("m5.large")},
+					&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-3"), InstanceType: aws.String("m5.large")},
+				))
+			})
+			It("should launch into one subnet per zone, chosen by weight", func() {
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				provider.SubnetSelector = map[string]string{"Name": "test-subnet-weighted"}
+				provider.SubnetWeights = map[string]int32{"test-subnet-2": 1000000}
+				fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+					{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a")},
+					{SubnetId: aws.String("test-subnet-2"), AvailabilityZone: aws.String("test-zone-1a")},
+				}}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(input.LaunchTemplateConfigs[0].Overrides).To(ConsistOf(
+					&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-2"), InstanceType: aws.String("m5.large")},
+				))
+			})
 		})
 		Context("Security Groups", func() {
 			It("should default to the clusters security groups", func() {
@@ -380,6 +1058,383 @@ var _ = Describe("Allocation", func() {
 				))
 			})
 		})
+		Context("Cluster Endpoint", func() {
+			It("should use the provisioner's endpoint", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring("--apiserver-endpoint 'https://test-cluster'"))
+				Expect(fakeEKSAPI.CalledWithDescribeClusterInput.Cardinality()).To(Equal(0))
+			})
+			It("should discover the endpoint from EKS if not specified", func() {
+				provider.Cluster.Endpoint = ""
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectUserData()).To(ContainSubstring("--apiserver-endpoint 'https://test-cluster.eks.test'"))
+				Expect(fakeEKSAPI.CalledWithDescribeClusterInput.Cardinality()).To(Equal(1))
+			})
+			It("should cache discovered clusters", func() {
+				info, err := clusterProvider.Get(ctx, v1alpha1.Cluster{Name: "test-cluster"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(info.Endpoint).To(Equal("https://test-cluster.eks.test"))
+				Expect(info.CABundle).To(Equal(aws.String("dGVzdC1jYS1idW5kbGU=")))
+				_, err = clusterProvider.Get(ctx, v1alpha1.Cluster{Name: "test-cluster"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeEKSAPI.CalledWithDescribeClusterInput.Cardinality()).To(Equal(1))
+			})
+			It("should prefer the configured endpoint over EKS", func() {
+				clusterEndpoint = "https://configured-endpoint"
+				defer func() { clusterEndpoint = "" }()
+				info, err := clusterProvider.Get(ctx, v1alpha1.Cluster{Name: "test-cluster"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(info.Endpoint).To(Equal("https://configured-endpoint"))
+				Expect(fakeEKSAPI.CalledWithDescribeClusterInput.Cardinality()).To(Equal(0))
+			})
+			It("should fail to provision if the cluster can't be discovered", func() {
+				fakeEKSAPI.WantErr = fmt.Errorf("test error")
+				provider.Cluster.Endpoint = ""
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(0))
+			})
+		})
+		Context("Block Device Mappings", func() {
+			ExpectBlockDeviceMappings := func() []*ec2.LaunchTemplateBlockDeviceMappingRequest {
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				return input.LaunchTemplateData.BlockDeviceMappings
+			}
+			It("should default the root volume with the global settings", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectBlockDeviceMappings()).To(ConsistOf(&ec2.LaunchTemplateBlockDeviceMappingRequest{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+						VolumeType:          aws.String(ec2.VolumeTypeGp3),
+						VolumeSize:          aws.Int64(20),
+						Encrypted:           aws.Bool(false),
+						DeleteOnTermination: aws.Bool(true),
+					},
+				}))
+			})
+			It("should default the data volume of bottlerocket amis", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				mappings := ExpectBlockDeviceMappings()
+				Expect(mappings).To(HaveLen(1))
+				Expect(mappings[0].DeviceName).To(Equal(aws.String("/dev/xvdb")))
+			})
+			It("should default the root volume of selected amis", func() {
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				provisioner.Spec.InstanceTypes = []string{"m5.large"}
+				fakeEC2API.DescribeImagesOutput = &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+					{ImageId: aws.String("test-ami"), Architecture: aws.String("x86_64"), CreationDate: aws.String("2021-06-01T00:00:00.000Z"), RootDeviceName: aws.String("/dev/sda1")},
+				}}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				mappings := ExpectBlockDeviceMappings()
+				Expect(mappings).To(HaveLen(1))
+				Expect(mappings[0].DeviceName).To(Equal(aws.String("/dev/sda1")))
+			})
+			It("should encrypt the root volume with the global kms key", func() {
+				volumeOptions.KMSKeyID = "test-key"
+				defer func() { volumeOptions.KMSKeyID = "" }()
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				mappings := ExpectBlockDeviceMappings()
+				Expect(mappings).To(HaveLen(1))
+				Expect(mappings[0].Ebs.Encrypted).To(Equal(aws.Bool(true)))
+				Expect(mappings[0].Ebs.KmsKeyId).To(Equal(aws.String("test-key")))
+			})
+			It("should use the provisioner's block device mappings instead of the global settings", func() {
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{
+					DeviceName: "/dev/xvdb",
+					EBS:        &v1alpha1.BlockDevice{VolumeType: aws.String(ec2.VolumeTypeIo2), VolumeSize: aws.Int64(100), IOPS: aws.Int64(1000)},
+				}}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectBlockDeviceMappings()).To(ConsistOf(&ec2.LaunchTemplateBlockDeviceMappingRequest{
+					DeviceName: aws.String("/dev/xvdb"),
+					Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+						VolumeType:          aws.String(ec2.VolumeTypeIo2),
+						VolumeSize:          aws.Int64(100),
+						Iops:                aws.Int64(1000),
+						DeleteOnTermination: aws.Bool(true),
+					},
+				}))
+			})
+		})
+		Context("Metadata Options", func() {
+			ExpectMetadataOptions := func() *ec2.LaunchTemplateInstanceMetadataOptionsRequest {
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				return input.LaunchTemplateData.MetadataOptions
+			}
+			It("should require IMDSv2 by default", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectMetadataOptions()).To(Equal(&ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+					HttpEndpoint:            aws.String(ec2.LaunchTemplateInstanceMetadataEndpointStateEnabled),
+					HttpTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateRequired),
+					HttpPutResponseHopLimit: aws.Int64(2),
+				}))
+			})
+			It("should use the provisioner's metadata options", func() {
+				provider.MetadataOptions = &v1alpha1.MetadataOptions{
+					HTTPTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateOptional),
+					HTTPPutResponseHopLimit: aws.Int64(1),
+				}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(ExpectMetadataOptions()).To(Equal(&ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+					HttpEndpoint:            aws.String(ec2.LaunchTemplateInstanceMetadataEndpointStateEnabled),
+					HttpTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateOptional),
+					HttpPutResponseHopLimit: aws.Int64(1),
+				}))
+			})
+		})
+		Context("Tags", func() {
+			ExpectTagSpecifications := func() *ec2.CreateLaunchTemplateInput {
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				return fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+			}
+			It("should tag instances, volumes and launch templates with discovery tags by default", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				input := ExpectTagSpecifications()
+				expected := []*ec2.Tag{
+					{Key: aws.String("Name"), Value: aws.String(fmt.Sprintf("Karpenter/%s", provider.Cluster.Name))},
+					{Key: aws.String(fmt.Sprintf(KarpenterTagKeyFormat, provider.Cluster.Name)), Value: aws.String("owned")},
+					{Key: aws.String(fmt.Sprintf(ClusterTagKeyFormat, provider.Cluster.Name)), Value: aws.String("owned")},
+				}
+				Expect(input.TagSpecifications).To(ConsistOf(&ec2.TagSpecification{ResourceType: aws.String(ec2.ResourceTypeLaunchTemplate), Tags: expected}))
+				Expect(input.LaunchTemplateData.TagSpecifications).To(ConsistOf(
+					&ec2.LaunchTemplateTagSpecificationRequest{ResourceType: aws.String(ec2.ResourceTypeInstance), Tags: expected},
+					&ec2.LaunchTemplateTagSpecificationRequest{ResourceType: aws.String(ec2.ResourceTypeVolume), Tags: expected},
+				))
+			})
+			It("should add the provisioner's tags to the discovery tags", func() {
+				provider.Tags = map[string]string{"team": "platform", "Name": "custom-name"}
+				ExpectCreated(env.Client, ProvisionerWithProvider(provisioner, provider))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				input := ExpectTagSpecifications()
+				expected := []*ec2.Tag{
+					{Key: aws.String("Name"), Value: aws.String("custom-name")},
+					{Key: aws.String(fmt.Sprintf(KarpenterTagKeyFormat, provider.Cluster.Name)), Value: aws.String("owned")},
+					{Key: aws.String(fmt.Sprintf(ClusterTagKeyFormat, provider.Cluster.Name)), Value: aws.String("owned")},
+					{Key: aws.String("team"), Value: aws.String("platform")},
+				}
+				Expect(input.TagSpecifications[0].Tags).To(Equal(expected))
+				for _, specification := range input.LaunchTemplateData.TagSpecifications {
+					Expect(specification.Tags).To(Equal(expected))
+				}
+			})
+		})
+	})
+	Context("Audit Log", func() {
+		var buffer *bytes.Buffer
+		var auditLogger *AuditLogger
+		BeforeEach(func() {
+			buffer = &bytes.Buffer{}
+			auditLogger = &AuditLogger{ctx: ctx, writer: buffer}
+		})
+		ExpectRecorded := func(r *request.Request) *AuditRecord {
+			auditLogger.record(r)
+			record := &AuditRecord{}
+			Expect(json.Unmarshal(buffer.Bytes(), record)).To(Succeed())
+			return record
+		}
+		It("should record mutating calls with redacted requests and resource ids", func() {
+			record := ExpectRecorded(&request.Request{
+				ClientInfo: metadata.ClientInfo{ServiceName: ec2.ServiceName},
+				Operation:  &request.Operation{Name: "CreateLaunchTemplate"},
+				Time:       time.Now(),
+				Params: &ec2.CreateLaunchTemplateInput{
+					LaunchTemplateName: aws.String("test-launch-template"),
+					LaunchTemplateData: &ec2.RequestLaunchTemplateData{UserData: aws.String("test-user-data")},
+				},
+				Data: &ec2.CreateLaunchTemplateOutput{LaunchTemplate: &ec2.LaunchTemplate{LaunchTemplateId: aws.String("lt-1234")}},
+			})
+			Expect(record.Service).To(Equal(ec2.ServiceName))
+			Expect(record.Operation).To(Equal("CreateLaunchTemplate"))
+			Expect(record.ResourceIDs).To(ConsistOf("lt-1234"))
+			Expect(record.Request).To(HaveKeyWithValue("LaunchTemplateName", "test-launch-template"))
+			Expect(buffer.String()).ToNot(ContainSubstring("test-user-data"))
+		})
+		It("should record errors", func() {
+			record := ExpectRecorded(&request.Request{
+				Operation: &request.Operation{Name: "TerminateInstances"},
+				Time:      time.Now(),
+				Params:    &ec2.TerminateInstancesInput{InstanceIds: aws.StringSlice([]string{"i-1234"})},
+				Error:     awserr.New("UnauthorizedOperation", "test error", nil),
+			})
+			Expect(record.Error).To(ContainSubstring("UnauthorizedOperation"))
+		})
+		It("should not record read only or dry run calls", func() {
+			auditLogger.record(&request.Request{Operation: &request.Operation{Name: "DescribeInstances"}, Params: &ec2.DescribeInstancesInput{}})
+			auditLogger.record(&request.Request{Operation: &request.Operation{Name: "ReceiveMessage"}, Params: &sqs.ReceiveMessageInput{}})
+			auditLogger.record(&request.Request{Operation: &request.Operation{Name: "CreateFleet"}, Params: &ec2.CreateFleetInput{DryRun: aws.Bool(true)}})
+			Expect(buffer.Len()).To(Equal(0))
+		})
+	})
+	Context("Endpoints", func() {
+		It("should resolve endpoints in the region's partition by default", func() {
+			resolver, err := EndpointOptions{}.Resolver()
+			Expect(err).ToNot(HaveOccurred())
+			endpoint, err := resolver.EndpointFor("ec2", "cn-north-1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(endpoint.URL).To(Equal("https://ec2.cn-north-1.amazonaws.com.cn"))
+		})
+		It("should resolve endpoints in a configured partition", func() {
+			resolver, err := EndpointOptions{Partition: "aws-cn"}.Resolver()
+			Expect(err).ToNot(HaveOccurred())
+			endpoint, err := resolver.EndpointFor("ssm", "test-region-1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(endpoint.URL).To(Equal("https://ssm.test-region-1.amazonaws.com.cn"))
+		})
+		It("should fail for unknown partitions", func() {
+			_, err := EndpointOptions{Partition: "unknown"}.Resolver()
+			Expect(err).To(HaveOccurred())
+		})
+		It("should prefer custom endpoints", func() {
+			resolver, err := EndpointOptions{
+				Endpoints:       map[string]string{"ec2": "https://vpce-123.ec2.us-west-2.vpce.amazonaws.com"},
+				UseFIPSEndpoint: true,
+			}.Resolver()
+			Expect(err).ToNot(HaveOccurred())
+			endpoint, err := resolver.EndpointFor("ec2", "us-west-2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(endpoint.URL).To(Equal("https://vpce-123.ec2.us-west-2.vpce.amazonaws.com"))
+			Expect(endpoint.SigningRegion).To(Equal("us-west-2"))
+		})
+		It("should resolve fips endpoints", func() {
+			resolver, err := EndpointOptions{UseFIPSEndpoint: true}.Resolver()
+			Expect(err).ToNot(HaveOccurred())
+			endpoint, err := resolver.EndpointFor("ec2", "us-west-2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(endpoint.URL).To(Equal("https://ec2-fips.us-west-2.amazonaws.com"))
+		})
+	})
+	Context("Permissions", func() {
+		var amiProvider *AMIProvider
+		BeforeEach(func() {
+			amiProvider = NewAMIProvider(&fake.SSMAPI{}, fakeEC2API, kubernetes.NewForConfigOrDie(env.Config))
+		})
+		It("should pass if all permissions are granted", func() {
+			permissionsProvider := NewPermissionsProvider(fakeEC2API, &fake.SSMAPI{}, amiProvider)
+			Expect(permissionsProvider.ReadinessProbe(nil)).ToNot(Succeed())
+			Expect(permissionsProvider.Check(ctx)).To(Succeed())
+			Expect(permissionsProvider.ReadinessProbe(nil)).To(Succeed())
+			Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(BeZero())
+		})
+		It("should report missing permissions without failing readiness", func() {
+			fakeEC2API.UnauthorizedOperations.Add("CreateFleet")
+			permissionsProvider := NewPermissionsProvider(fakeEC2API, &fake.SSMAPI{
+				WantErr: awserr.New("AccessDeniedException", "not authorized", nil),
+			}, amiProvider)
+			Expect(permissionsProvider.Check(ctx)).To(MatchError("missing permissions ec2:CreateFleet, ssm:GetParameter"))
+			Expect(permissionsProvider.ReadinessProbe(nil)).To(Succeed())
+
+			ExpectCreated(env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, provisioners.NewController(env.Client, &CloudProvider{permissionsProvider: permissionsProvider}), client.ObjectKeyFromObject(provisioner))
+			persisted := &v1alpha4.Provisioner{}
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), persisted)).To(Succeed())
+			condition := persisted.StatusConditions().GetCondition(v1alpha4.CloudProviderPermitted)
+			Expect(condition.IsFalse()).To(BeTrue())
+			Expect(condition.Message).To(Equal("Missing ec2:CreateFleet, ssm:GetParameter"))
+
+			fakeEC2API.UnauthorizedOperations.Remove("CreateFleet")
+			permissionsProvider.ssm = &fake.SSMAPI{}
+			Expect(permissionsProvider.Check(ctx)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, provisioners.NewController(env.Client, &CloudProvider{permissionsProvider: permissionsProvider}), client.ObjectKeyFromObject(provisioner))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), persisted)).To(Succeed())
+			Expect(persisted.StatusConditions().GetCondition(v1alpha4.CloudProviderPermitted).IsTrue()).To(BeTrue())
+		})
+		It("should ignore inconclusive errors", func() {
+			permissionsProvider := NewPermissionsProvider(fakeEC2API, &fake.SSMAPI{
+				WantErr: awserr.New("ParameterNotFound", "not found", nil),
+			}, amiProvider)
+			Expect(permissionsProvider.Check(ctx)).To(Succeed())
+		})
+	})
+	Context("Interruptions", func() {
+		message := func(receiptHandle string, detailType string, detail string) *sqs.Message {
+			return &sqs.Message{
+				MessageId:     aws.String(receiptHandle),
+				ReceiptHandle: aws.String(receiptHandle),
+				Body:          aws.String(fmt.Sprintf(`{"source":"aws.ec2","detail-type":%q,"detail":%s}`, detailType, detail)),
+			}
+		}
+		It("should receive spot interruptions, rebalance recommendations and stopping instances", func() {
+			interruptionProvider := NewInterruptionProvider(&fake.SQSAPI{Messages: []*sqs.Message{
+				message("spot", "EC2 Spot Instance Interruption Warning", `{"instance-id":"i-1","instance-action":"terminate"}`),
+				message("rebalance", "EC2 Instance Rebalance Recommendation", `{"instance-id":"i-2"}`),
+				message("stopping", "EC2 Instance State-change Notification", `{"instance-id":"i-3","state":"stopping"}`),
+			}}, "test-queue")
+			interruptions, err := interruptionProvider.Get(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interruptions).To(ConsistOf(
+				&cloudprovider.Interruption{InstanceID: "i-1", Reason: cloudprovider.SpotInterruption, Handle: "spot"},
+				&cloudprovider.Interruption{InstanceID: "i-2", Reason: cloudprovider.RebalanceRecommendation, Handle: "rebalance"},
+				&cloudprovider.Interruption{InstanceID: "i-3", Reason: cloudprovider.InstanceStopping, Handle: "stopping"},
+			))
+		})
+		It("should delete messages that aren't interruptions", func() {
+			sqsapi := &fake.SQSAPI{Messages: []*sqs.Message{
+				message("running", "EC2 Instance State-change Notification", `{"instance-id":"i-1","state":"running"}`),
+				{MessageId: aws.String("malformed"), ReceiptHandle: aws.String("malformed"), Body: aws.String("malformed")},
+				message("spot", "EC2 Spot Instance Interruption Warning", `{"instance-id":"i-2","instance-action":"terminate"}`),
+			}}
+			interruptionProvider := NewInterruptionProvider(sqsapi, "test-queue")
+			interruptions, err := interruptionProvider.Get(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interruptions).To(HaveLen(1))
+			Expect(sqsapi.DeletedMessages).To(ConsistOf("running", "malformed"))
+			Expect(interruptionProvider.Acknowledge(ctx, interruptions[0])).To(Succeed())
+			Expect(sqsapi.DeletedMessages).To(ConsistOf("running", "malformed", "spot"))
+		})
+		It("should block until the context is done if no queue is configured", func() {
+			timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			interruptions, err := NewInterruptionProvider(&fake.SQSAPI{}, "").Get(timeoutCtx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interruptions).To(BeEmpty())
+		})
+	})
+	Context("Lifecycle Events", func() {
+		event := &lifecycle.Event{Phase: lifecycle.Created, Node: "test-node"}
+		It("should not return sinks if none are configured", func() {
+			Expect(NewLifecycleSinks(&fake.SNSAPI{}, &fake.SQSAPI{}, LifecycleOptions{})).To(BeEmpty())
+		})
+		It("should publish events to the topic and queue", func() {
+			snsapi, sqsapi := &fake.SNSAPI{}, &fake.SQSAPI{}
+			sinks := NewLifecycleSinks(snsapi, sqsapi, LifecycleOptions{TopicARN: "arn:aws:sns:test-region:123456789012:test-topic", Queue: "test-queue"})
+			Expect(sinks).To(HaveLen(2))
+			for _, sink := range sinks {
+				Expect(sink.Publish(ctx, event)).To(Succeed())
+			}
+			Expect(snsapi.PublishedInputs).To(HaveLen(1))
+			Expect(aws.StringValue(snsapi.PublishedInputs[0].TopicArn)).To(Equal("arn:aws:sns:test-region:123456789012:test-topic"))
+			Expect(aws.StringValue(snsapi.PublishedInputs[0].MessageAttributes["phase"].StringValue)).To(Equal("Created"))
+			Expect(sqsapi.SentMessages).To(HaveLen(1))
+			Expect(aws.StringValue(sqsapi.SentMessages[0].QueueUrl)).To(Equal("https://sqs.test-region.amazonaws.com/123456789012/test-queue"))
+			published := &lifecycle.Event{}
+			Expect(json.Unmarshal([]byte(aws.StringValue(sqsapi.SentMessages[0].MessageBody)), published)).To(Succeed())
+			Expect(published.Node).To(Equal("test-node"))
+		})
 	})
 	Context("Defaulting", func() {
 		It("should default subnetSelector", func() {
@@ -400,19 +1455,36 @@ var _ = Describe("Allocation", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(constraints.CapacityTypes).To(ConsistOf(v1alpha1.CapacityTypeOnDemand))
 		})
+		It("should default capacityTypeFallback to spot, then on demand, if flexible to both", func() {
+			provider.CapacityTypes = []string{v1alpha1.CapacityTypeOnDemand, v1alpha1.CapacityTypeSpot}
+			provisioner := ProvisionerWithProvider(provisioner, provider)
+			provisioner.SetDefaults(ctx)
+			constraints, err := v1alpha1.NewConstraints(&provisioner.Spec.Constraints)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(constraints.CapacityTypeFallback).To(Equal([]string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}))
+		})
+		It("should not default capacityTypeFallback for a single capacity type", func() {
+			provisioner.SetDefaults(ctx)
+			constraints, err := v1alpha1.NewConstraints(&provisioner.Spec.Constraints)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(constraints.CapacityTypeFallback).To(BeNil())
+		})
 	})
 	Context("Validation", func() {
 		Context("Cluster", func() {
-			It("should fail if fields are empty", func() {
+			It("should fail if name is empty", func() {
 				for _, cluster := range []v1alpha1.Cluster{
 					{Endpoint: "https://test-cluster"},
-					{Name: "test-cluster"},
 					{},
 				} {
 					provisioner = ProvisionerWithProvider(provisioner, &v1alpha1.AWS{Cluster: cluster})
 					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 				}
 			})
+			It("should succeed without an endpoint", func() {
+				provider.Cluster.Endpoint = ""
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
 			It("should fail for invalid endpoint", func() {
 				for _, endpoint := range []string{
 					"http",
@@ -440,6 +1512,30 @@ var _ = Describe("Allocation", func() {
 					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 				}
 			})
+			It("should not allow empty subnet IDs", func() {
+				for _, value := range []string{"test-subnet-1,", " , test-subnet-1"} {
+					provider.SubnetSelector = map[string]string{v1alpha1.SubnetIDsSelectorKey: value}
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should allow subnet weights and exclusions", func() {
+				provider.SubnetWeights = map[string]int32{"test-subnet-1": 3}
+				provider.ExcludedSubnets = []string{"test-subnet-2"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow subnet weights less than 1", func() {
+				provider.SubnetWeights = map[string]int32{"test-subnet-1": 0}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow empty excluded subnets", func() {
+				provider.ExcludedSubnets = []string{""}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
 		})
 		Context("SecurityGroupSelector", func() {
 			It("should not allow empty string keys or values", func() {
@@ -453,6 +1549,178 @@ var _ = Describe("Allocation", func() {
 				}
 			})
 		})
+		Context("PodDensity", func() {
+			It("should fail for unknown profiles", func() {
+				provider.PodDensityProfile = "unknown"
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail for non positive podsPerCore", func() {
+				provider.PodsPerCore = ptr.Int32(-1)
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should succeed for supported profiles", func() {
+				for _, profile := range v1alpha1.PodDensityProfiles {
+					provider.PodDensityProfile = profile
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+				}
+			})
+		})
+		Context("BlockDeviceMappings", func() {
+			It("should fail for missing device names or volumes", func() {
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{EBS: &v1alpha1.BlockDevice{}}}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{DeviceName: "/dev/xvda"}}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail for invalid volumes", func() {
+				for _, device := range []*v1alpha1.BlockDevice{
+					{VolumeType: aws.String("unknown")},
+					{VolumeSize: aws.Int64(0)},
+					{IOPS: aws.Int64(-1)},
+					{KMSKeyID: aws.String("test-key")},
+				} {
+					provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{DeviceName: "/dev/xvda", EBS: device}}
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should succeed for valid volumes", func() {
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{DeviceName: "/dev/xvda", EBS: &v1alpha1.BlockDevice{
+					VolumeType: aws.String(ec2.VolumeTypeGp3), VolumeSize: aws.Int64(50), Throughput: aws.Int64(250), Encrypted: aws.Bool(true), KMSKeyID: aws.String("test-key"),
+				}}}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+		})
+		Context("AMIs", func() {
+			It("should default to the AL2 family", func() {
+				constraints, err := v1alpha1.NewConstraints(&ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(constraints.GetAMIFamily()).To(Equal(v1alpha1.AMIFamilyAL2))
+			})
+			It("should fail if the family is not supported", func() {
+				provider.AMIFamily = aws.String("unknown")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should support each family", func() {
+				for _, family := range v1alpha1.AMIFamilies {
+					provider.AMIFamily = aws.String(family)
+					provider.AMISelector, provider.UserData = nil, nil
+					if family == v1alpha1.AMIFamilyCustom {
+						provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+						provider.UserData = aws.String("#!/bin/bash")
+					}
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should fail for custom without an ami selector or user data", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyCustom)
+				provider.UserData = aws.String("#!/bin/bash")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				provider.UserData = nil
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail for custom with an invalid user data template", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyCustom)
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				provider.UserData = aws.String("#!/bin/bash\n{{ .ClusterName ")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				provider.UserData = aws.String("#!/bin/bash\n{{ .UnknownVariable }}")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail for user data without the custom family", func() {
+				provider.UserData = aws.String("#!/bin/bash")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail for empty selectors", func() {
+				for _, selector := range []map[string]string{{}, {"": "test"}, {"Name": ""}, {v1alpha1.AMIIDsSelectorKey: " , "}} {
+					provider.AMISelector = selector
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should fail if combined with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.AMISelector = map[string]string{"Name": randomdata.SillyName()}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail for bottlerocket with prepulled images", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.PrepullImages = []string{"public.ecr.aws/a/image:latest"}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("MetadataOptions", func() {
+			It("should fail for unsupported values", func() {
+				for _, options := range []*v1alpha1.MetadataOptions{
+					{HTTPEndpoint: aws.String("unknown")},
+					{HTTPTokens: aws.String("unknown")},
+					{HTTPPutResponseHopLimit: aws.Int64(0)},
+					{HTTPPutResponseHopLimit: aws.Int64(65)},
+				} {
+					provider.MetadataOptions = options
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should succeed for supported values", func() {
+				provider.MetadataOptions = &v1alpha1.MetadataOptions{
+					HTTPEndpoint:            aws.String(ec2.LaunchTemplateInstanceMetadataEndpointStateDisabled),
+					HTTPTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateOptional),
+					HTTPPutResponseHopLimit: aws.Int64(64),
+				}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should fail if combined with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.MetadataOptions = &v1alpha1.MetadataOptions{HTTPTokens: aws.String(ec2.LaunchTemplateHttpTokensStateRequired)}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("Tags", func() {
+			It("should succeed for user tags", func() {
+				provider.Tags = map[string]string{"team": "platform", "cost-center": "", "Name": "custom-name"}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should fail for reserved or invalid tags", func() {
+				for _, tags := range []map[string]string{
+					{"": "empty"},
+					{"kubernetes.io/cluster/test-cluster": "owned"},
+					{"karpenter.sh/cluster/test-cluster": "owned"},
+					{"aws:cloudformation:stack-name": "test"},
+					{strings.Repeat("k", v1alpha1.MaxTagKeyLength+1): "long"},
+					{"team": strings.Repeat("v", v1alpha1.MaxTagValueLength+1)},
+				} {
+					provider.Tags = tags
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should fail if combined with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.Tags = map[string]string{"team": "platform"}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("PrepullImages", func() {
+			It("should not allow empty or unsafe images", func() {
+				for _, image := range []string{"", "image:latest'; reboot", "$(echo foo)", "image latest"} {
+					provider.PrepullImages = []string{image}
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should not allow images without a registry", func() {
+				for _, image := range []string{"nginx:1.21", "library/nginx@sha256:abcdef"} {
+					provider.PrepullImages = []string{image}
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should allow image references", func() {
+				provider.PrepullImages = []string{
+					"public.ecr.aws/eks-distro/kubernetes/pause:3.2",
+					"docker.io/library/nginx@sha256:abcdef",
+					"123456789012.dkr.ecr.us-west-2.amazonaws.com/image:latest",
+					"localhost:5000/image",
+				}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+		})
 		Context("Labels", func() {
 			It("should not allow labels with the aws label prefix", func() {
 				provisioner.Spec.Labels = map[string]string{"node.k8s.aws/foo": randomdata.SillyName()}
@@ -527,10 +1795,40 @@ var _ = Describe("Allocation", func() {
 				provider.CapacityTypes = []string{"on demand"}
 				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
 			})
+			It("should support a fallback of the provisioner's capacity types", func() {
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should fail if the fallback contains other capacity types", func() {
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail if the fallback contains duplicates", func() {
+				provider.CapacityTypes = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}
+				provider.CapacityTypeFallback = []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeSpot}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail if the fallback contains unsupported capacity types", func() {
+				provider.CapacityTypeFallback = []string{"unknown"}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
 		})
 	})
 })
 
+// ExpectUserData returns the decoded user data of the one launch template created
+func ExpectUserData() string {
+	Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+	input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+	userData, err := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+	Expect(err).ToNot(HaveOccurred())
+	return string(userData)
+}
+
 func ProvisionerWithProvider(provisioner *v1alpha4.Provisioner, provider *v1alpha1.AWS) *v1alpha4.Provisioner {
 	raw, err := json.Marshal(provider)
 	Expect(err).ToNot(HaveOccurred())
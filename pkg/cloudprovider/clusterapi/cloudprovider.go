@@ -0,0 +1,379 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// MachineAnnotationKey is set by Cluster API on nodes to the name of their machine
+	MachineAnnotationKey = "cluster.x-k8s.io/machine"
+	// ClusterNamespaceAnnotationKey is set by Cluster API on nodes to the
+	// namespace of their cluster, which is also the namespace of their machine
+	ClusterNamespaceAnnotationKey = "cluster.x-k8s.io/cluster-namespace"
+	// DeploymentNameLabelKey is set by Cluster API on machines to the name of
+	// their MachineDeployment
+	DeploymentNameLabelKey = "cluster.x-k8s.io/deployment-name"
+	// DeleteMachineAnnotationKey marks machines to be deleted first when their
+	// MachineDeployment is scaled down
+	DeleteMachineAnnotationKey = "cluster.x-k8s.io/delete-machine"
+	// ScaledDownAnnotationKey is set on MachineDeployments to the machines
+	// they've been scaled down for, so that retried deletes scale down once
+	ScaledDownAnnotationKey = "karpenter.sh/scaled-down-machines"
+)
+
+var (
+	MachineDeploymentResource = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1alpha4", Resource: "machinedeployments"}
+	MachineResource           = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1alpha4", Resource: "machines"}
+	// machinePollInterval and machineTimeout bound how long launches wait for
+	// the MachineSet controller to create machines once scaled up
+	machinePollInterval = time.Second
+	machineTimeout      = time.Minute
+)
+
+// CloudProvider launches nodes by scaling Cluster API MachineDeployments,
+// each of which launches an instance type in a zone. Nodes are named after
+// their machines, so infrastructure providers must register nodes with the
+// names of their machines, as kubeadm does by default for providers that
+// name hosts after machines.
+type CloudProvider struct {
+	instanceTypes []*InstanceType
+	dynamicClient dynamic.Interface
+	// claimed are the machines claimed by launches from each
+	// MachineDeployment, so that concurrent launches don't claim each other's
+	// machines
+	claimed map[MachineDeploymentReference]sets.String
+	mu      sync.Mutex
+}
+
+func NewCloudProvider(ctx context.Context, options cloudprovider.Options, instanceTypes []*InstanceType) *CloudProvider {
+	logging.FromContext(ctx).Infof("Launching %d instance types with Cluster API machine deployments", len(instanceTypes))
+	return &CloudProvider{instanceTypes: instanceTypes, dynamicClient: dynamic.NewForConfigOrDie(options.RESTConfig)}
+}
+
+func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) chan error {
+	err := make(chan error, 1)
+	go func() {
+		err <- c.create(ctx, constraints, instanceTypes, quantity, callback)
+	}()
+	return err
+}
+
+func (c *CloudProvider) create(ctx context.Context, constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) error {
+	instanceType, zones := selectInstanceType(constraints, instanceTypes)
+	if instanceType == nil {
+		return fmt.Errorf("no machine deployments launch the instance type options in zones %v", constraints.Zones)
+	}
+	// Spread nodes across the allowed zones
+	counts := map[MachineDeploymentReference]int{}
+	for i := 0; i < quantity; i++ {
+		machineDeployment, _ := instanceType.MachineDeploymentFor(zones[i%len(zones)])
+		counts[machineDeployment]++
+	}
+	for machineDeployment, count := range counts {
+		// Nodes are launched for the machines that were created, even if
+		// others timed out
+		machines, err := c.scaleUp(ctx, machineDeployment, count)
+		for _, machine := range machines {
+			if err := callback(nodeFor(machine, instanceType, machineDeployment.Zone)); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectInstanceType returns the first of the instance type options with a
+// MachineDeployment in any of the allowed zones, and those zones
+func selectInstanceType(constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType) (*InstanceType, []string) {
+	for _, option := range instanceTypes {
+		instanceType, ok := option.(*InstanceType)
+		if !ok {
+			continue
+		}
+		zones := instanceType.Zones()
+		if len(constraints.Zones) != 0 {
+			zones = functional.IntersectStringSlice(constraints.Zones, zones)
+		}
+		if len(zones) != 0 {
+			sort.Strings(zones)
+			return instanceType, zones
+		}
+	}
+	return nil, nil
+}
+
+// scaleUp adds machines to the MachineDeployment and waits for the MachineSet
+// controller to create them, returning the new machines. If they aren't all
+// created in time, the replicas of the missing machines are removed again and
+// the machines that were created are returned with an error.
+func (c *CloudProvider) scaleUp(ctx context.Context, machineDeployment MachineDeploymentReference, count int) ([]*unstructured.Unstructured, error) {
+	existing, err := c.machinesOf(ctx, machineDeployment)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.scale(ctx, machineDeployment, int64(count)); err != nil {
+		return nil, err
+	}
+	created := []*unstructured.Unstructured{}
+	if err := wait.PollImmediate(machinePollInterval, machineTimeout, func() (bool, error) {
+		machines, err := c.machinesOf(ctx, machineDeployment)
+		if err != nil {
+			return false, err
+		}
+		created = append(created, c.claim(machineDeployment, existing, machines, count-len(created))...)
+		return len(created) >= count, nil
+	}); err != nil {
+		missing := count - len(created)
+		if err := c.scale(ctx, machineDeployment, -int64(missing)); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to remove %d replicas of machine deployment %s/%s, %s", missing, machineDeployment.Namespace, machineDeployment.Name, err.Error())
+		}
+		return created, fmt.Errorf("waiting for %d of %d machines of machine deployment %s/%s, %w", missing, count, machineDeployment.Namespace, machineDeployment.Name, err)
+	}
+	logging.FromContext(ctx).Infof("Scaled up machine deployment %s/%s by %d", machineDeployment.Namespace, machineDeployment.Name, count)
+	return created, nil
+}
+
+// claim claims up to count of the machines that didn't exist before scaling
+// up and aren't claimed by other launches. Claims on machines that no longer
+// exist are forgotten.
+func (c *CloudProvider) claim(machineDeployment MachineDeploymentReference, existing map[string]*unstructured.Unstructured, machines map[string]*unstructured.Unstructured, count int) []*unstructured.Unstructured {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.claimed == nil {
+		c.claimed = map[MachineDeploymentReference]sets.String{}
+	}
+	claimed := sets.NewString()
+	for name := range machines {
+		if c.claimed[machineDeployment].Has(name) {
+			claimed.Insert(name)
+		}
+	}
+	c.claimed[machineDeployment] = claimed
+	result := []*unstructured.Unstructured{}
+	for name, machine := range machines {
+		if len(result) >= count {
+			break
+		}
+		if _, ok := existing[name]; ok || claimed.Has(name) {
+			continue
+		}
+		claimed.Insert(name)
+		result = append(result, machine)
+	}
+	return result
+}
+
+// scale changes the replicas of the MachineDeployment by delta
+func (c *CloudProvider) scale(ctx context.Context, machineDeployment MachineDeploymentReference, delta int64) error {
+	return c.update(ctx, machineDeployment, func(object *unstructured.Unstructured) (bool, error) {
+		return true, addReplicas(object, delta)
+	})
+}
+
+// scaleDown removes the machine's replica from the MachineDeployment,
+// recording the machine on the MachineDeployment in the same update so that
+// it isn't removed twice. Records of machines that no longer exist are
+// forgotten.
+func (c *CloudProvider) scaleDown(ctx context.Context, machineDeployment MachineDeploymentReference, machine string) (bool, error) {
+	machines, err := c.machinesOf(ctx, machineDeployment)
+	if err != nil {
+		return false, err
+	}
+	scaled := false
+	if err := c.update(ctx, machineDeployment, func(object *unstructured.Unstructured) (bool, error) {
+		recorded := sets.NewString()
+		for _, name := range strings.Split(object.GetAnnotations()[ScaledDownAnnotationKey], ",") {
+			if _, ok := machines[name]; ok {
+				recorded.Insert(name)
+			}
+		}
+		if recorded.Has(machine) {
+			scaled = false
+			return false, nil
+		}
+		object.SetAnnotations(functional.UnionStringMaps(object.GetAnnotations(), map[string]string{
+			ScaledDownAnnotationKey: strings.Join(recorded.Insert(machine).List(), ","),
+		}))
+		scaled = true
+		return true, addReplicas(object, -1)
+	}); err != nil {
+		return false, err
+	}
+	return scaled, nil
+}
+
+// update applies the mutation to the MachineDeployment, retrying on conflict.
+// The mutation returns false if there's nothing to update.
+func (c *CloudProvider) update(ctx context.Context, machineDeployment MachineDeploymentReference, mutate func(*unstructured.Unstructured) (bool, error)) error {
+	client := c.dynamicClient.Resource(MachineDeploymentResource).Namespace(machineDeployment.Namespace)
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		object, err := client.Get(ctx, machineDeployment.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if changed, err := mutate(object); err != nil || !changed {
+			return err
+		}
+		_, err = client.Update(ctx, object, metav1.UpdateOptions{})
+		return err
+	}); err != nil {
+		return fmt.Errorf("scaling machine deployment %s/%s, %w", machineDeployment.Namespace, machineDeployment.Name, err)
+	}
+	return nil
+}
+
+// addReplicas changes the replicas of the MachineDeployment object by delta,
+// without going below zero
+func addReplicas(object *unstructured.Unstructured, delta int64) error {
+	replicas, _, err := unstructured.NestedInt64(object.Object, "spec", "replicas")
+	if err != nil {
+		return err
+	}
+	if replicas+delta < 0 {
+		delta = -replicas
+	}
+	return unstructured.SetNestedField(object.Object, replicas+delta, "spec", "replicas")
+}
+
+// machinesOf returns the machines of the MachineDeployment by name
+func (c *CloudProvider) machinesOf(ctx context.Context, machineDeployment MachineDeploymentReference) (map[string]*unstructured.Unstructured, error) {
+	machineList, err := c.dynamicClient.Resource(MachineResource).Namespace(machineDeployment.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", DeploymentNameLabelKey, machineDeployment.Name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing machines of machine deployment %s/%s, %w", machineDeployment.Namespace, machineDeployment.Name, err)
+	}
+	machines := map[string]*unstructured.Unstructured{}
+	for i := range machineList.Items {
+		machines[machineList.Items[i].GetName()] = &machineList.Items[i]
+	}
+	return machines, nil
+}
+
+func nodeFor(machine *unstructured.Unstructured, instanceType *InstanceType, zone string) *v1.Node {
+	// Infrastructure providers set the provider id once the machine is provisioned
+	providerID, _, _ := unstructured.NestedString(machine.Object, "spec", "providerID")
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: machine.GetName(),
+			Labels: map[string]string{
+				v1.LabelTopologyZone:       zone,
+				v1.LabelInstanceTypeStable: instanceType.Name(),
+				v1.LabelArchStable:         instanceType.Architecture(),
+				v1.LabelOSStable:           instanceType.OperatingSystems()[0],
+			},
+			Annotations: map[string]string{
+				MachineAnnotationKey:          machine.GetName(),
+				ClusterNamespaceAnnotationKey: machine.GetNamespace(),
+			},
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: providerID,
+		},
+	}
+}
+
+// Delete marks the node's machine to be deleted and scales down its
+// MachineDeployment, rather than deleting the machine, which its MachineSet
+// would replace
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	name, namespace := node.Annotations[MachineAnnotationKey], node.Annotations[ClusterNamespaceAnnotationKey]
+	if name == "" || namespace == "" {
+		return fmt.Errorf("node %s isn't annotated with its machine", node.Name)
+	}
+	client := c.dynamicClient.Resource(MachineResource).Namespace(namespace)
+	machine, err := client.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting machine %s/%s, %w", namespace, name, err)
+	}
+	// The machine is already being scaled down
+	if machine.GetDeletionTimestamp() != nil {
+		return nil
+	}
+	deployment, ok := machine.GetLabels()[DeploymentNameLabelKey]
+	if !ok {
+		return fmt.Errorf("machine %s/%s isn't owned by a machine deployment", namespace, name)
+	}
+	// The machine is marked before scaling down, so that the MachineSet
+	// deletes it rather than another machine. Retries scale down if a
+	// previous attempt failed after marking the machine.
+	if _, ok := machine.GetAnnotations()[DeleteMachineAnnotationKey]; !ok {
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, DeleteMachineAnnotationKey, injectabletime.Now().UTC().Format(time.RFC3339))
+		if _, err := client.Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("marking machine %s/%s for deletion, %w", namespace, name, err)
+		}
+	}
+	scaled, err := c.scaleDown(ctx, MachineDeploymentReference{Namespace: namespace, Name: deployment}, name)
+	if err != nil {
+		return err
+	}
+	if scaled {
+		logging.FromContext(ctx).Infof("Scaled down machine deployment %s/%s, deleting machine %s", namespace, deployment, name)
+	}
+	return nil
+}
+
+func (c *CloudProvider) GetInstanceTypes(context.Context, *v1alpha4.Constraints) ([]cloudprovider.InstanceType, error) {
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, instanceType := range c.instanceTypes {
+		instanceTypes = append(instanceTypes, instanceType)
+	}
+	return instanceTypes, nil
+}
+
+func (c *CloudProvider) ValidateLaunch(context.Context, *v1alpha4.Constraints, []cloudprovider.InstanceType, int) error {
+	return nil
+}
+
+func (c *CloudProvider) Default(context.Context, *v1alpha4.Constraints) {
+}
+
+func (c *CloudProvider) Validate(context.Context, *v1alpha4.Constraints) *apis.FieldError {
+	return nil
+}
+
+func (c *CloudProvider) Constrain(context.Context, *v1alpha4.Constraints, ...*v1.Pod) error {
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/ClusterAPI")
+}
+
+var _ = Describe("ClusterAPI", func() {
+	Context("Instance Types", func() {
+		It("should default architecture and operating systems", func() {
+			instanceTypes, err := ParseInstanceTypes([]byte(`[{"name": "small", "machineDeployments": [{"namespace": "default", "name": "small-a", "zone": "test-zone-1"}], "cpu": "2", "memory": "4Gi", "pods": "10"}]`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instanceTypes).To(HaveLen(1))
+			Expect(instanceTypes[0].Architecture()).To(Equal(v1alpha4.ArchitectureAmd64))
+			Expect(instanceTypes[0].OperatingSystems()).To(ConsistOf(v1alpha4.OperatingSystemLinux))
+			Expect(instanceTypes[0].Zones()).To(ConsistOf("test-zone-1"))
+		})
+		It("should fail for instance types without machine deployments", func() {
+			_, err := ParseInstanceTypes([]byte(`[{"name": "small", "cpu": "2"}]`))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail for machine deployments without zones", func() {
+			_, err := ParseInstanceTypes([]byte(`[{"name": "small", "machineDeployments": [{"namespace": "default", "name": "small-a"}]}]`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Machine Deployments", func() {
+		var dynamicClient *fake.FakeDynamicClient
+		var clusterAPI *CloudProvider
+		var instanceTypes []cloudprovider.InstanceType
+
+		BeforeEach(func() {
+			machinePollInterval = 10 * time.Millisecond
+			machineTimeout = time.Minute
+			dynamicClient = fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+				MachineDeploymentResource: "MachineDeploymentList",
+				MachineResource:           "MachineList",
+			},
+				machineDeployment("small-a", 1),
+				machineDeployment("small-b", 0),
+				machine("small-a-existing", "small-a"),
+			)
+			catalog, err := ParseInstanceTypes([]byte(`[{"name": "small", "cpu": "2", "memory": "4Gi", "pods": "10", "machineDeployments": [
+				{"namespace": "default", "name": "small-a", "zone": "test-zone-1"},
+				{"namespace": "default", "name": "small-b", "zone": "test-zone-2"}
+			]}]`))
+			Expect(err).ToNot(HaveOccurred())
+			clusterAPI = &CloudProvider{instanceTypes: catalog, dynamicClient: dynamicClient}
+			instanceTypes, err = clusterAPI.GetInstanceTypes(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("should scale up machine deployments and launch nodes for their new machines", func() {
+			nodes := []*v1.Node{}
+			launched := clusterAPI.Create(ctx, &v1alpha4.Constraints{Zones: []string{"test-zone-1"}}, instanceTypes, 2, func(node *v1.Node) error {
+				nodes = append(nodes, node)
+				return nil
+			})
+			// Simulate the MachineSet controller creating machines once scaled up
+			Eventually(func() int64 { return ExpectReplicas(dynamicClient, "small-a") }).Should(Equal(int64(3)))
+			ExpectCreatedMachines(dynamicClient, machine("small-a-1", "small-a"), machine("small-a-2", "small-a"))
+			Expect(<-launched).To(Succeed())
+			Expect(nodes).To(HaveLen(2))
+			names := []string{}
+			for _, node := range nodes {
+				names = append(names, node.Name)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small"))
+				Expect(node.Annotations).To(HaveKeyWithValue(MachineAnnotationKey, node.Name))
+				Expect(node.Annotations).To(HaveKeyWithValue(ClusterNamespaceAnnotationKey, "default"))
+			}
+			Expect(names).To(ConsistOf("small-a-1", "small-a-2"))
+		})
+		It("should remove the replicas of machines that aren't created in time", func() {
+			machineTimeout = 100 * time.Millisecond
+			nodes := []*v1.Node{}
+			launched := clusterAPI.Create(ctx, &v1alpha4.Constraints{Zones: []string{"test-zone-1"}}, instanceTypes, 2, func(node *v1.Node) error {
+				nodes = append(nodes, node)
+				return nil
+			})
+			Eventually(func() int64 { return ExpectReplicas(dynamicClient, "small-a") }).Should(Equal(int64(3)))
+			ExpectCreatedMachines(dynamicClient, machine("small-a-1", "small-a"))
+			Expect(<-launched).ToNot(Succeed())
+			Expect(ExpectReplicas(dynamicClient, "small-a")).To(Equal(int64(2)))
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].Name).To(Equal("small-a-1"))
+		})
+		It("should not claim machines claimed by concurrent launches", func() {
+			first := clusterAPI.Create(ctx, &v1alpha4.Constraints{Zones: []string{"test-zone-1"}}, instanceTypes, 1, func(node *v1.Node) error { return nil })
+			second := clusterAPI.Create(ctx, &v1alpha4.Constraints{Zones: []string{"test-zone-1"}}, instanceTypes, 1, func(node *v1.Node) error { return nil })
+			Eventually(func() int64 { return ExpectReplicas(dynamicClient, "small-a") }).Should(Equal(int64(3)))
+			ExpectCreatedMachines(dynamicClient, machine("small-a-1", "small-a"), machine("small-a-2", "small-a"))
+			Expect(<-first).To(Succeed())
+			Expect(<-second).To(Succeed())
+			Expect(clusterAPI.claimed[MachineDeploymentReference{Namespace: "default", Name: "small-a", Zone: "test-zone-1"}].List()).To(ConsistOf("small-a-1", "small-a-2"))
+		})
+		It("should fail to launch in zones without machine deployments", func() {
+			Expect(<-clusterAPI.Create(ctx, &v1alpha4.Constraints{Zones: []string{"test-zone-3"}}, instanceTypes, 1, func(node *v1.Node) error {
+				return nil
+			})).ToNot(Succeed())
+		})
+		It("should mark the machine for deletion and scale down its machine deployment", func() {
+			Expect(clusterAPI.Delete(ctx, nodeOf("small-a-existing"))).To(Succeed())
+			Expect(ExpectReplicas(dynamicClient, "small-a")).To(Equal(int64(0)))
+			machine, err := dynamicClient.Resource(MachineResource).Namespace("default").Get(ctx, "small-a-existing", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(machine.GetAnnotations()).To(HaveKey(DeleteMachineAnnotationKey))
+			// Deleting again doesn't scale down again
+			Expect(clusterAPI.Delete(ctx, nodeOf("small-a-existing"))).To(Succeed())
+			Expect(ExpectReplicas(dynamicClient, "small-a")).To(Equal(int64(0)))
+		})
+		It("should scale down when retrying after marking the machine", func() {
+			machine, err := dynamicClient.Resource(MachineResource).Namespace("default").Get(ctx, "small-a-existing", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			machine.SetAnnotations(map[string]string{DeleteMachineAnnotationKey: "2021-01-01T00:00:00Z"})
+			_, err = dynamicClient.Resource(MachineResource).Namespace("default").Update(ctx, machine, metav1.UpdateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clusterAPI.Delete(ctx, nodeOf("small-a-existing"))).To(Succeed())
+			Expect(ExpectReplicas(dynamicClient, "small-a")).To(Equal(int64(0)))
+		})
+		It("should succeed if the machine is already deleted", func() {
+			Expect(clusterAPI.Delete(ctx, nodeOf("small-a-deleted"))).To(Succeed())
+			Expect(ExpectReplicas(dynamicClient, "small-a")).To(Equal(int64(1)))
+		})
+		It("should fail for nodes without machines", func() {
+			Expect(clusterAPI.Delete(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unknown"}})).ToNot(Succeed())
+		})
+	})
+})
+
+func machineDeployment(name string, replicas int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.x-k8s.io/v1alpha4",
+		"kind":       "MachineDeployment",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       map[string]interface{}{"replicas": replicas},
+	}}
+}
+
+func machine(name string, deployment string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.x-k8s.io/v1alpha4",
+		"kind":       "Machine",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+			"labels":    map[string]interface{}{DeploymentNameLabelKey: deployment},
+		},
+	}}
+}
+
+func nodeOf(machine string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        machine,
+		Annotations: map[string]string{MachineAnnotationKey: machine, ClusterNamespaceAnnotationKey: "default"},
+	}}
+}
+
+func ExpectReplicas(dynamicClient *fake.FakeDynamicClient, name string) int64 {
+	object, err := dynamicClient.Resource(MachineDeploymentResource).Namespace("default").Get(ctx, name, metav1.GetOptions{})
+	Expect(err).ToNot(HaveOccurred())
+	replicas, _, err := unstructured.NestedInt64(object.Object, "spec", "replicas")
+	Expect(err).ToNot(HaveOccurred())
+	return replicas
+}
+
+func ExpectCreatedMachines(dynamicClient *fake.FakeDynamicClient, machines ...*unstructured.Unstructured) {
+	for _, machine := range machines {
+		_, err := dynamicClient.Resource(MachineResource).Namespace("default").Create(ctx, machine, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LaunchErrorReason categorizes why a cloud provider vetoed a launch
+type LaunchErrorReason string
+
+const (
+	// QuotaExceeded vetoes launches that would exceed a cloud provider quota
+	QuotaExceeded LaunchErrorReason = "QuotaExceeded"
+	// InvalidCombination vetoes launches of constraints and instance types
+	// that the cloud provider is unable to launch together
+	InvalidCombination LaunchErrorReason = "InvalidCombination"
+)
+
+// LaunchError is returned by CloudProvider.ValidateLaunch to veto a launch.
+// Vetoed launches are skipped and retried on the next provisioning loop.
+type LaunchError struct {
+	Reason  LaunchErrorReason
+	Message string
+}
+
+func NewLaunchError(reason LaunchErrorReason, format string, args ...interface{}) *LaunchError {
+	return &LaunchError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *LaunchError) Error() string {
+	return fmt.Sprintf("%s, %s", e.Reason, e.Message)
+}
+
+// AsLaunchError returns the LaunchError if err is one (even if it's wrapped)
+func AsLaunchError(err error) (*LaunchError, bool) {
+	var launchError *LaunchError
+	if errors.As(err, &launchError) {
+		return launchError, true
+	}
+	return nil, false
+}
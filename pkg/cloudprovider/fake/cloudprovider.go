@@ -18,11 +18,13 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
 	"knative.dev/pkg/apis"
 
 	v1 "k8s.io/api/core/v1"
@@ -30,21 +32,48 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-type CloudProvider struct{}
+type CloudProvider struct {
+	// LaunchError is returned by ValidateLaunch, if set
+	LaunchError error
+	// CreateError is returned by Create, if set
+	CreateError error
+	// InsufficientCapacityZones have no capacity, so launches constrained to
+	// them fail
+	InsufficientCapacityZones []string
+	// Interruptions are returned once by GetInterruptions
+	Interruptions []*cloudprovider.Interruption
+	// Acknowledged are the interruptions passed to AcknowledgeInterruption
+	Acknowledged []*cloudprovider.Interruption
+	// ReplacedImages are the images that replaced nodes' images, by node name
+	ReplacedImages map[string]string
+	// Prices override the hourly prices of instance types, by name
+	Prices map[string]float64
+	mu     sync.Mutex
+}
 
 func (c *CloudProvider) Create(_ context.Context, constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, bind func(*v1.Node) error) chan error {
+	if c.CreateError != nil {
+		err := make(chan error, 1)
+		err <- c.CreateError
+		return err
+	}
+	// Pick first instance type option
+	instance := instanceTypes[0]
+	// Pick first zone with capacity
+	zones := instance.Zones()
+	if len(constraints.Zones) != 0 {
+		zones = functional.IntersectStringSlice(constraints.Zones, instance.Zones())
+	}
+	available := functional.StringSliceWithout(zones, c.InsufficientCapacityZones...)
+	if len(available) == 0 {
+		err := make(chan error, 1)
+		err <- fmt.Errorf("insufficient capacity in zones %v", zones)
+		return err
+	}
+	zone := available[0]
 	err := make(chan error)
 	for i := 0; i < quantity; i++ {
 		name := strings.ToLower(randomdata.SillyName())
-		// Pick first instance type option
-		instance := instanceTypes[0]
-		// Pick first zone
-		zones := instance.Zones()
-		if len(constraints.Zones) != 0 {
-			zones = functional.IntersectStringSlice(constraints.Zones, instance.Zones())
-		}
-		zone := zones[0]
-
 		go func() {
 			err <- bind(&v1.Node{
 				ObjectMeta: metav1.ObjectMeta{
@@ -74,32 +103,98 @@ func (c *CloudProvider) Create(_ context.Context, constraints *v1alpha4.Constrai
 	return err
 }
 
-func (c *CloudProvider) GetInstanceTypes(_ context.Context) ([]cloudprovider.InstanceType, error) {
-	return []cloudprovider.InstanceType{
+func (c *CloudProvider) GetInstanceTypes(_ context.Context, _ *v1alpha4.Constraints) ([]cloudprovider.InstanceType, error) {
+	instanceTypes := []*InstanceType{
 		NewInstanceType(InstanceTypeOptions{
-			name: "default-instance-type",
+			name:          "default-instance-type",
+			price:         0.1,
+			capacityTypes: []string{"spot", "on-demand"},
 		}),
 		NewInstanceType(InstanceTypeOptions{
-			name:       "nvidia-gpu-instance-type",
-			nvidiaGPUs: resource.MustParse("2"),
+			name:      "nvidia-gpu-instance-type",
+			price:     3.06,
+			resources: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("2")},
 		}),
 		NewInstanceType(InstanceTypeOptions{
-			name:    "amd-gpu-instance-type",
-			amdGPUs: resource.MustParse("2"),
+			name:      "amd-gpu-instance-type",
+			price:     1.65,
+			resources: v1.ResourceList{resources.AMDGPU: resource.MustParse("2")},
 		}),
 		NewInstanceType(InstanceTypeOptions{
-			name:       "aws-neuron-instance-type",
-			awsNeurons: resource.MustParse("2"),
+			name:      "aws-neuron-instance-type",
+			price:     1.97,
+			resources: v1.ResourceList{resources.AWSNeuron: resource.MustParse("2")},
+		}),
+		NewInstanceType(InstanceTypeOptions{
+			name:      "habana-gaudi-instance-type",
+			price:     13.1,
+			resources: v1.ResourceList{"habana.ai/gaudi": resource.MustParse("8")},
 		}),
 		NewInstanceType(InstanceTypeOptions{
 			name:             "windows-instance-type",
+			price:            0.19,
 			operatingSystems: []string{"windows"},
 		}),
 		NewInstanceType(InstanceTypeOptions{
 			name:         "arm-instance-type",
+			price:        0.12,
 			architecture: "arm64",
 		}),
-	}, nil
+	}
+	result := []cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		if price, ok := c.Prices[instanceType.Name()]; ok {
+			instanceType.price = price
+		}
+		result = append(result, instanceType)
+	}
+	return result, nil
+}
+
+func (c *CloudProvider) ValidateLaunch(context.Context, *v1alpha4.Constraints, []cloudprovider.InstanceType, int) error {
+	return c.LaunchError
+}
+
+func (c *CloudProvider) GetInterruptions(ctx context.Context) ([]*cloudprovider.Interruption, error) {
+	c.mu.Lock()
+	interruptions := c.Interruptions
+	c.Interruptions = nil
+	c.mu.Unlock()
+	if len(interruptions) == 0 {
+		<-ctx.Done()
+	}
+	return interruptions, nil
+}
+
+func (c *CloudProvider) AcknowledgeInterruption(_ context.Context, interruption *cloudprovider.Interruption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Acknowledged = append(c.Acknowledged, interruption)
+	return nil
+}
+
+// Acknowledgements returns the interruptions passed to AcknowledgeInterruption
+func (c *CloudProvider) Acknowledgements() []*cloudprovider.Interruption {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*cloudprovider.Interruption{}, c.Acknowledged...)
+}
+
+// Reset clears the interruptions and their acknowledgements
+func (c *CloudProvider) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interruptions = nil
+	c.Acknowledged = nil
+}
+
+func (c *CloudProvider) ReplacedImage(node *v1.Node) (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if replacement, ok := c.ReplacedImages[node.Name]; ok {
+		return "test-image", replacement
+	}
+	return "", ""
 }
 
 func (c *CloudProvider) Delete(context.Context, *v1.Node) error {
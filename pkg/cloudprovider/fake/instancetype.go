@@ -15,6 +15,8 @@ limitations under the License.
 package fake
 
 import (
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
@@ -23,6 +25,9 @@ func NewInstanceType(options InstanceTypeOptions) *InstanceType {
 	if len(options.zones) == 0 {
 		options.zones = []string{"test-zone-1", "test-zone-2", "test-zone-3"}
 	}
+	if len(options.capacityTypes) == 0 {
+		options.capacityTypes = []string{"on-demand"}
+	}
 	if len(options.architecture) == 0 {
 		options.architecture = "amd64"
 	}
@@ -42,14 +47,14 @@ func NewInstanceType(options InstanceTypeOptions) *InstanceType {
 		InstanceTypeOptions: InstanceTypeOptions{
 			name:             options.name,
 			zones:            options.zones,
+			capacityTypes:    options.capacityTypes,
 			architecture:     options.architecture,
 			operatingSystems: options.operatingSystems,
 			cpu:              options.cpu,
 			memory:           options.memory,
 			pods:             options.pods,
-			nvidiaGPUs:       options.nvidiaGPUs,
-			amdGPUs:          options.amdGPUs,
-			awsNeurons:       options.awsNeurons,
+			resources:        options.resources,
+			price:            options.price,
 		},
 	}
 }
@@ -57,14 +62,15 @@ func NewInstanceType(options InstanceTypeOptions) *InstanceType {
 type InstanceTypeOptions struct {
 	name             string
 	zones            []string
+	capacityTypes    []string
 	architecture     string
 	operatingSystems []string
 	cpu              resource.Quantity
 	memory           resource.Quantity
 	pods             resource.Quantity
-	nvidiaGPUs       resource.Quantity
-	amdGPUs          resource.Quantity
-	awsNeurons       resource.Quantity
+	// resources are extended resources, e.g. nvidia.com/gpu
+	resources v1.ResourceList
+	price     float64
 }
 
 type InstanceType struct {
@@ -75,10 +81,24 @@ func (i *InstanceType) Name() string {
 	return i.name
 }
 
+func (i *InstanceType) HourlyPrice() float64 {
+	return i.price
+}
+
 func (i *InstanceType) Zones() []string {
 	return i.zones
 }
 
+func (i *InstanceType) Offerings() []cloudprovider.Offering {
+	offerings := []cloudprovider.Offering{}
+	for _, zone := range i.zones {
+		for _, capacityType := range i.capacityTypes {
+			offerings = append(offerings, cloudprovider.Offering{Zone: zone, CapacityType: capacityType})
+		}
+	}
+	return offerings
+}
+
 func (i *InstanceType) Architecture() string {
 	return i.architecture
 }
@@ -99,16 +119,12 @@ func (i *InstanceType) Pods() *resource.Quantity {
 	return &i.pods
 }
 
-func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
-	return &i.nvidiaGPUs
-}
-
-func (i *InstanceType) AMDGPUs() *resource.Quantity {
-	return &i.amdGPUs
-}
-
-func (i *InstanceType) AWSNeurons() *resource.Quantity {
-	return &i.awsNeurons
+func (i *InstanceType) Resources() v1.ResourceList {
+	return resources.NonZero(resources.Merge(v1.ResourceList{
+		v1.ResourceCPU:    i.cpu,
+		v1.ResourceMemory: i.memory,
+		v1.ResourcePods:   i.pods,
+	}, i.resources))
 }
 
 func (i *InstanceType) Overhead() v1.ResourceList {
@@ -0,0 +1,140 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/registry"
+	"github.com/awslabs/karpenter/pkg/test"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var env *test.Environment
+var cloudProvider *fake.CloudProvider
+var handler http.Handler
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/Fake")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider = &fake.CloudProvider{}
+		registry.RegisterOrDie(ctx, cloudProvider)
+		handler = cloudprovider.NewInstanceTypesHandler(ctx, e.Client, cloudProvider)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Instance Types Handler", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha4.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name}}
+	})
+	AfterEach(func() {
+		cloudProvider.Prices = nil
+		ExpectCleanedUp(env.Client)
+	})
+
+	get := func(method string, target string) (*httptest.ResponseRecorder, []cloudprovider.ProvisionerInstanceTypes) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		result := []cloudprovider.ProvisionerInstanceTypes{}
+		if recorder.Code == http.StatusOK {
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &result)).To(Succeed())
+		}
+		return recorder, result
+	}
+	summaryOf := func(result []cloudprovider.ProvisionerInstanceTypes, name string) *cloudprovider.InstanceTypeSummary {
+		Expect(result).To(HaveLen(1))
+		for i := range result[0].InstanceTypes {
+			if result[0].InstanceTypes[i].Name == name {
+				return &result[0].InstanceTypes[i]
+			}
+		}
+		Fail("instance type " + name + " not found")
+		return nil
+	}
+
+	It("should reject methods other than GET", func() {
+		recorder, _ := get(http.MethodPost, cloudprovider.InstanceTypesPath)
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+	It("should list the instance types that satisfy each provisioner's constraints", func() {
+		provisioner.Spec.Zones = []string{"test-zone-2"}
+		provisioner.Spec.InstanceTypes = []string{"default-instance-type", "arm-instance-type"}
+		provisioner.Spec.Architectures = []string{v1alpha4.ArchitectureAmd64}
+		ExpectCreated(env.Client, provisioner)
+		recorder, result := get(http.MethodGet, cloudprovider.InstanceTypesPath)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].Provisioner).To(Equal(provisioner.Name))
+		Expect(result[0].Error).To(BeEmpty())
+		Expect(result[0].InstanceTypes).To(HaveLen(1))
+		summary := result[0].InstanceTypes[0]
+		Expect(summary.Name).To(Equal("default-instance-type"))
+		Expect(summary.Zones).To(ConsistOf("test-zone-2"))
+		Expect(summary.Capacity.Cpu().String()).To(Equal("4"))
+	})
+	It("should include prices", func() {
+		cloudProvider.Prices = map[string]float64{"default-instance-type": 0.25}
+		ExpectCreated(env.Client, provisioner)
+		_, result := get(http.MethodGet, cloudprovider.InstanceTypesPath)
+		Expect(summaryOf(result, "default-instance-type").Price).To(BeNumerically("==", 0.25))
+		Expect(summaryOf(result, "arm-instance-type").Price).To(BeNumerically("==", 0.12))
+	})
+	It("should include capacity type offerings in the provisioner's zones", func() {
+		provisioner.Spec.Zones = []string{"test-zone-1", "test-zone-2"}
+		ExpectCreated(env.Client, provisioner)
+		_, result := get(http.MethodGet, cloudprovider.InstanceTypesPath)
+		Expect(summaryOf(result, "default-instance-type").Offerings).To(ConsistOf(
+			cloudprovider.Offering{Zone: "test-zone-1", CapacityType: "spot"},
+			cloudprovider.Offering{Zone: "test-zone-1", CapacityType: "on-demand"},
+			cloudprovider.Offering{Zone: "test-zone-2", CapacityType: "spot"},
+			cloudprovider.Offering{Zone: "test-zone-2", CapacityType: "on-demand"},
+		))
+		Expect(summaryOf(result, "arm-instance-type").Offerings).To(ConsistOf(
+			cloudprovider.Offering{Zone: "test-zone-1", CapacityType: "on-demand"},
+			cloudprovider.Offering{Zone: "test-zone-2", CapacityType: "on-demand"},
+		))
+	})
+	It("should limit the response to the requested provisioner", func() {
+		other := &v1alpha4.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+		ExpectCreated(env.Client, provisioner, other)
+		_, result := get(http.MethodGet, cloudprovider.InstanceTypesPath+"?provisioner=other")
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].Provisioner).To(Equal("other"))
+	})
+})
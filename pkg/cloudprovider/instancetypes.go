@@ -0,0 +1,150 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InstanceTypesPath is the path the instance types handler is served on
+const InstanceTypesPath = "/instancetypes"
+
+// ProvisionerInstanceTypes describes the instance types that a provisioner may launch
+type ProvisionerInstanceTypes struct {
+	Provisioner   string                `json:"provisioner"`
+	InstanceTypes []InstanceTypeSummary `json:"instanceTypes"`
+	Error         string                `json:"error,omitempty"`
+}
+
+// InstanceTypeSummary is a serializable description of an instance type
+type InstanceTypeSummary struct {
+	Name             string          `json:"name"`
+	Zones            []string        `json:"zones"`
+	Architecture     string          `json:"architecture"`
+	OperatingSystems []string        `json:"operatingSystems"`
+	Capacity         v1.ResourceList `json:"capacity"`
+	Overhead         v1.ResourceList `json:"overhead"`
+	// Price is the hourly price, if known
+	Price float64 `json:"price,omitempty"`
+	// Offerings are the capacity types available in each of the zones, if
+	// reported by the cloud provider
+	Offerings []Offering `json:"offerings,omitempty"`
+	// LaunchStatistics are the recent launch outcomes in each of the zones,
+	// if reported by the cloud provider
+	LaunchStatistics []LaunchStatistics `json:"launchStatistics,omitempty"`
+}
+
+// NewInstanceTypesHandler returns a read-only handler that lists the instance
+// types each provisioner may launch, so that tooling doesn't need to call the
+// cloud provider itself. The provisioner query parameter limits the response
+// to a single provisioner.
+func NewInstanceTypesHandler(ctx context.Context, kubeClient client.Client, cloudProvider CloudProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		provisioners := &v1alpha4.ProvisionerList{}
+		if err := kubeClient.List(r.Context(), provisioners); err != nil {
+			http.Error(w, fmt.Sprintf("listing provisioners, %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		result := []ProvisionerInstanceTypes{}
+		for i := range provisioners.Items {
+			provisioner := &provisioners.Items[i]
+			if name := r.URL.Query().Get("provisioner"); name != "" && name != provisioner.Name {
+				continue
+			}
+			instanceTypes, err := instanceTypesFor(r.Context(), cloudProvider, &provisioner.Spec.Constraints)
+			summary := ProvisionerInstanceTypes{Provisioner: provisioner.Name, InstanceTypes: instanceTypes}
+			if err != nil {
+				summary.Error = err.Error()
+			}
+			result = append(result, summary)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to write instance types response, %s", err.Error())
+		}
+	})
+}
+
+// instanceTypesFor returns summaries of the instance types that satisfy the constraints
+func instanceTypesFor(ctx context.Context, cloudProvider CloudProvider, constraints *v1alpha4.Constraints) ([]InstanceTypeSummary, error) {
+	constraints = constraints.DeepCopy()
+	if err := constraints.Constrain(ctx); err != nil {
+		return nil, fmt.Errorf("applying constraints, %w", err)
+	}
+	instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, constraints)
+	if err != nil {
+		return nil, fmt.Errorf("getting instance types, %w", err)
+	}
+	launchStatistics := map[string][]LaunchStatistics{}
+	if reporter, ok := cloudProvider.(LaunchStatisticsReporter); ok {
+		for _, statistics := range reporter.LaunchStatistics() {
+			if functional.ContainsString(constraints.Zones, statistics.Zone) {
+				launchStatistics[statistics.InstanceType] = append(launchStatistics[statistics.InstanceType], statistics)
+			}
+		}
+	}
+	summaries := []InstanceTypeSummary{}
+	for _, instanceType := range instanceTypes {
+		zones := functional.IntersectStringSlice(instanceType.Zones(), constraints.Zones)
+		operatingSystems := functional.IntersectStringSlice(instanceType.OperatingSystems(), constraints.OperatingSystems)
+		if len(zones) == 0 || len(operatingSystems) == 0 ||
+			!functional.ContainsString(constraints.InstanceTypes, instanceType.Name()) ||
+			!functional.ContainsString(constraints.Architectures, instanceType.Architecture()) {
+			continue
+		}
+		price, _ := HourlyPrice(instanceType)
+		summaries = append(summaries, InstanceTypeSummary{
+			Name:             instanceType.Name(),
+			Zones:            zones,
+			Architecture:     instanceType.Architecture(),
+			OperatingSystems: operatingSystems,
+			Capacity:         instanceType.Resources(),
+			Overhead:         instanceType.Overhead(),
+			Price:            price,
+			Offerings:        offeringsIn(instanceType, zones),
+			LaunchStatistics: launchStatistics[instanceType.Name()],
+		})
+	}
+	return summaries, nil
+}
+
+// offeringsIn returns the instance type's offerings in the zones, or nil if it
+// doesn't report offerings
+func offeringsIn(instanceType InstanceType, zones []string) []Offering {
+	offered, ok := instanceType.(OfferedInstanceType)
+	if !ok {
+		return nil
+	}
+	offerings := []Offering{}
+	for _, offering := range offered.Offerings() {
+		if functional.ContainsString(zones, offering.Zone) {
+			offerings = append(offerings, offering)
+		}
+	}
+	return offerings
+}
@@ -0,0 +1,41 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import "math"
+
+// HourlyPrice returns the hourly price of the instance type, and false if it
+// isn't priced
+func HourlyPrice(instanceType InstanceType) (float64, bool) {
+	priced, ok := instanceType.(PricedInstanceType)
+	if !ok || priced.HourlyPrice() <= 0 {
+		return 0, false
+	}
+	return priced.HourlyPrice(), true
+}
+
+// CheapestPrice returns the lowest hourly price of the instance types, and
+// false if any of them aren't priced
+func CheapestPrice(instanceTypes []InstanceType) (float64, bool) {
+	cheapest := math.Inf(1)
+	for _, instanceType := range instanceTypes {
+		price, ok := HourlyPrice(instanceType)
+		if !ok {
+			return 0, false
+		}
+		cheapest = math.Min(cheapest, price)
+	}
+	return cheapest, len(instanceTypes) > 0
+}
@@ -23,6 +23,9 @@ import (
 	"github.com/awslabs/karpenter/pkg/cloudprovider/aws"
 )
 
+// CloudProviderName is the name of the cloud provider compiled into the binary
+const CloudProviderName = "aws"
+
 func newCloudProvider(ctx context.Context, options cloudprovider.Options) cloudprovider.CloudProvider {
 	return aws.NewCloudProvider(ctx, options)
 }
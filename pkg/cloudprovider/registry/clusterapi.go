@@ -0,0 +1,38 @@
+// +build clusterapi
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/clusterapi"
+	"github.com/awslabs/karpenter/pkg/utils/env"
+)
+
+// CloudProviderName is the name of the cloud provider compiled into the binary
+const CloudProviderName = "clusterapi"
+
+func newCloudProvider(ctx context.Context, options cloudprovider.Options) cloudprovider.CloudProvider {
+	// The catalog is typically provided from a config map with valueFrom
+	instanceTypes, err := clusterapi.ParseInstanceTypes([]byte(env.WithDefaultString("CLUSTER_API_INSTANCE_TYPES", "")))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse Cluster API instance types from CLUSTER_API_INSTANCE_TYPES, %s", err.Error()))
+	}
+	return clusterapi.NewCloudProvider(ctx, options, instanceTypes)
+}
@@ -1,4 +1,4 @@
-// +build !aws
+// +build !aws,!simulation,!clusterapi
 
 /*
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -23,6 +23,9 @@ import (
 	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
 )
 
+// CloudProviderName is the name of the cloud provider compiled into the binary
+const CloudProviderName = "fake"
+
 func newCloudProvider(context.Context, cloudprovider.Options) cloudprovider.CloudProvider {
 	return &fake.CloudProvider{}
 }
@@ -38,12 +38,12 @@ func RegisterOrDie(ctx context.Context, cloudProvider cloudprovider.CloudProvide
 	architectures := map[string]bool{}
 	operatingSystems := map[string]bool{}
 
-	instanceTypes, err := cloudProvider.GetInstanceTypes(ctx)
+	instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, nil)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to retrieve instance types, %s", err.Error()))
 	}
 	for _, instanceType := range instanceTypes {
-		v1alpha4.WellKnownLabels[v1.LabelInstanceTypeStable] = append(v1alpha4.WellKnownLabels[v1.LabelInstanceTypeStable], instanceType.Name())
+		v1alpha4.WellKnownLabels.Register(v1.LabelInstanceTypeStable, instanceType.Name())
 		architectures[instanceType.Architecture()] = true
 		for _, zone := range instanceType.Zones() {
 			zones[zone] = true
@@ -53,13 +53,13 @@ func RegisterOrDie(ctx context.Context, cloudProvider cloudprovider.CloudProvide
 		}
 	}
 	for zone := range zones {
-		v1alpha4.WellKnownLabels[v1.LabelTopologyZone] = append(v1alpha4.WellKnownLabels[v1.LabelTopologyZone], zone)
+		v1alpha4.WellKnownLabels.Register(v1.LabelTopologyZone, zone)
 	}
 	for architecture := range architectures {
-		v1alpha4.WellKnownLabels[v1.LabelArchStable] = append(v1alpha4.WellKnownLabels[v1.LabelArchStable], architecture)
+		v1alpha4.WellKnownLabels.Register(v1.LabelArchStable, architecture)
 	}
 	for operatingSystem := range operatingSystems {
-		v1alpha4.WellKnownLabels[v1.LabelOSStable] = append(v1alpha4.WellKnownLabels[v1.LabelOSStable], operatingSystem)
+		v1alpha4.WellKnownLabels.Register(v1.LabelOSStable, operatingSystem)
 	}
 
 	v1alpha4.ValidateHook = cloudProvider.Validate
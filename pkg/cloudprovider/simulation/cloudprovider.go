@@ -0,0 +1,170 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// ProviderIDPrefix identifies nodes launched by the simulation
+	ProviderIDPrefix = "simulation://"
+	// KwokNodeAnnotationKey marks nodes as managed by kwok, which simulates
+	// their kubelets
+	KwokNodeAnnotationKey = "kwok.x-k8s.io/node"
+)
+
+// CloudProvider simulates launching the cheapest instance type of each
+// packing as kwok nodes. Workloads may be replayed against a cluster running
+// kwok to estimate the nodes and cost that Karpenter would provision for them.
+type CloudProvider struct {
+	instanceTypes []*InstanceType
+	clientSet     kubernetes.Interface
+}
+
+func NewCloudProvider(ctx context.Context, options cloudprovider.Options, instanceTypes []*InstanceType) *CloudProvider {
+	logging.FromContext(ctx).Infof("Simulating %d instance types, nodes will be launched as kwok nodes", len(instanceTypes))
+	return &CloudProvider{instanceTypes: instanceTypes, clientSet: options.ClientSet}
+}
+
+func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) chan error {
+	err := make(chan error, 1)
+	go func() {
+		err <- c.create(ctx, constraints, instanceTypes, quantity, callback)
+	}()
+	return err
+}
+
+func (c *CloudProvider) create(ctx context.Context, constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) error {
+	instanceType := cheapest(instanceTypes)
+	if instanceType == nil {
+		return fmt.Errorf("no simulated instance type options")
+	}
+	zones := instanceType.Zones()
+	if len(constraints.Zones) != 0 {
+		zones = functional.IntersectStringSlice(constraints.Zones, zones)
+	}
+	if len(zones) == 0 {
+		return fmt.Errorf("instance type %s is not offered in zones %v", instanceType.Name(), constraints.Zones)
+	}
+	for i := 0; i < quantity; i++ {
+		node := c.nodeFor(instanceType, zones[i%len(zones)])
+		if err := callback(node); err != nil {
+			return err
+		}
+		// The node's status isn't persisted when it's created, so resources
+		// are reported as a kubelet would once the node is registered
+		if err := c.patchStatus(ctx, node, instanceType); err != nil {
+			return err
+		}
+	}
+	c.publishEstimates(ctx, "")
+	return nil
+}
+
+func (c *CloudProvider) nodeFor(instanceType *InstanceType, zone string) *v1.Node {
+	name := fmt.Sprintf("simulated-%s", strings.ToLower(randomdata.SillyName()))
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				v1.LabelTopologyZone:       zone,
+				v1.LabelInstanceTypeStable: instanceType.Name(),
+				v1.LabelArchStable:         instanceType.Architecture(),
+				v1.LabelOSStable:           instanceType.OperatingSystems()[0],
+			},
+			Annotations: map[string]string{KwokNodeAnnotationKey: "fake"},
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: fmt.Sprintf("%s/%s/%s", ProviderIDPrefix, zone, name),
+		},
+	}
+}
+
+func (c *CloudProvider) patchStatus(ctx context.Context, node *v1.Node, instanceType *InstanceType) error {
+	resources := v1.ResourceList{
+		v1.ResourceCPU:    *instanceType.CPU(),
+		v1.ResourceMemory: *instanceType.Memory(),
+		v1.ResourcePods:   *instanceType.Pods(),
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"capacity": resources, "allocatable": resources},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.clientSet.CoreV1().Nodes().PatchStatus(ctx, node.Name, patch); err != nil {
+		return fmt.Errorf("patching status of node %s, %w", node.Name, err)
+	}
+	return nil
+}
+
+// cheapest returns the cheapest simulated instance type. Options are sorted by
+// resources, so the smallest is chosen if prices are equal.
+func cheapest(instanceTypes []cloudprovider.InstanceType) *InstanceType {
+	var cheapest *InstanceType
+	for _, option := range instanceTypes {
+		instanceType, ok := option.(*InstanceType)
+		if !ok {
+			continue
+		}
+		if cheapest == nil || instanceType.Price < cheapest.Price {
+			cheapest = instanceType
+		}
+	}
+	return cheapest
+}
+
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	// Nothing to terminate, but estimates exclude the node from now on
+	c.publishEstimates(ctx, node.Name)
+	return nil
+}
+
+func (c *CloudProvider) GetInstanceTypes(context.Context, *v1alpha4.Constraints) ([]cloudprovider.InstanceType, error) {
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, instanceType := range c.instanceTypes {
+		instanceTypes = append(instanceTypes, instanceType)
+	}
+	return instanceTypes, nil
+}
+
+func (c *CloudProvider) ValidateLaunch(context.Context, *v1alpha4.Constraints, []cloudprovider.InstanceType, int) error {
+	return nil
+}
+
+func (c *CloudProvider) Default(context.Context, *v1alpha4.Constraints) {
+}
+
+func (c *CloudProvider) Validate(context.Context, *v1alpha4.Constraints) *apis.FieldError {
+	return nil
+}
+
+func (c *CloudProvider) Constrain(context.Context, *v1alpha4.Constraints, ...*v1.Pod) error {
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricSubsystem   = "simulation"
+	instanceTypeLabel = "instance_type"
+)
+
+var (
+	nodeCountGaugeVec = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "nodes",
+			Help:      "Number of simulated nodes. Broken down by instance type.",
+		},
+		[]string{instanceTypeLabel},
+	)
+	hourlyCostGaugeVec = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "hourly_cost",
+			Help:      "Estimated hourly cost of simulated nodes, given the prices of the simulated instance types. Broken down by instance type.",
+		},
+		[]string{instanceTypeLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(nodeCountGaugeVec, hourlyCostGaugeVec)
+}
+
+// publishEstimates counts the simulated nodes in the cluster, excluding the
+// named node if it's being deleted. Nodes are counted rather than tracked so
+// that estimates survive restarts.
+func (c *CloudProvider) publishEstimates(ctx context.Context, excluding string) {
+	nodes, err := c.clientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Listing nodes to estimate simulated capacity, %s", err.Error())
+		return
+	}
+	prices := map[string]float64{}
+	for _, instanceType := range c.instanceTypes {
+		prices[instanceType.Name()] = instanceType.Price
+	}
+	counts := map[string]int{}
+	total := 0.0
+	for _, node := range nodes.Items {
+		if node.Name == excluding || !strings.HasPrefix(node.Spec.ProviderID, ProviderIDPrefix) {
+			continue
+		}
+		instanceType := node.Labels[v1.LabelInstanceTypeStable]
+		counts[instanceType]++
+		total += prices[instanceType]
+	}
+	nodeCountGaugeVec.Reset()
+	hourlyCostGaugeVec.Reset()
+	for instanceType, count := range counts {
+		nodeCountGaugeVec.WithLabelValues(instanceType).Set(float64(count))
+		hourlyCostGaugeVec.WithLabelValues(instanceType).Set(float64(count) * prices[instanceType])
+	}
+	logging.FromContext(ctx).Infof("Simulating %d node(s) with an estimated cost of %.2f per hour", sum(counts), total)
+}
+
+func sum(counts map[string]int) (total int) {
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
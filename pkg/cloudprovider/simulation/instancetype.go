@@ -0,0 +1,121 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// InstanceTypeOptions describes an instance type in the simulation's catalog
+type InstanceTypeOptions struct {
+	Name             string            `json:"name"`
+	Zones            []string          `json:"zones"`
+	Architecture     string            `json:"architecture,omitempty"`
+	OperatingSystems []string          `json:"operatingSystems,omitempty"`
+	CPU              resource.Quantity `json:"cpu"`
+	Memory           resource.Quantity `json:"memory"`
+	Pods             resource.Quantity `json:"pods"`
+	NvidiaGPUs       resource.Quantity `json:"nvidiaGPUs,omitempty"`
+	AMDGPUs          resource.Quantity `json:"amdGPUs,omitempty"`
+	AWSNeurons       resource.Quantity `json:"awsNeurons,omitempty"`
+	// ExtendedResources advertised by other device plugins, e.g. habana.ai/gaudi
+	ExtendedResources v1.ResourceList `json:"extendedResources,omitempty"`
+	Overhead          v1.ResourceList `json:"overhead,omitempty"`
+	// Price is the hourly price of the instance type, used to estimate the
+	// cost of simulated nodes
+	Price float64 `json:"price,omitempty"`
+}
+
+type InstanceType struct {
+	InstanceTypeOptions
+}
+
+// ParseInstanceTypes decodes a JSON catalog of instance types
+func ParseInstanceTypes(data []byte) ([]*InstanceType, error) {
+	options := []InstanceTypeOptions{}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, fmt.Errorf("decoding instance types, %w", err)
+	}
+	instanceTypes := []*InstanceType{}
+	for _, option := range options {
+		if option.Name == "" || len(option.Zones) == 0 {
+			return nil, fmt.Errorf("instance types must specify a name and zones, got %+v", option)
+		}
+		if option.Architecture == "" {
+			option.Architecture = v1alpha4.ArchitectureAmd64
+		}
+		if len(option.OperatingSystems) == 0 {
+			option.OperatingSystems = []string{v1alpha4.OperatingSystemLinux}
+		}
+		instanceTypes = append(instanceTypes, &InstanceType{InstanceTypeOptions: option})
+	}
+	return instanceTypes, nil
+}
+
+func (i *InstanceType) Name() string {
+	return i.InstanceTypeOptions.Name
+}
+
+func (i *InstanceType) HourlyPrice() float64 {
+	return i.InstanceTypeOptions.Price
+}
+
+func (i *InstanceType) Zones() []string {
+	return i.InstanceTypeOptions.Zones
+}
+
+func (i *InstanceType) Architecture() string {
+	return i.InstanceTypeOptions.Architecture
+}
+
+func (i *InstanceType) OperatingSystems() []string {
+	return i.InstanceTypeOptions.OperatingSystems
+}
+
+func (i *InstanceType) CPU() *resource.Quantity {
+	return &i.InstanceTypeOptions.CPU
+}
+
+func (i *InstanceType) Memory() *resource.Quantity {
+	return &i.InstanceTypeOptions.Memory
+}
+
+func (i *InstanceType) Pods() *resource.Quantity {
+	return &i.InstanceTypeOptions.Pods
+}
+
+func (i *InstanceType) Resources() v1.ResourceList {
+	return resources.NonZero(resources.Merge(v1.ResourceList{
+		v1.ResourceCPU:      i.InstanceTypeOptions.CPU,
+		v1.ResourceMemory:   i.InstanceTypeOptions.Memory,
+		v1.ResourcePods:     i.InstanceTypeOptions.Pods,
+		resources.NvidiaGPU: i.InstanceTypeOptions.NvidiaGPUs,
+		resources.AMDGPU:    i.InstanceTypeOptions.AMDGPUs,
+		resources.AWSNeuron: i.InstanceTypeOptions.AWSNeurons,
+	}, i.InstanceTypeOptions.ExtendedResources))
+}
+
+func (i *InstanceType) Overhead() v1.ResourceList {
+	if i.InstanceTypeOptions.Overhead == nil {
+		return v1.ResourceList{}
+	}
+	return i.InstanceTypeOptions.Overhead
+}
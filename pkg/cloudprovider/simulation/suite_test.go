@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/Simulation")
+}
+
+var _ = Describe("Simulation", func() {
+	Context("Instance Types", func() {
+		It("should default architecture and operating systems", func() {
+			instanceTypes, err := ParseInstanceTypes([]byte(`[{"name": "small", "zones": ["test-zone-1"], "cpu": "2", "memory": "4Gi", "pods": "10", "price": 0.1}]`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instanceTypes).To(HaveLen(1))
+			Expect(instanceTypes[0].Architecture()).To(Equal(v1alpha4.ArchitectureAmd64))
+			Expect(instanceTypes[0].OperatingSystems()).To(ConsistOf(v1alpha4.OperatingSystemLinux))
+			Expect(instanceTypes[0].CPU().String()).To(Equal("2"))
+		})
+		It("should fail for instance types without zones", func() {
+			_, err := ParseInstanceTypes([]byte(`[{"name": "small", "cpu": "2"}]`))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail for an empty catalog", func() {
+			_, err := ParseInstanceTypes([]byte(``))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Create", func() {
+		var clientSet *fake.Clientset
+		var simulation *CloudProvider
+		var instanceTypes []cloudprovider.InstanceType
+		BeforeEach(func() {
+			clientSet = fake.NewSimpleClientset()
+			catalog, err := ParseInstanceTypes([]byte(`[
+				{"name": "small", "zones": ["test-zone-1", "test-zone-2"], "cpu": "2", "memory": "4Gi", "pods": "10", "price": 0.2},
+				{"name": "large", "zones": ["test-zone-1", "test-zone-2"], "cpu": "8", "memory": "16Gi", "pods": "40", "price": 0.1}
+			]`))
+			Expect(err).ToNot(HaveOccurred())
+			simulation = NewCloudProvider(ctx, cloudprovider.Options{}, catalog)
+			simulation.clientSet = clientSet
+			instanceTypes, err = simulation.GetInstanceTypes(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("should launch kwok nodes of the cheapest instance type", func() {
+			nodes := []*v1.Node{}
+			Expect(<-simulation.Create(ctx, &v1alpha4.Constraints{Zones: []string{"test-zone-2"}}, instanceTypes, 2, func(node *v1.Node) error {
+				nodes = append(nodes, node)
+				_, err := clientSet.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+				return err
+			})).To(Succeed())
+			Expect(nodes).To(HaveLen(2))
+			for _, node := range nodes {
+				Expect(node.Annotations).To(HaveKeyWithValue(KwokNodeAnnotationKey, "fake"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "large"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+				persisted, err := clientSet.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(persisted.Status.Allocatable.Cpu().Cmp(resource.MustParse("8"))).To(Equal(0))
+			}
+		})
+	})
+})
@@ -16,11 +16,14 @@ package cloudprovider
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"knative.dev/pkg/apis"
 )
 
@@ -32,11 +35,16 @@ type CloudProvider interface {
 	// is fulfilled by the cloud providers capacity creation request. This API
 	// is called in parallel and then waits for all channels to return nil or error.
 	Create(context.Context, *v1alpha4.Constraints, []InstanceType, int, func(*v1.Node) error) chan error
+	// ValidateLaunch is a hook to veto launching the given quantity of nodes
+	// for the constraints and instance types before Create is called. Returns
+	// a LaunchError if the launch is vetoed (e.g. a quota would be exceeded).
+	ValidateLaunch(context.Context, *v1alpha4.Constraints, []InstanceType, int) error
 	// Delete node in cloudprovider
 	Delete(context.Context, *v1.Node) error
 	// GetInstanceTypes returns the instance types supported by the cloud
-	// provider limited by the provided constraints and daemons.
-	GetInstanceTypes(context.Context) ([]InstanceType, error)
+	// provider. Constraints may change the properties of the returned instance
+	// types (e.g. pod density). If constraints are nil, defaults are assumed.
+	GetInstanceTypes(context.Context, *v1alpha4.Constraints) ([]InstanceType, error)
 	// Default is a hook for additional defaulting logic at webhook time.
 	Default(context.Context, *v1alpha4.Constraints)
 	// Validate is a hook for additional validation logic at webhook time.
@@ -46,9 +54,116 @@ type CloudProvider interface {
 	Constrain(context.Context, *v1alpha4.Constraints, ...*v1.Pod) error
 }
 
+// ReadinessChecker is optionally implemented by cloud providers to delay the
+// controller's readiness until their startup checks (e.g. of permissions) have
+// finished. Checks that fail are reported by PermissionsChecker rather than
+// readiness.
+type ReadinessChecker interface {
+	ReadinessProbe(*http.Request) error
+}
+
+// PermissionsChecker is optionally implemented by cloud providers that verify
+// their own permissions (e.g. IAM policies), so that missing permissions are
+// reported in each provisioner's CloudProviderPermitted condition.
+type PermissionsChecker interface {
+	// MissingPermissions returns the permissions found missing by the last
+	// check, or nil if they haven't been checked
+	MissingPermissions() []string
+}
+
+// SettingsReporter is optionally implemented by cloud providers that are
+// configured with their own settings (e.g. flags), so that replicas running
+// with different settings can be detected
+type SettingsReporter interface {
+	// Settings returns the active settings, which are hashed
+	Settings() interface{}
+}
+
+// InterruptionNotifier is optionally implemented by cloud providers that are
+// notified of instances that are about to be interrupted (e.g. spot instances
+// being reclaimed), so that their nodes can be drained and replaced in advance.
+type InterruptionNotifier interface {
+	// GetInterruptions blocks until interruption notices are received or the
+	// context is done
+	GetInterruptions(context.Context) ([]*Interruption, error)
+	// AcknowledgeInterruption is called once the notice is handled, so that
+	// it isn't received again
+	AcknowledgeInterruption(context.Context, *Interruption) error
+}
+
+// InterruptionReason categorizes why an instance is being interrupted
+type InterruptionReason string
+
+const (
+	// SpotInterruption is received shortly before a spot instance is reclaimed
+	SpotInterruption InterruptionReason = "SpotInterruption"
+	// RebalanceRecommendation is received when a spot instance is at an
+	// elevated risk of being reclaimed
+	RebalanceRecommendation InterruptionReason = "RebalanceRecommendation"
+	// InstanceStopping is received when an instance is stopping or terminating,
+	// e.g. if it was terminated outside of Karpenter
+	InstanceStopping InterruptionReason = "InstanceStopping"
+)
+
+// Interruption is a notice that an instance is about to be interrupted
+type Interruption struct {
+	// InstanceID is the final segment of the interrupted node's provider id
+	InstanceID string
+	Reason     InterruptionReason
+	// Handle is an opaque identifier used by the cloud provider to
+	// acknowledge the notice
+	Handle string
+}
+
+// LifecyclePublisher is optionally implemented by cloud providers that
+// publish node lifecycle events to their messaging services, so that external
+// systems can track nodes without polling.
+type LifecyclePublisher interface {
+	// LifecycleSinks returns the configured sinks, if any
+	LifecycleSinks() []lifecycle.Sink
+}
+
+// ImageTracker is optionally implemented by cloud providers that resolve the
+// images nodes are launched with, so that nodes launched with an image that
+// has since been replaced, e.g. by a newer release, can be reported and
+// optionally marked drifted.
+type ImageTracker interface {
+	// ReplacedImage returns the image the node was launched with and the image
+	// that replaced it, or empty strings if it hasn't been replaced
+	ReplacedImage(*v1.Node) (image string, replacement string)
+}
+
+// LaunchStatisticsReporter is optionally implemented by cloud providers that
+// track how often launches of each instance type succeed, so that operators
+// can identify chronically unavailable pools and remove them from their
+// requirements.
+type LaunchStatisticsReporter interface {
+	// LaunchStatistics returns the recent launch outcomes of each instance
+	// type, zone and capacity type that has been launched
+	LaunchStatistics() []LaunchStatistics
+}
+
+// LaunchStatistics summarizes the recent launch outcomes of an instance type
+// in a zone with a capacity type
+type LaunchStatistics struct {
+	InstanceType string `json:"instanceType"`
+	Zone         string `json:"zone"`
+	CapacityType string `json:"capacityType"`
+	Successes    int    `json:"successes"`
+	Failures     int    `json:"failures"`
+	// SuccessRate is the ratio of successes to launches, from 0 to 1
+	SuccessRate float64 `json:"successRate"`
+	// Unavailable is true if the last launch failed due to insufficient
+	// capacity, in which case the cloud provider avoids it for a while
+	Unavailable bool `json:"unavailable"`
+}
+
 // Options are injected into cloud providers' factories
 type Options struct {
 	ClientSet *kubernetes.Clientset
+	// RESTConfig is for the cluster the controller runs in, which differs from
+	// the ClientSet's cluster if a remote workload cluster is configured
+	RESTConfig *rest.Config
 }
 
 // InstanceType describes the properties of a potential node
@@ -60,8 +175,31 @@ type InstanceType interface {
 	CPU() *resource.Quantity
 	Memory() *resource.Quantity
 	Pods() *resource.Quantity
-	NvidiaGPUs() *resource.Quantity
-	AMDGPUs() *resource.Quantity
-	AWSNeurons() *resource.Quantity
+	// Resources returns the capacity of the instance type, including cpu,
+	// memory and pods as well as any extended resources advertised by device
+	// plugins, e.g. nvidia.com/gpu or habana.ai/gaudi. Resources without
+	// capacity are omitted.
+	Resources() v1.ResourceList
 	Overhead() v1.ResourceList
 }
+
+// Offering is a zone and capacity type (e.g. spot or on-demand) that an
+// instance type can be launched in
+type Offering struct {
+	Zone         string `json:"zone"`
+	CapacityType string `json:"capacityType"`
+}
+
+// OfferedInstanceType is optionally implemented by instance types that are
+// offered with more than one capacity type, describing the capacity types
+// available in each of their zones
+type OfferedInstanceType interface {
+	Offerings() []Offering
+}
+
+// PricedInstanceType is optionally implemented by instance types with a known
+// hourly price, which is used to estimate the cost of launches. A price of
+// zero is unknown.
+type PricedInstanceType interface {
+	HourlyPrice() float64
+}
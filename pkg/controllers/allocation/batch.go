@@ -16,17 +16,89 @@ package allocation
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/pod"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const (
 	opAdd  = "add"
 	opWait = "wait"
+	// queuedTTL bounds how long a pod is tracked if it's never dequeued,
+	// e.g. if it was deleted or scheduled by another scheduler
+	queuedTTL = 1 * time.Hour
 )
 
+var queueWaitHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "pod_queue_wait_duration_seconds",
+		Help:      "Duration pods waited from being batched until they were bound to a provisioned node in seconds. Broken down by provisioner.",
+		Buckets:   metrics.DurationBuckets(),
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var queueDepthGaugeVec = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "pod_queue_depth",
+		Help:      "Number of pods waiting to be batched before provisioning. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var batchSizeHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "batch_size",
+		Help:      "Number of pods provisioned together in a batch. Broken down by provisioner.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var batchWindowHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "batch_window_duration_seconds",
+		Help:      "Duration of batch windows from the first pod until the window closed in seconds. Broken down by provisioner.",
+		Buckets:   metrics.DurationBuckets(),
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var batchDrainHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "batch_drain_duration_seconds",
+		Help:      "Duration to provision capacity for a batch of pods once the batch window closed in seconds. Broken down by provisioner.",
+		Buckets:   metrics.DurationBuckets(),
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(queueWaitHistogramVec)
+	crmetrics.Registry.MustRegister(queueDepthGaugeVec)
+	crmetrics.Registry.MustRegister(batchSizeHistogramVec)
+	crmetrics.Registry.MustRegister(batchWindowHistogramVec)
+	crmetrics.Registry.MustRegister(batchDrainHistogramVec)
+}
+
 // Batcher is a batch manager for multiple objects
 type Batcher struct {
 	// MaxPeriod is the maximum amount of time to batch incoming pods before flushing
@@ -41,16 +113,32 @@ type Batcher struct {
 	ops chan *batchOp
 	// isMonitorRunning indicates if the monitor go routine has been started
 	isMonitorRunning bool
+
+	// queued keeps a mapping of pod UIDs to the time they were first added to a batch
+	queued   map[types.UID]queuedPod
+	queuedMu sync.Mutex
+}
+
+// queuedPod is a pod waiting to be batched for a provisioner
+type queuedPod struct {
+	provisioner string
+	queued      time.Time
 }
 
 type batchOp struct {
-	kind    string
-	key     types.UID
-	waitEnd chan bool
+	kind       string
+	key        types.UID
+	name       string
+	maxPeriod  time.Duration
+	idlePeriod time.Duration
+	waitEnd    chan bool
 }
 
 // window is an individual batch window
 type window struct {
+	name        string
+	maxPeriod   time.Duration
+	idlePeriod  time.Duration
 	lastUpdated time.Time
 	started     time.Time
 	closed      []chan bool
@@ -62,6 +150,7 @@ func NewBatcher(maxPeriod time.Duration, idlePeriod time.Duration) *Batcher {
 		MaxPeriod:  maxPeriod,
 		IdlePeriod: idlePeriod,
 		windows:    map[types.UID]*window{},
+		queued:     map[types.UID]queuedPod{},
 	}
 }
 
@@ -80,17 +169,91 @@ func (b *Batcher) Start(ctx context.Context) {
 // Add is safe to be called concurrently
 func (b *Batcher) Add(obj metav1.Object) {
 	select {
-	case b.ops <- &batchOp{kind: opAdd, key: obj.GetUID()}:
+	case b.ops <- b.newBatchOp(opAdd, obj):
 	// Do not block if the channel is full
 	default:
 	}
 }
 
+// Enqueue records the time a pod was first added to a batch for the provisioner
+// Enqueue is safe to be called concurrently
+func (b *Batcher) Enqueue(provisioner metav1.Object, pod metav1.Object) {
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+	if _, ok := b.queued[pod.GetUID()]; !ok {
+		b.queued[pod.GetUID()] = queuedPod{provisioner: provisioner.GetName(), queued: time.Now()}
+		queueDepthGaugeVec.WithLabelValues(provisioner.GetName()).Inc()
+	}
+}
+
+// Dequeue stops tracking the pod and returns how long it waited since it was
+// first added to a batch, recording the wait for the provisioner. Returns
+// false if the pod was never enqueued.
+// Dequeue is safe to be called concurrently
+func (b *Batcher) Dequeue(provisioner metav1.Object, pod metav1.Object) (time.Duration, bool) {
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+	queued, ok := b.queued[pod.GetUID()]
+	if !ok {
+		return 0, false
+	}
+	delete(b.queued, pod.GetUID())
+	queueDepthGaugeVec.WithLabelValues(queued.provisioner).Dec()
+	wait := time.Since(queued.queued)
+	queueWaitHistogramVec.WithLabelValues(provisioner.GetName()).Observe(wait.Seconds())
+	return wait, true
+}
+
+// QueueWaitsByPriority sorts the pods so that higher priority pods come
+// first, and returns how long each pod that was enqueued has waited so far.
+// Pods stay queued until they're dequeued once they're bound, so that pods
+// that fail to schedule or launch keep their original queue time.
+// QueueWaitsByPriority is safe to be called concurrently
+func (b *Batcher) QueueWaitsByPriority(pods []*v1.Pod) map[types.UID]time.Duration {
+	pod.SortByPriority(pods)
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+	waits := map[types.UID]time.Duration{}
+	for _, p := range pods {
+		if queued, ok := b.queued[p.UID]; ok {
+			waits[p.UID] = time.Since(queued.queued)
+		}
+	}
+	return waits
+}
+
+// pruneQueued stops tracking pods that were never dequeued
+func (b *Batcher) pruneQueued() {
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+	for uid, queued := range b.queued {
+		if time.Since(queued.queued) > queuedTTL {
+			delete(b.queued, uid)
+			queueDepthGaugeVec.WithLabelValues(queued.provisioner).Dec()
+		}
+	}
+}
+
+// Depth returns the number of pods waiting to be batched for the provisioner
+// Depth is safe to be called concurrently
+func (b *Batcher) Depth(provisioner metav1.Object) int {
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+	depth := 0
+	for _, queued := range b.queued {
+		if queued.provisioner == provisioner.GetName() {
+			depth++
+		}
+	}
+	return depth
+}
+
 // Wait blocks until a batching window ends
 // If the batch is empty, it will block until something is added or the window times out
 func (b *Batcher) Wait(obj metav1.Object) {
-	waitBatchOp := &batchOp{kind: opWait, key: obj.GetUID(), waitEnd: make(chan bool, 1)}
-	timeout := time.NewTimer(b.MaxPeriod)
+	waitBatchOp := b.newBatchOp(opWait, obj)
+	waitBatchOp.waitEnd = make(chan bool, 1)
+	timeout := time.NewTimer(waitBatchOp.maxPeriod)
 	select {
 	case b.ops <- waitBatchOp:
 		<-waitBatchOp.waitEnd
@@ -99,11 +262,27 @@ func (b *Batcher) Wait(obj metav1.Object) {
 	}
 }
 
+// newBatchOp constructs an operation on the object's batch window. Provisioners
+// may override the batcher's periods with their spec.
+func (b *Batcher) newBatchOp(kind string, obj metav1.Object) *batchOp {
+	op := &batchOp{kind: kind, key: obj.GetUID(), name: obj.GetName(), maxPeriod: b.MaxPeriod, idlePeriod: b.IdlePeriod}
+	if provisioner, ok := obj.(*v1alpha4.Provisioner); ok {
+		if provisioner.Spec.MaxBatchDuration != nil {
+			op.maxPeriod = provisioner.Spec.MaxBatchDuration.Duration
+		}
+		if provisioner.Spec.BatchIdleDuration != nil {
+			op.idlePeriod = provisioner.Spec.BatchIdleDuration.Duration
+		}
+	}
+	return op
+}
+
 // monitor is a synchronous loop that controls the window start, update, and end
 // monitor should be executed in one go routine and will handle all object batch windows
 func (b *Batcher) monitor(ctx context.Context) {
 	defer func() { b.isMonitorRunning = false }()
-	ticker := time.NewTicker(b.IdlePeriod / 2)
+	tick := b.IdlePeriod / 2
+	ticker := time.NewTicker(tick)
 	for {
 		select {
 		// Wake and check for any timed out batch windows
@@ -111,20 +290,27 @@ func (b *Batcher) monitor(ctx context.Context) {
 			for key, batch := range b.windows {
 				b.checkForWindowEndAndNotify(key, batch)
 			}
+			b.pruneQueued()
 		// Process window operations
 		case op := <-b.ops:
 			switch op.kind {
 			// Start a new window or update progress on a window
 			case opAdd:
-				b.startOrUpdateWindow(op.key)
+				b.startOrUpdateWindow(op)
 			// Register a waiter and start a window if no window has been started
 			case opWait:
 				window, ok := b.windows[op.key]
 				if !ok {
-					window = b.startOrUpdateWindow(op.key)
+					window = b.startOrUpdateWindow(op)
 				}
 				window.closed = append(window.closed, op.waitEnd)
 			}
+			// Check windows at least twice per idle period, including
+			// windows with shorter idle periods than the default
+			if half := op.idlePeriod / 2; half > 0 && half < tick {
+				tick = half
+				ticker.Reset(tick)
+			}
 		// Stop monitor routine on shutdown
 		case <-ctx.Done():
 			for key, window := range b.windows {
@@ -135,10 +321,10 @@ func (b *Batcher) monitor(ctx context.Context) {
 	}
 }
 
-// checkForWindowEndAndNotify checks if a window has timed out due to inactivity (IdlePeriod) or has reached the MaxBatchPeriod.
+// checkForWindowEndAndNotify checks if a window has timed out due to inactivity (idlePeriod) or has reached the maxPeriod.
 // If the batch window has ended, then the batch closed channel will be notified and the window will be removed
 func (b *Batcher) checkForWindowEndAndNotify(key types.UID, window *window) {
-	if time.Since(window.lastUpdated) < b.IdlePeriod && time.Since(window.started) < b.MaxPeriod {
+	if time.Since(window.lastUpdated) < window.idlePeriod && time.Since(window.started) < window.maxPeriod {
 		return
 	}
 	b.endWindow(key, window)
@@ -146,6 +332,9 @@ func (b *Batcher) checkForWindowEndAndNotify(key types.UID, window *window) {
 
 // endWindow signals the end of a window to all wait consumers and deletes the window
 func (b *Batcher) endWindow(key types.UID, window *window) {
+	if window.name != "" {
+		batchWindowHistogramVec.WithLabelValues(window.name).Observe(time.Since(window.started).Seconds())
+	}
 	for _, end := range window.closed {
 		select {
 		case end <- true:
@@ -157,14 +346,15 @@ func (b *Batcher) endWindow(key types.UID, window *window) {
 }
 
 // startOrUpdateWindow starts a new window for the object key if one does not already exist
-// if a window already exists for the object key, then the lastUpdate time is set
-func (b *Batcher) startOrUpdateWindow(key types.UID) *window {
-	batchWindow, ok := b.windows[key]
+// if a window already exists for the object key, then the lastUpdate time and periods are set
+func (b *Batcher) startOrUpdateWindow(op *batchOp) *window {
+	batchWindow, ok := b.windows[op.key]
 	if !ok {
-		batchWindow = &window{lastUpdated: time.Now(), started: time.Now()}
-		b.windows[key] = batchWindow
+		batchWindow = &window{name: op.name, maxPeriod: op.maxPeriod, idlePeriod: op.idlePeriod, lastUpdated: time.Now(), started: time.Now()}
+		b.windows[op.key] = batchWindow
 		return batchWindow
 	}
+	batchWindow.maxPeriod, batchWindow.idlePeriod = op.maxPeriod, op.idlePeriod
 	batchWindow.lastUpdated = time.Now()
 	if batchWindow.started.IsZero() {
 		batchWindow.started = time.Now()
@@ -17,10 +17,14 @@ package allocation
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
 	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
@@ -51,6 +55,7 @@ func init() {
 type Binder struct {
 	KubeClient   client.Client
 	CoreV1Client corev1.CoreV1Interface
+	Notifier     *lifecycle.Notifier
 }
 
 func (b *Binder) Bind(ctx context.Context, node *v1.Node, pods []*v1.Pod) error {
@@ -95,7 +100,17 @@ func (b *Binder) bind(ctx context.Context, node *v1.Node, pods []*v1.Pod) error
 		Key:    v1alpha4.NotReadyTaintKey,
 		Effect: v1.TaintEffectNoSchedule,
 	})
-	// 3. Idempotently create a node. In rare cases, nodes can come online and
+	// 3. Record the extended resources (e.g. GPUs) requested by the pods. The
+	// node controller keeps the node tainted until device plugins have
+	// registered them as allocatable, otherwise pods bound early fail with
+	// UnexpectedAdmissionError.
+	pods = unbound(pods)
+	if extended := resources.ExtendedRequests(pods...); len(extended) > 0 {
+		node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{
+			v1alpha4.ExtendedResourcesAnnotationKey: strings.Join(extended, ","),
+		})
+	}
+	// 4. Idempotently create a node. In rare cases, nodes can come online and
 	// self register before the controller is able to register a node object
 	// with the API server. In the common case, we create the node object
 	// ourselves to enforce the binding decision and enable images to be pulled
@@ -105,17 +120,37 @@ func (b *Binder) bind(ctx context.Context, node *v1.Node, pods []*v1.Pod) error
 			return fmt.Errorf("creating node %s, %w", node.Name, err)
 		}
 	}
+	b.Notifier.Notify(ctx, lifecycle.Created, node)
 
-	// 4. Bind pods
+	// 5. Bind pods. Pods that are already bound, i.e. pods of terminating nodes
+	// that capacity was provisioned for in advance, are skipped and will be
+	// rescheduled once they're evicted. Pods constructed for scale hints don't
+	// exist yet, and are scheduled by the kube scheduler once created. Pods
+	// requesting extended resources are nominated to the node instead, and
+	// bound by the node controller once the node is initialized.
 	errs := make([]error, len(pods))
 	workqueue.ParallelizeUntil(ctx, len(pods), len(pods), func(index int) {
-		errs[index] = b.bindPod(ctx, node, pods[index])
+		if len(resources.ExtendedRequests(pods[index])) > 0 {
+			errs[index] = b.nominatePod(ctx, node, pods[index])
+		} else {
+			errs[index] = b.bindPod(ctx, node, pods[index])
+		}
 	})
 	err := multierr.Combine(errs...)
-	logging.FromContext(ctx).Infof("Bound %d pod(s) to node %s", len(pods)-len(multierr.Errors(err)), node.Name)
+	logging.FromContext(ctx).Infof("Bound or nominated %d pod(s) to node %s", len(pods)-len(multierr.Errors(err)), node.Name)
 	return err
 }
 
+func unbound(pods []*v1.Pod) []*v1.Pod {
+	result := []*v1.Pod{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" && !isScaleHint(pod) {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
+
 func (b *Binder) bindPod(ctx context.Context, node *v1.Node, pod *v1.Pod) error {
 	if err := b.CoreV1Client.Pods(pod.Namespace).Bind(ctx, &v1.Binding{
 		TypeMeta:   pod.TypeMeta,
@@ -126,3 +161,12 @@ func (b *Binder) bindPod(ctx context.Context, node *v1.Node, pod *v1.Pod) error
 	}
 	return nil
 }
+
+func (b *Binder) nominatePod(ctx context.Context, node *v1.Node, pod *v1.Pod) error {
+	persisted := pod.DeepCopy()
+	pod.Status.NominatedNodeName = node.Name
+	if err := b.KubeClient.Status().Patch(ctx, pod, client.MergeFrom(persisted)); err != nil {
+		return fmt.Errorf("nominating pod, %w", err)
+	}
+	return nil
+}
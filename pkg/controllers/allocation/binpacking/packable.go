@@ -32,6 +32,8 @@ type Packable struct {
 	cloudprovider.InstanceType
 	reserved v1.ResourceList
 	total    v1.ResourceList
+	// limitsPercent of pods' limits in excess of their requests are reserved
+	limitsPercent int32
 }
 
 type Result struct {
@@ -45,6 +47,7 @@ func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceTyp
 	packables := []*Packable{}
 	for _, instanceType := range instanceTypes {
 		packable := PackableFor(instanceType)
+		packable.limitsPercent = schedule.Overcommit.GetLimitsPercent()
 		// 1. First pass at filtering down to viable instance types;
 		// additional filtering will be done by later steps (such as
 		// removing instance types that obviously lack resources, such
@@ -54,12 +57,10 @@ func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceTyp
 			packable.validateInstanceType(schedule),
 			packable.validateArchitecture(schedule),
 			packable.validateOperatingSystem(schedule),
-			// Although this will remove instances that have GPUs when
-			// not required, removal of instance types that *lack*
-			// GPUs will be done later.
-			packable.validateNvidiaGpus(schedule),
-			packable.validateAMDGpus(schedule),
-			packable.validateAWSNeurons(schedule),
+			// Although this will remove instances that have extended
+			// resources like GPUs when not required, removal of instance
+			// types that *lack* them will be done later.
+			packable.validateExtendedResources(schedule),
 		); err != nil {
 			continue
 		}
@@ -78,17 +79,35 @@ func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceTyp
 	return packables
 }
 
+// OversizedPods returns the schedule's pods that don't fit on any viable
+// instance type, even if packed alone.
+func OversizedPods(ctx context.Context, instanceTypes []cloudprovider.InstanceType, schedule *scheduling.Schedule) []*v1.Pod {
+	packables := PackablesFor(ctx, instanceTypes, schedule)
+	oversized := []*v1.Pod{}
+	for _, pod := range schedule.Pods {
+		if !fitsAny(pod, packables) {
+			oversized = append(oversized, pod)
+		}
+	}
+	return oversized
+}
+
+func fitsAny(pod *v1.Pod, packables []*Packable) bool {
+	for _, packable := range packables {
+		// Reserving replaces rather than mutates the reserved resources, so a
+		// shallow copy leaves the packable untouched
+		candidate := *packable
+		if candidate.reservePod(pod) {
+			return true
+		}
+	}
+	return false
+}
+
 func PackableFor(i cloudprovider.InstanceType) *Packable {
 	return &Packable{
 		InstanceType: i,
-		total: v1.ResourceList{
-			v1.ResourceCPU:      *i.CPU(),
-			v1.ResourceMemory:   *i.Memory(),
-			resources.NvidiaGPU: *i.NvidiaGPUs(),
-			resources.AMDGPU:    *i.AMDGPUs(),
-			resources.AWSNeuron: *i.AWSNeurons(),
-			v1.ResourcePods:     *i.Pods(),
-		},
+		total:        i.Resources(),
 	}
 }
 
@@ -119,10 +138,10 @@ func (p *Packable) Pack(pods []*v1.Pod) *Result {
 // fits checks if adding the pod would overflow the total resources
 // available. It also ensures that instance types that could not
 // possibly satisfy the pod at all (for example if the pod needs
-// NvidiaGPUs and the instance type doesn't have any) will be
+// GPUs and the instance type doesn't have any) will be
 // eliminated from consideration.
 func (p *Packable) fits(pod *v1.Pod) bool {
-	minResourceList := resources.RequestsForPods(pod)
+	minResourceList := p.requestsFor(pod)
 	for resourceName, totalQuantity := range p.total {
 		reservedQuantity := p.reserved[resourceName].DeepCopy()
 		reservedQuantity.Add(minResourceList[resourceName])
@@ -146,11 +165,31 @@ func (p *Packable) reserve(requests v1.ResourceList) bool {
 }
 
 func (p *Packable) reservePod(pod *v1.Pod) bool {
-	requests := resources.RequestsForPods(pod)
+	requests := p.requestsFor(pod)
 	requests[v1.ResourcePods] = *resource.NewQuantity(1, resource.BinarySI)
 	return p.reserve(requests)
 }
 
+// requestsFor returns the resources reserved for the pods, which are their
+// requests plus the overcommit percentage of their limits in excess of them.
+func (p *Packable) requestsFor(pods ...*v1.Pod) v1.ResourceList {
+	requests := resources.RequestsForPods(pods...)
+	if p.limitsPercent == 0 {
+		return requests
+	}
+	for resourceName, limit := range resources.LimitsForPods(pods...) {
+		request := requests[resourceName]
+		if limit.Cmp(request) <= 0 {
+			continue
+		}
+		excess := limit.DeepCopy()
+		excess.Sub(request)
+		request.Add(*resource.NewMilliQuantity(excess.MilliValue()*int64(p.limitsPercent)/100, excess.Format))
+		requests[resourceName] = request
+	}
+	return requests
+}
+
 func (p *Packable) validateInstanceType(schedule *scheduling.Schedule) error {
 	if !functional.ContainsString(schedule.InstanceTypes, p.Name()) {
 		return fmt.Errorf("instance type %s is not in %v", p.Name(), schedule.InstanceTypes)
@@ -179,46 +218,17 @@ func (p *Packable) validateZones(schedule *scheduling.Schedule) error {
 	return nil
 }
 
-func (p *Packable) validateNvidiaGpus(schedule *scheduling.Schedule) error {
-	if p.InstanceType.NvidiaGPUs().IsZero() {
-		return nil
-	}
-	for _, pod := range schedule.Pods {
-		for _, container := range pod.Spec.Containers {
-			if _, ok := container.Resources.Requests[resources.NvidiaGPU]; ok {
-				return nil
-			}
-		}
-	}
-	return fmt.Errorf("nvidia gpu is not required")
-}
-
-func (p *Packable) validateAMDGpus(schedule *scheduling.Schedule) error {
-	if p.InstanceType.AMDGPUs().IsZero() {
-		return nil
-	}
-	for _, pod := range schedule.Pods {
-		for _, container := range pod.Spec.Containers {
-			if _, ok := container.Resources.Requests[resources.AMDGPU]; ok {
-				return nil
-			}
-		}
-	}
-	return fmt.Errorf("amd gpu is not required")
-}
-
-func (p *Packable) validateAWSNeurons(schedule *scheduling.Schedule) error {
-	if p.InstanceType.AWSNeurons().IsZero() {
-		return nil
-	}
-	for _, pod := range schedule.Pods {
-		for _, container := range pod.Spec.Containers {
-			if _, ok := container.Resources.Requests[resources.AWSNeuron]; ok {
-				return nil
-			}
+// validateExtendedResources excludes instance types with extended resources,
+// e.g. GPUs, that none of the schedule's pods request, so that they're
+// reserved for the workloads that need them.
+func (p *Packable) validateExtendedResources(schedule *scheduling.Schedule) error {
+	requested := resources.ExtendedRequests(schedule.Pods...)
+	for resourceName := range p.total {
+		if resources.IsExtended(resourceName) && !functional.ContainsString(requested, string(resourceName)) {
+			return fmt.Errorf("%s is not required", resourceName)
 		}
 	}
-	return fmt.Errorf("aws neuron is not required")
+	return nil
 }
 
 func packableNames(instanceTypes []*Packable) []string {
@@ -25,6 +25,7 @@ import (
 	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
 	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/utils/apiobject"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/awslabs/karpenter/pkg/utils/resources"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -91,16 +92,127 @@ func (p *packer) Pack(ctx context.Context, schedule *scheduling.Schedule, instan
 	// Sort pods in decreasing order by the amount of CPU requested, if
 	// CPU requested is equal compare memory requested.
 	sort.Sort(sort.Reverse(ByResourcesRequested{SortablePods: schedule.Pods}))
+	packings, unpackable := p.packCheapestArchitecture(ctx, schedule, instances)
+	if len(unpackable) > 0 {
+		logging.FromContext(ctx).Errorf("Failed to compute packing, pod(s) %s did not fit in instance type option(s) %v", apiobject.PodNamespacedNames(unpackable), packableNames(PackablesFor(ctx, instances, schedule)))
+	}
+	for _, packing := range packings {
+		logging.FromContext(ctx).Infof("Computed packing of %d node(s) for %d pod(s) with instance type option(s) %s", packing.NodeQuantity, flattenedLen(packing.Pods...), instanceTypeNames(packing.InstanceTypeOptions))
+	}
+	return packings
+}
+
+// packCheapestArchitecture packs the schedule separately for each architecture
+// its pods support and returns the packings that fit the most pods at the
+// lowest cost, so that architectures aren't mixed arbitrarily across instance
+// type options. Packings are compared by the hourly price of their cheapest
+// instance type options, which are only those offered in the schedule's zones,
+// or by their size if any option isn't priced.
+func (p *packer) packCheapestArchitecture(ctx context.Context, schedule *scheduling.Schedule, instances []cloudprovider.InstanceType) ([]*Packing, []*v1.Pod) {
+	if len(schedule.Architectures) <= 1 {
+		return p.pack(ctx, schedule, instances)
+	}
+	var best *architecturePacking
+	for _, architecture := range supportedArchitectures(schedule) {
+		constraints := schedule.Constraints.DeepCopy()
+		constraints.Architectures = []string{architecture}
+		packings, unpackable := p.pack(ctx, &scheduling.Schedule{Constraints: constraints, Pods: schedule.Pods, Daemons: schedule.Daemons}, instances)
+		candidate := newArchitecturePacking(packings, unpackable)
+		if best == nil || candidate.betterThan(best) {
+			best = candidate
+		}
+	}
+	return best.packings, best.unpackable
+}
+
+// supportedArchitectures returns the schedule's architectures that its pods
+// support, sorted for deterministic tie breaking. Pods that select or prefer
+// architectures support every architecture the schedule allows, since the
+// schedule is constrained by them. Other pods' images may only support amd64,
+// so they're packed for amd64 if the schedule allows it.
+func supportedArchitectures(schedule *scheduling.Schedule) []string {
+	architectures := append(schedule.Architectures[:0:0], schedule.Architectures...)
+	sort.Strings(architectures)
+	for _, pod := range schedule.Pods {
+		if !selectsArchitecture(pod) && functional.ContainsString(architectures, v1alpha4.ArchitectureAmd64) {
+			return []string{v1alpha4.ArchitectureAmd64}
+		}
+	}
+	return architectures
+}
+
+// selectsArchitecture returns true if the pod's node selector or node affinity
+// constrains its architecture
+func selectsArchitecture(pod *v1.Pod) bool {
+	if _, ok := pod.Spec.NodeSelector[v1.LabelArchStable]; ok {
+		return true
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return false
+	}
+	terms := []v1.NodeSelectorTerm{}
+	if required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		terms = append(terms, required.NodeSelectorTerms...)
+	}
+	for _, preferred := range pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		terms = append(terms, preferred.Preference)
+	}
+	for _, term := range terms {
+		for _, requirement := range term.MatchExpressions {
+			if requirement.Key == v1.LabelArchStable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// architecturePacking is the packing of a schedule for a single architecture
+type architecturePacking struct {
+	packings   []*Packing
+	unpackable []*v1.Pod
+	price      float64
+	priced     bool
+	weight     float64
+}
+
+func newArchitecturePacking(packings []*Packing, unpackable []*v1.Pod) *architecturePacking {
+	a := &architecturePacking{packings: packings, unpackable: unpackable, priced: true}
+	for _, packing := range packings {
+		price, ok := cloudprovider.CheapestPrice(packing.InstanceTypeOptions)
+		a.price += price * float64(packing.NodeQuantity)
+		a.priced = a.priced && ok
+		a.weight += weightOf(packing.InstanceTypeOptions[0]) * float64(packing.NodeQuantity)
+	}
+	return a
+}
+
+// betterThan returns true if the packing fits more pods, or as many pods at a
+// lower cost
+func (a *architecturePacking) betterThan(other *architecturePacking) bool {
+	if len(a.unpackable) != len(other.unpackable) {
+		return len(a.unpackable) < len(other.unpackable)
+	}
+	if a.priced && other.priced {
+		return a.price < other.price
+	}
+	return a.weight < other.weight
+}
+
+// pack returns the node packings for the schedule and the pods that could not
+// be packed onto any instance type.
+func (p *packer) pack(ctx context.Context, schedule *scheduling.Schedule, instances []cloudprovider.InstanceType) ([]*Packing, []*v1.Pod) {
 	packs := map[uint64]*Packing{}
 	var packings []*Packing
 	var packing *Packing
+	var unpackable []*v1.Pod
 	remainingPods := schedule.Pods
 	for len(remainingPods) > 0 {
 		packables := PackablesFor(ctx, instances, schedule)
 		packing, remainingPods = p.packWithLargestPod(schedule.Constraints, remainingPods, packables)
 		// checked all instance types and found no packing option
 		if flattenedLen(packing.Pods...) == 0 {
-			logging.FromContext(ctx).Errorf("Failed to compute packing, pod(s) %s did not fit in instance type option(s) %v", apiobject.PodNamespacedNames(remainingPods), packableNames(packables))
+			unpackable = append(unpackable, remainingPods[0])
 			remainingPods = remainingPods[1:]
 			continue
 		}
@@ -109,16 +221,14 @@ func (p *packer) Pack(ctx context.Context, schedule *scheduling.Schedule, instan
 			if mainPack, ok := packs[key]; ok {
 				mainPack.NodeQuantity++
 				mainPack.Pods = append(mainPack.Pods, packing.Pods...)
-				logging.FromContext(ctx).Infof("Incremented node count to %d on packing for %d pod(s) with instance type option(s) %v", mainPack.NodeQuantity, flattenedLen(packing.Pods...), instanceTypeNames(mainPack.InstanceTypeOptions))
 				continue
 			} else {
 				packs[key] = packing
 			}
 		}
 		packings = append(packings, packing)
-		logging.FromContext(ctx).Infof("Computed packing for %d pod(s) with instance type option(s) %s", flattenedLen(packing.Pods...), instanceTypeNames(packing.InstanceTypeOptions))
 	}
-	return packings
+	return packings, unpackable
 }
 
 // packWithLargestPod will try to pack max number of pods with largest pod in
@@ -183,17 +293,29 @@ func sortByResources(instanceTypes []cloudprovider.InstanceType) {
 }
 
 // weightOf uses a euclidean distance function to compare the instance types.
-// Units are normalized such that 1cpu = 1gb mem. Additionally, accelerators
-// carry an arbitrarily large weight such that they will dominate the priority,
-// but if equal, will still fall back to the weight of other dimensions.
+// Units are normalized such that 1cpu = 1gb mem. Additionally, extended
+// resources like accelerators carry an arbitrarily large weight such that they
+// will dominate the priority, but if equal, will still fall back to the weight
+// of other dimensions.
 func weightOf(instanceType cloudprovider.InstanceType) float64 {
-	return euclidean(
+	values := []float64{
 		float64(instanceType.CPU().Value()),
 		float64(instanceType.Memory().ScaledValue(resource.Giga)), // 1 gb = 1 cpu
-		float64(instanceType.NvidiaGPUs().Value())*1000,           // Heavily weigh gpus x 1000
-		float64(instanceType.AMDGPUs().Value())*1000,              // Heavily weigh gpus x 1000
-		float64(instanceType.AWSNeurons().Value())*1000,           // Heavily weigh neurons x 1000
-	)
+	}
+	capacity := instanceType.Resources()
+	extended := []string{}
+	for resourceName := range capacity {
+		if resources.IsExtended(resourceName) {
+			extended = append(extended, string(resourceName))
+		}
+	}
+	// Sorted so that the weight doesn't depend on map iteration order
+	sort.Strings(extended)
+	for _, resourceName := range extended {
+		quantity := capacity[v1.ResourceName(resourceName)]
+		values = append(values, float64(quantity.Value())*1000) // Heavily weigh extended resources x 1000
+	}
+	return euclidean(values...)
 }
 
 // euclidean measures the n-dimensional distance from the origin.
@@ -0,0 +1,106 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+)
+
+const (
+	// launchFailureThreshold is the number of consecutive failed provisioning
+	// loops after which a provisioner's launches are suspended
+	launchFailureThreshold = 3
+	// launchCooldown is how long launches are first suspended for. It doubles
+	// for each further failure, up to maxLaunchCooldown.
+	launchCooldown    = 1 * time.Minute
+	maxLaunchCooldown = 15 * time.Minute
+)
+
+// Breaker suspends launches for provisioners whose launches keep failing (e.g.
+// due to a misconfigured subnet or instance profile), instead of calling the
+// cloud provider on every provisioning loop. Once the cooldown elapses, the
+// next loop attempts to launch again, and closes the circuit if it succeeds.
+type Breaker struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit tracks the launch failures of a provisioner
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+func NewBreaker() *Breaker {
+	return &Breaker{circuits: map[string]*circuit{}}
+}
+
+// Open returns how long the provisioner's launches remain suspended for, or
+// zero if they aren't
+func (b *Breaker) Open(provisioner *v1alpha4.Provisioner) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[provisioner.Name]
+	if !ok {
+		return 0
+	}
+	if remaining := c.openUntil.Sub(injectabletime.Now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Failure records a failed provisioning loop. If the provisioner's launches
+// are suspended as a result, the cooldown is returned.
+func (b *Breaker) Failure(provisioner *v1alpha4.Provisioner) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[provisioner.Name]
+	if !ok {
+		c = &circuit{}
+		b.circuits[provisioner.Name] = c
+	}
+	c.failures++
+	if c.failures < launchFailureThreshold {
+		return 0
+	}
+	cooldown := launchCooldown
+	for i := launchFailureThreshold; i < c.failures && cooldown < maxLaunchCooldown; i++ {
+		cooldown *= 2
+	}
+	if cooldown > maxLaunchCooldown {
+		cooldown = maxLaunchCooldown
+	}
+	c.openUntil = injectabletime.Now().Add(cooldown)
+	return cooldown
+}
+
+// Success records a successful provisioning loop, closing the circuit
+func (b *Breaker) Success(provisioner *v1alpha4.Provisioner) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.circuits, provisioner.Name)
+}
+
+// Delete forgets the failures of a provisioner that no longer exists
+func (b *Breaker) Delete(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.circuits, name)
+}
@@ -0,0 +1,128 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// diagnosis collects why a provisioning loop didn't launch capacity for all
+// of its pods, which is published to the provisioner's status conditions.
+type diagnosis struct {
+	mu                sync.Mutex
+	unsatisfiable     []string
+	limits            map[string]int
+	cloudProviderErrs []error
+}
+
+func newDiagnosis() *diagnosis {
+	return &diagnosis{limits: map[string]int{}}
+}
+
+// unschedulable records pods that the scheduler ignored
+func (d *diagnosis) unschedulable(podErrs []*scheduling.PodError) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, podErr := range podErrs {
+		if isScaleHint(podErr.Pod) {
+			continue
+		}
+		d.unsatisfiable = append(d.unsatisfiable, podErr.Error())
+	}
+}
+
+// oversized records pods that don't fit on any of the instance types
+func (d *diagnosis) oversized(pods []*v1.Pod) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, pod := range pods {
+		d.unsatisfiable = append(d.unsatisfiable, fmt.Sprintf("pod %s/%s doesn't fit on any instance type", pod.Namespace, pod.Name))
+	}
+}
+
+// limitExceeded records nodes that weren't launched to stay within a limit
+func (d *diagnosis) limitExceeded(limit string, nodes int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.limits[limit] += nodes
+}
+
+// cloudProviderError records errors from the cloud provider, including
+// launches that it vetoed
+func (d *diagnosis) cloudProviderError(errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, err := range errs {
+		d.cloudProviderErrs = append(d.cloudProviderErrs, multierr.Errors(err)...)
+	}
+}
+
+// updateConditions sets the provisioner's Unsatisfiable, LimitExceeded and
+// CloudProviderError conditions from the diagnosis, and removes those that
+// the provisioning loop didn't hit. They're informational, so they don't
+// affect the provisioner's Active condition.
+func (c *Controller) updateConditions(ctx context.Context, provisioner *v1alpha4.Provisioner, d *diagnosis) error {
+	persisted := provisioner.DeepCopy()
+	conditions := provisioner.StatusConditions()
+	if len(d.unsatisfiable) > 0 {
+		conditions.SetCondition(informational(v1alpha4.Unsatisfiable, "PodsUnsatisfiable", fmt.Sprintf("%d pod(s) can't be scheduled, %s", len(d.unsatisfiable), d.unsatisfiable[0])))
+	} else if err := conditions.ClearCondition(v1alpha4.Unsatisfiable); err != nil {
+		return err
+	}
+	if len(d.limits) > 0 {
+		limits := []string{}
+		for limit, nodes := range d.limits {
+			limits = append(limits, fmt.Sprintf("reached %s limit, deferring %d node(s)", limit, nodes))
+		}
+		sort.Strings(limits)
+		conditions.SetCondition(informational(v1alpha4.LimitExceeded, "LimitExceeded", strings.Join(limits, "; ")))
+	} else if err := conditions.ClearCondition(v1alpha4.LimitExceeded); err != nil {
+		return err
+	}
+	if len(d.cloudProviderErrs) > 0 {
+		conditions.SetCondition(informational(v1alpha4.CloudProviderError, "CloudProviderError", multierr.Combine(d.cloudProviderErrs...).Error()))
+	} else if err := conditions.ClearCondition(v1alpha4.CloudProviderError); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(persisted.Status.Conditions, provisioner.Status.Conditions) {
+		return nil
+	}
+	if err := c.KubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+		return fmt.Errorf("patching provisioner status, %w", err)
+	}
+	return nil
+}
+
+func informational(conditionType apis.ConditionType, reason string, message string) apis.Condition {
+	return apis.Condition{
+		Type:     conditionType,
+		Status:   v1.ConditionTrue,
+		Reason:   reason,
+		Message:  message,
+		Severity: apis.ConditionSeverityInfo,
+	}
+}
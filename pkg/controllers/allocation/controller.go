@@ -17,13 +17,16 @@ package allocation
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"go.uber.org/multierr"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"knative.dev/pkg/logging"
 	controllerruntime "sigs.k8s.io/controller-runtime"
@@ -41,7 +44,10 @@ import (
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
 )
 
 const (
@@ -52,24 +58,35 @@ const (
 // Controller for the resource
 type Controller struct {
 	Batcher       *Batcher
+	Breaker       *Breaker
 	Filter        *Filter
 	Binder        *Binder
 	Scheduler     *scheduling.Scheduler
 	Packer        binpacking.Packer
 	CloudProvider cloudprovider.CloudProvider
 	KubeClient    client.Client
+	Recorder      record.EventRecorder
+	// Translators transform the resource requirements of pods before they're
+	// scheduled and packed
+	Translators []resources.Translator
+	// DryRun solves and packs pods without launching capacity for them, see
+	// recordDryRun
+	DryRun bool
 }
 
 // NewController constructs a controller instance
-func NewController(kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider) *Controller {
+func NewController(kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, recorder record.EventRecorder, notifier *lifecycle.Notifier) *Controller {
 	return &Controller{
 		Filter:        &Filter{KubeClient: kubeClient},
-		Binder:        &Binder{KubeClient: kubeClient, CoreV1Client: coreV1Client},
+		Binder:        &Binder{KubeClient: kubeClient, CoreV1Client: coreV1Client, Notifier: notifier},
 		Batcher:       NewBatcher(maxBatchWindow, batchIdleTimeout),
+		Breaker:       NewBreaker(),
 		Scheduler:     scheduling.NewScheduler(kubeClient),
 		Packer:        binpacking.NewPacker(),
 		CloudProvider: cloudProvider,
 		KubeClient:    kubeClient,
+		Recorder:      recorder,
+		Translators:   resources.Translators(),
 	}
 }
 
@@ -82,6 +99,7 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	if err != nil {
 		if errors.IsNotFound(err) {
 			c.Batcher.Wait(&v1alpha4.Provisioner{})
+			c.Breaker.Delete(req.Name)
 			logging.FromContext(ctx).Errorf("Provisioner \"%s\" not found. Create the \"default\" provisioner or specify an alternative using the nodeSelector %s", req.Name, v1alpha4.ProvisionerNameLabelKey)
 			return reconcile.Result{}, nil
 		}
@@ -90,6 +108,27 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	// Wait on a pod batch
 	logging.FromContext(ctx).Infof("Waiting to batch additional pods")
 	c.Batcher.Wait(provisioner)
+	batchClosed := time.Now()
+	// Don't launch while launches are suspended after repeated failures
+	if cooldown := c.Breaker.Open(provisioner); cooldown > 0 {
+		logging.FromContext(ctx).Infof("Launches are suspended for %s after repeated failures", cooldown.Round(time.Second))
+		return reconcile.Result{RequeueAfter: cooldown}, nil
+	}
+
+	// Get Instance Types Options
+	// Explain pods that capacity isn't launched for in the provisioner's status
+	diagnosis := newDiagnosis()
+	instanceTypes, err := c.CloudProvider.GetInstanceTypes(ctx, &provisioner.Spec.Constraints)
+	if err != nil {
+		err = fmt.Errorf("getting instance types, %w", err)
+		diagnosis.cloudProviderError(err)
+		return reconcile.Result{}, multierr.Append(err, c.updateConditions(ctx, provisioner, diagnosis))
+	}
+	// Track the capacity of the provisioner's nodes against its limits
+	limiter, err := c.limiterFor(ctx, provisioner, instanceTypes)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
 	// Filter pods
 	pods, err := c.Filter.GetProvisionablePods(ctx, provisioner)
@@ -97,44 +136,234 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, fmt.Errorf("filtering pods, %w", err)
 	}
 	logging.FromContext(ctx).Infof("Found %d provisionable pods", len(pods))
+	// Widen the constraints of pods that missed their provisioning deadline
+	c.escalate(ctx, provisioner, pods)
+	// Provision capacity in advance for pods on slowly draining nodes
+	replaceable, replaced, err := c.Filter.GetReplaceablePods(ctx, provisioner)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("filtering replaceable pods, %w", err)
+	}
+	// Provision capacity in advance for deployments that are about to scale
+	hinted, hints, err := c.Filter.GetHintedPods(ctx, provisioner)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("filtering hinted pods, %w", err)
+	}
+	// Sort pods by priority, so higher priority pods are considered first
+	queueWaits := c.Batcher.QueueWaitsByPriority(pods)
+	pods = append(pods, replaceable...)
+	pods = append(pods, hinted...)
+	// Translate resource requirements with the controller's translators
+	pods = c.translate(ctx, provisioner, pods)
 	if len(pods) == 0 {
 		logging.FromContext(ctx).Infof("Watching for pod events")
-		return reconcile.Result{}, nil
+		return reconcile.Result{}, multierr.Combine(c.updateConditions(ctx, provisioner, diagnosis), c.markHinted(ctx, hints))
+	}
+	batchSizeHistogramVec.WithLabelValues(provisioner.Name).Observe(float64(len(pods)))
+	defer func() {
+		batchDrainHistogramVec.WithLabelValues(provisioner.Name).Observe(time.Since(batchClosed).Seconds())
+	}()
+	// Record the provisioner's node configuration, so that nodes are detected
+	// as drifted once it changes
+	hash, err := provisioner.Spec.NodeConfigurationHash()
+	if err != nil {
+		return reconcile.Result{}, err
 	}
 	// Group by constraints
-	schedules, err := c.Scheduler.Solve(ctx, provisioner, pods)
+	schedules, podErrs, err := c.Scheduler.Solve(ctx, provisioner, pods)
 	if err != nil {
 		return reconcile.Result{}, fmt.Errorf("solving scheduling constraints, %w", err)
 	}
-	// Get Instance Types Options
-	instanceTypes, err := c.CloudProvider.GetInstanceTypes(ctx)
-	if err != nil {
-		return reconcile.Result{}, fmt.Errorf("getting instance types, %w", err)
+	c.reportUnschedulable(ctx, provisioner, podErrs)
+	diagnosis.unschedulable(podErrs)
+	for _, schedule := range schedules {
+		diagnosis.oversized(c.excludeOversizedPods(ctx, provisioner, schedule, instanceTypes))
 	}
-	// Create capacity
+	// Pack pods onto nodes, excluding launches vetoed by the cloud provider
+	packed := make([][]*binpacking.Packing, len(schedules))
 	errs := make([]error, len(schedules))
 	workqueue.ParallelizeUntil(ctx, len(schedules), len(schedules), func(index int) {
-		for _, packing := range c.Packer.Pack(ctx, schedules[index], instanceTypes) {
-			// Create thread safe channel to pop off packed pod slices
-			packedPods := make(chan []*v1.Pod, len(packing.Pods))
-			for _, pods := range packing.Pods {
-				packedPods <- pods
-			}
-			close(packedPods)
-			if err := <-c.CloudProvider.Create(ctx, packing.Constraints, packing.InstanceTypeOptions, packing.NodeQuantity, func(node *v1.Node) error {
-				node.Labels = functional.UnionStringMaps(
-					node.Labels,
-					packing.Constraints.Labels,
-					map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
-				)
-				node.Spec.Taints = append(node.Spec.Taints, packing.Constraints.Taints...)
-				return c.Binder.Bind(ctx, node, <-packedPods)
-			}); err != nil {
-				errs[index] = multierr.Append(errs[index], err)
+		packings := c.Packer.Pack(ctx, schedules[index], instanceTypes)
+		c.recordEstimate(ctx, provisioner, packings)
+		for _, packing := range packings {
+			if err := c.CloudProvider.ValidateLaunch(ctx, packing.Constraints, packing.InstanceTypeOptions, packing.NodeQuantity); err != nil {
+				if launchErr, ok := cloudprovider.AsLaunchError(err); ok {
+					c.recordVetoedLaunch(ctx, provisioner, packing, launchErr)
+					diagnosis.cloudProviderError(launchErr)
+					continue
+				}
+				errs[index] = multierr.Append(errs[index], fmt.Errorf("validating launch, %w", err))
+				continue
 			}
+			packed[index] = append(packed[index], packing)
 		}
 	})
-	return reconcile.Result{Requeue: true}, multierr.Combine(errs...)
+	packings := []*binpacking.Packing{}
+	for _, schedulePackings := range packed {
+		packings = append(packings, schedulePackings...)
+	}
+	// Reserve capacity within the provisioner's limits, deferring nodes for
+	// lower priority pods once a limit is reached
+	for limit, pods := range limiter.reserveByPriority(packings) {
+		c.recordLimitExceeded(ctx, provisioner, pods, limit)
+		diagnosis.limitExceeded(limit, len(pods))
+	}
+	if c.isDryRun(provisioner) {
+		c.recordDryRun(ctx, provisioner, packings)
+		diagnosis.cloudProviderError(errs...)
+		return reconcile.Result{}, multierr.Combine(append(errs, c.updateConditions(ctx, provisioner, diagnosis))...)
+	}
+	// Predict when launched nodes will be ready from recent launches
+	history, err := c.readyHistoryFor(ctx, provisioner)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	// Create capacity
+	launchErrs := make([]error, len(packings))
+	workqueue.ParallelizeUntil(ctx, len(packings), len(packings), func(index int) {
+		packing := packings[index]
+		if packing.NodeQuantity == 0 {
+			return
+		}
+		// Create thread safe channel to pop off packed pod slices
+		packedPods := make(chan []*v1.Pod, len(packing.Pods))
+		for _, pods := range packing.Pods {
+			packedPods <- pods
+		}
+		close(packedPods)
+		prediction := predictReady(history, packing.InstanceTypeOptions)
+		decision := c.recordDecision(ctx, provisioner, packing, batchClosed, queueWaits)
+		err := <-c.CloudProvider.Create(ctx, packing.Constraints, packing.InstanceTypeOptions, packing.NodeQuantity, func(node *v1.Node) error {
+			node.Labels = functional.UnionStringMaps(
+				node.Labels,
+				packing.Constraints.Labels,
+				map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			)
+			node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha4.ProvisionerHashAnnotationKey: hash})
+			decision.annotate(node)
+			if prediction != nil {
+				node.Annotations[v1alpha4.ExpectedReadyAnnotationKey] = time.Now().Add(prediction.p90).UTC().Format(time.RFC3339)
+			}
+			node.Spec.Taints = append(node.Spec.Taints, packing.Constraints.Taints...)
+			node.Spec.Taints = append(node.Spec.Taints, packing.Constraints.StartupTaints...)
+			pods := <-packedPods
+			if err := c.Binder.Bind(ctx, node, pods); err != nil {
+				return err
+			}
+			c.recordQueueWaits(provisioner, node, pods)
+			c.recordReadyPrediction(node, pods, prediction)
+			return nil
+		})
+		c.completeDecision(ctx, decision, err)
+		launchErrs[index] = err
+	})
+	c.pruneDecisions(ctx, provisioner)
+	errs = append(errs, launchErrs...)
+	diagnosis.cloudProviderError(errs...)
+	if err := c.updateConditions(ctx, provisioner, diagnosis); err != nil {
+		errs = append(errs, err)
+	}
+	if err := multierr.Combine(errs...); err != nil {
+		return c.launchFailed(ctx, provisioner, err)
+	}
+	return reconcile.Result{Requeue: true}, multierr.Combine(c.launchSucceeded(ctx, provisioner), c.markReplaced(ctx, replaced), c.markHinted(ctx, hints))
+}
+
+// launchFailed records a failed provisioning loop. After repeated failures,
+// launches are suspended for a cooldown and the provisioner's Launchable
+// condition is set to the error.
+func (c *Controller) launchFailed(ctx context.Context, provisioner *v1alpha4.Provisioner, err error) (reconcile.Result, error) {
+	cooldown := c.Breaker.Failure(provisioner)
+	if cooldown == 0 {
+		return reconcile.Result{}, err
+	}
+	logging.FromContext(ctx).Errorf("Suspending launches for %s after repeated failures, %s", cooldown, err.Error())
+	c.Recorder.Eventf(provisioner, v1.EventTypeWarning, "LaunchesSuspended", "Suspending launches for %s after repeated failures, %s", cooldown, err.Error())
+	persisted := provisioner.DeepCopy()
+	provisioner.StatusConditions().MarkFalse(v1alpha4.Launchable, "LaunchFailed", "%s", err.Error())
+	if err := c.KubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching provisioner status, %w", err)
+	}
+	return reconcile.Result{RequeueAfter: cooldown}, nil
+}
+
+// launchSucceeded closes the provisioner's circuit, and resets its Launchable
+// condition if launches were suspended
+func (c *Controller) launchSucceeded(ctx context.Context, provisioner *v1alpha4.Provisioner) error {
+	c.Breaker.Success(provisioner)
+	if condition := provisioner.StatusConditions().GetCondition(v1alpha4.Launchable); condition == nil || condition.IsTrue() {
+		return nil
+	}
+	logging.FromContext(ctx).Infof("Resuming launches")
+	persisted := provisioner.DeepCopy()
+	provisioner.StatusConditions().MarkTrue(v1alpha4.Launchable)
+	if err := c.KubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+		return fmt.Errorf("patching provisioner status, %w", err)
+	}
+	return nil
+}
+
+// recordQueueWaits dequeues pods once they're bound, and emits an event on the
+// node describing how long they waited to be batched, which can be used to
+// tune batch windows.
+func (c *Controller) recordQueueWaits(provisioner *v1alpha4.Provisioner, node *v1.Node, pods []*v1.Pod) {
+	waits := []time.Duration{}
+	for _, pod := range pods {
+		if wait, ok := c.Batcher.Dequeue(provisioner, pod); ok {
+			waits = append(waits, wait)
+		}
+	}
+	if len(waits) == 0 {
+		return
+	}
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	c.Recorder.Eventf(node, v1.EventTypeNormal, "Provisioned", "Provisioned for %d pod(s) that waited to be batched for p50 %s, p99 %s",
+		len(pods),
+		waits[(len(waits)-1)*50/100].Round(time.Millisecond),
+		waits[(len(waits)-1)*99/100].Round(time.Millisecond),
+	)
+}
+
+// translate returns the pods with their resource requirements translated by
+// the controller's translators, emitting an event on pods that fail to
+// translate, which are excluded from this provisioning loop
+func (c *Controller) translate(ctx context.Context, provisioner *v1alpha4.Provisioner, pods []*v1.Pod) []*v1.Pod {
+	translated, errs := resources.Translate(ctx, c.Translators, pods)
+	for pod, err := range errs {
+		logging.FromContext(ctx).Errorf("Failed to translate resource requirements of pod %s/%s, %s", pod.Namespace, pod.Name, err.Error())
+		if !isScaleHint(pod) {
+			c.Recorder.Eventf(pod, v1.EventTypeWarning, "TranslationFailed", "Failed to translate resource requirements for provisioner %s, %s", provisioner.Name, err.Error())
+		}
+	}
+	return translated
+}
+
+// recordVetoedLaunch emits an event on each of the packing's pods, which
+// remain pending and are retried on the next provisioning loop.
+func (c *Controller) recordVetoedLaunch(ctx context.Context, provisioner *v1alpha4.Provisioner, packing *binpacking.Packing, launchErr *cloudprovider.LaunchError) {
+	logging.FromContext(ctx).Errorf("Cloud provider vetoed launching %d node(s), %s", packing.NodeQuantity, launchErr.Error())
+	for _, pods := range packing.Pods {
+		for _, pod := range pods {
+			if isScaleHint(pod) {
+				continue
+			}
+			c.Recorder.Eventf(pod, v1.EventTypeWarning, string(launchErr.Reason), "Cloud provider vetoed launching capacity for provisioner %s, %s", provisioner.Name, launchErr.Message)
+		}
+	}
+}
+
+// recordLimitExceeded emits an event on each of the pods that capacity wasn't
+// launched for, which remain pending until the provisioner's nodes are removed
+// or its limits are raised.
+func (c *Controller) recordLimitExceeded(ctx context.Context, provisioner *v1alpha4.Provisioner, pods [][]*v1.Pod, limit string) {
+	logging.FromContext(ctx).Errorf("Not launching %d node(s), provisioner %s reached its %s limit", len(pods), provisioner.Name, limit)
+	for _, nodePods := range pods {
+		for _, pod := range nodePods {
+			if isScaleHint(pod) {
+				continue
+			}
+			c.Recorder.Eventf(pod, v1.EventTypeWarning, string(LimitExceeded), "Provisioner %s reached its %s limit", provisioner.Name, limit)
+		}
+	}
 }
 
 func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
@@ -154,8 +383,24 @@ func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
 				},
 			),
 		).
+		Watches(
+			&source.Kind{Type: &v1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(c.nodeToProvisioner(ctx)),
+			// Only process node update events
+			builder.WithPredicates(
+				predicate.Funcs{
+					CreateFunc:  func(_ event.CreateEvent) bool { return false },
+					DeleteFunc:  func(_ event.DeleteEvent) bool { return false },
+					GenericFunc: func(_ event.GenericEvent) bool { return false },
+				},
+			),
+		).
+		Watches(
+			&source.Kind{Type: &appsv1.Deployment{}},
+			handler.EnqueueRequestsFromMapFunc(c.deploymentToProvisioner(ctx)),
+		).
 		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
-		Complete(c)
+		Complete(metrics.NewInstrumentedReconciler("Allocation", c))
 	c.Batcher.Start(ctx)
 	return err
 }
@@ -176,28 +421,25 @@ func (c *Controller) podToProvisioner(ctx context.Context) func(o client.Object)
 		if err := c.Filter.isUnschedulable(pod); err != nil {
 			return nil
 		}
-		provisionerKey := v1alpha4.DefaultProvisioner
-		if name, ok := pod.Spec.NodeSelector[v1alpha4.ProvisionerNameLabelKey]; ok {
-			provisionerKey.Name = name
+		name, err := c.Filter.provisionerNameFor(ctx, pod)
+		if err != nil {
+			return nil
 		}
-		provisioner, err := c.provisionerFor(ctx, provisionerKey)
+		provisioner, err := c.provisionerFor(ctx, types.NamespacedName{Name: name})
 		if err != nil {
 			if errors.IsNotFound(err) {
 				// Queue and batch a reconcile request for a non-existent, empty provisioner
 				// This will reduce the number of repeated error messages about a provisioner not existing
 				c.Batcher.Add(&v1alpha4.Provisioner{})
-				notFoundProvisioner := v1alpha4.DefaultProvisioner.Name
-				if name, ok := pod.Spec.NodeSelector[v1alpha4.ProvisionerNameLabelKey]; ok {
-					notFoundProvisioner = name
-				}
-				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: notFoundProvisioner}}}
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name}}}
 			}
 			return nil
 		}
-		if err = c.Filter.isProvisionable(pod, provisioner); err != nil {
+		if err = c.Filter.isProvisionable(ctx, pod, provisioner); err != nil {
 			return nil
 		}
 		c.Batcher.Add(provisioner)
+		c.Batcher.Enqueue(provisioner, pod)
 		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: provisioner.Name}}}
 	}
 }
@@ -0,0 +1,154 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
+	"github.com/awslabs/karpenter/pkg/utils/apiobject"
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DecisionRetention is how long ProvisioningDecisions are kept before
+// they're pruned
+const DecisionRetention = 24 * time.Hour
+
+// decision records a packing decision as a ProvisioningDecision, so that it
+// can be audited after logs have rotated. Recording is best effort: if the
+// decision can't be created, e.g. because the CRD isn't installed, launches
+// proceed and the outcome isn't recorded.
+type decision struct {
+	mu       sync.Mutex
+	object   *v1alpha4.ProvisioningDecision
+	nodes    []v1alpha4.ProvisionedNode
+	launched time.Time
+}
+
+// recordDecision creates a ProvisioningDecision for the packing, owned by the
+// provisioner so that it's deleted along with it
+func (c *Controller) recordDecision(ctx context.Context, provisioner *v1alpha4.Provisioner, packing *binpacking.Packing, batchClosed time.Time, queueWaits map[types.UID]time.Duration) *decision {
+	hash, err := hashstructure.Hash(packing.Constraints, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to record provisioning decision, hashing constraints, %s", err.Error())
+		return nil
+	}
+	object := &v1alpha4.ProvisioningDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-", provisioner.Name),
+			Labels:       map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion:         v1alpha4.SchemeGroupVersion.String(),
+				Kind:               "Provisioner",
+				Name:               provisioner.Name,
+				UID:                provisioner.UID,
+				BlockOwnerDeletion: ptr.Bool(true),
+			}},
+		},
+		Spec: v1alpha4.ProvisioningDecisionSpec{
+			Provisioner:     provisioner.Name,
+			ConstraintsHash: fmt.Sprint(hash),
+			Pods:            []string{},
+			InstanceTypes:   []string{},
+			Zones:           packing.Constraints.Zones,
+			NodeQuantity:    packing.NodeQuantity,
+			BatchCloseTime:  metav1.NewTime(batchClosed),
+		},
+	}
+	var longestWait time.Duration
+	for _, pods := range packing.Pods {
+		for _, name := range apiobject.PodNamespacedNames(pods) {
+			object.Spec.Pods = append(object.Spec.Pods, name.String())
+		}
+		for _, pod := range pods {
+			if wait := queueWaits[pod.UID]; wait > longestWait {
+				longestWait = wait
+			}
+		}
+	}
+	if longestWait > 0 {
+		object.Spec.BatchStartTime = &metav1.Time{Time: batchClosed.Add(-longestWait)}
+	}
+	for _, instanceType := range packing.InstanceTypeOptions {
+		object.Spec.InstanceTypes = append(object.Spec.InstanceTypes, instanceType.Name())
+	}
+	if err := c.KubeClient.Create(ctx, object); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to record provisioning decision, %s", err.Error())
+		return nil
+	}
+	return &decision{object: object, launched: time.Now()}
+}
+
+// annotate references the decision from a node launched for it
+func (d *decision) annotate(node *v1.Node) {
+	if d == nil {
+		return
+	}
+	node.Annotations[v1alpha4.ProvisioningDecisionAnnotationKey] = d.object.Name
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nodes = append(d.nodes, v1alpha4.ProvisionedNode{
+		Name:         node.Name,
+		InstanceType: node.Labels[v1.LabelInstanceTypeStable],
+		Zone:         node.Labels[v1.LabelTopologyZone],
+	})
+}
+
+// completeDecision records the outcome of the decision's launch
+func (c *Controller) completeDecision(ctx context.Context, d *decision, launchErr error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	persisted := d.object.DeepCopy()
+	d.object.Status.Nodes = d.nodes
+	d.object.Status.LaunchDuration = &metav1.Duration{Duration: time.Since(d.launched)}
+	if launchErr != nil {
+		d.object.Status.Error = launchErr.Error()
+	}
+	if err := c.KubeClient.Status().Patch(ctx, d.object, client.MergeFrom(persisted)); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to record outcome of provisioning decision %s, %s", d.object.Name, err.Error())
+	}
+}
+
+// pruneDecisions deletes the provisioner's decisions that are older than the
+// retention period
+func (c *Controller) pruneDecisions(ctx context.Context, provisioner *v1alpha4.Provisioner) {
+	decisions := &v1alpha4.ProvisioningDecisionList{}
+	if err := c.KubeClient.List(ctx, decisions, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		logging.FromContext(ctx).Debugf("Failed to list provisioning decisions, %s", err.Error())
+		return
+	}
+	for i := range decisions.Items {
+		decision := &decisions.Items[i]
+		if time.Since(decision.CreationTimestamp.Time) < DecisionRetention {
+			continue
+		}
+		if err := c.KubeClient.Delete(ctx, decision); client.IgnoreNotFound(err) != nil {
+			logging.FromContext(ctx).Errorf("Failed to prune provisioning decision %s, %s", decision.Name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const DryRunLaunch = "DryRunLaunch"
+
+var dryRunNodesCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "dry_run_nodes_total",
+		Help:      "Number of nodes that would have been launched by provisioners in dry run mode. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(dryRunNodesCounterVec)
+}
+
+// isDryRun returns true if the provisioner's capacity should be solved and
+// packed, but not launched, either because the controller runs in dry run
+// mode or because the provisioner is annotated with karpenter.sh/dry-run=true
+func (c *Controller) isDryRun(provisioner *v1alpha4.Provisioner) bool {
+	return c.DryRun || provisioner.Annotations[v1alpha4.DryRunAnnotationKey] == "true"
+}
+
+// recordDryRun emits an event on the provisioner for each packing that would
+// have been launched, with its instance type options and zones, so that a
+// provisioner's configuration can be evaluated before it launches capacity
+func (c *Controller) recordDryRun(ctx context.Context, provisioner *v1alpha4.Provisioner, packings []*binpacking.Packing) {
+	for _, packing := range packings {
+		if packing.NodeQuantity == 0 {
+			continue
+		}
+		pods := 0
+		for _, nodePods := range packing.Pods {
+			pods += len(nodePods)
+		}
+		logging.FromContext(ctx).Infof("Dry run, would launch %d node(s) of %s in zones %v for %d pod(s)",
+			packing.NodeQuantity, instanceTypeNames(packing.InstanceTypeOptions, len(packing.InstanceTypeOptions)), packing.Constraints.Zones, pods)
+		c.Recorder.Eventf(provisioner, v1.EventTypeNormal, DryRunLaunch, "Would launch %d node(s) of %s in zones %v for %d pod(s)",
+			packing.NodeQuantity, instanceTypeNames(packing.InstanceTypeOptions, maxEventInstanceTypes), packing.Constraints.Zones, pods)
+		dryRunNodesCounterVec.WithLabelValues(provisioner.Name).Add(float64(packing.NodeQuantity))
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	podutil "github.com/awslabs/karpenter/pkg/utils/pod"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const ProvisioningEscalated = "ProvisioningEscalated"
+
+var escalationsCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "escalations_total",
+		Help:      "Number of pods whose constraints were widened after they missed their provisioning deadline. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(escalationsCounterVec)
+}
+
+// escalate annotates pods that have been pending for longer than the
+// duration in their karpenter.sh/provisioning-deadline annotation, e.g. "5m",
+// as escalated. The scheduler then removes all of their preferences, and cloud
+// providers may widen their constraints further, e.g. to launch on-demand
+// rather than spot capacity. Escalation never exceeds the provisioner's
+// constraints or the pods' required constraints. The in memory pods are
+// annotated even if the pod can't be patched, so that escalation isn't
+// delayed, and an event is emitted on each pod once it's escalated.
+func (c *Controller) escalate(ctx context.Context, provisioner *v1alpha4.Provisioner, pods []*v1.Pod) {
+	for _, pod := range pods {
+		deadline, ok := pod.Annotations[v1alpha4.ProvisioningDeadlineAnnotationKey]
+		if !ok || podutil.IsEscalated(pod) {
+			continue
+		}
+		duration, err := time.ParseDuration(deadline)
+		if err != nil {
+			logging.FromContext(ctx).Debugf("Ignoring provisioning deadline %q of %s/%s, %s", deadline, pod.Namespace, pod.Name, err.Error())
+			continue
+		}
+		pending := injectabletime.Now().Sub(pod.CreationTimestamp.Time)
+		if pending < duration {
+			continue
+		}
+		patched := pod.DeepCopy()
+		patched.Annotations = functional.UnionStringMaps(patched.Annotations, map[string]string{
+			v1alpha4.EscalatedAnnotationKey: injectabletime.Now().UTC().Format(time.RFC3339),
+		})
+		if err := c.KubeClient.Patch(ctx, patched, client.MergeFrom(pod)); err != nil {
+			logging.FromContext(ctx).Debugf("Failed to annotate escalation of %s/%s, %s", pod.Namespace, pod.Name, err.Error())
+		}
+		pod.Annotations = patched.Annotations
+		logging.FromContext(ctx).Infof("Escalating %s/%s, which has been pending for %s, past its provisioning deadline of %s", pod.Namespace, pod.Name, pending.Round(time.Second), duration)
+		c.Recorder.Eventf(pod, v1.EventTypeWarning, ProvisioningEscalated, "Pending for %s, past the provisioning deadline of %s, widening constraints within provisioner %s",
+			pending.Round(time.Second), duration, provisioner.Name)
+		escalationsCounterVec.WithLabelValues(provisioner.Name).Inc()
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	LaunchEstimated = "LaunchEstimated"
+	// maxEventInstanceTypes bounds the instance type options listed per
+	// packing in events, which are otherwise unreadable
+	maxEventInstanceTypes = 3
+)
+
+// recordEstimate emits an event on the provisioner describing the nodes about
+// to be launched for a schedule and their estimated hourly cost, so operators
+// can review the cost of each provisioning decision. Each packing is assumed
+// to launch its cheapest instance type option. The cost is unknown unless the
+// cloud provider prices all of the options. Launches may still be vetoed or
+// limited after the estimate.
+func (c *Controller) recordEstimate(ctx context.Context, provisioner *v1alpha4.Provisioner, packings []*binpacking.Packing) {
+	if len(packings) == 0 {
+		return
+	}
+	nodes, pods := 0, 0
+	cost, priced := 0.0, true
+	mix := []string{}
+	for _, packing := range packings {
+		nodes += packing.NodeQuantity
+		for _, nodePods := range packing.Pods {
+			pods += len(nodePods)
+		}
+		price, ok := cloudprovider.CheapestPrice(packing.InstanceTypeOptions)
+		cost += price * float64(packing.NodeQuantity)
+		priced = priced && ok
+		mix = append(mix, fmt.Sprintf("%d of %s", packing.NodeQuantity, instanceTypeNames(packing.InstanceTypeOptions, maxEventInstanceTypes)))
+		logging.FromContext(ctx).Debugf("Estimated %d node(s) of %s", packing.NodeQuantity, instanceTypeNames(packing.InstanceTypeOptions, len(packing.InstanceTypeOptions)))
+	}
+	estimate := "unknown"
+	if priced {
+		estimate = fmt.Sprintf("%.4f per hour", cost)
+	}
+	logging.FromContext(ctx).Debugf("Launching %d node(s) for %d pod(s) with an estimated cost of %s", nodes, pods, estimate)
+	c.Recorder.Eventf(provisioner, v1.EventTypeNormal, LaunchEstimated, "Launching %d node(s) for %d pod(s), %s, estimated cost %s",
+		nodes, pods, strings.Join(mix, ", "), estimate)
+}
+
+// instanceTypeNames lists up to max of the instance types' names
+func instanceTypeNames(instanceTypes []cloudprovider.InstanceType, max int) string {
+	names := []string{}
+	for i, instanceType := range instanceTypes {
+		if i == max {
+			names = append(names, fmt.Sprintf("+%d more", len(instanceTypes)-max))
+			break
+		}
+		names = append(names, instanceType.Name())
+	}
+	return fmt.Sprintf("[%s]", strings.Join(names, " "))
+}
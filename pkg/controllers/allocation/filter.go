@@ -17,12 +17,17 @@ package allocation
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/utils/pod"
 	"github.com/awslabs/karpenter/pkg/utils/ptr"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -41,7 +46,7 @@ func (f *Filter) GetProvisionablePods(ctx context.Context, provisioner *v1alpha4
 	provisionable := []*v1.Pod{}
 	for i := range pods.Items {
 		p := pods.Items[i]
-		if err := f.isProvisionable(&p, provisioner); err != nil {
+		if err := f.isProvisionable(ctx, &p, provisioner); err != nil {
 			logging.FromContext(ctx).Debugf("Ignored pod %s/%s when allocating for provisioner %s, %s",
 				p.Name, p.Namespace, provisioner.Name, err.Error(),
 			)
@@ -52,10 +57,11 @@ func (f *Filter) GetProvisionablePods(ctx context.Context, provisioner *v1alpha4
 	return provisionable, nil
 }
 
-func (f *Filter) isProvisionable(pod *v1.Pod, provisioner *v1alpha4.Provisioner) error {
+func (f *Filter) isProvisionable(ctx context.Context, pod *v1.Pod, provisioner *v1alpha4.Provisioner) error {
 	return multierr.Combine(
 		f.isUnschedulable(pod),
-		f.matchesProvisioner(pod, provisioner),
+		f.isNotNominated(ctx, pod),
+		f.matchesProvisioner(ctx, pod, provisioner),
 	)
 }
 
@@ -72,13 +78,84 @@ func (f *Filter) isUnschedulable(p *v1.Pod) error {
 	return nil
 }
 
-func (f *Filter) matchesProvisioner(pod *v1.Pod, provisioner *v1alpha4.Provisioner) error {
-	name, ok := pod.Spec.NodeSelector[v1alpha4.ProvisionerNameLabelKey]
-	if ok && provisioner.Name == name {
+// isNotNominated returns an error if the pod is nominated to a node that is
+// still initializing, e.g. waiting for its extended resources to register.
+func (f *Filter) isNotNominated(ctx context.Context, p *v1.Pod) error {
+	if p.Status.NominatedNodeName == "" {
 		return nil
 	}
-	if !ok && provisioner.Name == v1alpha4.DefaultProvisioner.Name {
+	node := &v1.Node{}
+	if err := f.KubeClient.Get(ctx, types.NamespacedName{Name: p.Status.NominatedNodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("getting nominated node %s, %w", p.Status.NominatedNodeName, err)
+	}
+	if !node.DeletionTimestamp.IsZero() {
 		return nil
 	}
-	return fmt.Errorf("matched another provisioner, %s", name)
+	return fmt.Errorf("awaiting initialization of node %s", node.Name)
+}
+
+func (f *Filter) matchesProvisioner(ctx context.Context, pod *v1.Pod, provisioner *v1alpha4.Provisioner) error {
+	name, err := f.provisionerNameFor(ctx, pod)
+	if err != nil {
+		return err
+	}
+	if provisioner.Name != name {
+		return fmt.Errorf("matched another provisioner, %s", name)
+	}
+	return f.matchesSelectors(ctx, pod, provisioner)
+}
+
+// provisionerNameFor returns the name of the provisioner responsible for the
+// pod. Pods may target a provisioner by name with a node selector. Otherwise,
+// the first provisioner (ordered by name) with selectors that match the pod is
+// responsible, falling back to the default provisioner.
+func (f *Filter) provisionerNameFor(ctx context.Context, pod *v1.Pod) (string, error) {
+	if name, ok := pod.Spec.NodeSelector[v1alpha4.ProvisionerNameLabelKey]; ok {
+		return name, nil
+	}
+	provisioners := &v1alpha4.ProvisionerList{}
+	if err := f.KubeClient.List(ctx, provisioners); err != nil {
+		return "", fmt.Errorf("listing provisioners, %w", err)
+	}
+	sort.Slice(provisioners.Items, func(i, j int) bool { return provisioners.Items[i].Name < provisioners.Items[j].Name })
+	for i := range provisioners.Items {
+		provisioner := &provisioners.Items[i]
+		if provisioner.Spec.PodSelector == nil && provisioner.Spec.NamespaceSelector == nil {
+			continue
+		}
+		if err := f.matchesSelectors(ctx, pod, provisioner); err == nil {
+			return provisioner.Name, nil
+		}
+	}
+	return v1alpha4.DefaultProvisioner.Name, nil
+}
+
+// matchesSelectors returns an error if the pod or its namespace don't match the provisioner's selectors
+func (f *Filter) matchesSelectors(ctx context.Context, pod *v1.Pod, provisioner *v1alpha4.Provisioner) error {
+	if provisioner.Spec.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(provisioner.Spec.PodSelector)
+		if err != nil {
+			return fmt.Errorf("parsing pod selector, %w", err)
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			return fmt.Errorf("doesn't match pod selector of provisioner %s", provisioner.Name)
+		}
+	}
+	if provisioner.Spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(provisioner.Spec.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("parsing namespace selector, %w", err)
+		}
+		namespace := &v1.Namespace{}
+		if err := f.KubeClient.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+			return fmt.Errorf("getting namespace %s, %w", pod.Namespace, err)
+		}
+		if !selector.Matches(labels.Set(namespace.Labels)) {
+			return fmt.Errorf("doesn't match namespace selector of provisioner %s", provisioner.Name)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,170 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// GetHintedPods returns pods for the replicas that the provisioner's
+// deployments are about to scale to, as hinted by the karpenter.sh/scale-hint
+// annotation, so that capacity is provisioned before the replicas are created.
+// Pods that fit on existing nodes are excluded. The pods don't exist and are
+// never bound. The hinted deployments are also returned.
+func (f *Filter) GetHintedPods(ctx context.Context, provisioner *v1alpha4.Provisioner) ([]*v1.Pod, []*appsv1.Deployment, error) {
+	deployments := &appsv1.DeploymentList{}
+	if err := f.KubeClient.List(ctx, deployments); err != nil {
+		return nil, nil, fmt.Errorf("listing deployments, %w", err)
+	}
+	hinted := []*v1.Pod{}
+	hints := []*appsv1.Deployment{}
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		pods := podsForHint(ctx, deployment)
+		if len(pods) == 0 {
+			continue
+		}
+		if err := f.matchesProvisioner(ctx, pods[0], provisioner); err != nil {
+			continue
+		}
+		hinted = append(hinted, pods...)
+		hints = append(hints, deployment)
+	}
+	if len(hinted) == 0 {
+		return nil, nil, nil
+	}
+	unfit, err := f.withoutCapacity(ctx, hinted)
+	if err != nil {
+		return nil, nil, err
+	}
+	logging.FromContext(ctx).Infof("Provisioning capacity for %d of %d pod(s) hinted by %d deployment(s)", len(unfit), len(hinted), len(hints))
+	return unfit, hints, nil
+}
+
+// withoutCapacity returns the pods that don't fit on existing nodes
+func (f *Filter) withoutCapacity(ctx context.Context, pods []*v1.Pod) ([]*v1.Pod, error) {
+	nodes := &v1.NodeList{}
+	if err := f.KubeClient.List(ctx, nodes); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	ready := []*v1.Node{}
+	for _, node := range ptr.NodeListToSlice(nodes) {
+		if nodeutil.IsReady(node) {
+			ready = append(ready, node)
+		}
+	}
+	scheduled := &v1.PodList{}
+	if err := f.KubeClient.List(ctx, scheduled); err != nil {
+		return nil, fmt.Errorf("listing pods, %w", err)
+	}
+	simulation := scheduling.NewSimulation(ready, ptr.PodListToSlice(scheduled))
+	unfit := []*v1.Pod{}
+	for _, pod := range pods {
+		if err := simulation.Schedule(pod); err != nil {
+			unfit = append(unfit, pod)
+		}
+	}
+	return unfit, nil
+}
+
+// podsForHint returns a pod from the deployment's template for each replica
+// that its scale hint exceeds its current replicas by. Pods are only returned
+// once per hint value.
+func podsForHint(ctx context.Context, deployment *appsv1.Deployment) []*v1.Pod {
+	value, ok := deployment.Annotations[v1alpha4.ScaleHintAnnotationKey]
+	if !ok || deployment.Annotations[v1alpha4.ScaleHintProvisionedAnnotationKey] == value {
+		return nil
+	}
+	hint, err := strconv.Atoi(value)
+	if err != nil {
+		logging.FromContext(ctx).Debugf("Ignoring scale hint for deployment %s/%s, %s", deployment.Namespace, deployment.Name, err.Error())
+		return nil
+	}
+	replicas := 1
+	if deployment.Spec.Replicas != nil {
+		replicas = int(*deployment.Spec.Replicas)
+	}
+	if int(deployment.Status.Replicas) > replicas {
+		replicas = int(deployment.Status.Replicas)
+	}
+	pods := []*v1.Pod{}
+	for i := replicas; i < hint; i++ {
+		pod := &v1.Pod{
+			ObjectMeta: *deployment.Spec.Template.ObjectMeta.DeepCopy(),
+			Spec:       *deployment.Spec.Template.Spec.DeepCopy(),
+		}
+		pod.Name = fmt.Sprintf("%s-scale-hint-%d", deployment.Name, i)
+		pod.Namespace = deployment.Namespace
+		// Unique and stable across provisioning loops, since pods are tracked by UID
+		pod.UID = types.UID(fmt.Sprintf("%s-scale-hint-%d", deployment.UID, i))
+		pod.Annotations = functional.UnionStringMaps(pod.Annotations, map[string]string{v1alpha4.ScaleHintAnnotationKey: deployment.Name})
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+// isScaleHint returns true if the pod was constructed for a scale hint
+func isScaleHint(pod *v1.Pod) bool {
+	_, ok := pod.Annotations[v1alpha4.ScaleHintAnnotationKey]
+	return ok
+}
+
+// markHinted annotates deployments once capacity has been provisioned for
+// their scale hints, so that capacity is only provisioned once per hint
+func (c *Controller) markHinted(ctx context.Context, deployments []*appsv1.Deployment) error {
+	for _, deployment := range deployments {
+		persisted := deployment.DeepCopy()
+		deployment.Annotations[v1alpha4.ScaleHintProvisionedAnnotationKey] = deployment.Annotations[v1alpha4.ScaleHintAnnotationKey]
+		if err := c.KubeClient.Patch(ctx, deployment, client.MergeFrom(persisted)); err != nil {
+			return fmt.Errorf("patching deployment %s/%s, %w", deployment.Namespace, deployment.Name, err)
+		}
+	}
+	return nil
+}
+
+// deploymentToProvisioner is a function handler to transform hinted deployment
+// objs to provisioner reconcile requests
+func (c *Controller) deploymentToProvisioner(ctx context.Context) func(o client.Object) []reconcile.Request {
+	return func(o client.Object) []reconcile.Request {
+		pods := podsForHint(ctx, o.(*appsv1.Deployment))
+		if len(pods) == 0 {
+			return nil
+		}
+		name, err := c.Filter.provisionerNameFor(ctx, pods[0])
+		if err != nil {
+			return nil
+		}
+		provisioner, err := c.provisionerFor(ctx, types.NamespacedName{Name: name})
+		if err != nil {
+			return nil
+		}
+		c.Batcher.Add(provisioner)
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: provisioner.Name}}}
+	}
+}
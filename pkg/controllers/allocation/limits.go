@@ -0,0 +1,180 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/pod"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// LimitExceeded vetoes launches that would exceed the provisioner's limits
+const LimitExceeded cloudprovider.LaunchErrorReason = "LimitExceeded"
+
+// limitNodes is the limit label value for spec.limits.maxNodes
+const limitNodes = "nodes"
+
+var limitExceededCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "limit_exceeded_total",
+		Help:      "Number of nodes not launched because they would exceed a provisioner limit. Broken down by provisioner and limit.",
+	},
+	[]string{metrics.ProvisionerLabel, "limit"},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(limitExceededCounter)
+}
+
+// limiter caps the nodes launched by a provisioning loop to the provisioner's
+// limits. The capacity of launched nodes is reserved as they're launched,
+// assuming the smallest of their instance type options.
+type limiter struct {
+	mu          sync.Mutex
+	provisioner *v1alpha4.Provisioner
+	capacity    v1.ResourceList
+	nodes       int32
+}
+
+// limiterFor returns a limiter with the current capacity of the provisioner's
+// nodes, which is also published to the provisioner's status. Nodes' capacity
+// is that of their instance type, since nodes that haven't registered yet
+// don't report their capacity.
+func (c *Controller) limiterFor(ctx context.Context, provisioner *v1alpha4.Provisioner, instanceTypes []cloudprovider.InstanceType) (*limiter, error) {
+	nodes := &v1.NodeList{}
+	if err := c.KubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	capacities := map[string]v1.ResourceList{}
+	for _, instanceType := range instanceTypes {
+		capacities[instanceType.Name()] = instanceType.Resources()
+	}
+	capacity := []v1.ResourceList{}
+	for _, node := range nodes.Items {
+		if instanceTypeCapacity, ok := capacities[node.Labels[v1.LabelInstanceTypeStable]]; ok {
+			capacity = append(capacity, instanceTypeCapacity)
+		} else {
+			capacity = append(capacity, node.Status.Capacity)
+		}
+	}
+	limiter := &limiter{provisioner: provisioner, capacity: resources.Merge(capacity...), nodes: int32(len(nodes.Items))}
+	if err := c.updateStatus(ctx, provisioner, limiter); err != nil {
+		return nil, err
+	}
+	return limiter, nil
+}
+
+// updateStatus publishes the capacity of the provisioner's nodes to its status
+func (c *Controller) updateStatus(ctx context.Context, provisioner *v1alpha4.Provisioner, limiter *limiter) error {
+	if equality.Semantic.DeepEqual(provisioner.Status.Resources, limiter.capacity) && provisioner.Status.Nodes == limiter.nodes {
+		return nil
+	}
+	persisted := provisioner.DeepCopy()
+	provisioner.Status.Resources = limiter.capacity
+	provisioner.Status.Nodes = limiter.nodes
+	if err := c.KubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+		return fmt.Errorf("patching provisioner status, %w", err)
+	}
+	return nil
+}
+
+// reserveByPriority reserves capacity for the packings' nodes within the
+// provisioner's limits. Nodes are reserved in order of the highest priority
+// pod on each, so that once a limit is reached, nodes for lower priority pods
+// are the ones deferred. Each packing is reduced to its reserved nodes, and
+// the pods of nodes that can't be launched are returned by the limit they
+// would exceed.
+func (l *limiter) reserveByPriority(packings []*binpacking.Packing) map[string][][]*v1.Pod {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	type node struct {
+		packing *binpacking.Packing
+		pods    []*v1.Pod
+	}
+	nodes := []node{}
+	for _, packing := range packings {
+		for _, pods := range packing.Pods {
+			nodes = append(nodes, node{packing: packing, pods: pods})
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return pod.Precedes(pod.Highest(nodes[i].pods), pod.Highest(nodes[j].pods)) })
+	reserved := map[*binpacking.Packing][][]*v1.Pod{}
+	deferred := map[string][][]*v1.Pod{}
+	for _, node := range nodes {
+		if limit := l.reserve(node.packing); limit != "" {
+			limitExceededCounter.WithLabelValues(l.provisioner.Name, limit).Inc()
+			deferred[limit] = append(deferred[limit], node.pods)
+			continue
+		}
+		reserved[node.packing] = append(reserved[node.packing], node.pods)
+	}
+	for _, packing := range packings {
+		packing.Pods = reserved[packing]
+		packing.NodeQuantity = len(packing.Pods)
+	}
+	return deferred
+}
+
+// reserve reserves the capacity of one of the packing's nodes, returning the
+// limit that it would exceed, or an empty string if it was reserved
+func (l *limiter) reserve(packing *binpacking.Packing) string {
+	limits := l.provisioner.Spec.Limits
+	if limits == nil || len(packing.InstanceTypeOptions) == 0 {
+		return ""
+	}
+	capacity := packing.InstanceTypeOptions[0].Resources()
+	if limit := l.exceeded(limits, capacity); limit != "" {
+		return limit
+	}
+	l.capacity = resources.Merge(l.capacity, capacity)
+	l.nodes++
+	return ""
+}
+
+// exceeded returns the limit that another node with the capacity would
+// exceed, or an empty string if none would be
+func (l *limiter) exceeded(limits *v1alpha4.Limits, capacity v1.ResourceList) string {
+	if limits.MaxNodes != nil && l.nodes >= *limits.MaxNodes {
+		return limitNodes
+	}
+	names := []string{}
+	for name := range limits.Resources {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		total := l.capacity[v1.ResourceName(name)].DeepCopy()
+		total.Add(capacity[v1.ResourceName(name)])
+		if total.Cmp(limits.Resources[v1.ResourceName(name)]) > 0 {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/apiobject"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var oversizedPodsCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "oversized_pods_total",
+		Help:      "Number of pods that were too large to fit on any instance type allowed by the provisioner. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var incompatibleAcceleratorPodsCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "incompatible_accelerator_pods_total",
+		Help:      "Number of pods that requested accelerators that no instance type allowed by the provisioner offers together. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(oversizedPodsCounterVec)
+	crmetrics.Registry.MustRegister(incompatibleAcceleratorPodsCounterVec)
+}
+
+// excludeOversizedPods removes pods from the schedule that don't fit on any of
+// the instance types, even if packed alone. This avoids failing the launch for
+// the pods that were batched alongside them. Pods that mix accelerators, e.g.
+// nvidia GPUs and AWS Neurons, fit instance types that offer all of them, and
+// are otherwise reported as incompatible rather than too large. The excluded
+// pods are returned, other than scale hints.
+func (c *Controller) excludeOversizedPods(ctx context.Context, provisioner *v1alpha4.Provisioner, schedule *scheduling.Schedule, instanceTypes []cloudprovider.InstanceType) []*v1.Pod {
+	oversized := binpacking.OversizedPods(ctx, instanceTypes, schedule)
+	if len(oversized) == 0 {
+		return nil
+	}
+	logging.FromContext(ctx).Errorf("Excluding pod(s) %s that are too large to fit on any instance type", apiobject.PodNamespacedNames(oversized))
+	pods := []*v1.Pod{}
+	excluded := []*v1.Pod{}
+	for _, pod := range schedule.Pods {
+		if !containsPod(oversized, pod) {
+			pods = append(pods, pod)
+			continue
+		}
+		if !isScaleHint(pod) {
+			excluded = append(excluded, pod)
+		}
+		if accelerators := resources.AcceleratorRequests(pod); len(accelerators) > 1 && !offersAll(instanceTypes, accelerators) {
+			incompatibleAcceleratorPodsCounterVec.WithLabelValues(provisioner.Name).Inc()
+			c.Recorder.Eventf(pod, v1.EventTypeWarning, "IncompatibleAccelerators", "Pod requests accelerators %s, which no instance type allowed by provisioner %s offers together",
+				strings.Join(accelerators, ", "), provisioner.Name)
+			continue
+		}
+		oversizedPodsCounterVec.WithLabelValues(provisioner.Name).Inc()
+		c.Recorder.Eventf(pod, v1.EventTypeWarning, "PodTooLarge", "Pod is too large to fit on any instance type allowed by provisioner %s", provisioner.Name)
+	}
+	schedule.Pods = pods
+	return excluded
+}
+
+// offersAll returns true if any of the instance types offers all of the
+// accelerators
+func offersAll(instanceTypes []cloudprovider.InstanceType, accelerators []string) bool {
+	for _, instanceType := range instanceTypes {
+		capacity := instanceType.Resources()
+		offered := true
+		for _, accelerator := range accelerators {
+			if _, ok := capacity[v1.ResourceName(accelerator)]; !ok {
+				offered = false
+				break
+			}
+		}
+		if offered {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPod(pods []*v1.Pod, pod *v1.Pod) bool {
+	for _, p := range pods {
+		if p == pod {
+			return true
+		}
+	}
+	return false
+}
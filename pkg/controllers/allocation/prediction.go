@@ -0,0 +1,133 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// readyHistoryNodes bounds the number of recently launched nodes that ready
+// time predictions are based on, so that they follow changes in launch times
+const readyHistoryNodes = 20
+
+// readySample is how long a node took from launch until it was initialized
+type readySample struct {
+	instanceType string
+	created      time.Time
+	duration     time.Duration
+}
+
+// readyPrediction is the expected time until a launched node is initialized
+type readyPrediction struct {
+	p50     time.Duration
+	p90     time.Duration
+	samples int
+}
+
+func (p *readyPrediction) String() string {
+	return fmt.Sprintf("p50 %s, p90 %s, based on %d recent launch(es)", p.p50.Round(time.Second), p.p90.Round(time.Second), p.samples)
+}
+
+// readyHistoryFor returns how long the provisioner's initialized nodes took to
+// become initialized, most recently launched first. History is read from the
+// nodes themselves, so that it survives restarts and is shared by replicas.
+func (c *Controller) readyHistoryFor(ctx context.Context, provisioner *v1alpha4.Provisioner) ([]readySample, error) {
+	nodes := &v1.NodeList{}
+	if err := c.KubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	history := []readySample{}
+	for _, node := range ptr.NodeListToSlice(nodes) {
+		condition := nodeutil.GetCondition(node.Status.Conditions, v1alpha4.NodeInitialized)
+		if condition.Status != v1.ConditionTrue || condition.LastTransitionTime.Before(&node.CreationTimestamp) {
+			continue
+		}
+		history = append(history, readySample{
+			instanceType: node.Labels[v1.LabelInstanceTypeStable],
+			created:      node.CreationTimestamp.Time,
+			duration:     condition.LastTransitionTime.Sub(node.CreationTimestamp.Time),
+		})
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].created.After(history[j].created) })
+	return history, nil
+}
+
+// predictReady returns the expected time until nodes of the instance types
+// are initialized, based on the most recently launched nodes of the same
+// instance types, or of any instance type if there are none. Returns nil if
+// the provisioner hasn't launched any nodes yet.
+func predictReady(history []readySample, instanceTypes []cloudprovider.InstanceType) *readyPrediction {
+	names := map[string]bool{}
+	for _, instanceType := range instanceTypes {
+		names[instanceType.Name()] = true
+	}
+	durations := []time.Duration{}
+	for _, sample := range history {
+		if names[sample.instanceType] && len(durations) < readyHistoryNodes {
+			durations = append(durations, sample.duration)
+		}
+	}
+	if len(durations) == 0 {
+		for _, sample := range history {
+			if len(durations) < readyHistoryNodes {
+				durations = append(durations, sample.duration)
+			}
+		}
+	}
+	if len(durations) == 0 {
+		return nil
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return &readyPrediction{
+		p50:     percentile(durations, 50),
+		p90:     percentile(durations, 90),
+		samples: len(durations),
+	}
+}
+
+// percentile returns the nearest rank percentile of the sorted durations
+func percentile(durations []time.Duration, p int) time.Duration {
+	rank := (len(durations)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return durations[rank-1]
+}
+
+// recordReadyPrediction emits an event on the node and on each of its pods
+// with the time the node is expected to be ready, so that workload operators
+// can decide whether to wait for it
+func (c *Controller) recordReadyPrediction(node *v1.Node, pods []*v1.Pod, prediction *readyPrediction) {
+	if prediction == nil {
+		return
+	}
+	c.Recorder.Eventf(node, v1.EventTypeNormal, "ReadyPredicted", "Node is expected to be ready in %s", prediction)
+	for _, pod := range pods {
+		if isScaleHint(pod) {
+			continue
+		}
+		c.Recorder.Eventf(pod, v1.EventTypeNormal, "ReadyPredicted", "Node %s is expected to be ready in %s", node.Name, prediction)
+	}
+}
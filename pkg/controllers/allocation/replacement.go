@@ -0,0 +1,131 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/termination"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ReplacementThreshold is the expected drain duration at which capacity is
+// provisioned in advance for the pods of a terminating node, so that workloads
+// don't wait on slow drains to be rescheduled.
+const ReplacementThreshold = time.Minute
+
+// GetReplaceablePods returns the pods of the provisioner's terminating nodes
+// that are expected to drain slowly, have expired or are being interrupted,
+// along with the nodes they belong to. Expired, interrupted and rebalanced nodes
+// are always replaced in advance, so that their pods don't wait to be
+// rescheduled. The pods of rebalanced nodes are replaced in the target zone.
+func (f *Filter) GetReplaceablePods(ctx context.Context, provisioner *v1alpha4.Provisioner) ([]*v1.Pod, []*v1.Node, error) {
+	nodes := &v1.NodeList{}
+	if err := f.KubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return nil, nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	replaceable := []*v1.Pod{}
+	replaced := []*v1.Node{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !needsReplacement(node) {
+			continue
+		}
+		pods := &v1.PodList{}
+		if err := f.KubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": node.Name}); err != nil {
+			return nil, nil, fmt.Errorf("listing pods on node %s, %w", node.Name, err)
+		}
+		estimate, err := termination.EstimateDrain(ctx, f.KubeClient, ptr.PodListToSlice(pods))
+		if err != nil {
+			return nil, nil, fmt.Errorf("estimating drain for node %s, %w", node.Name, err)
+		}
+		if estimate.Duration < ReplacementThreshold && estimate.Blocked == 0 && !nodeutil.IsExpired(provisioner, node) && !isInterrupted(node) && !isRebalanced(node) {
+			continue
+		}
+		logging.FromContext(ctx).Infof("Provisioning replacement capacity for node %s, %s", node.Name, estimate)
+		for _, pod := range termination.GetEvictablePods(ptr.PodListToSlice(pods)) {
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			if zone := node.Annotations[v1alpha4.RebalanceZoneAnnotationKey]; zone != "" {
+				pod = pod.DeepCopy()
+				pod.Spec.NodeSelector = functional.UnionStringMaps(pod.Spec.NodeSelector, map[string]string{v1.LabelTopologyZone: zone})
+			}
+			replaceable = append(replaceable, pod)
+		}
+		replaced = append(replaced, node)
+	}
+	return replaceable, replaced, nil
+}
+
+// markReplaced annotates nodes once replacement capacity has been provisioned
+// for them, so that capacity is only provisioned once per node
+func (c *Controller) markReplaced(ctx context.Context, nodes []*v1.Node) error {
+	for _, node := range nodes {
+		persisted := node.DeepCopy()
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[v1alpha4.ReplacementAnnotationKey] = "true"
+		if err := c.KubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
+			return fmt.Errorf("patching node %s, %w", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// nodeToProvisioner is a function handler to transform terminating node objs
+// to provisioner reconcile requests
+func (c *Controller) nodeToProvisioner(ctx context.Context) func(o client.Object) []reconcile.Request {
+	return func(o client.Object) []reconcile.Request {
+		node := o.(*v1.Node)
+		name, ok := node.Labels[v1alpha4.ProvisionerNameLabelKey]
+		if !ok || !needsReplacement(node) {
+			return nil
+		}
+		provisioner, err := c.provisionerFor(ctx, types.NamespacedName{Name: name})
+		if err != nil {
+			return nil
+		}
+		c.Batcher.Add(provisioner)
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: provisioner.Name}}}
+	}
+}
+
+func needsReplacement(node *v1.Node) bool {
+	if _, ok := node.Annotations[v1alpha4.ReplacementAnnotationKey]; ok {
+		return false
+	}
+	return nodeutil.GetCondition(node.Status.Conditions, v1alpha4.NodeTerminating).Status == v1.ConditionTrue
+}
+
+func isInterrupted(node *v1.Node) bool {
+	return nodeutil.GetCondition(node.Status.Conditions, v1alpha4.NodeInterrupted).Status == v1.ConditionTrue
+}
+
+func isRebalanced(node *v1.Node) bool {
+	_, ok := node.Annotations[v1alpha4.RebalanceZoneAnnotationKey]
+	return ok
+}
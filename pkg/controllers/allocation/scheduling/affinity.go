@@ -0,0 +1,305 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SupportedAffinityTopologyKeys are the topology keys of pod affinity and
+// anti-affinity terms that can be scheduled.
+var SupportedAffinityTopologyKeys = []string{v1.LabelTopologyZone, v1.LabelHostname}
+
+type Affinity struct {
+	kubeClient client.Client
+}
+
+// Inject injects pod affinity and anti-affinity rules into pods
+// using NodeSelectors, in the same way as topology spread constraints. Pods
+// are greedily assigned to the first domain that satisfies their rules, and to
+// a new hostname if no existing one does. Returns errors for pods whose rules
+// can't be satisfied.
+func (a *Affinity) Inject(ctx context.Context, constraints *v1alpha4.Constraints, pods []*v1.Pod) []*PodError {
+	podErrs := []*PodError{}
+	for _, topologyKey := range SupportedAffinityTopologyKeys {
+		errs, err := a.inject(ctx, constraints, topologyKey, withoutPodErrors(pods, podErrs))
+		if err != nil {
+			for _, pod := range withoutPodErrors(pods, podErrs) {
+				podErrs = append(podErrs, &PodError{Pod: pod, Err: fmt.Errorf("computing pod affinity, %w", err)})
+			}
+			return podErrs
+		}
+		podErrs = append(podErrs, errs...)
+	}
+	return podErrs
+}
+
+func (a *Affinity) inject(ctx context.Context, constraints *v1alpha4.Constraints, topologyKey string, pods []*v1.Pod) ([]*PodError, error) {
+	// 1. Assign pods that are selected by others' terms before pods with
+	// affinity terms, so that the latter can join them.
+	selected, affine := affinityParticipants(topologyKey, pods)
+	if len(affine) == 0 {
+		return nil, nil
+	}
+	// 2. Compute the pods in each existing domain. Nodes are launched empty,
+	// so existing pods only share zones with new nodes.
+	domains := &affinityDomains{topologyKey: topologyKey, pods: map[string][]*v1.Pod{}}
+	if topologyKey == v1.LabelTopologyZone {
+		if err := a.countExistingPods(ctx, domains); err != nil {
+			return nil, err
+		}
+	}
+	// 3. Assign pods to domains
+	podErrs := []*PodError{}
+	for _, pod := range append(selected, affine...) {
+		candidates := domains.candidatesFor(constraints, pod)
+		domain, ok := domains.firstSatisfying(pod, candidates)
+		if !ok {
+			podErrs = append(podErrs, &PodError{Pod: pod, Err: domains.unsatisfiableError(candidates)})
+			continue
+		}
+		domains.add(domain, pod)
+		pod.Spec.NodeSelector = functional.UnionStringMaps(pod.Spec.NodeSelector, map[string]string{topologyKey: domain})
+	}
+	return podErrs, nil
+}
+
+func (a *Affinity) countExistingPods(ctx context.Context, domains *affinityDomains) error {
+	podList := &v1.PodList{}
+	if err := a.kubeClient.List(ctx, podList); err != nil {
+		return fmt.Errorf("listing pods, %w", err)
+	}
+	nodes := map[string]*v1.Node{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if len(pod.Spec.NodeName) == 0 {
+			continue // Don't include pods that aren't scheduled
+		}
+		node, ok := nodes[pod.Spec.NodeName]
+		if !ok {
+			node = &v1.Node{}
+			if err := a.kubeClient.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+				return fmt.Errorf("getting node %s, %w", pod.Spec.NodeName, err)
+			}
+			nodes[pod.Spec.NodeName] = node
+		}
+		if domain, ok := node.Labels[domains.topologyKey]; ok {
+			domains.add(domain, pod)
+		}
+	}
+	return nil
+}
+
+// affinityParticipants returns the pods with affinity terms for the topology
+// key, and the pods without terms that are selected by them.
+func affinityParticipants(topologyKey string, pods []*v1.Pod) (selected []*v1.Pod, affine []*v1.Pod) {
+	for _, pod := range pods {
+		if affinity, antiAffinity := affinityTerms(pod, topologyKey); len(affinity)+len(antiAffinity) > 0 {
+			affine = append(affine, pod)
+		}
+	}
+	for _, pod := range pods {
+		if affinity, antiAffinity := affinityTerms(pod, topologyKey); len(affinity)+len(antiAffinity) > 0 {
+			continue
+		}
+		for _, owner := range affine {
+			affinity, antiAffinity := affinityTerms(owner, topologyKey)
+			if anyTermSelects(owner, append(affinity, antiAffinity...), pod) {
+				selected = append(selected, pod)
+				break
+			}
+		}
+	}
+	return selected, affine
+}
+
+// affinityDomains tracks the pods in each domain of a topology key
+type affinityDomains struct {
+	topologyKey string
+	pods        map[string][]*v1.Pod
+	// hostnames that have been generated, in order
+	hostnames []string
+}
+
+func (d *affinityDomains) add(domain string, pod *v1.Pod) {
+	if _, ok := d.pods[domain]; !ok && d.topologyKey == v1.LabelHostname {
+		d.hostnames = append(d.hostnames, domain)
+	}
+	d.pods[domain] = append(d.pods[domain], pod)
+}
+
+// candidatesFor returns the domains the pod may be assigned to, in order of
+// preference. Pods may always be assigned a new hostname.
+func (d *affinityDomains) candidatesFor(constraints *v1alpha4.Constraints, pod *v1.Pod) []string {
+	if d.topologyKey == v1.LabelTopologyZone {
+		zones := scheduling.LabelValuesFor(pod, v1.LabelTopologyZone, constraints.Zones)
+		sort.Strings(zones)
+		return zones
+	}
+	if hostname, ok := pod.Spec.NodeSelector[v1.LabelHostname]; ok {
+		return []string{hostname}
+	}
+	return append(append([]string{}, d.hostnames...), strings.ToLower(randomdata.Alphanumeric(8)))
+}
+
+func (d *affinityDomains) unsatisfiableError(candidates []string) error {
+	if d.topologyKey == v1.LabelHostname {
+		return fmt.Errorf("pod affinity or anti-affinity for %s can't be satisfied by a new node", d.topologyKey)
+	}
+	return fmt.Errorf("pod affinity or anti-affinity for %s can't be satisfied by %v", d.topologyKey, candidates)
+}
+
+func (d *affinityDomains) firstSatisfying(pod *v1.Pod, candidates []string) (string, bool) {
+	for _, candidate := range candidates {
+		if d.satisfies(pod, candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// satisfies returns true if the pod can join the domain without violating its
+// own rules or the anti-affinity of the pods already in the domain.
+func (d *affinityDomains) satisfies(pod *v1.Pod, domain string) bool {
+	affinity, antiAffinity := affinityTerms(pod, d.topologyKey)
+	for _, other := range d.pods[domain] {
+		if anyTermSelects(pod, antiAffinity, other) {
+			return false
+		}
+		if _, otherAntiAffinity := affinityTerms(other, d.topologyKey); anyTermSelects(other, otherAntiAffinity, pod) {
+			return false
+		}
+	}
+	for _, term := range affinity {
+		if d.selectsAny(pod, term, d.pods[domain]) {
+			continue
+		}
+		// Like kube-scheduler, the first of a group of pods with affinity for
+		// each other may schedule anywhere.
+		if termSelects(pod, term, pod) && !d.selectsAnyDomain(pod, term) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func (d *affinityDomains) selectsAny(owner *v1.Pod, term v1.PodAffinityTerm, pods []*v1.Pod) bool {
+	for _, pod := range pods {
+		if termSelects(owner, term, pod) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *affinityDomains) selectsAnyDomain(owner *v1.Pod, term v1.PodAffinityTerm) bool {
+	for _, pods := range d.pods {
+		if d.selectsAny(owner, term, pods) {
+			return true
+		}
+	}
+	return false
+}
+
+// affinityTerms returns the pod's affinity and anti-affinity terms for the
+// topology key. The heaviest preferred terms are treated as required. An outer
+// loop will iteratively remove them if unsatisfiable.
+func affinityTerms(pod *v1.Pod, topologyKey string) (affinity []v1.PodAffinityTerm, antiAffinity []v1.PodAffinityTerm) {
+	if pod.Spec.Affinity == nil {
+		return nil, nil
+	}
+	if pod.Spec.Affinity.PodAffinity != nil {
+		terms := pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		if i, ok := heaviestPodAffinityTerm(pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution); ok {
+			terms = append(append([]v1.PodAffinityTerm{}, terms...), pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].PodAffinityTerm)
+		}
+		for _, term := range terms {
+			if term.TopologyKey == topologyKey {
+				affinity = append(affinity, term)
+			}
+		}
+	}
+	if pod.Spec.Affinity.PodAntiAffinity != nil {
+		terms := pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		if i, ok := heaviestPodAffinityTerm(pod.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution); ok {
+			terms = append(append([]v1.PodAffinityTerm{}, terms...), pod.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].PodAffinityTerm)
+		}
+		for _, term := range terms {
+			if term.TopologyKey == topologyKey {
+				antiAffinity = append(antiAffinity, term)
+			}
+		}
+	}
+	return affinity, antiAffinity
+}
+
+// heaviestPodAffinityTerm returns the index of the first of the heaviest
+// preferred terms with a supported topology key and a valid label selector.
+// Other preferred terms are ignored.
+func heaviestPodAffinityTerm(terms []v1.WeightedPodAffinityTerm) (int, bool) {
+	heaviest := -1
+	for i, term := range terms {
+		if !functional.ContainsString(SupportedAffinityTopologyKeys, term.PodAffinityTerm.TopologyKey) {
+			continue
+		}
+		if _, err := metav1.LabelSelectorAsSelector(term.PodAffinityTerm.LabelSelector); err != nil {
+			continue
+		}
+		if heaviest < 0 || term.Weight > terms[heaviest].Weight {
+			heaviest = i
+		}
+	}
+	return heaviest, heaviest >= 0
+}
+
+func anyTermSelects(owner *v1.Pod, terms []v1.PodAffinityTerm, pod *v1.Pod) bool {
+	for _, term := range terms {
+		if termSelects(owner, term, pod) {
+			return true
+		}
+	}
+	return false
+}
+
+// termSelects returns true if the owner's term selects the pod. Terms select
+// pods in the owner's namespace unless namespaces are specified.
+func termSelects(owner *v1.Pod, term v1.PodAffinityTerm, pod *v1.Pod) bool {
+	namespaces := term.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{owner.Namespace}
+	}
+	if !functional.ContainsString(namespaces, pod.Namespace) {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false // Selectors are validated by validateAffinity
+	}
+	return selector.Matches(labels.Set(pod.Labels))
+}
@@ -17,16 +17,32 @@ package scheduling
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/scheduling"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// NewConstraints overrides the constraints with pod scheduling constraints
+// NewConstraints overrides the constraints with pod scheduling constraints.
+// Required node selector terms are ORed, so each term is tried in order and
+// the first that the constraints can satisfy is used.
 func NewConstraints(ctx context.Context, constraints *v1alpha4.Constraints, pod *v1.Pod) (*v1alpha4.Constraints, error) {
+	var errs error
+	for _, branch := range scheduling.NodeSelectorTermBranches(pod) {
+		constrained, err := newConstraintsFor(ctx, constraints, branch)
+		if err == nil {
+			return constrained, nil
+		}
+		errs = multierr.Append(errs, err)
+	}
+	return nil, errs
+}
+
+func newConstraintsFor(ctx context.Context, constraints *v1alpha4.Constraints, pod *v1.Pod) (*v1alpha4.Constraints, error) {
 	// Validate that the pod is viable
 	if err := multierr.Combine(
 		validateAffinity(pod),
@@ -86,14 +102,18 @@ func generateLabels(constraints *v1alpha4.Constraints, pod *v1.Pod) error {
 	// Override with pod labels
 	nodeAffinity := scheduling.NodeAffinityFor(pod)
 	for _, key := range nodeAffinity.GetLabels() {
-		if _, ok := v1alpha4.WellKnownLabels[key]; !ok {
+		if !v1alpha4.WellKnownLabels.IsWellKnown(key) {
 			var labelConstraints []string
 			if value, ok := constraints.Labels[key]; ok {
 				labelConstraints = append(labelConstraints, value)
 			}
 			values := nodeAffinity.GetLabelValues(key, labelConstraints)
 			if len(values) == 0 {
-				return fmt.Errorf("label %s is too constrained", key)
+				// Requirements such as NotIn and DoesNotExist are satisfied by omitting the label
+				if _, ok := constraints.Labels[key]; !ok && nodeAffinity.AllowsAbsent(key) {
+					continue
+				}
+				return nodeAffinity.ConflictFor(key, labelConstraints)
 			}
 			labels[key] = values[0]
 		}
@@ -102,6 +122,32 @@ func generateLabels(constraints *v1alpha4.Constraints, pod *v1.Pod) error {
 	return nil
 }
 
+// validateRestrictedLabels returns an error if the pod's node selector or
+// required node affinity selects on a restricted label. Requirements that are
+// satisfied by the label's absence, i.e. NotIn and DoesNotExist, are allowed.
+func validateRestrictedLabels(pod *v1.Pod) error {
+	keys := []string{}
+	for key := range pod.Spec.NodeSelector {
+		keys = append(keys, key)
+	}
+	if pod.Spec.Affinity != nil && pod.Spec.Affinity.NodeAffinity != nil && pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			for _, requirement := range term.MatchExpressions {
+				if requirement.Operator != v1.NodeSelectorOpNotIn && requirement.Operator != v1.NodeSelectorOpDoesNotExist {
+					keys = append(keys, requirement.Key)
+				}
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if v1alpha4.WellKnownLabels.IsRestricted(key) && !v1alpha4.WellKnownLabels.IsWellKnown(key) {
+			return &RestrictedLabelError{Key: key}
+		}
+	}
+	return nil
+}
+
 func validateTopology(pod *v1.Pod) (errs error) {
 	for _, constraint := range pod.Spec.TopologySpreadConstraints {
 		if supported := []string{v1.LabelHostname, v1.LabelTopologyZone}; !functional.ContainsString(supported, constraint.TopologyKey) {
@@ -116,10 +162,10 @@ func validateAffinity(pod *v1.Pod) (errs error) {
 		return nil
 	}
 	if pod.Spec.Affinity.PodAffinity != nil {
-		errs = multierr.Append(errs, fmt.Errorf("pod affinity is not supported"))
+		errs = multierr.Append(errs, validatePodAffinityTerms(pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution))
 	}
 	if pod.Spec.Affinity.PodAntiAffinity != nil {
-		errs = multierr.Append(errs, fmt.Errorf("pod anti-affinity is not supported"))
+		errs = multierr.Append(errs, validatePodAffinityTerms(pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution))
 	}
 	if pod.Spec.Affinity.NodeAffinity != nil {
 		for _, term := range pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
@@ -134,13 +180,38 @@ func validateAffinity(pod *v1.Pod) (errs error) {
 	return errs
 }
 
+// validatePodAffinityTerms validates required pod affinity or anti-affinity
+// terms. Preferred terms are ignored.
+func validatePodAffinityTerms(terms []v1.PodAffinityTerm) (errs error) {
+	for _, term := range terms {
+		if !functional.ContainsString(SupportedAffinityTopologyKeys, term.TopologyKey) {
+			errs = multierr.Append(errs, fmt.Errorf("unsupported pod affinity topology key, %s not in %s", term.TopologyKey, SupportedAffinityTopologyKeys))
+		}
+		if _, err := metav1.LabelSelectorAsSelector(term.LabelSelector); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("invalid pod affinity label selector, %w", err))
+		}
+	}
+	return errs
+}
+
+// SupportedNodeSelectorOperators are the operators of node affinity
+// requirements that can be scheduled.
+var SupportedNodeSelectorOperators = []string{
+	string(v1.NodeSelectorOpIn),
+	string(v1.NodeSelectorOpNotIn),
+	string(v1.NodeSelectorOpExists),
+	string(v1.NodeSelectorOpDoesNotExist),
+	string(v1.NodeSelectorOpGt),
+	string(v1.NodeSelectorOpLt),
+}
+
 func validateNodeSelectorTerm(term v1.NodeSelectorTerm) (errs error) {
 	if term.MatchFields != nil {
 		errs = multierr.Append(errs, fmt.Errorf("matchFields is not supported"))
 	}
 	if term.MatchExpressions != nil {
 		for _, requirement := range term.MatchExpressions {
-			if !functional.ContainsString([]string{string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn)}, string(requirement.Operator)) {
+			if !functional.ContainsString(SupportedNodeSelectorOperators, string(requirement.Operator)) {
 				errs = multierr.Append(errs, fmt.Errorf("unsupported operator, %s", requirement.Operator))
 			}
 		}
@@ -0,0 +1,65 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RestrictedLabelError is returned for pods that select on labels that are
+// restricted and not well known, e.g. karpenter.sh/emptiness-timestamp or
+// kubernetes.io/hostname. These labels are set by Karpenter, the cloud
+// provider, or the kubelet, so nodes can't be launched with them.
+type RestrictedLabelError struct {
+	Key string
+}
+
+func (e *RestrictedLabelError) Error() string {
+	return fmt.Sprintf("selecting on restricted label %s is not supported", e.Key)
+}
+
+// AsRestrictedLabelError returns the RestrictedLabelError if err is one (even if it's wrapped)
+func AsRestrictedLabelError(err error) (*RestrictedLabelError, bool) {
+	var restrictedLabelError *RestrictedLabelError
+	if errors.As(err, &restrictedLabelError) {
+		return restrictedLabelError, true
+	}
+	return nil, false
+}
+
+// ConstraintError is returned for pods whose scheduling constraints are
+// incompatible with the provisioner's constraints, e.g. a pod that requires a
+// zone that the provisioner doesn't allow, or a taint the pod doesn't tolerate.
+type ConstraintError struct {
+	Err error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("invalid constraints, %s", e.Err.Error())
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// AsConstraintError returns the ConstraintError if err is one (even if it's wrapped)
+func AsConstraintError(err error) (*ConstraintError, bool) {
+	var constraintError *ConstraintError
+	if errors.As(err, &constraintError) {
+		return constraintError, true
+	}
+	return nil, false
+}
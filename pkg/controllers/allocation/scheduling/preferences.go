@@ -18,88 +18,266 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	podutil "github.com/awslabs/karpenter/pkg/utils/pod"
 	"github.com/awslabs/karpenter/pkg/utils/pretty"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const (
 	ExpirationTTL   = 5 * time.Minute
 	CleanupInterval = 1 * time.Minute
+	// MaxRelaxations caps the number of times a pod's preferences are relaxed,
+	// to avoid thrashing. Once reached, relaxation stops and the pod keeps its
+	// relaxed preferences until they expire.
+	MaxRelaxations = 10
 )
 
+var relaxationsCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "relaxations_total",
+		Help:      "Number of times pod scheduling preferences were relaxed after failing to schedule. Broken down by the requirement key or field and topology key of the relaxed preference.",
+	},
+	[]string{"key"},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(relaxationsCounterVec)
+}
+
 type Preferences struct {
-	cache *cache.Cache
+	kubeClient client.Client
+	cache      *cache.Cache
+}
+
+// relaxation tracks the relaxed state of a pod's preferences
+type relaxation struct {
+	affinity                  *v1.Affinity
+	topologySpreadConstraints []v1.TopologySpreadConstraint
+	rounds                    int
+	// keys identify the preferences that have been relaxed
+	keys []string
 }
 
-func NewPreferences() *Preferences {
+func NewPreferences(kubeClient client.Client) *Preferences {
 	return &Preferences{
-		cache: cache.New(ExpirationTTL, CleanupInterval),
+		kubeClient: kubeClient,
+		cache:      cache.New(ExpirationTTL, CleanupInterval),
 	}
 }
 
 // Relax removes soft preferences from pods to enable scheduling if the cloud
 // provider's capacity is constrained. For example, this can be leveraged to
 // prefer a specific zone, but relax the preferences if the pod cannot be
-// scheduled to that zone. Preferences are removed iteratively until only hard
-// constraints remain. Pods relaxation is reset (forgotten) after 5 minutes.
+// scheduled to that zone. Preferred node affinity, preferred pod affinity and
+// anti-affinity, and topology spread constraints with ScheduleAnyway are
+// removed iteratively until only hard constraints remain. Scored preferences
+// only narrow the zones and architectures that capacity is launched in, so
+// they're removed last, once a pod fails to launch with every other preference
+// relaxed, e.g. if its preferred zone has no capacity. Relaxation stops
+// after MaxRelaxations rounds, and a pod's relaxation is forgotten
+// ExpirationTTL after its last round.
+// The relaxed preferences are summarized in an annotation on the pod, using
+// requirement keys for node affinity, and "<field>:<topologyKey>" otherwise.
 func (p *Preferences) Relax(ctx context.Context, pods []*v1.Pod) {
 	for _, pod := range pods {
-		affinity, ok := p.cache.Get(string(pod.UID))
+		cached, ok := p.cache.Get(string(pod.UID))
 		// Add to cache if we've never seen it before
 		if !ok {
-			p.cache.Set(string(pod.UID), pod.Spec.Affinity, ExpirationTTL)
+			p.cache.Set(string(pod.UID), &relaxation{
+				affinity:                  pod.Spec.Affinity,
+				topologySpreadConstraints: pod.Spec.TopologySpreadConstraints,
+			}, ExpirationTTL)
 			continue
 		}
 		// Attempt to relax the pod and update the cache
-		pod.Spec.Affinity = affinity.(*v1.Affinity)
-		if relaxed := p.relax(ctx, pod); relaxed {
-			p.cache.Set(string(pod.UID), pod.Spec.Affinity, ExpirationTTL)
+		state := cached.(*relaxation)
+		pod.Spec.Affinity = state.affinity
+		pod.Spec.TopologySpreadConstraints = state.topologySpreadConstraints
+		if state.rounds >= MaxRelaxations {
+			logging.FromContext(ctx).Debugf("Not relaxing soft constraints for %s/%s after %d relaxations", pod.Namespace, pod.Name, state.rounds)
+			continue
+		}
+		if keys, relaxed := p.relax(ctx, pod); relaxed {
+			state.affinity = pod.Spec.Affinity
+			state.topologySpreadConstraints = pod.Spec.TopologySpreadConstraints
+			state.rounds++
+			state.keys = functional.UniqueStrings(append(state.keys, keys...))
+			sort.Strings(state.keys)
+			p.cache.Set(string(pod.UID), state, ExpirationTTL)
+			for _, key := range keys {
+				relaxationsCounterVec.WithLabelValues(key).Inc()
+			}
+			p.annotate(ctx, pod, state.keys)
 		}
 	}
 }
 
-func (p *Preferences) relax(ctx context.Context, pod *v1.Pod) bool {
-	for _, relaxFunc := range []func(*v1.Pod) *string{
-		func(pod *v1.Pod) *string { return p.removePreferredNodeAffinityTerm(pod) },
-		func(pod *v1.Pod) *string { return p.removeRequiredNodeAffinityTerm(pod) },
+// Escalate removes every preference of pods that missed their provisioning
+// deadline, including scored preferences, which otherwise narrow the zones and
+// architectures they launch in. Required constraints are kept, so pods are
+// only widened within their provisioner's policy. The cached affinity is
+// shared with relaxation, so it's copied rather than modified.
+func (p *Preferences) Escalate(ctx context.Context, pods []*v1.Pod) {
+	for _, pod := range pods {
+		if !podutil.IsEscalated(pod) {
+			continue
+		}
+		if pod.Spec.Affinity != nil {
+			affinity := pod.Spec.Affinity.DeepCopy()
+			if affinity.NodeAffinity != nil {
+				affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = nil
+			}
+			if affinity.PodAffinity != nil {
+				affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution = nil
+			}
+			if affinity.PodAntiAffinity != nil {
+				affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = nil
+			}
+			pod.Spec.Affinity = affinity
+		}
+		var constraints []v1.TopologySpreadConstraint
+		for _, constraint := range pod.Spec.TopologySpreadConstraints {
+			if constraint.WhenUnsatisfiable != v1.ScheduleAnyway {
+				constraints = append(constraints, constraint)
+			}
+		}
+		pod.Spec.TopologySpreadConstraints = constraints
+		logging.FromContext(ctx).Debugf("Removing preferences of %s/%s since it missed its provisioning deadline", pod.Namespace, pod.Name)
+	}
+}
+
+func (p *Preferences) relax(ctx context.Context, pod *v1.Pod) ([]string, bool) {
+	for _, relaxFunc := range []func(*v1.Pod) (*string, []string){
+		p.removePreferredNodeAffinityTerm,
+		p.removePreferredPodAffinityTerm,
+		p.removePreferredPodAntiAffinityTerm,
+		p.removePreferredTopologySpreadConstraint,
+		p.removeScoredNodeAffinityTerm,
+		p.removeRequiredNodeAffinityTerm,
 	} {
-		if reason := relaxFunc(pod); reason != nil {
+		if reason, keys := relaxFunc(pod); reason != nil {
 			logging.FromContext(ctx).Debugf("Relaxing soft constraints for %s/%s since it previously failed to schedule, removing: %s", pod.Namespace, pod.Name, ptr.StringValue(reason))
-			return true
+			return keys, true
+		}
+	}
+	return nil, false
+}
+
+// annotate summarizes the relaxed preferences on the pod. The pod is
+// copied, since its in memory affinity differs from the stored pod.
+func (p *Preferences) annotate(ctx context.Context, pod *v1.Pod, keys []string) {
+	patched := pod.DeepCopy()
+	patched.Annotations = functional.UnionStringMaps(patched.Annotations, map[string]string{
+		v1alpha4.RelaxedPreferencesAnnotationKey: strings.Join(keys, ","),
+	})
+	if err := p.kubeClient.Patch(ctx, patched, client.MergeFrom(pod)); err != nil {
+		logging.FromContext(ctx).Debugf("Failed to annotate relaxed preferences for %s/%s, %s", pod.Namespace, pod.Name, err.Error())
+	}
+}
+
+func (p *Preferences) removePreferredNodeAffinityTerm(pod *v1.Pod) (*string, []string) {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || len(pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+		return nil, nil
+	}
+	terms := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	// Sort descending by weight to remove heaviest preferences to try lighter ones
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].Weight > terms[j].Weight })
+	// Scored preferences never prevent scheduling, so only remove the heaviest unscored term
+	for i, term := range terms {
+		if scheduling.IsScoredPreference(term) {
+			continue
 		}
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(terms[:i:i], terms[i+1:]...)
+		return ptr.String(fmt.Sprintf("spec.affinity.nodeAffinity.preferredDuringSchedulingIgnoredDuringExecution[%d]=%s", i, pretty.Concise(term))), requirementKeys(term.Preference.MatchExpressions)
 	}
-	return false
+	return nil, nil
 }
 
-func (p *Preferences) removePreferredNodeAffinityTerm(pod *v1.Pod) *string {
+func (p *Preferences) removeScoredNodeAffinityTerm(pod *v1.Pod) (*string, []string) {
 	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || len(pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
-		return nil
+		return nil, nil
 	}
+	// Unscored terms have already been removed, so remove the heaviest scored term
 	terms := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
-	// Remove the terms if there are any (terms are an OR semantic)
-	if len(terms) > 0 {
-		// Sort descending by weight to remove heaviest preferences to try lighter ones
-		sort.SliceStable(terms, func(i, j int) bool { return terms[i].Weight > terms[j].Weight })
-		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = terms[1:]
-		return ptr.String(fmt.Sprintf("spec.affinity.nodeAffinity.preferredDuringSchedulingIgnoredDuringExecution[0]=%s", pretty.Concise(terms[0])))
-	}
-	return nil
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].Weight > terms[j].Weight })
+	pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = terms[1:]
+	return ptr.String(fmt.Sprintf("spec.affinity.nodeAffinity.preferredDuringSchedulingIgnoredDuringExecution[0]=%s", pretty.Concise(terms[0]))), requirementKeys(terms[0].Preference.MatchExpressions)
 }
 
-func (p *Preferences) removeRequiredNodeAffinityTerm(pod *v1.Pod) *string {
-	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
-		return nil
+func (p *Preferences) removeRequiredNodeAffinityTerm(pod *v1.Pod) (*string, []string) {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil ||
+		len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+		return nil, nil
 	}
 	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
 	// Remove the first term if there's more than one (terms are an OR semantic), Unlike preferred affinity, we cannot remove all terms
 	if len(terms) > 1 {
 		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = terms[1:]
-		return ptr.String(fmt.Sprintf("spec.affinity.nodeAffinity.requiredDuringSchedulingIgnoredDuringExecution[0]=%s", pretty.Concise(terms[0])))
+		return ptr.String(fmt.Sprintf("spec.affinity.nodeAffinity.requiredDuringSchedulingIgnoredDuringExecution[0]=%s", pretty.Concise(terms[0]))), requirementKeys(terms[0].MatchExpressions)
+	}
+	return nil, nil
+}
+
+func (p *Preferences) removePreferredPodAffinityTerm(pod *v1.Pod) (*string, []string) {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAffinity == nil {
+		return nil, nil
+	}
+	terms := pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	// Remove the heaviest term, which is treated as required until relaxed
+	if i, ok := heaviestPodAffinityTerm(terms); ok {
+		pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(terms[:i:i], terms[i+1:]...)
+		return ptr.String(fmt.Sprintf("spec.affinity.podAffinity.preferredDuringSchedulingIgnoredDuringExecution[%d]=%s", i, pretty.Concise(terms[i]))),
+			[]string{"podAffinity:" + terms[i].PodAffinityTerm.TopologyKey}
+	}
+	return nil, nil
+}
+
+func (p *Preferences) removePreferredPodAntiAffinityTerm(pod *v1.Pod) (*string, []string) {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAntiAffinity == nil {
+		return nil, nil
+	}
+	terms := pod.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	// Remove the heaviest term, which is treated as required until relaxed
+	if i, ok := heaviestPodAffinityTerm(terms); ok {
+		pod.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(terms[:i:i], terms[i+1:]...)
+		return ptr.String(fmt.Sprintf("spec.affinity.podAntiAffinity.preferredDuringSchedulingIgnoredDuringExecution[%d]=%s", i, pretty.Concise(terms[i]))),
+			[]string{"podAntiAffinity:" + terms[i].PodAffinityTerm.TopologyKey}
+	}
+	return nil, nil
+}
+
+func (p *Preferences) removePreferredTopologySpreadConstraint(pod *v1.Pod) (*string, []string) {
+	constraints := pod.Spec.TopologySpreadConstraints
+	// Remove the first constraint that may be unsatisfied, unlike those with DoNotSchedule
+	for i, constraint := range constraints {
+		if constraint.WhenUnsatisfiable == v1.ScheduleAnyway {
+			pod.Spec.TopologySpreadConstraints = append(constraints[:i:i], constraints[i+1:]...)
+			return ptr.String(fmt.Sprintf("spec.topologySpreadConstraints[%d]=%s", i, pretty.Concise(constraint))),
+				[]string{"topologySpreadConstraints:" + constraint.TopologyKey}
+		}
+	}
+	return nil, nil
+}
+
+func requirementKeys(requirements []v1.NodeSelectorRequirement) []string {
+	keys := []string{}
+	for _, requirement := range requirements {
+		keys = append(keys, requirement.Key)
 	}
-	return nil
+	return keys
 }
@@ -17,16 +17,20 @@ package scheduling
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	podutil "github.com/awslabs/karpenter/pkg/utils/pod"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
@@ -48,9 +52,11 @@ func init() {
 }
 
 type Scheduler struct {
-	KubeClient  client.Client
-	Topology    *Topology
-	Preferences *Preferences
+	KubeClient     client.Client
+	VolumeTopology *VolumeTopology
+	Topology       *Topology
+	Affinity       *Affinity
+	Preferences    *Preferences
 }
 
 type Schedule struct {
@@ -61,19 +67,42 @@ type Schedule struct {
 	Daemons []*v1.Pod
 }
 
+// PodError is an error scheduling a single pod. Pod errors don't prevent the
+// remaining pods from being scheduled.
+type PodError struct {
+	Pod *v1.Pod
+	Err error
+}
+
+func (e *PodError) Error() string {
+	return fmt.Sprintf("pod %s/%s, %s", e.Pod.Namespace, e.Pod.Name, e.Err.Error())
+}
+
+func (e *PodError) Unwrap() error {
+	return e.Err
+}
+
 func NewScheduler(kubeClient client.Client) *Scheduler {
 	return &Scheduler{
 		KubeClient: kubeClient,
+		VolumeTopology: &VolumeTopology{
+			kubeClient: kubeClient,
+		},
 		Topology: &Topology{
 			kubeClient: kubeClient,
 		},
-		Preferences: NewPreferences(),
+		Affinity: &Affinity{
+			kubeClient: kubeClient,
+		},
+		Preferences: NewPreferences(kubeClient),
 	}
 }
 
-func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha4.Provisioner, pods []*v1.Pod) ([]*Schedule, error) {
+// Solve separates the pods into schedules. Pods that can't be scheduled are
+// returned as pod errors alongside the schedules of the remaining pods.
+func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha4.Provisioner, pods []*v1.Pod) ([]*Schedule, []*PodError, error) {
 	startTime := time.Now()
-	schedules, scheduleErr := s.solve(ctx, &provisioner.Spec.Constraints, pods)
+	schedules, podErrs, scheduleErr := s.solve(ctx, &provisioner.Spec.Constraints, provisioner.UnhealthyZones(), pods)
 	durationSeconds := time.Since(startTime).Seconds()
 
 	result := "success"
@@ -97,74 +126,169 @@ func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha4.Provisioner
 		observer.Observe(durationSeconds)
 	}
 
-	return schedules, scheduleErr
+	return schedules, podErrs, scheduleErr
 }
 
-func (s *Scheduler) solve(ctx context.Context, constraints *v1alpha4.Constraints, pods []*v1.Pod) ([]*Schedule, error) {
+func (s *Scheduler) solve(ctx context.Context, constraints *v1alpha4.Constraints, unhealthyZones []string, pods []*v1.Pod) ([]*Schedule, []*PodError, error) {
 	// Apply runtime constraints
 	constraints = constraints.DeepCopy()
 	if err := constraints.Constrain(ctx); err != nil {
-		return nil, fmt.Errorf("applying constraints, %w", err)
+		return nil, nil, fmt.Errorf("applying constraints, %w", err)
+	}
+	// Avoid zones that are being evacuated
+	if len(unhealthyZones) > 0 {
+		constraints.Zones = functional.StringSliceWithout(constraints.Zones, unhealthyZones...)
+		if len(constraints.Zones) == 0 {
+			return nil, nil, fmt.Errorf("all zones are unhealthy, %v", unhealthyZones)
+		}
 	}
-	// Relax preferences if pods have previously failed to schedule.
+	// Relax preferences if pods have previously failed to schedule, and remove
+	// them entirely from pods that missed their provisioning deadline.
 	s.Preferences.Relax(ctx, pods)
+	s.Preferences.Escalate(ctx, pods)
 	// Inject temporarily adds specific NodeSelectors to pods, which are then
 	// used by scheduling logic. This isn't strictly necessary, but is a useful
 	// trick to avoid passing topology decisions through the scheduling code. It
 	// lets us to treat TopologySpreadConstraints as just-in-time NodeSelectors.
-	if err := s.Topology.Inject(ctx, constraints, pods); err != nil {
-		return nil, fmt.Errorf("injecting topology, %w", err)
+	// Zones of persistent volumes are injected first, so that topology spread
+	// and affinity only choose zones in which the pods' volumes are available.
+	// Pods that select on restricted labels are rejected first, since topology
+	// and affinity inject selectors on restricted labels such as hostname.
+	podErrs := []*PodError{}
+	for _, pod := range pods {
+		if err := validateRestrictedLabels(pod); err != nil {
+			podErrs = append(podErrs, &PodError{Pod: pod, Err: err})
+		}
 	}
+	podErrs = append(podErrs, s.VolumeTopology.Inject(ctx, withoutPodErrors(pods, podErrs))...)
+	podErrs = append(podErrs, s.Topology.Inject(ctx, constraints, withoutPodErrors(pods, podErrs))...)
+	// Pod affinity and anti-affinity are injected in the same way, after
+	// topology so that they respect the domains chosen for topology spread.
+	podErrs = append(podErrs, s.Affinity.Inject(ctx, constraints, withoutPodErrors(pods, podErrs))...)
 	// Separate pods into schedules of isomorphic scheduling constraints.
-	schedules, err := s.getSchedules(ctx, constraints, pods)
+	schedules, schedulePodErrs, err := s.getSchedules(ctx, constraints, withoutPodErrors(pods, podErrs))
 	if err != nil {
-		return nil, fmt.Errorf("getting schedules, %w", err)
+		return nil, nil, fmt.Errorf("getting schedules, %w", err)
 	}
+	podErrs = append(podErrs, schedulePodErrs...)
 	// Remove labels injected by TopologySpreadConstraints.
 	for _, schedule := range schedules {
 		delete(schedule.Labels, v1.LabelHostname)
 	}
-	return schedules, nil
+	return schedules, podErrs, nil
 }
 
 // getSchedules separates pods into a set of schedules. All pods in each group
 // contain isomorphic scheduling constraints and can be deployed together on the
 // same node, or multiple similar nodes if the pods exceed one node's capacity.
-func (s *Scheduler) getSchedules(ctx context.Context, v1alpha4constraints *v1alpha4.Constraints, pods []*v1.Pod) ([]*Schedule, error) {
+func (s *Scheduler) getSchedules(ctx context.Context, v1alpha4constraints *v1alpha4.Constraints, pods []*v1.Pod) ([]*Schedule, []*PodError, error) {
 	// schedule uniqueness is tracked by hash(Constraints)
 	schedules := map[uint64]*Schedule{}
+	// Replicas of the same template usually have the same constraints, which
+	// are only computed once per template for large scale ups
+	templates := map[uint64]*templateConstraints{}
+	podErrs := []*PodError{}
 	for _, pod := range pods {
-		constraints, err := NewConstraints(ctx, v1alpha4constraints, pod)
-		if err != nil {
-			logging.FromContext(ctx).Debugf("Ignored pod %s/%s due to invalid constraints, %s", pod.Name, pod.Namespace, err.Error())
-			continue
+		templateKey, ok := podTemplateKey(pod)
+		computed, cached := templates[templateKey]
+		if !ok || !cached {
+			computed = newTemplateConstraints(ctx, v1alpha4constraints, pod)
+			if ok {
+				templates[templateKey] = computed
+			}
 		}
-		key, err := hashstructure.Hash(constraints, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
-		if err != nil {
-			return nil, fmt.Errorf("hashing constraints, %w", err)
+		if computed.err != nil {
+			podErrs = append(podErrs, &PodError{Pod: pod, Err: computed.err})
+			continue
 		}
 		// Create new schedule if one doesn't exist
-		if _, ok := schedules[key]; !ok {
+		if _, ok := schedules[computed.key]; !ok {
 			// Uses a theoretical node object to compute schedulablility of daemonset overhead.
-			daemons, err := s.getDaemons(ctx, constraints)
+			daemons, err := s.getDaemons(ctx, computed.constraints)
 			if err != nil {
-				return nil, fmt.Errorf("computing node overhead, %w", err)
+				return nil, nil, fmt.Errorf("computing node overhead, %w", err)
 			}
-			schedules[key] = &Schedule{
-				Constraints: constraints,
+			schedules[computed.key] = &Schedule{
+				Constraints: computed.constraints,
 				Pods:        []*v1.Pod{},
 				Daemons:     daemons,
 			}
 		}
 		// Append pod to schedule, guaranteed to exist
-		schedules[key].Pods = append(schedules[key].Pods, pod)
+		schedules[computed.key].Pods = append(schedules[computed.key].Pods, pod)
 	}
 
 	result := []*Schedule{}
 	for _, schedule := range schedules {
 		result = append(result, schedule)
 	}
-	return result, nil
+	return result, podErrs, nil
+}
+
+// templateConstraints are the constraints of a pod, and the hash of the
+// constraints that identifies its schedule, or the error computing them
+type templateConstraints struct {
+	constraints *v1alpha4.Constraints
+	key         uint64
+	err         error
+}
+
+func newTemplateConstraints(ctx context.Context, v1alpha4constraints *v1alpha4.Constraints, pod *v1.Pod) *templateConstraints {
+	constraints, err := NewConstraints(ctx, v1alpha4constraints, pod)
+	if err != nil {
+		return &templateConstraints{err: &ConstraintError{Err: err}}
+	}
+	constraints.Canonicalize()
+	key, err := hashstructure.Hash(constraints, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+	if err != nil {
+		return &templateConstraints{err: fmt.Errorf("hashing constraints, %w", err)}
+	}
+	return &templateConstraints{constraints: constraints, key: key}
+}
+
+// podTemplateKey identifies pods with the same controller and template, e.g.
+// replicas of a ReplicaSet, whose constraints are the same. Selectors injected
+// for topology, affinity and volumes, and relaxed preferences, vary between
+// replicas, so they're part of the key, as is escalation, which cloud
+// providers may widen constraints for. Returns false for pods without a
+// controller or template hash, e.g. bare pods created with generateName.
+func podTemplateKey(pod *v1.Pod) (uint64, bool) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return 0, false
+	}
+	templateHash, ok := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+	if !ok {
+		templateHash, ok = pod.Labels[appsv1.ControllerRevisionHashLabelKey]
+	}
+	if !ok {
+		return 0, false
+	}
+	key, err := hashstructure.Hash(struct {
+		Owner                     types.UID
+		TemplateHash              string
+		NodeSelector              map[string]string
+		Affinity                  *v1.Affinity
+		Tolerations               []v1.Toleration
+		TopologySpreadConstraints []v1.TopologySpreadConstraint
+		Escalated                 bool
+	}{owner.UID, templateHash, pod.Spec.NodeSelector, pod.Spec.Affinity, pod.Spec.Tolerations, pod.Spec.TopologySpreadConstraints, podutil.IsEscalated(pod)}, hashstructure.FormatV2, nil)
+	return key, err == nil
+}
+
+// withoutPodErrors returns the pods that don't have pod errors
+func withoutPodErrors(pods []*v1.Pod, podErrs []*PodError) []*v1.Pod {
+	errored := map[*v1.Pod]bool{}
+	for _, podErr := range podErrs {
+		errored[podErr.Pod] = true
+	}
+	result := []*v1.Pod{}
+	for _, pod := range pods {
+		if !errored[pod] {
+			result = append(result, pod)
+		}
+	}
+	return result
 }
 
 func (s *Scheduler) getDaemons(ctx context.Context, constraints *v1alpha4.Constraints) ([]*v1.Pod, error) {
@@ -187,14 +311,109 @@ func (s *Scheduler) getDaemons(ctx context.Context, constraints *v1alpha4.Constr
 
 // DaemonWillSchedule returns true if the pod can schedule to the node
 func DaemonWillSchedule(constraints *v1alpha4.Constraints, pod *v1.Pod) bool {
-	// Tolerate Taints
+	// Tolerate Taints. StartupTaints are ignored since they are removed once
+	// the node initializes, after which the daemon will schedule.
 	if err := scheduling.Taints(constraints.Taints).Tolerates(pod); err != nil {
 		return false
 	}
 	// Match Node Selector labels
-	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(constraints.Labels)) {
+	for key, value := range pod.Spec.NodeSelector {
+		if !requirementMatches(constraints, v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: []string{value}}) {
+			return false
+		}
+	}
+	// Match any of the required Node Affinity terms
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if termMatches(constraints, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// deprecatedLabels are still set by the kubelet, with the same values as
+// their stable equivalents
+var deprecatedLabels = map[string]string{
+	v1.LabelFailureDomainBetaZone: v1.LabelTopologyZone,
+	v1.LabelInstanceType:          v1.LabelInstanceTypeStable,
+	"beta.kubernetes.io/arch":     v1.LabelArchStable,
+	"beta.kubernetes.io/os":       v1.LabelOSStable,
+}
+
+// termMatches returns true if the node may match all of the term's
+// requirements. An empty term matches nothing, as in kube-scheduler. Fields
+// (i.e. the node's name) aren't known until launch and are assumed to match.
+func termMatches(constraints *v1alpha4.Constraints, term v1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
 		return false
 	}
-	// TODO support node affinity for daemonset
+	for _, requirement := range term.MatchExpressions {
+		if !requirementMatches(constraints, requirement) {
+			return false
+		}
+	}
 	return true
 }
+
+// requirementMatches returns true if the node may satisfy the requirement.
+// Constraints such as zones allow a node to have one of several values, so
+// daemons are included in overhead if any of the values satisfy them.
+func requirementMatches(constraints *v1alpha4.Constraints, requirement v1.NodeSelectorRequirement) bool {
+	values, exists := nodeLabelValues(constraints, requirement.Key)
+	switch requirement.Operator {
+	case v1.NodeSelectorOpIn:
+		return exists && len(functional.IntersectStringSlice(values, requirement.Values)) > 0
+	case v1.NodeSelectorOpNotIn:
+		return !exists || values == nil || len(functional.StringSliceWithout(values, requirement.Values...)) > 0
+	case v1.NodeSelectorOpExists:
+		return exists
+	case v1.NodeSelectorOpDoesNotExist:
+		return !exists
+	case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+		if !exists || len(requirement.Values) != 1 {
+			return false
+		}
+		if values == nil {
+			return true
+		}
+		bound, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		for _, value := range values {
+			if parsed, err := strconv.ParseInt(value, 10, 64); err == nil &&
+				((requirement.Operator == v1.NodeSelectorOpGt && parsed > bound) || (requirement.Operator == v1.NodeSelectorOpLt && parsed < bound)) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// nodeLabelValues returns the values the node may have for the label, and
+// whether the node will have the label at all. Nil values are unconstrained.
+func nodeLabelValues(constraints *v1alpha4.Constraints, key string) ([]string, bool) {
+	if stable, ok := deprecatedLabels[key]; ok {
+		key = stable
+	}
+	switch key {
+	case v1.LabelTopologyZone:
+		return constraints.Zones, true
+	case v1.LabelInstanceTypeStable:
+		return constraints.InstanceTypes, true
+	case v1.LabelArchStable:
+		return constraints.Architectures, true
+	case v1.LabelOSStable:
+		return constraints.OperatingSystems, true
+	}
+	value, ok := constraints.Labels[key]
+	if !ok {
+		return nil, false
+	}
+	return []string{value}, true
+}
@@ -16,31 +16,41 @@ package scheduling_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Pallinder/go-randomdata"
+
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/registry"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation/binpacking"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/test"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	. "github.com/awslabs/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
 )
 
 var ctx context.Context
 var provisioner *v1alpha4.Provisioner
 var controller *allocation.Controller
+var cloudProvider *fake.CloudProvider
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -51,16 +61,18 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		cloudProvider := &fake.CloudProvider{}
+		cloudProvider = &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
 		controller = &allocation.Controller{
 			Filter:        &allocation.Filter{KubeClient: e.Client},
 			Binder:        &allocation.Binder{KubeClient: e.Client, CoreV1Client: corev1.NewForConfigOrDie(e.Config)},
 			Batcher:       allocation.NewBatcher(1*time.Millisecond, 1*time.Millisecond),
+			Breaker:       allocation.NewBreaker(),
 			Scheduler:     scheduling.NewScheduler(e.Client),
 			Packer:        binpacking.NewPacker(),
 			CloudProvider: cloudProvider,
 			KubeClient:    e.Client,
+			Recorder:      &record.FakeRecorder{},
 		}
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
@@ -108,6 +120,17 @@ var _ = Describe("NodeAffinity", func() {
 			}))
 			Expect(pods[0].Spec.NodeName).To(BeEmpty())
 		})
+		It("should schedule pods batched with pods that have conflicting node selectors", func() {
+			provisioner.Spec.Labels = map[string]string{"test-key": "test-value"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{"test-key": "different-value"}}),
+				test.UnschedulablePod(),
+			)
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			node := ExpectNodeExists(env.Client, pods[1].Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue("test-key", "test-value"))
+		})
 		It("should schedule pods that have matching requirements", func() {
 			provisioner.Spec.Labels = map[string]string{"test-key": "test-value"}
 			ExpectCreated(env.Client, provisioner)
@@ -186,6 +209,203 @@ var _ = Describe("NodeAffinity", func() {
 			Expect(node.Labels).To(HaveKeyWithValue("test-key", "another-value"))
 		})
 	})
+	Context("Operators", func() {
+		It("should schedule pods that require an existing label", func() {
+			provisioner.Spec.Labels = map[string]string{"test-key": "test-value"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: "test-key", Operator: v1.NodeSelectorOpExists},
+				}},
+			))
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue("test-key", "test-value"))
+		})
+		It("should not schedule pods that require a label without a value", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: "test-key", Operator: v1.NodeSelectorOpExists},
+				}},
+			))
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+		})
+		It("should schedule pods that require a label to not exist", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: "test-key", Operator: v1.NodeSelectorOpDoesNotExist},
+				}},
+			))
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).ToNot(HaveKey("test-key"))
+		})
+		It("should not schedule pods that require a provisioner label to not exist", func() {
+			provisioner.Spec.Labels = map[string]string{"test-key": "test-value"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: "test-key", Operator: v1.NodeSelectorOpDoesNotExist},
+				}},
+			))
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+		})
+		It("should schedule pods that exclude values of a label without generating it", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: "test-key", Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-value"}},
+				}},
+			))
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).ToNot(HaveKey("test-key"))
+		})
+		It("should schedule pods with numeric requirements", func() {
+			provisioner.Spec.Labels = map[string]string{"test-key": "10"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: "test-key", Operator: v1.NodeSelectorOpGt, Values: []string{"5"}},
+					{Key: "test-key", Operator: v1.NodeSelectorOpLt, Values: []string{"20"}},
+				}}),
+				test.UnschedulablePod(test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: "test-key", Operator: v1.NodeSelectorOpGt, Values: []string{"10"}},
+				}}),
+			)
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue("test-key", "10"))
+			Expect(pods[1].Spec.NodeName).To(BeEmpty())
+		})
+		It("should schedule pods that require a well known label to exist", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpExists},
+				}},
+			))
+			ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+		})
+		It("should not schedule pods that require a well known label to not exist", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpDoesNotExist},
+				}},
+			))
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+		})
+	})
+	Context("Restricted Labels", func() {
+		It("should not schedule pods that have node selectors with restricted labels", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{v1alpha4.EmptinessTimestampAnnotationKey: "2021-08-01T00:00:00Z"},
+			}))
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+		})
+		It("should not schedule pods that require restricted labels", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelHostname, Operator: v1.NodeSelectorOpIn, Values: []string{"test-node"}},
+				}},
+			))
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+		})
+		It("should schedule pods that require restricted labels to be absent", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(
+				test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: v1alpha4.EmptinessTimestampAnnotationKey, Operator: v1.NodeSelectorOpDoesNotExist},
+					{Key: v1.LabelHostname, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-node"}},
+				}},
+			))
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).ToNot(HaveKey(v1alpha4.EmptinessTimestampAnnotationKey))
+		})
+		It("should schedule pods batched with pods that have restricted labels", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelHostname: "test-node"}}),
+				test.UnschedulablePod(),
+			)
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			ExpectNodeExists(env.Client, pods[1].Spec.NodeName)
+		})
+	})
+	Context("Incompatible Constraints", func() {
+		It("should explain which constraint conflicts with the provisioner", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1"}
+			pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"}})
+			schedules, podErrs, err := controller.Scheduler.Solve(ctx, provisioner, []*v1.Pod{pod})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(schedules).To(BeEmpty())
+			Expect(podErrs).To(HaveLen(1))
+			constraintErr, ok := scheduling.AsConstraintError(podErrs[0])
+			Expect(ok).To(BeTrue())
+			Expect(constraintErr.Err.Error()).To(Equal("topology.kubernetes.io/zone: provisioner allows [test-zone-1], pod requires [test-zone-2]"))
+		})
+		It("should not report restricted labels as incompatible constraints", func() {
+			pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelHostname: "test-node"}})
+			_, podErrs, err := controller.Scheduler.Solve(ctx, provisioner, []*v1.Pod{pod})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(podErrs).To(HaveLen(1))
+			_, ok := scheduling.AsConstraintError(podErrs[0])
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("Pod Templates", func() {
+		var owner []metav1.OwnerReference
+		var labels map[string]string
+		BeforeEach(func() {
+			owner = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "test-replicaset", UID: types.UID(randomdata.Alphanumeric(10)), Controller: ptr.Bool(true)}}
+			labels = map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "test-hash"}
+		})
+		It("should schedule replicas of a template together", func() {
+			pods := []*v1.Pod{}
+			for i := 0; i < 3; i++ {
+				pods = append(pods, test.UnschedulablePod(test.PodOptions{OwnerReferences: owner, Labels: labels}))
+			}
+			schedules, podErrs, err := controller.Scheduler.Solve(ctx, provisioner, pods)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(podErrs).To(BeEmpty())
+			Expect(schedules).To(HaveLen(1))
+			Expect(schedules[0].Pods).To(ConsistOf(pods))
+		})
+		It("should separate replicas whose selectors differ", func() {
+			pods := []*v1.Pod{
+				test.UnschedulablePod(test.PodOptions{OwnerReferences: owner, Labels: labels, NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1"}}),
+				test.UnschedulablePod(test.PodOptions{OwnerReferences: owner, Labels: labels, NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"}}),
+			}
+			schedules, podErrs, err := controller.Scheduler.Solve(ctx, provisioner, pods)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(podErrs).To(BeEmpty())
+			Expect(schedules).To(HaveLen(2))
+		})
+		It("should report incompatible constraints on every replica", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1"}
+			pods := []*v1.Pod{}
+			for i := 0; i < 2; i++ {
+				pods = append(pods, test.UnschedulablePod(test.PodOptions{OwnerReferences: owner, Labels: labels, NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"}}))
+			}
+			schedules, podErrs, err := controller.Scheduler.Solve(ctx, provisioner, pods)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(schedules).To(BeEmpty())
+			Expect(podErrs).To(HaveLen(2))
+			Expect([]*v1.Pod{podErrs[0].Pod, podErrs[1].Pod}).To(ConsistOf(pods))
+		})
+		It("should schedule pods without templates individually", func() {
+			pods := []*v1.Pod{
+				test.UnschedulablePod(test.PodOptions{OwnerReferences: owner}),
+				test.UnschedulablePod(test.PodOptions{Labels: labels}),
+				test.UnschedulablePod(test.PodOptions{Labels: labels, NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1"}}),
+			}
+			schedules, podErrs, err := controller.Scheduler.Solve(ctx, provisioner, pods)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(podErrs).To(BeEmpty())
+			Expect(schedules).To(HaveLen(2))
+		})
+	})
 	Context("Well Known Labels", func() {
 		It("should use provisioner constraints", func() {
 			provisioner.Spec.Zones = []string{"test-zone-2"}
@@ -194,6 +414,14 @@ var _ = Describe("NodeAffinity", func() {
 			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
 		})
+		It("should not schedule to unhealthy zones", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1", "test-zone-2"}
+			provisioner.Annotations = map[string]string{v1alpha4.UnhealthyZonesAnnotationKey: "test-zone-1"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+		})
 		It("should use node selectors", func() {
 			provisioner.Spec.Zones = []string{"test-zone-1", "test-zone-2"}
 			ExpectCreated(env.Client, provisioner)
@@ -266,7 +494,7 @@ var _ = Describe("NodeAffinity", func() {
 			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
 		})
-		It("should not schedule incompatible preferences and requirements with Operator=In", func() {
+		It("should ignore incompatible preferences and schedule requirements with Operator=In", func() {
 			ExpectCreated(env.Client, provisioner)
 			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
 				test.UnschedulablePod(test.PodOptions{
@@ -275,7 +503,7 @@ var _ = Describe("NodeAffinity", func() {
 					NodePreferences: []v1.NodeSelectorRequirement{
 						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown"}}},
 				}))
-			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
 		})
 		It("should schedule compatible preferences and requirements with Operator=NotIn", func() {
 			ExpectCreated(env.Client, provisioner)
@@ -289,7 +517,7 @@ var _ = Describe("NodeAffinity", func() {
 			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
 		})
-		It("should not schedule incompatible preferences and requirements with Operator=NotIn", func() {
+		It("should ignore incompatible preferences and schedule requirements with Operator=NotIn", func() {
 			ExpectCreated(env.Client, provisioner)
 			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
 				test.UnschedulablePod(test.PodOptions{
@@ -298,7 +526,7 @@ var _ = Describe("NodeAffinity", func() {
 					NodePreferences: []v1.NodeSelectorRequirement{
 						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}}},
 				}))
-			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
 		})
 		It("should schedule compatible node selectors, preferences and requirements", func() {
 			ExpectCreated(env.Client, provisioner)
@@ -313,7 +541,7 @@ var _ = Describe("NodeAffinity", func() {
 			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
 		})
-		It("should not schedule incompatible node selectors, preferences and requirements", func() {
+		It("should ignore incompatible preferences and schedule node selectors and requirements", func() {
 			ExpectCreated(env.Client, provisioner)
 			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
 				test.UnschedulablePod(test.PodOptions{
@@ -323,7 +551,8 @@ var _ = Describe("NodeAffinity", func() {
 					NodePreferences: []v1.NodeSelectorRequirement{
 						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-2", "test-zone-3"}}},
 				}))
-			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
 		})
 		It("should combine multidimensional node selectors, preferences and requirements", func() {
 			ExpectCreated(env.Client, provisioner)
@@ -366,61 +595,83 @@ var _ = Describe("NodeAffinity", func() {
 			Expect(pods[0].Spec.NodeName).To(BeEmpty())
 		})
 	})
-})
-
-var _ = Describe("Preferential Fallback", func() {
-	Context("Required", func() {
-		It("should not relax the final term", func() {
-			provisioner.Spec.Zones = []string{"test-zone-1"}
-			provisioner.Spec.InstanceTypes = []string{"default-instance-type"}
+	Context("Multiple Terms", func() {
+		It("should schedule to the first satisfiable term", func() {
+			ExpectCreated(env.Client, provisioner)
 			pod := test.UnschedulablePod()
 			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
 				{MatchExpressions: []v1.NodeSelectorRequirement{
-					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}}, // Should not be relaxed
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
+				}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
+				}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+				}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}, // OR operator, never get to this one
 				}},
 			}}}}
-			ExpectCreated(env.Client, provisioner)
-			ExpectCreatedWithStatus(env.Client, pod)
-			// Don't relax
-			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
-			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
-			Expect(pod.Spec.NodeName).To(BeEmpty())
-			// Don't relax
-			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
-			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
-			Expect(pod.Spec.NodeName).To(BeEmpty())
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, pod)
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
 		})
-		It("should relax multiple terms", func() {
+		It("should schedule to a term with multiple requirements", func() {
+			ExpectCreated(env.Client, provisioner)
 			pod := test.UnschedulablePod()
 			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
 				{MatchExpressions: []v1.NodeSelectorRequirement{
 					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
 				}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}},
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"arm-instance-type"}},
+				}},
+			}}}}
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, pod)
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "arm-instance-type"))
+		})
+		It("should not schedule if no term is satisfiable", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := test.UnschedulablePod()
+			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
 				{MatchExpressions: []v1.NodeSelectorRequirement{
 					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
 				}},
 				{MatchExpressions: []v1.NodeSelectorRequirement{
-					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown"}},
 				}},
+			}}}}
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, pod)
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Preferential Fallback", func() {
+	Context("Required", func() {
+		It("should not relax the final term", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1"}
+			provisioner.Spec.InstanceTypes = []string{"default-instance-type"}
+			pod := test.UnschedulablePod()
+			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
 				{MatchExpressions: []v1.NodeSelectorRequirement{
-					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}, // OR operator, never get to this one
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}}, // Should not be relaxed
 				}},
 			}}}}
 			ExpectCreated(env.Client, provisioner)
 			ExpectCreatedWithStatus(env.Client, pod)
-			// Remove first term
+			// Don't relax
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
 			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
 			Expect(pod.Spec.NodeName).To(BeEmpty())
-			// Remove second term
+			// Don't relax
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
 			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
 			Expect(pod.Spec.NodeName).To(BeEmpty())
-			// Success
-			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
-			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
-			node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
-			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
 		})
 	})
 	Context("Preferred", func() {
@@ -429,7 +680,7 @@ var _ = Describe("Preferential Fallback", func() {
 			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
 				{
 					Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
-						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
+						{Key: v1.LabelOSStable, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
 					}},
 				},
 				{
@@ -452,6 +703,9 @@ var _ = Describe("Preferential Fallback", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
 			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
 			ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha4.RelaxedPreferencesAnnotationKey,
+				strings.Join([]string{v1.LabelOSStable, v1.LabelInstanceTypeStable}, ","),
+			))
 		})
 		It("should relax to use lighter weights", func() {
 			provisioner.Spec.Zones = []string{"test-zone-1", "test-zone-2"}
@@ -486,6 +740,158 @@ var _ = Describe("Preferential Fallback", func() {
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
 		})
 	})
+	Context("Scored Preferences", func() {
+		It("should prefer the zone with the highest combined weight", func() {
+			pod := test.UnschedulablePod()
+			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
+				{
+					Weight: 10, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+					}},
+				},
+				{
+					Weight: 6, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2", "test-zone-3"}},
+					}},
+				},
+				{
+					Weight: 5, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1", "test-zone-3"}},
+					}},
+				},
+			}}}
+			ExpectCreated(env.Client, provisioner)
+			pod = ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, pod)[0]
+			node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+		})
+		It("should prefer architectures", func() {
+			pod := test.UnschedulablePod(test.PodOptions{NodePreferences: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha4.ArchitectureArm64}},
+			}})
+			ExpectCreated(env.Client, provisioner)
+			pod = ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, pod)[0]
+			node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelArchStable, v1alpha4.ArchitectureArm64))
+		})
+		It("should fall back to other zones without relaxing", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1", "test-zone-2"}
+			pod := test.UnschedulablePod(test.PodOptions{NodePreferences: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}},
+			}})
+			ExpectCreated(env.Client, provisioner)
+			pod = ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, pod)[0]
+			node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, BeElementOf("test-zone-1", "test-zone-2")))
+			Expect(pod.Annotations).ToNot(HaveKey(v1alpha4.RelaxedPreferencesAnnotationKey))
+		})
+		It("should fall back to other zones if the preferred zone fails to launch", func() {
+			cloudProvider.InsufficientCapacityZones = []string{"test-zone-1"}
+			defer func() {
+				cloudProvider.InsufficientCapacityZones = nil
+				controller.Breaker = allocation.NewBreaker()
+			}()
+			provisioner.Spec.Zones = []string{"test-zone-1", "test-zone-2"}
+			pod := test.UnschedulablePod(test.PodOptions{NodePreferences: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+			}})
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, pod)
+			// Launch in the preferred zone fails
+			_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(provisioner)})
+			Expect(err).To(HaveOccurred())
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			Expect(pod.Spec.NodeName).To(BeEmpty())
+			// Remove scored term
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha4.RelaxedPreferencesAnnotationKey, v1.LabelTopologyZone))
+		})
+	})
+	Context("Pod Affinity", func() {
+		It("should relax preferred pod affinity", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1"}
+			labels := map[string]string{"test": "test"}
+			node := test.Node(test.NodeOptions{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-2"}})
+			ExpectCreated(env.Client, provisioner, node)
+			ExpectCreatedWithStatus(env.Client, test.Pod(test.PodOptions{Labels: labels, NodeName: node.Name}))
+			pod := test.UnschedulablePod(test.PodOptions{PodPreferences: []v1.WeightedPodAffinityTerm{{
+				Weight:          1,
+				PodAffinityTerm: v1.PodAffinityTerm{TopologyKey: v1.LabelTopologyZone, LabelSelector: &metav1.LabelSelector{MatchLabels: labels}},
+			}}})
+			ExpectCreatedWithStatus(env.Client, pod)
+			// Remove term
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			Expect(pod.Spec.NodeName).To(BeEmpty())
+			// Success
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			node = ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha4.RelaxedPreferencesAnnotationKey, "podAffinity:"+v1.LabelTopologyZone))
+		})
+		It("should relax preferred pod anti-affinity", func() {
+			provisioner.Spec.Zones = []string{"test-zone-1"}
+			labels := map[string]string{"test": "test"}
+			node := test.Node(test.NodeOptions{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-1"}})
+			ExpectCreated(env.Client, provisioner, node)
+			ExpectCreatedWithStatus(env.Client, test.Pod(test.PodOptions{Labels: labels, NodeName: node.Name}))
+			pod := test.UnschedulablePod(test.PodOptions{PodAntiPreferences: []v1.WeightedPodAffinityTerm{{
+				Weight:          1,
+				PodAffinityTerm: v1.PodAffinityTerm{TopologyKey: v1.LabelTopologyZone, LabelSelector: &metav1.LabelSelector{MatchLabels: labels}},
+			}}})
+			ExpectCreatedWithStatus(env.Client, pod)
+			// Remove term
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			Expect(pod.Spec.NodeName).To(BeEmpty())
+			// Success
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha4.RelaxedPreferencesAnnotationKey, "podAntiAffinity:"+v1.LabelTopologyZone))
+		})
+	})
+	Context("Topology", func() {
+		It("should relax topology spread constraints with ScheduleAnyway", func() {
+			pod := test.UnschedulablePod(test.PodOptions{TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				TopologyKey:       "unknown",
+				WhenUnsatisfiable: v1.ScheduleAnyway,
+				MaxSkew:           1,
+			}}})
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, pod)
+			// Remove constraint
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			Expect(pod.Spec.NodeName).To(BeEmpty())
+			// Success
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha4.RelaxedPreferencesAnnotationKey, "topologySpreadConstraints:unknown"))
+		})
+		It("should not relax topology spread constraints with DoNotSchedule", func() {
+			pod := test.UnschedulablePod(test.PodOptions{TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				TopologyKey:       "unknown",
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				MaxSkew:           1,
+			}}})
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, pod)
+			// Don't relax
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			Expect(pod.Spec.NodeName).To(BeEmpty())
+			// Don't relax
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+			Expect(pod.Spec.NodeName).To(BeEmpty())
+		})
+	})
 })
 
 var _ = Describe("Topology", func() {
@@ -538,6 +944,27 @@ var _ = Describe("Topology", func() {
 			)
 			ExpectSkew(env.Client, v1.LabelTopologyZone).To(ConsistOf(2, 2))
 		})
+		It("should balance pods across the zones of any required term", func() {
+			ExpectCreated(env.Client, provisioner)
+			topology := []v1.TopologySpreadConstraint{{
+				TopologyKey:       v1.LabelTopologyZone,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+				MaxSkew:           1,
+			}}
+			affinity := &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}}},
+			}}}}
+			pods := []*v1.Pod{}
+			for i := 0; i < 4; i++ {
+				pod := test.UnschedulablePod(test.PodOptions{Labels: labels, TopologySpreadConstraints: topology})
+				pod.Spec.Affinity = affinity.DeepCopy()
+				pods = append(pods, pod)
+			}
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, pods...)
+			ExpectSkew(env.Client, v1.LabelTopologyZone).To(ConsistOf(2, 2))
+		})
 		It("should only count scheduled pods with matching labels scheduled to nodes with a corresponding domain", func() {
 			firstNode := test.Node(test.NodeOptions{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-1"}})
 			secondNode := test.Node(test.NodeOptions{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-2"}})
@@ -641,6 +1068,216 @@ var _ = Describe("Topology", func() {
 	})
 })
 
+var _ = Describe("Volume Topology", func() {
+	It("should schedule pods to the zone of their bound volumes", func() {
+		pv := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-2"}})
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: pv.Name})
+		ExpectCreated(env.Client, provisioner, pv, pvc)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+			test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{pvc.Name}}),
+		)
+		node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+	})
+	It("should schedule pods to the allowed topologies of their storage class", func() {
+		storageClass := test.StorageClass(test.StorageClassOptions{Zones: []string{"test-zone-3"}})
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &storageClass.Name})
+		ExpectCreated(env.Client, provisioner, storageClass, pvc)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+			test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{pvc.Name}}),
+		)
+		node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
+	})
+	It("should respect the pod's node affinity alongside its volumes", func() {
+		storageClass := test.StorageClass(test.StorageClassOptions{Zones: []string{"test-zone-1", "test-zone-2"}})
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &storageClass.Name})
+		ExpectCreated(env.Client, provisioner, storageClass, pvc)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+			test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: []string{pvc.Name},
+				NodeRequirements:       []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2", "test-zone-3"}}},
+			}),
+		)
+		node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+	})
+	It("should not modify the pod's affinity", func() {
+		pv := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-2"}})
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: pv.Name})
+		ExpectCreated(env.Client, pv, pvc)
+		pod := test.UnschedulablePod(test.PodOptions{
+			PersistentVolumeClaims: []string{pvc.Name},
+			NodeRequirements:       []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2", "test-zone-3"}}},
+		})
+		affinity := pod.Spec.Affinity
+		expected := affinity.DeepCopy()
+		Expect(scheduling.NewScheduler(env.Client).VolumeTopology.Inject(ctx, []*v1.Pod{pod})).To(BeEmpty())
+		Expect(affinity).To(Equal(expected))
+		Expect(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions).To(HaveLen(2))
+	})
+	It("should not schedule pods whose volumes are in zones the provisioner doesn't allow", func() {
+		provisioner.Spec.Constraints.Zones = []string{"test-zone-1"}
+		pv := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-2"}})
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: pv.Name})
+		ExpectCreated(env.Client, provisioner, pv, pvc)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+			test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{pvc.Name}}),
+		)
+		Expect(pods[0].Spec.NodeName).To(BeEmpty())
+	})
+	It("should not schedule pods whose volumes are in conflicting zones", func() {
+		first := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-1"}})
+		second := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-2"}})
+		firstClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: first.Name})
+		secondClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: second.Name})
+		ExpectCreated(env.Client, provisioner, first, second, firstClaim, secondClaim)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+			test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{firstClaim.Name, secondClaim.Name}}),
+		)
+		Expect(pods[0].Spec.NodeName).To(BeEmpty())
+	})
+	It("should not schedule pods whose claims don't exist", func() {
+		ExpectCreated(env.Client, provisioner)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+			test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{"unknown"}}),
+		)
+		Expect(pods[0].Spec.NodeName).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Pod Affinity", func() {
+	labels := map[string]string{"test": "test"}
+	term := func(topologyKey string, matchLabels map[string]string) []v1.PodAffinityTerm {
+		return []v1.PodAffinityTerm{{TopologyKey: topologyKey, LabelSelector: &metav1.LabelSelector{MatchLabels: matchLabels}}}
+	}
+
+	Context("Hostname", func() {
+		It("should separate pods with anti-affinity onto different nodes", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				MakePods(3, test.PodOptions{Labels: labels, PodAntiRequirements: term(v1.LabelHostname, labels)})...,
+			)
+			ExpectSkew(env.Client, v1.LabelHostname).To(ConsistOf(1, 1, 1))
+		})
+		It("should separate pods from pods selected by their anti-affinity", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{Labels: labels}),
+				test.UnschedulablePod(test.PodOptions{PodAntiRequirements: term(v1.LabelHostname, labels)}),
+			)
+			ExpectSkew(env.Client, v1.LabelHostname).To(ConsistOf(1, 1))
+		})
+		It("should colocate pods with affinity", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				MakePods(3, test.PodOptions{Labels: labels, PodRequirements: term(v1.LabelHostname, labels)})...,
+			)
+			ExpectSkew(env.Client, v1.LabelHostname).To(ConsistOf(3))
+		})
+		It("should not schedule pods with affinity for pods that can't share a new node", func() {
+			node := test.Node(test.NodeOptions{})
+			ExpectCreated(env.Client, provisioner, node)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.Pod(test.PodOptions{Labels: labels, NodeName: node.Name}),
+				test.UnschedulablePod(test.PodOptions{PodRequirements: term(v1.LabelHostname, labels)}),
+			)
+			Expect(pods[1].Spec.NodeName).To(BeEmpty())
+		})
+	})
+
+	Context("Zonal", func() {
+		It("should separate pods with anti-affinity into different zones", func() {
+			provisioner.Spec.Constraints.Zones = []string{"test-zone-1", "test-zone-2"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				MakePods(3, test.PodOptions{Labels: labels, PodAntiRequirements: term(v1.LabelTopologyZone, labels)})...,
+			)
+			ExpectSkew(env.Client, v1.LabelTopologyZone).To(ConsistOf(1, 1))
+			unscheduled := 0
+			for _, pod := range pods {
+				if pod.Spec.NodeName == "" {
+					unscheduled++
+				}
+			}
+			Expect(unscheduled).To(Equal(1))
+		})
+		It("should avoid zones with existing pods selected by anti-affinity", func() {
+			node := test.Node(test.NodeOptions{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-1"}})
+			ExpectCreated(env.Client, provisioner, node)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.Pod(test.PodOptions{Labels: labels, NodeName: node.Name}),
+				test.UnschedulablePod(test.PodOptions{PodAntiRequirements: term(v1.LabelTopologyZone, labels)}),
+			)
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(2))
+			for _, n := range nodes.Items {
+				if n.Name != node.Name {
+					Expect(n.Labels[v1.LabelTopologyZone]).ToNot(Equal("test-zone-1"))
+				}
+			}
+		})
+		It("should schedule pods with affinity into zones with existing pods", func() {
+			node := test.Node(test.NodeOptions{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-2"}})
+			ExpectCreated(env.Client, provisioner, node)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.Pod(test.PodOptions{Labels: labels, NodeName: node.Name}),
+				test.UnschedulablePod(test.PodOptions{PodRequirements: term(v1.LabelTopologyZone, labels)}),
+			)
+			ExpectSkew(env.Client, v1.LabelTopologyZone).To(ConsistOf(2))
+		})
+		It("should not schedule pods with affinity for zones outside of the provisioner's constraints", func() {
+			provisioner.Spec.Constraints.Zones = []string{"test-zone-1"}
+			node := test.Node(test.NodeOptions{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-2"}})
+			ExpectCreated(env.Client, provisioner, node)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.Pod(test.PodOptions{Labels: labels, NodeName: node.Name}),
+				test.UnschedulablePod(test.PodOptions{PodRequirements: term(v1.LabelTopologyZone, labels)}),
+			)
+			Expect(pods[1].Spec.NodeName).To(BeEmpty())
+		})
+	})
+
+	It("should not schedule pods with unsupported topology keys", func() {
+		ExpectCreated(env.Client, provisioner)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+			test.UnschedulablePod(test.PodOptions{Labels: labels, PodAntiRequirements: term("unknown", labels)}),
+		)
+		Expect(pods[0].Spec.NodeName).To(BeEmpty())
+	})
+
+	Context("Preferred", func() {
+		preference := func(weight int32, topologyKey string, matchLabels map[string]string) v1.WeightedPodAffinityTerm {
+			return v1.WeightedPodAffinityTerm{Weight: weight, PodAffinityTerm: term(topologyKey, matchLabels)[0]}
+		}
+		It("should separate pods with preferred anti-affinity onto different nodes", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				MakePods(3, test.PodOptions{Labels: labels, PodAntiPreferences: []v1.WeightedPodAffinityTerm{preference(1, v1.LabelHostname, labels)}})...,
+			)
+			ExpectSkew(env.Client, v1.LabelHostname).To(ConsistOf(1, 1, 1))
+		})
+		It("should only honor the heaviest preferred affinity", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				MakePods(3, test.PodOptions{Labels: labels, PodPreferences: []v1.WeightedPodAffinityTerm{
+					preference(1, v1.LabelHostname, map[string]string{"other": "other"}),
+					preference(100, v1.LabelHostname, labels),
+				}})...,
+			)
+			ExpectSkew(env.Client, v1.LabelHostname).To(ConsistOf(3))
+		})
+		It("should ignore preferences with unsupported topology keys", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{Labels: labels, PodAntiPreferences: []v1.WeightedPodAffinityTerm{preference(1, "unknown", labels)}}),
+			)
+			ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+		})
+	})
+})
+
 var _ = Describe("Taints", func() {
 	It("should schedule pods that tolerate provisioner constraints", func() {
 		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
@@ -744,6 +1381,88 @@ var _ = Describe("Taints", func() {
 	})
 })
 
+var _ = Describe("Provisioning Deadlines", func() {
+	invalidPreferences := func() *v1.Affinity {
+		return &v1.Affinity{NodeAffinity: &v1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
+			{Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelOSStable, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
+			}}},
+			{Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}},
+			}}},
+		}}}
+	}
+	It("should remove every preference of pods past their provisioning deadline", func() {
+		pod := test.UnschedulablePod(test.PodOptions{Annotations: map[string]string{v1alpha4.ProvisioningDeadlineAnnotationKey: "0s"}})
+		pod.Spec.Affinity = invalidPreferences()
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, pod)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+		ExpectNodeExists(env.Client, pod.Spec.NodeName)
+		Expect(pod.Annotations).To(HaveKey(v1alpha4.EscalatedAnnotationKey))
+	})
+	It("should not escalate pods before their provisioning deadline", func() {
+		pod := test.UnschedulablePod(test.PodOptions{Annotations: map[string]string{v1alpha4.ProvisioningDeadlineAnnotationKey: "1h"}})
+		pod.Spec.Affinity = invalidPreferences()
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, pod)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+		Expect(pod.Spec.NodeName).To(BeEmpty())
+		Expect(pod.Annotations).ToNot(HaveKey(v1alpha4.EscalatedAnnotationKey))
+	})
+	It("should ignore invalid provisioning deadlines", func() {
+		pod := test.UnschedulablePod(test.PodOptions{Annotations: map[string]string{v1alpha4.ProvisioningDeadlineAnnotationKey: "soon"}})
+		pod.Spec.Affinity = invalidPreferences()
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, pod)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		pod = ExpectPodExists(env.Client, pod.Name, pod.Namespace)
+		Expect(pod.Spec.NodeName).To(BeEmpty())
+		Expect(pod.Annotations).ToNot(HaveKey(v1alpha4.EscalatedAnnotationKey))
+	})
+	It("should not widen the required constraints of pods past their provisioning deadline", func() {
+		ExpectCreated(env.Client, provisioner)
+		pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+			Annotations:  map[string]string{v1alpha4.ProvisioningDeadlineAnnotationKey: "0s"},
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown"},
+		}))
+		Expect(pods[0].Spec.NodeName).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Metrics", func() {
+	It("should record the scheduling duration of the provisioner", func() {
+		provisioner.Name = strings.ToLower(randomdata.SillyName())
+		ExpectCreated(env.Client, provisioner)
+		ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+		ExpectMetricHistogramSampleCountValue("karpenter_allocation_controller_scheduling_duration_seconds", 1, map[string]string{
+			metrics.ProvisionerLabel: provisioner.Name,
+			metrics.ResultLabel:      "success",
+		})
+	})
+	It("should count relaxed preferences", func() {
+		relaxations := func() float64 {
+			metric, ok := FindMetricWithLabelValues("karpenter_allocation_controller_relaxations_total", map[string]string{"key": "topologySpreadConstraints:unknown"})
+			if !ok {
+				return 0
+			}
+			return metric.GetCounter().GetValue()
+		}
+		before := relaxations()
+		pod := test.UnschedulablePod(test.PodOptions{TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+			TopologyKey:       "unknown",
+			WhenUnsatisfiable: v1.ScheduleAnyway,
+			MaxSkew:           1,
+		}}})
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, pod)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(relaxations()).To(Equal(before + 1))
+	})
+})
+
 func MakePods(count int, options test.PodOptions) (pods []*v1.Pod) {
 	for i := 0; i < count; i++ {
 		pods = append(pods, test.UnschedulablePod(options))
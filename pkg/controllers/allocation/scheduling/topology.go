@@ -35,14 +35,19 @@ type Topology struct {
 	kubeClient client.Client
 }
 
-// Inject injects topology rules into pods using supported NodeSelectors
-func (t *Topology) Inject(ctx context.Context, constraints *v1alpha4.Constraints, pods []*v1.Pod) error {
+// Inject injects topology rules into pods using supported NodeSelectors.
+// Returns errors for pods whose topology couldn't be computed.
+func (t *Topology) Inject(ctx context.Context, constraints *v1alpha4.Constraints, pods []*v1.Pod) []*PodError {
+	podErrs := []*PodError{}
 	// 1. Group pods by equivalent topology spread constraints
 	topologyGroups := t.getTopologyGroups(pods)
 	// 2. Compute spread
 	for _, topologyGroup := range topologyGroups {
 		if err := t.computeCurrentTopology(ctx, constraints, topologyGroup); err != nil {
-			return fmt.Errorf("computing topology, %w", err)
+			for _, pod := range topologyGroup.Pods {
+				podErrs = append(podErrs, &PodError{Pod: pod, Err: fmt.Errorf("computing topology, %w", err)})
+			}
+			continue
 		}
 		for _, pod := range topologyGroup.Pods {
 			pod.Spec.NodeSelector = functional.UnionStringMaps(
@@ -51,7 +56,7 @@ func (t *Topology) Inject(ctx context.Context, constraints *v1alpha4.Constraints
 			)
 		}
 	}
-	return nil
+	return podErrs
 }
 
 // getTopologyGroups separates pods with equivalent topology rules
@@ -82,6 +87,9 @@ func (t *Topology) computeCurrentTopology(ctx context.Context, constraints *v1al
 	case v1.LabelTopologyZone:
 		return t.computeZonalTopology(ctx, constraints, topologyGroup)
 	default:
+		if len(v1alpha4.WellKnownLabels.Values(topologyGroup.Constraint.TopologyKey)) > 0 {
+			return t.computeWellKnownTopology(ctx, constraints, topologyGroup)
+		}
 		return nil
 	}
 }
@@ -107,7 +115,32 @@ func (t *Topology) computeHostnameTopology(topologyGroup *TopologyGroup) error {
 // selection. For example, if a cloud provider or provisioner changes the viable
 // set of nodes, topology calculations will rebalance the new set of zones.
 func (t *Topology) computeZonalTopology(ctx context.Context, constraints *v1alpha4.Constraints, topologyGroup *TopologyGroup) error {
-	topologyGroup.Register(scheduling.NodeAffinityFor(topologyGroup.Pods[0]).GetLabelValues(v1.LabelTopologyZone, constraints.Zones)...)
+	topologyGroup.Register(scheduling.LabelValuesFor(topologyGroup.Pods[0], v1.LabelTopologyZone, constraints.Zones)...)
+	if err := t.countMatchingPods(ctx, topologyGroup); err != nil {
+		return fmt.Errorf("getting matching pods, %w", err)
+	}
+	return nil
+}
+
+// computeWellKnownTopology for the topology group. Well known labels
+// registered by the cloud provider (e.g. karpenter.sh/capacity-type) aren't
+// modeled by the provisioner's constraints, so each value the pod allows is
+// checked against the { cloudprovider, provisioner, pod } constraints by
+// constraining a copy of the pod that selects it. Values that conflict are not
+// viable domains.
+func (t *Topology) computeWellKnownTopology(ctx context.Context, constraints *v1alpha4.Constraints, topologyGroup *TopologyGroup) error {
+	key := topologyGroup.Constraint.TopologyKey
+	for _, value := range scheduling.LabelValuesFor(topologyGroup.Pods[0], key, v1alpha4.WellKnownLabels.Values(key)) {
+		pod := topologyGroup.Pods[0].DeepCopy()
+		pod.Spec.NodeSelector = functional.UnionStringMaps(pod.Spec.NodeSelector, map[string]string{key: value})
+		if err := constraints.DeepCopy().Constrain(ctx, pod); err != nil {
+			continue
+		}
+		topologyGroup.Register(value)
+	}
+	if len(topologyGroup.spread) == 0 {
+		return fmt.Errorf("no viable values for %s", key)
+	}
 	if err := t.countMatchingPods(ctx, topologyGroup); err != nil {
 		return fmt.Errorf("getting matching pods, %w", err)
 	}
@@ -0,0 +1,165 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VolumeZoneLabels are the labels that volume provisioners use to restrict
+// volumes to a zone, which are equivalent to the well known zone label.
+var VolumeZoneLabels = []string{
+	v1.LabelTopologyZone,
+	v1.LabelFailureDomainBetaZone,
+	"topology.ebs.csi.aws.com/zone",
+}
+
+type VolumeTopology struct {
+	kubeClient client.Client
+}
+
+// Inject injects the zones of pods' persistent volumes into the pods'
+// required node affinity. Bound volumes restrict pods to the volume's zones,
+// and unbound volumes restrict pods to the allowed topologies of their
+// storage class. Returns errors for pods whose volumes couldn't be resolved.
+func (v *VolumeTopology) Inject(ctx context.Context, pods []*v1.Pod) []*PodError {
+	podErrs := []*PodError{}
+	for _, pod := range pods {
+		zones, err := v.getZones(ctx, pod)
+		if err != nil {
+			podErrs = append(podErrs, &PodError{Pod: pod, Err: fmt.Errorf("computing volume topology, %w", err)})
+			continue
+		}
+		if zones == nil {
+			continue
+		}
+		injectRequirement(pod, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: zones})
+	}
+	return podErrs
+}
+
+// getZones returns the zones that satisfy all of the pod's volumes, or nil if
+// the volumes aren't zonal
+func (v *VolumeTopology) getZones(ctx context.Context, pod *v1.Pod) ([]string, error) {
+	var result []string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		zones, err := v.getVolumeZones(ctx, pod.Namespace, volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return nil, err
+		}
+		if zones == nil {
+			continue
+		}
+		if result == nil {
+			result = zones
+		} else {
+			result = functional.IntersectStringSlice(result, zones)
+		}
+		if len(result) == 0 {
+			return nil, fmt.Errorf("volumes are in conflicting zones")
+		}
+	}
+	return result, nil
+}
+
+func (v *VolumeTopology) getVolumeZones(ctx context.Context, namespace string, claimName string) ([]string, error) {
+	pvc := &v1.PersistentVolumeClaim{}
+	if err := v.kubeClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claimName}, pvc); err != nil {
+		return nil, fmt.Errorf("getting persistent volume claim %s, %w", claimName, err)
+	}
+	if pvc.Spec.VolumeName != "" {
+		pv := &v1.PersistentVolume{}
+		if err := v.kubeClient.Get(ctx, types.NamespacedName{Name: pvc.Spec.VolumeName}, pv); err != nil {
+			return nil, fmt.Errorf("getting persistent volume %s, %w", pvc.Spec.VolumeName, err)
+		}
+		return persistentVolumeZones(pv), nil
+	}
+	storageClassName := pvc.Spec.StorageClassName
+	if storageClassName == nil || *storageClassName == "" {
+		return nil, nil
+	}
+	storageClass := &storagev1.StorageClass{}
+	if err := v.kubeClient.Get(ctx, types.NamespacedName{Name: *storageClassName}, storageClass); err != nil {
+		return nil, fmt.Errorf("getting storage class %s, %w", *storageClassName, err)
+	}
+	return storageClassZones(storageClass), nil
+}
+
+// persistentVolumeZones returns the zones of the first node selector term
+// that restricts zones, consistent with how pod node affinity is scheduled
+func persistentVolumeZones(pv *v1.PersistentVolume) []string {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return nil
+	}
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		var zones []string
+		for _, requirement := range term.MatchExpressions {
+			if functional.ContainsString(VolumeZoneLabels, requirement.Key) && requirement.Operator == v1.NodeSelectorOpIn {
+				zones = append(zones, requirement.Values...)
+			}
+		}
+		if len(zones) > 0 {
+			return zones
+		}
+	}
+	return nil
+}
+
+func storageClassZones(storageClass *storagev1.StorageClass) []string {
+	var zones []string
+	for _, term := range storageClass.AllowedTopologies {
+		for _, requirement := range term.MatchLabelExpressions {
+			if functional.ContainsString(VolumeZoneLabels, requirement.Key) {
+				zones = append(zones, requirement.Values...)
+			}
+		}
+	}
+	return zones
+}
+
+// injectRequirement adds the requirement to each of the pod's required node
+// selector terms, so that it applies regardless of the term that's selected.
+// The pod's affinity may be shared with the relaxation cache, so it's copied
+// rather than modified.
+func injectRequirement(pod *v1.Pod, requirement v1.NodeSelectorRequirement) {
+	affinity := &v1.Affinity{}
+	if pod.Spec.Affinity != nil {
+		affinity = pod.Spec.Affinity.DeepCopy()
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &v1.NodeAffinity{}
+	}
+	if affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{}
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []v1.NodeSelectorTerm{{}}
+	}
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchExpressions = append(required.NodeSelectorTerms[i].MatchExpressions, requirement)
+	}
+	pod.Spec.Affinity = affinity
+}
@@ -16,10 +16,12 @@ package allocation_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
 	"github.com/awslabs/karpenter/pkg/cloudprovider/registry"
 	"github.com/awslabs/karpenter/pkg/controllers/allocation"
@@ -30,19 +32,25 @@ import (
 	"github.com/awslabs/karpenter/pkg/utils/resources"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	. "github.com/awslabs/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 var ctx context.Context
 var controller *allocation.Controller
+var cloudProvider *fake.CloudProvider
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -53,21 +61,42 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		cloudProvider := &fake.CloudProvider{}
+		cloudProvider = &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
 		controller = &allocation.Controller{
 			Filter:        &allocation.Filter{KubeClient: e.Client},
 			Binder:        &allocation.Binder{KubeClient: e.Client, CoreV1Client: corev1.NewForConfigOrDie(e.Config)},
 			Batcher:       allocation.NewBatcher(1*time.Millisecond, 1*time.Millisecond),
+			Breaker:       allocation.NewBreaker(),
 			Scheduler:     scheduling.NewScheduler(e.Client),
 			Packer:        binpacking.NewPacker(),
 			CloudProvider: cloudProvider,
 			KubeClient:    e.Client,
+			Recorder:      &record.FakeRecorder{},
+			Translators:   []resources.Translator{resources.TranslatorFunc(translateLegacyGPUs)},
 		}
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
 
+// legacyGPU is a vendor specific resource name translated to nvidia GPUs
+const legacyGPU = "example.com/legacy-gpu"
+
+func translateLegacyGPUs(_ context.Context, pod *v1.Pod) error {
+	for i := range pod.Spec.Containers {
+		for _, list := range []v1.ResourceList{pod.Spec.Containers[i].Resources.Requests, pod.Spec.Containers[i].Resources.Limits} {
+			if quantity, ok := list[legacyGPU]; ok {
+				if quantity.Value() > 8 {
+					return fmt.Errorf("%s is limited to 8, requested %s", legacyGPU, quantity.String())
+				}
+				list[resources.NvidiaGPU] = quantity
+				delete(list, legacyGPU)
+			}
+		}
+	}
+	return nil
+}
+
 var _ = AfterSuite(func() {
 	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
 })
@@ -100,6 +129,37 @@ var _ = Describe("Allocation", func() {
 				Expect(pod.Spec.NodeName).To(Equal(nodes.Items[0].Name))
 			}
 		})
+		It("should choose a single architecture when multiple are allowed", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}),
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}),
+			)
+			for _, pod := range pods {
+				node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+				Expect(node.Status.NodeInfo.Architecture).To(Equal(v1alpha4.ArchitectureAmd64))
+			}
+		})
+		It("should choose the cheapest architecture that pods select", func() {
+			cloudProvider.Prices = map[string]float64{"arm-instance-type": 0.05}
+			defer func() { cloudProvider.Prices = nil }()
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha4.ArchitectureAmd64, v1alpha4.ArchitectureArm64}},
+				}}),
+			)
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Status.NodeInfo.Architecture).To(Equal(v1alpha4.ArchitectureArm64))
+		})
+		It("should choose amd64 for pods that don't select an architecture", func() {
+			cloudProvider.Prices = map[string]float64{"arm-instance-type": 0.05}
+			defer func() { cloudProvider.Prices = nil }()
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Status.NodeInfo.Architecture).To(Equal(v1alpha4.ArchitectureAmd64))
+		})
 		It("should provision nodes for pods with supported node selectors", func() {
 			schedulable := []client.Object{
 				// Constrained by provisioner
@@ -161,9 +221,155 @@ var _ = Describe("Allocation", func() {
 			Expect(env.Client.List(ctx, nodes)).To(Succeed())
 			Expect(len(nodes.Items)).To(Equal(3))
 			for _, pod := range pods {
-				scheduled := ExpectPodExists(env.Client, pod.GetName(), pod.GetNamespace())
-				ExpectNodeExists(env.Client, scheduled.Spec.NodeName)
+				nominated := ExpectPodExists(env.Client, pod.GetName(), pod.GetNamespace())
+				Expect(nominated.Spec.NodeName).To(BeEmpty())
+				node := ExpectNodeExists(env.Client, nominated.Status.NominatedNodeName)
+				Expect(node.Annotations).To(HaveKey(v1alpha4.ExtendedResourcesAnnotationKey))
+			}
+		})
+		It("should provision nodes for extended resources of other device plugins", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{"habana.ai/gaudi": resource.MustParse("4")}},
+				}),
+			)[0]
+			node := ExpectNodeExists(env.Client, pod.Status.NominatedNodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "habana-gaudi-instance-type"))
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.ExtendedResourcesAnnotationKey, "habana.ai/gaudi"))
+		})
+		It("should not provision nodes for more extended resources than instance types have", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{"habana.ai/gaudi": resource.MustParse("9")}},
+				}),
+			)[0]
+			Expect(pod.Spec.NodeName).To(BeEmpty())
+			Expect(pod.Status.NominatedNodeName).To(BeEmpty())
+		})
+		It("should not provision nodes with extended resources for pods that don't request them", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())[0]
+			node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "default-instance-type"))
+		})
+		It("should not provision nodes for pods nominated to initializing nodes", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")}},
+				}),
+			)[0]
+			node := ExpectNodeExists(env.Client, pod.Status.NominatedNodeName)
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.ExtendedResourcesAnnotationKey, resources.NvidiaGPU))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(1))
+		})
+		It("should exclude pods that are too large for any instance type", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}}),
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}}}),
+			)
+			Expect(pods[0].Spec.NodeName).ToNot(BeEmpty())
+			Expect(pods[1].Spec.NodeName).To(BeEmpty())
+		})
+		It("should predict when nodes will be ready from recent launches", func() {
+			launched := test.Node(test.NodeOptions{
+				Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name, v1.LabelInstanceTypeStable: "default-instance-type"},
+			})
+			launched.Status.Conditions = []v1.NodeCondition{{
+				Type:               v1alpha4.NodeInitialized,
+				Status:             v1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(2 * time.Minute)),
+			}}
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, launched)
+			pod := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())[0]
+			node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			Expect(node.Annotations).To(HaveKey(v1alpha4.ExpectedReadyAnnotationKey))
+			expected, err := time.Parse(time.RFC3339, node.Annotations[v1alpha4.ExpectedReadyAnnotationKey])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(expected).To(BeTemporally("~", time.Now().Add(2*time.Minute), 10*time.Second))
+		})
+		It("should not predict when nodes will be ready without recent launches", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())[0]
+			Expect(ExpectNodeExists(env.Client, pod.Spec.NodeName).Annotations).ToNot(HaveKey(v1alpha4.ExpectedReadyAnnotationKey))
+		})
+		It("should record provisioning decisions referenced by their nodes", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(), test.UnschedulablePod())
+			node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(node.Annotations).To(HaveKey(v1alpha4.ProvisioningDecisionAnnotationKey))
+
+			decision := &v1alpha4.ProvisioningDecision{}
+			Expect(env.Client.Get(ctx, types.NamespacedName{Name: node.Annotations[v1alpha4.ProvisioningDecisionAnnotationKey]}, decision)).To(Succeed())
+			Expect(decision.Spec.Provisioner).To(Equal(provisioner.Name))
+			Expect(decision.Spec.NodeQuantity).To(Equal(1))
+			Expect(decision.Spec.Pods).To(ConsistOf(client.ObjectKeyFromObject(pods[0]).String(), client.ObjectKeyFromObject(pods[1]).String()))
+			Expect(decision.Spec.InstanceTypes).ToNot(BeEmpty())
+			Expect(decision.Spec.ConstraintsHash).ToNot(BeEmpty())
+			Expect(decision.Status.Nodes).To(HaveLen(1))
+			Expect(decision.Status.Nodes[0].Name).To(Equal(node.Name))
+			Expect(decision.Status.Error).To(BeEmpty())
+		})
+		It("should provision for translated resource requirements", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{legacyGPU: resource.MustParse("1")}},
+				}),
+			)[0]
+			node := ExpectNodeExists(env.Client, pod.Status.NominatedNodeName)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "nvidia-gpu-instance-type"))
+			Expect(pod.Spec.Containers[0].Resources.Limits).To(HaveKey(v1.ResourceName(legacyGPU)))
+		})
+		It("should exclude pods that fail to translate", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(),
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{legacyGPU: resource.MustParse("16")}},
+				}),
+			)
+			Expect(pods[0].Spec.NodeName).ToNot(BeEmpty())
+			Expect(pods[1].Spec.NodeName).To(BeEmpty())
+			Expect(pods[1].Status.NominatedNodeName).To(BeEmpty())
+		})
+		It("should not launch capacity for provisioners in dry run mode", func() {
+			provisioner.Annotations = map[string]string{v1alpha4.DryRunAnnotationKey: "true"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(), test.UnschedulablePod())
+			for _, pod := range pods {
+				Expect(pod.Spec.NodeName).To(BeEmpty())
 			}
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+		})
+		It("should exclude pods that mix accelerators no instance type offers together", func() {
+			ExpectCreated(env.Client, provisioner)
+			mixed := test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")}},
+			})
+			neuron := mixed.Spec.Containers[0].DeepCopy()
+			neuron.Name = "neuron"
+			neuron.Resources = v1.ResourceRequirements{Limits: v1.ResourceList{resources.AWSNeuron: resource.MustParse("1")}}
+			mixed.Spec.Containers = append(mixed.Spec.Containers, *neuron)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")}},
+				}),
+				mixed,
+			)
+			Expect(pods[0].Status.NominatedNodeName).ToNot(BeEmpty())
+			Expect(pods[1].Status.NominatedNodeName).To(BeEmpty())
+			Expect(pods[1].Spec.NodeName).To(BeEmpty())
 		})
 		It("should account for daemonsets", func() {
 			daemonsets := []client.Object{
@@ -205,7 +411,146 @@ var _ = Describe("Allocation", func() {
 			Expect(*nodes.Items[0].Status.Allocatable.Cpu()).To(Equal(resource.MustParse("4")))
 			Expect(*nodes.Items[0].Status.Allocatable.Memory()).To(Equal(resource.MustParse("4Gi")))
 		})
+		It("should account for daemonsets that do not tolerate startup taints", func() {
+			provisioner.Spec.StartupTaints = []v1.Taint{{Key: "test", Value: "bar", Effect: v1.TaintEffectNoSchedule}}
+			daemonsets := []client.Object{
+				&appsv1.DaemonSet{
+					ObjectMeta: metav1.ObjectMeta{Name: "daemons", Namespace: "default"},
+					Spec: appsv1.DaemonSetSpec{
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+						Template: v1.PodTemplateSpec{
+							ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "test"}},
+							Spec: test.UnschedulablePod(test.PodOptions{
+								ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("1Gi")}},
+							}).Spec,
+						}},
+				},
+			}
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, daemonsets...)
+			// Fits on an instance type, but not alongside the daemon
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3.5")}},
+			}))
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+		})
+		Context("Daemonset Node Affinity", func() {
+			daemonWithAffinity := func(requirements ...v1.NodeSelectorRequirement) client.Object {
+				return &appsv1.DaemonSet{
+					ObjectMeta: metav1.ObjectMeta{Name: "daemons", Namespace: "default"},
+					Spec: appsv1.DaemonSetSpec{
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+						Template: v1.PodTemplateSpec{
+							ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "test"}},
+							Spec: test.UnschedulablePod(test.PodOptions{
+								NodeRequirements:     requirements,
+								ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+							}).Spec,
+						}},
+				}
+			}
+			It("should account for daemonsets with node affinity that matches the node", func() {
+				provisioner.Spec.Labels = map[string]string{"team": "a"}
+				ExpectCreated(env.Client, provisioner)
+				ExpectCreatedWithStatus(env.Client, daemonWithAffinity(
+					v1.NodeSelectorRequirement{Key: "team", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}},
+					v1.NodeSelectorRequirement{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{"amd64", "arm64"}},
+				))
+				// Fits on an instance type, but not alongside the daemon
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3.5")}},
+				}))
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			})
+			It("should ignore daemonsets with node affinity that doesn't match the node", func() {
+				provisioner.Spec.Labels = map[string]string{"team": "a"}
+				ExpectCreated(env.Client, provisioner)
+				ExpectCreatedWithStatus(env.Client, daemonWithAffinity(
+					v1.NodeSelectorRequirement{Key: "team", Operator: v1.NodeSelectorOpNotIn, Values: []string{"a"}},
+				))
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3.5")}},
+				}))
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			})
+		})
+		It("should not provision nodes if the cloud provider vetoes the launch", func() {
+			cloudProvider.LaunchError = cloudprovider.NewLaunchError(cloudprovider.QuotaExceeded, "test quota exceeded")
+			defer func() { cloudProvider.LaunchError = nil }()
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+		})
 
+		Context("Selectors", func() {
+			It("should provision pods matching the pod selector of another provisioner", func() {
+				team := &v1alpha4.Provisioner{
+					ObjectMeta: metav1.ObjectMeta{Name: "team"},
+					Spec:       v1alpha4.ProvisionerSpec{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}},
+				}
+				ExpectCreated(env.Client, provisioner, team)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{Labels: map[string]string{"team": "a"}}),
+				)
+				Expect(pods[0].Spec.NodeName).To(BeEmpty())
+				ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(team))
+				pod := ExpectPodExists(env.Client, pods[0].Name, pods[0].Namespace)
+				node := ExpectNodeExists(env.Client, pod.Spec.NodeName)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha4.ProvisionerNameLabelKey, team.Name))
+			})
+			It("should not provision pods that don't match the pod selector", func() {
+				provisioner.Spec.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{Labels: map[string]string{"team": "b"}}),
+					test.UnschedulablePod(test.PodOptions{Labels: map[string]string{"team": "b"}, NodeSelector: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}}),
+				)
+				for _, pod := range pods {
+					Expect(pod.Spec.NodeName).To(BeEmpty())
+				}
+			})
+			It("should only provision pods in namespaces matching the namespace selector", func() {
+				namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}}
+				provisioner.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+				ExpectCreated(env.Client, namespace, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+					test.UnschedulablePod(test.PodOptions{Namespace: namespace.Name}),
+					test.UnschedulablePod(),
+				)
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(pods[1].Spec.NodeName).To(BeEmpty())
+			})
+		})
+		Context("Overcommit", func() {
+			burstable := test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+				Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")},
+			}}
+			It("should pack pods by their requests by default", func() {
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(burstable), test.UnschedulablePod(burstable))
+				Expect(pods[0].Spec.NodeName).ToNot(BeEmpty())
+				Expect(pods[0].Spec.NodeName).To(Equal(pods[1].Spec.NodeName))
+			})
+			It("should reserve the configured percentage of pods' limits", func() {
+				provisioner.Spec.Overcommit = &v1alpha4.Overcommit{LimitsPercent: ptr.Int32(100)}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(burstable), test.UnschedulablePod(burstable))
+				ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				ExpectNodeExists(env.Client, pods[1].Spec.NodeName)
+				Expect(pods[0].Spec.NodeName).ToNot(Equal(pods[1].Spec.NodeName))
+			})
+			It("should reserve a fraction of pods' limits", func() {
+				provisioner.Spec.Overcommit = &v1alpha4.Overcommit{LimitsPercent: ptr.Int32(50)}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(burstable), test.UnschedulablePod(burstable))
+				Expect(pods[0].Spec.NodeName).ToNot(BeEmpty())
+				Expect(pods[0].Spec.NodeName).To(Equal(pods[1].Spec.NodeName))
+			})
+		})
 		Context("Labels", func() {
 			It("should label nodes with provisioner labels", func() {
 				provisioner.Spec.Labels = map[string]string{"test-key": "test-value", "test-key-2": "test-value-2"}
@@ -237,6 +582,364 @@ var _ = Describe("Allocation", func() {
 				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
 				Expect(node.Spec.Taints).To(ContainElement(provisioner.Spec.Taints[0]))
 			})
+			It("should taint nodes with provisioner startup taints without requiring tolerations", func() {
+				provisioner.Spec.StartupTaints = []v1.Taint{{Key: "test", Value: "bar", Effect: v1.TaintEffectNoSchedule}}
+				ExpectCreated(env.Client, provisioner)
+				pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+				node := ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+				Expect(node.Spec.Taints).To(ContainElement(provisioner.Spec.StartupTaints[0]))
+			})
+		})
+	})
+	Context("Replacement", func() {
+		var node *v1.Node
+		BeforeEach(func() {
+			node = test.Node(test.NodeOptions{
+				Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+				Conditions: []v1.NodeCondition{{Type: v1alpha4.NodeTerminating, Status: v1.ConditionTrue}},
+			})
+		})
+		It("should provision capacity in advance for pods on slowly draining nodes", func() {
+			pod := test.Pod(test.PodOptions{NodeName: node.Name})
+			pod.Spec.TerminationGracePeriodSeconds = ptr.Int64(int64(allocation.ReplacementThreshold.Seconds()))
+			ExpectCreated(env.Client, provisioner, pod)
+			ExpectCreatedWithStatus(env.Client, node)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(2))
+			node = ExpectNodeExists(env.Client, node.Name)
+			Expect(node.Annotations).To(HaveKey(v1alpha4.ReplacementAnnotationKey))
+			Expect(ExpectPodExists(env.Client, pod.Name, pod.Namespace).Spec.NodeName).To(Equal(node.Name))
+		})
+		It("should provision capacity in advance for pods on expired nodes", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(0)
+			pod := test.Pod(test.PodOptions{NodeName: node.Name})
+			ExpectCreated(env.Client, provisioner, pod)
+			ExpectCreatedWithStatus(env.Client, node)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(2))
+			Expect(ExpectNodeExists(env.Client, node.Name).Annotations).To(HaveKey(v1alpha4.ReplacementAnnotationKey))
+		})
+		It("should not provision capacity in advance for pods on quickly draining nodes", func() {
+			pod := test.Pod(test.PodOptions{NodeName: node.Name})
+			ExpectCreated(env.Client, provisioner, pod)
+			ExpectCreatedWithStatus(env.Client, node)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(1))
+			Expect(ExpectNodeExists(env.Client, node.Name).Annotations).ToNot(HaveKey(v1alpha4.ReplacementAnnotationKey))
+		})
+	})
+	Context("Limits", func() {
+		It("should not launch nodes beyond max nodes", func() {
+			provisioner.Spec.Limits = &v1alpha4.Limits{MaxNodes: ptr.Int32(0)}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+		})
+		It("should only launch nodes whose capacity fits within resource limits", func() {
+			provisioner.Spec.Limits = &v1alpha4.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner,
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}),
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}),
+			)
+			scheduled := 0
+			for _, pod := range pods {
+				if pod.Spec.NodeName != "" {
+					scheduled++
+				}
+			}
+			Expect(scheduled).To(Equal(1))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(1))
+		})
+		It("should launch nodes for higher priority pods first once limits are reached", func() {
+			priorityClass := &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "high-priority"}, Value: 1000}
+			ExpectCreated(env.Client, priorityClass)
+			defer ExpectDeleted(env.Client, priorityClass)
+			provisioner.Spec.Limits = &v1alpha4.Limits{MaxNodes: ptr.Int32(1)}
+			low := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}})
+			high := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}})
+			high.Spec.PriorityClassName = priorityClass.Name
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, low, high)
+			Expect(pods[0].Spec.NodeName).To(BeEmpty())
+			ExpectNodeExists(env.Client, pods[1].Spec.NodeName)
+		})
+		It("should publish the capacity of the provisioner's nodes to its status", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, test.Node(test.NodeOptions{Labels: map[string]string{
+				v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       "default-instance-type",
+			}}))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.Status.Nodes).To(BeNumerically("==", 1))
+			Expect(provisioner.Status.Resources.Cpu().String()).To(Equal("4"))
+			Expect(provisioner.Status.Resources.Memory().String()).To(Equal("4Gi"))
+		})
+	})
+	Context("Launch Failures", func() {
+		BeforeEach(func() {
+			cloudProvider.CreateError = fmt.Errorf("test create failed")
+		})
+		AfterEach(func() {
+			cloudProvider.CreateError = nil
+			controller.Breaker = allocation.NewBreaker()
+		})
+		It("should suspend launches after repeated failures", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := test.UnschedulablePod()
+			ExpectCreatedWithStatus(env.Client, pod)
+			for i := 0; i < 2; i++ {
+				_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(provisioner)})
+				Expect(err).To(HaveOccurred())
+			}
+			result, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(provisioner)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(time.Minute))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			condition := provisioner.StatusConditions().GetCondition(v1alpha4.Launchable)
+			Expect(condition.IsFalse()).To(BeTrue())
+			Expect(condition.Message).To(ContainSubstring("test create failed"))
+
+			// Launches aren't attempted while suspended
+			cloudProvider.CreateError = nil
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			Expect(ExpectPodExists(env.Client, pod.Name, pod.Namespace).Spec.NodeName).To(BeEmpty())
+		})
+		It("should keep pods queued until they're bound", func() {
+			ExpectCreated(env.Client, provisioner)
+			pod := test.UnschedulablePod()
+			ExpectCreatedWithStatus(env.Client, pod)
+			controller.Batcher.Enqueue(provisioner, pod)
+			_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(provisioner)})
+			Expect(err).To(HaveOccurred())
+			Expect(controller.Batcher.Depth(provisioner)).To(Equal(1))
+
+			cloudProvider.CreateError = nil
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			Expect(ExpectPodExists(env.Client, pod.Name, pod.Namespace).Spec.NodeName).ToNot(BeEmpty())
+			Expect(controller.Batcher.Depth(provisioner)).To(BeZero())
+		})
+		It("should resume launches after a successful launch", func() {
+			ExpectCreated(env.Client, provisioner)
+			provisioner.StatusConditions().MarkFalse(v1alpha4.Launchable, "LaunchFailed", "test create failed")
+			Expect(env.Client.Status().Update(ctx, provisioner)).To(Succeed())
+			cloudProvider.CreateError = nil
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.StatusConditions().GetCondition(v1alpha4.Launchable).IsTrue()).To(BeTrue())
+		})
+		It("should back off exponentially up to a bound", func() {
+			cooldowns := []time.Duration{}
+			for i := 0; i < 8; i++ {
+				cooldowns = append(cooldowns, controller.Breaker.Failure(provisioner))
+			}
+			Expect(cooldowns).To(Equal([]time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 15 * time.Minute, 15 * time.Minute}))
+		})
+	})
+	Context("Status Conditions", func() {
+		It("should report pods that can't be scheduled until they're provisioned", func() {
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+			}))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			condition := provisioner.StatusConditions().GetCondition(v1alpha4.Unsatisfiable)
+			Expect(condition.IsTrue()).To(BeTrue())
+			Expect(condition.Message).To(ContainSubstring(pods[0].Name))
+
+			ExpectDeleted(env.Client, pods[0])
+			pods = ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			ExpectNodeExists(env.Client, pods[0].Spec.NodeName)
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.StatusConditions().GetCondition(v1alpha4.Unsatisfiable)).To(BeNil())
+		})
+		It("should report limits that deferred nodes", func() {
+			provisioner.Spec.Limits = &v1alpha4.Limits{MaxNodes: ptr.Int32(0)}
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			condition := provisioner.StatusConditions().GetCondition(v1alpha4.LimitExceeded)
+			Expect(condition.IsTrue()).To(BeTrue())
+			Expect(condition.Message).To(Equal("reached nodes limit, deferring 1 node(s)"))
+			Expect(provisioner.StatusConditions().GetCondition(v1alpha4.Unsatisfiable)).To(BeNil())
+		})
+		It("should report launches vetoed by the cloud provider", func() {
+			cloudProvider.LaunchError = cloudprovider.NewLaunchError(cloudprovider.QuotaExceeded, "test quota exceeded")
+			defer func() { cloudProvider.LaunchError = nil }()
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod())
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			condition := provisioner.StatusConditions().GetCondition(v1alpha4.CloudProviderError)
+			Expect(condition.IsTrue()).To(BeTrue())
+			Expect(condition.Message).To(ContainSubstring("test quota exceeded"))
+		})
+		It("should not affect the provisioner's Active condition", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+			}))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.StatusConditions().GetCondition(v1alpha4.Active)).To(BeNil())
+		})
+	})
+	Context("Cost Estimates", func() {
+		var recorder *record.FakeRecorder
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			controller.Recorder = recorder
+		})
+		AfterEach(func() {
+			controller.Recorder = &record.FakeRecorder{}
+		})
+		It("should record the estimated cost of launches on the provisioner", func() {
+			provisioner.Spec.InstanceTypes = []string{"default-instance-type"}
+			ExpectCreated(env.Client, provisioner)
+			pods := ExpectProvisioningSucceeded(ctx, env.Client, controller, provisioner, test.UnschedulablePod(), test.UnschedulablePod())
+			for _, pod := range pods {
+				ExpectNodeExists(env.Client, pod.Spec.NodeName)
+			}
+			Expect(recorder.Events).To(Receive(Equal(fmt.Sprintf("Normal %s Launching 1 node(s) for 2 pod(s), 1 of [default-instance-type], estimated cost 0.1000 per hour", allocation.LaunchEstimated))))
+		})
+	})
+	Context("Scale Hints", func() {
+		deploymentWithHint := func(hint string, cpu string) *appsv1.Deployment {
+			return &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "hinted",
+					Namespace:   "default",
+					Annotations: map[string]string{v1alpha4.ScaleHintAnnotationKey: hint},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: ptr.Int32(1),
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "hinted"}},
+					Template: v1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "hinted"}},
+						Spec: test.Pod(test.PodOptions{
+							ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+						}).Spec,
+					},
+				},
+			}
+		}
+		It("should provision capacity in advance for hinted replicas", func() {
+			deployment := deploymentWithHint("3", "3")
+			ExpectCreated(env.Client, provisioner, deployment)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(2))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(deployment), deployment)).To(Succeed())
+			Expect(deployment.Annotations).To(HaveKeyWithValue(v1alpha4.ScaleHintProvisionedAnnotationKey, "3"))
+		})
+		It("should only provision capacity once per hint", func() {
+			deployment := deploymentWithHint("3", "3")
+			deployment.Annotations[v1alpha4.ScaleHintProvisionedAnnotationKey] = "3"
+			ExpectCreated(env.Client, provisioner, deployment)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+		})
+		It("should not provision capacity for hinted replicas that fit on existing nodes", func() {
+			deployment := deploymentWithHint("3", "1")
+			ExpectCreated(env.Client, provisioner, deployment)
+			ExpectCreatedWithStatus(env.Client, test.Node(test.NodeOptions{Allocatable: v1.ResourceList{
+				v1.ResourceCPU:  resource.MustParse("4"),
+				v1.ResourcePods: resource.MustParse("10"),
+			}}))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(len(nodes.Items)).To(Equal(1))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(deployment), deployment)).To(Succeed())
+			Expect(deployment.Annotations).To(HaveKeyWithValue(v1alpha4.ScaleHintProvisionedAnnotationKey, "3"))
+		})
+		It("should not provision capacity for deployments that have scaled to their hint", func() {
+			deployment := deploymentWithHint("1", "3")
+			ExpectCreated(env.Client, provisioner, deployment)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+		})
+	})
+	Context("Batching", func() {
+		It("should track how long pods waited to be batched", func() {
+			batcher := allocation.NewBatcher(1*time.Millisecond, 1*time.Millisecond)
+			pod := test.UnschedulablePod()
+			pod.UID = "pod-uid"
+			batcher.Enqueue(provisioner, pod)
+			time.Sleep(10 * time.Millisecond)
+			batcher.Enqueue(provisioner, pod)
+			wait, ok := batcher.Dequeue(provisioner, pod)
+			Expect(ok).To(BeTrue())
+			Expect(wait).To(BeNumerically(">=", 10*time.Millisecond))
+			_, ok = batcher.Dequeue(provisioner, pod)
+			Expect(ok).To(BeFalse())
+		})
+		It("should track the depth of the queue by provisioner", func() {
+			batcher := allocation.NewBatcher(1*time.Millisecond, 1*time.Millisecond)
+			other := &v1alpha4.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+			pods := []*v1.Pod{test.UnschedulablePod(), test.UnschedulablePod(), test.UnschedulablePod()}
+			for i, pod := range pods {
+				pod.UID = types.UID(fmt.Sprintf("pod-uid-%d", i))
+			}
+			batcher.Enqueue(provisioner, pods[0])
+			batcher.Enqueue(provisioner, pods[1])
+			batcher.Enqueue(other, pods[2])
+			Expect(batcher.Depth(provisioner)).To(Equal(2))
+			Expect(batcher.Depth(other)).To(Equal(1))
+			batcher.Dequeue(provisioner, pods[0])
+			Expect(batcher.Depth(provisioner)).To(Equal(1))
+		})
+		It("should close windows after the provisioner's idle duration", func() {
+			batcher := allocation.NewBatcher(time.Hour, time.Hour)
+			batchCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			batcher.Start(batchCtx)
+			provisioner.UID = "provisioner-uid"
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: 10 * time.Millisecond}
+			start := time.Now()
+			batcher.Add(provisioner)
+			batcher.Wait(provisioner)
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+		It("should close windows after the provisioner's max duration", func() {
+			batcher := allocation.NewBatcher(time.Hour, time.Hour)
+			batchCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			batcher.Start(batchCtx)
+			provisioner.UID = "provisioner-uid"
+			provisioner.Spec.MaxBatchDuration = &metav1.Duration{Duration: 50 * time.Millisecond}
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: 20 * time.Millisecond}
+			stop := make(chan struct{})
+			defer close(stop)
+			go func() {
+				for {
+					select {
+					case <-stop:
+						return
+					case <-time.After(5 * time.Millisecond):
+						batcher.Add(provisioner)
+					}
+				}
+			}()
+			start := time.Now()
+			batcher.Wait(provisioner)
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
 		})
 	})
 })
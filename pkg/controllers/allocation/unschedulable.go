@@ -0,0 +1,81 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocation
+
+import (
+	"context"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	RestrictedLabel         = "RestrictedLabel"
+	IncompatibleConstraints = "IncompatibleConstraints"
+	FailedProvisioning      = "FailedProvisioning"
+)
+
+var unschedulablePodsCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "allocation_controller",
+		Name:      "unschedulable_pods_total",
+		Help:      "Number of pods that the provisioner ignored because they couldn't be scheduled. Broken down by provisioner and reason.",
+	},
+	[]string{metrics.ProvisionerLabel, metrics.ReasonLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(unschedulablePodsCounterVec)
+}
+
+// reportUnschedulable records an event on each pod that couldn't be scheduled,
+// explaining why the provisioner ignored it. Scale hints aren't real pods, so
+// they're only logged.
+func (c *Controller) reportUnschedulable(ctx context.Context, provisioner *v1alpha4.Provisioner, podErrs []*scheduling.PodError) {
+	for _, podErr := range podErrs {
+		logging.FromContext(ctx).Debugf("Ignored %s", podErr.Error())
+		if isScaleHint(podErr.Pod) {
+			continue
+		}
+		reason := unschedulableReason(podErr.Err)
+		unschedulablePodsCounterVec.WithLabelValues(provisioner.Name, reason).Inc()
+		switch reason {
+		case RestrictedLabel:
+			restrictedErr, _ := scheduling.AsRestrictedLabelError(podErr.Err)
+			c.Recorder.Eventf(podErr.Pod, v1.EventTypeWarning, reason, "Ignored by provisioner %s, node selector uses restricted label %s, which is set by Karpenter, the cloud provider, or the kubelet", provisioner.Name, restrictedErr.Key)
+		case IncompatibleConstraints:
+			constraintErr, _ := scheduling.AsConstraintError(podErr.Err)
+			c.Recorder.Eventf(podErr.Pod, v1.EventTypeWarning, reason, "Incompatible with provisioner %s, %s", provisioner.Name, constraintErr.Err.Error())
+		default:
+			c.Recorder.Eventf(podErr.Pod, v1.EventTypeWarning, reason, "Failed to schedule pod for provisioner %s, %s", provisioner.Name, podErr.Err.Error())
+		}
+	}
+}
+
+func unschedulableReason(err error) string {
+	if _, ok := scheduling.AsRestrictedLabelError(err); ok {
+		return RestrictedLabel
+	}
+	if _, ok := scheduling.AsConstraintError(err); ok {
+		return IncompatibleConstraints
+	}
+	return FailedProvisioning
+}
@@ -0,0 +1,346 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CacheOptions reduce the memory of the manager's pod and node caches, which
+// dominate the controller's footprint on large clusters.
+type CacheOptions struct {
+	// PodLabelSelector, PodFieldSelector and NodeLabelSelector restrict the
+	// pods and nodes that are cached. Controllers don't see pods and nodes
+	// that don't match, e.g. pods excluded by their phase aren't considered
+	// when deciding if a node is empty.
+	PodLabelSelector  string
+	PodFieldSelector  string
+	NodeLabelSelector string
+	// StripUnusedFields drops fields that controllers don't read from cached
+	// pods and nodes: managed fields, pods' container statuses and IPs, and
+	// nodes' images and volumes.
+	StripUnusedFields bool
+}
+
+// NewCache returns a cache that lists and watches pods and nodes with the
+// options, and delegates other objects to controller-runtime's cache. Without
+// options, it's controller-runtime's cache.
+func NewCache(options CacheOptions) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		delegate, err := cache.New(config, opts)
+		if err != nil {
+			return nil, err
+		}
+		if options == (CacheOptions{}) {
+			return delegate, nil
+		}
+		clientSet, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("creating client set, %w", err)
+		}
+		return newSelectiveCache(delegate, clientSet, opts.Namespace, options)
+	}
+}
+
+// NewReaderCache returns a cache built from the config with the options, for
+// controllers whose reads are rate limited separately. The manager's cache
+// lists and watches with the manager's config, so controllers reading from it
+// share the manager's rate limiter regardless of their own. The cache is
+// started with the manager, before leader election, so that it's synced by the
+// time the controllers reading from it start.
+func NewReaderCache(manager Manager, config *rest.Config, options CacheOptions) (cache.Cache, error) {
+	c, err := newIndexedCache(config, cache.Options{Scheme: manager.GetScheme(), Mapper: manager.GetRESTMapper()}, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.Add(unelectedCache{Cache: c}); err != nil {
+		return nil, fmt.Errorf("adding cache, %w", err)
+	}
+	return c, nil
+}
+
+// newIndexedCache returns a cache with the same indexes as the manager's
+func newIndexedCache(config *rest.Config, opts cache.Options, options CacheOptions) (cache.Cache, error) {
+	c, err := NewCache(options)(config, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.IndexField(context.Background(), &v1.Pod{}, "spec.nodeName", podSchedulingIndex); err != nil {
+		return nil, fmt.Errorf("indexing pods, %w", err)
+	}
+	return c, nil
+}
+
+// unelectedCache runs whether or not the manager is the leader
+type unelectedCache struct {
+	cache.Cache
+}
+
+func (unelectedCache) NeedLeaderElection() bool {
+	return false
+}
+
+// selectiveCache serves pods and nodes from its own informers, and other
+// objects from the cache it wraps
+type selectiveCache struct {
+	cache.Cache
+	pods  toolscache.SharedIndexInformer
+	nodes toolscache.SharedIndexInformer
+}
+
+func newSelectiveCache(delegate cache.Cache, clientSet kubernetes.Interface, namespace string, options CacheOptions) (*selectiveCache, error) {
+	if _, err := labels.Parse(options.PodLabelSelector); err != nil {
+		return nil, fmt.Errorf("parsing pod label selector, %w", err)
+	}
+	if _, err := fields.ParseSelector(options.PodFieldSelector); err != nil {
+		return nil, fmt.Errorf("parsing pod field selector, %w", err)
+	}
+	if _, err := labels.Parse(options.NodeLabelSelector); err != nil {
+		return nil, fmt.Errorf("parsing node label selector, %w", err)
+	}
+	transform := func(runtime.Object) {}
+	if options.StripUnusedFields {
+		transform = stripUnusedFields
+	}
+	return &selectiveCache{
+		Cache: delegate,
+		pods: newInformer(&v1.Pod{}, &toolscache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				opts.LabelSelector, opts.FieldSelector = options.PodLabelSelector, options.PodFieldSelector
+				return clientSet.CoreV1().Pods(namespace).List(context.Background(), opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				opts.LabelSelector, opts.FieldSelector = options.PodLabelSelector, options.PodFieldSelector
+				return clientSet.CoreV1().Pods(namespace).Watch(context.Background(), opts)
+			},
+		}, transform),
+		nodes: newInformer(&v1.Node{}, &toolscache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				opts.LabelSelector = options.NodeLabelSelector
+				return clientSet.CoreV1().Nodes().List(context.Background(), opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				opts.LabelSelector = options.NodeLabelSelector
+				return clientSet.CoreV1().Nodes().Watch(context.Background(), opts)
+			},
+		}, transform),
+	}, nil
+}
+
+// newInformer returns an informer that transforms listed and watched objects
+// before they're stored
+func newInformer(object runtime.Object, listWatch *toolscache.ListWatch, transform func(runtime.Object)) toolscache.SharedIndexInformer {
+	list, watchFunc := listWatch.ListFunc, listWatch.WatchFunc
+	return toolscache.NewSharedIndexInformer(&toolscache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			objects, err := list(opts)
+			if err != nil {
+				return nil, err
+			}
+			if err := meta.EachListItem(objects, func(object runtime.Object) error {
+				transform(object)
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			return objects, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			watcher, err := watchFunc(opts)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(watcher, func(event watch.Event) (watch.Event, bool) {
+				transform(event.Object)
+				return event, true
+			}), nil
+		},
+	}, object, 0, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+}
+
+// stripUnusedFields drops fields that Karpenter's controllers don't read.
+// Controllers patch pods and nodes rather than updating them, so the fields
+// aren't cleared on the API server.
+func stripUnusedFields(object runtime.Object) {
+	switch o := object.(type) {
+	case *v1.Pod:
+		o.ManagedFields = nil
+		o.Status.ContainerStatuses = nil
+		o.Status.InitContainerStatuses = nil
+		o.Status.EphemeralContainerStatuses = nil
+		o.Status.HostIP = ""
+		o.Status.PodIP = ""
+		o.Status.PodIPs = nil
+	case *v1.Node:
+		o.ManagedFields = nil
+		o.Status.Images = nil
+		o.Status.VolumesInUse = nil
+		o.Status.VolumesAttached = nil
+	}
+}
+
+func (c *selectiveCache) informerFor(object runtime.Object) toolscache.SharedIndexInformer {
+	switch object.(type) {
+	case *v1.Pod, *v1.PodList:
+		return c.pods
+	case *v1.Node, *v1.NodeList:
+		return c.nodes
+	}
+	return nil
+}
+
+func (c *selectiveCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	informer := c.informerFor(obj)
+	if informer == nil {
+		return c.Cache.Get(ctx, key, obj)
+	}
+	storeKey := key.Name
+	if key.Namespace != "" {
+		storeKey = fmt.Sprintf("%s/%s", key.Namespace, key.Name)
+	}
+	item, exists, err := informer.GetIndexer().GetByKey(storeKey)
+	if err != nil {
+		return err
+	}
+	switch o := obj.(type) {
+	case *v1.Pod:
+		if !exists {
+			return errors.NewNotFound(v1.Resource("pods"), key.Name)
+		}
+		item.(*v1.Pod).DeepCopyInto(o)
+		o.SetGroupVersionKind(v1.SchemeGroupVersion.WithKind("Pod"))
+	case *v1.Node:
+		if !exists {
+			return errors.NewNotFound(v1.Resource("nodes"), key.Name)
+		}
+		item.(*v1.Node).DeepCopyInto(o)
+		o.SetGroupVersionKind(v1.SchemeGroupVersion.WithKind("Node"))
+	}
+	return nil
+}
+
+// List supports the same options as controller-runtime's cache: a namespace,
+// a label selector, and a field selector that exactly matches an indexed field
+func (c *selectiveCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	informer := c.informerFor(list)
+	if informer == nil {
+		return c.Cache.List(ctx, list, opts...)
+	}
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+	items, err := itemsFor(informer.GetIndexer(), listOptions)
+	if err != nil {
+		return err
+	}
+	switch l := list.(type) {
+	case *v1.PodList:
+		l.Items = []v1.Pod{}
+		for _, item := range items {
+			if pod := item.(*v1.Pod); matches(pod, listOptions) {
+				l.Items = append(l.Items, *pod.DeepCopy())
+			}
+		}
+	case *v1.NodeList:
+		l.Items = []v1.Node{}
+		for _, item := range items {
+			if node := item.(*v1.Node); matches(node, listOptions) {
+				l.Items = append(l.Items, *node.DeepCopy())
+			}
+		}
+	}
+	return nil
+}
+
+func itemsFor(indexer toolscache.Indexer, listOptions *client.ListOptions) ([]interface{}, error) {
+	if listOptions.FieldSelector != nil && !listOptions.FieldSelector.Empty() {
+		requirements := listOptions.FieldSelector.Requirements()
+		if len(requirements) != 1 || (requirements[0].Operator != selection.Equals && requirements[0].Operator != selection.DoubleEquals) {
+			return nil, fmt.Errorf("field selector %s must exactly match a single field", listOptions.FieldSelector.String())
+		}
+		return indexer.ByIndex(fieldIndexName(requirements[0].Field), requirements[0].Value)
+	}
+	if listOptions.Namespace != "" {
+		return indexer.ByIndex(toolscache.NamespaceIndex, listOptions.Namespace)
+	}
+	return indexer.List(), nil
+}
+
+func matches(object metav1.Object, listOptions *client.ListOptions) bool {
+	if listOptions.Namespace != "" && object.GetNamespace() != listOptions.Namespace {
+		return false
+	}
+	if listOptions.LabelSelector != nil && !listOptions.LabelSelector.Matches(labels.Set(object.GetLabels())) {
+		return false
+	}
+	return true
+}
+
+func (c *selectiveCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	informer := c.informerFor(obj)
+	if informer == nil {
+		return c.Cache.IndexField(ctx, obj, field, extractValue)
+	}
+	return informer.AddIndexers(toolscache.Indexers{fieldIndexName(field): func(object interface{}) ([]string, error) {
+		return extractValue(object.(client.Object)), nil
+	}})
+}
+
+func fieldIndexName(field string) string {
+	return fmt.Sprintf("field:%s", field)
+}
+
+func (c *selectiveCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	if informer := c.informerFor(obj); informer != nil {
+		return informer, nil
+	}
+	return c.Cache.GetInformer(ctx, obj)
+}
+
+func (c *selectiveCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	switch gvk {
+	case v1.SchemeGroupVersion.WithKind("Pod"):
+		return c.pods, nil
+	case v1.SchemeGroupVersion.WithKind("Node"):
+		return c.nodes, nil
+	}
+	return c.Cache.GetInformerForKind(ctx, gvk)
+}
+
+func (c *selectiveCache) Start(ctx context.Context) error {
+	go c.pods.Run(ctx.Done())
+	go c.nodes.Run(ctx.Done())
+	return c.Cache.Start(ctx)
+}
+
+func (c *selectiveCache) WaitForCacheSync(ctx context.Context) bool {
+	return toolscache.WaitForCacheSync(ctx.Done(), c.pods.HasSynced, c.nodes.HasSynced) && c.Cache.WaitForCacheSync(ctx)
+}
@@ -0,0 +1,297 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consolidation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/approval"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/scheduling"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	podutil "github.com/awslabs/karpenter/pkg/utils/pod"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+)
+
+const (
+	controllerName = "Consolidation"
+	// consolidationInterval is how often a provisioner's nodes are evaluated
+	consolidationInterval = 5 * time.Minute
+)
+
+// Controller periodically evaluates the nodes launched by each provisioner and
+// deletes at most one underutilized node per evaluation. A node is
+// underutilized if its pods fit on the provisioner's other nodes, or with the
+// Replace policy, on a smaller instance type. Deleted nodes are cordoned,
+// drained and terminated by the termination controller, and evicted pods that
+// don't fit on other nodes are provisioned onto a right sized node.
+type Controller struct {
+	kubeClient    client.Client
+	cloudProvider cloudprovider.CloudProvider
+	recorder      record.EventRecorder
+	gate          *approval.Gate
+	coordinator   *deprovisioning.Coordinator
+}
+
+// NewController constructs a controller instance
+func NewController(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider, recorder record.EventRecorder, coordinator *deprovisioning.Coordinator) *Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		cloudProvider: cloudProvider,
+		recorder:      recorder,
+		gate:          approval.NewGate(),
+		coordinator:   coordinator,
+	}
+}
+
+// Reconcile executes a consolidation control loop for the provisioner
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(fmt.Sprintf("consolidation.provisioner/%s", req.Name)))
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if provisioner.Spec.ConsolidationPolicy == "" || provisioner.Spec.ConsolidationPolicy == v1alpha4.ConsolidationPolicyDisabled {
+		return reconcile.Result{}, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	pods := &v1.PodList{}
+	if err := c.kubeClient.List(ctx, pods); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing pods, %w", err)
+	}
+	all := ptr.NodeListToSlice(nodes)
+	podsByNode := map[string][]*v1.Pod{}
+	for _, pod := range ptr.PodListToSlice(pods) {
+		podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
+	}
+	for _, node := range c.candidatesFor(provisioner, all, podsByNode) {
+		reason, err := c.consolidatable(ctx, provisioner, node, all, podsByNode)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if reason == "" {
+			continue
+		}
+		// Wait for the node to be approved, rather than disrupting another
+		if approved, err := c.gate.ApproveAndPersist(ctx, c.kubeClient, provisioner, node, approval.Consolidation); err != nil || !approved {
+			return reconcile.Result{RequeueAfter: approval.RetryInterval}, err
+		}
+		if allowed, err := c.coordinator.Allow(ctx, provisioner, node); err != nil || !allowed {
+			return reconcile.Result{RequeueAfter: deprovisioning.RetryInterval}, err
+		}
+		logging.FromContext(ctx).Infof("Triggering termination for underutilized node %s, %s", node.Name, reason)
+		c.recorder.Eventf(node, v1.EventTypeNormal, "Consolidating", "Consolidating underutilized node, %s", reason)
+		if err := c.kubeClient.Delete(ctx, node); err != nil {
+			return reconcile.Result{}, fmt.Errorf("deleting node %s, %w", node.Name, err)
+		}
+		break
+	}
+	return reconcile.Result{RequeueAfter: consolidationInterval}, nil
+}
+
+// candidatesFor returns the provisioner's nodes that may be consolidated,
+// ordered from least to most utilized
+func (c *Controller) candidatesFor(provisioner *v1alpha4.Provisioner, nodes []*v1.Node, podsByNode map[string][]*v1.Pod) []*v1.Node {
+	candidates := []*v1.Node{}
+	for _, node := range nodes {
+		if !nodeutil.OwnedBy(node, provisioner) {
+			continue
+		}
+		if !nodeutil.IsReady(node) || node.Spec.Unschedulable || nodeutil.IsDeleting(node) {
+			continue
+		}
+		if !nodeutil.IsDisruptable(provisioner, node) {
+			continue
+		}
+		if !reschedulable(podsByNode[node.Name]) {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return utilization(candidates[i], podsByNode[candidates[i].Name]) < utilization(candidates[j], podsByNode[candidates[j].Name])
+	})
+	return candidates
+}
+
+// consolidatable returns a reason the node can be consolidated, or an empty
+// string if it can't be
+func (c *Controller) consolidatable(ctx context.Context, provisioner *v1alpha4.Provisioner, node *v1.Node, nodes []*v1.Node, podsByNode map[string][]*v1.Pod) (string, error) {
+	others := []*v1.Node{}
+	scheduled := []*v1.Pod{}
+	for _, other := range nodes {
+		if other.Name == node.Name || !nodeutil.IsReady(other) {
+			continue
+		}
+		others = append(others, other)
+		scheduled = append(scheduled, podsByNode[other.Name]...)
+	}
+	daemons, movable := partition(podsByNode[node.Name])
+	if err := scheduling.NewSimulation(others, scheduled).Schedule(movable...); err == nil {
+		return fmt.Sprintf("%d pod(s) fit on other nodes", len(movable)), nil
+	}
+	if provisioner.Spec.ConsolidationPolicy != v1alpha4.ConsolidationPolicyReplace {
+		return "", nil
+	}
+	instanceTypes, err := c.cloudProvider.GetInstanceTypes(ctx, &provisioner.Spec.Constraints)
+	if err != nil {
+		return "", fmt.Errorf("getting instance types, %w", err)
+	}
+	for _, instanceType := range instanceTypes {
+		replacement, ok := replacementFor(node, instanceType)
+		if !ok {
+			continue
+		}
+		if err := scheduling.NewSimulation([]*v1.Node{replacement}, daemons).Schedule(movable...); err == nil {
+			return fmt.Sprintf("%d pod(s) fit on smaller instance type %s", len(movable), instanceType.Name()), nil
+		}
+	}
+	return "", nil
+}
+
+// replacementFor returns a theoretical node for the instance type with the
+// node's labels, if the instance type is smaller than the node and is offered
+// in its zone
+func replacementFor(node *v1.Node, instanceType cloudprovider.InstanceType) (*v1.Node, bool) {
+	allocatable := instanceType.Resources()
+	for resourceName, overhead := range instanceType.Overhead() {
+		quantity := allocatable[resourceName]
+		quantity.Sub(overhead)
+		allocatable[resourceName] = quantity
+	}
+	if !smaller(allocatable, node.Status.Allocatable) {
+		return nil, false
+	}
+	if zone, ok := node.Labels[v1.LabelTopologyZone]; ok && !functional.ContainsString(instanceType.Zones(), zone) {
+		return nil, false
+	}
+	replacement := node.DeepCopy()
+	replacement.Name = fmt.Sprintf("%s-replacement", node.Name)
+	replacement.Spec.Unschedulable = false
+	replacement.Labels[v1.LabelInstanceTypeStable] = instanceType.Name()
+	replacement.Labels[v1.LabelArchStable] = instanceType.Architecture()
+	replacement.Status.Allocatable = allocatable
+	return replacement, true
+}
+
+// smaller returns true if the allocatable cpu and memory are no larger than
+// the node's, and at least one of them is smaller
+func smaller(allocatable v1.ResourceList, nodeAllocatable v1.ResourceList) bool {
+	strictly := false
+	for _, resourceName := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		quantity, nodeQuantity := allocatable[resourceName], nodeAllocatable[resourceName]
+		switch quantity.Cmp(nodeQuantity) {
+		case 1:
+			return false
+		case -1:
+			strictly = true
+		}
+	}
+	return strictly
+}
+
+// reschedulable returns true if the pods that would be evicted from the node
+// can be safely rescheduled elsewhere
+func reschedulable(pods []*v1.Pod) bool {
+	_, movable := partition(pods)
+	for _, pod := range movable {
+		// Pods without a controller won't be recreated
+		if metav1.GetControllerOf(pod) == nil {
+			return false
+		}
+		if pod.Annotations[v1alpha4.DoNotEvictPodAnnotationKey] == "true" {
+			return false
+		}
+		// Inter-pod scheduling constraints aren't simulated
+		if pod.Spec.Affinity != nil && (pod.Spec.Affinity.PodAffinity != nil || pod.Spec.Affinity.PodAntiAffinity != nil) {
+			return false
+		}
+		if len(pod.Spec.TopologySpreadConstraints) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// partition returns the node's active pods that are bound to it, such as
+// daemonsets, and those that would be moved if the node were deleted
+func partition(pods []*v1.Pod) (bound []*v1.Pod, movable []*v1.Pod) {
+	for _, pod := range pods {
+		if podutil.HasFailed(pod) || pod.Status.Phase == v1.PodSucceeded {
+			continue
+		}
+		if nodeutil.IsDaemon(pod) {
+			bound = append(bound, pod)
+			continue
+		}
+		movable = append(movable, pod)
+	}
+	return bound, movable
+}
+
+// utilization returns the largest fraction of the node's cpu or memory that's
+// available to pods other than daemons and requested by them
+func utilization(node *v1.Node, pods []*v1.Pod) float64 {
+	_, movable := partition(pods)
+	requests := resources.RequestsForPods(movable...)
+	available := nodeutil.Allocatable(node, pods)
+	result := 0.0
+	for _, resourceName := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		allocatable := available[resourceName]
+		if allocatable.IsZero() {
+			continue
+		}
+		request := requests[resourceName]
+		if fraction := float64(request.MilliValue()) / float64(allocatable.MilliValue()); fraction > result {
+			result = fraction
+		}
+	}
+	return result
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha4.Provisioner{}).
+		// Provisioners are consolidated serially, since they may share nodes
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
+}
@@ -0,0 +1,245 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consolidation_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/registry"
+	"github.com/awslabs/karpenter/pkg/controllers/consolidation"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/test"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *consolidation.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Consolidation")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider := &fake.CloudProvider{}
+		registry.RegisterOrDie(ctx, cloudProvider)
+		controller = consolidation.NewController(e.Client, cloudProvider, record.NewFakeRecorder(100), deprovisioning.NewCoordinator(e.Client))
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Consolidation", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha4.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name},
+			Spec:       v1alpha4.ProvisionerSpec{ConsolidationPolicy: v1alpha4.ConsolidationPolicyDelete},
+		}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(env.Client)
+	})
+
+	nodeWithAllocatable := func(cpu string, memory string) *v1.Node {
+		return test.Node(test.NodeOptions{
+			Finalizers: []string{v1alpha4.TerminationFinalizer},
+			Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+				v1.ResourcePods:   resource.MustParse("10"),
+			},
+		})
+	}
+	podOn := func(node *v1.Node, cpu string) *v1.Pod {
+		return test.Pod(test.PodOptions{
+			NodeName: node.Name,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Name:       "test",
+				UID:        "test",
+				Controller: ptr.Bool(true),
+			}},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		})
+	}
+	expectDeleting := func(node *v1.Node, deleting bool) {
+		n := ExpectNodeExists(env.Client, node.Name)
+		Expect(n.DeletionTimestamp.IsZero()).To(Equal(!deleting))
+	}
+
+	It("should not consolidate nodes if disabled", func() {
+		provisioner.Spec.ConsolidationPolicy = v1alpha4.ConsolidationPolicyDisabled
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, podOn(underutilized, "1"), podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, false)
+		expectDeleting(other, false)
+	})
+	It("should delete the least utilized node if its pods fit on other nodes", func() {
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, podOn(underutilized, "1"), podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, true)
+		expectDeleting(other, false)
+	})
+	It("should not count daemons towards the utilization of nodes", func() {
+		withDaemon, underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		daemon := podOn(withDaemon, "2")
+		daemon.OwnerReferences[0].Kind = "DaemonSet"
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, withDaemon, underutilized, other)
+		ExpectCreated(env.Client, daemon, podOn(withDaemon, "250m"), podOn(underutilized, "1"), podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(withDaemon, true)
+		expectDeleting(underutilized, false)
+		expectDeleting(other, false)
+	})
+	It("should wait for approval to delete underutilized nodes", func() {
+		provisioner.Spec.DisruptionApproval = &v1alpha4.DisruptionApproval{}
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, podOn(underutilized, "1"), podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, false)
+		expectDeleting(other, false)
+		Expect(ExpectNodeExists(env.Client, underutilized.Name).Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, "Consolidation"))
+		Expect(ExpectNodeExists(env.Client, other.Name).Annotations).ToNot(HaveKey(v1alpha4.DisruptionPendingAnnotationKey))
+	})
+	It("should delete approved underutilized nodes", func() {
+		provisioner.Spec.DisruptionApproval = &v1alpha4.DisruptionApproval{}
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		underutilized.Annotations = map[string]string{v1alpha4.DisruptionApprovedAnnotationKey: "true"}
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, podOn(underutilized, "1"), podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, true)
+		expectDeleting(other, false)
+	})
+	It("should not delete nodes if their pods don't fit on other nodes", func() {
+		a, b := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, a, b)
+		ExpectCreated(env.Client, podOn(a, "3"), podOn(b, "3"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(a, false)
+		expectDeleting(b, false)
+	})
+	It("should not delete nodes if their pods don't tolerate other nodes' taints", func() {
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		other.Spec.Taints = []v1.Taint{{Key: "team", Value: "a", Effect: v1.TaintEffectNoSchedule}}
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, podOn(underutilized, "1"), podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, false)
+	})
+	It("should not delete nodes with pods that won't be recreated", func() {
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		pod := podOn(underutilized, "1")
+		pod.OwnerReferences = nil
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, pod, podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, false)
+	})
+	It("should not delete nodes with pods that shouldn't be evicted", func() {
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		pod := podOn(underutilized, "1")
+		pod.Annotations = map[string]string{v1alpha4.DoNotEvictPodAnnotationKey: "true"}
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, pod, podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, false)
+	})
+	It("should not delete nodes protected for long running jobs", func() {
+		underutilized, other := nodeWithAllocatable("4", "4Gi"), nodeWithAllocatable("4", "4Gi")
+		underutilized.Status.Conditions = append(underutilized.Status.Conditions, v1.NodeCondition{Type: v1alpha4.NodeJobProtected, Status: v1.ConditionTrue})
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, underutilized, other)
+		ExpectCreated(env.Client, podOn(underutilized, "1"), podOn(other, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(underutilized, false)
+	})
+	It("should delete nodes whose pods fit on a smaller instance type with the Replace policy", func() {
+		provisioner.Spec.ConsolidationPolicy = v1alpha4.ConsolidationPolicyReplace
+		oversized := nodeWithAllocatable("16", "16Gi")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, oversized)
+		ExpectCreated(env.Client, podOn(oversized, "1"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(oversized, true)
+	})
+	It("should not replace nodes with the Delete policy", func() {
+		oversized := nodeWithAllocatable("16", "16Gi")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, oversized)
+		ExpectCreated(env.Client, podOn(oversized, "1"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(oversized, false)
+	})
+	It("should not replace nodes whose pods don't fit on a smaller instance type", func() {
+		provisioner.Spec.ConsolidationPolicy = v1alpha4.ConsolidationPolicyReplace
+		node := nodeWithAllocatable("16", "16Gi")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectCreated(env.Client, podOn(node, "8"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		expectDeleting(node, false)
+	})
+})
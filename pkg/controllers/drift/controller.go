@@ -0,0 +1,140 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drift
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/approval"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+)
+
+const (
+	controllerName = "Drift"
+	// driftInterval is how often a provisioner's nodes are checked
+	driftInterval = 5 * time.Minute
+	// rollInterval is how often a provisioner's nodes are checked while
+	// drifted nodes are being replaced
+	rollInterval = 30 * time.Second
+)
+
+// Controller replaces the drifted nodes of provisioners with a DriftBudget,
+// oldest first, so that changes to a provisioner roll out to the nodes it has
+// already launched. Nodes are marked drifted by the node controller, other
+// controllers and cloud providers. Drifted nodes are only deleted while fewer
+// of the provisioner's nodes than the budget are terminating. Deleted nodes
+// are drained by the termination controller, which respects pod disruption
+// budgets, and their pods are provisioned onto new nodes.
+type Controller struct {
+	kubeClient  client.Client
+	recorder    record.EventRecorder
+	gate        *approval.Gate
+	coordinator *deprovisioning.Coordinator
+}
+
+// NewController constructs a controller instance
+func NewController(kubeClient client.Client, recorder record.EventRecorder, coordinator *deprovisioning.Coordinator) *Controller {
+	return &Controller{
+		kubeClient:  kubeClient,
+		recorder:    recorder,
+		gate:        approval.NewGate(),
+		coordinator: coordinator,
+	}
+}
+
+// Reconcile executes a drift control loop for the provisioner
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(fmt.Sprintf("drift.provisioner/%s", req.Name)))
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if provisioner.Spec.DriftBudget == nil {
+		return reconcile.Result{}, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	terminating := 0
+	drifted := []*v1.Node{}
+	for _, node := range ptr.NodeListToSlice(nodes) {
+		if !node.DeletionTimestamp.IsZero() {
+			terminating++
+			continue
+		}
+		if node.Annotations[v1alpha4.DriftedAnnotationKey] == "true" {
+			drifted = append(drifted, node)
+		}
+	}
+	if len(drifted) == 0 {
+		return reconcile.Result{RequeueAfter: driftInterval}, nil
+	}
+	// Replace the oldest nodes first
+	sort.SliceStable(drifted, func(i, j int) bool {
+		return drifted[i].CreationTimestamp.Before(&drifted[j].CreationTimestamp)
+	})
+	for _, node := range drifted {
+		if terminating >= int(*provisioner.Spec.DriftBudget) {
+			break
+		}
+		if !nodeutil.IsDisruptable(provisioner, node) {
+			continue
+		}
+		// Wait for the node to be approved, rather than replacing another
+		if approved, err := c.gate.ApproveAndPersist(ctx, c.kubeClient, provisioner, node, approval.Drift); err != nil || !approved {
+			return reconcile.Result{RequeueAfter: approval.RetryInterval}, err
+		}
+		if allowed, err := c.coordinator.Allow(ctx, provisioner, node); err != nil || !allowed {
+			return reconcile.Result{RequeueAfter: deprovisioning.RetryInterval}, err
+		}
+		logging.FromContext(ctx).Infof("Triggering termination for drifted node %s", node.Name)
+		c.recorder.Eventf(node, v1.EventTypeNormal, "ReplacingDrifted", "Replacing drifted node")
+		if err := c.kubeClient.Delete(ctx, node); err != nil {
+			return reconcile.Result{}, fmt.Errorf("deleting node %s, %w", node.Name, err)
+		}
+		terminating++
+	}
+	return reconcile.Result{RequeueAfter: rollInterval}, nil
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha4.Provisioner{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
+}
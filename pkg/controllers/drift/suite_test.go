@@ -0,0 +1,152 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drift_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/drift"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/test"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *drift.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Drift")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		controller = drift.NewController(e.Client, record.NewFakeRecorder(100), deprovisioning.NewCoordinator(e.Client))
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Drift", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha4.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name},
+			Spec:       v1alpha4.ProvisionerSpec{DriftBudget: ptr.Int32(1)},
+		}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(env.Client)
+	})
+
+	driftedNode := func() *v1.Node {
+		return test.Node(test.NodeOptions{
+			Finalizers:  []string{v1alpha4.TerminationFinalizer},
+			Labels:      map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			Annotations: map[string]string{v1alpha4.DriftedAnnotationKey: "true"},
+		})
+	}
+
+	It("should ignore nodes that haven't drifted", func() {
+		node := test.Node(test.NodeOptions{
+			Finalizers: []string{v1alpha4.TerminationFinalizer},
+			Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+		})
+		ExpectCreated(env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+	It("should replace drifted nodes", func() {
+		node := driftedNode()
+		ExpectCreated(env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeFalse())
+	})
+	It("should not replace drifted nodes without a budget", func() {
+		provisioner.Spec.DriftBudget = nil
+		node := driftedNode()
+		ExpectCreated(env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+	It("should not replace drifted nodes with a budget of 0", func() {
+		provisioner.Spec.DriftBudget = ptr.Int32(0)
+		node := driftedNode()
+		ExpectCreated(env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+	It("should replace at most the budget of nodes at a time", func() {
+		provisioner.Spec.DriftBudget = ptr.Int32(2)
+		nodes := []*v1.Node{driftedNode(), driftedNode(), driftedNode()}
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreated(env.Client, nodes[0], nodes[1], nodes[2])
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		deleting := 0
+		for _, node := range nodes {
+			if !ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero() {
+				deleting++
+			}
+		}
+		Expect(deleting).To(Equal(2))
+	})
+	It("should not replace nodes with protected jobs", func() {
+		node := driftedNode()
+		node.Status.Conditions = append(node.Status.Conditions, v1.NodeCondition{Type: v1alpha4.NodeJobProtected, Status: v1.ConditionTrue})
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+	It("should wait for approval to replace nodes", func() {
+		provisioner.Spec.DisruptionApproval = &v1alpha4.DisruptionApproval{}
+		node := driftedNode()
+		ExpectCreated(env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, "Drift"))
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+
+		node.Annotations[v1alpha4.DisruptionApprovedAnnotationKey] = "true"
+		Expect(env.Client.Update(ctx, node)).To(Succeed())
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeFalse())
+	})
+})
@@ -0,0 +1,263 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package interruption
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+)
+
+// retryInterval is how long to wait before receiving notices again after the
+// cloud provider fails to return them
+const retryInterval = 10 * time.Second
+
+var interruptionsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "interruption_controller",
+		Name:      "interruptions_total",
+		Help:      "Number of interruption notices received for Karpenter's nodes. Broken down by reason.",
+	},
+	[]string{metrics.ReasonLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(interruptionsCounter)
+}
+
+// Controller receives notices of instances that are about to be interrupted
+// from cloud providers that implement cloudprovider.InterruptionNotifier, and
+// reconciles the interrupted nodes. The node is tainted so that no more pods
+// schedule to it, and deleted so that it's drained and terminated by the
+// termination controller. Replacement capacity is provisioned in advance for
+// its pods by the allocation controller.
+type Controller struct {
+	kubeClient client.Client
+	notifier   cloudprovider.InterruptionNotifier
+	recorder   record.EventRecorder
+	// events enqueues the nodes of received notices
+	events chan event.GenericEvent
+
+	mu sync.Mutex
+	// pending are the received notices that haven't been handled, by node name
+	pending map[string][]*cloudprovider.Interruption
+}
+
+// NewController constructs a controller instance. The controller isn't
+// registered if the cloud provider doesn't notify of interruptions.
+func NewController(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider, recorder record.EventRecorder) *Controller {
+	notifier, _ := cloudProvider.(cloudprovider.InterruptionNotifier)
+	return &Controller{
+		kubeClient: kubeClient,
+		notifier:   notifier,
+		recorder:   recorder,
+		events:     make(chan event.GenericEvent),
+		pending:    map[string][]*cloudprovider.Interruption{},
+	}
+}
+
+// Reconcile taints and deletes the node for its pending interruption notices,
+// and acknowledges the notices once they're handled
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named("Interruption"))
+	interruptions := c.pop(req.Name)
+	if len(interruptions) == 0 {
+		return reconcile.Result{}, nil
+	}
+	node := &v1.Node{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, node); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, c.acknowledge(ctx, interruptions)
+		}
+		c.push(req.Name, interruptions...)
+		return reconcile.Result{}, err
+	}
+	for _, interruption := range interruptions {
+		if err := c.interrupt(ctx, node, interruption); err != nil {
+			// Retry the notices that haven't been acknowledged
+			c.push(req.Name, interruptions...)
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{}, c.acknowledge(ctx, interruptions)
+}
+
+// interrupt taints and deletes the interrupted node
+func (c *Controller) interrupt(ctx context.Context, node *v1.Node, interruption *cloudprovider.Interruption) error {
+	if err := c.taint(ctx, node, interruption); err != nil {
+		return err
+	}
+	if !node.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	logging.FromContext(ctx).Infof("Triggering termination for node %s, received %s for instance %s", node.Name, interruption.Reason, interruption.InstanceID)
+	c.recorder.Eventf(node, v1.EventTypeWarning, string(interruption.Reason), "Draining node, its instance %s is being interrupted", interruption.InstanceID)
+	if err := c.kubeClient.Delete(ctx, node); err != nil {
+		return fmt.Errorf("deleting node %s, %w", node.Name, err)
+	}
+	return nil
+}
+
+func (c *Controller) acknowledge(ctx context.Context, interruptions []*cloudprovider.Interruption) error {
+	for _, interruption := range interruptions {
+		if err := c.notifier.AcknowledgeInterruption(ctx, interruption); err != nil {
+			return fmt.Errorf("acknowledging interruption of instance %s, %w", interruption.InstanceID, err)
+		}
+	}
+	return nil
+}
+
+// taint prevents pods from scheduling to the node, and marks it interrupted so
+// that capacity is provisioned for its pods before they're evicted
+func (c *Controller) taint(ctx context.Context, node *v1.Node, interruption *cloudprovider.Interruption) error {
+	stored := node.DeepCopy()
+	nodeutil.SetCondition(node, v1alpha4.NodeInterrupted, v1.ConditionTrue, string(interruption.Reason), fmt.Sprintf("Instance %s is being interrupted", interruption.InstanceID))
+	if !equality.Semantic.DeepEqual(node.Status, stored.Status) {
+		if err := c.kubeClient.Status().Patch(ctx, node.DeepCopy(), client.StrategicMergeFrom(stored)); err != nil {
+			return fmt.Errorf("patching node status %s, %w", node.Name, err)
+		}
+	}
+	taint := v1.Taint{Key: v1alpha4.InterruptionTaintKey, Effect: v1.TaintEffectNoSchedule}
+	for i := range node.Spec.Taints {
+		if node.Spec.Taints[i].MatchTaint(&taint) {
+			return nil
+		}
+	}
+	node.Spec.Taints = append(node.Spec.Taints, taint)
+	if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+		return fmt.Errorf("patching node %s, %w", node.Name, err)
+	}
+	return nil
+}
+
+// nodeFor returns the Karpenter node whose provider id ends with the instance
+// id, or nil if there isn't one
+func (c *Controller) nodeFor(ctx context.Context, instanceID string) (*v1.Node, error) {
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.HasLabels{v1alpha4.ProvisionerNameLabelKey}); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	for i := range nodes.Items {
+		if strings.HasSuffix(nodes.Items[i].Spec.ProviderID, "/"+instanceID) {
+			return &nodes.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Receive enqueues the nodes of interruption notices until the context is done
+func (c *Controller) Receive(ctx context.Context) {
+	for {
+		interruptions, err := c.notifier.GetInterruptions(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Failed to receive interruption notices, %s", err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+		for _, interruption := range interruptions {
+			if err := c.enqueue(ctx, interruption); err != nil {
+				// Notices that aren't acknowledged are received again
+				logging.FromContext(ctx).Errorf("Failed to handle interruption of instance %s, %s", interruption.InstanceID, err.Error())
+			}
+		}
+	}
+}
+
+// enqueue queues the notice for the interrupted node to be reconciled. Notices
+// for instances that aren't Karpenter's nodes are acknowledged and ignored.
+func (c *Controller) enqueue(ctx context.Context, interruption *cloudprovider.Interruption) error {
+	node, err := c.nodeFor(ctx, interruption.InstanceID)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		logging.FromContext(ctx).Debugf("Ignoring %s for instance %s, not one of Karpenter's nodes", interruption.Reason, interruption.InstanceID)
+		return c.notifier.AcknowledgeInterruption(ctx, interruption)
+	}
+	interruptionsCounter.WithLabelValues(string(interruption.Reason)).Inc()
+	c.push(node.Name, interruption)
+	select {
+	case c.events <- event.GenericEvent{Object: node}:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (c *Controller) push(name string, interruptions ...*cloudprovider.Interruption) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[name] = append(c.pending[name], interruptions...)
+}
+
+func (c *Controller) pop(name string) []*cloudprovider.Interruption {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	interruptions := c.pending[name]
+	delete(c.pending, name)
+	return interruptions
+}
+
+func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
+	if c.notifier == nil {
+		return nil
+	}
+	if err := controllerruntime.
+		NewControllerManagedBy(m).
+		Named("Interruption").
+		// Nodes are only reconciled once interruption notices are received
+		For(&v1.Node{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(client.Object) bool { return false }))).
+		Watches(&source.Channel{Source: c.events}, &handler.EnqueueRequestForObject{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(metrics.NewInstrumentedReconciler("Interruption", c)); err != nil {
+		return err
+	}
+	return m.Add(manager.RunnableFunc(func(runCtx context.Context) error {
+		c.Receive(logging.WithLogger(runCtx, logging.FromContext(ctx).Named("Interruption")))
+		return nil
+	}))
+}
@@ -0,0 +1,121 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package interruption_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/registry"
+	"github.com/awslabs/karpenter/pkg/controllers/interruption"
+	"github.com/awslabs/karpenter/pkg/test"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *interruption.Controller
+var cloudProvider *fake.CloudProvider
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Interruption")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider = &fake.CloudProvider{}
+		registry.RegisterOrDie(ctx, cloudProvider)
+		controller = interruption.NewController(e.Client, cloudProvider, record.NewFakeRecorder(100))
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Interruption", func() {
+	var receiveCtx context.Context
+	var cancel context.CancelFunc
+	BeforeEach(func() {
+		cloudProvider.Reset()
+		receiveCtx, cancel = context.WithCancel(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+		ExpectCleanedUp(env.Client)
+	})
+
+	nodeFor := func(instanceID string) *v1.Node {
+		return test.Node(test.NodeOptions{
+			Finalizers: []string{v1alpha4.TerminationFinalizer},
+			Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: v1alpha4.DefaultProvisioner.Name},
+			ProviderID: "fake:///test-zone/" + instanceID,
+		})
+	}
+	receive := func(interruptions ...*cloudprovider.Interruption) {
+		cloudProvider.Interruptions = interruptions
+		go controller.Receive(receiveCtx)
+	}
+
+	It("should taint and delete interrupted nodes", func() {
+		node := nodeFor("test-instance")
+		ExpectCreatedWithStatus(env.Client, node)
+		interruption := &cloudprovider.Interruption{InstanceID: "test-instance", Reason: cloudprovider.SpotInterruption}
+		receive(interruption)
+		Eventually(func() bool {
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			return !ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()
+		}).Should(BeTrue())
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.Spec.Taints).To(ContainElement(v1.Taint{Key: v1alpha4.InterruptionTaintKey, Effect: v1.TaintEffectNoSchedule}))
+		condition := nodeutil.GetCondition(node.Status.Conditions, v1alpha4.NodeInterrupted)
+		Expect(condition.Status).To(Equal(v1.ConditionTrue))
+		Expect(condition.Reason).To(Equal(string(cloudprovider.SpotInterruption)))
+		Expect(cloudProvider.Acknowledgements()).To(ConsistOf(interruption))
+	})
+	It("should acknowledge interruptions of instances that aren't Karpenter's nodes", func() {
+		node := nodeFor("test-instance")
+		ExpectCreatedWithStatus(env.Client, node)
+		interruption := &cloudprovider.Interruption{InstanceID: "other-instance", Reason: cloudprovider.RebalanceRecommendation}
+		receive(interruption)
+		Eventually(func() []*cloudprovider.Interruption { return cloudProvider.Acknowledgements() }).Should(ConsistOf(interruption))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+	It("should not reconcile nodes without interruptions", func() {
+		node := nodeFor("test-instance")
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+		Expect(node.Spec.Taints).To(BeEmpty())
+	})
+})
@@ -16,10 +16,11 @@ package node
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
-	v1 "k8s.io/api/core/v1"
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"knative.dev/pkg/logging"
 	controllerruntime "sigs.k8s.io/controller-runtime"
@@ -28,6 +29,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -48,36 +50,39 @@ func NewController(kubeClient client.Client) *Controller {
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName))
 
-	provisionerName := req.NamespacedName.Name
-
 	// 1. Has the provisioner been deleted?
-	if err := c.provisionerExists(ctx, req); err != nil {
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.KubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
 		if !errors.IsNotFound(err) {
 			// Unable to determine existence of the provisioner, try again later.
 			return reconcile.Result{Requeue: true}, err
 		}
 
-		// The provisioner has been deleted. Reset all the associated counts to zero.
-		if err := publishNodeCountsForProvisioner(provisionerName, consumeZeroNodes); err != nil {
-			// One or more metrics were not zeroed. Try again later.
-			return reconcile.Result{Requeue: true}, err
-		}
+		// The provisioner has been deleted. Node counts are collected at
+		// scrape time, so only the remaining metrics need to be removed.
+		deleteUtilizationForProvisioner(req.Name)
+		deleteFragmentationForProvisioner(req.Name)
 
 		// Since the provisioner is gone, do not requeue.
 		return reconcile.Result{}, nil
 	}
 
-	// 2. Update node counts associated with this provisioner.
-	if err := publishNodeCountsForProvisioner(provisionerName, c.consumeNodesWith(ctx)); err != nil {
-		// An updated value for one or more metrics was not published. Try again later.
+	// 2. Update the utilization and fragmentation of the provisioner's nodes.
+	nodes, podsByNode, pending, err := c.nodesAndPodsFor(ctx, provisioner)
+	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
+	publishUtilizationForProvisioner(provisioner, nodes, podsByNode)
+	publishFragmentationForProvisioner(provisioner.Name, nodes, podsByNode, pending)
 
 	// 3. Schedule the next run.
 	return reconcile.Result{RequeueAfter: requeueInterval}, nil
 }
 
 func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	if err := crmetrics.Registry.Register(newNodeCollector(c.KubeClient)); err != nil {
+		return fmt.Errorf("registering node collector, %w", err)
+	}
 	return controllerruntime.
 		NewControllerManagedBy(m).
 		Named(controllerName).
@@ -92,30 +97,5 @@ func (c *Controller) Register(_ context.Context, m manager.Manager) error {
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: 1,
 		}).
-		Complete(c)
-}
-
-// provisionerExists simply attempts to retrieve the provisioner from the Controller's Client
-// and returns any resulting error.
-func (c *Controller) provisionerExists(ctx context.Context, req reconcile.Request) error {
-	provisioner := v1alpha4.Provisioner{}
-	return c.KubeClient.Get(ctx, req.NamespacedName, &provisioner)
-}
-
-// consumeNodesWith will retrieve matching nodes from the Controller's Client then
-// pass the nodes to `consume` and returns any resulting error. If Client returns an error when
-// retrieving nodes then the error is returned without calling `consume`.
-func (c *Controller) consumeNodesWith(ctx context.Context) consumeNodesWithFunc {
-	return func(nodeLabels client.MatchingLabels, consume nodeListConsumerFunc) error {
-		nodes := v1.NodeList{}
-		if err := c.KubeClient.List(ctx, &nodes, nodeLabels); err != nil {
-			return err
-		}
-		return consume(nodes.Items)
-	}
-}
-
-// consumeZeroNodes calls `consume` with an empty slice and returns any resulting error.
-func consumeZeroNodes(_ client.MatchingLabels, consume nodeListConsumerFunc) error {
-	return consume([]v1.Node{})
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
 }
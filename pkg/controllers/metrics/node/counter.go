@@ -15,15 +15,16 @@ limitations under the License.
 package node
 
 import (
-	"strings"
+	"context"
+	"fmt"
+	"sort"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/metrics"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
 	"github.com/prometheus/client_golang/prometheus"
-	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const (
@@ -40,196 +41,147 @@ const (
 	nodeLabelInstanceType = v1.LabelInstanceTypeStable
 	nodeLabelOS           = v1.LabelOSStable
 	nodeLabelZone         = v1.LabelTopologyZone
-
-	nodeConditionTypeReady = v1.NodeReady
 )
 
-type (
-	nodeListConsumerFunc = func([]v1.Node) error
-	consumeNodesWithFunc = func(client.MatchingLabels, nodeListConsumerFunc) error
-)
+var nodeLabelProvisioner = v1alpha4.ProvisionerNameLabelKey
 
-var (
-	nodeLabelProvisioner = v1alpha4.ProvisionerNameLabelKey
-
-	knownValuesForNodeLabels = v1alpha4.WellKnownLabels
-
-	nodeCountByProvisioner = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: metricNamespace,
-			Subsystem: metricSubsystem,
-			Name:      "node_count",
-			Help:      "Total node count by provisioner.",
-		},
-		[]string{
-			metricLabelProvisioner,
-		},
-	)
-
-	readyNodeCountByProvisionerZone = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: metricNamespace,
-			Subsystem: metricSubsystem,
-			Name:      "ready_node_count",
-			Help:      "Count of nodes that are ready by provisioner and zone.",
-		},
-		[]string{
-			metricLabelProvisioner,
-			metricLabelZone,
-		},
-	)
-
-	readyNodeCountByArchProvisionerZone = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: metricNamespace,
-			Subsystem: metricSubsystem,
-			Name:      "ready_node_arch_count",
-			Help:      "Count of nodes that are ready by architecture, provisioner, and zone.",
-		},
-		[]string{
-			metricLabelArch,
-			metricLabelProvisioner,
-			metricLabelZone,
-		},
-	)
-
-	readyNodeCountByInstancetypeProvisionerZone = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: metricNamespace,
-			Subsystem: metricSubsystem,
-			Name:      "ready_node_instancetype_count",
-			Help:      "Count of nodes that are ready by instance type, provisioner, and zone.",
-		},
-		[]string{
-			metricLabelInstanceType,
-			metricLabelProvisioner,
-			metricLabelZone,
-		},
-	)
-
-	readyNodeCountByOsProvisionerZone = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: metricNamespace,
-			Subsystem: metricSubsystem,
-			Name:      "ready_node_os_count",
-			Help:      "Count of nodes that are ready by operating system, provisioner, and zone.",
-		},
-		[]string{
-			metricLabelOS,
-			metricLabelProvisioner,
-			metricLabelZone,
-		},
-	)
-)
+// nodeCount is a node count published by the collector. Ready node counts
+// are partitioned by zone and, optionally, one further node label.
+type nodeCount struct {
+	name      string
+	help      string
+	ready     bool
+	nodeLabel string
+	label     string
+}
 
-func init() {
-	crmetrics.Registry.MustRegister(nodeCountByProvisioner)
-	crmetrics.Registry.MustRegister(readyNodeCountByProvisionerZone)
-	crmetrics.Registry.MustRegister(readyNodeCountByArchProvisionerZone)
-	crmetrics.Registry.MustRegister(readyNodeCountByInstancetypeProvisionerZone)
-	crmetrics.Registry.MustRegister(readyNodeCountByOsProvisionerZone)
+var nodeCounts = []nodeCount{
+	{name: "node_count", help: "Total node count by provisioner."},
+	{name: "ready_node_count", help: "Count of nodes that are ready by provisioner and zone.", ready: true},
+	{name: "ready_node_arch_count", help: "Count of nodes that are ready by architecture, provisioner, and zone.", ready: true, nodeLabel: nodeLabelArch, label: metricLabelArch},
+	{name: "ready_node_instancetype_count", help: "Count of nodes that are ready by instance type, provisioner, and zone.", ready: true, nodeLabel: nodeLabelInstanceType, label: metricLabelInstanceType},
+	{name: "ready_node_os_count", help: "Count of nodes that are ready by operating system, provisioner, and zone.", ready: true, nodeLabel: nodeLabelOS, label: metricLabelOS},
 }
 
-func publishNodeCountsForProvisioner(provisioner string, consumeNodesWith consumeNodesWithFunc) error {
-	archValues := knownValuesForNodeLabels[nodeLabelArch]
-	instanceTypeValues := knownValuesForNodeLabels[nodeLabelInstanceType]
-	osValues := knownValuesForNodeLabels[nodeLabelOS]
-	zoneValues := knownValuesForNodeLabels[nodeLabelZone]
+// labelsFor returns the metric labels of the node for the count, or false if
+// the node isn't counted
+func (n nodeCount) labelsFor(node *v1.Node) (prometheus.Labels, bool) {
+	labels := prometheus.Labels{metricLabelProvisioner: node.Labels[nodeLabelProvisioner]}
+	if !n.ready {
+		return labels, true
+	}
+	if !nodeutil.IsReady(node) || node.Labels[nodeLabelZone] == "" {
+		return nil, false
+	}
+	labels[metricLabelZone] = node.Labels[nodeLabelZone]
+	if n.nodeLabel != "" {
+		if node.Labels[n.nodeLabel] == "" {
+			return nil, false
+		}
+		labels[n.label] = node.Labels[n.nodeLabel]
+	}
+	return labels, true
+}
 
-	errors := make([]error, 0, len(archValues)*len(instanceTypeValues)*len(osValues)*len(zoneValues))
+// nodeCollector counts the nodes of each provisioner when metrics are
+// scraped. Nodes are listed once per scrape from the informer cache, and
+// series are only published for label values that nodes actually have, so
+// series of deleted provisioners or nodes don't linger. Attribution labels
+// vary between provisioners, so the collector is unchecked.
+type nodeCollector struct {
+	kubeClient client.Client
+}
 
-	nodeLabels := client.MatchingLabels{nodeLabelProvisioner: provisioner}
-	errors = append(errors, consumeNodesWith(nodeLabels, func(nodes []v1.Node) error {
-		return publishCount(nodeCountByProvisioner, metricLabelsFrom(nodeLabels), len(nodes))
-	}))
+func newNodeCollector(kubeClient client.Client) *nodeCollector {
+	return &nodeCollector{kubeClient: kubeClient}
+}
 
-	for _, zone := range zoneValues {
-		nodeLabels = client.MatchingLabels{
-			nodeLabelProvisioner: provisioner,
-			nodeLabelZone:        zone,
-		}
-		errors = append(errors, consumeNodesWith(nodeLabels, filterReadyNodes(func(readyNodes []v1.Node) error {
-			return publishCount(readyNodeCountByProvisionerZone, metricLabelsFrom(nodeLabels), len(readyNodes))
-		})))
-
-		for _, arch := range archValues {
-			nodeLabels := client.MatchingLabels{
-				nodeLabelArch:        arch,
-				nodeLabelProvisioner: provisioner,
-				nodeLabelZone:        zone,
+// Describe implements prometheus.Collector. No descriptors are sent, since
+// label names vary between provisioners.
+func (c *nodeCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (c *nodeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	provisioners := &v1alpha4.ProvisionerList{}
+	if err := c.kubeClient.List(ctx, provisioners); err != nil {
+		ch <- prometheus.NewInvalidMetric(descFor(nodeCounts[0], nil), fmt.Errorf("listing provisioners, %w", err))
+		return
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.HasLabels{nodeLabelProvisioner}); err != nil {
+		ch <- prometheus.NewInvalidMetric(descFor(nodeCounts[0], nil), fmt.Errorf("listing nodes, %w", err))
+		return
+	}
+	attributions := map[string]prometheus.Labels{}
+	for _, provisioner := range provisioners.Items {
+		attributions[provisioner.Name] = metrics.AttributionLabels(provisioner.Spec.MetricLabels, provisioner.Labels)
+	}
+	for _, count := range nodeCounts {
+		counts := map[string]*countedLabels{}
+		// Publish zero nodes for provisioners without any
+		if !count.ready {
+			for name := range attributions {
+				labels := prometheus.Labels{metricLabelProvisioner: name}
+				counts[keyOf(labels)] = &countedLabels{labels: labels}
 			}
-			errors = append(errors, consumeNodesWith(nodeLabels, filterReadyNodes(func(readyNodes []v1.Node) error {
-				return publishCount(readyNodeCountByArchProvisionerZone, metricLabelsFrom(nodeLabels), len(readyNodes))
-			})))
 		}
-
-		for _, instanceType := range instanceTypeValues {
-			nodeLabels := client.MatchingLabels{
-				nodeLabelInstanceType: instanceType,
-				nodeLabelProvisioner:  provisioner,
-				nodeLabelZone:         zone,
+		for i := range nodes.Items {
+			labels, ok := count.labelsFor(&nodes.Items[i])
+			if !ok {
+				continue
+			}
+			key := keyOf(labels)
+			if _, ok := counts[key]; !ok {
+				counts[key] = &countedLabels{labels: labels}
 			}
-			errors = append(errors, consumeNodesWith(nodeLabels, filterReadyNodes(func(readyNodes []v1.Node) error {
-				return publishCount(readyNodeCountByInstancetypeProvisionerZone, metricLabelsFrom(nodeLabels), len(readyNodes))
-			})))
+			counts[key].count++
 		}
-
-		for _, os := range osValues {
-			nodeLabels := client.MatchingLabels{
-				nodeLabelOS:          os,
-				nodeLabelProvisioner: provisioner,
-				nodeLabelZone:        zone,
+		for _, counted := range counts {
+			attribution, ok := attributions[counted.labels[metricLabelProvisioner]]
+			if !ok {
+				// The provisioner was deleted
+				continue
+			}
+			all := prometheus.Labels{}
+			for name, value := range attribution {
+				all[name] = value
 			}
-			errors = append(errors, consumeNodesWith(nodeLabels, filterReadyNodes(func(readyNodes []v1.Node) error {
-				return publishCount(readyNodeCountByOsProvisionerZone, metricLabelsFrom(nodeLabels), len(readyNodes))
-			})))
+			for name, value := range counted.labels {
+				all[name] = value
+			}
+			names := sortedNames(all)
+			values := make([]string, 0, len(names))
+			for _, name := range names {
+				values = append(values, all[name])
+			}
+			ch <- prometheus.MustNewConstMetric(descFor(count, names), prometheus.GaugeValue, float64(counted.count), values...)
 		}
 	}
+}
 
-	return multierr.Combine(errors...)
+type countedLabels struct {
+	labels prometheus.Labels
+	count  int
 }
 
-// filterReadyNodes returns a new function that will filter "ready" nodes to pass on
-// to `consume`, and returns the result.
-func filterReadyNodes(consume nodeListConsumerFunc) nodeListConsumerFunc {
-	return func(nodes []v1.Node) error {
-		readyNodes := make([]v1.Node, 0, len(nodes))
-		for _, node := range nodes {
-			for _, condition := range node.Status.Conditions {
-				if condition.Type == nodeConditionTypeReady && strings.ToLower(string(condition.Status)) == "true" {
-					readyNodes = append(readyNodes, node)
-				}
-			}
-		}
-		return consume(readyNodes)
-	}
+func descFor(count nodeCount, labelNames []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(metricNamespace, metricSubsystem, count.name), count.help, labelNames, nil)
 }
 
-func metricLabelsFrom(nodeLabels client.MatchingLabels) prometheus.Labels {
-	metricLabels := prometheus.Labels{}
-	// Exclude node label values that not present or are empty strings.
-	if arch := nodeLabels[nodeLabelArch]; arch != "" {
-		metricLabels[metricLabelArch] = arch
-	}
-	if instanceType := nodeLabels[nodeLabelInstanceType]; instanceType != "" {
-		metricLabels[metricLabelInstanceType] = instanceType
-	}
-	if os := nodeLabels[nodeLabelOS]; os != "" {
-		metricLabels[metricLabelOS] = os
-	}
-	if provisioner := nodeLabels[nodeLabelProvisioner]; provisioner != "" {
-		metricLabels[metricLabelProvisioner] = provisioner
-	}
-	if zone := nodeLabels[nodeLabelZone]; zone != "" {
-		metricLabels[metricLabelZone] = zone
+func keyOf(labels prometheus.Labels) string {
+	key := ""
+	for _, name := range sortedNames(labels) {
+		key += name + "\xff" + labels[name] + "\xff"
 	}
-	return metricLabels
+	return key
 }
 
-func publishCount(gaugeVec *prometheus.GaugeVec, labels prometheus.Labels, count int) error {
-	gauge, err := gaugeVec.GetMetricWith(labels)
-	if err == nil {
-		gauge.Set(float64(count))
+func sortedNames(labels prometheus.Labels) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
 	}
-	return err
+	sort.Strings(names)
+	return names
 }
@@ -0,0 +1,160 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	largestSchedulablePod = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "largest_schedulable_pod",
+			Help:      "Largest resource request that fits in the unrequested resources of any of the provisioner's nodes, in cores or bytes, by provisioner and resource.",
+		},
+		[]string{metricLabelProvisioner, metricLabelResource},
+	)
+
+	strandedResources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "stranded",
+			Help:      "Unrequested resources of the provisioner's nodes that no pending or scheduled pod's requests fit in, in cores or bytes, by provisioner and resource.",
+		},
+		[]string{metricLabelProvisioner, metricLabelResource},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(largestSchedulablePod)
+	crmetrics.Registry.MustRegister(strandedResources)
+}
+
+// publishFragmentationForProvisioner publishes how the unrequested resources
+// of the provisioner's nodes are fragmented. A node's unrequested resources
+// are stranded if the node can't fit additional pods, or if they can't fit the
+// requests of any pod shape, i.e. those of pending pods and of pods scheduled
+// to the provisioner's nodes.
+func publishFragmentationForProvisioner(provisioner string, nodes []v1.Node, podsByNode map[string][]*v1.Pod, pending []*v1.Pod) {
+	shapes := shapesFor(pending, podsByNode)
+	largest := v1.ResourceList{}
+	stranded := v1.ResourceList{}
+	for _, node := range nodes {
+		available := availableFor(node, podsByNode[node.Name])
+		podCapacity := node.Status.Allocatable[v1.ResourcePods]
+		if podCapacity.Value() <= int64(len(podsByNode[node.Name])) {
+			stranded = resources.Merge(stranded, available)
+			continue
+		}
+		for _, resourceName := range utilizationResources {
+			if quantity := available[resourceName]; quantity.Cmp(largest[resourceName]) > 0 {
+				largest[resourceName] = quantity
+			}
+		}
+		if !fitsAny(shapes, available) {
+			stranded = resources.Merge(stranded, available)
+		}
+	}
+	for _, resourceName := range utilizationResources {
+		largestQuantity, strandedQuantity := largest[resourceName], stranded[resourceName]
+		largestSchedulablePod.WithLabelValues(provisioner, string(resourceName)).Set(float64(largestQuantity.MilliValue()) / 1000)
+		strandedResources.WithLabelValues(provisioner, string(resourceName)).Set(float64(strandedQuantity.MilliValue()) / 1000)
+	}
+}
+
+// availableFor returns the node's unrequested cpu and memory
+func availableFor(node v1.Node, pods []*v1.Pod) v1.ResourceList {
+	requests := resources.RequestsForPods(pods...)
+	available := v1.ResourceList{}
+	for _, resourceName := range utilizationResources {
+		quantity := node.Status.Allocatable[resourceName]
+		quantity.Sub(requests[resourceName])
+		if quantity.Sign() > 0 {
+			available[resourceName] = quantity
+		}
+	}
+	return available
+}
+
+// shapesFor returns the distinct cpu and memory requests of the pods
+func shapesFor(pending []*v1.Pod, podsByNode map[string][]*v1.Pod) []v1.ResourceList {
+	pods := append([]*v1.Pod{}, pending...)
+	for _, scheduled := range podsByNode {
+		pods = append(pods, scheduled...)
+	}
+	shapes := []v1.ResourceList{}
+	for _, pod := range pods {
+		requests := resources.RequestsForPods(pod)
+		shape := v1.ResourceList{}
+		for _, resourceName := range utilizationResources {
+			if quantity, ok := requests[resourceName]; ok {
+				shape[resourceName] = quantity
+			}
+		}
+		// Pods without requests don't consume the resources
+		if len(shape) == 0 {
+			continue
+		}
+		if !containsShape(shapes, shape) {
+			shapes = append(shapes, shape)
+		}
+	}
+	return shapes
+}
+
+func containsShape(shapes []v1.ResourceList, shape v1.ResourceList) bool {
+	for _, s := range shapes {
+		if equality.Semantic.DeepEqual(s, shape) {
+			return true
+		}
+	}
+	return false
+}
+
+// fitsAny returns true if any of the shapes fit in the available resources.
+// Without available resources or known shapes, nothing is stranded.
+func fitsAny(shapes []v1.ResourceList, available v1.ResourceList) bool {
+	if len(available) == 0 || len(shapes) == 0 {
+		return true
+	}
+	for _, shape := range shapes {
+		fits := true
+		for resourceName, quantity := range shape {
+			if quantity.Cmp(available[resourceName]) > 0 {
+				fits = false
+			}
+		}
+		if fits {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteFragmentationForProvisioner removes the fragmentation metrics of a
+// deleted provisioner
+func deleteFragmentationForProvisioner(provisioner string) {
+	for _, resourceName := range utilizationResources {
+		largestSchedulablePod.DeleteLabelValues(provisioner, string(resourceName))
+		strandedResources.DeleteLabelValues(provisioner, string(resourceName))
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/test"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var ctx context.Context
+var reconciler *Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Node Metrics")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		reconciler = NewController(e.Client)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+	crmetrics.Registry.MustRegister(newNodeCollector(env.Client))
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Node Metrics", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha4.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name}}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(env.Client)
+	})
+
+	nodeIn := func(zone string, ready v1.ConditionStatus) *v1.Node {
+		return test.Node(test.NodeOptions{
+			ReadyStatus: ready,
+			Labels: map[string]string{
+				v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelTopologyZone:             zone,
+				v1.LabelInstanceTypeStable:       "test-instance-type",
+			},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("4Gi")},
+		})
+	}
+
+	Context("Counts", func() {
+		It("should count nodes by provisioner", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client,
+				nodeIn("test-zone-1", v1.ConditionTrue),
+				nodeIn("test-zone-1", v1.ConditionTrue),
+				nodeIn("test-zone-2", v1.ConditionFalse),
+			)
+			ExpectMetricGaugeValue("karpenter_capacity_node_count", 3, map[string]string{metricLabelProvisioner: provisioner.Name})
+		})
+		It("should count ready nodes by zone", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client,
+				nodeIn("test-zone-1", v1.ConditionTrue),
+				nodeIn("test-zone-1", v1.ConditionTrue),
+				nodeIn("test-zone-2", v1.ConditionFalse),
+			)
+			ExpectMetricGaugeValue("karpenter_capacity_ready_node_count", 2, map[string]string{metricLabelProvisioner: provisioner.Name, metricLabelZone: "test-zone-1"})
+			ExpectMetricGaugeValue("karpenter_capacity_ready_node_instancetype_count", 2, map[string]string{
+				metricLabelProvisioner: provisioner.Name, metricLabelZone: "test-zone-1", metricLabelInstanceType: "test-instance-type",
+			})
+			ExpectMetricAbsent("karpenter_capacity_ready_node_count", map[string]string{metricLabelProvisioner: provisioner.Name, metricLabelZone: "test-zone-2"})
+		})
+		It("should publish zero nodes for provisioners without nodes", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectMetricGaugeValue("karpenter_capacity_node_count", 0, map[string]string{metricLabelProvisioner: provisioner.Name})
+		})
+		It("should not count nodes of deleted provisioners", func() {
+			ExpectCreatedWithStatus(env.Client, nodeIn("test-zone-1", v1.ConditionTrue))
+			ExpectMetricAbsent("karpenter_capacity_node_count", map[string]string{metricLabelProvisioner: provisioner.Name})
+		})
+	})
+	Context("Utilization", func() {
+		It("should publish the requests utilization of the provisioner's nodes", func() {
+			ExpectCreated(env.Client, provisioner)
+			node := nodeIn("test-zone-1", v1.ConditionTrue)
+			ExpectCreatedWithStatus(env.Client, node)
+			ExpectCreatedWithStatus(env.Client, test.Pod(test.PodOptions{
+				NodeName:             node.Name,
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+			}))
+			ExpectReconcileSucceeded(ctx, reconciler, client.ObjectKeyFromObject(provisioner))
+			ExpectMetricGaugeValue("karpenter_capacity_requests_utilization", 0.25, map[string]string{
+				metricLabelProvisioner: provisioner.Name, metricLabelResource: string(v1.ResourceCPU),
+			})
+		})
+		It("should remove the utilization of deleted provisioners", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, nodeIn("test-zone-1", v1.ConditionTrue))
+			ExpectReconcileSucceeded(ctx, reconciler, client.ObjectKeyFromObject(provisioner))
+			ExpectMetricGaugeValue("karpenter_capacity_requests_utilization", 0, map[string]string{
+				metricLabelProvisioner: provisioner.Name, metricLabelResource: string(v1.ResourceCPU),
+			})
+			ExpectDeleted(env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, reconciler, client.ObjectKeyFromObject(provisioner))
+			ExpectMetricAbsent("karpenter_capacity_requests_utilization", map[string]string{metricLabelProvisioner: provisioner.Name})
+		})
+	})
+})
@@ -0,0 +1,136 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/pod"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricLabelResource = "resource"
+
+var (
+	utilizationResources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+
+	overcommitLimitsPercent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "overcommit_limits_percent",
+			Help:      "Configured percentage of pods' limits in excess of their requests that's reserved when binpacking, by provisioner.",
+		},
+		[]string{metricLabelProvisioner},
+	)
+
+	requestsUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "requests_utilization",
+			Help:      "Ratio of the resource requests of pods to the allocatable resources of nodes, by provisioner and resource.",
+		},
+		[]string{metricLabelProvisioner, metricLabelResource},
+	)
+
+	limitsUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "limits_utilization",
+			Help:      "Ratio of the resource limits of pods to the allocatable resources of nodes, by provisioner and resource. Values above 1 are overcommitted.",
+		},
+		[]string{metricLabelProvisioner, metricLabelResource},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(overcommitLimitsPercent)
+	crmetrics.Registry.MustRegister(requestsUtilization)
+	crmetrics.Registry.MustRegister(limitsUtilization)
+}
+
+// publishUtilizationForProvisioner publishes the configured overcommit of the
+// provisioner alongside the actual utilization of its nodes by pods
+func publishUtilizationForProvisioner(provisioner *v1alpha4.Provisioner, nodes []v1.Node, podsByNode map[string][]*v1.Pod) {
+	overcommitLimitsPercent.WithLabelValues(provisioner.Name).Set(float64(provisioner.Spec.Overcommit.GetLimitsPercent()))
+
+	allocatable := []v1.ResourceList{}
+	scheduled := []*v1.Pod{}
+	for _, node := range nodes {
+		allocatable = append(allocatable, node.Status.Allocatable)
+		scheduled = append(scheduled, podsByNode[node.Name]...)
+	}
+	total := resources.Merge(allocatable...)
+	requests := resources.RequestsForPods(scheduled...)
+	limits := resources.LimitsForPods(scheduled...)
+	for _, resourceName := range utilizationResources {
+		quantity := total[resourceName]
+		if quantity.IsZero() {
+			requestsUtilization.DeleteLabelValues(provisioner.Name, string(resourceName))
+			limitsUtilization.DeleteLabelValues(provisioner.Name, string(resourceName))
+			continue
+		}
+		requested, limited := requests[resourceName], limits[resourceName]
+		requestsUtilization.WithLabelValues(provisioner.Name, string(resourceName)).Set(float64(requested.MilliValue()) / float64(quantity.MilliValue()))
+		limitsUtilization.WithLabelValues(provisioner.Name, string(resourceName)).Set(float64(limited.MilliValue()) / float64(quantity.MilliValue()))
+	}
+}
+
+// nodesAndPodsFor returns the provisioner's nodes, their active pods by node
+// name, and the pods that are pending scheduling anywhere in the cluster
+func (c *Controller) nodesAndPodsFor(ctx context.Context, provisioner *v1alpha4.Provisioner) ([]v1.Node, map[string][]*v1.Pod, []*v1.Pod, error) {
+	nodes := &v1.NodeList{}
+	if err := c.KubeClient.List(ctx, nodes, client.MatchingLabels{nodeLabelProvisioner: provisioner.Name}); err != nil {
+		return nil, nil, nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	nodeNames := map[string]bool{}
+	for _, node := range nodes.Items {
+		nodeNames[node.Name] = true
+	}
+	pods := &v1.PodList{}
+	if err := c.KubeClient.List(ctx, pods); err != nil {
+		return nil, nil, nil, fmt.Errorf("listing pods, %w", err)
+	}
+	podsByNode := map[string][]*v1.Pod{}
+	pending := []*v1.Pod{}
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if nodeNames[p.Spec.NodeName] && !pod.HasFailed(p) && p.Status.Phase != v1.PodSucceeded {
+			podsByNode[p.Spec.NodeName] = append(podsByNode[p.Spec.NodeName], p)
+		}
+		if pod.FailedToSchedule(p) {
+			pending = append(pending, p)
+		}
+	}
+	return nodes.Items, podsByNode, pending, nil
+}
+
+// deleteUtilizationForProvisioner removes the utilization metrics of a
+// deleted provisioner
+func deleteUtilizationForProvisioner(provisioner string) {
+	overcommitLimitsPercent.DeleteLabelValues(provisioner)
+	for _, resourceName := range utilizationResources {
+		requestsUtilization.DeleteLabelValues(provisioner, string(resourceName))
+		limitsUtilization.DeleteLabelValues(provisioner, string(resourceName))
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation"
+	"github.com/awslabs/karpenter/pkg/controllers/allocation/scheduling"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	controllerName  = "PodMetrics"
+	requeueInterval = 10 * time.Second
+
+	metricSubsystem = "pods"
+)
+
+var (
+	pendingCount = metrics.NewAttributedGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "pending_count",
+			Help:      "Count of unschedulable pods that the provisioner is responsible for provisioning.",
+		},
+	)
+	oldestPendingAge = metrics.NewAttributedGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "oldest_pending_age_seconds",
+			Help:      "Age of the oldest unschedulable pod that the provisioner is responsible for provisioning, or zero if there are none.",
+		},
+	)
+	invalidConstraintsCount = metrics.NewAttributedGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "invalid_constraints_count",
+			Help:      "Count of unschedulable pods that the provisioner ignores because their scheduling constraints are invalid.",
+		},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(pendingCount)
+	crmetrics.Registry.MustRegister(oldestPendingAge)
+	crmetrics.Registry.MustRegister(invalidConstraintsCount)
+}
+
+// Controller publishes metrics describing the pending pods that each
+// provisioner is responsible for, so that provisioning stalls can be alerted on
+type Controller struct {
+	KubeClient client.Client
+	Filter     *allocation.Filter
+}
+
+func NewController(kubeClient client.Client) *Controller {
+	return &Controller{KubeClient: kubeClient, Filter: &allocation.Filter{KubeClient: kubeClient}}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName))
+	labels := prometheus.Labels{metrics.ProvisionerLabel: req.Name}
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.KubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			pendingCount.Delete(labels)
+			oldestPendingAge.Delete(labels)
+			invalidConstraintsCount.Delete(labels)
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	pods, err := c.Filter.GetProvisionablePods(ctx, provisioner)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("filtering pods, %w", err)
+	}
+	invalid := 0
+	oldest := time.Duration(0)
+	for _, pod := range pods {
+		if _, err := scheduling.NewConstraints(ctx, &provisioner.Spec.Constraints, pod); err != nil {
+			invalid++
+		}
+		if age := injectabletime.Now().Sub(pod.CreationTimestamp.Time); age > oldest {
+			oldest = age
+		}
+	}
+	attribution := metrics.AttributionLabels(provisioner.Spec.MetricLabels, provisioner.Labels)
+	pendingCount.Set(labels, attribution, float64(len(pods)))
+	oldestPendingAge.Set(labels, attribution, oldest.Seconds())
+	invalidConstraintsCount.Set(labels, attribution, float64(invalid))
+	return reconcile.Result{RequeueAfter: requeueInterval}, nil
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha4.Provisioner{}, builder.WithPredicates(
+			predicate.Funcs{
+				CreateFunc:  func(_ event.CreateEvent) bool { return true },
+				DeleteFunc:  func(_ event.DeleteEvent) bool { return true },
+				UpdateFunc:  func(_ event.UpdateEvent) bool { return false },
+				GenericFunc: func(_ event.GenericEvent) bool { return false },
+			},
+		)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
+}
@@ -0,0 +1,111 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	controllerName = "ProvisionerMetrics"
+)
+
+var (
+	specHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.KarpenterNamespace,
+			Subsystem: "provisioner",
+			Name:      "spec_hash",
+			Help:      "A metric with a constant '1' value labeled by the hash of the provisioner spec. The hash changes when the provisioner is updated.",
+		},
+		[]string{
+			metrics.ProvisionerLabel,
+			"hash",
+		},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(specHash)
+}
+
+// Controller publishes metrics describing the configuration of provisioners
+type Controller struct {
+	KubeClient client.Client
+
+	// hashes are the published spec hashes, by provisioner name, so that
+	// they're removed once they change
+	hashes map[string]string
+	mu     sync.Mutex
+}
+
+func NewController(kubeClient client.Client) *Controller {
+	return &Controller{KubeClient: kubeClient, hashes: map[string]string{}}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName))
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.KubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			c.publish(req.Name, "")
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	hash, err := metrics.Hash(provisioner.Spec)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	c.publish(req.Name, hash)
+	return reconcile.Result{}, nil
+}
+
+// publish replaces the provisioner's published spec hash, removing it if the
+// hash is empty
+func (c *Controller) publish(provisioner string, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.hashes[provisioner]; ok && previous != hash {
+		specHash.DeleteLabelValues(provisioner, previous)
+		delete(c.hashes, provisioner)
+	}
+	if hash != "" {
+		specHash.WithLabelValues(provisioner, hash).Set(1)
+		c.hashes[provisioner] = hash
+	}
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha4.Provisioner{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
+}
@@ -23,6 +23,9 @@ import (
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"knative.dev/pkg/logging"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -33,28 +36,50 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/approval"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/utils/result"
 )
 
-// NewController constructs a controller instance
-func NewController(kubeClient client.Client) *Controller {
+// NewController constructs a controller instance. Nodes launched with images
+// that the cloud provider has since replaced are marked drifted if
+// markDriftedOnImageChange is set.
+func NewController(kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, recorder record.EventRecorder, notifier *lifecycle.Notifier, coordinator *deprovisioning.Coordinator, markDriftedOnImageChange bool) *Controller {
+	tracker, _ := cloudProvider.(cloudprovider.ImageTracker)
+	gate := approval.NewGate()
 	return &Controller{
-		kubeClient: kubeClient,
-		liveness:   &Liveness{kubeClient: kubeClient},
-		emptiness:  &Emptiness{kubeClient: kubeClient},
-		expiration: &Expiration{kubeClient: kubeClient},
+		kubeClient:    kubeClient,
+		readiness:     &Readiness{kubeClient: kubeClient, coreV1Client: coreV1Client, notifier: notifier},
+		liveness:      &Liveness{kubeClient: kubeClient},
+		disruption:    &Disruption{kubeClient: kubeClient, recorder: recorder},
+		jobProtection: &JobProtection{kubeClient: kubeClient},
+		emptiness:     &Emptiness{kubeClient: kubeClient, coordinator: coordinator},
+		expiration:    &Expiration{kubeClient: kubeClient, gate: gate, coordinator: coordinator},
+		taints:        &Taints{},
+		drift:         &Drift{recorder: recorder, tracker: tracker, markOnImageChange: markDriftedOnImageChange, reported: sets.NewString()},
+		labels:        &Labels{},
+		evacuation:    &Evacuation{kubeClient: kubeClient, gate: gate, coordinator: coordinator},
 	}
 }
 
 // Controller manages a set of properites on karpenter provisioned nodes, such as
 // taints, labels, finalizers.
 type Controller struct {
-	kubeClient client.Client
-	readiness  *Readiness
-	liveness   *Liveness
-	emptiness  *Emptiness
-	expiration *Expiration
-	finalizer  *Finalizer
+	kubeClient    client.Client
+	readiness     *Readiness
+	liveness      *Liveness
+	disruption    *Disruption
+	jobProtection *JobProtection
+	emptiness     *Emptiness
+	expiration    *Expiration
+	taints        *Taints
+	drift         *Drift
+	labels        *Labels
+	evacuation    *Evacuation
+	finalizer     *Finalizer
 }
 
 // Reconcile executes a reallocation control loop for the resource
@@ -90,8 +115,14 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	}{
 		c.readiness,
 		c.liveness,
+		c.disruption,
+		c.jobProtection,
 		c.expiration,
 		c.emptiness,
+		c.taints,
+		c.drift,
+		c.labels,
+		c.evacuation,
 		c.finalizer,
 	} {
 		res, err := reconciler.Reconcile(ctx, provisioner, node)
@@ -99,7 +130,13 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		results = append(results, res)
 	}
 
-	// 4. Patch any changes, regardless of errors
+	// 4. Patch any changes, regardless of errors. Conditions are patched
+	// separately, using a strategic merge to avoid overwriting the kubelet's.
+	if !equality.Semantic.DeepEqual(node.Status, stored.Status) {
+		if err := c.kubeClient.Status().Patch(ctx, node.DeepCopy(), client.StrategicMergeFrom(stored)); err != nil {
+			return reconcile.Result{}, fmt.Errorf("patching node status %s, %w", node.Name, err)
+		}
+	}
 	if !equality.Semantic.DeepEqual(node, stored) {
 		if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
 			return reconcile.Result{}, fmt.Errorf("patching node %s, %w", node.Name, err)
@@ -143,5 +180,5 @@ func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
 			}),
 		).
 		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
-		Complete(c)
+		Complete(metrics.NewInstrumentedReconciler("Node", c))
 }
@@ -0,0 +1,116 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/apiobject"
+	"github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/permissions"
+	"github.com/awslabs/karpenter/pkg/utils/pod"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// disruptionBlockedInterval is how often nodes that block disruption are
+// rechecked, and warned about by the Warn policy
+const disruptionBlockedInterval = 5 * time.Minute
+
+// Disruption is a subreconciler that detects nodes running single replica
+// pods whose pod disruption budgets don't allow any disruptions. Voluntary
+// disruption of these nodes stalls until the budgets allow the pods to be
+// evicted, so they're reported or excluded according to the provisioner's
+// SingleReplicaPolicy.
+type Disruption struct {
+	kubeClient client.Client
+	recorder   record.EventRecorder
+}
+
+// Reconcile reconciles the node
+func (r *Disruption) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	if provisioner.Spec.SingleReplicaPolicy == "" || provisioner.Spec.SingleReplicaPolicy == v1alpha4.SingleReplicaPolicyIgnore {
+		return reconcile.Result{}, nil
+	}
+	// Pod disruption budgets can't be checked with reduced RBAC
+	if !permissions.Allowed(ctx, permissions.ListPodDisruptionBudgets) {
+		return reconcile.Result{}, nil
+	}
+	blocking, err := r.blockingPods(ctx, n)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(blocking) == 0 {
+		node.SetCondition(n, v1alpha4.NodeDisruptionBlocked, v1.ConditionFalse, "NotBlocked", "Pods on the node can be evicted")
+		return reconcile.Result{}, nil
+	}
+	message := fmt.Sprintf("Single replica pod(s) %s have pod disruption budgets that don't allow any disruptions", apiobject.PodNamespacedNames(blocking))
+	node.SetCondition(n, v1alpha4.NodeDisruptionBlocked, v1.ConditionTrue, "SingleReplicaPodDisruptionBudget", message)
+	if provisioner.Spec.SingleReplicaPolicy == v1alpha4.SingleReplicaPolicyWarn {
+		r.recorder.Eventf(n, v1.EventTypeWarning, "DisruptionBlocked", "%s, voluntary disruption of the node will stall", message)
+	}
+	return reconcile.Result{RequeueAfter: disruptionBlockedInterval}, nil
+}
+
+// blockingPods returns the node's pods that are the only pod expected by a pod
+// disruption budget that doesn't allow any disruptions
+func (r *Disruption) blockingPods(ctx context.Context, n *v1.Node) ([]*v1.Pod, error) {
+	pods := &v1.PodList{}
+	if err := r.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
+		return nil, fmt.Errorf("listing pods for node %s, %w", n.Name, err)
+	}
+	pdbs := map[string][]v1beta1.PodDisruptionBudget{}
+	blocking := []*v1.Pod{}
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if pod.HasFailed(p) || p.Status.Phase == v1.PodSucceeded || pod.IsOwnedByDaemonSet(p) || pod.IsOwnedByNode(p) {
+			continue
+		}
+		if _, ok := pdbs[p.Namespace]; !ok {
+			pdbList := &v1beta1.PodDisruptionBudgetList{}
+			if err := r.kubeClient.List(ctx, pdbList, client.InNamespace(p.Namespace)); err != nil {
+				return nil, fmt.Errorf("listing pod disruption budgets, %w", err)
+			}
+			pdbs[p.Namespace] = pdbList.Items
+		}
+		if isSingleReplicaBlocked(p, pdbs[p.Namespace]) {
+			blocking = append(blocking, p)
+		}
+	}
+	return blocking, nil
+}
+
+// isSingleReplicaBlocked returns true if a pod disruption budget that selects
+// the pod expects at most one pod and allows no disruptions
+func isSingleReplicaBlocked(p *v1.Pod, pdbs []v1beta1.PodDisruptionBudget) bool {
+	for _, pdb := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+		if selector.Matches(labels.Set(p.Labels)) && pdb.Status.ExpectedPods <= 1 && pdb.Status.DisruptionsAllowed <= 0 {
+			return true
+		}
+	}
+	return false
+}
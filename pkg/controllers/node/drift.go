@@ -0,0 +1,125 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/node"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Drift is a subreconciler that detects nodes that no longer match their
+// provisioner, or optionally were launched with an image that the cloud
+// provider has since replaced, and marks them with the drifted annotation,
+// which may also be set by other controllers. The annotation is reflected
+// as a condition on the node. Drift is never unmarked, since the node's
+// configuration doesn't change if the provisioner is reverted.
+type Drift struct {
+	recorder record.EventRecorder
+	// tracker is nil if the cloud provider doesn't track images
+	tracker cloudprovider.ImageTracker
+	// markOnImageChange marks nodes launched with replaced images as drifted
+	markOnImageChange bool
+	// reported are the image changes already reported on each provisioner
+	reported   sets.String
+	reportedMu sync.Mutex
+}
+
+// Reconcile reconciles the node
+func (r *Drift) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	if n.Annotations[v1alpha4.DriftedAnnotationKey] != "true" {
+		reason, err := driftReason(provisioner, n)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if imageReason := r.imageDrift(ctx, provisioner, n); reason == "" && r.markOnImageChange {
+			reason = imageReason
+		}
+		if reason != "" {
+			logging.FromContext(ctx).Infof("Marking node %s as drifted, %s", n.Name, reason)
+			r.recorder.Eventf(n, v1.EventTypeNormal, "Drifted", "Node drifted, %s", reason)
+			n.Annotations = functional.UnionStringMaps(n.Annotations, map[string]string{v1alpha4.DriftedAnnotationKey: "true"})
+		}
+	}
+	if n.Annotations[v1alpha4.DriftedAnnotationKey] == "true" {
+		node.SetCondition(n, v1alpha4.NodeDrifted, v1.ConditionTrue, "Drifted", "Node no longer matches the configuration it would be launched with")
+		return reconcile.Result{}, nil
+	}
+	node.SetCondition(n, v1alpha4.NodeDrifted, v1.ConditionFalse, "NotDrifted", "Node matches the configuration it would be launched with")
+	return reconcile.Result{}, nil
+}
+
+// imageDrift returns why the node drifted if it was launched with an image
+// that the cloud provider has since replaced, or an empty string. Each
+// replacement is reported once on the provisioner, rather than for each node.
+func (r *Drift) imageDrift(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) string {
+	if r.tracker == nil {
+		return ""
+	}
+	image, replacement := r.tracker.ReplacedImage(n)
+	if replacement == "" {
+		return ""
+	}
+	r.reportedMu.Lock()
+	defer r.reportedMu.Unlock()
+	if key := fmt.Sprintf("%s/%s/%s", provisioner.Name, image, replacement); !r.reported.Has(key) {
+		r.reported.Insert(key)
+		logging.FromContext(ctx).Infof("Discovered image %s replacing %s, which provisioner %s has nodes launched with", replacement, image, provisioner.Name)
+		r.recorder.Eventf(provisioner, v1.EventTypeNormal, "ImageChanged", "Discovered image %s replacing %s, which nodes were launched with", replacement, image)
+	}
+	return fmt.Sprintf("image %s was replaced by %s", image, replacement)
+}
+
+// driftReason returns why the node no longer matches the provisioner, or an
+// empty string if it matches. Nodes launched before their configuration was
+// recorded adopt the provisioner's current configuration.
+func driftReason(provisioner *v1alpha4.Provisioner, n *v1.Node) (string, error) {
+	for _, requirement := range []struct {
+		label   string
+		allowed []string
+	}{
+		{v1.LabelTopologyZone, provisioner.Spec.Zones},
+		{v1.LabelInstanceTypeStable, provisioner.Spec.InstanceTypes},
+		{v1.LabelArchStable, provisioner.Spec.Architectures},
+		{v1.LabelOSStable, provisioner.Spec.OperatingSystems},
+	} {
+		if value, ok := n.Labels[requirement.label]; ok && len(requirement.allowed) > 0 && !functional.ContainsString(requirement.allowed, value) {
+			return fmt.Sprintf("%s %s is no longer allowed", requirement.label, value), nil
+		}
+	}
+	hash, err := provisioner.Spec.NodeConfigurationHash()
+	if err != nil {
+		return "", err
+	}
+	recorded, ok := n.Annotations[v1alpha4.ProvisionerHashAnnotationKey]
+	if !ok {
+		n.Annotations = functional.UnionStringMaps(n.Annotations, map[string]string{v1alpha4.ProvisionerHashAnnotationKey: hash})
+		return "", nil
+	}
+	if recorded != hash {
+		return "provisioner's kubelet configuration or provider changed", nil
+	}
+	return "", nil
+}
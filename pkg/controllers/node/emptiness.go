@@ -20,12 +20,14 @@ import (
 	"time"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
 	"github.com/awslabs/karpenter/pkg/utils/node"
 	"github.com/awslabs/karpenter/pkg/utils/pod"
 	"github.com/awslabs/karpenter/pkg/utils/ptr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -33,23 +35,26 @@ import (
 
 // Emptiness is a subreconciler that deletes nodes that are empty after a ttl
 type Emptiness struct {
-	kubeClient client.Client
+	kubeClient  client.Client
+	coordinator *deprovisioning.Coordinator
 }
 
 // Reconcile reconciles the node
 func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
 	// 1. Ignore node if not applicable
-	if provisioner.Spec.TTLSecondsAfterEmpty == nil {
-		return reconcile.Result{}, nil
-	}
 	if !node.IsReady(n) {
 		return reconcile.Result{}, nil
 	}
-	// 2. Remove ttl if not empty
 	empty, err := r.isEmpty(ctx, n)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	ttlSeconds := ttlSecondsAfterEmpty(provisioner, n)
+	setEmptinessConditions(n, empty, ttlSeconds != nil)
+	if ttlSeconds == nil {
+		return reconcile.Result{}, nil
+	}
+	// 2. Remove ttl if not empty
 
 	emptinessTimestamp, hasEmptinessTimestamp := n.Annotations[v1alpha4.EmptinessTimestampAnnotationKey]
 	if !empty {
@@ -61,7 +66,7 @@ func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisi
 	}
 	// 3. Set TTL if not set
 	n.Annotations = functional.UnionStringMaps(n.Annotations)
-	ttl := time.Duration(ptr.Int64Value(provisioner.Spec.TTLSecondsAfterEmpty)) * time.Second
+	ttl := time.Duration(ptr.Int64Value(ttlSeconds)) * time.Second
 	if !hasEmptinessTimestamp {
 		n.Annotations[v1alpha4.EmptinessTimestampAnnotationKey] = injectabletime.Now().Format(time.RFC3339)
 		logging.FromContext(ctx).Infof("Added TTL to empty node %s", n.Name)
@@ -73,6 +78,9 @@ func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisi
 		return reconcile.Result{}, fmt.Errorf("parsing emptiness timestamp, %s", emptinessTimestamp)
 	}
 	if injectabletime.Now().After(emptinessTime.Add(ttl)) {
+		if allowed, err := r.coordinator.Allow(ctx, provisioner, n); err != nil || !allowed {
+			return reconcile.Result{RequeueAfter: deprovisioning.RetryInterval}, err
+		}
 		logging.FromContext(ctx).Infof("Triggering termination after %s for empty node %s", ttl, n.Name)
 		if err := r.kubeClient.Delete(ctx, n); err != nil {
 			return reconcile.Result{}, fmt.Errorf("deleting node %s, %w", n.Name, err)
@@ -81,6 +89,35 @@ func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisi
 	return reconcile.Result{}, nil
 }
 
+// ttlSecondsAfterEmpty returns the TTL of the node once it's empty, from the
+// first of the provisioner's overrides that matches the node's labels, or nil
+// if the provisioner doesn't remove the node when it's empty
+func ttlSecondsAfterEmpty(provisioner *v1alpha4.Provisioner, n *v1.Node) *int64 {
+	for _, override := range provisioner.Spec.TTLSecondsAfterEmptyOverrides {
+		if labels.SelectorFromSet(override.Labels).Matches(labels.Set(n.Labels)) {
+			seconds := override.Seconds
+			return &seconds
+		}
+	}
+	return provisioner.Spec.TTLSecondsAfterEmpty
+}
+
+// setEmptinessConditions sets the Empty condition, and Consolidatable if the
+// node is empty and has a TTL after which empty nodes are removed
+func setEmptinessConditions(n *v1.Node, empty bool, hasTTL bool) {
+	if !empty {
+		node.SetCondition(n, v1alpha4.NodeEmpty, v1.ConditionFalse, "PodsScheduled", "Pods other than daemonsets are scheduled to the node")
+		node.SetCondition(n, v1alpha4.NodeConsolidatable, v1.ConditionFalse, "NodeNotEmpty", "Node has pods other than daemonsets")
+		return
+	}
+	node.SetCondition(n, v1alpha4.NodeEmpty, v1.ConditionTrue, "NoPodsScheduled", "No pods other than daemonsets are scheduled to the node")
+	if !hasTTL {
+		node.SetCondition(n, v1alpha4.NodeConsolidatable, v1.ConditionFalse, "TTLSecondsAfterEmptyNotSet", "Provisioner does not remove empty nodes")
+		return
+	}
+	node.SetCondition(n, v1alpha4.NodeConsolidatable, v1.ConditionTrue, "NodeEmpty", "Node is empty and will be removed after ttlSecondsAfterEmpty")
+}
+
 func (r *Emptiness) isEmpty(ctx context.Context, n *v1.Node) (bool, error) {
 	pods := &v1.PodList{}
 	if err := r.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
@@ -91,7 +128,7 @@ func (r *Emptiness) isEmpty(ctx context.Context, n *v1.Node) (bool, error) {
 		if pod.HasFailed(&p) {
 			continue
 		}
-		if !pod.IsOwnedByDaemonSet(&p) && !pod.IsOwnedByNode(&p) {
+		if !node.IsDaemon(&p) {
 			return false, nil
 		}
 	}
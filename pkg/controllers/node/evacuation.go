@@ -0,0 +1,86 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/approval"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// EvacuationInterval is how often nodes in unhealthy zones are rechecked while
+// waiting for another node in the zone to finish terminating
+const EvacuationInterval = 30 * time.Second
+
+// Evacuation is a subreconciler that terminates nodes in zones marked unhealthy
+// on the provisioner. Nodes are terminated one at a time per zone, and drained
+// by the termination controller, which respects pod disruption budgets.
+// Evacuation is a voluntary disruption, so it's subject to the provisioner's
+// disruption approval and budget.
+type Evacuation struct {
+	kubeClient  client.Client
+	gate        *approval.Gate
+	coordinator *deprovisioning.Coordinator
+}
+
+// Reconcile reconciles the node
+func (r *Evacuation) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, node *v1.Node) (reconcile.Result, error) {
+	// 1. Ignore node if not in an unhealthy zone
+	zone := node.Labels[v1.LabelTopologyZone]
+	if !functional.ContainsString(provisioner.UnhealthyZones(), zone) {
+		return reconcile.Result{}, nil
+	}
+	// 2. Wait for other nodes in the zone to terminate
+	nodes := &v1.NodeList{}
+	if err := r.kubeClient.List(ctx, nodes, client.MatchingLabels{
+		v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+		v1.LabelTopologyZone:             zone,
+	}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	for _, n := range nodes.Items {
+		if n.Name != node.Name && !n.DeletionTimestamp.IsZero() {
+			return reconcile.Result{RequeueAfter: EvacuationInterval}, nil
+		}
+	}
+	// 3. Skip nodes that can't be disrupted
+	if !nodeutil.IsDisruptable(provisioner, node) {
+		logging.FromContext(ctx).Infof("Skipping termination for node %s in unhealthy zone %s, its pods don't allow disruptions", node.Name, zone)
+		return reconcile.Result{RequeueAfter: disruptionBlockedInterval}, nil
+	}
+	if !r.gate.Approve(ctx, provisioner, node, approval.Evacuation) {
+		logging.FromContext(ctx).Infof("Skipping termination for node %s in unhealthy zone %s, disruption is pending approval", node.Name, zone)
+		return reconcile.Result{RequeueAfter: approval.RetryInterval}, nil
+	}
+	if allowed, err := r.coordinator.Allow(ctx, provisioner, node); err != nil || !allowed {
+		return reconcile.Result{RequeueAfter: deprovisioning.RetryInterval}, err
+	}
+	// 4. Trigger termination workflow
+	logging.FromContext(ctx).Infof("Triggering termination for node %s in unhealthy zone %s", node.Name, zone)
+	if err := r.kubeClient.Delete(ctx, node); err != nil {
+		return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+	}
+	return reconcile.Result{}, nil
+}
@@ -20,7 +20,9 @@ import (
 	"time"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
-	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	"github.com/awslabs/karpenter/pkg/approval"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
 	"github.com/awslabs/karpenter/pkg/utils/ptr"
 	v1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/logging"
@@ -30,19 +32,32 @@ import (
 
 // Expiration is a subreconciler that terminates nodes after a period of time.
 type Expiration struct {
-	kubeClient client.Client
+	kubeClient  client.Client
+	gate        *approval.Gate
+	coordinator *deprovisioning.Coordinator
 }
 
 // Reconcile reconciles the node
 func (r *Expiration) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, node *v1.Node) (reconcile.Result, error) {
 	// 1. Ignore node if not applicable
-	if provisioner.Spec.TTLSecondsUntilExpired == nil {
+	expirationTime, ok := nodeutil.ExpirationTime(provisioner, node)
+	if !ok {
 		return reconcile.Result{}, nil
 	}
 	// 2. Trigger termination workflow if expired
 	expirationTTL := time.Duration(ptr.Int64Value(provisioner.Spec.TTLSecondsUntilExpired)) * time.Second
-	expirationTime := node.CreationTimestamp.Add(expirationTTL)
-	if injectabletime.Now().After(expirationTime) {
+	if nodeutil.IsExpired(provisioner, node) {
+		if !nodeutil.IsDisruptable(provisioner, node) {
+			logging.FromContext(ctx).Infof("Skipping termination for expired node %s, its pods don't allow disruptions", node.Name)
+			return reconcile.Result{RequeueAfter: disruptionBlockedInterval}, nil
+		}
+		if !r.gate.Approve(ctx, provisioner, node, approval.Expiration) {
+			logging.FromContext(ctx).Infof("Skipping termination for expired node %s, disruption is pending approval", node.Name)
+			return reconcile.Result{RequeueAfter: approval.RetryInterval}, nil
+		}
+		if allowed, err := r.coordinator.Allow(ctx, provisioner, node); err != nil || !allowed {
+			return reconcile.Result{RequeueAfter: deprovisioning.RetryInterval}, err
+		}
 		logging.FromContext(ctx).Infof("Triggering termination for expired node %s after %s (+%s)", node.Name, expirationTTL, time.Since(expirationTime))
 		if err := r.kubeClient.Delete(ctx, node); err != nil {
 			return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
@@ -0,0 +1,116 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/apiobject"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	"github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/pod"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// JobProtection is a subreconciler that detects nodes running pods of long
+// running Jobs, which are excluded from voluntary disruption until the pods
+// complete so that jobs near completion aren't restarted.
+type JobProtection struct {
+	kubeClient client.Client
+}
+
+// Reconcile reconciles the node
+func (r *JobProtection) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	if provisioner.Spec.JobProtectionThresholdSeconds == nil {
+		if node.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status == v1.ConditionTrue {
+			node.SetCondition(n, v1alpha4.NodeJobProtected, v1.ConditionFalse, "NotProtected", "Job protection is disabled")
+		}
+		return reconcile.Result{}, nil
+	}
+	threshold := time.Duration(*provisioner.Spec.JobProtectionThresholdSeconds) * time.Second
+	pods := &v1.PodList{}
+	if err := r.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing pods for node %s, %w", n.Name, err)
+	}
+	protected := []*v1.Pod{}
+	var untilProtected *time.Duration
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if !pod.IsOwnedByJob(p) || pod.HasFailed(p) || p.Status.Phase == v1.PodSucceeded {
+			continue
+		}
+		deadline, err := r.activeDeadlineSeconds(ctx, p)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if deadline >= int64(threshold.Seconds()) {
+			protected = append(protected, p)
+			continue
+		}
+		if p.Status.StartTime == nil {
+			continue
+		}
+		running := injectabletime.Now().Sub(p.Status.StartTime.Time)
+		if running >= threshold {
+			protected = append(protected, p)
+			continue
+		}
+		// Recheck once the pod has been running for longer than the threshold
+		if remaining := threshold - running; untilProtected == nil || remaining < *untilProtected {
+			untilProtected = &remaining
+		}
+	}
+	if len(protected) == 0 {
+		node.SetCondition(n, v1alpha4.NodeJobProtected, v1.ConditionFalse, "NotProtected", "No long running jobs are running on the node")
+		if untilProtected != nil {
+			return reconcile.Result{RequeueAfter: *untilProtected}, nil
+		}
+		return reconcile.Result{}, nil
+	}
+	node.SetCondition(n, v1alpha4.NodeJobProtected, v1.ConditionTrue, "LongRunningJob",
+		fmt.Sprintf("Pod(s) %s of jobs running for at least %s are protected until they complete", apiobject.PodNamespacedNames(protected), threshold))
+	return reconcile.Result{RequeueAfter: disruptionBlockedInterval}, nil
+}
+
+// activeDeadlineSeconds returns the longest deadline set on the pod or on the
+// Job that owns it. Jobs usually set the deadline on their own spec.
+func (r *JobProtection) activeDeadlineSeconds(ctx context.Context, p *v1.Pod) (int64, error) {
+	deadline := ptr.Int64Value(p.Spec.ActiveDeadlineSeconds)
+	for _, owner := range p.OwnerReferences {
+		if owner.Kind != "Job" || owner.APIVersion != batchv1.SchemeGroupVersion.String() {
+			continue
+		}
+		job := &batchv1.Job{}
+		if err := r.kubeClient.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: owner.Name}, job); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return 0, fmt.Errorf("getting job %s/%s, %w", p.Namespace, owner.Name, err)
+		}
+		if jobDeadline := ptr.Int64Value(job.Spec.ActiveDeadlineSeconds); jobDeadline > deadline {
+			deadline = jobDeadline
+		}
+	}
+	return deadline, nil
+}
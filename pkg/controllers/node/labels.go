@@ -0,0 +1,72 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Labels is a subreconciler that syncs changes to the provisioner's labels
+// onto the nodes it has already launched. Labels are only added or updated,
+// never removed, and labels in kubernetes domains (e.g. those set by the
+// kubelet) are left untouched.
+type Labels struct{}
+
+// Reconcile reconciles the node
+func (r *Labels) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	if !provisioner.Spec.SyncLabels {
+		return reconcile.Result{}, nil
+	}
+	n.Labels = functional.UnionStringMaps(n.Labels)
+	synced := []string{}
+	for key, value := range provisioner.Spec.Labels {
+		if isKubernetesLabel(key) {
+			continue
+		}
+		if existing, ok := n.Labels[key]; ok && existing == value {
+			continue
+		}
+		n.Labels[key] = value
+		synced = append(synced, key)
+	}
+	if len(synced) > 0 {
+		sort.Strings(synced)
+		logging.FromContext(ctx).Infof("Synced labels %v from provisioner %s to node %s", synced, provisioner.Name, n.Name)
+	}
+	return reconcile.Result{}, nil
+}
+
+// isKubernetesLabel returns true if the label's prefix is in the kubernetes.io
+// or k8s.io domains, which are reserved for the kubelet and other components.
+func isKubernetesLabel(key string) bool {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	for _, domain := range []string{"kubernetes.io", "k8s.io"} {
+		if parts[0] == domain || strings.HasSuffix(parts[0], "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -16,21 +16,69 @@ package node
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
 	"github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-// Readiness is a subreconciler that removes the NotReady taint when the node is ready
-type Readiness struct{}
+var readyDurationHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "node_controller",
+		Name:      "ready_duration_seconds",
+		Help:      "Duration from a node's launch until it's initialized, i.e. ready with its extended resources registered, in seconds. Broken down by provisioner.",
+		Buckets:   []float64{15, 30, 45, 60, 90, 120, 150, 180, 240, 300, 420, 600, 900},
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(readyDurationHistogramVec)
+}
+
+// Readiness is a subreconciler that removes the NotReady taint when the node
+// is ready and its extended resources are registered, binding any pods that
+// were nominated to the node while it initialized.
+type Readiness struct {
+	kubeClient   client.Client
+	coreV1Client corev1.CoreV1Interface
+	notifier     *lifecycle.Notifier
+}
 
 // Reconcile reconciles the node
-func (r *Readiness) Reconcile(_ context.Context, _ *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
+func (r *Readiness) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	initialized := node.IsInitialized(n)
 	if !node.IsReady(n) {
+		if !initialized {
+			node.SetCondition(n, v1alpha4.NodeInitialized, v1.ConditionFalse, "NodeNotReady", "Node has not become ready")
+		}
 		return reconcile.Result{}, nil
 	}
+	if !initialized {
+		if missing := unregisteredExtendedResources(n); len(missing) > 0 {
+			node.SetCondition(n, v1alpha4.NodeInitialized, v1.ConditionFalse, "ExtendedResourcesNotRegistered",
+				fmt.Sprintf("Awaiting extended resources %s", strings.Join(missing, ", ")))
+			return reconcile.Result{}, nil
+		}
+		if err := r.bindNominated(ctx, n); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
 	taints := []v1.Taint{}
 	for _, taint := range n.Spec.Taints {
 		if taint.Key != v1alpha4.NotReadyTaintKey {
@@ -38,5 +86,59 @@ func (r *Readiness) Reconcile(_ context.Context, _ *v1alpha4.Provisioner, n *v1.
 		}
 	}
 	n.Spec.Taints = taints
+	if !initialized {
+		r.notifier.Notify(ctx, lifecycle.Registered, n)
+		readyDurationHistogramVec.WithLabelValues(provisioner.Name).Observe(injectabletime.Now().Sub(n.CreationTimestamp.Time).Seconds())
+	}
+	node.SetCondition(n, v1alpha4.NodeInitialized, v1.ConditionTrue, "NodeReady", "Node is ready and the not-ready taint is removed")
 	return reconcile.Result{}, nil
 }
+
+// unregisteredExtendedResources returns the extended resources requested by
+// the pods the node was launched for that aren't allocatable yet
+func unregisteredExtendedResources(n *v1.Node) []string {
+	annotation, ok := n.Annotations[v1alpha4.ExtendedResourcesAnnotationKey]
+	if !ok || annotation == "" {
+		return nil
+	}
+	missing := []string{}
+	for _, name := range strings.Split(annotation, ",") {
+		if quantity, ok := n.Status.Allocatable[v1.ResourceName(name)]; !ok || quantity.IsZero() {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// bindNominated binds the unscheduled pods nominated to the node. Pods that
+// have been bound or deleted in the meantime are ignored.
+func (r *Readiness) bindNominated(ctx context.Context, n *v1.Node) error {
+	pods := &v1.PodList{}
+	if err := r.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": ""}); err != nil {
+		return fmt.Errorf("listing unscheduled pods, %w", err)
+	}
+	var errs error
+	bound := 0
+	for i := range pods.Items {
+		pod := pods.Items[i]
+		if pod.Status.NominatedNodeName != n.Name || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if err := r.coreV1Client.Pods(pod.Namespace).Bind(ctx, &v1.Binding{
+			TypeMeta:   pod.TypeMeta,
+			ObjectMeta: pod.ObjectMeta,
+			Target:     v1.ObjectReference{Name: n.Name},
+		}, metav1.CreateOptions{}); err != nil {
+			if errors.IsConflict(err) || errors.IsNotFound(err) {
+				continue
+			}
+			errs = multierr.Append(errs, fmt.Errorf("binding pod %s/%s, %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+		bound++
+	}
+	if bound > 0 {
+		logging.FromContext(ctx).Infof("Bound %d nominated pod(s) to node %s", bound, n.Name)
+	}
+	return errs
+}
@@ -22,15 +22,25 @@ import (
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
 	"github.com/awslabs/karpenter/pkg/controllers/node"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
 	"github.com/awslabs/karpenter/pkg/test"
 	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/permissions"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
 
 	. "github.com/awslabs/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	. "knative.dev/pkg/logging/testing"
 	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -38,6 +48,8 @@ import (
 
 var ctx context.Context
 var controller *node.Controller
+var recorder *record.FakeRecorder
+var cloudProvider *fake.CloudProvider
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -48,7 +60,9 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		controller = node.NewController(e.Client)
+		recorder = record.NewFakeRecorder(100)
+		cloudProvider = &fake.CloudProvider{}
+		controller = node.NewController(e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, recorder, nil, deprovisioning.NewCoordinator(e.Client), true)
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -116,6 +130,73 @@ var _ = Describe("Controller", func() {
 			n = ExpectNodeExists(env.Client, n.Name)
 			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
 		})
+		It("should wait for approval to delete expired nodes", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.DisruptionApproval = &v1alpha4.DisruptionApproval{}
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+				},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			injectabletime.Now = func() time.Time {
+				return time.Now().Add(time.Duration(*provisioner.Spec.TTLSecondsUntilExpired) * time.Second)
+			}
+
+			// Should be pending approval
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, "Expiration"))
+
+			// Approve
+			n.Annotations[v1alpha4.DisruptionApprovedAnnotationKey] = "true"
+			Expect(env.Client.Update(ctx, n)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should delete expired nodes within the disruption budget", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.DisruptionBudget = &v1alpha4.DisruptionBudget{MaxUnavailable: &intstr.IntOrString{Type: intstr.Int, IntVal: 1}}
+			nodes := []*v1.Node{}
+			for i := 0; i < 2; i++ {
+				nodes = append(nodes, test.Node(test.NodeOptions{
+					Finalizers: []string{v1alpha4.TerminationFinalizer},
+					Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+				}))
+			}
+			ExpectCreated(env.Client, provisioner, nodes[0], nodes[1])
+			injectabletime.Now = func() time.Time {
+				return time.Now().Add(time.Duration(*provisioner.Spec.TTLSecondsUntilExpired) * time.Second)
+			}
+			deleting := 0
+			for _, n := range nodes {
+				ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+				if !ExpectNodeExists(env.Client, n.Name).DeletionTimestamp.IsZero() {
+					deleting++
+				}
+			}
+			Expect(deleting).To(Equal(1))
+		})
+		It("should not delete expired nodes outside of the disruption windows", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.DisruptionBudget = &v1alpha4.DisruptionBudget{Windows: []v1alpha4.DisruptionWindow{{
+				Start:    time.Now().UTC().Add(2 * time.Hour).Format(v1alpha4.DisruptionWindowTimeFormat),
+				Duration: metav1.Duration{Duration: time.Hour},
+			}}}
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			injectabletime.Now = func() time.Time {
+				return time.Now().Add(time.Duration(*provisioner.Spec.TTLSecondsUntilExpired) * time.Second)
+			}
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			Expect(ExpectNodeExists(env.Client, n.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+		})
 	})
 
 	Context("Readiness", func() {
@@ -164,6 +245,49 @@ var _ = Describe("Controller", func() {
 			n = ExpectNodeExists(env.Client, n.Name)
 			Expect(n.Spec.Taints).To(Equal(n.Spec.Taints))
 		})
+		It("should not remove the readiness taint until extended resources are registered", func() {
+			n := test.Node(test.NodeOptions{
+				ReadyStatus: v1.ConditionTrue,
+				Labels:      map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+				Annotations: map[string]string{v1alpha4.ExtendedResourcesAnnotationKey: resources.NvidiaGPU},
+				Taints:      []v1.Taint{{Key: v1alpha4.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}},
+			})
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Spec.Taints).To(ContainElement(v1.Taint{Key: v1alpha4.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}))
+			condition := nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeInitialized)
+			Expect(condition.Status).To(Equal(v1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("ExtendedResourcesNotRegistered"))
+
+			n.Status.Allocatable = v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")}
+			Expect(env.Client.Status().Update(ctx, n)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Spec.Taints).ToNot(ContainElement(v1.Taint{Key: v1alpha4.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeInitialized).Status).To(Equal(v1.ConditionTrue))
+		})
+		It("should bind nominated pods once the node is initialized", func() {
+			n := test.Node(test.NodeOptions{
+				ReadyStatus: v1.ConditionTrue,
+				Labels:      map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+				Annotations: map[string]string{v1alpha4.ExtendedResourcesAnnotationKey: resources.NvidiaGPU},
+				Taints:      []v1.Taint{{Key: v1alpha4.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}},
+				Allocatable: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+			})
+			nominated := test.UnschedulablePod()
+			nominated.Status.NominatedNodeName = n.Name
+			other := test.UnschedulablePod()
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, n, nominated, other)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			Expect(ExpectPodExists(env.Client, nominated.Name, nominated.Namespace).Spec.NodeName).To(Equal(n.Name))
+			Expect(ExpectPodExists(env.Client, other.Name, other.Namespace).Spec.NodeName).To(BeEmpty())
+		})
 		It("should do nothing if not owned by a provisioner", func() {
 			n := test.Node(test.NodeOptions{
 				ReadyStatus: v1.ConditionTrue,
@@ -304,6 +428,448 @@ var _ = Describe("Controller", func() {
 			node = ExpectNodeExists(env.Client, node.Name)
 			Expect(node.DeletionTimestamp.IsZero()).To(BeFalse())
 		})
+		Context("Overrides", func() {
+			emptyNode := func(capacityType string) *v1.Node {
+				return test.Node(test.NodeOptions{
+					Finalizers: []string{v1alpha4.TerminationFinalizer},
+					Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name, "example.com/capacity-type": capacityType},
+					Annotations: map[string]string{
+						v1alpha4.EmptinessTimestampAnnotationKey: time.Now().Add(-100 * time.Second).Format(time.RFC3339),
+					},
+				})
+			}
+			BeforeEach(func() {
+				provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(300)
+				provisioner.Spec.TTLSecondsAfterEmptyOverrides = []v1alpha4.TTLOverride{
+					{Labels: map[string]string{"example.com/capacity-type": "on-demand"}, Seconds: 30},
+					{Labels: map[string]string{"example.com/capacity-type": "on-demand", "example.com/other": "other"}, Seconds: 600},
+				}
+			})
+			It("should delete empty nodes past the TTL of the first matching override", func() {
+				node := emptyNode("on-demand")
+				ExpectCreated(env.Client, provisioner, node)
+				ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+
+				node = ExpectNodeExists(env.Client, node.Name)
+				Expect(node.DeletionTimestamp.IsZero()).To(BeFalse())
+			})
+			It("should use ttlSecondsAfterEmpty for nodes that don't match an override", func() {
+				node := emptyNode("spot")
+				ExpectCreated(env.Client, provisioner, node)
+				ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+
+				node = ExpectNodeExists(env.Client, node.Name)
+				Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+			})
+			It("should only remove nodes that match an override if ttlSecondsAfterEmpty is not set", func() {
+				provisioner.Spec.TTLSecondsAfterEmpty = nil
+				onDemand, spot := emptyNode("on-demand"), emptyNode("spot")
+				ExpectCreated(env.Client, provisioner, onDemand, spot)
+				ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(onDemand))
+				ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(spot))
+
+				Expect(ExpectNodeExists(env.Client, onDemand.Name).DeletionTimestamp.IsZero()).To(BeFalse())
+				Expect(ExpectNodeExists(env.Client, spot.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+			})
+		})
+	})
+	Context("Evacuation", func() {
+		BeforeEach(func() {
+			provisioner.Annotations = map[string]string{v1alpha4.UnhealthyZonesAnnotationKey: "test-zone-1"}
+		})
+		It("should delete nodes in unhealthy zones", func() {
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+					v1.LabelTopologyZone:             "test-zone-1",
+				},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should ignore nodes in healthy zones", func() {
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+					v1.LabelTopologyZone:             "test-zone-2",
+				},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should wait for other nodes in the zone to terminate", func() {
+			labels := map[string]string{
+				v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelTopologyZone:             "test-zone-1",
+			}
+			terminating := test.Node(test.NodeOptions{Finalizers: []string{v1alpha4.TerminationFinalizer}, Labels: labels})
+			n := test.Node(test.NodeOptions{Finalizers: []string{v1alpha4.TerminationFinalizer}, Labels: labels})
+			ExpectCreated(env.Client, provisioner, terminating, n)
+			Expect(env.Client.Delete(ctx, terminating)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should wait for approval to delete nodes in unhealthy zones", func() {
+			provisioner.Spec.DisruptionApproval = &v1alpha4.DisruptionApproval{}
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+					v1.LabelTopologyZone:             "test-zone-1",
+				},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, "Evacuation"))
+		})
+		It("should not delete nodes in unhealthy zones outside of the disruption windows", func() {
+			provisioner.Spec.DisruptionBudget = &v1alpha4.DisruptionBudget{Windows: []v1alpha4.DisruptionWindow{{
+				Start:    time.Now().UTC().Add(2 * time.Hour).Format(v1alpha4.DisruptionWindowTimeFormat),
+				Duration: metav1.Duration{Duration: time.Hour},
+			}}}
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+					v1.LabelTopologyZone:             "test-zone-1",
+				},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			Expect(ExpectNodeExists(env.Client, n.Name).DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+	})
+	Context("Labels", func() {
+		BeforeEach(func() {
+			provisioner.Spec.Labels = map[string]string{"team": "a", "topology.kubernetes.io/region": "test-region"}
+		})
+		It("should not sync labels unless enabled", func() {
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name, "team": "b"}})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Labels).To(HaveKeyWithValue("team", "b"))
+		})
+		It("should add and update labels, preserving others", func() {
+			provisioner.Spec.SyncLabels = true
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name, "team": "b", "other": "value"}})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Labels).To(HaveKeyWithValue("team", "a"))
+			Expect(n.Labels).To(HaveKeyWithValue("other", "value"))
+			Expect(n.Labels).To(HaveKeyWithValue(v1alpha4.ProvisionerNameLabelKey, provisioner.Name))
+		})
+		It("should not sync labels in kubernetes domains", func() {
+			provisioner.Spec.SyncLabels = true
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Labels).To(HaveKeyWithValue("team", "a"))
+			Expect(n.Labels).ToNot(HaveKey("topology.kubernetes.io/region"))
+		})
+	})
+	Context("Taints", func() {
+		BeforeEach(func() {
+			provisioner.Spec.Taints = []v1.Taint{{Key: "team", Value: "a", Effect: v1.TaintEffectNoSchedule}}
+		})
+		It("should ignore taint changes by default", func() {
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Spec.Taints).To(BeEmpty())
+			Expect(n.Annotations).ToNot(HaveKey(v1alpha4.DriftedAnnotationKey))
+		})
+		It("should add and update taints with the Sync policy", func() {
+			provisioner.Spec.TaintSyncPolicy = v1alpha4.TaintSyncPolicySync
+			provisioner.Spec.Taints = append(provisioner.Spec.Taints, v1.Taint{Key: "dedicated", Effect: v1.TaintEffectNoExecute})
+			n := test.Node(test.NodeOptions{
+				Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+				Taints: []v1.Taint{
+					{Key: "team", Value: "b", Effect: v1.TaintEffectNoSchedule},
+					{Key: "other", Effect: v1.TaintEffectNoSchedule},
+				},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Spec.Taints).To(ConsistOf(
+				v1.Taint{Key: "team", Value: "a", Effect: v1.TaintEffectNoSchedule},
+				v1.Taint{Key: "other", Effect: v1.TaintEffectNoSchedule},
+				v1.Taint{Key: "dedicated", Effect: v1.TaintEffectNoExecute},
+			))
+		})
+		It("should mark nodes as drifted with the Drift policy", func() {
+			provisioner.Spec.TaintSyncPolicy = v1alpha4.TaintSyncPolicyDrift
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Spec.Taints).To(BeEmpty())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDrifted).Status).To(Equal(v1.ConditionTrue))
+		})
+		It("should not mark nodes with the taints as drifted", func() {
+			provisioner.Spec.TaintSyncPolicy = v1alpha4.TaintSyncPolicyDrift
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}, Taints: provisioner.Spec.Taints})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Annotations).ToNot(HaveKey(v1alpha4.DriftedAnnotationKey))
+		})
+	})
+	Context("Drift", func() {
+		It("should record the provisioner's hash on nodes without one", func() {
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			hash, err := provisioner.Spec.NodeConfigurationHash()
+			Expect(err).ToNot(HaveOccurred())
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha4.ProvisionerHashAnnotationKey, hash))
+			Expect(n.Annotations).ToNot(HaveKey(v1alpha4.DriftedAnnotationKey))
+		})
+		It("should mark nodes with a different hash as drifted", func() {
+			n := test.Node(test.NodeOptions{
+				Labels:      map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+				Annotations: map[string]string{v1alpha4.ProvisionerHashAnnotationKey: "stale"},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDrifted).Status).To(Equal(v1.ConditionTrue))
+		})
+		It("should mark nodes launched with a replaced image as drifted", func() {
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}})
+			cloudProvider.ReplacedImages = map[string]string{n.Name: "test-image-2"}
+			defer func() { cloudProvider.ReplacedImages = nil }()
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+			Eventually(recorder.Events).Should(Receive(ContainSubstring("ImageChanged")))
+		})
+		It("should mark nodes with disallowed instance types as drifted", func() {
+			provisioner.Spec.InstanceTypes = []string{"m5.large"}
+			n := test.Node(test.NodeOptions{Labels: map[string]string{
+				v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       "m5.xlarge",
+			}})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+		})
+		It("should not mark nodes matching the provisioner as drifted", func() {
+			provisioner.Spec.InstanceTypes = []string{"m5.large"}
+			hash, err := provisioner.Spec.NodeConfigurationHash()
+			Expect(err).ToNot(HaveOccurred())
+			n := test.Node(test.NodeOptions{
+				Labels: map[string]string{
+					v1alpha4.ProvisionerNameLabelKey: provisioner.Name,
+					v1.LabelInstanceTypeStable:       "m5.large",
+				},
+				Annotations: map[string]string{v1alpha4.ProvisionerHashAnnotationKey: hash},
+			})
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.Annotations).ToNot(HaveKey(v1alpha4.DriftedAnnotationKey))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDrifted).Status).To(Equal(v1.ConditionFalse))
+		})
+	})
+	Context("SingleReplicaPolicy", func() {
+		var n *v1.Node
+		BeforeEach(func() {
+			n = test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			pdb := test.PodDisruptionBudget(test.PDBOptions{Labels: map[string]string{"app": "a"}, MinAvailable: &intstr.IntOrString{IntVal: 1}})
+			pdb.Status.ExpectedPods = 1
+			pdb.Status.DisruptionsAllowed = 0
+			ExpectCreated(env.Client, n)
+			ExpectCreatedWithStatus(env.Client, pdb, test.Pod(test.PodOptions{
+				Labels:     map[string]string{"app": "a"},
+				NodeName:   n.Name,
+				Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+			}))
+		})
+		It("should ignore single replica pods by default", func() {
+			ExpectCreated(env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDisruptionBlocked).Status).To(BeEmpty())
+		})
+		It("should mark nodes as blocked and warn with the Warn policy", func() {
+			provisioner.Spec.SingleReplicaPolicy = v1alpha4.SingleReplicaPolicyWarn
+			ExpectCreated(env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDisruptionBlocked).Status).To(Equal(v1.ConditionTrue))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DisruptionBlocked")))
+		})
+		It("should not mark nodes as blocked if the budget allows disruptions", func() {
+			provisioner.Spec.SingleReplicaPolicy = v1alpha4.SingleReplicaPolicyWarn
+			pdbs := &v1beta1.PodDisruptionBudgetList{}
+			Expect(env.Client.List(ctx, pdbs)).To(Succeed())
+			for i := range pdbs.Items {
+				pdbs.Items[i].Status.DisruptionsAllowed = 1
+				Expect(env.Client.Status().Update(ctx, &pdbs.Items[i])).To(Succeed())
+			}
+			ExpectCreated(env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDisruptionBlocked).Status).To(Equal(v1.ConditionFalse))
+		})
+		It("should not check pod disruption budgets without permission", func() {
+			provisioner.Spec.SingleReplicaPolicy = v1alpha4.SingleReplicaPolicyWarn
+			ExpectCreated(env.Client, provisioner)
+			ExpectReconcileSucceeded(permissions.Inject(ctx, []permissions.Permission{permissions.ListPodDisruptionBudgets}), controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDisruptionBlocked).Status).To(BeEmpty())
+		})
+		It("should not expire blocked nodes with the Exclude policy", func() {
+			provisioner.Spec.SingleReplicaPolicy = v1alpha4.SingleReplicaPolicyExclude
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			ExpectCreated(env.Client, provisioner)
+			injectabletime.Now = func() time.Time {
+				return time.Now().Add(time.Duration(*provisioner.Spec.TTLSecondsUntilExpired) * time.Second)
+			}
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDisruptionBlocked).Status).To(Equal(v1.ConditionTrue))
+		})
+	})
+	Context("JobProtection", func() {
+		var n *v1.Node
+		var p *v1.Pod
+		BeforeEach(func() {
+			provisioner.Spec.JobProtectionThresholdSeconds = ptr.Int64(3600)
+			n = test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			p = test.Pod(test.PodOptions{
+				NodeName: n.Name,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "batch/v1",
+					Kind:       "Job",
+					Name:       strings.ToLower(randomdata.SillyName()),
+					UID:        "test-uid",
+				}},
+			})
+			p.Status.Phase = v1.PodRunning
+			p.Status.StartTime = &metav1.Time{Time: time.Now()}
+		})
+		It("should ignore jobs if the threshold is not set", func() {
+			provisioner.Spec.JobProtectionThresholdSeconds = nil
+			p.Spec.ActiveDeadlineSeconds = ptr.Int64(7200)
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectCreatedWithStatus(env.Client, p)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status).To(BeEmpty())
+		})
+		It("should not protect short running jobs", func() {
+			p.Spec.ActiveDeadlineSeconds = ptr.Int64(60)
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectCreatedWithStatus(env.Client, p)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status).To(Equal(v1.ConditionFalse))
+		})
+		It("should not protect pods that aren't owned by jobs", func() {
+			p.OwnerReferences = nil
+			p.Spec.ActiveDeadlineSeconds = ptr.Int64(7200)
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectCreatedWithStatus(env.Client, p)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status).To(Equal(v1.ConditionFalse))
+		})
+		It("should protect jobs with a deadline beyond the threshold", func() {
+			p.Spec.ActiveDeadlineSeconds = ptr.Int64(7200)
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectCreatedWithStatus(env.Client, p)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status).To(Equal(v1.ConditionTrue))
+		})
+		It("should protect jobs running beyond the threshold", func() {
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectCreatedWithStatus(env.Client, p)
+			injectabletime.Now = func() time.Time {
+				return time.Now().Add(time.Duration(*provisioner.Spec.JobProtectionThresholdSeconds) * time.Second)
+			}
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status).To(Equal(v1.ConditionTrue))
+		})
+		It("should not protect completed jobs", func() {
+			p.Spec.ActiveDeadlineSeconds = ptr.Int64(7200)
+			p.Status.Phase = v1.PodSucceeded
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectCreatedWithStatus(env.Client, p)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status).To(Equal(v1.ConditionFalse))
+		})
+		It("should not expire protected nodes", func() {
+			p.Spec.ActiveDeadlineSeconds = ptr.Int64(7200)
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			ExpectCreated(env.Client, provisioner, n)
+			ExpectCreatedWithStatus(env.Client, p)
+			injectabletime.Now = func() time.Time {
+				return time.Now().Add(time.Duration(*provisioner.Spec.TTLSecondsUntilExpired) * time.Second)
+			}
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeJobProtected).Status).To(Equal(v1.ConditionTrue))
+		})
 	})
 	Context("Finalizer", func() {
 		It("should add the termination finalizer if missing", func() {
@@ -355,4 +921,56 @@ var _ = Describe("Controller", func() {
 			Expect(n.Finalizers).To(Equal(n.Finalizers))
 		})
 	})
+	Context("Conditions", func() {
+		It("should set the initialized condition once ready", func() {
+			n := test.Node(test.NodeOptions{
+				ReadyStatus: v1.ConditionFalse,
+				Labels:      map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeInitialized).Status).To(Equal(v1.ConditionFalse))
+
+			n.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+			Expect(env.Client.Status().Update(ctx, n)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeInitialized).Status).To(Equal(v1.ConditionTrue))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1.NodeReady).Status).To(Equal(v1.ConditionTrue))
+		})
+		It("should set the empty and consolidatable conditions", func() {
+			provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(30)
+			n := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}})
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeEmpty).Status).To(Equal(v1.ConditionTrue))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeConsolidatable).Status).To(Equal(v1.ConditionTrue))
+
+			ExpectCreatedWithStatus(env.Client, test.Pod(test.PodOptions{
+				Name:       strings.ToLower(randomdata.SillyName()),
+				Namespace:  provisioner.Namespace,
+				NodeName:   n.Name,
+				Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+			}))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeEmpty).Status).To(Equal(v1.ConditionFalse))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeConsolidatable).Status).To(Equal(v1.ConditionFalse))
+		})
+		It("should set the drifted condition", func() {
+			n := test.Node(test.NodeOptions{
+				Labels:      map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+				Annotations: map[string]string{v1alpha4.DriftedAnnotationKey: "true"},
+			})
+			ExpectCreated(env.Client, provisioner)
+			ExpectCreatedWithStatus(env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(env.Client, n.Name)
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha4.NodeDrifted).Status).To(Equal(v1.ConditionTrue))
+		})
+	})
 })
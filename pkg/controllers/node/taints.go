@@ -0,0 +1,76 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Taints is a subreconciler that applies the provisioner's taint sync policy
+// to nodes that don't have its current taints.
+type Taints struct{}
+
+// Reconcile reconciles the node
+func (r *Taints) Reconcile(ctx context.Context, provisioner *v1alpha4.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	missing := missingTaints(provisioner.Spec.Taints, n.Spec.Taints)
+	if len(missing) == 0 {
+		return reconcile.Result{}, nil
+	}
+	switch provisioner.Spec.TaintSyncPolicy {
+	case v1alpha4.TaintSyncPolicySync:
+		for _, taint := range missing {
+			n.Spec.Taints = withTaint(n.Spec.Taints, taint)
+		}
+		logging.FromContext(ctx).Infof("Synced %d taints from provisioner %s to node %s", len(missing), provisioner.Name, n.Name)
+	case v1alpha4.TaintSyncPolicyDrift:
+		if n.Annotations[v1alpha4.DriftedAnnotationKey] != "true" {
+			n.Annotations = functional.UnionStringMaps(n.Annotations, map[string]string{v1alpha4.DriftedAnnotationKey: "true"})
+			logging.FromContext(ctx).Infof("Marked node %s as drifted, missing %d taints from provisioner %s", n.Name, len(missing), provisioner.Name)
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// missingTaints returns the desired taints that aren't on the node with the
+// same key, value, and effect.
+func missingTaints(desired []v1.Taint, existing []v1.Taint) (missing []v1.Taint) {
+	for i := range desired {
+		found := false
+		for j := range existing {
+			found = found || existing[j].MatchTaint(&desired[i]) && existing[j].Value == desired[i].Value
+		}
+		if !found {
+			missing = append(missing, desired[i])
+		}
+	}
+	return missing
+}
+
+// withTaint replaces the taint with the same key and effect, or appends it
+func withTaint(taints []v1.Taint, taint v1.Taint) []v1.Taint {
+	for i := range taints {
+		if taints[i].MatchTaint(&taint) {
+			taints[i] = taint
+			return taints
+		}
+	}
+	return append(taints, taint)
+}
@@ -0,0 +1,172 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/permissions"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	controllerName = "Provisioner"
+	// permissionsRefreshInterval is the period at which the cloud provider's
+	// missing permissions are copied to provisioners' status
+	permissionsRefreshInterval = time.Minute
+)
+
+// Controller summarizes the provisioner's nodes in its status, so that its
+// nodes' capacity and health are visible without cross-referencing them
+type Controller struct {
+	kubeClient    client.Client
+	cloudProvider cloudprovider.CloudProvider
+}
+
+// NewController constructs a controller instance
+func NewController(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider) *Controller {
+	return &Controller{kubeClient: kubeClient, cloudProvider: cloudProvider}
+}
+
+// Reconcile publishes the allocatable resources and readiness of the
+// provisioner's nodes to its status, along with a one line summary shown by
+// kubectl, and the instance types and zones it resolves to after defaulting.
+// The last scale time is updated whenever the number of nodes
+// changes. The Permitted condition reports features that are disabled by
+// missing permissions, and the CloudProviderPermitted condition reports
+// permissions missing from the cloud provider's credentials.
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName))
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	persisted := provisioner.DeepCopy()
+	allocatable := []v1.ResourceList{}
+	ready, notReady := int32(0), int32(0)
+	var lastProvisionTime *apis.VolatileTime
+	for i := range nodes.Items {
+		allocatable = append(allocatable, nodes.Items[i].Status.Allocatable)
+		if created := nodes.Items[i].CreationTimestamp; lastProvisionTime == nil || lastProvisionTime.Inner.Before(&created) {
+			lastProvisionTime = &apis.VolatileTime{Inner: created}
+		}
+		if nodeutil.IsReady(&nodes.Items[i]) {
+			ready++
+		} else {
+			notReady++
+		}
+	}
+	if ready+notReady != provisioner.Status.ReadyNodes+provisioner.Status.NotReadyNodes {
+		provisioner.Status.LastScaleTime = &apis.VolatileTime{Inner: metav1.NewTime(injectabletime.Now())}
+	}
+	provisioner.Status.Allocatable = resources.Merge(allocatable...)
+	provisioner.Status.ReadyNodes = ready
+	provisioner.Status.NotReadyNodes = notReady
+	provisioner.Status.LastProvisionTime = lastProvisionTime
+	provisioner.Status.Summary = summarize(provisioner.Status)
+	provisioner.Status.InstanceTypes = provisioner.Spec.InstanceTypes
+	if len(provisioner.Status.InstanceTypes) == 0 {
+		provisioner.Status.InstanceTypes = v1alpha4.DefaultInstanceTypes()
+	}
+	provisioner.Status.Zones = provisioner.Spec.Zones
+	if len(provisioner.Status.Zones) == 0 {
+		provisioner.Status.Zones = v1alpha4.DefaultZones()
+	}
+	if disabled := permissions.DisabledFeatures(ctx); disabled != "" {
+		provisioner.StatusConditions().MarkFalse(v1alpha4.Permitted, "MissingPermissions", "Disabled %s", disabled)
+	} else {
+		provisioner.StatusConditions().MarkTrue(v1alpha4.Permitted)
+	}
+	result := reconcile.Result{}
+	if checker, ok := c.cloudProvider.(cloudprovider.PermissionsChecker); ok {
+		// Permissions are rechecked periodically, so requeue to pick up changes
+		result.RequeueAfter = permissionsRefreshInterval
+		if missing := checker.MissingPermissions(); len(missing) != 0 {
+			provisioner.StatusConditions().MarkFalse(v1alpha4.CloudProviderPermitted, "MissingPermissions", "Missing %s", strings.Join(missing, ", "))
+		} else {
+			provisioner.StatusConditions().MarkTrue(v1alpha4.CloudProviderPermitted)
+		}
+	}
+	if equality.Semantic.DeepEqual(provisioner.Status, persisted.Status) {
+		return result, nil
+	}
+	if err := c.kubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching provisioner status, %w", err)
+	}
+	return result, nil
+}
+
+// summarize describes the provisioner's nodes in a single line for kubectl,
+// e.g. "4 nodes (75% ready), 8 cpu, 32Gi memory, last provisioned 2021-08-01T00:00:00Z"
+func summarize(status v1alpha4.ProvisionerStatus) string {
+	nodes := status.ReadyNodes + status.NotReadyNodes
+	if nodes == 0 {
+		return "0 nodes"
+	}
+	summary := fmt.Sprintf("%d nodes (%d%% ready), %s cpu, %s memory",
+		nodes, status.ReadyNodes*100/nodes, status.Allocatable.Cpu(), status.Allocatable.Memory())
+	if status.LastProvisionTime != nil {
+		summary += fmt.Sprintf(", last provisioned %s", status.LastProvisionTime.Inner.UTC().Format(time.RFC3339))
+	}
+	return summary
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha4.Provisioner{}).
+		Watches(
+			// Reconcile the provisioner when one of its nodes changes
+			&source.Kind{Type: &v1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(func(o client.Object) (requests []reconcile.Request) {
+				if name, ok := o.GetLabels()[v1alpha4.ProvisionerNameLabelKey]; ok {
+					requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+				}
+				return requests
+			}),
+		).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
+}
@@ -0,0 +1,163 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
+	"github.com/awslabs/karpenter/pkg/controllers/provisioner"
+	"github.com/awslabs/karpenter/pkg/test"
+	"github.com/awslabs/karpenter/pkg/utils/permissions"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *provisioner.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Provisioner")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		controller = provisioner.NewController(e.Client, &fake.CloudProvider{})
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Status", func() {
+	var p *v1alpha4.Provisioner
+	BeforeEach(func() {
+		p = &v1alpha4.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name}}
+	})
+	AfterEach(func() {
+		ExpectCleanedUp(env.Client)
+	})
+
+	nodeWith := func(ready v1.ConditionStatus, cpu string) *v1.Node {
+		return test.Node(test.NodeOptions{
+			Labels:      map[string]string{v1alpha4.ProvisionerNameLabelKey: p.Name},
+			ReadyStatus: ready,
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu), v1.ResourceMemory: resource.MustParse("1Gi")},
+		})
+	}
+	expectStatus := func() v1alpha4.ProvisionerStatus {
+		persisted := &v1alpha4.Provisioner{}
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(p), persisted)).To(Succeed())
+		return persisted.Status
+	}
+
+	It("should summarize the provisioner's nodes", func() {
+		ExpectCreated(env.Client, p)
+		ExpectCreatedWithStatus(env.Client, nodeWith(v1.ConditionTrue, "2"), nodeWith(v1.ConditionTrue, "4"), nodeWith(v1.ConditionFalse, "1"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(p))
+
+		status := expectStatus()
+		Expect(status.ReadyNodes).To(BeNumerically("==", 2))
+		Expect(status.NotReadyNodes).To(BeNumerically("==", 1))
+		Expect(status.Allocatable.Cpu().String()).To(Equal("7"))
+		Expect(status.Allocatable.Memory().String()).To(Equal("3Gi"))
+		Expect(status.LastScaleTime).ToNot(BeNil())
+	})
+	It("should report missing permissions", func() {
+		ExpectCreated(env.Client, p)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(p))
+		persisted := &v1alpha4.Provisioner{}
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(p), persisted)).To(Succeed())
+		Expect(persisted.StatusConditions().GetCondition(v1alpha4.Permitted).IsTrue()).To(BeTrue())
+
+		ExpectReconcileSucceeded(permissions.Inject(ctx, []permissions.Permission{permissions.CreateEvents}), controller, client.ObjectKeyFromObject(p))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(p), persisted)).To(Succeed())
+		condition := persisted.StatusConditions().GetCondition(v1alpha4.Permitted)
+		Expect(condition.IsFalse()).To(BeTrue())
+		Expect(condition.Message).To(ContainSubstring("create events"))
+	})
+	It("should record the instance types and zones the provisioner resolves to", func() {
+		v1alpha4.WellKnownLabels.Register(v1.LabelTopologyZone, "test-zone-1", "test-zone-2")
+		v1alpha4.WellKnownLabels.Register(v1.LabelInstanceTypeStable, "m5.large", "t3.micro")
+		v1alpha4.Settings = v1alpha4.NewGlobalSettings("t3", "")
+		defer func() { v1alpha4.Settings = v1alpha4.GlobalSettings{} }()
+		p.Spec.Zones = []string{"test-zone-2"}
+		ExpectCreated(env.Client, p)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(p))
+
+		status := expectStatus()
+		Expect(status.InstanceTypes).To(ContainElement("m5.large"))
+		Expect(status.InstanceTypes).ToNot(ContainElement("t3.micro"))
+		Expect(status.Zones).To(Equal([]string{"test-zone-2"}))
+	})
+	It("should ignore nodes of other provisioners", func() {
+		other := nodeWith(v1.ConditionTrue, "2")
+		other.Labels[v1alpha4.ProvisionerNameLabelKey] = "other"
+		ExpectCreated(env.Client, p)
+		ExpectCreatedWithStatus(env.Client, other)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(p))
+
+		status := expectStatus()
+		Expect(status.ReadyNodes).To(BeNumerically("==", 0))
+		Expect(status.Allocatable).To(BeEmpty())
+		Expect(status.LastScaleTime).To(BeNil())
+		Expect(status.LastProvisionTime).To(BeNil())
+		Expect(status.Summary).To(Equal("0 nodes"))
+	})
+	It("should summarize the provisioner's nodes in one line", func() {
+		ExpectCreated(env.Client, p)
+		ExpectCreatedWithStatus(env.Client, nodeWith(v1.ConditionTrue, "2"), nodeWith(v1.ConditionTrue, "4"), nodeWith(v1.ConditionFalse, "1"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(p))
+
+		status := expectStatus()
+		Expect(status.LastProvisionTime).ToNot(BeNil())
+		Expect(status.Summary).To(Equal(fmt.Sprintf("3 nodes (66%% ready), 7 cpu, 3Gi memory, last provisioned %s",
+			status.LastProvisionTime.Inner.UTC().Format(time.RFC3339))))
+	})
+	It("should only update the last scale time when the number of nodes changes", func() {
+		ExpectCreated(env.Client, p)
+		node := nodeWith(v1.ConditionFalse, "2")
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(p))
+		scaled := expectStatus().LastScaleTime
+		Expect(scaled).ToNot(BeNil())
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+		Expect(env.Client.Status().Update(ctx, node)).To(Succeed())
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(p))
+
+		status := expectStatus()
+		Expect(status.ReadyNodes).To(BeNumerically("==", 1))
+		Expect(status.NotReadyNodes).To(BeNumerically("==", 0))
+		Expect(status.LastScaleTime.Inner.Equal(&scaled.Inner)).To(BeTrue())
+	})
+})
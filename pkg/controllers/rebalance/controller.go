@@ -0,0 +1,315 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rebalance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/approval"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/controllers/termination"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+)
+
+const (
+	controllerName = "Rebalance"
+	// DefaultSkewDuration is how long zones must stay skewed before nodes are
+	// moved, if not specified by the provisioner
+	DefaultSkewDuration = 30 * time.Minute
+	// rebalanceInterval is how often a provisioner's zones are checked
+	rebalanceInterval = 5 * time.Minute
+	// rollInterval is how often a provisioner's zones are checked while
+	// skewed or while a node is being moved
+	rollInterval = 30 * time.Second
+)
+
+var zoneSkewGaugeVec = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "rebalance_controller",
+		Name:      "zone_skew",
+		Help:      "Difference between the number of nodes in the provisioner's most and least populated zones. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(zoneSkewGaugeVec)
+}
+
+// Controller rebalances the nodes of provisioners with a ZoneRebalance across
+// the zones they may launch in. Once the difference between the number of
+// nodes in the most and least populated zones has exceeded MaxSkew for
+// SkewDuration, a node in the most populated zone is annotated with the least
+// populated zone and deleted. The allocation controller launches replacement
+// capacity for its pods in that zone, and the termination controller drains
+// it. Nodes are moved one at a time, within the provisioner's disruption
+// budget.
+type Controller struct {
+	kubeClient    client.Client
+	cloudProvider cloudprovider.CloudProvider
+	recorder      record.EventRecorder
+	gate          *approval.Gate
+	coordinator   *deprovisioning.Coordinator
+
+	mu sync.Mutex
+	// skewedSince is when each provisioner's zones were first observed to be
+	// skewed, cleared once they're balanced
+	skewedSince map[string]time.Time
+}
+
+// NewController constructs a controller instance
+func NewController(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider, recorder record.EventRecorder, coordinator *deprovisioning.Coordinator) *Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		cloudProvider: cloudProvider,
+		recorder:      recorder,
+		gate:          approval.NewGate(),
+		coordinator:   coordinator,
+		skewedSince:   map[string]time.Time{},
+	}
+}
+
+// Reconcile executes a rebalance control loop for the provisioner
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(fmt.Sprintf("rebalance.provisioner/%s", req.Name)))
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			c.reset(req.Name)
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if provisioner.Spec.ZoneRebalance == nil {
+		c.reset(provisioner.Name)
+		return reconcile.Result{}, nil
+	}
+	zones, err := c.zonesFor(ctx, provisioner)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	// Group the nodes by allowed zone, ignoring terminating nodes
+	byZone := map[string][]*v1.Node{}
+	for _, zone := range zones {
+		byZone[zone] = nil
+	}
+	moving := false
+	for _, node := range ptr.NodeListToSlice(nodes) {
+		if !node.DeletionTimestamp.IsZero() {
+			moving = moving || node.Annotations[v1alpha4.RebalanceZoneAnnotationKey] != ""
+			continue
+		}
+		if _, ok := byZone[node.Labels[v1.LabelTopologyZone]]; ok {
+			byZone[node.Labels[v1.LabelTopologyZone]] = append(byZone[node.Labels[v1.LabelTopologyZone]], node)
+		}
+	}
+	if len(byZone) < 2 {
+		c.reset(provisioner.Name)
+		return reconcile.Result{RequeueAfter: rebalanceInterval}, nil
+	}
+	overweighted, underweighted := extremes(byZone)
+	skew := len(byZone[overweighted]) - len(byZone[underweighted])
+	zoneSkewGaugeVec.WithLabelValues(provisioner.Name).Set(float64(skew))
+	if skew <= int(provisioner.Spec.ZoneRebalance.MaxSkew) {
+		c.reset(provisioner.Name)
+		return reconcile.Result{RequeueAfter: rebalanceInterval}, nil
+	}
+	if skewed := c.skewedFor(provisioner.Name); skewed < skewDurationFor(provisioner) {
+		logging.FromContext(ctx).Debugf("Zones %s and %s have been skewed by %d node(s) for %s", overweighted, underweighted, skew, skewed.Round(time.Second))
+		return reconcile.Result{RequeueAfter: rollInterval}, nil
+	}
+	// Move one node at a time, waiting for the previous move to complete
+	if moving {
+		return reconcile.Result{RequeueAfter: rollInterval}, nil
+	}
+	node, err := c.candidate(ctx, provisioner, byZone[overweighted])
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if node == nil {
+		logging.FromContext(ctx).Debugf("Unable to rebalance zone %s, no nodes can be moved", overweighted)
+		return reconcile.Result{RequeueAfter: rebalanceInterval}, nil
+	}
+	// Wait for the node to be approved, rather than moving another
+	if approved, err := c.gate.ApproveAndPersist(ctx, c.kubeClient, provisioner, node, approval.Rebalance); err != nil || !approved {
+		return reconcile.Result{RequeueAfter: approval.RetryInterval}, err
+	}
+	if allowed, err := c.coordinator.Allow(ctx, provisioner, node); err != nil || !allowed {
+		return reconcile.Result{RequeueAfter: deprovisioning.RetryInterval}, err
+	}
+	logging.FromContext(ctx).Infof("Moving node %s from zone %s to zone %s, zones are skewed by %d node(s)", node.Name, overweighted, underweighted, skew)
+	c.recorder.Eventf(node, v1.EventTypeNormal, "RebalancingZones", "Moving node from zone %s to zone %s", overweighted, underweighted)
+	persisted := node.DeepCopy()
+	node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha4.RebalanceZoneAnnotationKey: underweighted})
+	if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching node %s, %w", node.Name, err)
+	}
+	if err := c.kubeClient.Delete(ctx, node); err != nil {
+		return reconcile.Result{}, fmt.Errorf("deleting node %s, %w", node.Name, err)
+	}
+	c.reset(provisioner.Name)
+	return reconcile.Result{RequeueAfter: rollInterval}, nil
+}
+
+// zonesFor returns the healthy zones that the provisioner may launch nodes in
+func (c *Controller) zonesFor(ctx context.Context, provisioner *v1alpha4.Provisioner) ([]string, error) {
+	zones := sets.NewString(provisioner.Spec.Zones...)
+	if zones.Len() == 0 {
+		zones.Insert(v1alpha4.DefaultZones()...)
+	}
+	if zones.Len() == 0 {
+		instanceTypes, err := c.cloudProvider.GetInstanceTypes(ctx, &provisioner.Spec.Constraints)
+		if err != nil {
+			return nil, fmt.Errorf("getting instance types, %w", err)
+		}
+		for _, instanceType := range instanceTypes {
+			zones.Insert(instanceType.Zones()...)
+		}
+	}
+	return zones.Delete(provisioner.UnhealthyZones()...).List(), nil
+}
+
+// candidate returns the oldest node whose pods can all move to another zone,
+// or nil if there is none
+func (c *Controller) candidate(ctx context.Context, provisioner *v1alpha4.Provisioner, nodes []*v1.Node) (*v1.Node, error) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].CreationTimestamp.Before(&nodes[j].CreationTimestamp)
+	})
+	for _, node := range nodes {
+		if !nodeutil.IsDisruptable(provisioner, node) {
+			continue
+		}
+		pods := &v1.PodList{}
+		if err := c.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": node.Name}); err != nil {
+			return nil, fmt.Errorf("listing pods on node %s, %w", node.Name, err)
+		}
+		if movable(termination.GetEvictablePods(ptr.PodListToSlice(pods))) {
+			return node, nil
+		}
+	}
+	return nil, nil
+}
+
+// skewedFor returns how long the provisioner's zones have been skewed,
+// starting the clock if they weren't already
+func (c *Controller) skewedFor(name string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	since, ok := c.skewedSince[name]
+	if !ok {
+		since = injectabletime.Now()
+		c.skewedSince[name] = since
+	}
+	return injectabletime.Now().Sub(since)
+}
+
+func (c *Controller) reset(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.skewedSince, name)
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha4.Provisioner{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
+}
+
+func skewDurationFor(provisioner *v1alpha4.Provisioner) time.Duration {
+	if provisioner.Spec.ZoneRebalance.SkewDuration == nil {
+		return DefaultSkewDuration
+	}
+	return provisioner.Spec.ZoneRebalance.SkewDuration.Duration
+}
+
+// extremes returns the most and least populated zones, breaking ties by name
+func extremes(byZone map[string][]*v1.Node) (string, string) {
+	zones := []string{}
+	for zone := range byZone {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	overweighted, underweighted := zones[0], zones[0]
+	for _, zone := range zones[1:] {
+		if len(byZone[zone]) > len(byZone[overweighted]) {
+			overweighted = zone
+		}
+		if len(byZone[zone]) < len(byZone[underweighted]) {
+			underweighted = zone
+		}
+	}
+	return overweighted, underweighted
+}
+
+// movable returns true if none of the pods are tied to their zone, either by
+// requiring it or by mounting persistent volumes, which may be zonal
+func movable(pods []*v1.Pod) bool {
+	for _, pod := range pods {
+		if pod.Annotations[v1alpha4.DoNotEvictPodAnnotationKey] == "true" {
+			return false
+		}
+		for _, key := range []string{v1.LabelTopologyZone, v1.LabelFailureDomainBetaZone} {
+			if _, ok := pod.Spec.NodeSelector[key]; ok {
+				return false
+			}
+		}
+		if pod.Spec.Affinity != nil && pod.Spec.Affinity.NodeAffinity != nil && pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+			for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				for _, requirement := range term.MatchExpressions {
+					if requirement.Key == v1.LabelTopologyZone || requirement.Key == v1.LabelFailureDomainBetaZone {
+						return false
+					}
+				}
+			}
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rebalance_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
+	"github.com/awslabs/karpenter/pkg/controllers/rebalance"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/test"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *rebalance.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Rebalance")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Rebalance", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		controller = rebalance.NewController(env.Client, &fake.CloudProvider{}, record.NewFakeRecorder(100), deprovisioning.NewCoordinator(env.Client))
+		provisioner = &v1alpha4.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name},
+			Spec: v1alpha4.ProvisionerSpec{
+				Constraints:   v1alpha4.Constraints{Zones: []string{"test-zone-1", "test-zone-2"}},
+				ZoneRebalance: &v1alpha4.ZoneRebalance{MaxSkew: 1},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		injectabletime.Now = time.Now
+		ExpectCleanedUp(env.Client)
+	})
+
+	nodeIn := func(zone string) *v1.Node {
+		return test.Node(test.NodeOptions{
+			Finalizers: []string{v1alpha4.TerminationFinalizer},
+			Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name, v1.LabelTopologyZone: zone},
+		})
+	}
+	// skew reconciles the provisioner before and after the skew duration
+	skew := func() {
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		injectabletime.Now = func() time.Time { return time.Now().Add(rebalance.DefaultSkewDuration) }
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+	}
+	moved := func(nodes ...*v1.Node) []*v1.Node {
+		result := []*v1.Node{}
+		for _, node := range nodes {
+			if node = ExpectNodeExists(env.Client, node.Name); !node.DeletionTimestamp.IsZero() {
+				result = append(result, node)
+			}
+		}
+		return result
+	}
+
+	It("should not move nodes while zones are within maxSkew", func() {
+		nodes := []*v1.Node{nodeIn("test-zone-1"), nodeIn("test-zone-1"), nodeIn("test-zone-2")}
+		ExpectCreated(env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+		skew()
+
+		Expect(moved(nodes...)).To(BeEmpty())
+	})
+	It("should not move nodes before the skew duration has elapsed", func() {
+		nodes := []*v1.Node{nodeIn("test-zone-1"), nodeIn("test-zone-1"), nodeIn("test-zone-1")}
+		ExpectCreated(env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		Expect(moved(nodes...)).To(BeEmpty())
+	})
+	It("should move one node to the least populated zone once skewed for the skew duration", func() {
+		nodes := []*v1.Node{nodeIn("test-zone-1"), nodeIn("test-zone-1"), nodeIn("test-zone-1")}
+		ExpectCreated(env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+		skew()
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		result := moved(nodes...)
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].Annotations).To(HaveKeyWithValue(v1alpha4.RebalanceZoneAnnotationKey, "test-zone-2"))
+	})
+	It("should not move nodes without zoneRebalance", func() {
+		provisioner.Spec.ZoneRebalance = nil
+		nodes := []*v1.Node{nodeIn("test-zone-1"), nodeIn("test-zone-1"), nodeIn("test-zone-1")}
+		ExpectCreated(env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+		skew()
+
+		Expect(moved(nodes...)).To(BeEmpty())
+	})
+	It("should not consider unhealthy zones", func() {
+		provisioner.Annotations = map[string]string{v1alpha4.UnhealthyZonesAnnotationKey: "test-zone-2"}
+		nodes := []*v1.Node{nodeIn("test-zone-1"), nodeIn("test-zone-1"), nodeIn("test-zone-1")}
+		ExpectCreated(env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+		skew()
+
+		Expect(moved(nodes...)).To(BeEmpty())
+	})
+	It("should not move nodes with pods that mount persistent volumes", func() {
+		nodes := []*v1.Node{nodeIn("test-zone-1"), nodeIn("test-zone-1"), nodeIn("test-zone-1")}
+		ExpectCreated(env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+		for _, node := range nodes {
+			ExpectCreated(env.Client, test.Pod(test.PodOptions{NodeName: node.Name, PersistentVolumeClaims: []string{"claim"}}))
+		}
+		skew()
+
+		Expect(moved(nodes...)).To(BeEmpty())
+	})
+	It("should wait for approval to move nodes", func() {
+		provisioner.Spec.DisruptionApproval = &v1alpha4.DisruptionApproval{}
+		nodes := []*v1.Node{nodeIn("test-zone-1"), nodeIn("test-zone-1"), nodeIn("test-zone-1")}
+		ExpectCreated(env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+		skew()
+
+		Expect(moved(nodes...)).To(BeEmpty())
+		pending := 0
+		for _, node := range nodes {
+			if ExpectNodeExists(env.Client, node.Name).Annotations[v1alpha4.DisruptionPendingAnnotationKey] == "Rebalance" {
+				pending++
+			}
+		}
+		Expect(pending).To(Equal(1))
+	})
+})
@@ -0,0 +1,238 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Controllers")
+}
+
+var _ = Describe("Cache", func() {
+	var clientSet *fake.Clientset
+	var stop chan struct{}
+
+	BeforeEach(func() {
+		clientSet = fake.NewSimpleClientset(
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default", Labels: map[string]string{"app": "test"},
+					ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "test"}}},
+				Status: v1.PodStatus{PodIP: "10.0.0.1", ContainerStatuses: []v1.ContainerStatus{{Name: "test"}}, Phase: v1.PodPending},
+			},
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "other", Labels: map[string]string{"app": "test"}},
+				Spec:       v1.PodSpec{NodeName: "karpenter-node"},
+			},
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "excluded", Namespace: "default"}},
+			&v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "karpenter-node", Labels: map[string]string{"karpenter.sh/provisioner-name": "default"}},
+				Status:     v1.NodeStatus{Images: []v1.ContainerImage{{Names: []string{"test"}}}, Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}},
+			},
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other-node"}},
+		)
+		stop = make(chan struct{})
+	})
+	AfterEach(func() {
+		close(stop)
+	})
+
+	start := func(options CacheOptions) *selectiveCache {
+		c, err := newSelectiveCache(nil, clientSet, "", options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.IndexField(ctx, &v1.Pod{}, "spec.nodeName", podSchedulingIndex)).To(Succeed())
+		go c.pods.Run(stop)
+		go c.nodes.Run(stop)
+		Expect(toolscache.WaitForCacheSync(stop, c.pods.HasSynced, c.nodes.HasSynced)).To(BeTrue())
+		return c
+	}
+
+	It("should only cache pods and nodes that match the selectors", func() {
+		c := start(CacheOptions{PodLabelSelector: "app=test", NodeLabelSelector: "karpenter.sh/provisioner-name"})
+		pods := &v1.PodList{}
+		Expect(c.List(ctx, pods)).To(Succeed())
+		Expect(pods.Items).To(HaveLen(2))
+		nodes := &v1.NodeList{}
+		Expect(c.List(ctx, nodes)).To(Succeed())
+		Expect(nodes.Items).To(HaveLen(1))
+		Expect(nodes.Items[0].Name).To(Equal("karpenter-node"))
+		err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "excluded"}, &v1.Pod{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+	It("should strip unused fields", func() {
+		c := start(CacheOptions{StripUnusedFields: true})
+		pod := &v1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "pending"}, pod)).To(Succeed())
+		Expect(pod.ManagedFields).To(BeNil())
+		Expect(pod.Status.ContainerStatuses).To(BeNil())
+		Expect(pod.Status.PodIP).To(BeEmpty())
+		Expect(pod.Status.Phase).To(Equal(v1.PodPending))
+		Expect(pod.Labels).To(HaveKeyWithValue("app", "test"))
+		node := &v1.Node{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "karpenter-node"}, node)).To(Succeed())
+		Expect(node.Status.Images).To(BeNil())
+		Expect(node.Status.Allocatable.Cpu().String()).To(Equal("4"))
+	})
+	It("should strip unused fields from watched pods", func() {
+		c := start(CacheOptions{StripUnusedFields: true})
+		_, err := clientSet.CoreV1().Pods("default").Create(ctx, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "created", Namespace: "default", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "test"}}},
+			Status:     v1.PodStatus{PodIP: "10.0.0.2"},
+		}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		pod := &v1.Pod{}
+		Eventually(func() error { return c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "created"}, pod) }).Should(Succeed())
+		Expect(pod.ManagedFields).To(BeNil())
+		Expect(pod.Status.PodIP).To(BeEmpty())
+	})
+	It("should keep fields if not stripping them", func() {
+		c := start(CacheOptions{PodLabelSelector: "app=test"})
+		pod := &v1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "pending"}, pod)).To(Succeed())
+		Expect(pod.ManagedFields).To(HaveLen(1))
+		Expect(pod.Status.PodIP).To(Equal("10.0.0.1"))
+	})
+	It("should list by namespace, labels and indexed fields", func() {
+		c := start(CacheOptions{StripUnusedFields: true})
+		pods := &v1.PodList{}
+		Expect(c.List(ctx, pods, client.InNamespace("default"))).To(Succeed())
+		Expect(pods.Items).To(HaveLen(2))
+		Expect(c.List(ctx, pods, client.InNamespace("default"), client.MatchingLabels{"app": "test"})).To(Succeed())
+		Expect(pods.Items).To(HaveLen(1))
+		Expect(pods.Items[0].Name).To(Equal("pending"))
+		Expect(c.List(ctx, pods, client.MatchingFields{"spec.nodeName": "karpenter-node"})).To(Succeed())
+		Expect(pods.Items).To(HaveLen(1))
+		Expect(pods.Items[0].Name).To(Equal("running"))
+		Expect(c.List(ctx, pods, client.InNamespace("default"), client.MatchingFields{"spec.nodeName": "karpenter-node"})).To(Succeed())
+		Expect(pods.Items).To(BeEmpty())
+		Expect(c.List(ctx, pods, client.MatchingFields{"spec.schedulerName": "default"})).ToNot(Succeed())
+	})
+	It("should serve its own informers for pods and nodes", func() {
+		c := start(CacheOptions{StripUnusedFields: true})
+		informer, err := c.GetInformer(ctx, &v1.Pod{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(informer).To(Equal(c.pods))
+		informer, err = c.GetInformerForKind(ctx, v1.SchemeGroupVersion.WithKind("Node"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(informer).To(Equal(c.nodes))
+	})
+	It("should reject invalid selectors", func() {
+		_, err := newSelectiveCache(nil, clientSet, "", CacheOptions{PodFieldSelector: "status.phase"})
+		Expect(err).To(HaveOccurred())
+		_, err = newSelectiveCache(nil, clientSet, "", CacheOptions{NodeLabelSelector: "!!"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ReaderCache", func() {
+	var server *httptest.Server
+	var limiter *countingRateLimiter
+	var config *rest.Config
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("watch") == "true" {
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+				return
+			}
+			if strings.HasSuffix(r.URL.Path, "/nodes") {
+				fmt.Fprint(w, `{"kind":"NodeList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[{"metadata":{"name":"karpenter-node"}}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[{"metadata":{"name":"test","namespace":"default"},"spec":{"nodeName":"karpenter-node"}}]}`)
+		}))
+		limiter = &countingRateLimiter{RateLimiter: flowcontrol.NewFakeAlwaysRateLimiter()}
+		config = &rest.Config{Host: server.URL, RateLimiter: limiter}
+	})
+	AfterEach(func() {
+		cancel()
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	start := func(options CacheOptions) cache.Cache {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(v1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+		mapper.Add(v1.SchemeGroupVersion.WithKind("Node"), meta.RESTScopeRoot)
+		c, err := newIndexedCache(config, cache.Options{Scheme: scheme.Scheme, Mapper: mapper}, options)
+		Expect(err).ToNot(HaveOccurred())
+		var cacheCtx context.Context
+		cacheCtx, cancel = context.WithCancel(ctx)
+		go func() { _ = c.Start(cacheCtx) }()
+		Expect(c.WaitForCacheSync(cacheCtx)).To(BeTrue())
+		return c
+	}
+
+	It("should list and watch through the config's rate limiter", func() {
+		c := start(CacheOptions{})
+		pods := &v1.PodList{}
+		Expect(c.List(ctx, pods, client.MatchingFields{"spec.nodeName": "karpenter-node"})).To(Succeed())
+		Expect(pods.Items).To(HaveLen(1))
+		Expect(atomic.LoadInt64(&limiter.requests)).To(BeNumerically(">", 0))
+	})
+	It("should list and watch selected pods and nodes through the config's rate limiter", func() {
+		c := start(CacheOptions{StripUnusedFields: true})
+		Expect(atomic.LoadInt64(&limiter.requests)).To(BeNumerically(">", 0))
+		pods := &v1.PodList{}
+		Expect(c.List(ctx, pods, client.MatchingFields{"spec.nodeName": "karpenter-node"})).To(Succeed())
+		Expect(pods.Items).To(HaveLen(1))
+	})
+})
+
+// countingRateLimiter counts the requests it admits
+type countingRateLimiter struct {
+	flowcontrol.RateLimiter
+	requests int64
+}
+
+func (l *countingRateLimiter) Accept() {
+	atomic.AddInt64(&l.requests, 1)
+	l.RateLimiter.Accept()
+}
+
+func (l *countingRateLimiter) Wait(ctx context.Context) error {
+	atomic.AddInt64(&l.requests, 1)
+	return l.RateLimiter.Wait(ctx)
+}
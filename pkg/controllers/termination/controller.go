@@ -25,6 +25,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -34,6 +35,8 @@ import (
 
 	provisioning "github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 )
 
@@ -44,7 +47,7 @@ type Controller struct {
 }
 
 // NewController constructs a controller instance
-func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider) *Controller {
+func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, recorder record.EventRecorder, notifier *lifecycle.Notifier) *Controller {
 	return &Controller{
 		KubeClient: kubeClient,
 		Terminator: &Terminator{
@@ -52,6 +55,8 @@ func NewController(ctx context.Context, kubeClient client.Client, coreV1Client c
 			CoreV1Client:  coreV1Client,
 			CloudProvider: cloudProvider,
 			EvictionQueue: NewEvictionQueue(ctx, coreV1Client),
+			Recorder:      recorder,
+			Notifier:      notifier,
 		},
 	}
 }
@@ -73,7 +78,10 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	if node.DeletionTimestamp.IsZero() || !functional.ContainsString(node.Finalizers, provisioning.TerminationFinalizer) {
 		return reconcile.Result{}, nil
 	}
-	// 3. Cordon node
+	// 3. Mark node as terminating and cordon it
+	if err := c.Terminator.markTerminating(ctx, node); err != nil {
+		return reconcile.Result{}, err
+	}
 	if err := c.Terminator.cordon(ctx, node); err != nil {
 		return reconcile.Result{}, fmt.Errorf("cordoning node %s, %w", node.Name, err)
 	}
@@ -107,5 +115,5 @@ func (c *Controller) Register(_ context.Context, m manager.Manager) error {
 				MaxConcurrentReconciles: 10,
 			},
 		).
-		Complete(c)
+		Complete(metrics.NewInstrumentedReconciler("Termination", c))
 }
@@ -0,0 +1,123 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package termination
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	provisioning "github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/permissions"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+)
+
+// defaultTerminationGracePeriod is used for pods that don't specify terminationGracePeriodSeconds
+const defaultTerminationGracePeriod = 30 * time.Second
+
+var expectedDrainHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "termination_controller",
+		Name:      "expected_drain_duration_seconds",
+		Help:      "Expected duration to drain nodes, estimated from pods' termination grace periods when termination begins. Broken down by provisioner.",
+		Buckets:   metrics.DurationBuckets(),
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(expectedDrainHistogramVec)
+}
+
+// DrainEstimate is the expected time to drain a node's pods
+type DrainEstimate struct {
+	// Duration is the longest termination grace period of the pods that can be
+	// evicted, since pods are evicted in parallel
+	Duration time.Duration
+	// Pods is the number of pods to be evicted
+	Pods int
+	// Blocked is the number of pods that cannot currently be evicted due to
+	// pod disruption budgets, which may delay the drain indefinitely
+	Blocked int
+}
+
+func (d DrainEstimate) String() string {
+	message := fmt.Sprintf("Draining %d pod(s), expected to complete within %s", d.Pods, d.Duration)
+	if d.Blocked > 0 {
+		message += fmt.Sprintf(", %d pod(s) blocked by pod disruption budgets", d.Blocked)
+	}
+	return message
+}
+
+// EstimateDrain estimates how long it will take to evict the pods, based on
+// their termination grace periods and the state of pod disruption budgets.
+func EstimateDrain(ctx context.Context, kubeClient client.Client, pods []*v1.Pod) (DrainEstimate, error) {
+	estimate := DrainEstimate{}
+	pdbs := map[string][]v1beta1.PodDisruptionBudget{}
+	for _, pod := range GetEvictablePods(pods) {
+		estimate.Pods++
+		gracePeriod := defaultTerminationGracePeriod
+		if pod.Spec.TerminationGracePeriodSeconds != nil {
+			gracePeriod = time.Duration(ptr.Int64Value(pod.Spec.TerminationGracePeriodSeconds)) * time.Second
+		}
+		if gracePeriod > estimate.Duration {
+			estimate.Duration = gracePeriod
+		}
+		// Pod disruption budgets can't be checked with reduced RBAC
+		if !permissions.Allowed(ctx, permissions.ListPodDisruptionBudgets) {
+			continue
+		}
+		if _, ok := pdbs[pod.Namespace]; !ok {
+			pdbList := &v1beta1.PodDisruptionBudgetList{}
+			if err := kubeClient.List(ctx, pdbList, client.InNamespace(pod.Namespace)); err != nil {
+				return DrainEstimate{}, fmt.Errorf("listing pod disruption budgets, %w", err)
+			}
+			pdbs[pod.Namespace] = pdbList.Items
+		}
+		if isBlocked(pod, pdbs[pod.Namespace]) {
+			estimate.Blocked++
+		}
+	}
+	return estimate, nil
+}
+
+// isBlocked returns true if a pod disruption budget that selects the pod allows no disruptions
+func isBlocked(pod *v1.Pod, pdbs []v1beta1.PodDisruptionBudget) bool {
+	for _, pdb := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) && pdb.Status.DisruptionsAllowed <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// observeDrainEstimate records the estimate when a node begins terminating
+func observeDrainEstimate(node *v1.Node, estimate DrainEstimate) {
+	expectedDrainHistogramVec.WithLabelValues(node.Labels[provisioning.ProvisionerNameLabelKey]).Observe(estimate.Duration.Seconds())
+}
@@ -16,9 +16,11 @@ package termination
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	set "github.com/deckarep/golang-set"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -33,6 +35,13 @@ import (
 const (
 	evictionQueueBaseDelay = 100 * time.Millisecond
 	evictionQueueMaxDelay  = 10 * time.Second
+	// evictionWorkers bounds the number of concurrent eviction calls
+	evictionWorkers = 10
+	// Evictions are rate limited per namespace, since pods of the same
+	// namespace are likely to share pod disruption budgets, so a namespace
+	// with many pods doesn't starve the others of workers
+	evictionNamespaceQPS   = 20
+	evictionNamespaceBurst = 50
 )
 
 type EvictionQueue struct {
@@ -40,6 +49,9 @@ type EvictionQueue struct {
 	set.Set
 
 	coreV1Client corev1.CoreV1Interface
+
+	mu                sync.Mutex
+	namespaceLimiters map[string]*rate.Limiter
 }
 
 func NewEvictionQueue(ctx context.Context, coreV1Client corev1.CoreV1Interface) *EvictionQueue {
@@ -47,7 +59,8 @@ func NewEvictionQueue(ctx context.Context, coreV1Client corev1.CoreV1Interface)
 		RateLimitingInterface: workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(evictionQueueBaseDelay, evictionQueueMaxDelay)),
 		Set:                   set.NewSet(),
 
-		coreV1Client: coreV1Client,
+		coreV1Client:      coreV1Client,
+		namespaceLimiters: map[string]*rate.Limiter{},
 	}
 	go queue.Start(ctx)
 	return queue
@@ -63,14 +76,34 @@ func (e *EvictionQueue) Add(pods []*v1.Pod) {
 	}
 }
 
+// Start evicts pods with parallel workers until the queue is shut down
 func (e *EvictionQueue) Start(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < evictionWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.work(ctx)
+		}()
+	}
+	wg.Wait()
+	logging.FromContext(ctx).Errorf("EvictionQueue is broken and has shutdown.")
+}
+
+func (e *EvictionQueue) work(ctx context.Context) {
 	for {
 		// Get pod from queue. This waits until queue is non-empty.
 		item, shutdown := e.RateLimitingInterface.Get()
 		if shutdown {
-			break
+			return
 		}
 		nn := item.(types.NamespacedName)
+		// Requeue pod if its namespace is rate limited, rather than blocking the worker
+		if !e.limiterFor(nn.Namespace).Allow() {
+			e.RateLimitingInterface.Done(nn)
+			e.RateLimitingInterface.AddAfter(nn, time.Second/evictionNamespaceQPS)
+			continue
+		}
 		// Evict pod
 		if e.evict(ctx, nn) {
 			logging.FromContext(ctx).Debugf("Evicted pod %s", nn.String())
@@ -83,7 +116,18 @@ func (e *EvictionQueue) Start(ctx context.Context) {
 		// Requeue pod if eviction failed
 		e.RateLimitingInterface.AddRateLimited(nn)
 	}
-	logging.FromContext(ctx).Errorf("EvictionQueue is broken and has shutdown.")
+}
+
+// limiterFor returns the rate limiter of evictions in the namespace
+func (e *EvictionQueue) limiterFor(namespace string) *rate.Limiter {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	limiter, ok := e.namespaceLimiters[namespace]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(evictionNamespaceQPS), evictionNamespaceBurst)
+		e.namespaceLimiters[namespace] = limiter
+	}
+	return limiter
 }
 
 // evict returns true if successful eviction call, error is returned if not eviction-related error
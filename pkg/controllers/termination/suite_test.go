@@ -28,20 +28,25 @@ import (
 	"github.com/awslabs/karpenter/pkg/test"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	. "github.com/awslabs/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
 )
 
 var ctx context.Context
 var controller *termination.Controller
 var evictionQueue *termination.EvictionQueue
+var recorder *record.FakeRecorder
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -56,6 +61,7 @@ var _ = BeforeSuite(func() {
 		registry.RegisterOrDie(ctx, cloudProvider)
 		coreV1Client := corev1.NewForConfigOrDie(e.Config)
 		evictionQueue = termination.NewEvictionQueue(ctx, coreV1Client)
+		recorder = record.NewFakeRecorder(100)
 		controller = &termination.Controller{
 			KubeClient: e.Client,
 			Terminator: &termination.Terminator{
@@ -63,6 +69,7 @@ var _ = BeforeSuite(func() {
 				CoreV1Client:  coreV1Client,
 				CloudProvider: cloudProvider,
 				EvictionQueue: evictionQueue,
+				Recorder:      recorder,
 			},
 		}
 	})
@@ -93,6 +100,29 @@ var _ = Describe("Termination", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
 			ExpectNotFound(env.Client, node)
 		})
+		It("should set the terminating condition while draining", func() {
+			pod := test.Pod(test.PodOptions{NodeName: node.Name})
+			ExpectCreated(env.Client, node, pod)
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			node = ExpectNodeExists(env.Client, node.Name)
+			condition := nodeutil.GetCondition(node.Status.Conditions, v1alpha4.NodeTerminating)
+			Expect(condition.Status).To(Equal(v1.ConditionTrue))
+			Expect(condition.Message).To(Equal("Draining 1 pod(s), expected to complete within 30s"))
+		})
+		It("should estimate drain duration from grace periods and pod disruption budgets", func() {
+			labels := map[string]string{"app": "test"}
+			pods := []*v1.Pod{
+				test.Pod(test.PodOptions{NodeName: node.Name}),
+				test.Pod(test.PodOptions{NodeName: node.Name, Labels: labels}),
+			}
+			pods[0].Spec.TerminationGracePeriodSeconds = ptr.Int64(120)
+			ExpectCreated(env.Client, test.PodDisruptionBudget(test.PDBOptions{Labels: labels, MaxUnavailable: &intstr.IntOrString{IntVal: 0}}))
+			estimate, err := termination.EstimateDrain(ctx, env.Client, pods)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(estimate).To(Equal(termination.DrainEstimate{Duration: 120 * time.Second, Pods: 2, Blocked: 1}))
+		})
 		It("should not evict pods that tolerate unschedulable taint", func() {
 			podEvict := test.Pod(test.PodOptions{NodeName: node.Name})
 			podSkip := test.Pod(test.PodOptions{
@@ -159,6 +189,30 @@ var _ = Describe("Termination", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
 			ExpectNotFound(env.Client, node)
 		})
+		It("should evict do-not-evict pods once the termination grace period elapses", func() {
+			provisioner := &v1alpha4.Provisioner{
+				ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name},
+				Spec:       v1alpha4.ProvisionerSpec{TerminationGracePeriod: &metav1.Duration{Duration: time.Minute}},
+			}
+			node.Labels = map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}
+			podEvict := test.Pod(test.PodOptions{NodeName: node.Name})
+			podNoEvict := test.Pod(test.PodOptions{
+				NodeName:    node.Name,
+				Annotations: map[string]string{v1alpha4.DoNotEvictPodAnnotationKey: "true"},
+			})
+			ExpectCreated(env.Client, provisioner, node, podEvict, podNoEvict)
+
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotEnqueuedForEviction(evictionQueue, podEvict, podNoEvict)
+			Expect(recorder.Events).To(Receive(ContainSubstring("DrainBlocked")))
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+			node = ExpectNodeExists(env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectEnqueuedForEviction(evictionQueue, podEvict, podNoEvict)
+		})
 		It("should fail to evict pods that violate a PDB", func() {
 			minAvailable := intstr.FromInt(1)
 			labelSelector := map[string]string{randomdata.SillyName(): randomdata.SillyName()}
@@ -227,6 +281,31 @@ var _ = Describe("Termination", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
 			ExpectNotFound(env.Client, node)
 		})
+		It("should evict pods in parallel", func() {
+			ExpectCreated(env.Client, node)
+			pods := []*v1.Pod{}
+			for i := 0; i < 20; i++ {
+				pod := test.Pod(test.PodOptions{NodeName: node.Name})
+				ExpectCreated(env.Client, pod)
+				pods = append(pods, pod)
+			}
+
+			// Trigger Termination Controller
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+
+			// Expect all pods to be evicting, and delete them
+			ExpectEvicted(env.Client, pods...)
+			for _, pod := range pods {
+				ExpectDeleted(env.Client, pod)
+			}
+
+			// Reconcile to delete node
+			node = ExpectNodeExists(env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotFound(env.Client, node)
+		})
 		It("should wait for pods to terminate", func() {
 			pod := test.Pod(test.PodOptions{NodeName: node.Name})
 			ExpectCreated(env.Client, node, pod)
@@ -18,25 +18,74 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	provisioning "github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	"github.com/awslabs/karpenter/pkg/lifecycle"
+	"github.com/awslabs/karpenter/pkg/metrics"
 	"github.com/awslabs/karpenter/pkg/scheduling"
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
 	"github.com/awslabs/karpenter/pkg/utils/ptr"
 )
 
+var drainBlockedCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "termination_controller",
+		Name:      "drain_blocked_total",
+		Help:      "Number of times draining a node was blocked by a pod with the do-not-evict annotation. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(drainBlockedCounterVec)
+}
+
 type Terminator struct {
 	EvictionQueue *EvictionQueue
 	KubeClient    client.Client
 	CoreV1Client  corev1.CoreV1Interface
 	CloudProvider cloudprovider.CloudProvider
+	Recorder      record.EventRecorder
+	Notifier      *lifecycle.Notifier
+}
+
+// markTerminating sets the terminating condition on the node, describing the
+// expected time to drain the node
+func (t *Terminator) markTerminating(ctx context.Context, node *v1.Node) error {
+	pods, err := t.getPods(ctx, node)
+	if err != nil {
+		return err
+	}
+	estimate, err := EstimateDrain(ctx, t.KubeClient, pods)
+	if err != nil {
+		return fmt.Errorf("estimating drain for node %s, %w", node.Name, err)
+	}
+	condition := nodeutil.GetCondition(node.Status.Conditions, provisioning.NodeTerminating)
+	if condition.Status == v1.ConditionTrue && condition.Message == estimate.String() {
+		return nil
+	}
+	if condition.Status != v1.ConditionTrue {
+		observeDrainEstimate(node, estimate)
+	}
+	persisted := node.DeepCopy()
+	nodeutil.SetCondition(node, provisioning.NodeTerminating, v1.ConditionTrue, "Draining", estimate.String())
+	if err := t.KubeClient.Status().Patch(ctx, node, client.StrategicMergeFrom(persisted)); err != nil {
+		return fmt.Errorf("patching node status %s, %w", node.Name, err)
+	}
+	return nil
 }
 
 // cordon cordons a node
@@ -63,17 +112,29 @@ func (t *Terminator) drain(ctx context.Context, node *v1.Node) (bool, error) {
 		return false, fmt.Errorf("listing pods for node %s, %w", node.Name, err)
 	}
 
-	// 2. Separate pods as non-critical and critical
-	// https://kubernetes.io/docs/concepts/architecture/nodes/#graceful-node-shutdown
+	// 2. Wait for pods with the do-not-evict annotation, unless the
+	// provisioner's termination grace period has elapsed
+	gracePeriodElapsed, err := t.terminationGracePeriodElapsed(ctx, node)
+	if err != nil {
+		return false, err
+	}
 	for _, pod := range pods {
 		if val := pod.Annotations[provisioning.DoNotEvictPodAnnotationKey]; val == "true" {
+			if gracePeriodElapsed {
+				logging.FromContext(ctx).Infof("Evicting pod %s/%s from node %s despite do-not-evict annotation, termination grace period elapsed", pod.Namespace, pod.Name, node.Name)
+				continue
+			}
 			logging.FromContext(ctx).Debugf("Unable to drain node %s, pod %s has do-not-evict annotation", node.Name, pod.Name)
+			drainBlockedCounterVec.WithLabelValues(node.Labels[provisioning.ProvisionerNameLabelKey]).Inc()
+			if t.Recorder != nil {
+				t.Recorder.Eventf(node, v1.EventTypeWarning, "DrainBlocked", "Pod %s/%s has the do-not-evict annotation", pod.Namespace, pod.Name)
+			}
 			return false, nil
 		}
 	}
 
 	// 4. Get and evict pods
-	evictable := t.getEvictablePods(pods)
+	evictable := GetEvictablePods(pods)
 	if len(evictable) == 0 {
 		return true, nil
 	}
@@ -81,7 +142,29 @@ func (t *Terminator) drain(ctx context.Context, node *v1.Node) (bool, error) {
 	return false, nil
 }
 
-// terminate calls cloud provider delete then removes the finalizer to delete the node
+// terminationGracePeriodElapsed returns true if the node has been terminating
+// for longer than its provisioner's termination grace period
+func (t *Terminator) terminationGracePeriodElapsed(ctx context.Context, node *v1.Node) (bool, error) {
+	name, ok := node.Labels[provisioning.ProvisionerNameLabelKey]
+	if !ok || node.DeletionTimestamp.IsZero() {
+		return false, nil
+	}
+	provisioner := &provisioning.Provisioner{}
+	if err := t.KubeClient.Get(ctx, types.NamespacedName{Name: name}, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("getting provisioner %s, %w", name, err)
+	}
+	if provisioner.Spec.TerminationGracePeriod == nil {
+		return false, nil
+	}
+	return !injectabletime.Now().Before(node.DeletionTimestamp.Add(provisioner.Spec.TerminationGracePeriod.Duration)), nil
+}
+
+// terminate calls cloud provider delete then removes the finalizer to delete
+// the node. Lifecycle events are only sent once both have succeeded, since
+// the node is reconciled again, and would repeat them, if either fails.
 func (t *Terminator) terminate(ctx context.Context, node *v1.Node) error {
 	// 1. Delete the instance associated with node
 	if err := t.CloudProvider.Delete(ctx, node); err != nil {
@@ -90,12 +173,11 @@ func (t *Terminator) terminate(ctx context.Context, node *v1.Node) error {
 	// 2. Remove finalizer from node in APIServer
 	persisted := node.DeepCopy()
 	node.Finalizers = functional.StringSliceWithout(node.Finalizers, provisioning.TerminationFinalizer)
-	if err := t.KubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
-		if errors.IsNotFound(err) {
-			return nil
-		}
+	if err := t.KubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil && !errors.IsNotFound(err) {
 		return fmt.Errorf("removing finalizer from node %s, %w", node.Name, err)
 	}
+	t.Notifier.Notify(ctx, lifecycle.Drained, node)
+	t.Notifier.Notify(ctx, lifecycle.Terminated, node)
 	logging.FromContext(ctx).Infof("Deleted node %s", node.Name)
 	return nil
 }
@@ -109,7 +191,8 @@ func (t *Terminator) getPods(ctx context.Context, node *v1.Node) ([]*v1.Pod, err
 	return ptr.PodListToSlice(pods), nil
 }
 
-func (t *Terminator) getEvictablePods(pods []*v1.Pod) []*v1.Pod {
+// GetEvictablePods returns the pods that will be evicted when draining a node
+func GetEvictablePods(pods []*v1.Pod) []*v1.Pod {
 	evictable := []*v1.Pod{}
 	for _, pod := range pods {
 		// Ignore if unschedulable is tolerated, since they will reschedule
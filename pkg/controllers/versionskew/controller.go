@@ -0,0 +1,194 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package versionskew
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/approval"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	nodeutil "github.com/awslabs/karpenter/pkg/utils/node"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+)
+
+const (
+	controllerName = "VersionSkew"
+	// versionSkewInterval is how often a provisioner's nodes are checked
+	versionSkewInterval = 5 * time.Minute
+	// rollInterval is how often a provisioner's nodes are checked while
+	// skewed nodes are being replaced
+	rollInterval = 30 * time.Second
+)
+
+// Controller replaces nodes whose kubelet or kube-proxy lag the control plane
+// by more than the provisioner's MaxKubeletVersionSkew, so that cluster
+// upgrades complete without manually cycling nodes. Skewed nodes are marked
+// drifted and deleted one at a time, once no other node of the provisioner is
+// terminating. Deleted nodes are drained by the termination controller, which
+// respects pod disruption budgets, and their pods are provisioned onto new
+// nodes.
+type Controller struct {
+	kubeClient    client.Client
+	serverVersion discovery.ServerVersionInterface
+	recorder      record.EventRecorder
+	gate          *approval.Gate
+	coordinator   *deprovisioning.Coordinator
+}
+
+// NewController constructs a controller instance
+func NewController(kubeClient client.Client, serverVersion discovery.ServerVersionInterface, recorder record.EventRecorder, coordinator *deprovisioning.Coordinator) *Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		serverVersion: serverVersion,
+		recorder:      recorder,
+		gate:          approval.NewGate(),
+		coordinator:   coordinator,
+	}
+}
+
+// Reconcile executes a version skew control loop for the provisioner
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(fmt.Sprintf("versionskew.provisioner/%s", req.Name)))
+	provisioner := &v1alpha4.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if provisioner.Spec.MaxKubeletVersionSkew == nil {
+		return reconcile.Result{}, nil
+	}
+	info, err := c.serverVersion.ServerVersion()
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("getting server version, %w", err)
+	}
+	controlPlane, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("parsing server version %s, %w", info.GitVersion, err)
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	terminating := false
+	skewed := []*v1.Node{}
+	for _, node := range ptr.NodeListToSlice(nodes) {
+		if !node.DeletionTimestamp.IsZero() {
+			terminating = true
+			continue
+		}
+		skew, err := skewOf(controlPlane, node)
+		if err != nil {
+			logging.FromContext(ctx).Debugf("Ignoring node %s, %s", node.Name, err.Error())
+			continue
+		}
+		if skew <= ptr.Int64Value(provisioner.Spec.MaxKubeletVersionSkew) {
+			continue
+		}
+		if err := c.markDrifted(ctx, node, controlPlane, skew); err != nil {
+			return reconcile.Result{}, err
+		}
+		skewed = append(skewed, node)
+	}
+	if len(skewed) == 0 {
+		return reconcile.Result{RequeueAfter: versionSkewInterval}, nil
+	}
+	// Replace one node at a time, once the previous node has terminated
+	if terminating {
+		return reconcile.Result{RequeueAfter: rollInterval}, nil
+	}
+	for _, node := range skewed {
+		if !nodeutil.IsDisruptable(provisioner, node) {
+			continue
+		}
+		// Wait for the node to be approved, rather than replacing another
+		if approved, err := c.gate.ApproveAndPersist(ctx, c.kubeClient, provisioner, node, approval.Drift); err != nil || !approved {
+			return reconcile.Result{RequeueAfter: approval.RetryInterval}, err
+		}
+		if allowed, err := c.coordinator.Allow(ctx, provisioner, node); err != nil || !allowed {
+			return reconcile.Result{RequeueAfter: deprovisioning.RetryInterval}, err
+		}
+		logging.FromContext(ctx).Infof("Triggering termination for node %s, kubelet %s lags control plane %s", node.Name, node.Status.NodeInfo.KubeletVersion, controlPlane)
+		if err := c.kubeClient.Delete(ctx, node); err != nil {
+			return reconcile.Result{}, fmt.Errorf("deleting node %s, %w", node.Name, err)
+		}
+		break
+	}
+	return reconcile.Result{RequeueAfter: rollInterval}, nil
+}
+
+// markDrifted annotates the node as drifted, which the node controller reflects
+// as the node's Drifted condition
+func (c *Controller) markDrifted(ctx context.Context, node *v1.Node, controlPlane *version.Version, skew int64) error {
+	if node.Annotations[v1alpha4.DriftedAnnotationKey] == "true" {
+		return nil
+	}
+	persisted := node.DeepCopy()
+	node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha4.DriftedAnnotationKey: "true"})
+	if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
+		return fmt.Errorf("patching node %s, %w", node.Name, err)
+	}
+	c.recorder.Eventf(node, v1.EventTypeNormal, "VersionSkew", "Node lags control plane %s by %d minor version(s) and will be replaced", controlPlane, skew)
+	return nil
+}
+
+// skewOf returns the number of minor versions that the node's kubelet or
+// kube-proxy, whichever is older, lag the control plane
+func skewOf(controlPlane *version.Version, node *v1.Node) (int64, error) {
+	skew := int64(0)
+	for _, component := range []string{node.Status.NodeInfo.KubeletVersion, node.Status.NodeInfo.KubeProxyVersion} {
+		if component == "" {
+			continue
+		}
+		parsed, err := version.ParseGeneric(component)
+		if err != nil {
+			return 0, fmt.Errorf("parsing version %s, %w", component, err)
+		}
+		if parsed.Major() != controlPlane.Major() {
+			return 0, fmt.Errorf("major version %s differs from control plane %s", component, controlPlane)
+		}
+		if lag := int64(controlPlane.Minor()) - int64(parsed.Minor()); lag > skew {
+			skew = lag
+		}
+	}
+	return skew, nil
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha4.Provisioner{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(metrics.NewInstrumentedReconciler(controllerName, c))
+}
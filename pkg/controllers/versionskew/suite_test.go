@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package versionskew_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/controllers/versionskew"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/test"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *versionskew.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "VersionSkew")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}, FakedServerVersion: &version.Info{GitVersion: "v1.21.2-eks-0389ca3"}}
+		controller = versionskew.NewController(e.Client, discovery, record.NewFakeRecorder(100), deprovisioning.NewCoordinator(e.Client))
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("VersionSkew", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha4.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name},
+			Spec:       v1alpha4.ProvisionerSpec{MaxKubeletVersionSkew: ptr.Int64(1)},
+		}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(env.Client)
+	})
+
+	nodeWithVersion := func(kubelet string) *v1.Node {
+		node := test.Node(test.NodeOptions{
+			Finalizers: []string{v1alpha4.TerminationFinalizer},
+			Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+		})
+		node.Status.NodeInfo.KubeletVersion = kubelet
+		node.Status.NodeInfo.KubeProxyVersion = kubelet
+		return node
+	}
+
+	It("should ignore nodes within the allowed skew", func() {
+		node := nodeWithVersion("v1.20.7-eks-135321")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.Annotations).ToNot(HaveKey(v1alpha4.DriftedAnnotationKey))
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+	It("should mark drifted and replace nodes beyond the allowed skew", func() {
+		node := nodeWithVersion("v1.19.8-eks-96780e")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+		Expect(node.DeletionTimestamp.IsZero()).To(BeFalse())
+	})
+	It("should consider kube-proxy's version", func() {
+		node := nodeWithVersion("v1.21.2")
+		node.Status.NodeInfo.KubeProxyVersion = "v1.19.8"
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.DeletionTimestamp.IsZero()).To(BeFalse())
+	})
+	It("should replace one node at a time", func() {
+		first, second := nodeWithVersion("v1.19.8"), nodeWithVersion("v1.19.8")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, first, second)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		deleting := 0
+		for _, node := range []*v1.Node{first, second} {
+			node = ExpectNodeExists(env.Client, node.Name)
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+			if !node.DeletionTimestamp.IsZero() {
+				deleting++
+			}
+		}
+		Expect(deleting).To(Equal(1))
+	})
+	It("should not replace nodes with protected jobs", func() {
+		node := nodeWithVersion("v1.19.8")
+		node.Status.Conditions = append(node.Status.Conditions, v1.NodeCondition{Type: v1alpha4.NodeJobProtected, Status: v1.ConditionTrue})
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+	It("should wait for approval to replace nodes", func() {
+		provisioner.Spec.DisruptionApproval = &v1alpha4.DisruptionApproval{}
+		node := nodeWithVersion("v1.19.8")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DriftedAnnotationKey, "true"))
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha4.DisruptionPendingAnnotationKey, "Drift"))
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+
+		node.Annotations[v1alpha4.DisruptionApprovedAnnotationKey] = "true"
+		Expect(env.Client.Update(ctx, node)).To(Succeed())
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(ExpectNodeExists(env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeFalse())
+	})
+	It("should ignore skew if the provisioner doesn't set a max skew", func() {
+		provisioner.Spec.MaxKubeletVersionSkew = nil
+		node := nodeWithVersion("v1.16.0")
+		ExpectCreated(env.Client, provisioner)
+		ExpectCreatedWithStatus(env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+
+		node = ExpectNodeExists(env.Client, node.Name)
+		Expect(node.Annotations).ToNot(HaveKey(v1alpha4.DriftedAnnotationKey))
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+	})
+})
@@ -0,0 +1,153 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deprovisioning
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	"github.com/awslabs/karpenter/pkg/utils/ptr"
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// RetryInterval is how often disruptions that the budget doesn't allow
+	// are requested again
+	RetryInterval = 30 * time.Second
+	// reservationTTL is how long an allowed disruption counts towards the
+	// budget before its node is observed terminating, which covers the delay
+	// before the deletion is reflected in the informer cache
+	reservationTTL = 1 * time.Minute
+)
+
+var blockedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "disruption",
+		Name:      "budget_blocked_total",
+		Help:      "Number of voluntary disruptions delayed by provisioners' disruption budgets. Broken down by provisioner and cause.",
+	},
+	[]string{metrics.ProvisionerLabel, "cause"},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(blockedCounter)
+}
+
+// Coordinator enforces provisioners' disruption budgets for all of the
+// controllers that voluntarily terminate nodes. A single coordinator is shared
+// by the controllers in a process, so that disruptions they allow at the same
+// time are counted before the nodes are observed terminating.
+type Coordinator struct {
+	kubeClient client.Client
+	mu         sync.Mutex
+	// reserved are the nodes whose disruptions were allowed recently
+	reserved *cache.Cache
+}
+
+// NewCoordinator constructs a coordinator, reading nodes with the client
+func NewCoordinator(kubeClient client.Client) *Coordinator {
+	return &Coordinator{
+		kubeClient: kubeClient,
+		reserved:   cache.New(reservationTTL, reservationTTL),
+	}
+}
+
+// Allow returns true if the provisioner's disruption budget allows the node
+// to be terminated now, in which case the disruption counts towards the
+// budget. Callers delete the node if allowed, and try again after the
+// RetryInterval otherwise.
+func (c *Coordinator) Allow(ctx context.Context, provisioner *v1alpha4.Provisioner, node *v1.Node) (bool, error) {
+	budget := provisioner.Spec.DisruptionBudget
+	if budget == nil {
+		return true, nil
+	}
+	if !InWindow(budget.Windows, injectabletime.Now()) {
+		logging.FromContext(ctx).Debugf("Delaying disruption of node %s until the provisioner's next disruption window", node.Name)
+		blockedCounter.WithLabelValues(provisioner.Name, "window").Inc()
+		return false, nil
+	}
+	if budget.MaxUnavailable == nil {
+		return true, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.reserved.Get(node.Name); ok {
+		return true, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return false, fmt.Errorf("listing nodes, %w", err)
+	}
+	unavailable := 0
+	for _, n := range ptr.NodeListToSlice(nodes) {
+		if !n.DeletionTimestamp.IsZero() {
+			unavailable++
+			c.reserved.Delete(n.Name)
+		} else if _, ok := c.reserved.Get(n.Name); ok {
+			unavailable++
+		}
+	}
+	maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(budget.MaxUnavailable, len(nodes.Items), true)
+	if err != nil {
+		return false, fmt.Errorf("computing max unavailable, %w", err)
+	}
+	if unavailable >= maxUnavailable {
+		logging.FromContext(ctx).Debugf("Delaying disruption of node %s, %d of %d nodes are unavailable", node.Name, unavailable, maxUnavailable)
+		blockedCounter.WithLabelValues(provisioner.Name, "max_unavailable").Inc()
+		return false, nil
+	}
+	c.reserved.SetDefault(node.Name, struct{}{})
+	return true, nil
+}
+
+// InWindow returns true if the time is within any of the windows, or if there
+// are no windows
+func InWindow(windows []v1alpha4.DisruptionWindow, now time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	now = now.UTC()
+	for _, window := range windows {
+		start, err := time.Parse(v1alpha4.DisruptionWindowTimeFormat, window.Start)
+		if err != nil {
+			continue
+		}
+		// Windows may span midnight, so check the window that started today
+		// and the one that started yesterday
+		for _, day := range []time.Time{now, now.AddDate(0, 0, -1)} {
+			if len(window.Days) > 0 && !functional.ContainsString(window.Days, day.Weekday().String()) {
+				continue
+			}
+			opened := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+			if !now.Before(opened) && now.Before(opened.Add(window.Duration.Duration)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deprovisioning_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/deprovisioning"
+	"github.com/awslabs/karpenter/pkg/test"
+
+	. "github.com/awslabs/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var coordinator *deprovisioning.Coordinator
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Deprovisioning")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Coordinator", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		coordinator = deprovisioning.NewCoordinator(env.Client)
+		provisioner = &v1alpha4.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name},
+			Spec:       v1alpha4.ProvisionerSpec{DisruptionBudget: &v1alpha4.DisruptionBudget{}},
+		}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(env.Client)
+	})
+
+	nodes := func(count int) []*v1.Node {
+		result := []*v1.Node{}
+		for i := 0; i < count; i++ {
+			node := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha4.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			ExpectCreated(env.Client, node)
+			result = append(result, node)
+		}
+		return result
+	}
+	allowed := func(node *v1.Node) bool {
+		allowed, err := coordinator.Allow(ctx, provisioner, node)
+		Expect(err).ToNot(HaveOccurred())
+		return allowed
+	}
+
+	It("should allow disruptions without a budget", func() {
+		provisioner.Spec.DisruptionBudget = nil
+		Expect(allowed(nodes(1)[0])).To(BeTrue())
+	})
+	It("should allow at most max unavailable disruptions", func() {
+		provisioner.Spec.DisruptionBudget.MaxUnavailable = &intstr.IntOrString{Type: intstr.Int, IntVal: 2}
+		n := nodes(3)
+		Expect(allowed(n[0])).To(BeTrue())
+		Expect(allowed(n[1])).To(BeTrue())
+		Expect(allowed(n[2])).To(BeFalse())
+	})
+	It("should allow the same node again", func() {
+		provisioner.Spec.DisruptionBudget.MaxUnavailable = &intstr.IntOrString{Type: intstr.Int, IntVal: 1}
+		n := nodes(2)
+		Expect(allowed(n[0])).To(BeTrue())
+		Expect(allowed(n[0])).To(BeTrue())
+		Expect(allowed(n[1])).To(BeFalse())
+	})
+	It("should count terminating nodes as unavailable", func() {
+		provisioner.Spec.DisruptionBudget.MaxUnavailable = &intstr.IntOrString{Type: intstr.Int, IntVal: 1}
+		n := nodes(2)
+		Expect(env.Client.Delete(ctx, n[0])).To(Succeed())
+		Eventually(func() bool { return !ExpectNodeExists(env.Client, n[0].Name).DeletionTimestamp.IsZero() }).Should(BeTrue())
+		Expect(allowed(n[1])).To(BeFalse())
+	})
+	It("should scale percentages up", func() {
+		provisioner.Spec.DisruptionBudget.MaxUnavailable = &intstr.IntOrString{Type: intstr.String, StrVal: "10%"}
+		n := nodes(3)
+		Expect(allowed(n[0])).To(BeTrue())
+		Expect(allowed(n[1])).To(BeFalse())
+	})
+	It("should not allow disruptions with a max unavailable of 0", func() {
+		provisioner.Spec.DisruptionBudget.MaxUnavailable = &intstr.IntOrString{Type: intstr.Int, IntVal: 0}
+		Expect(allowed(nodes(1)[0])).To(BeFalse())
+	})
+	It("should not allow disruptions outside of the windows", func() {
+		start := time.Now().UTC().Add(time.Hour)
+		provisioner.Spec.DisruptionBudget.Windows = []v1alpha4.DisruptionWindow{{
+			Start:    start.Format(v1alpha4.DisruptionWindowTimeFormat),
+			Duration: metav1.Duration{Duration: time.Hour},
+		}}
+		Expect(allowed(nodes(1)[0])).To(BeFalse())
+	})
+	It("should allow disruptions within the windows", func() {
+		start := time.Now().UTC().Add(-time.Hour)
+		provisioner.Spec.DisruptionBudget.Windows = []v1alpha4.DisruptionWindow{{
+			Start:    start.Format(v1alpha4.DisruptionWindowTimeFormat),
+			Duration: metav1.Duration{Duration: 2 * time.Hour},
+		}}
+		Expect(allowed(nodes(1)[0])).To(BeTrue())
+	})
+})
+
+var _ = Describe("InWindow", func() {
+	saturday := time.Date(2021, time.August, 7, 23, 30, 0, 0, time.UTC)
+	It("should be in window without windows", func() {
+		Expect(deprovisioning.InWindow(nil, saturday)).To(BeTrue())
+	})
+	It("should only be in window on the window's days", func() {
+		windows := []v1alpha4.DisruptionWindow{{Days: []string{"Saturday"}, Start: "23:00", Duration: metav1.Duration{Duration: time.Hour}}}
+		Expect(deprovisioning.InWindow(windows, saturday)).To(BeTrue())
+		Expect(deprovisioning.InWindow(windows, saturday.AddDate(0, 0, 1))).To(BeFalse())
+	})
+	It("should be in window after midnight if the window started the day before", func() {
+		windows := []v1alpha4.DisruptionWindow{{Days: []string{"Saturday"}, Start: "23:00", Duration: metav1.Duration{Duration: 2 * time.Hour}}}
+		Expect(deprovisioning.InWindow(windows, saturday.Add(time.Hour))).To(BeTrue())
+		Expect(deprovisioning.InWindow(windows, saturday.Add(2*time.Hour))).To(BeFalse())
+	})
+	It("should not be in window before the window starts", func() {
+		windows := []v1alpha4.DisruptionWindow{{Start: "23:45", Duration: metav1.Duration{Duration: time.Hour}}}
+		Expect(deprovisioning.InWindow(windows, saturday)).To(BeFalse())
+	})
+})
@@ -0,0 +1,164 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lifecycle
+
+import (
+	"context"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/metrics"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// queueSize is the number of events buffered for publishing, beyond which
+	// events are dropped rather than slowing down controllers
+	queueSize = 1000
+	// publishAttempts is the number of times an event is published to each
+	// sink before giving up
+	publishAttempts = 3
+	// publishRetryInterval is multiplied by the number of failed attempts
+	publishRetryInterval = time.Second
+)
+
+var notificationsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.KarpenterNamespace,
+		Subsystem: "lifecycle",
+		Name:      "notifications_total",
+		Help:      "Number of node lifecycle notifications. Broken down by phase and result.",
+	},
+	[]string{"phase", metrics.ResultLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(notificationsCounter)
+}
+
+// Phase of a node's lifecycle
+type Phase string
+
+const (
+	// Created nodes have been launched by the cloud provider
+	Created Phase = "Created"
+	// Registered nodes have joined the cluster and are ready
+	Registered Phase = "Registered"
+	// Drained nodes have had their pods evicted before they were terminated
+	Drained Phase = "Drained"
+	// Terminated nodes have been deleted by the cloud provider
+	Terminated Phase = "Terminated"
+)
+
+// Event describes a node entering a phase of its lifecycle
+type Event struct {
+	Phase        Phase             `json:"phase"`
+	Time         time.Time         `json:"time"`
+	Node         string            `json:"node"`
+	ProviderID   string            `json:"providerID,omitempty"`
+	Provisioner  string            `json:"provisioner,omitempty"`
+	InstanceType string            `json:"instanceType,omitempty"`
+	Zone         string            `json:"zone,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// NewEvent describes the node entering the phase
+func NewEvent(phase Phase, node *v1.Node) *Event {
+	return &Event{
+		Phase:        phase,
+		Time:         injectabletime.Now().UTC(),
+		Node:         node.Name,
+		ProviderID:   node.Spec.ProviderID,
+		Provisioner:  node.Labels[v1alpha4.ProvisionerNameLabelKey],
+		InstanceType: node.Labels[v1.LabelInstanceTypeStable],
+		Zone:         node.Labels[v1.LabelTopologyZone],
+		// Copied, since the event is published after the node is updated
+		Labels: functional.UnionStringMaps(node.Labels),
+	}
+}
+
+// Sink publishes lifecycle events to an external system, e.g. an inventory
+type Sink interface {
+	Publish(context.Context, *Event) error
+}
+
+// Notifier publishes lifecycle events to sinks in the background, so that
+// controllers aren't slowed down by external systems. Events are delivered at
+// least once, and may be repeated if controllers retry.
+type Notifier struct {
+	sinks  []Sink
+	events chan *Event
+}
+
+// NewNotifier starts publishing events to the sinks until the context is
+// done. Returns nil if there are no sinks, which ignores all events.
+func NewNotifier(ctx context.Context, sinks ...Sink) *Notifier {
+	if len(sinks) == 0 {
+		return nil
+	}
+	notifier := &Notifier{sinks: sinks, events: make(chan *Event, queueSize)}
+	go notifier.run(ctx)
+	return notifier
+}
+
+// Notify queues an event for the node entering the phase
+func (n *Notifier) Notify(ctx context.Context, phase Phase, node *v1.Node) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.events <- NewEvent(phase, node):
+	default:
+		logging.FromContext(ctx).Errorf("Dropped %s lifecycle event for node %s, too many events queued", phase, node.Name)
+		notificationsCounter.WithLabelValues(string(phase), "dropped").Inc()
+	}
+}
+
+func (n *Notifier) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.events:
+			for _, sink := range n.sinks {
+				n.publish(ctx, sink, event)
+			}
+		}
+	}
+}
+
+func (n *Notifier) publish(ctx context.Context, sink Sink, event *Event) {
+	for attempt := 1; ; attempt++ {
+		err := sink.Publish(ctx, event)
+		if err == nil {
+			notificationsCounter.WithLabelValues(string(event.Phase), "success").Inc()
+			return
+		}
+		if attempt == publishAttempts || ctx.Err() != nil {
+			logging.FromContext(ctx).Errorf("Failed to publish %s lifecycle event for node %s, %s", event.Phase, event.Node, err.Error())
+			notificationsCounter.WithLabelValues(string(event.Phase), "error").Inc()
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(publishRetryInterval * time.Duration(attempt)):
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Lifecycle")
+}
+
+// fakeSink records published events, failing the first Failures attempts
+type fakeSink struct {
+	mu        sync.Mutex
+	Failures  int
+	Attempts  int
+	Published []*Event
+}
+
+func (f *fakeSink) Publish(_ context.Context, event *Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Attempts++
+	if f.Attempts <= f.Failures {
+		return fmt.Errorf("test error")
+	}
+	f.Published = append(f.Published, event)
+	return nil
+}
+
+func (f *fakeSink) published() []*Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*Event{}, f.Published...)
+}
+
+var _ = Describe("Lifecycle", func() {
+	var node *v1.Node
+	var cancel context.CancelFunc
+	var notifierCtx context.Context
+
+	BeforeEach(func() {
+		notifierCtx, cancel = context.WithCancel(ctx)
+		node = test.Node(test.NodeOptions{
+			ProviderID: "test:///test-zone-1/i-123",
+			Labels: map[string]string{
+				v1alpha4.ProvisionerNameLabelKey: "default",
+				v1.LabelInstanceTypeStable:       "test-instance-type",
+				v1.LabelTopologyZone:             "test-zone-1",
+			},
+		})
+	})
+	AfterEach(func() {
+		cancel()
+	})
+
+	Context("Notifier", func() {
+		It("should ignore events without sinks", func() {
+			notifier := NewNotifier(notifierCtx)
+			Expect(notifier).To(BeNil())
+			notifier.Notify(notifierCtx, Created, node)
+		})
+		It("should describe the node", func() {
+			event := NewEvent(Registered, node)
+			Expect(event.Phase).To(Equal(Registered))
+			Expect(event.Node).To(Equal(node.Name))
+			Expect(event.ProviderID).To(Equal("test:///test-zone-1/i-123"))
+			Expect(event.Provisioner).To(Equal("default"))
+			Expect(event.InstanceType).To(Equal("test-instance-type"))
+			Expect(event.Zone).To(Equal("test-zone-1"))
+		})
+		It("should not share the node's labels", func() {
+			event := NewEvent(Registered, node)
+			node.Labels["test-key"] = "test-value"
+			Expect(event.Labels).ToNot(HaveKey("test-key"))
+		})
+		It("should publish events to every sink in order", func() {
+			first, second := &fakeSink{}, &fakeSink{}
+			notifier := NewNotifier(notifierCtx, first, second)
+			for _, phase := range []Phase{Created, Registered, Drained, Terminated} {
+				notifier.Notify(notifierCtx, phase, node)
+			}
+			for _, sink := range []*fakeSink{first, second} {
+				Eventually(func() []Phase {
+					phases := []Phase{}
+					for _, event := range sink.published() {
+						phases = append(phases, event.Phase)
+					}
+					return phases
+				}).Should(Equal([]Phase{Created, Registered, Drained, Terminated}))
+			}
+		})
+		It("should retry failed publishes", func() {
+			sink := &fakeSink{Failures: 1}
+			NewNotifier(notifierCtx, sink).Notify(notifierCtx, Created, node)
+			Eventually(sink.published, "5s").Should(HaveLen(1))
+		})
+		It("should give up after repeated failures", func() {
+			failing, succeeding := &fakeSink{Failures: publishAttempts}, &fakeSink{}
+			NewNotifier(notifierCtx, failing, succeeding).Notify(notifierCtx, Created, node)
+			Eventually(succeeding.published, "10s").Should(HaveLen(1))
+			Expect(failing.published()).To(BeEmpty())
+		})
+	})
+
+	Context("HTTPSink", func() {
+		It("should post events as json", func() {
+			received := make(chan *Event, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.Method).To(Equal(http.MethodPost))
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+				event := &Event{}
+				Expect(json.NewDecoder(r.Body).Decode(event)).To(Succeed())
+				received <- event
+			}))
+			defer server.Close()
+			Expect(NewHTTPSink(server.URL).Publish(ctx, NewEvent(Created, node))).To(Succeed())
+			var event *Event
+			Eventually(received).Should(Receive(&event))
+			Expect(event.Phase).To(Equal(Created))
+			Expect(event.Node).To(Equal(node.Name))
+		})
+		It("should fail for unsuccessful responses", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+			Expect(NewHTTPSink(server.URL).Publish(ctx, NewEvent(Created, node))).ToNot(Succeed())
+		})
+	})
+})
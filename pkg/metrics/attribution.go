@@ -0,0 +1,136 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// attributionLabelPrefix avoids collisions between attribution labels and
+// the labels of the metrics they're added to
+const attributionLabelPrefix = "label_"
+
+// AttributionLabels returns metric labels for the values of the attribution
+// keys, e.g. the key "example.com/team" becomes the label "label_example_com_team".
+// Keys without a value are labeled with an empty string. If keys sanitize to
+// the same label, the first key's value is used.
+func AttributionLabels(keys []string, values map[string]string) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for _, key := range keys {
+		name := AttributionLabelName(key)
+		if _, ok := labels[name]; ok {
+			continue
+		}
+		labels[name] = values[key]
+	}
+	return labels
+}
+
+// AttributionLabelName returns the metric label for the attribution key.
+// Characters that aren't allowed in metric labels are replaced with
+// underscores, so distinct keys may share a label.
+func AttributionLabelName(key string) string {
+	return attributionLabelPrefix + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// AttributedGaugeVec is a set of gauges partitioned by labels, to which
+// attribution labels may be added. Attribution labels vary between gauges, so
+// the vector is registered as an unchecked collector. A gauge's attribution
+// labels are replaced each time it's set, so a gauge is never duplicated when
+// its attribution changes.
+type AttributedGaugeVec struct {
+	opts   prometheus.GaugeOpts
+	mu     sync.RWMutex
+	gauges map[string]attributedGauge
+}
+
+type attributedGauge struct {
+	desc        *prometheus.Desc
+	labelValues []string
+	value       float64
+}
+
+func NewAttributedGaugeVec(opts prometheus.GaugeOpts) *AttributedGaugeVec {
+	return &AttributedGaugeVec{opts: opts, gauges: map[string]attributedGauge{}}
+}
+
+// Set sets the value of the gauge identified by the labels, labeled with the attribution labels
+func (v *AttributedGaugeVec) Set(labels prometheus.Labels, attribution prometheus.Labels, value float64) {
+	all := prometheus.Labels{}
+	for name, value := range attribution {
+		all[name] = value
+	}
+	for name, value := range labels {
+		all[name] = value
+	}
+	names := sortedNames(all)
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, all[name])
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.gauges[keyOf(labels)] = attributedGauge{
+		desc:        prometheus.NewDesc(prometheus.BuildFQName(v.opts.Namespace, v.opts.Subsystem, v.opts.Name), v.opts.Help, names, v.opts.ConstLabels),
+		labelValues: values,
+		value:       value,
+	}
+}
+
+// Delete removes the gauge identified by the labels
+func (v *AttributedGaugeVec) Delete(labels prometheus.Labels) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.gauges, keyOf(labels))
+}
+
+// Describe implements prometheus.Collector. No descriptors are sent, since
+// label names vary between gauges.
+func (v *AttributedGaugeVec) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (v *AttributedGaugeVec) Collect(metrics chan<- prometheus.Metric) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, gauge := range v.gauges {
+		metrics <- prometheus.MustNewConstMetric(gauge.desc, prometheus.GaugeValue, gauge.value, gauge.labelValues...)
+	}
+}
+
+func keyOf(labels prometheus.Labels) string {
+	pairs := []string{}
+	for _, name := range sortedNames(labels) {
+		pairs = append(pairs, name+"\xff"+labels[name])
+	}
+	return strings.Join(pairs, "\xff")
+}
+
+func sortedNames(labels prometheus.Labels) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,82 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/awslabs/karpenter/pkg/utils/project"
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: KarpenterNamespace,
+			Name:      "build_info",
+			Help:      "A metric with a constant '1' value labeled by the version, commit, and cloud provider from which Karpenter was built.",
+		},
+		[]string{
+			"version",
+			"commit",
+			"cloudprovider",
+		},
+	)
+	configHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: KarpenterNamespace,
+			Name:      "config_hash",
+			Help:      "A metric with a constant '1' value labeled by the hash of the active global and cloud provider settings. Replicas reporting different hashes are running with different configuration.",
+		},
+		[]string{
+			"hash",
+		},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(buildInfo)
+	crmetrics.Registry.MustRegister(configHash)
+}
+
+// PublishBuildInfo publishes the build information of the running binary.
+func PublishBuildInfo(cloudProvider string) {
+	buildInfo.WithLabelValues(project.Version, project.Commit, cloudProvider).Set(1)
+}
+
+// PublishConfigHash publishes the hash of the active settings, replacing the
+// previously published hash.
+func PublishConfigHash(settings interface{}) error {
+	hash, err := Hash(settings)
+	if err != nil {
+		return err
+	}
+	configHash.Reset()
+	configHash.WithLabelValues(hash).Set(1)
+	return nil
+}
+
+// Hash returns a hex encoded hash of the supplied value for use as a label.
+// Equivalent values always hash the same.
+func Hash(value interface{}) (string, error) {
+	hash, err := hashstructure.Hash(value, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+	if err != nil {
+		return "", fmt.Errorf("hashing %T, %w", value, err)
+	}
+	return strconv.FormatUint(hash, 16), nil
+}
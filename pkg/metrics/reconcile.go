@@ -0,0 +1,131 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/apis"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	ControllerLabel = "controller"
+	ReasonLabel     = "reason"
+
+	ErrorReasonThrottled  = "throttled"
+	ErrorReasonConflict   = "conflict"
+	ErrorReasonValidation = "validation"
+	ErrorReasonNotFound   = "not_found"
+	ErrorReasonUnknown    = "unknown"
+)
+
+var (
+	reconcileErrorsCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: KarpenterNamespace,
+			Subsystem: "controller",
+			Name:      "reconcile_errors_total",
+			Help:      "Total number of reconcile errors. Broken down by controller and error reason.",
+		},
+		[]string{ControllerLabel, ReasonLabel},
+	)
+	reconcileRequeuesCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: KarpenterNamespace,
+			Subsystem: "controller",
+			Name:      "reconcile_requeues_total",
+			Help:      "Total number of reconciles that succeeded and requested a requeue. Broken down by controller.",
+		},
+		[]string{ControllerLabel},
+	)
+	// This is not an exhaustive list, add to it as needed
+	throttledErrorCodes = []string{
+		"Throttling",
+		"ThrottlingException",
+		"RequestLimitExceeded",
+		"RequestThrottled",
+		"RequestThrottledException",
+		"TooManyRequestsException",
+	}
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(reconcileErrorsCounterVec, reconcileRequeuesCounterVec)
+}
+
+// InstrumentedReconciler records errors and requeues of the reconciler it wraps
+type InstrumentedReconciler struct {
+	reconcile.Reconciler
+	name string
+}
+
+// NewInstrumentedReconciler wraps the reconciler of the named controller
+func NewInstrumentedReconciler(name string, reconciler reconcile.Reconciler) *InstrumentedReconciler {
+	return &InstrumentedReconciler{Reconciler: reconciler, name: name}
+}
+
+func (r *InstrumentedReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	result, err := r.Reconciler.Reconcile(ctx, req)
+	if err != nil {
+		reconcileErrorsCounterVec.WithLabelValues(r.name, ErrorReason(err)).Inc()
+	} else if result.Requeue || result.RequeueAfter > 0 {
+		reconcileRequeuesCounterVec.WithLabelValues(r.name).Inc()
+	}
+	return result, err
+}
+
+// ErrorReason classifies the error, even if it's wrapped
+func ErrorReason(err error) string {
+	switch {
+	case isThrottled(err):
+		return ErrorReasonThrottled
+	case apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err):
+		return ErrorReasonConflict
+	case isValidation(err):
+		return ErrorReasonValidation
+	case apierrors.IsNotFound(err):
+		return ErrorReasonNotFound
+	default:
+		return ErrorReasonUnknown
+	}
+}
+
+// isThrottled returns true if the err was caused by rate limiting by the
+// kube-apiserver or a cloud provider (e.g. AWS errors with a throttling code)
+func isThrottled(err error) bool {
+	if apierrors.IsTooManyRequests(err) {
+		return true
+	}
+	var codeError interface{ Code() string }
+	if errors.As(err, &codeError) {
+		for _, code := range throttledErrorCodes {
+			if strings.EqualFold(codeError.Code(), code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isValidation(err error) bool {
+	var fieldError *apis.FieldError
+	return apierrors.IsInvalid(err) || apierrors.IsBadRequest(err) || errors.As(err, &fieldError)
+}
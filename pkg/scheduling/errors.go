@@ -0,0 +1,79 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ConflictError is returned when the values a provisioner allows for a label
+// don't intersect with the values required by a pod's scheduling rules.
+type ConflictError struct {
+	Key string
+	// Allowed values of the provisioner, where nil allows any value
+	Allowed []string
+	// Requirements of the pod on the key
+	Requirements []v1.NodeSelectorRequirement
+}
+
+// ConflictFor constructs a ConflictError for the label from the requirements
+// of the node affinity on the label.
+func (n NodeAffinity) ConflictFor(label string, allowed []string) *ConflictError {
+	conflict := &ConflictError{Key: label, Allowed: allowed}
+	for _, requirement := range n {
+		if requirement.Key == label {
+			conflict.Requirements = append(conflict.Requirements, requirement)
+		}
+	}
+	return conflict
+}
+
+func (e *ConflictError) Error() string {
+	allowed := "any value"
+	if e.Allowed != nil {
+		allowed = formatValues(e.Allowed)
+	}
+	required := []string{}
+	for _, requirement := range e.Requirements {
+		switch requirement.Operator {
+		case v1.NodeSelectorOpIn:
+			required = append(required, formatValues(requirement.Values))
+		case v1.NodeSelectorOpNotIn:
+			required = append(required, fmt.Sprintf("not %s", formatValues(requirement.Values)))
+		case v1.NodeSelectorOpExists:
+			required = append(required, "exists")
+		case v1.NodeSelectorOpDoesNotExist:
+			required = append(required, "does not exist")
+		case v1.NodeSelectorOpGt:
+			required = append(required, fmt.Sprintf("greater than %s", strings.Join(requirement.Values, ",")))
+		case v1.NodeSelectorOpLt:
+			required = append(required, fmt.Sprintf("less than %s", strings.Join(requirement.Values, ",")))
+		}
+	}
+	if len(required) == 0 {
+		return fmt.Sprintf("%s: provisioner allows %s", e.Key, allowed)
+	}
+	return fmt.Sprintf("%s: provisioner allows %s, pod requires %s", e.Key, allowed, strings.Join(required, " and "))
+}
+
+func formatValues(values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return fmt.Sprintf("[%s]", strings.Join(sorted, ","))
+}
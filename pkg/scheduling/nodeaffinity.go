@@ -16,6 +16,7 @@ package scheduling
 
 import (
 	"sort"
+	"strconv"
 
 	"github.com/awslabs/karpenter/pkg/utils/functional"
 	v1 "k8s.io/api/core/v1"
@@ -33,12 +34,18 @@ func NodeAffinityFor(pods ...*v1.Pod) (nodeAffinity NodeAffinity) {
 		if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
 			continue
 		}
-		// Select heaviest preference and treat as a requirement. An outer loop will iteratively unconstrain them if unsatisfiable.
+		// Select heaviest unscored preference and treat as a requirement. An outer loop will iteratively unconstrain them if unsatisfiable.
+		// Scored preferences are applied separately by PreferredLabelValues.
 		if preferred := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution; len(preferred) > 0 {
-			sort.Slice(preferred, func(i int, j int) bool { return preferred[i].Weight > preferred[j].Weight })
-			nodeAffinity = append(nodeAffinity, preferred[0].Preference.MatchExpressions...)
+			sort.SliceStable(preferred, func(i int, j int) bool { return preferred[i].Weight > preferred[j].Weight })
+			for _, term := range preferred {
+				if !IsScoredPreference(term) {
+					nodeAffinity = append(nodeAffinity, term.Preference.MatchExpressions...)
+					break
+				}
+			}
 		}
-		// Select first requirement. An outer loop will iteratively remove OR requirements if unsatisfiable
+		// Select first requirement. Use NodeSelectorTermBranches to consider the remaining OR requirements
 		if pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil &&
 			len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) > 0 {
 			nodeAffinity = append(nodeAffinity, pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions...)
@@ -47,6 +54,35 @@ func NodeAffinityFor(pods ...*v1.Pod) (nodeAffinity NodeAffinity) {
 	return nodeAffinity
 }
 
+// NodeSelectorTermBranches returns a copy of the pod for each of its required
+// node selector terms, keeping only that term. Terms are ORed, so the pod may
+// schedule if any of its branches can. Pods with fewer than two terms are
+// returned as is.
+func NodeSelectorTermBranches(pod *v1.Pod) []*v1.Pod {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil ||
+		len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) < 2 {
+		return []*v1.Pod{pod}
+	}
+	branches := []*v1.Pod{}
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		branch := pod.DeepCopy()
+		branch.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{term}
+		branches = append(branches, branch)
+	}
+	return branches
+}
+
+// LabelValuesFor returns the values of the label that satisfy any of the pod's
+// required node selector terms.
+func LabelValuesFor(pod *v1.Pod, label string, constraints ...[]string) []string {
+	values := []string{}
+	for _, branch := range NodeSelectorTermBranches(pod) {
+		values = append(values, NodeAffinityFor(branch).GetLabelValues(label, constraints...)...)
+	}
+	return functional.UniqueStrings(values)
+}
+
 // GetLabels returns the label keys specified by the scheduling rules
 func (n NodeAffinity) GetLabels() []string {
 	keys := map[string]bool{}
@@ -60,7 +96,9 @@ func (n NodeAffinity) GetLabels() []string {
 	return result
 }
 
-// GetLabelValues for the provided key. Default values are used to substract options for NotIn.
+// GetLabelValues for the provided key. Default values are used to substract
+// options for NotIn and to compare against for Gt and Lt. Exists doesn't
+// constrain the values, while DoesNotExist isn't satisfied by any value.
 func (n NodeAffinity) GetLabelValues(label string, constraints ...[]string) []string {
 	// Intersect external constraints
 	result := functional.IntersectStringSlice(constraints...)
@@ -70,10 +108,71 @@ func (n NodeAffinity) GetLabelValues(label string, constraints ...[]string) []st
 			result = functional.IntersectStringSlice(result, requirement.Values)
 		}
 	}
-	// OpNotIn
 	for _, requirement := range n {
-		if requirement.Key == label && requirement.Operator == v1.NodeSelectorOpNotIn {
+		if requirement.Key != label {
+			continue
+		}
+		switch requirement.Operator {
+		case v1.NodeSelectorOpNotIn:
 			result = functional.StringSliceWithout(result, requirement.Values...)
+		case v1.NodeSelectorOpDoesNotExist:
+			result = []string{}
+		case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+			result = compareNumeric(result, requirement)
+		}
+	}
+	return result
+}
+
+// AllowsAbsent returns true if a node without the label satisfies the
+// requirements on it. As in kube-scheduler, NotIn and DoesNotExist are
+// satisfied by a missing label, while In, Exists, Gt and Lt are not.
+func (n NodeAffinity) AllowsAbsent(label string) bool {
+	for _, requirement := range n {
+		if requirement.Key != label {
+			continue
+		}
+		switch requirement.Operator {
+		case v1.NodeSelectorOpNotIn, v1.NodeSelectorOpDoesNotExist:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsLabel returns true if a node with the labels satisfies the
+// requirements on the label
+func (n NodeAffinity) AllowsLabel(labels map[string]string, label string) bool {
+	value, ok := labels[label]
+	if !ok {
+		return n.AllowsAbsent(label)
+	}
+	return len(n.GetLabelValues(label, []string{value})) > 0
+}
+
+// compareNumeric returns the values that are integers greater than (Gt) or
+// less than (Lt) the requirement's value. Nil values are unconstrained and
+// can't be compared, so they're returned as is.
+func compareNumeric(values []string, requirement v1.NodeSelectorRequirement) []string {
+	if values == nil {
+		return nil
+	}
+	result := []string{}
+	if len(requirement.Values) != 1 {
+		return result
+	}
+	bound, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+	if err != nil {
+		return result
+	}
+	for _, value := range values {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if (requirement.Operator == v1.NodeSelectorOpGt && parsed > bound) || (requirement.Operator == v1.NodeSelectorOpLt && parsed < bound) {
+			result = append(result, value)
 		}
 	}
 	return result
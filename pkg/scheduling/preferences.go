@@ -0,0 +1,89 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// ScoredLabels are labels whose preferred node affinity terms are scored
+// against the feasible values rather than treated as requirements. Every
+// feasible value remains a valid choice, so an unsatisfiable preference never
+// prevents a pod from scheduling.
+var ScoredLabels = []string{v1.LabelTopologyZone, v1.LabelArchStable}
+
+// PreferredLabelValues returns the values of the label most preferred by the
+// pods. Each value is scored by the sum of the weights of the scored preferred
+// terms it satisfies. If no value satisfies any preference, all values are
+// returned. Nil values are unconstrained and returned as is.
+func PreferredLabelValues(label string, values []string, pods ...*v1.Pod) []string {
+	if values == nil {
+		return nil
+	}
+	scores := map[string]int32{}
+	best := int32(0)
+	for _, term := range scoredTerms(pods...) {
+		for _, value := range NodeAffinity(term.Preference.MatchExpressions).GetLabelValues(label, values) {
+			scores[value] += term.Weight
+			if scores[value] > best {
+				best = scores[value]
+			}
+		}
+	}
+	if best == 0 {
+		return values
+	}
+	result := []string{}
+	for _, value := range values {
+		if scores[value] == best {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// scoredTerms returns the pods' preferred terms that only constrain scored labels
+func scoredTerms(pods ...*v1.Pod) (terms []v1.PreferredSchedulingTerm) {
+	for _, pod := range pods {
+		if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+			continue
+		}
+		for _, term := range pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			if IsScoredPreference(term) {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// IsScoredPreference returns true if every expression in the term constrains a scored label
+func IsScoredPreference(term v1.PreferredSchedulingTerm) bool {
+	if len(term.Preference.MatchExpressions) == 0 {
+		return false
+	}
+	for _, requirement := range term.Preference.MatchExpressions {
+		scored := false
+		for _, label := range ScoredLabels {
+			if requirement.Key == label {
+				scored = true
+			}
+		}
+		if !scored {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// Simulation simulates scheduling pods to a set of nodes, reserving the
+// resources of each pod it schedules. It considers resource requests, taints,
+// node selectors and required node affinity, but not pod affinity or topology
+// spread constraints.
+type Simulation struct {
+	nodes []*simulatedNode
+}
+
+type simulatedNode struct {
+	node      *v1.Node
+	available v1.ResourceList
+}
+
+// NewSimulation constructs a simulation of the nodes with the given pods
+// already scheduled to them. Pods are matched to nodes by spec.nodeName, and
+// nodes that are unschedulable or terminating are excluded.
+func NewSimulation(nodes []*v1.Node, pods []*v1.Pod) *Simulation {
+	scheduled := map[string][]*v1.Pod{}
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		scheduled[pod.Spec.NodeName] = append(scheduled[pod.Spec.NodeName], pod)
+	}
+	simulation := &Simulation{}
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !node.DeletionTimestamp.IsZero() {
+			continue
+		}
+		available := v1.ResourceList{}
+		for resourceName, quantity := range node.Status.Allocatable {
+			available[resourceName] = quantity.DeepCopy()
+		}
+		subtract(available, requestsFor(scheduled[node.Name]...))
+		simulation.nodes = append(simulation.nodes, &simulatedNode{node: node, available: available})
+	}
+	return simulation
+}
+
+// Schedule simulates scheduling the pods, reserving their resources on the
+// nodes they are scheduled to. If any of the pods can't be scheduled, an
+// error is returned and no resources are reserved.
+func (s *Simulation) Schedule(pods ...*v1.Pod) error {
+	reserved := make([]v1.ResourceList, len(s.nodes))
+	for i, node := range s.nodes {
+		reserved[i] = v1.ResourceList{}
+		for resourceName, quantity := range node.available {
+			reserved[i][resourceName] = quantity.DeepCopy()
+		}
+	}
+	for _, pod := range pods {
+		scheduled := false
+		for i, node := range s.nodes {
+			if fits(pod, node.node, reserved[i]) {
+				subtract(reserved[i], requestsFor(pod))
+				scheduled = true
+				break
+			}
+		}
+		if !scheduled {
+			return fmt.Errorf("pod %s/%s does not fit on any node", pod.Namespace, pod.Name)
+		}
+	}
+	for i, node := range s.nodes {
+		node.available = reserved[i]
+	}
+	return nil
+}
+
+// fits returns true if the pod can be scheduled to the node with the available resources
+func fits(pod *v1.Pod, node *v1.Node, available v1.ResourceList) bool {
+	for resourceName, quantity := range requestsFor(pod) {
+		if quantity.IsZero() {
+			continue
+		}
+		if remaining, ok := available[resourceName]; !ok || remaining.Cmp(quantity) < 0 {
+			return false
+		}
+	}
+	taints := Taints{}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == v1.TaintEffectNoSchedule || taint.Effect == v1.TaintEffectNoExecute {
+			taints = append(taints, taint)
+		}
+	}
+	if taints.Tolerates(pod) != nil {
+		return false
+	}
+	return NodeSelectorMatches(pod, node)
+}
+
+// NodeSelectorMatches returns true if the node's labels satisfy the pod's
+// node selector and required node affinity
+func NodeSelectorMatches(pod *v1.Pod, node *v1.Node) bool {
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	// Terms are ORed, and their expressions are ANDed
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if len(term.MatchExpressions) == 0 {
+			continue
+		}
+		if selector, err := selectorFor(term.MatchExpressions); err == nil && selector.Matches(labels.Set(node.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+var selectionOperators = map[v1.NodeSelectorOperator]selection.Operator{
+	v1.NodeSelectorOpIn:           selection.In,
+	v1.NodeSelectorOpNotIn:        selection.NotIn,
+	v1.NodeSelectorOpExists:       selection.Exists,
+	v1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	v1.NodeSelectorOpGt:           selection.GreaterThan,
+	v1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+func selectorFor(requirements []v1.NodeSelectorRequirement) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, requirement := range requirements {
+		operator, ok := selectionOperators[requirement.Operator]
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator %s", requirement.Operator)
+		}
+		r, err := labels.NewRequirement(requirement.Key, operator, requirement.Values)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	return selector, nil
+}
+
+// requestsFor returns the resources requested by the pods, including a pod
+// slot for each pod
+func requestsFor(pods ...*v1.Pod) v1.ResourceList {
+	requests := resources.RequestsForPods(pods...)
+	requests[v1.ResourcePods] = *resource.NewQuantity(int64(len(pods)), resource.DecimalSI)
+	return requests
+}
+
+func subtract(available v1.ResourceList, requests v1.ResourceList) {
+	for resourceName, quantity := range requests {
+		remaining := available[resourceName]
+		remaining.Sub(quantity)
+		available[resourceName] = remaining
+	}
+}
@@ -68,7 +68,10 @@ func NewEnvironment(ctx context.Context, options ...EnvironmentOption) *Environm
 	ctx, stop := context.WithCancel(ctx)
 	return &Environment{
 		Environment: envtest.Environment{
-			CRDDirectoryPaths: []string{project.RelativeToRoot("charts/karpenter/templates/karpenter.sh_provisioners.yaml")},
+			CRDDirectoryPaths: []string{
+				project.RelativeToRoot("charts/karpenter/templates/karpenter.sh_provisioners.yaml"),
+				project.RelativeToRoot("charts/karpenter/templates/karpenter.sh_provisioningdecisions.yaml"),
+			},
 		},
 		Ctx:     ctx,
 		stop:    stop,
@@ -21,8 +21,10 @@ import (
 
 	//nolint:revive,stylecheck
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/api/policy/v1beta1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/ptr"
@@ -95,6 +97,11 @@ func ExpectCleanedUp(c client.Client) {
 	for i := range pdbs.Items {
 		ExpectDeleted(c, &pdbs.Items[i])
 	}
+	deployments := appsv1.DeploymentList{}
+	Expect(c.List(ctx, &deployments)).To(Succeed())
+	for i := range deployments.Items {
+		ExpectDeleted(c, &deployments.Items[i])
+	}
 	pods := v1.PodList{}
 	Expect(c.List(ctx, &pods)).To(Succeed())
 	for i := range pods.Items {
@@ -110,6 +117,26 @@ func ExpectCleanedUp(c client.Client) {
 	for i := range provisioners.Items {
 		ExpectDeleted(c, &provisioners.Items[i])
 	}
+	decisions := v1alpha4.ProvisioningDecisionList{}
+	Expect(c.List(ctx, &decisions)).To(Succeed())
+	for i := range decisions.Items {
+		ExpectDeleted(c, &decisions.Items[i])
+	}
+	persistentVolumeClaims := v1.PersistentVolumeClaimList{}
+	Expect(c.List(ctx, &persistentVolumeClaims)).To(Succeed())
+	for i := range persistentVolumeClaims.Items {
+		ExpectDeleted(c, &persistentVolumeClaims.Items[i])
+	}
+	persistentVolumes := v1.PersistentVolumeList{}
+	Expect(c.List(ctx, &persistentVolumes)).To(Succeed())
+	for i := range persistentVolumes.Items {
+		ExpectDeleted(c, &persistentVolumes.Items[i])
+	}
+	storageClasses := storagev1.StorageClassList{}
+	Expect(c.List(ctx, &storageClasses)).To(Succeed())
+	for i := range storageClasses.Items {
+		ExpectDeleted(c, &storageClasses.Items[i])
+	}
 }
 
 func ExpectProvisioningSucceeded(ctx context.Context, c client.Client, reconciler reconcile.Reconciler, provisioner *v1alpha4.Provisioner, pods ...*v1.Pod) []*v1.Pod {
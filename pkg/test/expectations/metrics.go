@@ -0,0 +1,85 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package expecations
+
+import (
+	"fmt"
+
+	//nolint:revive,stylecheck
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// FindMetricWithLabelValues returns the metric of the family with the given
+// name whose labels include all of the given label values, or nil if there is
+// no such metric.
+func FindMetricWithLabelValues(name string, labelValues map[string]string) (*dto.Metric, bool) {
+	families, err := crmetrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if labelsMatch(metric, labelValues) {
+				return metric, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func ExpectMetricGaugeValue(name string, expected float64, labelValues map[string]string) {
+	metric := expectMetric(name, labelValues)
+	Expect(metric.GetGauge()).ToNot(BeNil(), fmt.Sprintf("expected %s to be a gauge", name))
+	Expect(metric.GetGauge().GetValue()).To(Equal(expected), fmt.Sprintf("gauge %s with labels %v", name, labelValues))
+}
+
+func ExpectMetricCounterValue(name string, expected float64, labelValues map[string]string) {
+	metric := expectMetric(name, labelValues)
+	Expect(metric.GetCounter()).ToNot(BeNil(), fmt.Sprintf("expected %s to be a counter", name))
+	Expect(metric.GetCounter().GetValue()).To(Equal(expected), fmt.Sprintf("counter %s with labels %v", name, labelValues))
+}
+
+func ExpectMetricHistogramSampleCountValue(name string, expected uint64, labelValues map[string]string) {
+	metric := expectMetric(name, labelValues)
+	Expect(metric.GetHistogram()).ToNot(BeNil(), fmt.Sprintf("expected %s to be a histogram", name))
+	Expect(metric.GetHistogram().GetSampleCount()).To(Equal(expected), fmt.Sprintf("histogram %s with labels %v", name, labelValues))
+}
+
+func ExpectMetricAbsent(name string, labelValues map[string]string) {
+	_, ok := FindMetricWithLabelValues(name, labelValues)
+	Expect(ok).To(BeFalse(), fmt.Sprintf("expected no metric %s with labels %v", name, labelValues))
+}
+
+func expectMetric(name string, labelValues map[string]string) *dto.Metric {
+	metric, ok := FindMetricWithLabelValues(name, labelValues)
+	Expect(ok).To(BeTrue(), fmt.Sprintf("expected metric %s with labels %v", name, labelValues))
+	return metric
+}
+
+func labelsMatch(metric *dto.Metric, labelValues map[string]string) bool {
+	matched := 0
+	for _, label := range metric.GetLabel() {
+		if value, ok := labelValues[label.GetName()]; ok {
+			if value != label.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+	return matched == len(labelValues)
+}
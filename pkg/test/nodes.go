@@ -35,6 +35,7 @@ type NodeOptions struct {
 	Taints        []v1.Taint
 	Allocatable   v1.ResourceList
 	Finalizers    []string
+	ProviderID    string
 }
 
 func Node(overrides ...NodeOptions) *v1.Node {
@@ -69,10 +70,11 @@ func Node(overrides ...NodeOptions) *v1.Node {
 		Spec: v1.NodeSpec{
 			Unschedulable: options.Unschedulable,
 			Taints:        options.Taints,
+			ProviderID:    options.ProviderID,
 		},
 		Status: v1.NodeStatus{
 			Allocatable: options.Allocatable,
-			Conditions:  []v1.NodeCondition{{Type: v1.NodeReady, Status: options.ReadyStatus, Reason: options.ReadyReason}},
+			Conditions:  append([]v1.NodeCondition{{Type: v1.NodeReady, Status: options.ReadyStatus, Reason: options.ReadyReason}}, options.Conditions...),
 		},
 	}
 }
@@ -37,12 +37,18 @@ type PodOptions struct {
 	NodeSelector              map[string]string
 	NodeRequirements          []v1.NodeSelectorRequirement
 	NodePreferences           []v1.NodeSelectorRequirement
+	PodRequirements           []v1.PodAffinityTerm
+	PodAntiRequirements       []v1.PodAffinityTerm
+	PodPreferences            []v1.WeightedPodAffinityTerm
+	PodAntiPreferences        []v1.WeightedPodAffinityTerm
 	TopologySpreadConstraints []v1.TopologySpreadConstraint
 	Tolerations               []v1.Toleration
 	Conditions                []v1.PodCondition
 	Annotations               map[string]string
 	Labels                    map[string]string
 	Finalizers                []string
+	// PersistentVolumeClaims are mounted as volumes, by name
+	PersistentVolumeClaims []string
 }
 
 type PDBOptions struct {
@@ -82,7 +88,7 @@ func Pod(overrides ...PodOptions) *v1.Pod {
 		},
 		Spec: v1.PodSpec{
 			NodeSelector:              options.NodeSelector,
-			Affinity:                  buildAffinity(options.NodeRequirements, options.NodePreferences),
+			Affinity:                  buildAffinity(options),
 			TopologySpreadConstraints: options.TopologySpreadConstraints,
 			Tolerations:               options.Tolerations,
 			Containers: []v1.Container{{
@@ -91,6 +97,7 @@ func Pod(overrides ...PodOptions) *v1.Pod {
 				Resources: options.ResourceRequirements,
 			}},
 			NodeName: options.NodeName,
+			Volumes:  buildVolumes(options),
 		},
 		Status: v1.PodStatus{Conditions: options.Conditions},
 	}
@@ -131,21 +138,48 @@ func PodDisruptionBudget(overrides ...PDBOptions) *v1beta1.PodDisruptionBudget {
 	}
 }
 
-func buildAffinity(nodeRequirements []v1.NodeSelectorRequirement, nodePreferences []v1.NodeSelectorRequirement) *v1.Affinity {
+func buildVolumes(options PodOptions) []v1.Volume {
+	var volumes []v1.Volume
+	for _, claimName := range options.PersistentVolumeClaims {
+		volumes = append(volumes, v1.Volume{
+			Name:         claimName,
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+		})
+	}
+	return volumes
+}
+
+func buildAffinity(options PodOptions) *v1.Affinity {
 	var affinity *v1.Affinity
-	if nodeRequirements == nil && nodePreferences == nil {
+	if options.NodeRequirements == nil && options.NodePreferences == nil &&
+		options.PodRequirements == nil && options.PodPreferences == nil &&
+		options.PodAntiRequirements == nil && options.PodAntiPreferences == nil {
 		return affinity
 	}
-	affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{}}
-
-	if nodeRequirements != nil {
+	affinity = &v1.Affinity{}
+	if options.NodeRequirements != nil || options.NodePreferences != nil {
+		affinity.NodeAffinity = &v1.NodeAffinity{}
+	}
+	if options.NodeRequirements != nil {
 		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{
-			NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: nodeRequirements}},
+			NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: options.NodeRequirements}},
 		}
 	}
-	if nodePreferences != nil {
+	if options.NodePreferences != nil {
 		affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []v1.PreferredSchedulingTerm{
-			{Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: nodePreferences}},
+			{Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: options.NodePreferences}},
+		}
+	}
+	if options.PodRequirements != nil || options.PodPreferences != nil {
+		affinity.PodAffinity = &v1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution:  options.PodRequirements,
+			PreferredDuringSchedulingIgnoredDuringExecution: options.PodPreferences,
+		}
+	}
+	if options.PodAntiRequirements != nil || options.PodAntiPreferences != nil {
+		affinity.PodAntiAffinity = &v1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution:  options.PodAntiRequirements,
+			PreferredDuringSchedulingIgnoredDuringExecution: options.PodAntiPreferences,
 		}
 	}
 	return affinity
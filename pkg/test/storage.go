@@ -0,0 +1,127 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/imdario/mergo"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PersistentVolumeOptions customizes a PersistentVolume.
+type PersistentVolumeOptions struct {
+	Name  string
+	Zones []string
+}
+
+// PersistentVolumeClaimOptions customizes a PersistentVolumeClaim.
+type PersistentVolumeClaimOptions struct {
+	Name             string
+	Namespace        string
+	StorageClassName *string
+	VolumeName       string
+}
+
+// StorageClassOptions customizes a StorageClass.
+type StorageClassOptions struct {
+	Name  string
+	Zones []string
+}
+
+// PersistentVolume creates a test persistent volume with defaults that can be
+// overriden by PersistentVolumeOptions.
+func PersistentVolume(overrides ...PersistentVolumeOptions) *v1.PersistentVolume {
+	options := PersistentVolumeOptions{}
+	for _, opts := range overrides {
+		if err := mergo.Merge(&options, opts, mergo.WithOverride); err != nil {
+			panic(fmt.Sprintf("Failed to merge persistent volume options: %s", err.Error()))
+		}
+	}
+	if options.Name == "" {
+		options.Name = strings.ToLower(randomdata.SillyName())
+	}
+	var nodeAffinity *v1.VolumeNodeAffinity
+	if len(options.Zones) > 0 {
+		nodeAffinity = &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+			MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: options.Zones}},
+		}}}}
+	}
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:               v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+			AccessModes:            []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: "test-driver", VolumeHandle: options.Name}},
+			NodeAffinity:           nodeAffinity,
+		},
+	}
+}
+
+// PersistentVolumeClaim creates a test persistent volume claim with defaults
+// that can be overriden by PersistentVolumeClaimOptions.
+func PersistentVolumeClaim(overrides ...PersistentVolumeClaimOptions) *v1.PersistentVolumeClaim {
+	options := PersistentVolumeClaimOptions{}
+	for _, opts := range overrides {
+		if err := mergo.Merge(&options, opts, mergo.WithOverride); err != nil {
+			panic(fmt.Sprintf("Failed to merge persistent volume claim options: %s", err.Error()))
+		}
+	}
+	if options.Name == "" {
+		options.Name = strings.ToLower(randomdata.SillyName())
+	}
+	if options.Namespace == "" {
+		options.Namespace = "default"
+	}
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: options.Namespace},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources:        v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")}},
+			StorageClassName: options.StorageClassName,
+			VolumeName:       options.VolumeName,
+		},
+	}
+}
+
+// StorageClass creates a test storage class with defaults that can be
+// overriden by StorageClassOptions.
+func StorageClass(overrides ...StorageClassOptions) *storagev1.StorageClass {
+	options := StorageClassOptions{}
+	for _, opts := range overrides {
+		if err := mergo.Merge(&options, opts, mergo.WithOverride); err != nil {
+			panic(fmt.Sprintf("Failed to merge storage class options: %s", err.Error()))
+		}
+	}
+	if options.Name == "" {
+		options.Name = strings.ToLower(randomdata.SillyName())
+	}
+	var allowedTopologies []v1.TopologySelectorTerm
+	if len(options.Zones) > 0 {
+		allowedTopologies = []v1.TopologySelectorTerm{{MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{{Key: v1.LabelTopologyZone, Values: options.Zones}}}}
+	}
+	volumeBindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	return &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: options.Name},
+		Provisioner:       "test-provisioner",
+		VolumeBindingMode: &volumeBindingMode,
+		AllowedTopologies: allowedTopologies,
+	}
+}
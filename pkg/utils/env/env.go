@@ -32,3 +32,27 @@ func WithDefaultInt(key string, def int) int {
 	}
 	return i
 }
+
+// WithDefaultString returns the string value of the supplied environ variable or, if not present,
+// the supplied default value.
+func WithDefaultString(key string, def string) string {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	return val
+}
+
+// WithDefaultBool returns the boolean value of the supplied environ variable or, if not present,
+// the supplied default value. If the conversion fails, returns the default
+func WithDefaultBool(key string, def bool) bool {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return def
+	}
+	return b
+}
@@ -0,0 +1,58 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/awslabs/karpenter/pkg/utils/pod"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+)
+
+// IsDaemon returns true if the pod runs on the node regardless of the
+// workloads scheduled to it, i.e. it's owned by a daemonset or is a static pod
+func IsDaemon(p *v1.Pod) bool {
+	return pod.IsOwnedByDaemonSet(p) || pod.IsOwnedByNode(p)
+}
+
+// Allocatable returns the resources of the node that are available to pods
+// other than daemons, which is its allocatable resources less the requests of
+// the daemons among its pods. Pods that have failed or succeeded don't consume
+// resources.
+func Allocatable(node *v1.Node, pods []*v1.Pod) v1.ResourceList {
+	daemons := []*v1.Pod{}
+	for _, p := range pods {
+		if pod.HasFailed(p) || p.Status.Phase == v1.PodSucceeded {
+			continue
+		}
+		if IsDaemon(p) {
+			daemons = append(daemons, p)
+		}
+	}
+	requests := resources.RequestsForPods(daemons...)
+	// Each daemon also occupies one of the node's pods
+	requests[v1.ResourcePods] = *resource.NewQuantity(int64(len(daemons)), resource.DecimalSI)
+	allocatable := v1.ResourceList{}
+	for resourceName, quantity := range node.Status.Allocatable {
+		quantity = quantity.DeepCopy()
+		quantity.Sub(requests[resourceName])
+		if quantity.Sign() < 0 {
+			quantity.Set(0)
+		}
+		allocatable[resourceName] = quantity
+	}
+	return allocatable
+}
@@ -0,0 +1,40 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
+)
+
+// ExpirationTime returns the time at which the node expires, or false if the
+// provisioner's nodes don't expire
+func ExpirationTime(provisioner *v1alpha4.Provisioner, node *v1.Node) (time.Time, bool) {
+	if provisioner.Spec.TTLSecondsUntilExpired == nil {
+		return time.Time{}, false
+	}
+	return node.CreationTimestamp.Add(time.Duration(*provisioner.Spec.TTLSecondsUntilExpired) * time.Second), true
+}
+
+// IsExpired returns true if the node is older than the provisioner's
+// ttlSecondsUntilExpired
+func IsExpired(provisioner *v1alpha4.Provisioner, node *v1.Node) bool {
+	expirationTime, ok := ExpirationTime(provisioner, node)
+	return ok && injectabletime.Now().After(expirationTime)
+}
@@ -16,12 +16,43 @@ package node
 
 import (
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/utils/injectabletime"
 )
 
 func IsReady(node *v1.Node) bool {
 	return GetCondition(node.Status.Conditions, v1.NodeReady).Status == v1.ConditionTrue
 }
 
+// IsInitialized returns true if the node is ready, its extended resources are
+// registered and the not-ready taint is removed
+func IsInitialized(node *v1.Node) bool {
+	return GetCondition(node.Status.Conditions, v1alpha4.NodeInitialized).Status == v1.ConditionTrue
+}
+
+// OwnedBy returns true if the node was launched by the provisioner
+func OwnedBy(node *v1.Node, provisioner *v1alpha4.Provisioner) bool {
+	return node.Labels[v1alpha4.ProvisionerNameLabelKey] == provisioner.Name
+}
+
+// IsDeleting returns true if the node is being terminated
+func IsDeleting(node *v1.Node) bool {
+	return !node.DeletionTimestamp.IsZero()
+}
+
+// IsDisruptable returns false if the node mustn't be voluntarily disrupted,
+// because it runs long running jobs, or single replica pods and the
+// provisioner excludes them
+func IsDisruptable(provisioner *v1alpha4.Provisioner, node *v1.Node) bool {
+	if provisioner.Spec.SingleReplicaPolicy == v1alpha4.SingleReplicaPolicyExclude &&
+		GetCondition(node.Status.Conditions, v1alpha4.NodeDisruptionBlocked).Status == v1.ConditionTrue {
+		return false
+	}
+	return GetCondition(node.Status.Conditions, v1alpha4.NodeJobProtected).Status != v1.ConditionTrue
+}
+
 func GetCondition(conditions []v1.NodeCondition, match v1.NodeConditionType) v1.NodeCondition {
 	for _, condition := range conditions {
 		if condition.Type == match {
@@ -30,3 +61,24 @@ func GetCondition(conditions []v1.NodeCondition, match v1.NodeConditionType) v1.
 	}
 	return v1.NodeCondition{}
 }
+
+// SetCondition sets the condition on the node, preserving the transition time
+// if the status hasn't changed.
+func SetCondition(node *v1.Node, conditionType v1.NodeConditionType, status v1.ConditionStatus, reason string, message string) {
+	condition := v1.NodeCondition{Type: conditionType, Status: status, Reason: reason, Message: message}
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type != conditionType {
+			continue
+		}
+		condition.LastTransitionTime = node.Status.Conditions[i].LastTransitionTime
+		if node.Status.Conditions[i].Status != status {
+			condition.LastTransitionTime = metav1.NewTime(injectabletime.Now())
+		}
+		condition.LastHeartbeatTime = condition.LastTransitionTime
+		node.Status.Conditions[i] = condition
+		return
+	}
+	condition.LastTransitionTime = metav1.NewTime(injectabletime.Now())
+	condition.LastHeartbeatTime = condition.LastTransitionTime
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+}
@@ -0,0 +1,116 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package permissions
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an optional permission. Karpenter runs without optional
+// permissions, disabling the features that require them.
+type Permission struct {
+	Group    string
+	Resource string
+	Verb     string
+	// Feature describes what's disabled without the permission
+	Feature string
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource = fmt.Sprintf("%s.%s", p.Resource, p.Group)
+	}
+	return fmt.Sprintf("%s %s", p.Verb, resource)
+}
+
+var (
+	// ListPodDisruptionBudgets is required to detect nodes whose pods can't
+	// be evicted, for the SingleReplicaPolicy and drain estimates
+	ListPodDisruptionBudgets = Permission{Group: "policy", Resource: "poddisruptionbudgets", Verb: "list", Feature: "pod disruption budget checks"}
+	// CreateEvents is required to record events on pods, nodes and provisioners
+	CreateEvents = Permission{Resource: "events", Verb: "create", Feature: "events"}
+
+	// Optional permissions that are checked at startup
+	Optional = []Permission{ListPodDisruptionBudgets, CreateEvents}
+)
+
+// Missing returns the permissions that the client's identity doesn't have
+func Missing(ctx context.Context, clientSet kubernetes.Interface, permissions ...Permission) ([]Permission, error) {
+	missing := []Permission{}
+	for _, permission := range permissions {
+		review, err := clientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:    permission.Group,
+					Resource: permission.Resource,
+					Verb:     permission.Verb,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("reviewing access to %s, %w", permission, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
+
+type contextKey struct{}
+
+// Inject records the missing permissions in the context
+func Inject(ctx context.Context, missing []Permission) context.Context {
+	return context.WithValue(ctx, contextKey{}, missing)
+}
+
+// Get returns the missing permissions recorded in the context
+func Get(ctx context.Context) []Permission {
+	retval := ctx.Value(contextKey{})
+	if retval == nil {
+		return nil
+	}
+	return retval.([]Permission)
+}
+
+// Allowed returns false if the permission is recorded as missing in the
+// context. Permissions are allowed if none are recorded.
+func Allowed(ctx context.Context, permission Permission) bool {
+	for _, missing := range Get(ctx) {
+		if missing == permission {
+			return false
+		}
+	}
+	return true
+}
+
+// DisabledFeatures describes the features disabled by the missing
+// permissions recorded in the context, or returns an empty string if none are
+func DisabledFeatures(ctx context.Context) string {
+	disabled := []string{}
+	for _, missing := range Get(ctx) {
+		disabled = append(disabled, fmt.Sprintf("%s (requires %s)", missing.Feature, missing))
+	}
+	sort.Strings(disabled)
+	return strings.Join(disabled, ", ")
+}
@@ -0,0 +1,65 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Priority returns the pod's priority, which is resolved from its
+// PriorityClass when the pod is admitted, defaulting to zero
+func Priority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// IsPreempting returns true if the pod may preempt lower priority pods, which
+// is the default unless its PriorityClass has a preemptionPolicy of Never
+func IsPreempting(pod *v1.Pod) bool {
+	return pod.Spec.PreemptionPolicy == nil || *pod.Spec.PreemptionPolicy != v1.PreemptNever
+}
+
+// Precedes returns true if capacity should be provisioned for pod a before
+// pod b. Higher priority pods come first. At equal priority, pods that can't
+// preempt come first, since the kube scheduler can't make room for them by
+// preempting lower priority pods on existing nodes.
+func Precedes(a, b *v1.Pod) bool {
+	if Priority(a) != Priority(b) {
+		return Priority(a) > Priority(b)
+	}
+	return !IsPreempting(a) && IsPreempting(b)
+}
+
+// SortByPriority sorts the pods so that pods that capacity should be
+// provisioned for first come first, preserving the order of equivalent pods
+func SortByPriority(pods []*v1.Pod) {
+	sort.SliceStable(pods, func(i, j int) bool { return Precedes(pods[i], pods[j]) })
+}
+
+// Highest returns the pod that capacity should be provisioned for first, or
+// nil if there are no pods
+func Highest(pods []*v1.Pod) *v1.Pod {
+	var highest *v1.Pod
+	for _, pod := range pods {
+		if highest == nil || Precedes(pod, highest) {
+			highest = pod
+		}
+	}
+	return highest
+}
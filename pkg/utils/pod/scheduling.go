@@ -15,6 +15,7 @@ limitations under the License.
 package pod
 
 import (
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
@@ -32,12 +33,26 @@ func HasFailed(pod *v1.Pod) bool {
 	return pod.Status.Phase == "Failed"
 }
 
+// IsEscalated returns true if the pod missed its provisioning deadline, so
+// that its constraints are widened within its provisioner's policy
+func IsEscalated(pod *v1.Pod) bool {
+	_, ok := pod.Annotations[v1alpha4.EscalatedAnnotationKey]
+	return ok
+}
+
 func IsOwnedByDaemonSet(pod *v1.Pod) bool {
 	return IsOwnedBy(pod, []schema.GroupVersionKind{
 		{Group: "apps", Version: "v1", Kind: "DaemonSet"},
 	})
 }
 
+// IsOwnedByJob returns true if the pod is owned by a batch Job
+func IsOwnedByJob(pod *v1.Pod) bool {
+	return IsOwnedBy(pod, []schema.GroupVersionKind{
+		{Group: "batch", Version: "v1", Kind: "Job"},
+	})
+}
+
 // IsOwnedByNode returns true if the pod is a static pod owned by a specific node
 func IsOwnedByNode(pod *v1.Pod) bool {
 	return IsOwnedBy(pod, []schema.GroupVersionKind{
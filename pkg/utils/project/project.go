@@ -23,6 +23,9 @@ var (
 	// Version is the karpenter app version injected during compilation
 	// when using the Makefile
 	Version = "unspecified"
+	// Commit is the git commit karpenter was built from, injected during
+	// compilation when using the Makefile
+	Commit = "unspecified"
 )
 
 func RelativeToRoot(path string) string {
@@ -34,6 +34,14 @@ func PodListToSlice(pods *v1.PodList) []*v1.Pod {
 	return podPointers
 }
 
+func NodeListToSlice(nodes *v1.NodeList) []*v1.Node {
+	nodePointers := []*v1.Node{}
+	for _, node := range nodes.Items {
+		nodePointers = append(nodePointers, Node(node))
+	}
+	return nodePointers
+}
+
 func Int64Value(ptr *int64) int64 {
 	if ptr == nil {
 		return 0
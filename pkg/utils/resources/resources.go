@@ -15,6 +15,9 @@ limitations under the License.
 package resources
 
 import (
+	"sort"
+	"strings"
+
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
@@ -36,6 +39,25 @@ func RequestsForPods(pods ...*v1.Pod) v1.ResourceList {
 	return Merge(resources...)
 }
 
+// LimitsForPods returns the total resource limits of a variadic list of
+// podspecs. Containers without a limit for a resource contribute their request.
+func LimitsForPods(pods ...*v1.Pod) v1.ResourceList {
+	resources := []v1.ResourceList{}
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			limits := v1.ResourceList{}
+			for resourceName, quantity := range container.Resources.Requests {
+				limits[resourceName] = quantity
+			}
+			for resourceName, quantity := range container.Resources.Limits {
+				limits[resourceName] = quantity
+			}
+			resources = append(resources, limits)
+		}
+	}
+	return Merge(resources...)
+}
+
 // Merge the resources from the variadic into a single v1.ResourceList
 func Merge(resources ...v1.ResourceList) v1.ResourceList {
 	result := v1.ResourceList{}
@@ -49,6 +71,50 @@ func Merge(resources ...v1.ResourceList) v1.ResourceList {
 	return result
 }
 
+// NonZero returns the resources of the list with nonzero quantities
+func NonZero(resourceList v1.ResourceList) v1.ResourceList {
+	result := v1.ResourceList{}
+	for resourceName, quantity := range resourceList {
+		if !quantity.IsZero() {
+			result[resourceName] = quantity.DeepCopy()
+		}
+	}
+	return result
+}
+
+// IsExtended returns true if the resource is an extended resource, e.g. an
+// accelerator advertised by a device plugin, rather than a native resource
+func IsExtended(name v1.ResourceName) bool {
+	return strings.Contains(string(name), "/") &&
+		!strings.Contains(string(name), v1.ResourceDefaultNamespacePrefix) &&
+		!strings.HasPrefix(string(name), v1.DefaultResourceRequestsPrefix)
+}
+
+// ExtendedRequests returns the names of the extended resources that the pods
+// request, sorted by name
+func ExtendedRequests(pods ...*v1.Pod) []string {
+	names := []string{}
+	for name, quantity := range RequestsForPods(pods...) {
+		if IsExtended(name) && !quantity.IsZero() {
+			names = append(names, string(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AcceleratorRequests returns the names of the accelerators, e.g. GPUs, that
+// the pods request, sorted by name
+func AcceleratorRequests(pods ...*v1.Pod) []string {
+	names := []string{}
+	for _, name := range ExtendedRequests(pods...) {
+		if name == NvidiaGPU || name == AMDGPU || name == AWSNeuron {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // Quantity parses the string value into a *Quantity
 func Quantity(value string) *resource.Quantity {
 	r := resource.MustParse(value)
@@ -0,0 +1,82 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Translator transforms the resource requirements of pending pods before
+// they're scheduled and packed, for platforms with bespoke resource
+// conventions, e.g. to translate vendor specific resource names, or to apply
+// namespace specific multipliers. Translators receive a copy of the pod and may
+// modify its containers' requests and limits. The pod itself is unchanged, and
+// is bound to the node launched for its translated requirements.
+type Translator interface {
+	Translate(ctx context.Context, pod *v1.Pod) error
+}
+
+// TranslatorFunc adapts a function to a Translator
+type TranslatorFunc func(ctx context.Context, pod *v1.Pod) error
+
+// Translate calls f(ctx, pod)
+func (f TranslatorFunc) Translate(ctx context.Context, pod *v1.Pod) error {
+	return f(ctx, pod)
+}
+
+var translators []Translator
+
+// RegisterTranslator adds a translator, applied after those registered before
+// it. Translators must be registered at startup, before controllers are
+// constructed.
+func RegisterTranslator(translator Translator) {
+	translators = append(translators, translator)
+}
+
+// Translators returns the registered translators, in the order they're applied
+func Translators() []Translator {
+	return append([]Translator{}, translators...)
+}
+
+// Translate returns copies of the pods with resource requirements translated
+// by the translators, or the pods themselves if there are no translators. Pods
+// that fail to translate are omitted, and returned with their errors.
+func Translate(ctx context.Context, translators []Translator, pods []*v1.Pod) ([]*v1.Pod, map[*v1.Pod]error) {
+	if len(translators) == 0 {
+		return pods, nil
+	}
+	translated := []*v1.Pod{}
+	errs := map[*v1.Pod]error{}
+	for _, pod := range pods {
+		if copied, err := translate(ctx, translators, pod); err != nil {
+			errs[pod] = err
+		} else {
+			translated = append(translated, copied)
+		}
+	}
+	return translated, errs
+}
+
+func translate(ctx context.Context, translators []Translator, pod *v1.Pod) (*v1.Pod, error) {
+	copied := pod.DeepCopy()
+	for _, translator := range translators {
+		if err := translator.Translate(ctx, copied); err != nil {
+			return nil, err
+		}
+	}
+	return copied, nil
+}
@@ -0,0 +1,142 @@
+// +build e2e
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/cloudprovider/fake"
+	nodeutils "github.com/awslabs/karpenter/pkg/utils/node"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// KubeletSyncPeriod is how often simulated kubelets report status, which
+	// must be well within the node lifecycle controller's grace period.
+	KubeletSyncPeriod = 2 * time.Second
+	// FakeProviderIDPrefix identifies nodes launched by the fake cloud provider
+	FakeProviderIDPrefix = "fake://"
+)
+
+// Kubelet simulates the kubelets of nodes launched by the fake cloud provider,
+// which aren't backed by instances. Similar to kwok, it reports the nodes as
+// ready, starts pods that are bound to them, and completes the termination of
+// their deleted pods.
+type Kubelet struct {
+	kubeClient client.Client
+}
+
+func NewKubelet(kubeClient client.Client) *Kubelet {
+	return &Kubelet{kubeClient: kubeClient}
+}
+
+// Start syncs simulated kubelets until the context is cancelled
+func (k *Kubelet) Start(ctx context.Context) {
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := k.sync(ctx); err != nil {
+			logging.FromContext(ctx).Errorf("Syncing simulated kubelets, %s", err.Error())
+		}
+	}, KubeletSyncPeriod)
+}
+
+func (k *Kubelet) sync(ctx context.Context) error {
+	nodes := &v1.NodeList{}
+	if err := k.kubeClient.List(ctx, nodes); err != nil {
+		return err
+	}
+	simulated := map[string]bool{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !strings.HasPrefix(node.Spec.ProviderID, FakeProviderIDPrefix) {
+			continue
+		}
+		simulated[node.Name] = true
+		if err := k.heartbeat(ctx, node); err != nil {
+			return err
+		}
+	}
+	pods := &v1.PodList{}
+	if err := k.kubeClient.List(ctx, pods); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !simulated[pod.Spec.NodeName] {
+			continue
+		}
+		if err := k.syncPod(ctx, pod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heartbeat reports the node as ready, renewing its heartbeat, with the resources of its instance type
+func (k *Kubelet) heartbeat(ctx context.Context, node *v1.Node) error {
+	persisted := node.DeepCopy()
+	// Only the ready condition is reported, leaving conditions set by other
+	// controllers in place
+	nodeutils.SetCondition(node, v1.NodeReady, v1.ConditionTrue, "KubeletReady", "")
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == v1.NodeReady {
+			node.Status.Conditions[i].LastHeartbeatTime = metav1.Now()
+		}
+	}
+	if node.Status.Allocatable == nil {
+		instanceTypes, err := (&fake.CloudProvider{}).GetInstanceTypes(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, instanceType := range instanceTypes {
+			if instanceType.Name() == node.Labels[v1.LabelInstanceTypeStable] {
+				node.Status.Allocatable = v1.ResourceList{
+					v1.ResourceCPU:    *instanceType.CPU(),
+					v1.ResourceMemory: *instanceType.Memory(),
+					v1.ResourcePods:   *instanceType.Pods(),
+				}
+				node.Status.Capacity = node.Status.Allocatable
+			}
+		}
+	}
+	return client.IgnoreNotFound(k.kubeClient.Status().Patch(ctx, node, client.MergeFrom(persisted)))
+}
+
+// syncPod starts pending pods and removes deleted pods, as the kubelet would
+// once their containers have stopped
+func (k *Kubelet) syncPod(ctx context.Context, pod *v1.Pod) error {
+	if pod.DeletionTimestamp != nil {
+		return client.IgnoreNotFound(k.kubeClient.Delete(ctx, pod, client.GracePeriodSeconds(0)))
+	}
+	if pod.Status.Phase == v1.PodRunning {
+		return nil
+	}
+	persisted := pod.DeepCopy()
+	now := metav1.Now()
+	pod.Status.Phase = v1.PodRunning
+	pod.Status.StartTime = &now
+	pod.Status.Conditions = []v1.PodCondition{
+		{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: now},
+		{Type: v1.PodReady, Status: v1.ConditionTrue, LastTransitionTime: now},
+	}
+	return client.IgnoreNotFound(k.kubeClient.Status().Patch(ctx, pod, client.MergeFrom(persisted)))
+}
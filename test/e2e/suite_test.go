@@ -0,0 +1,122 @@
+// +build e2e
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/awslabs/karpenter/pkg/apis"
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+const (
+	// Timeout for the controller to act, which includes batching, node
+	// readiness, and the ttls of provisioners under test
+	Timeout  = 2 * time.Minute
+	Interval = 2 * time.Second
+)
+
+var ctx context.Context
+var stop context.CancelFunc
+var kubeClient client.Client
+
+// TestE2E runs against the cluster in the current kubeconfig context, which
+// must be running the controller with the fake cloud provider. See
+// hack/e2e.sh to run the suite against a kind cluster.
+func TestE2E(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "E2E")
+}
+
+var _ = BeforeSuite(func() {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(apis.AddToScheme(scheme)).To(Succeed())
+	var err error
+	kubeClient, err = client.New(config.GetConfigOrDie(), client.Options{Scheme: scheme})
+	Expect(err).ToNot(HaveOccurred())
+	ctx, stop = context.WithCancel(ctx)
+	NewKubelet(kubeClient).Start(ctx)
+})
+
+var _ = AfterSuite(func() {
+	stop()
+})
+
+var _ = Describe("E2E", func() {
+	var provisioner *v1alpha4.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha4.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha4.DefaultProvisioner.Name},
+			Spec:       v1alpha4.ProvisionerSpec{TTLSecondsAfterEmpty: ptr.Int64(10)},
+		}
+		Expect(kubeClient.Create(ctx, provisioner)).To(Succeed())
+	})
+	AfterEach(func() {
+		Expect(client.IgnoreNotFound(kubeClient.Delete(ctx, provisioner))).To(Succeed())
+	})
+
+	It("should provision, schedule, and terminate empty nodes", func() {
+		// Provision: the selector can't be satisfied by the kind node
+		pod := test.Pod(test.PodOptions{NodeSelector: map[string]string{v1alpha4.ProvisionerNameLabelKey: provisioner.Name}})
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+
+		// Schedule
+		Eventually(func() v1.PodPhase {
+			Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+			return pod.Status.Phase
+		}, Timeout, Interval).Should(Equal(v1.PodRunning))
+		node := &v1.Node{}
+		Expect(kubeClient.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, node)).To(Succeed())
+		Expect(node.Labels).To(HaveKeyWithValue(v1alpha4.ProvisionerNameLabelKey, provisioner.Name))
+		Eventually(func() bool {
+			Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(node), node)).To(Succeed())
+			for _, taint := range node.Spec.Taints {
+				if taint.Key == v1alpha4.NotReadyTaintKey {
+					return false
+				}
+			}
+			return true
+		}, Timeout, Interval).Should(BeTrue())
+
+		// Empty
+		Expect(kubeClient.Delete(ctx, pod)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(kubeClient.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+		}, Timeout, Interval).Should(BeTrue())
+
+		// Terminate
+		Eventually(func() bool {
+			return errors.IsNotFound(kubeClient.Get(ctx, client.ObjectKeyFromObject(node), node))
+		}, Timeout, Interval).Should(BeTrue())
+	})
+})
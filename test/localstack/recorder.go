@@ -0,0 +1,101 @@
+// +build localstack
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Request is an AWS API request recorded by the Recorder
+type Request struct {
+	// Action is the API operation, e.g. CreateFleet
+	Action string
+	// Params are the parameters of query protocol requests (e.g. EC2)
+	Params url.Values
+	// Body is the payload of JSON protocol requests (e.g. SSM)
+	Body map[string]interface{}
+}
+
+// Recorder is a reverse proxy to an AWS endpoint, e.g. localstack, that
+// records the requests that pass through it, so that tests can assert on the
+// requests constructed by the AWS cloud provider.
+type Recorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []Request
+}
+
+func NewRecorder(endpoint *url.URL) *Recorder {
+	r := &Recorder{}
+	proxy := httputil.NewSingleHostReverseProxy(endpoint)
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.record(req, body)
+		proxy.ServeHTTP(w, req)
+	}))
+	return r
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) {
+	request := Request{}
+	if target := req.Header.Get("X-Amz-Target"); target != "" {
+		// JSON protocol, e.g. X-Amz-Target: AmazonSSM.GetParameter
+		request.Action = target[strings.LastIndex(target, ".")+1:]
+		request.Body = map[string]interface{}{}
+		_ = json.Unmarshal(body, &request.Body)
+	} else {
+		// Query protocol, e.g. Action=CreateFleet&Version=2016-11-15
+		request.Params, _ = url.ParseQuery(string(body))
+		request.Action = request.Params.Get("Action")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, request)
+}
+
+// Requests returns the recorded requests for the action
+func (r *Recorder) Requests(action string) []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requests := []Request{}
+	for _, request := range r.requests {
+		if request.Action == action {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// Reset forgets the recorded requests
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = nil
+}
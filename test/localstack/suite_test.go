@@ -0,0 +1,243 @@
+// +build localstack
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localstack
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/awslabs/karpenter/pkg/apis/provisioning/v1alpha4"
+	"github.com/awslabs/karpenter/pkg/cloudprovider"
+	awscloudprovider "github.com/awslabs/karpenter/pkg/cloudprovider/aws"
+	"github.com/awslabs/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	envutils "github.com/awslabs/karpenter/pkg/utils/env"
+	"github.com/awslabs/karpenter/pkg/utils/functional"
+	"github.com/awslabs/karpenter/pkg/utils/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	. "knative.dev/pkg/logging/testing"
+)
+
+const (
+	ClusterName       = "test-cluster"
+	Region            = "us-east-1"
+	Zone              = "us-east-1a"
+	KubernetesVersion = "1.21"
+)
+
+var ctx context.Context
+var recorder *Recorder
+var kubeServer *httptest.Server
+var cloudProvider *awscloudprovider.CloudProvider
+var ec2api *ec2.EC2
+var subnetID string
+var amiID string
+
+// TestLocalstack runs the AWS cloud provider against localstack, which must be
+// running at LOCALSTACK_ENDPOINT (default http://localhost:4566). Requests pass
+// through a Recorder, so specs can assert on the requests that were constructed.
+// See hack/localstack.sh to run the suite against a localstack container.
+func TestLocalstack(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Localstack")
+}
+
+var _ = BeforeSuite(func() {
+	endpoint, err := url.Parse(envutils.WithDefaultString("LOCALSTACK_ENDPOINT", "http://localhost:4566"))
+	Expect(err).ToNot(HaveOccurred())
+	Expect(os.Setenv("AWS_REGION", Region)).To(Succeed())
+	Expect(os.Setenv("AWS_ACCESS_KEY_ID", "test")).To(Succeed())
+	Expect(os.Setenv("AWS_SECRET_ACCESS_KEY", "test")).To(Succeed())
+
+	// Seed the resources that the cloud provider discovers, bypassing the recorder
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(Region),
+		Endpoint:    aws.String(endpoint.String()),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	}))
+	ec2api = ec2.New(sess)
+	subnetID, amiID = seed(ssm.New(sess))
+
+	// The cloud provider discovers the kubernetes version to resolve AMIs
+	kubeServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Expect(json.NewEncoder(w).Encode(version.Info{Major: "1", Minor: "21", GitVersion: "v1.21.2"})).To(Succeed())
+	}))
+	recorder = NewRecorder(endpoint)
+	for _, service := range []string{"ec2", "ssm", "sqs"} {
+		Expect(flag.Set(fmt.Sprintf("aws-%s-endpoint", service), recorder.URL)).To(Succeed())
+	}
+})
+
+var _ = AfterSuite(func() {
+	recorder.Close()
+	kubeServer.Close()
+})
+
+var _ = Describe("Localstack", func() {
+	var constraints *v1alpha4.Constraints
+	BeforeEach(func() {
+		raw, err := json.Marshal(&v1alpha1.AWS{
+			Cluster:         v1alpha1.Cluster{Name: ClusterName, Endpoint: "https://test-cluster"},
+			InstanceProfile: "test-instance-profile",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		constraints = &v1alpha4.Constraints{Provider: &runtime.RawExtension{Raw: raw}}
+		// A new cloud provider for each spec, so that cached responses don't hide requests
+		cloudProvider = awscloudprovider.NewCloudProvider(ctx, cloudprovider.Options{
+			ClientSet: kubernetes.NewForConfigOrDie(&rest.Config{Host: kubeServer.URL}),
+		})
+		cloudProvider.Default(ctx, constraints)
+		recorder.Reset()
+	})
+	It("should discover subnets by the cluster tag", func() {
+		instanceTypes := launchableInstanceTypes(constraints)
+		Expect(launch(constraints, instanceTypes[:1])).To(HaveLen(1))
+		requests := recorder.Requests("DescribeSubnets")
+		Expect(requests).ToNot(BeEmpty())
+		Expect(requests[0].Params.Get("Filter.1.Name")).To(Equal("tag-key"))
+		Expect(requests[0].Params.Get("Filter.1.Value.1")).To(Equal(fmt.Sprintf(v1alpha1.ClusterDiscoveryTagKeyFormat, ClusterName)))
+	})
+	It("should resolve the AMI from SSM", func() {
+		instanceTypes := launchableInstanceTypes(constraints)
+		Expect(launch(constraints, instanceTypes[:1])).To(HaveLen(1))
+		requests := recorder.Requests("GetParameter")
+		Expect(requests).ToNot(BeEmpty())
+		Expect(requests[0].Body).To(HaveKeyWithValue("Name", ssmQuery()))
+		fleets := recorder.Requests("CreateFleet")
+		Expect(fleets).To(HaveLen(1))
+		versions, err := ec2api.DescribeLaunchTemplateVersions(&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateName: aws.String(fleets[0].Params.Get("LaunchTemplateConfigs.1.LaunchTemplateSpecification.LaunchTemplateName")),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(versions.LaunchTemplateVersions).ToNot(BeEmpty())
+		Expect(aws.StringValue(versions.LaunchTemplateVersions[0].LaunchTemplateData.ImageId)).To(Equal(amiID))
+	})
+	It("should create a fleet with an override for each instance type", func() {
+		instanceTypes := launchableInstanceTypes(constraints)
+		if len(instanceTypes) > 2 {
+			instanceTypes = instanceTypes[:2]
+		}
+		nodes := launch(constraints, instanceTypes)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Spec.ProviderID).To(HavePrefix("aws:///"))
+		requests := recorder.Requests("CreateFleet")
+		Expect(requests).To(HaveLen(1))
+		params := requests[0].Params
+		Expect(params.Get("Type")).To(Equal("instant"))
+		Expect(params.Get("TargetCapacitySpecification.TotalTargetCapacity")).To(Equal("1"))
+		Expect(params.Get("LaunchTemplateConfigs.1.LaunchTemplateSpecification.LaunchTemplateName")).ToNot(BeEmpty())
+		overrides := []string{}
+		for i := range instanceTypes {
+			Expect(params.Get(fmt.Sprintf("LaunchTemplateConfigs.1.Overrides.%d.SubnetId", i+1))).To(Equal(subnetID))
+			overrides = append(overrides, params.Get(fmt.Sprintf("LaunchTemplateConfigs.1.Overrides.%d.InstanceType", i+1)))
+		}
+		for _, instanceType := range instanceTypes {
+			Expect(overrides).To(ContainElement(instanceType.Name()))
+		}
+	})
+})
+
+// seed creates a subnet and security group tagged for the cluster, and an SSM
+// parameter for the AMI, returning the IDs of the subnet and AMI.
+func seed(ssmapi *ssm.SSM) (string, string) {
+	tags := []*ec2.TagSpecification{}
+	for _, resourceType := range []string{ec2.ResourceTypeSubnet, ec2.ResourceTypeSecurityGroup} {
+		tags = append(tags, &ec2.TagSpecification{ResourceType: aws.String(resourceType), Tags: []*ec2.Tag{{
+			Key: aws.String(fmt.Sprintf(v1alpha1.ClusterDiscoveryTagKeyFormat, ClusterName)), Value: aws.String("owned"),
+		}}})
+	}
+	vpc, err := ec2api.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")})
+	Expect(err).ToNot(HaveOccurred())
+	subnet, err := ec2api.CreateSubnet(&ec2.CreateSubnetInput{
+		VpcId:             vpc.Vpc.VpcId,
+		CidrBlock:         aws.String("10.0.0.0/24"),
+		AvailabilityZone:  aws.String(Zone),
+		TagSpecifications: tags[:1],
+	})
+	Expect(err).ToNot(HaveOccurred())
+	_, err = ec2api.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		VpcId:             vpc.Vpc.VpcId,
+		GroupName:         aws.String(ClusterName),
+		Description:       aws.String(ClusterName),
+		TagSpecifications: tags[1:],
+	})
+	Expect(err).ToNot(HaveOccurred())
+	images, err := ec2api.DescribeImages(&ec2.DescribeImagesInput{})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(images.Images).ToNot(BeEmpty())
+	_, err = ssmapi.PutParameter(&ssm.PutParameterInput{
+		Name:      aws.String(ssmQuery()),
+		Value:     images.Images[0].ImageId,
+		Type:      aws.String(ssm.ParameterTypeString),
+		Overwrite: aws.Bool(true),
+	})
+	Expect(err).ToNot(HaveOccurred())
+	return aws.StringValue(subnet.Subnet.SubnetId), aws.StringValue(images.Images[0].ImageId)
+}
+
+// ssmQuery is the AMI parameter for amd64 instance types without accelerators
+func ssmQuery() string {
+	return fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2/recommended/image_id", KubernetesVersion)
+}
+
+// launchableInstanceTypes returns the amd64 instance types without
+// accelerators that are offered in the seeded subnet's zone
+func launchableInstanceTypes(constraints *v1alpha4.Constraints) []cloudprovider.InstanceType {
+	all, err := cloudProvider.GetInstanceTypes(ctx, constraints)
+	Expect(err).ToNot(HaveOccurred())
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, instanceType := range all {
+		capacity := instanceType.Resources()
+		_, nvidiaGPUs := capacity[resources.NvidiaGPU]
+		_, awsNeurons := capacity[resources.AWSNeuron]
+		if instanceType.Architecture() != v1alpha4.ArchitectureAmd64 || nvidiaGPUs || awsNeurons {
+			continue
+		}
+		if functional.ContainsString(instanceType.Zones(), Zone) {
+			instanceTypes = append(instanceTypes, instanceType)
+		}
+	}
+	Expect(instanceTypes).ToNot(BeEmpty())
+	return instanceTypes
+}
+
+func launch(constraints *v1alpha4.Constraints, instanceTypes []cloudprovider.InstanceType) []*v1.Node {
+	nodes := []*v1.Node{}
+	Expect(<-cloudProvider.Create(ctx, constraints, instanceTypes, 1, func(node *v1.Node) error {
+		nodes = append(nodes, node)
+		return nil
+	})).To(Succeed())
+	return nodes
+}